@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	mathrand "math/rand"
+	"net/http"
+	"time"
+
+	goreddit "github.com/ArjunKaliyath/GoReddit/pkg/client"
+)
+
+// ChurnSpec makes a share of a scenario's users come and go: each of them
+// logs in with a session, stays online for a while, logs out and goes
+// quiet, then logs in again with a new session. Online and Offline are the
+// average stretches, jittered by up to 50% either way.
+type ChurnSpec struct {
+	Fraction float64       `yaml:"fraction"`
+	Online   time.Duration `yaml:"online"`
+	Offline  time.Duration `yaml:"offline"`
+}
+
+const (
+	defaultChurnOnline  = time.Minute
+	defaultChurnOffline = 15 * time.Second
+)
+
+func (c *ChurnSpec) applyDefaults() error {
+	if c.Fraction < 0 || c.Fraction > 1 {
+		return fmt.Errorf("churn: fraction must be between 0 and 1")
+	}
+	if c.Online < 0 || c.Offline < 0 {
+		return fmt.Errorf("churn: online and offline must not be negative")
+	}
+	if c.Online == 0 {
+		c.Online = defaultChurnOnline
+	}
+	if c.Offline == 0 {
+		c.Offline = defaultChurnOffline
+	}
+	return nil
+}
+
+// jittered returns d give or take up to half of it
+func jittered(rng *mathrand.Rand, d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + time.Duration(rng.Int63n(int64(d)))
+}
+
+// login starts a session for the user, whose requests then carry its token
+func (u *loadUser) login() error {
+	var response goreddit.LoginResult
+	body := goreddit.LoginRequest{Username: u.name, Password: u.name}
+	if err := u.do("POST", "/login", body, http.StatusOK, &response); err != nil {
+		return err
+	}
+	u.token = response.Token
+	return nil
+}
+
+// logout ends the user's session
+func (u *loadUser) logout() error {
+	err := u.do("POST", "/logout", nil, http.StatusOK, nil)
+	u.token = ""
+	return err
+}
+
+// churn takes a churning user offline once it has been online long enough:
+// it logs out, waits, and logs back in, unless the run ends first. A user
+// whose login failed stays offline and tries again after another wait. It
+// returns false when the run ended while the user was offline.
+func (r *ScenarioRunner) churn(user *loadUser, start time.Time) bool {
+	if user.token != "" {
+		if time.Now().Before(user.onlineUntil) {
+			return true
+		}
+		r.record("logout", user.logout())
+	}
+
+	offline := jittered(user.rng, r.scenario.Churn.Offline)
+	if remaining := r.scenario.Duration() - time.Since(start); offline > remaining {
+		time.Sleep(remaining)
+		return false
+	}
+	time.Sleep(offline)
+
+	err := user.login()
+	r.record("login", err)
+	if err == nil {
+		user.onlineUntil = time.Now().Add(jittered(user.rng, r.scenario.Churn.Online))
+	}
+	return true
+}
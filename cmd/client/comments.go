@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/manifoldco/promptui"
+
+	goreddit "github.com/ArjunKaliyath/GoReddit/pkg/client"
+)
+
+// commentSorts are the orders a comment thread can be sorted in
+var commentSorts = []string{"top", "best", "new", "old"}
+
+// threadComment is a comment in the Reddit-compatible comments listing,
+// with its replies
+type threadComment struct {
+	ID      int
+	Author  string
+	Body    string
+	Score   int
+	Depth   int
+	Replies []threadComment
+}
+
+// redditThingList is the shape of a Reddit-compatible listing of things
+type redditThingList struct {
+	Data struct {
+		Children []struct {
+			Kind string          `json:"kind"`
+			Data json.RawMessage `json:"data"`
+		} `json:"children"`
+	} `json:"data"`
+}
+
+// parseThread reads the comments, and their replies, from a listing
+func parseThread(listing redditThingList) ([]threadComment, error) {
+	var comments []threadComment
+	for _, child := range listing.Data.Children {
+		if child.Kind != "t1" {
+			continue
+		}
+		var data struct {
+			ID      string          `json:"id"`
+			Author  string          `json:"author"`
+			Body    string          `json:"body"`
+			Score   int             `json:"score"`
+			Depth   int             `json:"depth"`
+			Replies json.RawMessage `json:"replies"`
+		}
+		if err := json.Unmarshal(child.Data, &data); err != nil {
+			return nil, err
+		}
+		id, err := strconv.ParseInt(data.ID, 36, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid comment ID %q", data.ID)
+		}
+		comment := threadComment{ID: int(id), Author: data.Author, Body: data.Body, Score: data.Score, Depth: data.Depth}
+
+		// Comments without replies have "" instead of a listing
+		if len(data.Replies) > 0 && data.Replies[0] == '{' {
+			var replies redditThingList
+			if err := json.Unmarshal(data.Replies, &replies); err != nil {
+				return nil, err
+			}
+			if comment.Replies, err = parseThread(replies); err != nil {
+				return nil, err
+			}
+		}
+		comments = append(comments, comment)
+	}
+	return comments, nil
+}
+
+// fetchThread gets a post's comment tree, sorted by sortBy
+func (c *Client) fetchThread(postID int, sortBy string) ([]threadComment, error) {
+	endpoint := fmt.Sprintf("/comments/%s.json?sort=%s", strconv.FormatInt(int64(postID), 36), sortBy)
+	resp, err := c.makeRequest("GET", endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var response map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&response)
+		return nil, fmt.Errorf("failed to fetch comments: %v", response["error"])
+	}
+
+	// The listing is the post, then its comments
+	var listings []redditThingList
+	if err := json.NewDecoder(resp.Body).Decode(&listings); err != nil {
+		return nil, err
+	}
+	if len(listings) < 2 {
+		return nil, fmt.Errorf("unexpected comments listing")
+	}
+	return parseThread(listings[1])
+}
+
+// printThread prints comments indented by their depth in the thread
+func printThread(comments []threadComment) {
+	for _, comment := range comments {
+		indent := strings.Repeat("    ", comment.Depth)
+		fmt.Printf("%s[%d] %s (%d points)\n", indent, comment.ID, comment.Author, comment.Score)
+		for _, line := range strings.Split(comment.Body, "\n") {
+			fmt.Printf("%s  %s\n", indent, line)
+		}
+		printThread(comment.Replies)
+	}
+}
+
+// postComment comments on a post, or replies to a comment when parentID
+// isn't nil
+func (c *Client) postComment(postID int, parentID *int) error {
+	contentPrompt := promptui.Prompt{
+		Label: "Enter comment content",
+	}
+	content, err := contentPrompt.Run()
+	if err != nil {
+		return err
+	}
+
+	commentID, err := c.createComment(postID, parentID, content)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Comment created successfully! Comment ID: %v\n", commentID)
+	return nil
+}
+
+// createComment comments on a post, or replies to a comment when parentID
+// isn't nil, and returns the new comment's ID
+func (c *Client) createComment(postID int, parentID *int, content string) (int, error) {
+	result, err := c.sdk().CreateComment(context.Background(), goreddit.CreateCommentRequest{
+		Content:         content,
+		PostID:          postID,
+		ParentCommentID: parentID,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("comment creation failed: %v", err)
+	}
+	return result.CommentID, nil
+}
+
+// voteOnComment upvotes or downvotes a comment
+func (c *Client) voteOnComment(commentID, value int) error {
+	if err := c.vote("comment", commentID, value); err != nil {
+		return err
+	}
+	fmt.Println("Vote recorded successfully!")
+	return nil
+}
+
+// vote upvotes or downvotes a post or comment
+func (c *Client) vote(targetType string, targetID, value int) error {
+	if err := c.sdk().Vote(context.Background(), goreddit.NewVoteRequest(targetType, targetID, value)); err != nil {
+		return fmt.Errorf("voting failed: %v", err)
+	}
+	return nil
+}
+
+// promptID asks for a numeric ID
+func promptID(label string) (int, error) {
+	prompt := promptui.Prompt{
+		Label: label,
+	}
+	value, err := prompt.Run()
+	if err != nil {
+		return 0, err
+	}
+	id, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("invalid ID")
+	}
+	return id, nil
+}
+
+// ViewComments shows a post's comment tree and lets the user reply to and
+// vote on its comments
+func (c *Client) ViewComments() error {
+	postID, err := promptID("Enter post ID")
+	if err != nil {
+		return err
+	}
+	return c.browseThread(postID)
+}
+
+// browseThread shows a post's comment tree, in the order the user picks,
+// until they go back
+func (c *Client) browseThread(postID int) error {
+	sortPrompt := promptui.Select{
+		Label: "Sort comments by",
+		Items: commentSorts,
+	}
+	_, sortBy, err := sortPrompt.Run()
+	if err != nil {
+		return err
+	}
+
+	for {
+		comments, err := c.fetchThread(postID, sortBy)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("\nComments on post %d:\n", postID)
+		if len(comments) == 0 {
+			fmt.Println("No comments yet.")
+		}
+		printThread(comments)
+		fmt.Println()
+
+		actionPrompt := promptui.Select{
+			Label: "Comments",
+			Items: []string{
+				"Reply to a comment",
+				"Comment on the post",
+				"Upvote a comment",
+				"Downvote a comment",
+				"Refresh",
+				"Back",
+			},
+		}
+		_, action, err := actionPrompt.Run()
+		if err != nil {
+			return err
+		}
+
+		var actionErr error
+		switch action {
+		case "Reply to a comment":
+			var parentID int
+			if parentID, actionErr = promptID("Enter comment ID to reply to"); actionErr == nil {
+				actionErr = c.postComment(postID, &parentID)
+			}
+		case "Comment on the post":
+			actionErr = c.postComment(postID, nil)
+		case "Upvote a comment", "Downvote a comment":
+			value := 1
+			if action == "Downvote a comment" {
+				value = -1
+			}
+			var commentID int
+			if commentID, actionErr = promptID("Enter comment ID"); actionErr == nil {
+				actionErr = c.voteOnComment(commentID, value)
+			}
+		case "Back":
+			return nil
+		}
+		if actionErr != nil {
+			fmt.Printf("Error: %v\n", actionErr)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/manifoldco/promptui"
+
+	goreddit "github.com/ArjunKaliyath/GoReddit/pkg/client"
+)
+
+// credentialsPath is where the CLI keeps the signed-in account between runs
+var credentialsPath string
+
+// Credentials are the signed-in account, saved so the CLI stays signed in
+// between runs
+type Credentials struct {
+	UserID   string `json:"user_id"`
+	Username string `json:"username"`
+	Token    string `json:"token,omitempty"` // session token, unless only registered
+}
+
+// defaultCredentialsPath is credentials.json in the client's config
+// directory
+func defaultCredentialsPath() string {
+	if dir := configDir(); dir != "" {
+		return filepath.Join(dir, "credentials.json")
+	}
+	return ".goreddit-credentials.json"
+}
+
+// loadCredentials signs the client in as the saved account, if there is one
+func (c *Client) loadCredentials() error {
+	data, err := os.ReadFile(credentialsPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var creds Credentials
+	if err := json.Unmarshal(data, &creds); err != nil {
+		return fmt.Errorf("invalid credentials file %s: %v", credentialsPath, err)
+	}
+	c.userID, c.username, c.token = creds.UserID, creds.Username, creds.Token
+	return nil
+}
+
+// saveCredentials saves the signed-in account, readable only by the user
+func (c *Client) saveCredentials() error {
+	data, err := json.MarshalIndent(Credentials{UserID: c.userID, Username: c.username, Token: c.token}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(credentialsPath), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(credentialsPath, data, 0600)
+}
+
+// Login signs in to an existing account with a new session
+func (c *Client) Login() error {
+	prompt := promptui.Prompt{
+		Label: "Enter username",
+	}
+	username, err := prompt.Run()
+	if err != nil {
+		return err
+	}
+
+	passwordPrompt := promptui.Prompt{
+		Label: "Enter password",
+		Mask:  '*',
+	}
+	password, err := passwordPrompt.Run()
+	if err != nil {
+		return err
+	}
+
+	result, err := c.sdk().Login(context.Background(), goreddit.LoginRequest{Username: username, Password: password})
+	if err != nil {
+		return fmt.Errorf("login failed: %v", err)
+	}
+
+	c.userID = strconv.Itoa(result.UserID)
+	c.username = username
+	c.token = result.Token
+	if err := c.saveCredentials(); err != nil {
+		return fmt.Errorf("logged in, but failed to save credentials: %v", err)
+	}
+	fmt.Printf("Logged in as %s (user ID %s)\n", c.username, c.userID)
+	return nil
+}
+
+// Logout ends the session and forgets the saved account
+func (c *Client) Logout() error {
+	if err := c.sdk().Logout(context.Background()); err != nil {
+		return fmt.Errorf("logout failed: %v", err)
+	}
+
+	c.userID, c.username, c.token = "", "", ""
+	if err := os.Remove(credentialsPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove saved credentials: %v", err)
+	}
+	fmt.Println("Logged out")
+	return nil
+}
+
+// SwitchUser logs out and offers to log in as another account
+func (c *Client) SwitchUser() error {
+	if err := c.Logout(); err != nil {
+		return err
+	}
+	prompt := promptui.Prompt{
+		Label:     "Log in as another user",
+		IsConfirm: true,
+	}
+	if _, err := prompt.Run(); err != nil {
+		return nil // declined
+	}
+	return c.Login()
+}
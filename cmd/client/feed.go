@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/manifoldco/promptui"
+
+	goreddit "github.com/ArjunKaliyath/GoReddit/pkg/client"
+)
+
+// feedPageSize is how many posts the feed browser shows at a time
+const feedPageSize = 10
+
+// feedSorts are the rankings the feed browser offers, with the one the
+// server uses when none is given first
+var feedSorts = []string{"default", "latest", "hot", "rising", "half_life"}
+
+// fetchFeedPage gets up to limit posts of the feed from offset on, ranked
+// by sortBy
+func (c *Client) fetchFeedPage(sortBy string, limit, offset int) ([]goreddit.Post, error) {
+	if sortBy == "default" {
+		sortBy = ""
+	}
+	posts, err := c.sdk().Feed(context.Background(), goreddit.FeedOptions{Sort: sortBy, Limit: limit, Offset: offset})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch feed: %v", err)
+	}
+	return posts, nil
+}
+
+// postPager gets the page of a listing starting at offset, ranked by
+// sortBy, and whether there is a page after it
+type postPager func(sortBy string, offset int) ([]goreddit.Post, bool, error)
+
+// ViewFeed browses the feed a page at a time, in the order the user picks,
+// and opens posts with their comments
+func (c *Client) ViewFeed() error {
+	return c.browsePosts("Feed", func(sortBy string, offset int) ([]goreddit.Post, bool, error) {
+		// One post more than a page tells whether there is a next page
+		posts, err := c.fetchFeedPage(sortBy, feedPageSize+1, offset)
+		if err != nil {
+			return nil, false, err
+		}
+		if len(posts) > feedPageSize {
+			return posts[:feedPageSize], true, nil
+		}
+		return posts, false, nil
+	})
+}
+
+// BrowseSubreddit browses the posts of any subreddit, joined or not, like
+// the feed
+func (c *Client) BrowseSubreddit() error {
+	ctx := context.Background()
+	subreddits, err := c.sdk().Subreddits(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to fetch subreddits: %v", err)
+	}
+	fmt.Println("Available Subreddits:")
+	printSubreddits(subreddits)
+
+	subredditID, err := promptID("Enter subreddit ID to browse")
+	if err != nil {
+		return err
+	}
+	title := fmt.Sprintf("Subreddit %d", subredditID)
+	for _, subreddit := range subreddits {
+		if subreddit.ID == subredditID {
+			title = "r/" + subreddit.Name
+		}
+	}
+
+	return c.browsePosts(title, func(sortBy string, offset int) ([]goreddit.Post, bool, error) {
+		if sortBy == "default" {
+			sortBy = ""
+		}
+		page, err := c.sdk().SubredditPosts(ctx, subredditID, goreddit.FeedOptions{Sort: sortBy, Limit: feedPageSize, Offset: offset})
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to fetch posts: %v", err)
+		}
+		return page.Posts, page.NextOffset != nil, nil
+	})
+}
+
+// browsePosts shows a listing a page at a time, in the order the user
+// picks, and opens posts with their comments
+func (c *Client) browsePosts(title string, fetch postPager) error {
+	sortBy, offset := "default", 0
+	for {
+		posts, hasNext, err := fetch(sortBy, offset)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("\n%s (%s), page %d:\n", title, sortBy, offset/feedPageSize+1)
+		if len(posts) == 0 {
+			fmt.Println("No posts.")
+		}
+		for i, post := range posts {
+			fmt.Printf("%2d. [%d] %s\n", offset+i+1, post.ID, post.Title)
+			fmt.Printf("    by %s in r/%s | %d points | %d comments\n", post.AuthorName, post.SubredditName, post.Score(), post.CommentCount)
+		}
+		fmt.Println()
+
+		var items []string
+		if hasNext {
+			items = append(items, "Next page")
+		}
+		if offset > 0 {
+			items = append(items, "Previous page")
+		}
+		if len(posts) > 0 {
+			items = append(items, "Open post")
+		}
+		items = append(items, "Change sort", "Back")
+		actionPrompt := promptui.Select{
+			Label: title,
+			Items: items,
+		}
+		_, action, err := actionPrompt.Run()
+		if err != nil {
+			return err
+		}
+
+		switch action {
+		case "Next page":
+			offset += feedPageSize
+		case "Previous page":
+			offset -= feedPageSize
+			if offset < 0 {
+				offset = 0
+			}
+		case "Open post":
+			if err := c.openPost(posts); err != nil {
+				fmt.Printf("Error: %v\n", err)
+			}
+		case "Change sort":
+			sortPrompt := promptui.Select{
+				Label: "Sort posts by",
+				Items: feedSorts,
+			}
+			if _, sortBy, err = sortPrompt.Run(); err != nil {
+				return err
+			}
+			offset = 0
+		case "Back":
+			return nil
+		}
+	}
+}
+
+// openPost shows one of the page's posts in full, then its comments
+func (c *Client) openPost(posts []goreddit.Post) error {
+	items := make([]string, len(posts))
+	for i, post := range posts {
+		items[i] = fmt.Sprintf("[%d] %s", post.ID, post.Title)
+	}
+	postPrompt := promptui.Select{
+		Label: "Open post",
+		Items: items,
+	}
+	i, _, err := postPrompt.Run()
+	if err != nil {
+		return err
+	}
+
+	post := posts[i]
+	fmt.Printf("\n%s\n", post.Title)
+	fmt.Printf("by %s in r/%s | %d upvotes, %d downvotes\n\n", post.AuthorName, post.SubredditName,
+		post.VoteCount.Upvotes, post.VoteCount.Downvotes)
+	for _, line := range strings.Split(post.Content, "\n") {
+		fmt.Printf("  %s\n", line)
+	}
+	return c.browseThread(post.ID)
+}
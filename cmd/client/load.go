@@ -0,0 +1,130 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultLoadMix is the action mix of the load subcommand's bots unless
+// -mix says otherwise
+const defaultLoadMix = "register=1,join_subreddit=2,create_post=3,comment=5,vote=10"
+
+// parseActionMix reads a comma-separated list of weighted actions, e.g.
+// "create_post=3,vote=10". An action without a weight has weight 1.
+func parseActionMix(value string) (map[string]int, error) {
+	mix := make(map[string]int)
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		name, weight := item, 1
+		if i := strings.Index(item, "="); i >= 0 {
+			var err error
+			name = strings.TrimSpace(item[:i])
+			if weight, err = strconv.Atoi(strings.TrimSpace(item[i+1:])); err != nil {
+				return nil, fmt.Errorf("action %s: weight must be a number", name)
+			}
+		}
+		mix[name] += weight
+	}
+	if err := validateActionMix(mix); err != nil {
+		return nil, err
+	}
+	return mix, nil
+}
+
+// loadScenario is the scenario the load subcommand runs: one cohort of bots
+// acting for the whole run, optionally ramping up first and with some of
+// them churning
+func loadScenario(users int, duration, rampUp, thinkTime time.Duration, mix map[string]int, subreddits int, seed int64, churn *ChurnSpec) (*Scenario, error) {
+	scenario := &Scenario{
+		Name:    "load",
+		Seed:    seed,
+		Setup:   ScenarioSetup{Subreddits: subreddits},
+		Cohorts: []CohortSpec{{Name: "bots", Users: users, ThinkTime: thinkTime, Actions: mix}},
+		Churn:   churn,
+	}
+	if rampUp > 0 {
+		scenario.Phases = append(scenario.Phases, PhaseSpec{
+			Name:     "ramp-up",
+			Duration: rampUp,
+			Ramp:     &RampSpec{From: 0, To: 1, Curve: "linear"},
+		})
+	}
+	scenario.Phases = append(scenario.Phases, PhaseSpec{Name: "load", Duration: duration})
+
+	if err := scenario.Validate(); err != nil {
+		return nil, err
+	}
+	return scenario, nil
+}
+
+// runLoad runs the load subcommand, which sends bots at the server without
+// a scenario file, and returns the exit code
+func runLoad(args []string) int {
+	flags := flag.NewFlagSet("load", flag.ExitOnError)
+	users := flags.Int("users", 10, "number of concurrent bots")
+	duration := flags.Duration("duration", time.Minute, "how long the bots keep acting, after any ramp-up")
+	rampUp := flags.Duration("ramp-up", 0, "bring the bots in gradually over this long first")
+	thinkTime := flags.Duration("think-time", 500*time.Millisecond, "average pause between a bot's actions")
+	mixValue := flags.String("mix", defaultLoadMix, "weighted action mix, e.g. create_post=3,vote=10")
+	subreddits := flags.Int("subreddits", 5, "subreddits created before the bots start")
+	seed := flags.Int64("seed", time.Now().UnixNano(), "seed for the bots' choice of actions")
+	churnFraction := flags.Float64("churn", 0, "fraction of bots, from 0 to 1, that go offline and come back with a new session")
+	online := flags.Duration("online", defaultChurnOnline, "average time a churning bot stays online")
+	offline := flags.Duration("offline", defaultChurnOffline, "average time a churning bot stays offline")
+	reportJSON := flags.String("report-json", "", "write the performance report as JSON to this path")
+	reportCSV := flags.String("report-csv", "", "write the per-endpoint performance report as CSV to this path")
+	flags.StringVar(&captchaToken, "captcha-token", captchaToken, "CAPTCHA response sent when registering, such as a provider's test token")
+	flags.StringVar(&serverURL, "server", defaultServerURL, "URL of the server to load")
+	configPath := flags.String("config", defaultConfigPath(), "client config file with the server profiles")
+	profile := flags.String("profile", "", "load the server of this profile from the config file instead of the default one")
+	recordPath := flags.String("record", "", "record the bots' API calls to this file, for the replay subcommand")
+	flags.Parse(args)
+
+	if err := applyProfile(flags, *configPath, *profile); err != nil {
+		log.Printf("Invalid profile: %v", err)
+		return 2
+	}
+	stopRecording, err := startRecording(*recordPath)
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
+	defer stopRecording()
+
+	mix, err := parseActionMix(*mixValue)
+	if err != nil {
+		log.Printf("Invalid -mix: %v", err)
+		return 2
+	}
+	var churn *ChurnSpec
+	if *churnFraction > 0 {
+		churn = &ChurnSpec{Fraction: *churnFraction, Online: *online, Offline: *offline}
+	}
+	scenario, err := loadScenario(*users, *duration, *rampUp, *thinkTime, mix, *subreddits, *seed, churn)
+	if err != nil {
+		log.Printf("Invalid load: %v", err)
+		return 2
+	}
+
+	runner := NewScenarioRunner(scenario)
+	failures, err := runner.Run()
+	if err != nil {
+		log.Printf("Load failed: %v", err)
+		return 1
+	}
+	if err := runner.WriteReports(nil, *reportJSON, *reportCSV); err != nil {
+		log.Printf("Failed to write the report: %v", err)
+		return 1
+	}
+	if failures > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,1828 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"embed"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"math/bits"
+	mathrand "math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/manifoldco/promptui"
+	"gopkg.in/yaml.v3"
+
+	"github.com/ArjunKaliyath/GoReddit/internal/buildinfo"
+	goreddit "github.com/ArjunKaliyath/GoReddit/pkg/client"
+)
+
+// captchaToken is sent when registering, for servers that require a CAPTCHA
+var captchaToken string
+
+type Client struct {
+	userID   string
+	username string
+	token    string // session token from POST /login, sent instead of the user ID
+	api      *goreddit.Client
+}
+
+// NewClient returns a client of the server at serverURL, with the SDK's
+// retries unless opts say otherwise
+func NewClient(opts ...goreddit.Option) *Client {
+	httpClient := &http.Client{}
+	if recorder != nil {
+		httpClient.Transport = recordingTransport{base: http.DefaultTransport, recorder: recorder}
+	}
+	opts = append([]goreddit.Option{goreddit.WithHTTPClient(httpClient)}, opts...)
+	return &Client{
+		api: goreddit.New(serverURL, opts...),
+	}
+}
+
+// newNonce returns a random hex string, used to make IDs unique
+func newNonce() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// sdk is the API client, signed in as the client's user
+func (c *Client) sdk() *goreddit.Client {
+	c.api.SetCredentials(c.userID, c.token)
+	return c.api
+}
+
+// makeRequest sends a request the SDK has no method for
+func (c *Client) makeRequest(method, endpoint string, body interface{}) (*http.Response, error) {
+	return c.sdk().Send(context.Background(), method, endpoint, body)
+}
+
+// printPosts lists posts with their IDs, to pick one from
+func printPosts(posts []goreddit.Post) {
+	for _, post := range posts {
+		fmt.Printf("Post ID: %v\n", post.ID)
+		fmt.Printf("Title: %v\n", post.Title)
+		fmt.Printf("Author: %v\n", post.AuthorName)
+		fmt.Printf("Subreddit: %v\n", post.SubredditName)
+		fmt.Printf("Content: %v\n", post.Content)
+		fmt.Printf("Upvotes: %v, Downvotes: %v\n\n", post.VoteCount.Upvotes, post.VoteCount.Downvotes)
+	}
+}
+
+// printSubreddits lists subreddits with their IDs, to pick one from
+func printSubreddits(subreddits []goreddit.Subreddit) {
+	for _, subreddit := range subreddits {
+		fmt.Printf("ID: %v | Name: %v | Description: %v\n", subreddit.ID, subreddit.Name, subreddit.Description)
+	}
+}
+
+func (c *Client) Register() error {
+	prompt := promptui.Prompt{
+		Label: "Enter username",
+	}
+	username, err := prompt.Run()
+	if err != nil {
+		return err
+	}
+
+	passwordPrompt := promptui.Prompt{
+		Label: "Enter password",
+		Mask:  '*',
+	}
+	password, err := passwordPrompt.Run()
+	if err != nil {
+		return err
+	}
+
+	result, err := c.sdk().Register(context.Background(), goreddit.RegisterRequest{
+		Username:     username,
+		Password:     password,
+		CaptchaToken: captchaToken,
+	})
+	if err != nil {
+		return fmt.Errorf("registration failed: %v", err)
+	}
+
+	c.userID = strconv.Itoa(result.UserID)
+	c.username = username
+	c.token = ""
+	fmt.Printf("Registered successfully! Your User ID is: %s\n", c.userID)
+	if err := c.saveCredentials(); err != nil {
+		fmt.Printf("Failed to save credentials: %v\n", err)
+	}
+
+	if len(result.SuggestedSubreddits) > 0 {
+		fmt.Println("Subreddits you might like to join:")
+		for _, subreddit := range result.SuggestedSubreddits {
+			fmt.Printf("  %v: %v (%v members)\n", subreddit.ID, subreddit.Name, subreddit.MemberCount)
+		}
+	}
+	return nil
+}
+
+func (c *Client) CreateSubreddit() error {
+	namePrompt := promptui.Prompt{
+		Label: "Enter subreddit name",
+	}
+	name, err := namePrompt.Run()
+	if err != nil {
+		return err
+	}
+
+	descPrompt := promptui.Prompt{
+		Label: "Enter subreddit description",
+	}
+	description, err := descPrompt.Run()
+	if err != nil {
+		return err
+	}
+
+	result, err := c.sdk().CreateSubreddit(context.Background(), goreddit.CreateSubredditRequest{
+		Name:        name,
+		Description: description,
+	})
+	if err != nil {
+		return fmt.Errorf("subreddit creation failed: %v", err)
+	}
+
+	fmt.Printf("Subreddit created successfully! Subreddit ID: %v\n", result.SubredditID)
+	return nil
+}
+
+func (c *Client) CreatePost() error {
+	ctx := context.Background()
+	joinedSubreddits, err := c.sdk().JoinedSubreddits(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to fetch joined subreddits: %v", err)
+	}
+
+	// Display joined subreddits
+	fmt.Println("Subreddits You've Joined:")
+	if len(joinedSubreddits) == 0 {
+		fmt.Println("You haven't joined any subreddits yet. Please join a subreddit first.")
+		return nil
+	}
+	printSubreddits(joinedSubreddits)
+
+	titlePrompt := promptui.Prompt{
+		Label: "Enter post title",
+	}
+	title, err := titlePrompt.Run()
+	if err != nil {
+		return err
+	}
+
+	contentPrompt := promptui.Prompt{
+		Label: "Enter post content",
+	}
+	content, err := contentPrompt.Run()
+	if err != nil {
+		return err
+	}
+
+	subredditID, err := promptID("Enter subreddit ID")
+	if err != nil {
+		return err
+	}
+
+	result, err := c.sdk().CreatePost(ctx, goreddit.CreatePostRequest{
+		Title:       title,
+		Content:     content,
+		SubredditID: subredditID,
+	})
+	if err != nil {
+		return fmt.Errorf("post creation failed: %v", err)
+	}
+
+	fmt.Printf("Post created successfully! Post ID: %v\n", result.PostID)
+	return nil
+}
+
+func (c *Client) Vote() error {
+	ctx := context.Background()
+	posts, err := c.sdk().Feed(ctx, goreddit.FeedOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to fetch feed: %v", err)
+	}
+
+	// Display feed posts with their IDs
+	fmt.Println("Feed Posts:")
+	if len(posts) == 0 {
+		fmt.Println("No posts available. Please create or join a subreddit first.")
+		return nil
+	}
+	printPosts(posts)
+
+	targetID, err := promptID("Enter target ID (post/comment ID)")
+	if err != nil {
+		return err
+	}
+
+	typePrompt := promptui.Select{
+		Label: "Select target type",
+		Items: []string{"post", "comment"},
+	}
+	_, targetType, err := typePrompt.Run()
+	if err != nil {
+		return err
+	}
+
+	valuePrompt := promptui.Select{
+		Label: "Select vote",
+		Items: []string{"Upvote (+1)", "Downvote (-1)"},
+	}
+	_, voteStr, err := valuePrompt.Run()
+	if err != nil {
+		return err
+	}
+
+	var voteValue int
+	if voteStr == "Upvote (+1)" {
+		voteValue = 1
+	} else {
+		voteValue = -1
+	}
+
+	if err := c.vote(targetType, targetID, voteValue); err != nil {
+		return err
+	}
+
+	fmt.Println("Vote recorded successfully!")
+	return nil
+}
+
+func (c *Client) SendMessage() error {
+	subscriptions, err := c.sdk().Subscriptions(context.Background())
+	if err != nil {
+		return fmt.Errorf("failed to fetch subscriptions: %v", err)
+	}
+
+	// Display subscribed users
+	fmt.Println("Users You're Subscribed To:")
+	if len(subscriptions) == 0 {
+		fmt.Println("You haven't subscribed to any users yet.")
+	} else {
+		for _, user := range subscriptions {
+			fmt.Printf("User ID: %v | Username: %v\n", user.ID, user.Username)
+		}
+	}
+
+	toUserID, err := promptID("Enter recipient user ID")
+	if err != nil {
+		return err
+	}
+
+	contentPrompt := promptui.Prompt{
+		Label: "Enter message content",
+	}
+	content, err := contentPrompt.Run()
+	if err != nil {
+		return err
+	}
+
+	if err := c.sendDirectMessage(toUserID, content); err != nil {
+		return err
+	}
+
+	fmt.Println("Message sent successfully!")
+	return nil
+}
+
+func (c *Client) ViewMessages() error {
+	ctx := context.Background()
+	conversations, err := c.sdk().Conversations(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to fetch messages: %v", err)
+	}
+
+	if len(conversations) == 0 {
+		fmt.Println("You have no messages yet.")
+		return nil
+	}
+
+	fmt.Println("Conversations:")
+	for _, conv := range conversations {
+		fmt.Printf("User ID: %v | %v | %v unread\n", conv.UserID, conv.Username, conv.UnreadCount)
+		fmt.Printf("  %v: %v\n\n", conv.LatestMessage.FromUsername, conv.LatestMessage.Content)
+	}
+
+	userIDPrompt := promptui.Prompt{
+		Label: "Enter user ID to open the conversation (leave empty to go back)",
+	}
+	otherUserID, err := userIDPrompt.Run()
+	if err != nil || otherUserID == "" {
+		return err
+	}
+	userID, err := strconv.Atoi(otherUserID)
+	if err != nil {
+		return fmt.Errorf("invalid user ID")
+	}
+
+	messages, err := c.sdk().Messages(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("failed to fetch conversation: %v", err)
+	}
+
+	for _, msg := range messages {
+		fmt.Printf("From: %v\n", msg.FromUsername)
+		fmt.Printf("Content: %v\n", msg.Content)
+		fmt.Printf("Sent at: %v\n\n", msg.CreatedAt)
+	}
+	if err := c.sdk().MarkConversationRead(ctx, userID); err != nil {
+		return fmt.Errorf("failed to mark conversation read: %v", err)
+	}
+	return nil
+}
+
+func (c *Client) SubscribeToUser() error {
+	userID, err := promptID("Enter user ID to subscribe to")
+	if err != nil {
+		return err
+	}
+
+	if err := c.sdk().Subscribe(context.Background(), userID); err != nil {
+		return fmt.Errorf("subscription failed: %v", err)
+	}
+
+	fmt.Println("Successfully subscribed to user!")
+	return nil
+}
+
+func (c *Client) ViewTopUsers() error {
+	users, err := c.sdk().TopUsers(context.Background())
+	if err != nil {
+		return fmt.Errorf("failed to fetch top users: %v", err)
+	}
+
+	fmt.Println("Top Users:")
+	for _, user := range users {
+		fmt.Printf("Username: %v\n", user.Username)
+		fmt.Printf("Karma: %v\n", user.Karma)
+		fmt.Printf("Posts: %v\n", user.PostCount)
+		fmt.Printf("Comments: %v\n\n", user.CommentCount)
+	}
+	return nil
+}
+
+// UnsubscribeFromUser lists the users the user subscribes to and
+// unsubscribes from one of them
+func (c *Client) UnsubscribeFromUser() error {
+	ctx := context.Background()
+	subscriptions, err := c.sdk().Subscriptions(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to fetch subscriptions: %v", err)
+	}
+
+	fmt.Println("Users You're Subscribed To:")
+	if len(subscriptions) == 0 {
+		fmt.Println("You haven't subscribed to any users yet.")
+		return nil
+	}
+	for _, user := range subscriptions {
+		fmt.Printf("User ID: %v | Username: %v\n", user.ID, user.Username)
+	}
+
+	userID, err := promptID("Enter user ID to unsubscribe from")
+	if err != nil {
+		return err
+	}
+
+	if err := c.sdk().Unsubscribe(ctx, userID); err != nil {
+		return fmt.Errorf("unsubscribing failed: %v", err)
+	}
+
+	fmt.Println("Successfully unsubscribed from user!")
+	return nil
+}
+
+func (c *Client) ViewTopSubscribedUsers() error {
+	users, err := c.sdk().TopSubscribedUsers(context.Background())
+	if err != nil {
+		return fmt.Errorf("failed to fetch top subscribed users: %v", err)
+	}
+
+	fmt.Println("Top Subscribed Users:")
+	for _, user := range users {
+		fmt.Printf("User ID: %v | Username: %v\n", user.ID, user.Username)
+		fmt.Printf("Subscribers: %v\n", user.SubscriberCount)
+		fmt.Printf("Karma: %v\n\n", user.Karma)
+	}
+	return nil
+}
+
+// LeaveChat lists the user's chat rooms and leaves one of them
+func (c *Client) LeaveChat() error {
+	ctx := context.Background()
+	rooms, err := c.sdk().ChatRooms(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to fetch chats: %v", err)
+	}
+
+	fmt.Println("Your Chats:")
+	if len(rooms) == 0 {
+		fmt.Println("You aren't in any chats.")
+		return nil
+	}
+	for _, room := range rooms {
+		fmt.Printf("ID: %v | Name: %v | %v members\n", room.ID, room.Name, room.MemberCount)
+	}
+
+	roomID, err := promptID("Enter chat ID to leave")
+	if err != nil {
+		return err
+	}
+
+	if err := c.sdk().LeaveChatRoom(ctx, roomID); err != nil {
+		return fmt.Errorf("leaving the chat failed: %v", err)
+	}
+
+	fmt.Println("Successfully left the chat!")
+	return nil
+}
+
+func (c *Client) JoinSubreddit() error {
+	ctx := context.Background()
+	subreddits, err := c.sdk().Subreddits(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to fetch subreddits: %v", err)
+	}
+
+	// Display available subreddits
+	fmt.Println("Available Subreddits:")
+	printSubreddits(subreddits)
+
+	// Recommendations are a nicety, so the user can join without them
+	if recommendations, err := c.sdk().RecommendedSubreddits(ctx, 5); err == nil && len(recommendations) > 0 {
+		fmt.Println("\nRecommended for you:")
+		for _, r := range recommendations {
+			fmt.Printf("ID: %v | Name: %v | Members: %v\n", r.ID, r.Name, r.MemberCount)
+		}
+	}
+
+	subredditID, err := promptID("Enter subreddit ID to join")
+	if err != nil {
+		return err
+	}
+
+	if err := c.sdk().JoinSubreddit(ctx, subredditID); err != nil {
+		return fmt.Errorf("subreddit join failed: %v", err)
+	}
+
+	fmt.Println("Successfully joined the subreddit!")
+	return nil
+}
+
+func (c *Client) LeaveSubreddit() error {
+	ctx := context.Background()
+	joinedSubreddits, err := c.sdk().JoinedSubreddits(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to fetch joined subreddits: %v", err)
+	}
+
+	// Display joined subreddits
+	fmt.Println("Subreddits You've Joined:")
+	if len(joinedSubreddits) == 0 {
+		fmt.Println("You haven't joined any subreddits yet.")
+		return nil
+	}
+	printSubreddits(joinedSubreddits)
+
+	subredditID, err := promptID("Enter subreddit ID to leave")
+	if err != nil {
+		return err
+	}
+
+	if err := c.sdk().LeaveSubreddit(ctx, subredditID); err != nil {
+		return fmt.Errorf("subreddit leave failed: %v", err)
+	}
+
+	fmt.Println("Successfully left the subreddit!")
+	return nil
+}
+
+func (c *Client) CreateComment() error {
+	posts, err := c.sdk().Feed(context.Background(), goreddit.FeedOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to fetch feed: %v", err)
+	}
+
+	// Display feed posts with their IDs
+	fmt.Println("Feed Posts:")
+	if len(posts) == 0 {
+		fmt.Println("No posts available. Please create or join a subreddit first.")
+		return nil
+	}
+	printPosts(posts)
+
+	postID, err := promptID("Enter post ID to comment on")
+	if err != nil {
+		return err
+	}
+	return c.postComment(postID, nil)
+}
+
+
+// Scenario describes a load test declaratively: cohorts of simulated users with
+// their own action mixes, run through a sequence of phases
+type Scenario struct {
+	Name    string        `yaml:"name"`
+	Seed    int64         `yaml:"seed"`
+	Setup   ScenarioSetup `yaml:"setup"`
+	Cohorts []CohortSpec  `yaml:"cohorts"`
+	Phases  []PhaseSpec   `yaml:"phases"`
+	SLOs    []SLOSpec     `yaml:"slos"`
+
+	CircuitBreaker *CircuitBreakerSpec `yaml:"circuit_breaker"`
+	Churn          *ChurnSpec          `yaml:"churn"`
+}
+
+// ScenarioSetup is the data created before the first phase starts
+type ScenarioSetup struct {
+	Subreddits int `yaml:"subreddits"`
+}
+
+// CohortSpec is a group of users sharing an action mix. Action weights are
+// relative, e.g. {view_feed: 3, vote: 1} views the feed three times as often.
+type CohortSpec struct {
+	Name      string         `yaml:"name"`
+	Users     int            `yaml:"users"`
+	ThinkTime time.Duration  `yaml:"think_time"`
+	Actions   map[string]int `yaml:"actions"`
+}
+
+// PhaseSpec is a stretch of the run. Cohorts limits which cohorts are active
+// (all of them when empty) and Actions replaces their action mixes.
+type PhaseSpec struct {
+	Name     string         `yaml:"name"`
+	Duration time.Duration  `yaml:"duration"`
+	Ramp     *RampSpec      `yaml:"ramp"`
+	Cohorts  []string       `yaml:"cohorts"`
+	Actions  map[string]int `yaml:"actions"`
+}
+
+// RampSpec moves the active fraction of each cohort from From to To over a
+// phase, following Curve: linear, exponential or step
+type RampSpec struct {
+	From  float64 `yaml:"from"`
+	To    float64 `yaml:"to"`
+	Curve string  `yaml:"curve"`
+}
+
+// scenarioActions are the actions a scenario can use in its action mixes
+var scenarioActions = map[string]func(*loadUser) error{
+	"register":         (*loadUser).signUp,
+	"view_feed":        (*loadUser).viewFeed,
+	"create_subreddit": (*loadUser).createSubreddit,
+	"join_subreddit":   (*loadUser).joinSubreddit,
+	"create_post":      (*loadUser).createPost,
+	"comment":          (*loadUser).comment,
+	"vote":             (*loadUser).vote,
+	"vote_batch":       (*loadUser).voteBatch,
+	"send_message":     (*loadUser).sendMessage,
+	"view_messages":    (*loadUser).viewMessages,
+}
+
+// builtinScenarios are the scenarios shipped inside the client binary,
+// runnable by name (e.g. -scenario example) without the file at hand
+//
+//go:embed scenarios/*.yaml scenarios/*.json
+var builtinScenarios embed.FS
+
+// LoadScenario reads and validates a YAML or JSON scenario file, or a
+// built-in scenario when no file exists at the path
+func LoadScenario(path string) (*Scenario, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) && !strings.ContainsAny(path, `/\.`) {
+		for _, ext := range []string{".yaml", ".json"} {
+			if data, err = builtinScenarios.ReadFile("scenarios/" + path + ext); err == nil {
+				path += ext
+				break
+			}
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// JSON scenarios are read the same way as YAML ones, so they can give
+	// durations as strings like "30s" and unknown fields are still caught
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		var doc interface{}
+		if err := json.Unmarshal(data, &doc); err != nil {
+			return nil, fmt.Errorf("failed to parse scenario: %v", err)
+		}
+		if data, err = yaml.Marshal(doc); err != nil {
+			return nil, fmt.Errorf("failed to parse scenario: %v", err)
+		}
+	}
+
+	var scenario Scenario
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&scenario); err != nil {
+		return nil, fmt.Errorf("failed to parse scenario: %v", err)
+	}
+
+	if err := scenario.Validate(); err != nil {
+		return nil, err
+	}
+	return &scenario, nil
+}
+
+// Validate checks the scenario refers only to known cohorts, actions and curves
+func (s *Scenario) Validate() error {
+	if len(s.Cohorts) == 0 {
+		return fmt.Errorf("scenario has no cohorts")
+	}
+	if len(s.Phases) == 0 {
+		return fmt.Errorf("scenario has no phases")
+	}
+
+	for _, slo := range s.SLOs {
+		if slo.Endpoint == "" {
+			return fmt.Errorf("SLOs need an endpoint, such as \"GET /feed\" or \"*\"")
+		}
+		if slo.P50 < 0 || slo.P95 < 0 || slo.P99 < 0 {
+			return fmt.Errorf("SLO %s: thresholds must not be negative", slo.Endpoint)
+		}
+	}
+
+	if s.CircuitBreaker == nil {
+		s.CircuitBreaker = &CircuitBreakerSpec{}
+	}
+	if err := s.CircuitBreaker.applyDefaults(); err != nil {
+		return err
+	}
+	if s.Churn != nil {
+		if err := s.Churn.applyDefaults(); err != nil {
+			return err
+		}
+	}
+
+	cohorts := make(map[string]bool)
+	for _, cohort := range s.Cohorts {
+		if cohort.Name == "" || cohorts[cohort.Name] {
+			return fmt.Errorf("cohort names must be unique and non-empty")
+		}
+		cohorts[cohort.Name] = true
+		if cohort.Users <= 0 {
+			return fmt.Errorf("cohort %s: users must be positive", cohort.Name)
+		}
+		if err := validateActionMix(cohort.Actions); err != nil {
+			return fmt.Errorf("cohort %s: %v", cohort.Name, err)
+		}
+	}
+
+	for i, phase := range s.Phases {
+		if phase.Name == "" {
+			s.Phases[i].Name = fmt.Sprintf("phase-%d", i+1)
+			phase.Name = s.Phases[i].Name
+		}
+		if phase.Duration <= 0 {
+			return fmt.Errorf("phase %s: duration must be positive", phase.Name)
+		}
+		for _, name := range phase.Cohorts {
+			if !cohorts[name] {
+				return fmt.Errorf("phase %s: unknown cohort %s", phase.Name, name)
+			}
+		}
+		if phase.Actions != nil {
+			if err := validateActionMix(phase.Actions); err != nil {
+				return fmt.Errorf("phase %s: %v", phase.Name, err)
+			}
+		}
+		if ramp := phase.Ramp; ramp != nil {
+			if ramp.From < 0 || ramp.From > 1 || ramp.To < 0 || ramp.To > 1 {
+				return fmt.Errorf("phase %s: ramp fractions must be between 0 and 1", phase.Name)
+			}
+			switch ramp.Curve {
+			case "", "linear", "exponential", "step":
+			default:
+				return fmt.Errorf("phase %s: unknown ramp curve %s", phase.Name, ramp.Curve)
+			}
+		}
+	}
+	return nil
+}
+
+func validateActionMix(actions map[string]int) error {
+	if len(actions) == 0 {
+		return fmt.Errorf("no actions")
+	}
+	for name, weight := range actions {
+		if _, ok := scenarioActions[name]; !ok {
+			return fmt.Errorf("unknown action %s", name)
+		}
+		if weight <= 0 {
+			return fmt.Errorf("action %s: weight must be positive", name)
+		}
+	}
+	return nil
+}
+
+// Duration is the total length of all phases
+func (s *Scenario) Duration() time.Duration {
+	var total time.Duration
+	for _, phase := range s.Phases {
+		total += phase.Duration
+	}
+	return total
+}
+
+// phaseAt returns the phase running at the given offset into the run and how
+// far through it the run is, from 0 to 1. It returns nil once the run is over.
+func (s *Scenario) phaseAt(elapsed time.Duration) (*PhaseSpec, float64) {
+	for i := range s.Phases {
+		phase := &s.Phases[i]
+		if elapsed < phase.Duration {
+			return phase, float64(elapsed) / float64(phase.Duration)
+		}
+		elapsed -= phase.Duration
+	}
+	return nil, 1
+}
+
+// activeFraction is the share of each cohort that is active at the given
+// progress through the phase
+func (p *PhaseSpec) activeFraction(progress float64) float64 {
+	if p.Ramp == nil {
+		return 1
+	}
+	switch p.Ramp.Curve {
+	case "step":
+		return p.Ramp.To
+	case "exponential":
+		progress = progress * progress
+	}
+	return p.Ramp.From + (p.Ramp.To-p.Ramp.From)*progress
+}
+
+// runsCohort reports whether the cohort takes part in the phase
+func (p *PhaseSpec) runsCohort(name string) bool {
+	if len(p.Cohorts) == 0 {
+		return true
+	}
+	for _, cohort := range p.Cohorts {
+		if cohort == name {
+			return true
+		}
+	}
+	return false
+}
+
+// loadState is what simulated users have created so far, shared so they can
+// act on each other's content
+type loadState struct {
+	mu         sync.Mutex
+	subreddits []int
+	posts      []int
+	users      []int
+}
+
+func (s *loadState) add(list *[]int, id int) {
+	s.mu.Lock()
+	*list = append(*list, id)
+	s.mu.Unlock()
+}
+
+// pick returns a random ID from the list, or false when it is empty
+func (s *loadState) pick(rng *mathrand.Rand, list *[]int) (int, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(*list) == 0 {
+		return 0, false
+	}
+	return (*list)[rng.Intn(len(*list))], true
+}
+
+// actionStats counts the outcome of one action across the run
+type actionStats struct {
+	Count   int
+	Errors  int
+	Skipped int // an endpoint the action needed had its circuit open
+}
+
+// loadUser is one simulated user in a scenario run
+type loadUser struct {
+	*Client
+	name      string
+	rng       *mathrand.Rand
+	state     *loadState
+	latencies *latencyRecorder
+	breaker   *circuitBreaker
+	seq       int
+	accounts  int // registered by the register action, after the first
+
+	// churns is set for users that go offline and come back, who act while
+	// online until onlineUntil
+	churns      bool
+	onlineUntil time.Time
+
+	// writesResumeAt holds back writes when the server's rate limit headers
+	// say the user has run out
+	writesResumeAt time.Time
+}
+
+// ScenarioRunner runs a scenario against the server
+type ScenarioRunner struct {
+	scenario  *Scenario
+	state     *loadState
+	latencies *latencyRecorder
+	breaker   *circuitBreaker
+	runID     string
+	result    *RunReport // once the run has finished
+
+	mu    sync.Mutex
+	stats map[string]*actionStats
+}
+
+func NewScenarioRunner(scenario *Scenario) *ScenarioRunner {
+	return &ScenarioRunner{
+		scenario:  scenario,
+		state:     &loadState{},
+		latencies: newLatencyRecorder(),
+		breaker:   newCircuitBreaker(scenario.CircuitBreaker),
+		runID:     newNonce()[:8],
+		stats:     make(map[string]*actionStats),
+	}
+}
+
+func (r *ScenarioRunner) record(action string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stats, ok := r.stats[action]
+	if !ok {
+		stats = &actionStats{}
+		r.stats[action] = stats
+	}
+	stats.Count++
+	if errors.Is(err, errCircuitOpen) {
+		stats.Skipped++
+	} else if err != nil {
+		stats.Errors++
+	}
+}
+
+// Run executes the setup and every phase, returning the number of failed actions
+func (r *ScenarioRunner) Run() (int, error) {
+	s := r.scenario
+	log.Printf("Running scenario %q with seed %d for %v", s.Name, s.Seed, s.Duration())
+
+	if s.Setup.Subreddits > 0 {
+		owner := r.newUser("setup", 0)
+		if err := owner.register(); err != nil {
+			return 0, fmt.Errorf("setup failed: %v", err)
+		}
+		for i := 0; i < s.Setup.Subreddits; i++ {
+			if err := owner.createSubreddit(); err != nil {
+				return 0, fmt.Errorf("setup failed: %v", err)
+			}
+		}
+	}
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for _, cohort := range s.Cohorts {
+		for i := 0; i < cohort.Users; i++ {
+			wg.Add(1)
+			go func(cohort CohortSpec, index int) {
+				defer wg.Done()
+				r.runUser(start, cohort, index)
+			}(cohort, i)
+		}
+	}
+
+	// Report each phase as it starts
+	go func() {
+		var offset time.Duration
+		for _, phase := range s.Phases {
+			time.Sleep(time.Until(start.Add(offset)))
+			log.Printf("Phase %q started", phase.Name)
+			offset += phase.Duration
+		}
+	}()
+
+	wg.Wait()
+	return r.report(time.Since(start)), nil
+}
+
+func (r *ScenarioRunner) newUser(cohort string, index int) *loadUser {
+	return &loadUser{
+		Client:    NewClient(goreddit.WithRetries(0)), // the runner measures every attempt
+		name:      fmt.Sprintf("%s_%s_%d", cohort, r.runID, index),
+		rng:       mathrand.New(mathrand.NewSource(r.scenario.Seed + int64(index)*7919 + int64(len(cohort)))),
+		state:     r.state,
+		latencies: r.latencies,
+		breaker:   r.breaker,
+	}
+}
+
+// runUser acts as one member of a cohort until the scenario ends. The user is
+// registered the first time it becomes active, and only acts while its index
+// falls within the active fraction of the cohort and, if it churns, while
+// it's online.
+func (r *ScenarioRunner) runUser(start time.Time, cohort CohortSpec, index int) {
+	user := r.newUser(cohort.Name, index)
+	user.churns = r.scenario.Churn != nil && user.rng.Float64() < r.scenario.Churn.Fraction
+	for {
+		phase, progress := r.scenario.phaseAt(time.Since(start))
+		if phase == nil {
+			return
+		}
+
+		active := phase.runsCohort(cohort.Name) &&
+			float64(index) < phase.activeFraction(progress)*float64(cohort.Users)
+		if !active {
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+
+		if user.userID == "" {
+			err := user.register()
+			r.record("register", err)
+			if err != nil {
+				time.Sleep(time.Second)
+				continue
+			}
+			if user.churns {
+				err := user.login()
+				r.record("login", err)
+				if err == nil {
+					user.onlineUntil = time.Now().Add(jittered(user.rng, r.scenario.Churn.Online))
+				}
+			}
+		}
+		if user.churns && !r.churn(user, start) {
+			return
+		}
+
+		mix := cohort.Actions
+		if phase.Actions != nil {
+			mix = phase.Actions
+		}
+		action := pickAction(user.rng, mix)
+		r.record(action, scenarioActions[action](user))
+
+		if cohort.ThinkTime > 0 {
+			// Up to 50% jitter so users don't act in lockstep
+			jitter := time.Duration(user.rng.Int63n(int64(cohort.ThinkTime)))
+			time.Sleep(cohort.ThinkTime/2 + jitter)
+		}
+	}
+}
+
+// pickAction chooses an action from a weighted mix
+func pickAction(rng *mathrand.Rand, mix map[string]int) string {
+	names := make([]string, 0, len(mix))
+	total := 0
+	for name, weight := range mix {
+		names = append(names, name)
+		total += weight
+	}
+	// Map order is random, so sort to keep seeded runs reproducible
+	sort.Strings(names)
+
+	n := rng.Intn(total)
+	for _, name := range names {
+		n -= mix[name]
+		if n < 0 {
+			return name
+		}
+	}
+	return names[len(names)-1]
+}
+
+// latencyHistogram records durations in log-linear buckets, HDR-style: each
+// power of two range of microseconds is split into histogramSubBuckets equal
+// buckets, so percentiles stay within a few percent of the true value
+// whatever the latency range.
+type latencyHistogram struct {
+	counts map[int]int
+	total  int
+	max    time.Duration
+}
+
+// histogramSubBuckets is the number of buckets per power of two. 32 keeps the
+// relative error of a percentile under about 3%.
+const histogramSubBuckets = 32
+
+func newLatencyHistogram() *latencyHistogram {
+	return &latencyHistogram{counts: make(map[int]int)}
+}
+
+// bucketOf maps a latency in microseconds to its bucket index
+func bucketOf(us int64) int {
+	if us < histogramSubBuckets {
+		return int(us)
+	}
+	magnitude := bits.Len64(uint64(us)) - 1 // floor(log2(us))
+	shift := magnitude - bits.Len64(histogramSubBuckets-1)
+	sub := int(us>>uint(shift)) - histogramSubBuckets
+	return (shift+1)*histogramSubBuckets + sub
+}
+
+// bucketUpperBound is the largest latency in microseconds that falls in the bucket
+func bucketUpperBound(bucket int) int64 {
+	if bucket < histogramSubBuckets {
+		return int64(bucket)
+	}
+	shift := bucket/histogramSubBuckets - 1
+	sub := bucket % histogramSubBuckets
+	return int64(histogramSubBuckets+sub+1)<<uint(shift) - 1
+}
+
+func (h *latencyHistogram) Record(d time.Duration) {
+	h.counts[bucketOf(d.Microseconds())]++
+	h.total++
+	if d > h.max {
+		h.max = d
+	}
+}
+
+// Percentile returns the latency below which the given fraction (0 to 1) of
+// the recorded requests fall
+func (h *latencyHistogram) Percentile(q float64) time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+	buckets := make([]int, 0, len(h.counts))
+	for bucket := range h.counts {
+		buckets = append(buckets, bucket)
+	}
+	sort.Ints(buckets)
+
+	rank := int(math.Ceil(q * float64(h.total)))
+	seen := 0
+	for _, bucket := range buckets {
+		seen += h.counts[bucket]
+		if seen >= rank {
+			d := time.Duration(bucketUpperBound(bucket)) * time.Microsecond
+			if d > h.max {
+				d = h.max
+			}
+			return d
+		}
+	}
+	return h.max
+}
+
+// endpointStats is the latency and error count of one endpoint across a run
+type endpointStats struct {
+	latency *latencyHistogram
+	errors  int
+}
+
+// latencyRecorder collects endpointStats for every endpoint called in a run
+type latencyRecorder struct {
+	mu        sync.Mutex
+	endpoints map[string]*endpointStats
+}
+
+func newLatencyRecorder() *latencyRecorder {
+	return &latencyRecorder{endpoints: make(map[string]*endpointStats)}
+}
+
+func (l *latencyRecorder) Record(endpoint string, d time.Duration, failed bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	stats, ok := l.endpoints[endpoint]
+	if !ok {
+		stats = &endpointStats{latency: newLatencyHistogram()}
+		l.endpoints[endpoint] = stats
+	}
+	stats.latency.Record(d)
+	if failed {
+		stats.errors++
+	}
+}
+
+// names returns the recorded endpoints in sorted order
+func (l *latencyRecorder) names() []string {
+	names := make([]string, 0, len(l.endpoints))
+	for name := range l.endpoints {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// routeOf turns a request path into its route, replacing numeric IDs so
+// requests to different resources are grouped, e.g. POST /subreddits/:id/join
+func routeOf(method, path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if _, err := strconv.Atoi(segment); err == nil {
+			segments[i] = ":id"
+		}
+	}
+	return method + " " + strings.Join(segments, "/")
+}
+
+// CircuitBreakerSpec configures per-endpoint circuit breaking. An endpoint
+// whose failure rate (transport errors and 5xx responses) reaches
+// ErrorThreshold over at least MinRequests requests within Window is skipped
+// for CoolDown, then probed with a single request before it's used again.
+type CircuitBreakerSpec struct {
+	Disabled       bool          `yaml:"disabled"`
+	ErrorThreshold float64       `yaml:"error_threshold"`
+	MinRequests    int           `yaml:"min_requests"`
+	Window         time.Duration `yaml:"window"`
+	CoolDown       time.Duration `yaml:"cool_down"`
+}
+
+// Circuit breaker defaults, used for settings a scenario leaves out
+const (
+	defaultBreakerErrorThreshold = 0.5
+	defaultBreakerMinRequests    = 20
+	defaultBreakerWindow         = 10 * time.Second
+	defaultBreakerCoolDown       = 30 * time.Second
+)
+
+// applyDefaults fills in unset settings and checks the rest
+func (c *CircuitBreakerSpec) applyDefaults() error {
+	if c.ErrorThreshold == 0 {
+		c.ErrorThreshold = defaultBreakerErrorThreshold
+	}
+	if c.MinRequests == 0 {
+		c.MinRequests = defaultBreakerMinRequests
+	}
+	if c.Window == 0 {
+		c.Window = defaultBreakerWindow
+	}
+	if c.CoolDown == 0 {
+		c.CoolDown = defaultBreakerCoolDown
+	}
+	if c.ErrorThreshold < 0 || c.ErrorThreshold > 1 {
+		return fmt.Errorf("circuit_breaker: error_threshold must be between 0 and 1")
+	}
+	if c.MinRequests < 0 || c.Window < 0 || c.CoolDown < 0 {
+		return fmt.Errorf("circuit_breaker: settings must not be negative")
+	}
+	return nil
+}
+
+// errCircuitOpen is returned instead of sending a request to an endpoint
+// whose circuit is open
+var errCircuitOpen = errors.New("circuit open")
+
+// endpointCircuit is the circuit breaker state of one endpoint
+type endpointCircuit struct {
+	windowStart time.Time
+	requests    int
+	failures    int
+	openUntil   time.Time // zero while closed
+	probing     bool      // a half-open probe request is in flight
+	trips       int
+	skipped     int
+}
+
+// circuitBreaker tracks failure rates per endpoint across all users of a run
+// and stops requests to failing endpoints for a while, so one broken route
+// doesn't fail every action that touches it for the rest of the run. A nil
+// circuitBreaker lets every request through.
+type circuitBreaker struct {
+	spec      CircuitBreakerSpec
+	mu        sync.Mutex
+	endpoints map[string]*endpointCircuit
+}
+
+func newCircuitBreaker(spec *CircuitBreakerSpec) *circuitBreaker {
+	if spec == nil || spec.Disabled {
+		return nil
+	}
+	return &circuitBreaker{spec: *spec, endpoints: make(map[string]*endpointCircuit)}
+}
+
+func (b *circuitBreaker) circuit(endpoint string) *endpointCircuit {
+	circuit, ok := b.endpoints[endpoint]
+	if !ok {
+		circuit = &endpointCircuit{windowStart: time.Now()}
+		b.endpoints[endpoint] = circuit
+	}
+	return circuit
+}
+
+// Allow returns errCircuitOpen when requests to the endpoint should be
+// skipped. Once the cool-down has passed one probe request is let through.
+func (b *circuitBreaker) Allow(endpoint string) error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	circuit := b.circuit(endpoint)
+	if circuit.openUntil.IsZero() {
+		return nil
+	}
+	if time.Now().Before(circuit.openUntil) || circuit.probing {
+		circuit.skipped++
+		return errCircuitOpen
+	}
+	circuit.probing = true
+	return nil
+}
+
+// Done records the outcome of a request Allow let through
+func (b *circuitBreaker) Done(endpoint string, failed bool) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	circuit := b.circuit(endpoint)
+	now := time.Now()
+	if circuit.probing {
+		circuit.probing = false
+		if failed {
+			b.trip(endpoint, circuit, now)
+		} else {
+			log.Printf("Circuit for %s closed", endpoint)
+			*circuit = endpointCircuit{windowStart: now, trips: circuit.trips, skipped: circuit.skipped}
+		}
+		return
+	}
+	if !circuit.openUntil.IsZero() {
+		// A request sent before the circuit opened
+		return
+	}
+
+	if now.Sub(circuit.windowStart) > b.spec.Window {
+		circuit.windowStart, circuit.requests, circuit.failures = now, 0, 0
+	}
+	circuit.requests++
+	if failed {
+		circuit.failures++
+	}
+	if circuit.requests >= b.spec.MinRequests &&
+		float64(circuit.failures)/float64(circuit.requests) >= b.spec.ErrorThreshold {
+		b.trip(endpoint, circuit, now)
+	}
+}
+
+func (b *circuitBreaker) trip(endpoint string, circuit *endpointCircuit, now time.Time) {
+	circuit.trips++
+	circuit.openUntil = now.Add(b.spec.CoolDown)
+	log.Printf("Circuit for %s opened for %v (%d/%d requests failed)",
+		endpoint, b.spec.CoolDown, circuit.failures, circuit.requests)
+}
+
+// report prints every endpoint whose circuit opened during the run
+func (b *circuitBreaker) report() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	names := make([]string, 0, len(b.endpoints))
+	for name, circuit := range b.endpoints {
+		if circuit.trips > 0 {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return
+	}
+	sort.Strings(names)
+
+	fmt.Printf("\n%-36s %8s %8s %8s\n", "CIRCUIT OPENED", "TRIPS", "SKIPPED", "STATE")
+	for _, name := range names {
+		circuit := b.endpoints[name]
+		state := "closed"
+		if !circuit.openUntil.IsZero() {
+			state = "open"
+		}
+		fmt.Printf("%-36s %8d %8d %8s\n", name, circuit.trips, circuit.skipped, state)
+	}
+}
+
+// SLOSpec sets latency thresholds for an endpoint, such as "GET /feed", or for
+// every endpoint when Endpoint is "*". Zero thresholds are not checked.
+type SLOSpec struct {
+	Endpoint string        `yaml:"endpoint"`
+	P50      time.Duration `yaml:"p50"`
+	P95      time.Duration `yaml:"p95"`
+	P99      time.Duration `yaml:"p99"`
+}
+
+// CheckSLOs compares the recorded latencies with the scenario's SLOs and
+// prints and returns every violation
+func (r *ScenarioRunner) CheckSLOs() []string {
+	r.latencies.mu.Lock()
+	defer r.latencies.mu.Unlock()
+
+	var violations []string
+	for _, slo := range r.scenario.SLOs {
+		for _, endpoint := range r.latencies.names() {
+			if slo.Endpoint != "*" && slo.Endpoint != endpoint {
+				continue
+			}
+			latency := r.latencies.endpoints[endpoint].latency
+			for _, check := range []struct {
+				name      string
+				q         float64
+				threshold time.Duration
+			}{{"p50", 0.50, slo.P50}, {"p95", 0.95, slo.P95}, {"p99", 0.99, slo.P99}} {
+				if check.threshold == 0 {
+					continue
+				}
+				if actual := latency.Percentile(check.q); actual > check.threshold {
+					violations = append(violations, fmt.Sprintf("%s %s %v exceeds %v",
+						endpoint, check.name, actual.Round(time.Microsecond), check.threshold))
+				}
+			}
+		}
+	}
+
+	if len(r.scenario.SLOs) > 0 {
+		if len(violations) == 0 {
+			fmt.Println("\nAll SLOs met")
+		} else {
+			fmt.Println("\nSLO violations:")
+			for _, violation := range violations {
+				fmt.Printf("  %s\n", violation)
+			}
+		}
+	}
+	return violations
+}
+
+// do sends a request and decodes the JSON response, failing on any status
+// other than the expected one
+func (u *loadUser) do(method, endpoint string, body interface{}, status int, out interface{}) error {
+	route := routeOf(method, endpoint)
+	if err := u.breaker.Allow(route); err != nil {
+		return fmt.Errorf("%s: %w", route, err)
+	}
+
+	write := method != "GET"
+	if wait := time.Until(u.writesResumeAt); write && wait > 0 {
+		time.Sleep(wait)
+	}
+
+	start := time.Now()
+	resp, err := u.makeRequest(method, endpoint, body)
+	if err != nil {
+		u.latencies.Record(route, time.Since(start), true)
+		u.breaker.Done(route, true)
+		return err
+	}
+	defer resp.Body.Close()
+	if write {
+		u.throttle(resp)
+	}
+
+	u.latencies.Record(route, time.Since(start), resp.StatusCode != status)
+	u.breaker.Done(route, resp.StatusCode >= http.StatusInternalServerError)
+	if resp.StatusCode != status {
+		var response map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&response)
+		return fmt.Errorf("%s %s: %d %v", method, endpoint, resp.StatusCode, response["error"])
+	}
+	if out != nil {
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+	return nil
+}
+
+// throttle paces the user's writes from the server's rate limit headers:
+// once no writes remain, the next one waits for a token, and after a 429 it
+// waits as long as Retry-After says
+func (u *loadUser) throttle(resp *http.Response) {
+	if retryAfter, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && resp.StatusCode == http.StatusTooManyRequests {
+		u.writesResumeAt = time.Now().Add(time.Duration(retryAfter) * time.Second)
+		return
+	}
+
+	limit, err1 := strconv.Atoi(resp.Header.Get("X-RateLimit-Limit"))
+	remaining, err2 := strconv.Atoi(resp.Header.Get("X-RateLimit-Remaining"))
+	reset, err3 := strconv.Atoi(resp.Header.Get("X-RateLimit-Reset"))
+	if err1 != nil || err2 != nil || err3 != nil || limit <= 0 || remaining > 0 {
+		return
+	}
+	// With nothing left, the reset time covers refilling the whole bucket
+	u.writesResumeAt = time.Now().Add(time.Duration(reset) * time.Second / time.Duration(limit))
+}
+
+// onboardingJoins is how many of its suggested subreddits a new simulated
+// user joins
+const onboardingJoins = 3
+
+func (u *loadUser) register() error {
+	var response goreddit.RegisterResult
+	body := goreddit.RegisterRequest{Username: u.name, Password: u.name, CaptchaToken: captchaToken}
+	if err := u.do("POST", "/register", body, http.StatusCreated, &response); err != nil {
+		return err
+	}
+	u.userID = strconv.Itoa(response.UserID)
+	u.state.add(&u.state.users, response.UserID)
+
+	// Start out in a few subreddits so posting and the feed have something
+	// to use, like a person picking from the onboarding suggestions
+	var picks []int
+	for _, subreddit := range response.SuggestedSubreddits {
+		if len(picks) == onboardingJoins {
+			break
+		}
+		picks = append(picks, subreddit.ID)
+	}
+	if len(picks) > 0 {
+		return u.do("POST", "/subreddits/join", goreddit.JoinSubredditsRequest{SubredditIDs: picks}, http.StatusOK, nil)
+	}
+	if _, ok := u.state.pick(u.rng, &u.state.subreddits); ok {
+		return u.joinSubreddit()
+	}
+	return nil
+}
+
+// signUp switches the user to a newly registered account, like a new
+// visitor joining the site
+func (u *loadUser) signUp() error {
+	if u.accounts > 0 {
+		u.name = u.name[:strings.LastIndex(u.name, "_a")]
+	}
+	u.accounts++
+	u.name = fmt.Sprintf("%s_a%d", u.name, u.accounts)
+	u.token = "" // the previous account's session
+	if err := u.register(); err != nil {
+		return err
+	}
+	if u.churns {
+		return u.login()
+	}
+	return nil
+}
+
+func (u *loadUser) viewFeed() error {
+	return u.do("GET", "/feed", nil, http.StatusOK, nil)
+}
+
+func (u *loadUser) createSubreddit() error {
+	u.seq++
+	var response goreddit.SubredditCreated
+	body := goreddit.CreateSubredditRequest{
+		Name:        fmt.Sprintf("%s_%d", u.name, u.seq),
+		Description: "Created by the load simulator",
+	}
+	if err := u.do("POST", "/subreddits", body, http.StatusCreated, &response); err != nil {
+		return err
+	}
+	u.state.add(&u.state.subreddits, response.SubredditID)
+	return nil
+}
+
+func (u *loadUser) joinSubreddit() error {
+	subredditID, ok := u.state.pick(u.rng, &u.state.subreddits)
+	if !ok {
+		return u.createSubreddit()
+	}
+	return u.do("POST", fmt.Sprintf("/subreddits/%d/join", subredditID), nil, http.StatusOK, nil)
+}
+
+func (u *loadUser) createPost() error {
+	subredditID, ok := u.state.pick(u.rng, &u.state.subreddits)
+	if !ok {
+		return u.createSubreddit()
+	}
+	u.seq++
+	var response goreddit.PostCreated
+	body := goreddit.CreatePostRequest{
+		Title:       fmt.Sprintf("Post %d from %s", u.seq, u.name),
+		Content:     "Generated by the load simulator",
+		SubredditID: subredditID,
+	}
+	if err := u.do("POST", "/posts", body, http.StatusCreated, &response); err != nil {
+		return err
+	}
+	u.state.add(&u.state.posts, response.PostID)
+	return nil
+}
+
+func (u *loadUser) comment() error {
+	postID, ok := u.state.pick(u.rng, &u.state.posts)
+	if !ok {
+		return u.createPost()
+	}
+	body := goreddit.CreateCommentRequest{
+		Content: fmt.Sprintf("Comment from %s", u.name),
+		PostID:  postID,
+	}
+	return u.do("POST", "/comments", body, http.StatusCreated, nil)
+}
+
+func (u *loadUser) vote() error {
+	postID, ok := u.state.pick(u.rng, &u.state.posts)
+	if !ok {
+		return u.createPost()
+	}
+	value := 1
+	if u.rng.Intn(4) == 0 {
+		value = -1
+	}
+	return u.do("POST", "/vote", goreddit.NewVoteRequest("post", postID, value), http.StatusOK, nil)
+}
+
+// voteBatch upvotes up to 10 posts in one request, as a client catching up
+// on votes cast offline would
+func (u *loadUser) voteBatch() error {
+	seen := map[int]bool{}
+	var votes []goreddit.VoteRequest
+	for i := 0; i < 10; i++ {
+		postID, ok := u.state.pick(u.rng, &u.state.posts)
+		if !ok {
+			return u.createPost()
+		}
+		if !seen[postID] {
+			seen[postID] = true
+			votes = append(votes, goreddit.NewVoteRequest("post", postID, 1))
+		}
+	}
+	body := struct {
+		Votes []goreddit.VoteRequest `json:"votes"`
+	}{votes}
+	return u.do("POST", "/votes/batch", body, http.StatusOK, nil)
+}
+
+func (u *loadUser) sendMessage() error {
+	toUserID, ok := u.state.pick(u.rng, &u.state.users)
+	if !ok {
+		return fmt.Errorf("no users to message")
+	}
+	body := goreddit.SendMessageRequest{
+		ToUserID: toUserID,
+		Content:  fmt.Sprintf("Hello from %s", u.name),
+	}
+	return u.do("POST", "/messages", body, http.StatusCreated, nil)
+}
+
+func (u *loadUser) viewMessages() error {
+	return u.do("GET", "/messages", nil, http.StatusOK, nil)
+}
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "load" {
+		log.SetOutput(os.Stdout)
+		log.SetFlags(0)
+		os.Exit(runLoad(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		log.SetOutput(os.Stdout)
+		log.SetFlags(0)
+		os.Exit(runReplay(os.Args[2:]))
+	}
+
+	scenarioPath := flag.String("scenario", "", "run the YAML or JSON load scenario at this path, or a built-in one by name, instead of the interactive menu")
+	seed := flag.Int64("seed", 0, "run the scenario with this seed instead of its own")
+	reportJSON := flag.String("report-json", "", "write the scenario's performance report as JSON to this path")
+	reportCSV := flag.String("report-csv", "", "write the scenario's per-endpoint performance report as CSV to this path")
+	version := flag.Bool("version", false, "print the client version and exit")
+	menu := flag.Bool("menu", false, "use the step-by-step menu instead of the full-screen client")
+	flag.StringVar(&captchaToken, "captcha-token", "", "CAPTCHA response sent when registering, such as a provider's test token")
+	flag.StringVar(&credentialsPath, "credentials", defaultCredentialsPath(), "file keeping the signed-in account between runs")
+	flag.StringVar(&serverURL, "server", defaultServerURL, "URL of the server to use")
+	configPath := flag.String("config", defaultConfigPath(), "client config file with the server profiles")
+	profile := flag.String("profile", "", "use this profile from the config file instead of the default one")
+	recordPath := flag.String("record", "", "record the session's API calls to this file, for the replay subcommand")
+	flag.Parse()
+
+	if *version {
+		fmt.Println(buildinfo.String("goreddit-client"))
+		return
+	}
+
+	log.SetOutput(os.Stdout)
+    log.SetFlags(0)
+
+	if err := applyProfile(flag.CommandLine, *configPath, *profile); err != nil {
+		log.Fatalf("Invalid profile: %v", err)
+	}
+	stopRecording, err := startRecording(*recordPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer stopRecording()
+
+	client := NewClient()
+
+	if *scenarioPath != "" {
+		scenario, err := LoadScenario(*scenarioPath)
+		if err != nil {
+			log.Fatalf("Invalid scenario: %v", err)
+		}
+		if *seed != 0 {
+			scenario.Seed = *seed
+		}
+		runner := NewScenarioRunner(scenario)
+		failures, err := runner.Run()
+		if err != nil {
+			log.Fatalf("Scenario failed: %v", err)
+		}
+		violations := runner.CheckSLOs()
+		if err := runner.WriteReports(violations, *reportJSON, *reportCSV); err != nil {
+			log.Fatalf("Failed to write the report: %v", err)
+		}
+		if failures > 0 || len(violations) > 0 {
+			stopRecording()
+			os.Exit(1)
+		}
+		return
+	}
+
+	if err := client.loadCredentials(); err != nil {
+		fmt.Printf("Error: %v\n", err)
+	} else if client.userID != "" {
+		if !*menu {
+			if err := runTUI(client); err != nil {
+				log.Fatalf("Full-screen client failed: %v", err)
+			}
+			return
+		}
+		fmt.Printf("Signed in as %s (user ID %s) on %s\n", client.username, client.userID, serverURL)
+	}
+
+	for {
+		prompt := promptui.Select{
+			Label: "Reddit Clone API Client",
+			Items: []string{
+				"Register",
+				"Login",
+				"Open Full-Screen Client",
+				"Create Subreddit",
+				"Create Post",
+				"Comment",
+				"View Comments",
+				"View Feed",
+				"Browse Subreddit",
+				"Join Subreddit",
+				"Leave Subreddit",
+				"Vote",
+				"Send Message",
+				"View Messages",
+				"Leave Chat",
+				"Subscribe to User",
+				"Unsubscribe from User",
+				"View Top Users",
+				"View Top Subscribed Users",
+				"Logout / Switch User",
+				"Exit",
+			},
+		}
+
+		_, result, err := prompt.Run()
+		if err != nil {
+			fmt.Printf("Prompt failed %v\n", err)
+			return
+		}
+
+		var actionErr error
+		switch result {
+		case "Register":
+			if client.userID == "" {
+				actionErr = client.Register()
+			} else {
+				fmt.Printf("You have already registered.\n")
+			}
+		case "Login":
+			if client.userID == "" {
+				actionErr = client.Login()
+			} else {
+				fmt.Printf("You are already logged in as %s; use Logout / Switch User first.\n", client.username)
+			}
+		case "Open Full-Screen Client":
+			if client.userID == "" {
+				log.Printf("You need to register or log in before accessing the system.")
+			} else {
+				actionErr = runTUI(client)
+			}
+		case "Logout / Switch User":
+			if client.userID == "" {
+				log.Printf("You are not logged in.")
+			} else {
+				actionErr = client.SwitchUser()
+			}
+		case "Create Subreddit":
+			if client.userID == "" {
+				log.Printf("You need to register or log in before accessing the system.")
+			} else {
+				actionErr = client.CreateSubreddit()
+			}
+		case "Create Post":
+			if client.userID == "" {
+				log.Printf("You need to register or log in before accessing the system.")
+			} else {
+				actionErr = client.CreatePost()
+			}
+		case "View Feed":
+			if client.userID == "" {
+				log.Printf("You need to register or log in before accessing the system.")
+			} else {
+				actionErr = client.ViewFeed()
+			}
+		case "Browse Subreddit":
+			if client.userID == "" {
+				log.Printf("You need to register or log in before accessing the system.")
+			} else {
+				actionErr = client.BrowseSubreddit()
+			}
+		case "Vote":
+			if client.userID == "" {
+				log.Printf("You need to register or log in before accessing the system.")
+			} else {
+				actionErr = client.Vote()
+			}
+		case "Send Message":
+			if client.userID == "" {
+				log.Printf("You need to register or log in before accessing the system.")
+			} else {
+				actionErr = client.SendMessage()
+			}
+		case "View Messages":
+			if client.userID == "" {
+				log.Printf("You need to register or log in before accessing the system.")
+			} else {
+				actionErr = client.ViewMessages()
+			}
+		case "Subscribe to User":
+			if client.userID == "" {
+				log.Printf("You need to register or log in before accessing the system.")
+			} else {
+				actionErr = client.SubscribeToUser()
+			}
+		case "View Top Users":
+			if client.userID == "" {
+				log.Printf("You need to register or log in before accessing the system.")
+			} else {
+				actionErr = client.ViewTopUsers()
+			}
+		case "Unsubscribe from User":
+			if client.userID == "" {
+				log.Printf("You need to register or log in before accessing the system.")
+			} else {
+				actionErr = client.UnsubscribeFromUser()
+			}
+		case "View Top Subscribed Users":
+			if client.userID == "" {
+				log.Printf("You need to register or log in before accessing the system.")
+			} else {
+				actionErr = client.ViewTopSubscribedUsers()
+			}
+		case "Leave Chat":
+			if client.userID == "" {
+				log.Printf("You need to register or log in before accessing the system.")
+			} else {
+				actionErr = client.LeaveChat()
+			}
+		case "Join Subreddit":
+			if client.userID == "" {
+				log.Printf("You need to register or log in before accessing the system.")
+			} else {
+				actionErr = client.JoinSubreddit()
+			}
+		case "Leave Subreddit":
+			if client.userID == "" {
+				log.Printf("You need to register or log in before accessing the system.")
+			} else {
+				actionErr = client.LeaveSubreddit()
+			}
+		case "Comment":
+			if client.userID == "" {
+				log.Printf("You need to register or log in before accessing the system.")
+			} else {
+				actionErr = client.CreateComment()
+			}
+		case "View Comments":
+			if client.userID == "" {
+				log.Printf("You need to register or log in before accessing the system.")
+			} else {
+				actionErr = client.ViewComments()
+			}
+		case "Exit":
+			fmt.Println("Exiting...")
+			stopRecording()
+			os.Exit(0)
+
+		}
+
+		if actionErr != nil {
+			fmt.Printf("Error: %v\n", actionErr)
+		}
+
+		fmt.Println("\nPress Enter to continue...")
+		fmt.Scanln()
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const defaultServerURL = "http://localhost:8080"
+
+// serverURL is the server the client sends its requests to
+var serverURL = defaultServerURL
+
+// Profile is a named server to target, e.g. local, staging or production,
+// with its own saved account
+type Profile struct {
+	Server       string `yaml:"server"`
+	Credentials  string `yaml:"credentials"` // defaults to credentials-<profile>.json next to the config file
+	CaptchaToken string `yaml:"captcha_token"`
+}
+
+// ClientConfig is the client's config file: its profiles, and the one used
+// unless -profile picks another
+type ClientConfig struct {
+	DefaultProfile string             `yaml:"default_profile"`
+	Profiles       map[string]Profile `yaml:"profiles"`
+}
+
+// configDir is the client's directory in the user's config directory, e.g.
+// ~/.config/goreddit on Linux, or "" if there isn't one
+func configDir() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "goreddit")
+}
+
+func defaultConfigPath() string {
+	if dir := configDir(); dir != "" {
+		return filepath.Join(dir, "config.yaml")
+	}
+	return ".goreddit.yaml"
+}
+
+// loadClientConfig reads the config file at path. A missing file is an
+// empty config.
+func loadClientConfig(path string) (*ClientConfig, error) {
+	config := &ClientConfig{}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return config, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(config); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %v", path, err)
+	}
+	return config, nil
+}
+
+// applyProfile targets the server of the named profile, or of the config's
+// default profile when name is empty, and uses its saved account. Flags set
+// on the command line win over the profile.
+func applyProfile(flags *flag.FlagSet, configPath, name string) error {
+	set := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	config, err := loadClientConfig(configPath)
+	if err != nil {
+		return err
+	}
+	if name == "" {
+		name = config.DefaultProfile
+	}
+	if name != "" {
+		profile, ok := config.Profiles[name]
+		if !ok {
+			return fmt.Errorf("profile %q not found in %s", name, configPath)
+		}
+		if profile.Server != "" && !set["server"] {
+			serverURL = profile.Server
+		}
+		if profile.CaptchaToken != "" && !set["captcha-token"] {
+			captchaToken = profile.CaptchaToken
+		}
+		if !set["credentials"] {
+			credentialsPath = profile.Credentials
+			if credentialsPath == "" {
+				credentialsPath = filepath.Join(filepath.Dir(configPath), "credentials-"+name+".json")
+			}
+		}
+	}
+
+	serverURL, err = normalizeServerURL(serverURL)
+	return err
+}
+
+// normalizeServerURL checks that a server URL is an http or https URL, and
+// drops any trailing slash so that paths can be appended to it
+func normalizeServerURL(value string) (string, error) {
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("invalid server URL %q: it must be like http://localhost:8080", value)
+	}
+	return strings.TrimRight(value, "/"), nil
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// recorder, when set, records every request the client sends
+var recorder *Recorder
+
+// Recording is one API call of a recorded session: the request, as sent
+// with its session token or user ID, and the response it got
+type Recording struct {
+	Method     string            `json:"method"`
+	Path       string            `json:"path"` // with the query, without the server
+	Headers    map[string]string `json:"headers,omitempty"`
+	Body       json.RawMessage   `json:"body,omitempty"`
+	Status     int               `json:"status"` // 0 when the request failed
+	Response   json.RawMessage   `json:"response,omitempty"`
+	Error      string            `json:"error,omitempty"`
+	DurationMs float64           `json:"duration_ms"`
+}
+
+// recordedHeaders are the request headers worth replaying, which identify
+// the user
+var recordedHeaders = []string{"Authorization", "X-User-ID"}
+
+// Recorder writes a session's API calls to a file as JSON lines, in the
+// order they finish
+type Recorder struct {
+	mu   sync.Mutex
+	file *os.File
+	enc  *json.Encoder
+}
+
+// NewRecorder records to a new file at path, readable only by the user
+// since recordings hold session tokens
+func NewRecorder(path string) (*Recorder, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &Recorder{file: file, enc: json.NewEncoder(file)}, nil
+}
+
+func (r *Recorder) Record(rec Recording) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.enc.Encode(rec)
+}
+
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.file.Close()
+}
+
+// rawJSON keeps a body as JSON, or as a JSON string when it isn't JSON
+func rawJSON(data []byte) json.RawMessage {
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil
+	}
+	if json.Valid(data) {
+		return json.RawMessage(bytes.TrimSpace(data))
+	}
+	quoted, _ := json.Marshal(string(data))
+	return quoted
+}
+
+// recordingTransport sends requests and records them with their responses
+type recordingTransport struct {
+	base     http.RoundTripper
+	recorder *Recorder
+}
+
+func (t recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rec := Recording{Method: req.Method, Path: req.URL.RequestURI()}
+	for _, name := range recordedHeaders {
+		if value := req.Header.Get(name); value != "" {
+			if rec.Headers == nil {
+				rec.Headers = make(map[string]string)
+			}
+			rec.Headers[name] = value
+		}
+	}
+	if req.Body != nil {
+		body, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		rec.Body = rawJSON(body)
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		rec.Error = err.Error()
+		rec.DurationMs = milliseconds(time.Since(start))
+		t.record(rec)
+		return nil, err
+	}
+	response, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	rec.DurationMs = milliseconds(time.Since(start))
+	if err != nil {
+		rec.Error = err.Error()
+	}
+	rec.Status = resp.StatusCode
+	rec.Response = rawJSON(response)
+	t.record(rec)
+
+	resp.Body = io.NopCloser(bytes.NewReader(response))
+	return resp, err
+}
+
+func (t recordingTransport) record(rec Recording) {
+	if err := t.recorder.Record(rec); err != nil {
+		log.Printf("Failed to record %s %s: %v", rec.Method, rec.Path, err)
+	}
+}
+
+// startRecording records the session's API calls to path, if it isn't
+// empty. The returned func finishes the recording.
+func startRecording(path string) (func(), error) {
+	if path == "" {
+		return func() {}, nil
+	}
+	r, err := NewRecorder(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start recording: %v", err)
+	}
+	recorder = r
+	return func() {
+		if err := r.Close(); err != nil {
+			log.Printf("Failed to finish the recording: %v", err)
+		}
+	}, nil
+}
+
+// loadRecordings reads a recorded session
+func loadRecordings(path string) ([]Recording, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var recordings []Recording
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var rec Recording
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return nil, fmt.Errorf("%s line %d: %v", path, line, err)
+		}
+		recordings = append(recordings, rec)
+	}
+	return recordings, scanner.Err()
+}
+
+// defaultReplayIgnore are response fields that differ from one run to the
+// next, left out when comparing bodies
+const defaultReplayIgnore = "CreatedAt,UpdatedAt,EditedAt,ReadAt,created_at,updated_at,edited_at,read_at,expires_at,token"
+
+// Replayer sends a recorded session to another server. Registering and
+// logging in there gives other user IDs and session tokens than in the
+// recording, so it swaps them in for the recorded ones as it goes.
+type Replayer struct {
+	httpClient *http.Client
+	bodies     bool
+	ignore     map[string]bool
+	tokens     map[string]string // recorded session token to replayed one
+	userIDs    map[string]string
+	elapsed    time.Duration
+}
+
+func NewReplayer(bodies bool, ignore []string) *Replayer {
+	r := &Replayer{
+		httpClient: &http.Client{},
+		bodies:     bodies,
+		ignore:     make(map[string]bool),
+		tokens:     make(map[string]string),
+		userIDs:    make(map[string]string),
+	}
+	for _, field := range ignore {
+		if field = strings.TrimSpace(field); field != "" {
+			r.ignore[field] = true
+		}
+	}
+	return r
+}
+
+// Replay sends a recorded request and returns how its outcome differs from
+// the recorded one, or "" if it doesn't
+func (r *Replayer) Replay(rec Recording) (string, error) {
+	var body io.Reader
+	if len(rec.Body) > 0 {
+		body = bytes.NewReader(rec.Body)
+	}
+	req, err := http.NewRequest(rec.Method, serverURL+rec.Path, body)
+	if err != nil {
+		return "", err
+	}
+	if token := strings.TrimPrefix(rec.Headers["Authorization"], "Bearer "); token != "" {
+		if replayed, ok := r.tokens[token]; ok {
+			token = replayed
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	if userID := rec.Headers["X-User-ID"]; userID != "" {
+		if replayed, ok := r.userIDs[userID]; ok {
+			userID = replayed
+		}
+		req.Header.Set("X-User-ID", userID)
+	}
+	if len(rec.Headers) > 0 {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	start := time.Now()
+	resp, err := r.httpClient.Do(req)
+	r.elapsed += time.Since(start)
+	if err != nil {
+		if rec.Error != "" {
+			return "", nil
+		}
+		return fmt.Sprintf("request failed: %v", err), nil
+	}
+	data, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return fmt.Sprintf("failed to read the response: %v", err), nil
+	}
+
+	var recorded, replayed interface{}
+	json.Unmarshal(rec.Response, &recorded)
+	json.Unmarshal(data, &replayed)
+	r.learnIdentity(rec.Path, recorded, replayed)
+
+	if resp.StatusCode != rec.Status {
+		return fmt.Sprintf("status %d, recorded %d", resp.StatusCode, rec.Status), nil
+	}
+	if r.bodies && !reflect.DeepEqual(r.strip(recorded), r.strip(replayed)) {
+		return fmt.Sprintf("response differs: %s, recorded %s", bytes.TrimSpace(data), rec.Response), nil
+	}
+	return "", nil
+}
+
+// learnIdentity maps the user ID and session token a register or login
+// response gave in the recording to the ones it gave in the replay
+func (r *Replayer) learnIdentity(path string, recorded, replayed interface{}) {
+	if path != "/register" && path != "/login" {
+		return
+	}
+	old, ok1 := recorded.(map[string]interface{})
+	now, ok2 := replayed.(map[string]interface{})
+	if !ok1 || !ok2 {
+		return
+	}
+	if old["user_id"] != nil && now["user_id"] != nil {
+		r.userIDs[fmt.Sprintf("%v", old["user_id"])] = fmt.Sprintf("%v", now["user_id"])
+	}
+	if oldToken, ok := old["token"].(string); ok {
+		if token, ok := now["token"].(string); ok {
+			r.tokens[oldToken] = token
+		}
+	}
+}
+
+// strip drops the ignored fields from a decoded JSON value
+func (r *Replayer) strip(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		stripped := make(map[string]interface{}, len(value))
+		for key, field := range value {
+			if !r.ignore[key] {
+				stripped[key] = r.strip(field)
+			}
+		}
+		return stripped
+	case []interface{}:
+		stripped := make([]interface{}, len(value))
+		for i, item := range value {
+			stripped[i] = r.strip(item)
+		}
+		return stripped
+	}
+	return value
+}
+
+// runReplay runs the replay subcommand, which sends a recorded session to
+// a server and reports where its responses differ from the recorded ones,
+// and returns the exit code
+func runReplay(args []string) int {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	flags.StringVar(&serverURL, "server", defaultServerURL, "URL of the server to replay the session against")
+	configPath := flags.String("config", defaultConfigPath(), "client config file with the server profiles")
+	profile := flags.String("profile", "", "replay against the server of this profile from the config file")
+	bodies := flags.Bool("bodies", false, "compare response bodies as well as statuses")
+	ignore := flags.String("ignore", defaultReplayIgnore, "comma-separated response fields left out when comparing bodies")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: goreddit-client replay [flags] recording.jsonl")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	if err := applyProfile(flags, *configPath, *profile); err != nil {
+		log.Printf("Invalid profile: %v", err)
+		return 2
+	}
+	recordings, err := loadRecordings(flags.Arg(0))
+	if err != nil {
+		log.Printf("Invalid recording: %v", err)
+		return 2
+	}
+
+	replayer := NewReplayer(*bodies, strings.Split(*ignore, ","))
+	differences := 0
+	var recorded float64
+	for i, rec := range recordings {
+		recorded += rec.DurationMs
+		diff, err := replayer.Replay(rec)
+		if err != nil {
+			log.Printf("Replay failed at call %d, %s %s: %v", i+1, rec.Method, rec.Path, err)
+			return 1
+		}
+		if diff != "" {
+			differences++
+			log.Printf("#%d %s %s: %s", i+1, rec.Method, rec.Path, diff)
+		}
+	}
+
+	log.Printf("Replayed %d calls against %s: %d differed. Requests took %v, recorded %v",
+		len(recordings), serverURL, differences, replayer.elapsed.Round(time.Millisecond), formatMillis(recorded))
+	if differences > 0 {
+		return 1
+	}
+	return 0
+}
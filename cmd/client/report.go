@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// RunReport is the outcome of a scenario run, printed as tables at the end
+// and optionally written as JSON or CSV for comparing runs
+type RunReport struct {
+	Scenario        string           `json:"scenario"`
+	Seed            int64            `json:"seed"`
+	DurationSeconds float64          `json:"duration_seconds"`
+	Count           int              `json:"count"` // actions run
+	Errors          int              `json:"errors"`
+	Skipped         int              `json:"skipped"`
+	Throughput      float64          `json:"throughput"` // actions per second
+	Actions         []ActionReport   `json:"actions"`
+	Endpoints       []EndpointReport `json:"endpoints"`
+	SLOViolations   []string         `json:"slo_violations"`
+}
+
+// ActionReport counts the outcomes of one action
+type ActionReport struct {
+	Action  string `json:"action"`
+	Count   int    `json:"count"`
+	Errors  int    `json:"errors"`
+	Skipped int    `json:"skipped"`
+}
+
+// EndpointReport is the traffic and latency of one endpoint, with latencies
+// in milliseconds
+type EndpointReport struct {
+	Endpoint   string  `json:"endpoint"`
+	Requests   int     `json:"requests"`
+	Errors     int     `json:"errors"`
+	ErrorRate  float64 `json:"error_rate"` // from 0 to 1
+	Throughput float64 `json:"throughput"` // requests per second
+	P50        float64 `json:"p50_ms"`
+	P95        float64 `json:"p95_ms"`
+	P99        float64 `json:"p99_ms"`
+	Max        float64 `json:"max_ms"`
+}
+
+// milliseconds is a latency in fractional milliseconds, to the microsecond
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// buildReport gathers the run's statistics into a RunReport
+func (r *ScenarioRunner) buildReport(elapsed time.Duration) *RunReport {
+	report := &RunReport{
+		Scenario:        r.scenario.Name,
+		Seed:            r.scenario.Seed,
+		DurationSeconds: elapsed.Seconds(),
+		Actions:         []ActionReport{},
+		Endpoints:       []EndpointReport{},
+		SLOViolations:   []string{},
+	}
+
+	r.mu.Lock()
+	names := make([]string, 0, len(r.stats))
+	for name := range r.stats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		stats := r.stats[name]
+		report.Actions = append(report.Actions, ActionReport{name, stats.Count, stats.Errors, stats.Skipped})
+		report.Count += stats.Count
+		report.Errors += stats.Errors
+		report.Skipped += stats.Skipped
+	}
+	r.mu.Unlock()
+	report.Throughput = float64(report.Count) / elapsed.Seconds()
+
+	r.latencies.mu.Lock()
+	defer r.latencies.mu.Unlock()
+	for _, name := range r.latencies.names() {
+		stats := r.latencies.endpoints[name]
+		latency := stats.latency
+		endpoint := EndpointReport{
+			Endpoint:   name,
+			Requests:   latency.total,
+			Errors:     stats.errors,
+			Throughput: float64(latency.total) / elapsed.Seconds(),
+			P50:        milliseconds(latency.Percentile(0.50)),
+			P95:        milliseconds(latency.Percentile(0.95)),
+			P99:        milliseconds(latency.Percentile(0.99)),
+			Max:        milliseconds(latency.max),
+		}
+		if latency.total > 0 {
+			endpoint.ErrorRate = float64(stats.errors) / float64(latency.total)
+		}
+		report.Endpoints = append(report.Endpoints, endpoint)
+	}
+	return report
+}
+
+// report prints per-action counts and per-endpoint latencies, error rates
+// and throughput, and returns the total number of errors
+func (r *ScenarioRunner) report(elapsed time.Duration) int {
+	report := r.buildReport(elapsed)
+	r.result = report
+
+	fmt.Printf("\nScenario %q (seed %d) finished in %v\n", report.Scenario, report.Seed, elapsed.Round(time.Millisecond))
+	fmt.Printf("%-18s %8s %8s %8s\n", "ACTION", "COUNT", "ERRORS", "SKIPPED")
+	for _, action := range report.Actions {
+		fmt.Printf("%-18s %8d %8d %8d\n", action.Action, action.Count, action.Errors, action.Skipped)
+	}
+	fmt.Printf("%-18s %8d %8d %8d (%.1f req/s)\n", "total", report.Count, report.Errors, report.Skipped, report.Throughput)
+
+	fmt.Printf("\n%-36s %8s %8s %7s %8s %10s %10s %10s %10s\n", "ENDPOINT", "COUNT", "ERRORS", "ERR%", "REQ/S", "P50", "P95", "P99", "MAX")
+	for _, endpoint := range report.Endpoints {
+		fmt.Printf("%-36s %8d %8d %6.1f%% %8.1f %10s %10s %10s %10s\n", endpoint.Endpoint, endpoint.Requests, endpoint.Errors,
+			endpoint.ErrorRate*100, endpoint.Throughput,
+			formatMillis(endpoint.P50), formatMillis(endpoint.P95), formatMillis(endpoint.P99), formatMillis(endpoint.Max))
+	}
+
+	r.breaker.report()
+	return report.Errors
+}
+
+// formatMillis prints a latency in milliseconds as a duration
+func formatMillis(ms float64) string {
+	return (time.Duration(ms*1000) * time.Microsecond).String()
+}
+
+// WriteReports writes the report of the finished run, with the SLO
+// violations found, as JSON and CSV to the paths that aren't empty
+func (r *ScenarioRunner) WriteReports(violations []string, jsonPath, csvPath string) error {
+	if r.result == nil {
+		return fmt.Errorf("the run hasn't finished")
+	}
+	if violations != nil {
+		r.result.SLOViolations = violations
+	}
+
+	if jsonPath != "" {
+		data, err := json.MarshalIndent(r.result, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(jsonPath, append(data, '\n'), 0644); err != nil {
+			return fmt.Errorf("failed to write JSON report: %v", err)
+		}
+	}
+
+	if csvPath != "" {
+		if err := writeCSVReport(csvPath, r.result); err != nil {
+			return fmt.Errorf("failed to write CSV report: %v", err)
+		}
+	}
+	return nil
+}
+
+// writeCSVReport writes one row per endpoint, then a total row of all their
+// requests
+func writeCSVReport(path string, report *RunReport) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	w.Write([]string{"endpoint", "requests", "errors", "error_rate", "throughput", "p50_ms", "p95_ms", "p99_ms", "max_ms"})
+	float := func(v float64) string { return strconv.FormatFloat(v, 'f', 3, 64) }
+	requests, errors, throughput := 0, 0, 0.0
+	for _, e := range report.Endpoints {
+		w.Write([]string{e.Endpoint, strconv.Itoa(e.Requests), strconv.Itoa(e.Errors), float(e.ErrorRate),
+			float(e.Throughput), float(e.P50), float(e.P95), float(e.P99), float(e.Max)})
+		requests += e.Requests
+		errors += e.Errors
+		throughput += e.Throughput
+	}
+	errorRate := 0.0
+	if requests > 0 {
+		errorRate = float64(errors) / float64(requests)
+	}
+	w.Write([]string{"total", strconv.Itoa(requests), strconv.Itoa(errors), float(errorRate), float(throughput), "", "", "", ""})
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,735 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+
+	goreddit "github.com/ArjunKaliyath/GoReddit/pkg/client"
+)
+
+// fetchConversation gets the messages exchanged with a user, oldest first,
+// and marks them read
+func (c *Client) fetchConversation(userID int) ([]goreddit.DirectMessage, error) {
+	ctx := context.Background()
+	messages, err := c.sdk().Messages(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch conversation: %v", err)
+	}
+	if err := c.sdk().MarkConversationRead(ctx, userID); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// sendDirectMessage sends a direct message to a user
+func (c *Client) sendDirectMessage(toUserID int, content string) error {
+	_, err := c.sdk().SendMessage(context.Background(), goreddit.SendMessageRequest{ToUserID: toUserID, Content: content})
+	if err != nil {
+		return fmt.Errorf("message sending failed: %v", err)
+	}
+	return nil
+}
+
+// tuiPane is the pane of the full-screen client that has the keyboard
+type tuiPane int
+
+const (
+	feedPane tuiPane = iota
+	postPane
+	inboxPane
+	conversationPane
+)
+
+// Results of the full-screen client's requests, which run in the background
+type (
+	feedMsg struct {
+		offset  int
+		posts   []goreddit.Post
+		hasNext bool
+		err     error
+	}
+	threadMsg struct {
+		postID   int
+		comments []threadComment
+		err      error
+	}
+	inboxMsg struct {
+		conversations []goreddit.Conversation
+		err           error
+	}
+	conversationMsg struct {
+		userID   int
+		messages []goreddit.DirectMessage
+		err      error
+	}
+	// doneMsg is the outcome of a vote, comment or message, with the
+	// request that refreshes what it changed
+	doneMsg struct {
+		status  string
+		err     error
+		refresh tea.Cmd
+	}
+)
+
+var (
+	tuiTitleStyle    = lipgloss.NewStyle().Bold(true)
+	tuiFaintStyle    = lipgloss.NewStyle().Faint(true)
+	tuiSelectedStyle = lipgloss.NewStyle().Reverse(true)
+	tuiErrorStyle    = lipgloss.NewStyle().Foreground(lipgloss.Color("9"))
+	tuiPaneStyle     = lipgloss.NewStyle().Border(lipgloss.RoundedBorder()).Padding(0, 1)
+	tuiFocusColor    = lipgloss.Color("12")
+)
+
+// tuiModel is the full-screen client: the feed beside the open post and its
+// comments, or the inbox beside the open conversation
+type tuiModel struct {
+	client        *Client
+	width, height int
+	pane          tuiPane
+
+	sortIndex int
+	offset    int
+	posts     []goreddit.Post
+	hasNext   bool
+	selected  int
+
+	post        *goreddit.Post
+	commentSort int
+	comments    []threadComment // the open post's thread, flattened
+	comment     int
+
+	conversations []goreddit.Conversation
+	conversation  int
+	peer          *goreddit.Conversation
+	messages      []goreddit.DirectMessage
+
+	// compose sends what's typed in input, while the user is writing
+	input   textinput.Model
+	compose func(content string) tea.Cmd
+
+	status string
+	failed bool
+}
+
+func newTUIModel(client *Client) tuiModel {
+	input := textinput.New()
+	input.CharLimit = 10000
+	return tuiModel{client: client, input: input}
+}
+
+// runTUI runs the full-screen client until the user quits
+func runTUI(client *Client) error {
+	_, err := tea.NewProgram(newTUIModel(client), tea.WithAltScreen()).Run()
+	return err
+}
+
+func (m tuiModel) Init() tea.Cmd {
+	return m.loadFeed()
+}
+
+func (m tuiModel) loadFeed() tea.Cmd {
+	client, sortBy, offset := m.client, feedSorts[m.sortIndex], m.offset
+	return func() tea.Msg {
+		// One post more than a page tells whether there is a next page
+		posts, err := client.fetchFeedPage(sortBy, feedPageSize+1, offset)
+		hasNext := len(posts) > feedPageSize
+		if hasNext {
+			posts = posts[:feedPageSize]
+		}
+		return feedMsg{offset, posts, hasNext, err}
+	}
+}
+
+func (m tuiModel) loadThread() tea.Cmd {
+	if m.post == nil {
+		return nil
+	}
+	client, postID, sortBy := m.client, m.post.ID, commentSorts[m.commentSort]
+	return func() tea.Msg {
+		comments, err := client.fetchThread(postID, sortBy)
+		return threadMsg{postID, flattenThread(comments), err}
+	}
+}
+
+func (m tuiModel) loadInbox() tea.Cmd {
+	client := m.client
+	return func() tea.Msg {
+		conversations, err := client.sdk().Conversations(context.Background())
+		return inboxMsg{conversations, err}
+	}
+}
+
+func (m tuiModel) loadConversation() tea.Cmd {
+	if m.peer == nil {
+		return nil
+	}
+	client, userID := m.client, m.peer.UserID
+	return func() tea.Msg {
+		messages, err := client.fetchConversation(userID)
+		return conversationMsg{userID, messages, err}
+	}
+}
+
+// action runs a request that changes something, then refresh if it worked
+func action(status string, refresh tea.Cmd, do func() error) tea.Cmd {
+	return func() tea.Msg {
+		return doneMsg{status, do(), refresh}
+	}
+}
+
+// flattenThread lists a comment tree depth first, as it's displayed
+func flattenThread(comments []threadComment) []threadComment {
+	var flat []threadComment
+	for _, comment := range comments {
+		flat = append(flat, comment)
+		flat = append(flat, flattenThread(comment.Replies)...)
+	}
+	return flat
+}
+
+func (m *tuiModel) setStatus(status string, err error) {
+	m.status, m.failed = status, err != nil
+	if err != nil {
+		m.status = fmt.Sprintf("Error: %v", err)
+	}
+}
+
+// startCompose asks for text, which send sends once the user presses enter
+func (m *tuiModel) startCompose(label string, send func(content string) tea.Cmd) tea.Cmd {
+	m.compose = send
+	m.input.Placeholder = label
+	m.input.Reset()
+	m.setStatus("", nil)
+	return m.input.Focus()
+}
+
+func (m tuiModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.width, m.height = msg.Width, msg.Height
+		m.input.Width = msg.Width - 4
+		return m, nil
+
+	case feedMsg:
+		if msg.err != nil {
+			m.setStatus("", msg.err)
+			return m, nil
+		}
+		if msg.offset != m.offset {
+			return m, nil // a page the user has already moved on from
+		}
+		m.posts, m.hasNext = msg.posts, msg.hasNext
+		m.selected = clampIndex(m.selected, len(m.posts))
+		return m, nil
+
+	case threadMsg:
+		if msg.err != nil {
+			m.setStatus("", msg.err)
+			return m, nil
+		}
+		if m.post == nil || msg.postID != m.post.ID {
+			return m, nil
+		}
+		m.comments = msg.comments
+		m.comment = clampIndex(m.comment, len(m.comments))
+		return m, nil
+
+	case inboxMsg:
+		if msg.err != nil {
+			m.setStatus("", msg.err)
+			return m, nil
+		}
+		m.conversations = msg.conversations
+		m.conversation = clampIndex(m.conversation, len(m.conversations))
+		return m, nil
+
+	case conversationMsg:
+		if msg.err != nil {
+			m.setStatus("", msg.err)
+			return m, nil
+		}
+		if m.peer == nil || msg.userID != m.peer.UserID {
+			return m, nil
+		}
+		m.messages = msg.messages
+		for i := range m.conversations {
+			if m.conversations[i].UserID == msg.userID {
+				m.conversations[i].UnreadCount = 0
+			}
+		}
+		return m, nil
+
+	case doneMsg:
+		m.setStatus(msg.status, msg.err)
+		if msg.err != nil {
+			return m, nil
+		}
+		return m, msg.refresh
+
+	case tea.KeyMsg:
+		return m.handleKey(msg)
+	}
+
+	if m.compose != nil {
+		var cmd tea.Cmd
+		m.input, cmd = m.input.Update(msg)
+		return m, cmd
+	}
+	return m, nil
+}
+
+// clampIndex keeps a selection within a list of n items
+func clampIndex(i, n int) int {
+	if i >= n {
+		i = n - 1
+	}
+	if i < 0 {
+		i = 0
+	}
+	return i
+}
+
+func (m tuiModel) handleKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	key := msg.String()
+	if key == "ctrl+c" {
+		return m, tea.Quit
+	}
+
+	if m.compose != nil {
+		switch key {
+		case "esc":
+			m.compose = nil
+			m.input.Blur()
+			return m, nil
+		case "enter":
+			send, content := m.compose, strings.TrimSpace(m.input.Value())
+			m.compose = nil
+			m.input.Blur()
+			if content == "" {
+				return m, nil
+			}
+			m.setStatus("Sending...", nil)
+			return m, send(content)
+		}
+		var cmd tea.Cmd
+		m.input, cmd = m.input.Update(msg)
+		return m, cmd
+	}
+
+	switch key {
+	case "q":
+		return m, tea.Quit
+	case "tab":
+		switch {
+		case m.pane == feedPane && m.post != nil:
+			m.pane = postPane
+		case m.pane == postPane:
+			m.pane = feedPane
+		case m.pane == inboxPane && m.peer != nil:
+			m.pane = conversationPane
+		case m.pane == conversationPane:
+			m.pane = inboxPane
+		}
+		return m, nil
+	case "f":
+		if m.pane == inboxPane || m.pane == conversationPane {
+			m.pane = feedPane
+			return m, m.loadFeed()
+		}
+	case "i":
+		if m.pane == feedPane || m.pane == postPane {
+			m.pane = inboxPane
+			return m, m.loadInbox()
+		}
+	}
+
+	switch m.pane {
+	case feedPane:
+		return m.feedKey(key)
+	case postPane:
+		return m.postKey(key)
+	case inboxPane:
+		return m.inboxKey(key)
+	default:
+		return m.conversationKey(key)
+	}
+}
+
+func (m tuiModel) feedKey(key string) (tea.Model, tea.Cmd) {
+	switch key {
+	case "up", "k":
+		m.selected = clampIndex(m.selected-1, len(m.posts))
+	case "down", "j":
+		m.selected = clampIndex(m.selected+1, len(m.posts))
+	case "right", "n":
+		if m.hasNext {
+			m.offset += feedPageSize
+			m.selected = 0
+			return m, m.loadFeed()
+		}
+	case "left", "p":
+		if m.offset > 0 {
+			m.offset = clampIndex(m.offset-feedPageSize, m.offset)
+			m.selected = 0
+			return m, m.loadFeed()
+		}
+	case "s":
+		m.sortIndex = (m.sortIndex + 1) % len(feedSorts)
+		m.offset, m.selected = 0, 0
+		return m, m.loadFeed()
+	case "r":
+		return m, m.loadFeed()
+	case "u", "d":
+		if len(m.posts) == 0 {
+			return m, nil
+		}
+		client, postID, value := m.client, m.posts[m.selected].ID, 1
+		if key == "d" {
+			value = -1
+		}
+		return m, action("Vote recorded", m.loadFeed(), func() error {
+			return client.vote("post", postID, value)
+		})
+	case "enter":
+		if len(m.posts) == 0 {
+			return m, nil
+		}
+		post := m.posts[m.selected]
+		m.post, m.comments, m.comment = &post, nil, 0
+		m.pane = postPane
+		return m, m.loadThread()
+	}
+	return m, nil
+}
+
+func (m tuiModel) postKey(key string) (tea.Model, tea.Cmd) {
+	client, postID := m.client, m.post.ID
+	switch key {
+	case "up", "k":
+		m.comment = clampIndex(m.comment-1, len(m.comments))
+	case "down", "j":
+		m.comment = clampIndex(m.comment+1, len(m.comments))
+	case "o":
+		m.commentSort = (m.commentSort + 1) % len(commentSorts)
+		return m, m.loadThread()
+	case "r":
+		return m, m.loadThread()
+	case "c":
+		refresh := m.loadThread()
+		return m, m.startCompose("Comment on the post", func(content string) tea.Cmd {
+			return action("Comment posted", refresh, func() error {
+				_, err := client.createComment(postID, nil, content)
+				return err
+			})
+		})
+	case "enter":
+		if len(m.comments) == 0 {
+			return m, nil
+		}
+		refresh, parent := m.loadThread(), m.comments[m.comment]
+		return m, m.startCompose("Reply to "+parent.Author, func(content string) tea.Cmd {
+			return action("Reply posted", refresh, func() error {
+				_, err := client.createComment(postID, &parent.ID, content)
+				return err
+			})
+		})
+	case "u", "d":
+		if len(m.comments) == 0 {
+			return m, nil
+		}
+		commentID, value := m.comments[m.comment].ID, 1
+		if key == "d" {
+			value = -1
+		}
+		return m, action("Vote recorded", m.loadThread(), func() error {
+			return client.vote("comment", commentID, value)
+		})
+	case "esc":
+		m.pane = feedPane
+	}
+	return m, nil
+}
+
+func (m tuiModel) inboxKey(key string) (tea.Model, tea.Cmd) {
+	switch key {
+	case "up", "k":
+		m.conversation = clampIndex(m.conversation-1, len(m.conversations))
+	case "down", "j":
+		m.conversation = clampIndex(m.conversation+1, len(m.conversations))
+	case "r":
+		return m, m.loadInbox()
+	case "enter":
+		if len(m.conversations) == 0 {
+			return m, nil
+		}
+		peer := m.conversations[m.conversation]
+		m.peer, m.messages = &peer, nil
+		m.pane = conversationPane
+		return m, m.loadConversation()
+	}
+	return m, nil
+}
+
+func (m tuiModel) conversationKey(key string) (tea.Model, tea.Cmd) {
+	switch key {
+	case "r":
+		return m, m.loadConversation()
+	case "enter", "m":
+		client, userID := m.client, m.peer.UserID
+		refresh := m.loadConversation()
+		return m, m.startCompose("Message "+m.peer.Username, func(content string) tea.Cmd {
+			return action("Message sent", refresh, func() error {
+				return client.sendDirectMessage(userID, content)
+			})
+		})
+	case "esc":
+		m.pane = inboxPane
+		return m, m.loadInbox()
+	}
+	return m, nil
+}
+
+// tuiLine is a line of a pane, highlighted when it's part of the selection
+type tuiLine struct {
+	text     string
+	selected bool
+}
+
+func (m tuiModel) View() string {
+	if m.width == 0 {
+		return "Loading..."
+	}
+
+	header := m.header()
+	footer := m.footer()
+	height := m.height - lipgloss.Height(header) - lipgloss.Height(footer)
+	leftWidth := m.width * 2 / 5
+	rightWidth := m.width - leftWidth
+
+	var left, right string
+	if m.pane == inboxPane || m.pane == conversationPane {
+		lines, anchor := m.inboxLines()
+		left = renderPane("Inbox", m.pane == inboxPane, leftWidth, height, lines, anchor)
+		title := "Conversation"
+		if m.peer != nil {
+			title = "Conversation with " + m.peer.Username
+		}
+		lines, anchor = m.conversationLines(rightWidth - 4)
+		right = renderPane(title, m.pane == conversationPane, rightWidth, height, lines, anchor)
+	} else {
+		title := fmt.Sprintf("Feed (%s), page %d", feedSorts[m.sortIndex], m.offset/feedPageSize+1)
+		lines, anchor := m.feedLines()
+		left = renderPane(title, m.pane == feedPane, leftWidth, height, lines, anchor)
+		lines, anchor = m.postLines(rightWidth - 4)
+		right = renderPane("Post", m.pane == postPane, rightWidth, height, lines, anchor)
+	}
+	return lipgloss.JoinVertical(lipgloss.Left, header, lipgloss.JoinHorizontal(lipgloss.Top, left, right), footer)
+}
+
+func (m tuiModel) header() string {
+	feed, inbox := " Feed ", " Inbox "
+	if m.pane == inboxPane || m.pane == conversationPane {
+		inbox = tuiSelectedStyle.Render(inbox)
+	} else {
+		feed = tuiSelectedStyle.Render(feed)
+	}
+	return tuiTitleStyle.Render("GoReddit") + " " + feed + inbox + tuiFaintStyle.Render("  signed in as "+m.client.username)
+}
+
+func (m tuiModel) footer() string {
+	status := m.status
+	if m.failed {
+		status = tuiErrorStyle.Render(status)
+	}
+	if m.compose != nil {
+		return m.input.View() + "\n" + tuiFaintStyle.Render("enter send • esc cancel")
+	}
+
+	var help string
+	switch m.pane {
+	case feedPane:
+		help = "↑/↓ select • enter open • n/p next/prev page • s sort • u/d vote • r refresh • i inbox • q quit"
+	case postPane:
+		help = "↑/↓ select • c comment • enter reply • u/d vote • o sort • r refresh • esc back • i inbox • q quit"
+	case inboxPane:
+		help = "↑/↓ select • enter open • r refresh • f feed • q quit"
+	default:
+		help = "m reply • r refresh • esc back • f feed • q quit"
+	}
+	return status + "\n" + tuiFaintStyle.Render(help)
+}
+
+// renderPane draws a bordered pane of lines, scrolled so that the line at
+// anchor is in view
+func renderPane(title string, focused bool, width, height int, lines []tuiLine, anchor int) string {
+	style := tuiPaneStyle.Width(width - 2).Height(height - 2)
+	if focused {
+		style = style.BorderForeground(tuiFocusColor)
+	}
+	innerWidth, innerHeight := width-4, height-3 // the title takes a line
+	if innerWidth < 1 || innerHeight < 1 {
+		return ""
+	}
+
+	start := 0
+	if anchor >= innerHeight {
+		start = anchor - innerHeight + 1
+	}
+	end := start + innerHeight
+	if end > len(lines) {
+		end = len(lines)
+	}
+
+	out := []string{tuiTitleStyle.Render(truncate(title, innerWidth))}
+	for _, line := range lines[start:end] {
+		text := truncate(line.text, innerWidth)
+		if line.selected {
+			text = tuiSelectedStyle.Render(text)
+		}
+		out = append(out, text)
+	}
+	return style.Render(strings.Join(out, "\n"))
+}
+
+func (m tuiModel) feedLines() ([]tuiLine, int) {
+	if len(m.posts) == 0 {
+		return []tuiLine{{text: "No posts."}}, 0
+	}
+	var lines []tuiLine
+	anchor := 0
+	for i, post := range m.posts {
+		selected := i == m.selected
+		if selected {
+			anchor = len(lines) + 1
+		}
+		lines = append(lines,
+			tuiLine{fmt.Sprintf("%d. %s", m.offset+i+1, post.Title), selected},
+			tuiLine{fmt.Sprintf("   %s in r/%s • %d points • %d comments", post.AuthorName, post.SubredditName, post.Score(), post.CommentCount), selected})
+	}
+	return lines, anchor
+}
+
+func (m tuiModel) postLines(width int) ([]tuiLine, int) {
+	if m.post == nil {
+		return []tuiLine{{text: "Open a post from the feed."}}, 0
+	}
+	post := m.post
+	lines := []tuiLine{
+		{text: post.Title},
+		{text: fmt.Sprintf("by %s in r/%s • %d upvotes, %d downvotes", post.AuthorName, post.SubredditName,
+			post.VoteCount.Upvotes, post.VoteCount.Downvotes)},
+		{},
+	}
+	for _, line := range wrapText(post.Content, width) {
+		lines = append(lines, tuiLine{text: line})
+	}
+	lines = append(lines, tuiLine{}, tuiLine{text: fmt.Sprintf("Comments (%s)", commentSorts[m.commentSort])})
+	if len(m.comments) == 0 {
+		return append(lines, tuiLine{text: "No comments yet."}), 0
+	}
+
+	anchor := 0
+	for i, comment := range m.comments {
+		selected := i == m.comment
+		indent := strings.Repeat("  ", comment.Depth)
+		lines = append(lines, tuiLine{fmt.Sprintf("%s%s • %d points", indent, comment.Author, comment.Score), selected})
+		for _, line := range wrapText(comment.Body, width-len(indent)-2) {
+			lines = append(lines, tuiLine{indent + "  " + line, selected})
+		}
+		if selected {
+			anchor = len(lines) - 1
+		}
+	}
+	return lines, anchor
+}
+
+func (m tuiModel) inboxLines() ([]tuiLine, int) {
+	if len(m.conversations) == 0 {
+		return []tuiLine{{text: "No messages yet."}}, 0
+	}
+	var lines []tuiLine
+	anchor := 0
+	for i, conv := range m.conversations {
+		selected := i == m.conversation
+		if selected {
+			anchor = len(lines) + 1
+		}
+		name := conv.Username
+		if conv.UnreadCount > 0 {
+			name = fmt.Sprintf("%s (%d unread)", name, conv.UnreadCount)
+		}
+		lines = append(lines,
+			tuiLine{name, selected},
+			tuiLine{fmt.Sprintf("   %s: %s", conv.LatestMessage.FromUsername, conv.LatestMessage.Content), selected})
+	}
+	return lines, anchor
+}
+
+// conversationLines lists the open conversation, scrolled to the latest
+// message
+func (m tuiModel) conversationLines(width int) ([]tuiLine, int) {
+	if m.peer == nil {
+		return []tuiLine{{text: "Open a conversation from the inbox."}}, 0
+	}
+	if len(m.messages) == 0 {
+		return []tuiLine{{text: "No messages yet."}}, 0
+	}
+	var lines []tuiLine
+	for _, msg := range m.messages {
+		lines = append(lines, tuiLine{text: fmt.Sprintf("%s • %s", msg.FromUsername, msg.CreatedAt.Local().Format("Jan 2 15:04"))})
+		for _, line := range wrapText(msg.Content, width-2) {
+			lines = append(lines, tuiLine{text: "  " + line})
+		}
+	}
+	return lines, len(lines) - 1
+}
+
+// wrapText breaks text into lines of at most width characters, between
+// words where it can
+func wrapText(text string, width int) []string {
+	if width < 1 {
+		width = 1
+	}
+	var lines []string
+	for _, paragraph := range strings.Split(text, "\n") {
+		line := ""
+		for _, word := range strings.Fields(paragraph) {
+			for len([]rune(word)) > width {
+				if line != "" {
+					lines = append(lines, line)
+					line = ""
+				}
+				lines = append(lines, string([]rune(word)[:width]))
+				word = string([]rune(word)[width:])
+			}
+			switch {
+			case line == "":
+				line = word
+			case len([]rune(line))+1+len([]rune(word)) <= width:
+				line += " " + word
+			default:
+				lines = append(lines, line)
+				line = word
+			}
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// truncate shortens s to at most width characters
+func truncate(s string, width int) string {
+	runes := []rune(s)
+	if len(runes) <= width {
+		return s
+	}
+	if width <= 1 {
+		return string(runes[:width])
+	}
+	return string(runes[:width-1]) + "…"
+}
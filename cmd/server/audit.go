@@ -0,0 +1,213 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Admin audit log
+//
+// Privileged actions are appended to admin_audit_log with who took them, on
+// what, when and why. Entries are never updated or deleted, and the audit log
+// survives resetting the database. The admin routes that change anything, and
+// looking up individual votes, are recorded by the auditAdminActions
+// middleware once they succeed; resetting the database, impersonating a user
+// and deleting or restoring content record themselves, in the same
+// transaction as the action. A reason can be given to any of them in the
+// X-Audit-Reason header.
+
+const adminAuditReasonHeader = "X-Audit-Reason"
+
+// adminAuditedRoute is how a successful request to an admin route is recorded
+type adminAuditedRoute struct {
+	action     string
+	targetType string
+}
+
+// adminAuditedRoutes are the admin routes auditAdminActions records
+var adminAuditedRoutes = map[string]adminAuditedRoute{
+	"POST /admin/maintenance":      {"run_maintenance", "database"},
+	"POST /admin/repair-comments":  {"repair_comments", "database"},
+	"POST /admin/votes/bulk":       {"bulk_votes", "database"},
+	"GET /admin/votes":             {"view_votes", "votes"},
+	"POST /admin/import":           {"import_bundle", "database"},
+	"POST /admin/standby/snapshot": {"standby_snapshot", "database"},
+	"POST /admin/jobs/:name/run":   {"run_job", "job"},
+	"POST /admin/config/reload":    {"reload_config", "config"},
+}
+
+// auditAdminActions records the successful requests to adminAuditedRoutes.
+// The details are the route's parameters and query string.
+func (h *APIHandler) auditAdminActions() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		route, ok := adminAuditedRoutes[c.Request.Method+" "+c.FullPath()]
+		if !ok || len(c.Errors) > 0 || c.Writer.Status() >= http.StatusBadRequest {
+			return
+		}
+
+		values := c.Request.URL.Query()
+		for _, param := range c.Params {
+			values.Set(param.Key, param.Value)
+		}
+		details := values.Encode()
+
+		adminID, _ := strconv.Atoi(c.GetString("user_id"))
+		err := h.dbFor(c).LogAdminAction(adminID, route.action, route.targetType, nil, c.GetHeader(adminAuditReasonHeader), details)
+		if err != nil {
+			logAt(logError, "Failed to audit %s by admin %d: %v", route.action, adminID, err)
+		}
+	}
+}
+
+// AdminAuditEntry is a recorded privileged action
+type AdminAuditEntry struct {
+	ID         int       `json:"id"`
+	AdminID    int       `json:"admin_id"`
+	Admin      string    `json:"admin"` // empty once the user is gone
+	Action     string    `json:"action"`
+	TargetType string    `json:"target_type"`
+	TargetID   *int      `json:"target_id"`
+	Reason     string    `json:"reason,omitempty"`
+	Details    string    `json:"details,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// AdminAuditFilter narrows the audit log. Zero fields match everything.
+type AdminAuditFilter struct {
+	AdminID    int
+	Action     string
+	TargetType string
+	TargetID   *int
+	Since      *time.Time
+	Until      *time.Time
+}
+
+// GetAdminAuditLog lists the audit log entries matching a filter, newest
+// first
+func (dm *DatabaseManager) GetAdminAuditLog(filter AdminAuditFilter, limit, offset int) ([]AdminAuditEntry, error) {
+	defer dm.span("GetAdminAuditLog").End()
+	dm.mu.RLock()
+	defer dm.mu.RUnlock()
+
+	query := `
+		SELECT a.id, a.admin_id, COALESCE(u.username, ''), a.action, a.target_type, a.target_id,
+			   COALESCE(a.reason, ''), COALESCE(a.details, ''), a.created_at
+		FROM admin_audit_log a
+		LEFT JOIN users u ON a.admin_id = u.id
+		WHERE 1 = 1
+	`
+	var args []interface{}
+
+	if filter.AdminID != 0 {
+		query += ` AND a.admin_id = ?`
+		args = append(args, filter.AdminID)
+	}
+	if filter.Action != "" {
+		query += ` AND a.action = ?`
+		args = append(args, filter.Action)
+	}
+	if filter.TargetType != "" {
+		query += ` AND a.target_type = ?`
+		args = append(args, filter.TargetType)
+	}
+	if filter.TargetID != nil {
+		query += ` AND a.target_id = ?`
+		args = append(args, *filter.TargetID)
+	}
+	if filter.Since != nil {
+		query += ` AND a.created_at >= ?`
+		args = append(args, importTimestamp(filter.Since))
+	}
+	if filter.Until != nil {
+		query += ` AND a.created_at < ?`
+		args = append(args, importTimestamp(filter.Until))
+	}
+	query += ` ORDER BY a.id DESC LIMIT ? OFFSET ?`
+	args = append(args, limit, offset)
+
+	rows, err := dm.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get audit log: %v", err)
+	}
+	defer rows.Close()
+
+	entries := []AdminAuditEntry{}
+	for rows.Next() {
+		var entry AdminAuditEntry
+		var targetID sql.NullInt64
+		err := rows.Scan(&entry.ID, &entry.AdminID, &entry.Admin, &entry.Action, &entry.TargetType, &targetID,
+			&entry.Reason, &entry.Details, &entry.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		if targetID.Valid {
+			id := int(targetID.Int64)
+			entry.TargetID = &id
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// parseAuditTime reads a ?since= or ?until= bound, an RFC 3339 time or a
+// date
+func parseAuditTime(value string) (*time.Time, error) {
+	for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return &t, nil
+		}
+	}
+	return nil, fmt.Errorf("%q is not an RFC 3339 time or a date", value)
+}
+
+// getAdminAuditLog lists the audit log, newest first, filtered by
+// ?admin_id=, ?action=, ?target_type=, ?target_id=, ?since= and ?until=
+func (h *APIHandler) getAdminAuditLog(c *gin.Context) {
+	filter := AdminAuditFilter{Action: c.Query("action"), TargetType: c.Query("target_type")}
+	if value := c.Query("admin_id"); value != "" {
+		id, err := strconv.Atoi(value)
+		if err != nil {
+			c.Error(newAPIError(http.StatusBadRequest, "Invalid admin ID"))
+			return
+		}
+		filter.AdminID = id
+	}
+	if value := c.Query("target_id"); value != "" {
+		id, err := strconv.Atoi(value)
+		if err != nil {
+			c.Error(newAPIError(http.StatusBadRequest, "Invalid target ID"))
+			return
+		}
+		filter.TargetID = &id
+	}
+	for param, bound := range map[string]**time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if value := c.Query(param); value != "" {
+			t, err := parseAuditTime(value)
+			if err != nil {
+				c.Error(newAPIError(http.StatusBadRequest, param+": "+err.Error()))
+				return
+			}
+			*bound = t
+		}
+	}
+
+	limit, offset := parsePagination(c)
+	entries, err := h.dbFor(c).GetAdminAuditLog(filter, limit, offset)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"entries": entries,
+		"limit":   limit,
+		"offset":  offset,
+	})
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Awards
+//
+// Users can give posts and comments an award from a fixed set of types, with
+// an optional message. Each user can give a piece of content each type of
+// award once. Posts and comments carry the number of each award they've
+// received, the recipient is notified, and the awards a user has received
+// are listed on their profile.
+
+// AwardType is a kind of award
+type AwardType struct {
+	Name        string `json:"name"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+}
+
+// awardTypes are the awards that can be given, from least to most
+// prestigious
+var awardTypes = []AwardType{
+	{Name: "silver", Title: "Silver", Description: "Shine a little light on something worth reading"},
+	{Name: "gold", Title: "Gold", Description: "Recognize something exceptional"},
+	{Name: "platinum", Title: "Platinum", Description: "The highest honor there is"},
+	{Name: "helpful", Title: "Helpful", Description: "Thank someone for their help"},
+	{Name: "wholesome", Title: "Wholesome", Description: "For content that made your day better"},
+}
+
+// isAwardType reports whether name is one of awardTypes
+func isAwardType(name string) bool {
+	for _, t := range awardTypes {
+		if t.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// AwardCounts is the number of each type of award a post or comment has
+// received. It's read from a JSON array of award names.
+type AwardCounts map[string]int
+
+// Scan implements sql.Scanner
+func (a *AwardCounts) Scan(src interface{}) error {
+	var data []byte
+	switch v := src.(type) {
+	case string:
+		data = []byte(v)
+	case []byte:
+		data = v
+	case nil:
+	default:
+		return fmt.Errorf("cannot scan %T into AwardCounts", src)
+	}
+
+	var names []string
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &names); err != nil {
+			return err
+		}
+	}
+	counts := AwardCounts{}
+	for _, name := range names {
+		counts[name]++
+	}
+	*a = counts
+	return nil
+}
+
+var (
+	ErrAwardOwnContent   = errors.New("you can't award your own content")
+	ErrAwardAlreadyGiven = errors.New("you have already given this award here")
+)
+
+// GiveAward gives a post or comment an award and notifies its author,
+// returning the award's ID
+func (dm *DatabaseManager) GiveAward(giverID int, targetType string, targetID int, award, message string) (int, error) {
+	defer dm.span("GiveAward").End()
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	tx, err := dm.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+
+	var recipientID, postID int
+	query := `SELECT author_id, id FROM posts WHERE id = ? AND removed = 0 AND deleted_at IS NULL`
+	if targetType == "comment" {
+		query = `SELECT author_id, post_id FROM comments WHERE id = ? AND removed = 0 AND deleted_at IS NULL`
+	}
+	if err := tx.QueryRow(query, targetID).Scan(&recipientID, &postID); err != nil {
+		tx.Rollback()
+		return 0, fmt.Errorf("%s not found: %v", targetType, err)
+	}
+	if recipientID == giverID {
+		tx.Rollback()
+		return 0, ErrAwardOwnContent
+	}
+	if err := checkNotArchived(tx, targetID, targetType); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	result, err := tx.Exec(`
+		INSERT OR IGNORE INTO awards (award, giver_id, recipient_id, target_type, target_id, message)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, award, giverID, recipientID, targetType, targetID, message)
+	if err != nil {
+		tx.Rollback()
+		return 0, fmt.Errorf("failed to give award: %v", err)
+	}
+	if added, _ := result.RowsAffected(); added == 0 {
+		tx.Rollback()
+		return 0, ErrAwardAlreadyGiven
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	refs := notificationRefs{PostID: &postID}
+	if targetType == "comment" {
+		refs.CommentID = &targetID
+	}
+	if err := createNotification(tx, recipientID, "award", giverID, refs); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	return int(id), tx.Commit()
+}
+
+// ReceivedAward is an award a user has received
+type ReceivedAward struct {
+	ID            int       `json:"id"`
+	Award         string    `json:"award"`
+	GiverID       int       `json:"giver_id"`
+	GiverUsername string    `json:"giver_username"`
+	TargetType    string    `json:"target_type"`
+	TargetID      int       `json:"target_id"`
+	Message       string    `json:"message"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// GetReceivedAwards returns the awards a user has received, newest first,
+// along with how many of each type they've received in total
+func (dm *DatabaseManager) GetReceivedAwards(userID, limit, offset int) ([]ReceivedAward, AwardCounts, error) {
+	defer dm.span("GetReceivedAwards").End()
+	dm.mu.RLock()
+	defer dm.mu.RUnlock()
+
+	totals := AwardCounts{}
+	err := dm.db.QueryRow(`SELECT json_group_array(award) FROM awards WHERE recipient_id = ?`, userID).Scan(&totals)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to count awards: %v", err)
+	}
+
+	rows, err := dm.db.Query(`
+		SELECT a.id, a.award, a.giver_id, u.username, a.target_type, a.target_id, a.message, a.created_at
+		FROM awards a
+		JOIN users u ON a.giver_id = u.id
+		WHERE a.recipient_id = ?
+		ORDER BY a.id DESC
+		LIMIT ? OFFSET ?
+	`, userID, limit, offset)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get awards: %v", err)
+	}
+	defer rows.Close()
+
+	awards := []ReceivedAward{}
+	for rows.Next() {
+		var a ReceivedAward
+		err := rows.Scan(&a.ID, &a.Award, &a.GiverID, &a.GiverUsername, &a.TargetType, &a.TargetID, &a.Message, &a.CreatedAt)
+		if err != nil {
+			return nil, nil, err
+		}
+		awards = append(awards, a)
+	}
+	return awards, totals, rows.Err()
+}
+
+// GiveAwardRequest is the body of POST /posts/:id/awards and
+// POST /comments/:comment_id/awards
+type GiveAwardRequest struct {
+	Award   string `json:"award" binding:"required"`
+	Message string `json:"message" binding:"max=500"`
+}
+
+// getAwardTypes lists the awards that can be given
+func (h *APIHandler) getAwardTypes(c *gin.Context) {
+	c.JSON(http.StatusOK, awardTypes)
+}
+
+// giveAward returns a handler giving the post or comment named by the path
+// parameter param an award
+func (h *APIHandler) giveAward(targetType, param string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		targetID, err := strconv.Atoi(c.Param(param))
+		if err != nil {
+			c.Error(newAPIError(http.StatusBadRequest, "Invalid "+targetType+" ID"))
+			return
+		}
+
+		var req GiveAwardRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.Error(withStatus(http.StatusBadRequest, err))
+			return
+		}
+		if !isAwardType(req.Award) {
+			c.Error(newAPIError(http.StatusBadRequest, "Unknown award; see GET /awards"))
+			return
+		}
+
+		userID, _ := strconv.Atoi(c.GetString("user_id"))
+		awardID, err := h.dbFor(c).GiveAward(userID, targetType, targetID, req.Award, strings.TrimSpace(req.Message))
+		if err != nil {
+			c.Error(err)
+			return
+		}
+		h.publishNotifications()
+
+		c.JSON(http.StatusCreated, gin.H{"id": awardID, "award": req.Award})
+	}
+}
+
+// getUserAwards lists the awards a user has received, newest first, with
+// their totals by type
+func (h *APIHandler) getUserAwards(c *gin.Context) {
+	user, err := h.dbFor(c).GetUserByUsername(c.Param("username"))
+	if err != nil {
+		c.Error(newAPIError(http.StatusNotFound, "User not found"))
+		return
+	}
+	userID, _ := strconv.Atoi(user.ID)
+
+	limit, offset := parsePagination(c)
+	awards, totals, err := h.dbFor(c).GetReceivedAwards(userID, limit, offset)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"totals": totals,
+		"awards": awards,
+		"limit":  limit,
+		"offset": offset,
+	})
+}
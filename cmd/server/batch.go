@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxBatchPosts is how many posts GET /posts fetches at once
+const maxBatchPosts = 100
+
+// VoteBatchRequest is several votes cast at once, each like a POST /vote
+type VoteBatchRequest struct {
+	Votes []VoteRequest `json:"votes" binding:"required,min=1,max=50,dive"`
+}
+
+// VoteBatchResponse is the answer to a batch of votes
+type VoteBatchResponse struct {
+	Message string `json:"message"`
+	Count   int    `json:"count"`
+}
+
+// VoteBatch records several votes in one transaction, checking each nonce
+// like Vote. If any vote fails none are recorded, and the error says which.
+func (dm *DatabaseManager) VoteBatch(userID int, votes []VoteRequest) error {
+	defer dm.span("VoteBatch").End()
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	tx, err := dm.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if err := pruneVoteNonces(tx, userID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	for i, vote := range votes {
+		err := claimVoteNonce(tx, userID, vote.Nonce)
+		if err == nil {
+			err = applyVote(tx, userID, vote.TargetID, vote.TargetType, vote.Value)
+		}
+		if err == nil {
+			err = dm.enqueueOutboxEvent(tx, VoteCastEvent{UserID: userID, TargetID: vote.TargetID, TargetType: vote.TargetType, Value: vote.Value})
+		}
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("vote %d: %w", i, err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetPostsByID returns the visible posts among postIDs, in the order asked
+// for. IDs of posts that don't exist or are hidden are skipped.
+func (dm *DatabaseManager) GetPostsByID(postIDs []int) ([]Post, error) {
+	defer dm.span("GetPostsByID").End()
+	dm.mu.RLock()
+	defer dm.mu.RUnlock()
+
+	if len(postIDs) == 0 {
+		return []Post{}, nil
+	}
+	placeholders := make([]string, len(postIDs))
+	args := make([]interface{}, len(postIDs))
+	for i, id := range postIDs {
+		placeholders[i] = "?"
+		args[i] = id
+	}
+
+	rows, err := dm.db.Query(`
+		SELECT `+postColumns+`
+		FROM posts p
+		JOIN users u ON p.author_id = u.id
+		JOIN subreddits s ON p.subreddit_id = s.id
+		WHERE p.id IN (`+strings.Join(placeholders, ", ")+`) AND p.removed = 0 AND p.deleted_at IS NULL
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get posts: %v", err)
+	}
+	defer rows.Close()
+
+	found, err := scanPosts(rows)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[int]Post, len(found))
+	for _, post := range found {
+		byID[post.ID] = post
+	}
+
+	posts := make([]Post, 0, len(found))
+	for _, id := range postIDs {
+		if post, ok := byID[id]; ok {
+			posts = append(posts, post)
+			delete(byID, id) // an ID asked for twice is returned once
+		}
+	}
+	return posts, nil
+}
+
+// voteBatch casts several votes at once, all or none of them. Each vote
+// costs a write of the rate limit, and the batch is cast by one actor in one
+// transaction, so unlike POST /vote its votes on hot posts aren't aggregated.
+func (h *APIHandler) voteBatch(c *gin.Context) {
+	var req VoteBatchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(withStatus(http.StatusBadRequest, err))
+		return
+	}
+
+	// The route-level limit skips batches, so they're charged by vote
+	for range req.Votes {
+		if !h.allowWrite(c) {
+			return
+		}
+	}
+
+	userID, _ := strconv.Atoi(c.GetString("user_id"))
+	if _, err := h.pool.ProcessRequest(c, VoteBatchCommand{UserID: userID, Votes: req.Votes}); err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, VoteBatchResponse{Message: "Votes recorded successfully", Count: len(req.Votes)})
+}
+
+// getPosts returns the posts whose IDs are listed in ?ids=, e.g. ?ids=1,2,3,
+// in that order
+func (h *APIHandler) getPosts(c *gin.Context) {
+	var postIDs []int
+	for _, field := range strings.Split(c.Query("ids"), ",") {
+		if field = strings.TrimSpace(field); field == "" {
+			continue
+		}
+		id, err := strconv.Atoi(field)
+		if err != nil {
+			c.Error(newAPIError(http.StatusBadRequest, fmt.Sprintf("Invalid post ID %q", field)))
+			return
+		}
+		postIDs = append(postIDs, id)
+	}
+	if len(postIDs) == 0 {
+		c.Error(newAPIError(http.StatusBadRequest, "ids must list at least one post ID"))
+		return
+	}
+	if len(postIDs) > maxBatchPosts {
+		c.Error(newAPIError(http.StatusBadRequest, fmt.Sprintf("ids can list at most %d post IDs", maxBatchPosts)))
+		return
+	}
+
+	posts, err := h.dbFor(c).GetPostsByID(postIDs)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, posts)
+}
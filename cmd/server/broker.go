@@ -0,0 +1,94 @@
+//go:build broker
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/segmentio/kafka-go"
+
+	"github.com/ArjunKaliyath/GoReddit/internal/config"
+)
+
+// newEventPublisher connects to the broker named by events.broker
+func newEventPublisher(cfg config.Events) (EventPublisher, error) {
+	switch cfg.Broker {
+	case config.BrokerNATS:
+		return newNATSPublisher(cfg.BrokerURLs)
+	case config.BrokerKafka:
+		return newKafkaPublisher(cfg.BrokerURLs), nil
+	}
+	return nil, fmt.Errorf("unknown broker %q", cfg.Broker)
+}
+
+// natsPublisher publishes to NATS JetStream, which acknowledges messages
+// once a stream has stored them. A stream must capture the event subjects,
+// e.g. goreddit.>. The event ID is sent as Nats-Msg-Id, so JetStream drops
+// events the relay publishes again within the stream's duplicate window.
+type natsPublisher struct {
+	conn *nats.Conn
+	js   nats.JetStreamContext
+}
+
+func newNATSPublisher(urls []string) (*natsPublisher, error) {
+	conn, err := nats.Connect(strings.Join(urls, ","), nats.Name("goreddit-server"), nats.MaxReconnects(-1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to NATS: %v", err)
+	}
+	js, err := conn.JetStream()
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to open JetStream: %v", err)
+	}
+	return &natsPublisher{conn: conn, js: js}, nil
+}
+
+func (p *natsPublisher) Publish(ctx context.Context, topic string, event OutboxEvent) error {
+	msg := nats.NewMsg(topic)
+	msg.Data = event.Payload
+	msg.Header.Set("GoReddit-Event", event.Type)
+
+	wait := outboxPublishWait
+	if deadline, ok := ctx.Deadline(); ok {
+		wait = time.Until(deadline)
+	}
+	_, err := p.js.PublishMsg(msg, nats.MsgId(event.EventID), nats.AckWait(wait))
+	return err
+}
+
+func (p *natsPublisher) Close() error {
+	return p.conn.Drain()
+}
+
+// kafkaPublisher publishes to Kafka, waiting for every in-sync replica to
+// acknowledge. Messages are keyed by event ID.
+type kafkaPublisher struct {
+	writer *kafka.Writer
+}
+
+func newKafkaPublisher(brokers []string) *kafkaPublisher {
+	return &kafkaPublisher{writer: &kafka.Writer{
+		Addr:                   kafka.TCP(brokers...),
+		Balancer:               &kafka.Hash{},
+		RequiredAcks:           kafka.RequireAll,
+		AllowAutoTopicCreation: true,
+		MaxAttempts:            1, // the relay retries
+	}}
+}
+
+func (p *kafkaPublisher) Publish(ctx context.Context, topic string, event OutboxEvent) error {
+	return p.writer.WriteMessages(ctx, kafka.Message{
+		Topic:   topic,
+		Key:     []byte(event.EventID),
+		Value:   event.Payload,
+		Headers: []kafka.Header{{Key: "GoReddit-Event", Value: []byte(event.Type)}},
+	})
+}
+
+func (p *kafkaPublisher) Close() error {
+	return p.writer.Close()
+}
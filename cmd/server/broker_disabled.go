@@ -0,0 +1,16 @@
+//go:build !broker
+
+package main
+
+import (
+	"errors"
+
+	"github.com/ArjunKaliyath/GoReddit/internal/config"
+)
+
+// newEventPublisher fails in builds without the broker tag, which leave out
+// the NATS and Kafka clients. Build with -tags broker to publish events to a
+// broker.
+func newEventPublisher(cfg config.Events) (EventPublisher, error) {
+	return nil, errors.New("this build doesn't include broker support; build with -tags broker")
+}
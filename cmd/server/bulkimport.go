@@ -0,0 +1,311 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Bulk import
+//
+// POST /admin/import loads a whole simulation dataset in one transaction.
+// Entities in the bundle refer to each other by name: users by username,
+// subreddits by name, and posts and comments by a ref chosen by the bundle.
+// Users and subreddits that already exist can be referred to as well.
+// Imported content skips automod, notifications and mentions.
+
+const maxImportEntities = 200000 // per bundle, across all entity kinds
+
+// ImportBundle is a dataset to load with POST /admin/import
+type ImportBundle struct {
+	Users      []ImportUser      `json:"users" binding:"dive"`
+	Subreddits []ImportSubreddit `json:"subreddits" binding:"dive"`
+	Posts      []ImportPost      `json:"posts" binding:"dive"`
+	Comments   []ImportComment   `json:"comments" binding:"dive"`
+	Votes      []ImportVote      `json:"votes" binding:"dive"`
+}
+
+// ImportUser is a user to create
+type ImportUser struct {
+	Username string `json:"username" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+// ImportSubreddit is a subreddit to create
+type ImportSubreddit struct {
+	Name        string   `json:"name" binding:"required"`
+	Description string   `json:"description"`
+	Creator     string   `json:"creator" binding:"required"` // joins and moderates the subreddit
+	Members     []string `json:"members"`
+}
+
+// ImportPost is a post to create, referred to by its ref
+type ImportPost struct {
+	Ref       string     `json:"ref" binding:"required"`
+	Title     string     `json:"title" binding:"required"`
+	Content   string     `json:"content" binding:"required"`
+	Author    string     `json:"author" binding:"required"`
+	Subreddit string     `json:"subreddit" binding:"required"`
+	CreatedAt *time.Time `json:"created_at"` // defaults to the time of the import
+}
+
+// ImportComment is a comment to create, referred to by its ref
+type ImportComment struct {
+	Ref       string     `json:"ref" binding:"required"`
+	Post      string     `json:"post" binding:"required"` // ref of the post
+	Parent    string     `json:"parent"`                  // ref of the parent comment, if a reply
+	Content   string     `json:"content" binding:"required"`
+	Author    string     `json:"author" binding:"required"`
+	CreatedAt *time.Time `json:"created_at"`
+}
+
+// ImportVote votes on either a post or a comment, by ref
+type ImportVote struct {
+	User    string `json:"user" binding:"required"`
+	Post    string `json:"post"`
+	Comment string `json:"comment"`
+	Value   int    `json:"value" binding:"required,oneof=1 -1"`
+}
+
+// ImportResult reports what an import created and the IDs it was given
+type ImportResult struct {
+	Users      map[string]int `json:"users"`      // username to ID
+	Subreddits map[string]int `json:"subreddits"` // name to ID
+	Posts      map[string]int `json:"posts"`      // ref to ID
+	Comments   map[string]int `json:"comments"`   // ref to ID
+	Votes      int            `json:"votes"`
+}
+
+// importTimestamp formats an optional creation time like SQLite's
+// CURRENT_TIMESTAMP
+func importTimestamp(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return t.UTC().Format("2006-01-02 15:04:05")
+}
+
+// importer resolves the names in a bundle while it's imported
+type importer struct {
+	tx     *sql.Tx
+	result ImportResult
+}
+
+// user resolves a username, from the bundle or the existing users
+func (im *importer) user(username string) (int, error) {
+	if id, ok := im.result.Users[username]; ok {
+		return id, nil
+	}
+	var id int
+	if err := im.tx.QueryRow(`SELECT id FROM users WHERE username = ?`, username).Scan(&id); err != nil {
+		return 0, fmt.Errorf("user %q not found", username)
+	}
+	return id, nil
+}
+
+// subreddit resolves a subreddit name, from the bundle or the existing
+// subreddits
+func (im *importer) subreddit(name string) (int, error) {
+	if id, ok := im.result.Subreddits[name]; ok {
+		return id, nil
+	}
+	var id int
+	if err := im.tx.QueryRow(`SELECT id FROM subreddits WHERE name = ?`, name).Scan(&id); err != nil {
+		return 0, fmt.Errorf("subreddit %q not found", name)
+	}
+	return id, nil
+}
+
+// insert runs an INSERT and returns the new row's ID
+func (im *importer) insert(query string, args ...interface{}) (int, error) {
+	result, err := im.tx.Exec(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	return int(id), err
+}
+
+func (im *importer) run(bundle ImportBundle) error {
+	for i, u := range bundle.Users {
+		id, err := im.insert(`INSERT INTO users (username, password) VALUES (?, ?)`, u.Username, u.Password)
+		if err != nil {
+			return fmt.Errorf("users[%d]: failed to create user: %v", i, err)
+		}
+		im.result.Users[u.Username] = id
+	}
+
+	for i, s := range bundle.Subreddits {
+		creatorID, err := im.user(s.Creator)
+		if err != nil {
+			return fmt.Errorf("subreddits[%d]: %v", i, err)
+		}
+		id, err := im.insert(`INSERT INTO subreddits (name, description, owner_id) VALUES (?, ?, ?)`, s.Name, s.Description, creatorID)
+		if err != nil {
+			return fmt.Errorf("subreddits[%d]: failed to create subreddit: %v", i, err)
+		}
+		im.result.Subreddits[s.Name] = id
+
+		if _, err := im.tx.Exec(`INSERT INTO subreddit_moderators (subreddit_id, user_id) VALUES (?, ?)`, id, creatorID); err != nil {
+			return fmt.Errorf("subreddits[%d]: failed to add moderator: %v", i, err)
+		}
+		for _, member := range append([]string{s.Creator}, s.Members...) {
+			userID, err := im.user(member)
+			if err != nil {
+				return fmt.Errorf("subreddits[%d]: %v", i, err)
+			}
+			_, err = im.tx.Exec(`INSERT OR IGNORE INTO subreddit_members (subreddit_id, user_id) VALUES (?, ?)`, id, userID)
+			if err != nil {
+				return fmt.Errorf("subreddits[%d]: failed to add member: %v", i, err)
+			}
+		}
+	}
+
+	for i, p := range bundle.Posts {
+		if _, ok := im.result.Posts[p.Ref]; ok {
+			return fmt.Errorf("posts[%d]: duplicate ref %q", i, p.Ref)
+		}
+		authorID, err := im.user(p.Author)
+		if err != nil {
+			return fmt.Errorf("posts[%d]: %v", i, err)
+		}
+		subredditID, err := im.subreddit(p.Subreddit)
+		if err != nil {
+			return fmt.Errorf("posts[%d]: %v", i, err)
+		}
+		id, err := im.insert(`
+			INSERT INTO posts (title, content, author_id, subreddit_id, created_at, slug)
+			VALUES (?, ?, ?, ?, COALESCE(?, CURRENT_TIMESTAMP), ?)
+		`, p.Title, p.Content, authorID, subredditID, importTimestamp(p.CreatedAt), slugify(p.Title))
+		if err != nil {
+			return fmt.Errorf("posts[%d]: failed to create post: %v", i, err)
+		}
+		im.result.Posts[p.Ref] = id
+	}
+
+	// Comments are imported in order, so replies come after their parents
+	commentPosts := make(map[string]string)
+	for i, cm := range bundle.Comments {
+		if _, ok := im.result.Comments[cm.Ref]; ok {
+			return fmt.Errorf("comments[%d]: duplicate ref %q", i, cm.Ref)
+		}
+		postID, ok := im.result.Posts[cm.Post]
+		if !ok {
+			return fmt.Errorf("comments[%d]: post %q not found", i, cm.Post)
+		}
+		var parentID *int
+		if cm.Parent != "" {
+			id, ok := im.result.Comments[cm.Parent]
+			if !ok || commentPosts[cm.Parent] != cm.Post {
+				return fmt.Errorf("comments[%d]: parent comment %q not found on this post", i, cm.Parent)
+			}
+			parentID = &id
+		}
+		authorID, err := im.user(cm.Author)
+		if err != nil {
+			return fmt.Errorf("comments[%d]: %v", i, err)
+		}
+		id, err := im.insert(`
+			INSERT INTO comments (content, author_id, post_id, parent_comment_id, created_at)
+			VALUES (?, ?, ?, ?, COALESCE(?, CURRENT_TIMESTAMP))
+		`, cm.Content, authorID, postID, parentID, importTimestamp(cm.CreatedAt))
+		if err != nil {
+			return fmt.Errorf("comments[%d]: failed to create comment: %v", i, err)
+		}
+		im.result.Comments[cm.Ref] = id
+		commentPosts[cm.Ref] = cm.Post
+	}
+	for ref, postID := range im.result.Posts {
+		if err := updateCommentCount(im.tx, postID); err != nil {
+			return fmt.Errorf("post %q: %v", ref, err)
+		}
+	}
+
+	for i, v := range bundle.Votes {
+		userID, err := im.user(v.User)
+		if err != nil {
+			return fmt.Errorf("votes[%d]: %v", i, err)
+		}
+
+		var targetID int
+		var targetType string
+		var ok bool
+		switch {
+		case v.Post != "" && v.Comment == "":
+			targetID, ok = im.result.Posts[v.Post]
+			targetType = "post"
+		case v.Comment != "" && v.Post == "":
+			targetID, ok = im.result.Comments[v.Comment]
+			targetType = "comment"
+		default:
+			return fmt.Errorf("votes[%d]: exactly one of post and comment is required", i)
+		}
+		if !ok {
+			return fmt.Errorf("votes[%d]: %s not found", i, targetType)
+		}
+
+		if err := applyVote(im.tx, userID, targetID, targetType, v.Value); err != nil {
+			return fmt.Errorf("votes[%d]: %v", i, err)
+		}
+		im.result.Votes++
+	}
+
+	return nil
+}
+
+// ImportBundle loads a bundle in one transaction. Nothing is imported if any
+// entity fails, and the error names the first one that did.
+func (dm *DatabaseManager) ImportBundle(bundle ImportBundle) (*ImportResult, error) {
+	defer dm.span("ImportBundle").End()
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	tx, err := dm.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	im := &importer{tx: tx, result: ImportResult{
+		Users:      make(map[string]int),
+		Subreddits: make(map[string]int),
+		Posts:      make(map[string]int),
+		Comments:   make(map[string]int),
+	}}
+	if err := im.run(bundle); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit import: %v", err)
+	}
+	return &im.result, nil
+}
+
+// importBundle loads a simulation dataset in one request
+func (h *APIHandler) importBundle(c *gin.Context) {
+	var bundle ImportBundle
+	if err := c.ShouldBindJSON(&bundle); err != nil {
+		c.Error(withStatus(http.StatusBadRequest, err))
+		return
+	}
+
+	total := len(bundle.Users) + len(bundle.Subreddits) + len(bundle.Posts) + len(bundle.Comments) + len(bundle.Votes)
+	if total > maxImportEntities {
+		c.Error(newAPIError(http.StatusRequestEntityTooLarge, fmt.Sprintf("bundles are limited to %d entities", maxImportEntities)))
+		return
+	}
+
+	result, err := h.dbFor(c).ImportBundle(bundle)
+	if err != nil {
+		h.metrics.Inc(`goreddit_imports_total{result="error"}`)
+		c.Error(withStatus(http.StatusBadRequest, err))
+		return
+	}
+
+	h.metrics.Inc(`goreddit_imports_total{result="ok"}`)
+	c.JSON(http.StatusCreated, result)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ArjunKaliyath/GoReddit/internal/config"
+)
+
+// CAPTCHA verification
+//
+// With captcha.provider set, registering takes the response token of a
+// CAPTCHA the client solved, which is checked with the provider's siteverify
+// API before the account is created. Clients get the provider and site key to
+// render the challenge with from GET /captcha.
+
+const captchaTimeout = 10 * time.Second
+
+// captchaVerifyURLs are the siteverify endpoints of the providers
+var captchaVerifyURLs = map[string]string{
+	config.CaptchaHCaptcha:  "https://api.hcaptcha.com/siteverify",
+	config.CaptchaReCaptcha: "https://www.google.com/recaptcha/api/siteverify",
+}
+
+// ErrCaptchaFailed is returned when a CAPTCHA response is missing or rejected
+var ErrCaptchaFailed = errors.New("CAPTCHA verification failed")
+
+// captchaVerifier checks CAPTCHA responses with a provider
+type captchaVerifier struct {
+	provider  string
+	siteKey   string
+	secret    string
+	verifyURL string
+	client    *http.Client
+}
+
+func newCaptchaVerifier(cfg config.Captcha) *captchaVerifier {
+	return &captchaVerifier{
+		provider:  cfg.Provider,
+		siteKey:   cfg.SiteKey,
+		secret:    cfg.Secret,
+		verifyURL: captchaVerifyURLs[cfg.Provider],
+		client:    &http.Client{Timeout: captchaTimeout},
+	}
+}
+
+// Verify checks a response token, failing with ErrCaptchaFailed when the
+// provider rejects it. Other errors mean the provider couldn't be asked.
+func (v *captchaVerifier) Verify(token, remoteIP string) error {
+	if token == "" {
+		return fmt.Errorf("%w: captcha_token is required", ErrCaptchaFailed)
+	}
+
+	form := url.Values{"secret": {v.secret}, "response": {token}, "remoteip": {remoteIP}}
+	if v.provider == config.CaptchaHCaptcha {
+		form.Set("sitekey", v.siteKey)
+	}
+	resp, err := v.client.PostForm(v.verifyURL, form)
+	if err != nil {
+		return fmt.Errorf("failed to reach %s: %v", v.provider, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s answered %s", v.provider, resp.Status)
+	}
+
+	var result struct {
+		Success    bool     `json:"success"`
+		ErrorCodes []string `json:"error-codes"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("failed to read %s response: %v", v.provider, err)
+	}
+	if !result.Success {
+		if len(result.ErrorCodes) > 0 {
+			return fmt.Errorf("%w: %s", ErrCaptchaFailed, strings.Join(result.ErrorCodes, ", "))
+		}
+		return ErrCaptchaFailed
+	}
+	return nil
+}
+
+// CaptchaSettings tells clients whether registering takes a CAPTCHA, and
+// how to render it
+type CaptchaSettings struct {
+	Enabled  bool   `json:"enabled"`
+	Provider string `json:"provider,omitempty"`
+	SiteKey  string `json:"site_key,omitempty"`
+}
+
+// getCaptchaSettings returns the CAPTCHA registering takes, if any
+func (h *APIHandler) getCaptchaSettings(c *gin.Context) {
+	settings := CaptchaSettings{}
+	if h.captcha != nil {
+		settings = CaptchaSettings{Enabled: true, Provider: h.captcha.provider, SiteKey: h.captcha.siteKey}
+	}
+
+	c.JSON(http.StatusOK, settings)
+}
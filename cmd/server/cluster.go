@@ -0,0 +1,257 @@
+//go:build cluster
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"runtime/debug"
+	"strconv"
+	"time"
+
+	"github.com/asynkron/protoactor-go/actor"
+	"github.com/asynkron/protoactor-go/cluster"
+	"github.com/asynkron/protoactor-go/cluster/clusterproviders/automanaged"
+	"github.com/asynkron/protoactor-go/cluster/identitylookup/disthash"
+	"github.com/asynkron/protoactor-go/remote"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+
+	"github.com/ArjunKaliyath/GoReddit/internal/config"
+	"github.com/ArjunKaliyath/GoReddit/internal/redditpb"
+)
+
+// Cluster mode
+//
+// With cluster.role set, writes are processed on worker nodes instead of the
+// API node's own actor pool. Each shard key (a subreddit, or the recipient of
+// a direct message) is a virtual actor of the commands kind, placed on one of
+// the workers by consistent hashing, so the commands for a subreddit are
+// still processed in order by one actor. Nodes find each other through the
+// health ports listed in cluster.seeds. Commands and their results travel as
+// the ClusterCommand and ClusterResult messages of
+// proto/goreddit/v1/cluster.proto.
+
+const clusterCommandKind = "commands"
+
+// clusterErrors are the errors that keep their identity on the way back to
+// the API node, so errorResponses can map them: all of errorKinds
+var clusterErrors = func() []error {
+	kinds := make([]error, len(errorKinds))
+	for i, kind := range errorKinds {
+		kinds[i] = kind.err
+	}
+	return kinds
+}()
+
+// startCluster joins the cluster. Worker nodes host the command actors and
+// get no commandCluster; API nodes get one that sends commands to the
+// workers. The returned function leaves the cluster.
+func startCluster(system *actor.ActorSystem, h *APIHandler, cfg config.Cluster, timeout time.Duration) (commandCluster, func(), error) {
+	host, portValue, err := net.SplitHostPort(cfg.Addr)
+	if err != nil {
+		return nil, nil, err
+	}
+	port, err := strconv.Atoi(portValue)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid cluster port %q", portValue)
+	}
+
+	options := []cluster.ConfigOption{cluster.WithRequestTimeout(timeout)}
+	if cfg.Role == config.ClusterWorker {
+		options = append(options, cluster.WithKinds(cluster.NewKind(clusterCommandKind, actor.PropsFromProducer(func() actor.Actor {
+			return &commandGrain{worker: &RequestProcessingActor{handler: h, hot: newHotPosts(h.pool.voteFlush)}}
+		}))))
+	}
+
+	provider := automanaged.NewWithConfig(2*time.Second, cfg.MembershipPort, cfg.Seeds...)
+	c := cluster.New(system, cluster.Configure(cfg.Name, provider, disthash.New(), remote.Configure(host, port), options...))
+	leave := func() { c.Shutdown(true) }
+
+	if cfg.Role == config.ClusterWorker {
+		c.StartMember()
+		return nil, leave, nil
+	}
+	c.StartClient()
+	return &clusterClient{cluster: c}, leave, nil
+}
+
+// clusterClient sends commands from an API node to the workers
+type clusterClient struct {
+	cluster *cluster.Cluster
+}
+
+func (cc *clusterClient) Process(ctx context.Context, key string, cmd Command) (interface{}, error) {
+	payload, err := json.Marshal(cmd)
+	if err != nil {
+		return nil, err
+	}
+	msg := &redditpb.ClusterCommand{Type: cmd.commandType(), Payload: payload, TraceContext: map[string]string{}}
+	otel.GetTextMapPropagator().Inject(ctx, propagation.MapCarrier(msg.TraceContext))
+
+	// Commands without a target still need an actor
+	if key == "" {
+		key = cmd.commandType()
+	}
+
+	reply, err := cc.cluster.Request(key, clusterCommandKind, msg)
+	if errors.Is(err, actor.ErrTimeout) {
+		return nil, errRequestTimeout
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cluster request failed: %v", err)
+	}
+
+	res, ok := reply.(*redditpb.ClusterResult)
+	if !ok {
+		return nil, fmt.Errorf("unexpected reply %T from cluster", reply)
+	}
+	if res.Error != "" {
+		err := &clusterError{message: res.Error}
+		for _, kind := range clusterErrors {
+			if kind.Error() == res.ErrorKind {
+				err.kind = kind
+			}
+		}
+		return nil, err
+	}
+	return decodeClusterResult(cmd, res.Payload)
+}
+
+// clusterError is an error returned by a worker node
+type clusterError struct {
+	message string
+	kind    error // one of clusterErrors, or nil
+}
+
+func (e *clusterError) Error() string { return e.message }
+func (e *clusterError) Unwrap() error { return e.kind }
+
+// commandGrain is the virtual actor a worker node runs for a shard key
+type commandGrain struct {
+	worker *RequestProcessingActor
+}
+
+func (g *commandGrain) Receive(ctx actor.Context) {
+	msg, ok := ctx.Message().(*redditpb.ClusterCommand)
+	if !ok {
+		return
+	}
+
+	// Answer a command that crashes the actor before it's restarted, so the
+	// API node doesn't wait for it to time out
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Cluster actor crashed processing request of type %s: %v\n%s", msg.Type, r, debug.Stack())
+			ctx.Respond(&redditpb.ClusterResult{Error: fmt.Sprintf("internal error processing %s request", msg.Type)})
+			panic(r)
+		}
+	}()
+
+	traceCtx := otel.GetTextMapPropagator().Extract(context.Background(), propagation.MapCarrier(msg.TraceContext))
+	cmd, err := decodeClusterCommand(msg.Type, msg.Payload)
+	var result interface{}
+	if err == nil {
+		result, err = g.worker.process(ctx, traceCtx, cmd)
+	}
+	ctx.Respond(encodeClusterResult(result, err))
+}
+
+func encodeClusterResult(result interface{}, err error) *redditpb.ClusterResult {
+	if err != nil {
+		res := &redditpb.ClusterResult{Error: err.Error()}
+		for _, kind := range clusterErrors {
+			if errors.Is(err, kind) {
+				res.ErrorKind = kind.Error()
+			}
+		}
+		return res
+	}
+
+	payload, err := json.Marshal(result)
+	if err != nil {
+		return &redditpb.ClusterResult{Error: err.Error()}
+	}
+	return &redditpb.ClusterResult{Payload: payload}
+}
+
+func decodeClusterCommand(commandType string, payload []byte) (Command, error) {
+	var cmd Command
+	var err error
+	switch commandType {
+	case "create_post":
+		var c CreatePostCommand
+		err = json.Unmarshal(payload, &c)
+		cmd = c
+	case "create_comment":
+		var c CreateCommentCommand
+		err = json.Unmarshal(payload, &c)
+		cmd = c
+	case "send_message":
+		var c SendMessageCommand
+		err = json.Unmarshal(payload, &c)
+		cmd = c
+	case "join_subreddit":
+		var c JoinSubredditCommand
+		err = json.Unmarshal(payload, &c)
+		cmd = c
+	case "leave_subreddit":
+		var c LeaveSubredditCommand
+		err = json.Unmarshal(payload, &c)
+		cmd = c
+	case "create_subreddit":
+		var c CreateSubredditCommand
+		err = json.Unmarshal(payload, &c)
+		cmd = c
+	case "vote":
+		var c VoteCommand
+		err = json.Unmarshal(payload, &c)
+		cmd = c
+	case "vote_batch":
+		var c VoteBatchCommand
+		err = json.Unmarshal(payload, &c)
+		cmd = c
+	default:
+		return nil, fmt.Errorf("unhandled request type: %s", commandType)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s command: %v", commandType, err)
+	}
+	return cmd, nil
+}
+
+// decodeClusterResult decodes the result of cmd
+func decodeClusterResult(cmd Command, payload []byte) (interface{}, error) {
+	var result interface{}
+	var err error
+	switch cmd.(type) {
+	case CreatePostCommand:
+		var r PostCreated
+		err = json.Unmarshal(payload, &r)
+		result = r
+	case CreateCommentCommand:
+		var r CommentCreated
+		err = json.Unmarshal(payload, &r)
+		result = r
+	case SendMessageCommand:
+		var r MessageSent
+		err = json.Unmarshal(payload, &r)
+		result = r
+	case CreateSubredditCommand:
+		var r SubredditCreated
+		err = json.Unmarshal(payload, &r)
+		result = r
+	default:
+		var r Acknowledged
+		err = json.Unmarshal(payload, &r)
+		result = r
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid result from cluster: %v", err)
+	}
+	return result, nil
+}
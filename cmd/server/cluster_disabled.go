@@ -0,0 +1,19 @@
+//go:build !cluster
+
+package main
+
+import (
+	"errors"
+	"time"
+
+	"github.com/asynkron/protoactor-go/actor"
+
+	"github.com/ArjunKaliyath/GoReddit/internal/config"
+)
+
+// startCluster fails in builds without the cluster tag, which leave out
+// protoactor's cluster support and the code generated from the proto files.
+// Run `make proto` and build with -tags cluster to run in cluster mode.
+func startCluster(system *actor.ActorSystem, h *APIHandler, cfg config.Cluster, timeout time.Duration) (commandCluster, func(), error) {
+	return nil, nil, errors.New("this build doesn't include cluster mode; run make proto and build with -tags cluster")
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Error responses
+//
+// Handlers and middleware don't write error responses themselves. They
+// attach the error to the request with c.Error and return (or abort), and the
+// errorResponses middleware answers with its status and a body like
+//
+//	{"error": "post not found", "code": "not_found"}
+//
+// Codes are stable, so clients can rely on them where the messages may
+// change. The status and code come from what the error is: the data layer's
+// sentinel errors are listed in errorKinds, a unique constraint violation is
+// a 409 conflict, an *APIError carries its own, and an error about something
+// not found is a 404. Anything else is a 500 whose details are logged rather
+// than sent, so database errors don't leak to clients.
+
+// The codes of errors that don't have one of their own
+const (
+	codeInvalidRequest = "invalid_request"
+	codeUnauthorized   = "unauthorized"
+	codeForbidden      = "forbidden"
+	codeNotFound       = "not_found"
+	codeConflict       = "conflict"
+	codeTooLarge       = "too_large"
+	codeRateLimited    = "rate_limited"
+	codeInternal       = "internal"
+	codeUpstream       = "upstream_error"
+	codeUnavailable    = "unavailable"
+	codeTimeout        = "timeout"
+)
+
+// statusCodes are the codes of errors by their status
+var statusCodes = map[int]string{
+	http.StatusBadRequest:            codeInvalidRequest,
+	http.StatusUnauthorized:          codeUnauthorized,
+	http.StatusForbidden:             codeForbidden,
+	http.StatusNotFound:              codeNotFound,
+	http.StatusConflict:              codeConflict,
+	http.StatusRequestEntityTooLarge: codeTooLarge,
+	http.StatusTooManyRequests:       codeRateLimited,
+	http.StatusInternalServerError:   codeInternal,
+	http.StatusBadGateway:            codeUpstream,
+	http.StatusServiceUnavailable:    codeUnavailable,
+	http.StatusGatewayTimeout:        codeTimeout,
+}
+
+// codeForStatus is the code of an error with the given status
+func codeForStatus(status int) string {
+	if code, ok := statusCodes[status]; ok {
+		return code
+	}
+	if status >= http.StatusInternalServerError {
+		return codeInternal
+	}
+	return codeInvalidRequest
+}
+
+// errorKinds are the sentinel errors with the status and code they're
+// reported with, however they're wrapped
+var errorKinds = []struct {
+	err    error
+	status int
+	code   string
+}{
+	{ErrInvalidCredentials, http.StatusUnauthorized, "invalid_credentials"},
+	{ErrCaptchaFailed, http.StatusBadRequest, "captcha_failed"},
+	{ErrBannedFromSubreddit, http.StatusForbidden, "banned"},
+	{ErrPostArchived, http.StatusForbidden, "post_archived"},
+	{ErrSubredditArchived, http.StatusForbidden, "subreddit_archived"},
+	{ErrNotEnoughKarma, http.StatusForbidden, "not_enough_karma"},
+	{ErrPostingTooFast, http.StatusTooManyRequests, "posting_too_fast"},
+	{ErrPostNotAllowed, http.StatusBadRequest, "post_not_allowed"},
+	{ErrNotAuthor, http.StatusForbidden, "not_author"},
+	{ErrHistoryHidden, http.StatusForbidden, "history_hidden"},
+	{ErrCannotDelete, http.StatusForbidden, codeForbidden},
+	{ErrCannotRestore, http.StatusForbidden, codeForbidden},
+	{ErrSubredditGone, http.StatusConflict, "subreddit_deleted"},
+	{ErrNotOwner, http.StatusForbidden, "not_owner"},
+	{ErrNotModerator, http.StatusBadRequest, "not_moderator"},
+	{ErrAlreadyOwner, http.StatusBadRequest, "already_owner"},
+	{ErrStaleVote, http.StatusBadRequest, "stale_vote"},
+	{ErrVoteReplay, http.StatusConflict, "vote_replay"},
+	{errVoteExists, http.StatusConflict, "vote_exists"},
+	{ErrAwardOwnContent, http.StatusBadRequest, "award_own_content"},
+	{ErrAwardAlreadyGiven, http.StatusConflict, "award_already_given"},
+	{ErrNotChatMember, http.StatusForbidden, "not_chat_member"},
+	{ErrNotChatOwner, http.StatusForbidden, "not_chat_owner"},
+	{ErrChatRoomFull, http.StatusConflict, "chat_room_full"},
+	{errUnknownJob, http.StatusNotFound, codeNotFound},
+	{errJobRunning, http.StatusConflict, "job_running"},
+	{errPoolSaturated, http.StatusServiceUnavailable, codeUnavailable},
+	{errRequestTimeout, http.StatusGatewayTimeout, codeTimeout},
+}
+
+// uniqueConflicts describe what violating the unique constraint on a column
+// means
+var uniqueConflicts = map[string]struct{ code, message string }{
+	"users.username":  {"username_taken", "username is already taken"},
+	"subreddits.name": {"subreddit_exists", "a subreddit with this name already exists"},
+}
+
+// APIError is an error reported with a given status and code
+type APIError struct {
+	Status  int
+	Code    string
+	Message string
+	Err     error       // what caused it, if anything
+	Report  interface{} // what was done before it, sent with the error if set
+}
+
+func (e *APIError) Error() string { return e.Message }
+
+func (e *APIError) Unwrap() error { return e.Err }
+
+// newAPIError is an error with a message meant for the client
+func newAPIError(status int, message string) *APIError {
+	return &APIError{Status: status, Code: codeForStatus(status), Message: message}
+}
+
+// withStatus reports err with a status, unless it's one of errorKinds or a
+// unique constraint violation, which have their own
+func withStatus(status int, err error) *APIError {
+	return &APIError{Status: status, Code: codeForStatus(status), Message: err.Error(), Err: err}
+}
+
+// withReport reports err along with the report of the work it stopped, so
+// clients learn what was done before it
+func withReport(err error, report interface{}) *APIError {
+	apiErr := classifyError(err)
+	apiErr.Report = report
+	return apiErr
+}
+
+// ErrorResponse is the body of every error response
+type ErrorResponse struct {
+	Error  string      `json:"error"`
+	Code   string      `json:"code"`
+	Report interface{} `json:"report,omitempty"` // see withReport
+}
+
+// trimCause cuts what caused an error about something not found off its
+// message, e.g. "post not found: sql: no rows in result set" to
+// "post not found"
+func trimCause(message string) string {
+	if i := strings.Index(message, "not found: "); i >= 0 {
+		return message[:i+len("not found")]
+	}
+	return message
+}
+
+// classifyError works out the status, code and message of the response to
+// err
+func classifyError(err error) *APIError {
+	for _, kind := range errorKinds {
+		if errors.Is(err, kind.err) {
+			return &APIError{Status: kind.status, Code: kind.code, Message: trimCause(err.Error()), Err: err}
+		}
+	}
+
+	if message := err.Error(); strings.Contains(message, "UNIQUE constraint failed") {
+		for column, conflict := range uniqueConflicts {
+			if strings.Contains(message, "UNIQUE constraint failed: "+column) {
+				return &APIError{Status: http.StatusConflict, Code: conflict.code, Message: conflict.message, Err: err}
+			}
+		}
+		return &APIError{Status: http.StatusConflict, Code: codeConflict, Message: "it already exists", Err: err}
+	}
+
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		classified := *apiErr
+		if classified.Status >= http.StatusInternalServerError && classified.Err != nil {
+			classified.Message = "internal server error"
+		} else {
+			classified.Message = trimCause(classified.Message)
+		}
+		return &classified
+	}
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return &APIError{Status: http.StatusNotFound, Code: codeNotFound, Message: "not found", Err: err}
+	}
+	if message := err.Error(); strings.Contains(message, "not found") {
+		return &APIError{Status: http.StatusNotFound, Code: codeNotFound, Message: trimCause(message), Err: err}
+	}
+	return &APIError{Status: http.StatusInternalServerError, Code: codeInternal, Message: "internal server error", Err: err}
+}
+
+// errorResponses answers a request its handlers attached an error to
+// without responding, logging server errors with their cause
+func errorResponses() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		if len(c.Errors) == 0 || c.Writer.Written() {
+			return
+		}
+		err := c.Errors.Last().Err
+		apiErr := classifyError(err)
+		if apiErr.Status >= http.StatusInternalServerError {
+			logAt(logError, "%s %s failed: %v", c.Request.Method, c.Request.URL.Path, err)
+		}
+		if apiErr.Status == http.StatusServiceUnavailable {
+			c.Header("Retry-After", "1")
+		}
+		c.JSON(apiErr.Status, ErrorResponse{Error: apiErr.Message, Code: apiErr.Code, Report: apiErr.Report})
+	}
+}
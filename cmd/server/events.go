@@ -0,0 +1,309 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Domain events
+//
+// The write paths (the actor pool, gRPC and GraphQL) emit a typed event onto
+// h.events for each thing that happens, and features react to the events by
+// subscribing a sink to the bus rather than being called from every write
+// path. The realtime sink pushes WebSocket and SSE events, and the log and
+// webhook sinks are enabled by the startup config. Events reach
+// sinks in the order they were emitted, asynchronously, after the write has
+// committed; an event a sink fails on is logged and counted, not retried.
+// Each sink has its own queue and goroutine, so a slow sink, such as a
+// webhook that takes its time to respond, only holds up itself.
+// Events for the broker don't go through the bus: the writes store them in
+// the event outbox in their own transactions (see outbox.go).
+
+// DomainEvent is something that happened, such as a post being created
+type DomainEvent interface {
+	EventType() string
+}
+
+type PostCreatedEvent struct {
+	PostID      int    `json:"post_id"`
+	AuthorID    int    `json:"author_id"`
+	SubredditID int    `json:"subreddit_id"`
+	Title       string `json:"title"`
+	Removed     bool   `json:"removed"` // by automod
+}
+
+type CommentCreatedEvent struct {
+	CommentID       int  `json:"comment_id"`
+	PostID          int  `json:"post_id"`
+	ParentCommentID *int `json:"parent_comment_id"`
+	AuthorID        int  `json:"author_id"`
+	Removed         bool `json:"removed"` // by automod
+}
+
+type VoteCastEvent struct {
+	UserID     int    `json:"user_id"`
+	TargetID   int    `json:"target_id"`
+	TargetType string `json:"target_type"`
+	Value      int    `json:"value"`
+}
+
+type UserSubscribedEvent struct {
+	UserID      int `json:"user_id"`
+	SubredditID int `json:"subreddit_id"`
+}
+
+type UserUnsubscribedEvent struct {
+	UserID      int `json:"user_id"`
+	SubredditID int `json:"subreddit_id"`
+}
+
+type MessageSentEvent struct {
+	MessageID  int `json:"message_id"`
+	FromUserID int `json:"from_user_id"`
+	ToUserID   int `json:"to_user_id"`
+}
+
+func (PostCreatedEvent) EventType() string      { return "post_created" }
+func (CommentCreatedEvent) EventType() string   { return "comment_created" }
+func (VoteCastEvent) EventType() string         { return "vote_cast" }
+func (UserSubscribedEvent) EventType() string   { return "user_subscribed" }
+func (UserUnsubscribedEvent) EventType() string { return "user_unsubscribed" }
+func (MessageSentEvent) EventType() string      { return "message_sent" }
+
+// EventEnvelope is an emitted event as sinks receive and serialize it
+type EventEnvelope struct {
+	ID         string      `json:"id"` // unique within a server run
+	Type       string      `json:"type"`
+	OccurredAt time.Time   `json:"occurred_at"`
+	Data       DomainEvent `json:"data"`
+}
+
+// EventSink receives every event emitted on the bus it's subscribed to
+type EventSink interface {
+	Name() string
+	Handle(event EventEnvelope) error
+}
+
+// eventBusBuffer is how many events can wait for a sink before new ones are
+// dropped for it
+const eventBusBuffer = 4096
+
+// EventBus delivers emitted events to its sinks
+type EventBus struct {
+	metrics *Metrics
+
+	mu    sync.RWMutex
+	sinks []*sinkQueue
+}
+
+// sinkQueue is a sink with the events waiting for it
+type sinkQueue struct {
+	sink  EventSink
+	queue chan EventEnvelope
+}
+
+func NewEventBus(metrics *Metrics) *EventBus {
+	return &EventBus{metrics: metrics}
+}
+
+// eventIDPrefix starts the IDs of the events of this process, which are
+// numbered by eventSeq
+var (
+	eventIDPrefix = strconv.FormatInt(time.Now().UnixNano(), 36)
+	eventSeq      int64
+)
+
+// newEventEnvelope wraps an event that just happened with a new ID
+func newEventEnvelope(event DomainEvent) EventEnvelope {
+	return EventEnvelope{
+		ID:         fmt.Sprintf("%s-%d", eventIDPrefix, atomic.AddInt64(&eventSeq, 1)),
+		Type:       event.EventType(),
+		OccurredAt: time.Now().UTC(),
+		Data:       event,
+	}
+}
+
+// Subscribe adds a sink, which receives the events emitted from then on.
+// Sinks must be subscribed before Run.
+func (b *EventBus) Subscribe(sink EventSink) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.sinks = append(b.sinks, &sinkQueue{sink: sink, queue: make(chan EventEnvelope, eventBusBuffer)})
+}
+
+// Emit queues an event for each sink without waiting for them. A sink that
+// has fallen too far behind misses the event; the others still get it.
+func (b *EventBus) Emit(event DomainEvent) {
+	envelope := newEventEnvelope(event)
+	b.metrics.Inc(fmt.Sprintf(`goreddit_domain_events_total{type=%q}`, envelope.Type))
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, s := range b.sinks {
+		select {
+		case s.queue <- envelope:
+		default:
+			b.metrics.Inc(fmt.Sprintf(`goreddit_domain_events_dropped_total{sink=%q}`, s.sink.Name()))
+			logAt(logWarn, "Dropping %s event, the %s event sink is behind", envelope.Type, s.sink.Name())
+		}
+	}
+}
+
+// Run delivers queued events to the sinks, each on its own goroutine, until
+// the process exits
+func (b *EventBus) Run() {
+	b.mu.RLock()
+	sinks := b.sinks
+	b.mu.RUnlock()
+
+	var wg sync.WaitGroup
+	for _, s := range sinks {
+		wg.Add(1)
+		go func(s *sinkQueue) {
+			defer wg.Done()
+			for event := range s.queue {
+				b.handle(s.sink, event)
+			}
+		}(s)
+	}
+	wg.Wait()
+}
+
+func (b *EventBus) handle(sink EventSink, event EventEnvelope) {
+	if err := sink.Handle(event); err != nil {
+		b.metrics.Inc(fmt.Sprintf(`goreddit_domain_event_sink_errors_total{sink=%q}`, sink.Name()))
+		log.Printf("Event sink %s failed on %s event %s: %v", sink.Name(), event.Type, event.ID, err)
+	}
+}
+
+// emitCommandEvents emits the events of a command processed by the actor
+// pool
+func (h *APIHandler) emitCommandEvents(cmd Command, result interface{}) {
+	switch cmd := cmd.(type) {
+	case CreatePostCommand:
+		if created, ok := result.(PostCreated); ok {
+			h.events.Emit(PostCreatedEvent{
+				PostID:      created.PostID,
+				AuthorID:    cmd.UserID,
+				SubredditID: cmd.SubredditID,
+				Title:       cmd.Title,
+				Removed:     created.Automod.Removed,
+			})
+		}
+	case CreateCommentCommand:
+		if created, ok := result.(CommentCreated); ok {
+			h.events.Emit(CommentCreatedEvent{
+				CommentID:       created.CommentID,
+				PostID:          cmd.PostID,
+				ParentCommentID: cmd.ParentCommentID,
+				AuthorID:        cmd.UserID,
+				Removed:         created.Automod.Removed,
+			})
+		}
+	case SendMessageCommand:
+		if sent, ok := result.(MessageSent); ok {
+			h.events.Emit(MessageSentEvent{MessageID: sent.MessageID, FromUserID: cmd.UserID, ToUserID: cmd.ToUserID})
+		}
+	case VoteCommand:
+		h.events.Emit(VoteCastEvent{UserID: cmd.UserID, TargetID: cmd.TargetID, TargetType: cmd.TargetType, Value: cmd.Value})
+	case VoteBatchCommand:
+		for _, vote := range cmd.Votes {
+			h.events.Emit(VoteCastEvent{UserID: cmd.UserID, TargetID: vote.TargetID, TargetType: vote.TargetType, Value: vote.Value})
+		}
+	case JoinSubredditCommand:
+		h.events.Emit(UserSubscribedEvent{UserID: cmd.UserID, SubredditID: cmd.SubredditID})
+	case LeaveSubredditCommand:
+		h.events.Emit(UserUnsubscribedEvent{UserID: cmd.UserID, SubredditID: cmd.SubredditID})
+	}
+}
+
+// realtimeSink pushes the real-time events that follow writes to users'
+// WebSocket and SSE connections
+type realtimeSink struct {
+	h *APIHandler
+}
+
+func (realtimeSink) Name() string { return "realtime" }
+
+func (s realtimeSink) Handle(event EventEnvelope) error {
+	switch e := event.Data.(type) {
+	case PostCreatedEvent, MessageSentEvent:
+		s.h.publishNotifications()
+	case CommentCreatedEvent:
+		s.h.publishNotifications()
+		if !e.Removed {
+			s.h.hub.Publish(postTopic(e.PostID), "comment", map[string]int{"comment_id": e.CommentID})
+		}
+	case VoteCastEvent:
+		if e.Value == 1 {
+			s.h.publishVoteMilestone(e.UserID, e.TargetID, e.TargetType)
+		}
+	}
+	return nil
+}
+
+// logSink writes every event to the server log
+type logSink struct{}
+
+func (logSink) Name() string { return "log" }
+
+func (logSink) Handle(event EventEnvelope) error {
+	data, err := json.Marshal(event.Data)
+	if err != nil {
+		return err
+	}
+	log.Printf("Event %s %s: %s", event.ID, event.Type, data)
+	return nil
+}
+
+// webhookSink POSTs every event to a URL, signed like moderation webhooks
+// (see signModWebhook)
+type webhookSink struct {
+	url    string
+	secret string
+	client *http.Client
+}
+
+func newWebhookSink(url, secret string) *webhookSink {
+	return &webhookSink{url: url, secret: secret, client: &http.Client{Timeout: webhookTimeout}}
+}
+
+func (*webhookSink) Name() string { return "webhook" }
+
+func (s *webhookSink) Handle(event EventEnvelope) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-GoReddit-Event", event.Type)
+	req.Header.Set("X-GoReddit-Delivery", event.ID)
+	req.Header.Set("X-GoReddit-Timestamp", timestamp)
+	req.Header.Set("X-GoReddit-Signature", signModWebhook(s.secret, timestamp, body))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,763 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+)
+
+// GraphQL API
+//
+// /graphql serves the same data as the REST API through one schema: posts,
+// comments, subreddits and users with nested fields (post -> comments ->
+// author), and mutations mirroring the REST writes. Resolvers read through a
+// per-request graphqlLoader, so a user or a post's comments are loaded once
+// however many times a query refers to them.
+
+// graphqlLoader caches what one GraphQL request has loaded
+type graphqlLoader struct {
+	h      *APIHandler
+	db     *DatabaseManager // traced as part of the request
+	userID int              // the authenticated user
+
+	mu         sync.Mutex
+	users      map[int]*User
+	subreddits map[int]*Subreddit
+	comments   map[int][]*Comment // by post
+}
+
+type graphqlLoaderKey struct{}
+
+func loaderOf(p graphql.ResolveParams) *graphqlLoader {
+	return p.Context.Value(graphqlLoaderKey{}).(*graphqlLoader)
+}
+
+func (l *graphqlLoader) user(userID int) (*User, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if user, ok := l.users[userID]; ok {
+		return user, nil
+	}
+	user, err := l.db.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	l.users[userID] = user
+	return user, nil
+}
+
+func (l *graphqlLoader) subreddit(subredditID int) (*Subreddit, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if subreddit, ok := l.subreddits[subredditID]; ok {
+		return subreddit, nil
+	}
+	subreddit, err := l.db.GetSubreddit(subredditID)
+	if err != nil {
+		return nil, err
+	}
+	l.subreddits[subredditID] = subreddit
+	return subreddit, nil
+}
+
+// postComments returns a post's visible comments, oldest first
+func (l *graphqlLoader) postComments(postID int) ([]*Comment, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if comments, ok := l.comments[postID]; ok {
+		return comments, nil
+	}
+	loaded, err := l.db.GetCommentsSince(postID, 0, l.userID)
+	if err != nil {
+		return nil, err
+	}
+	comments := make([]*Comment, len(loaded))
+	for i := range loaded {
+		comments[i] = &loaded[i]
+	}
+	l.comments[postID] = comments
+	return comments, nil
+}
+
+// replies returns the visible comments directly under parentID on a post, or
+// its top-level comments when parentID is nil, ordered by sortBy
+func (l *graphqlLoader) replies(postID int, parentID *int, sortBy string) ([]*Comment, error) {
+	comments, err := l.postComments(postID)
+	if err != nil {
+		return nil, err
+	}
+	var level []Comment
+	for _, comment := range comments {
+		if (parentID == nil && comment.ParentCommentID == nil) ||
+			(parentID != nil && comment.ParentCommentID != nil && *comment.ParentCommentID == *parentID) {
+			level = append(level, *comment)
+		}
+	}
+	if err := sortComments(level, sortBy); err != nil {
+		return nil, err
+	}
+
+	replies := make([]*Comment, len(level))
+	for i := range level {
+		replies[i] = &level[i]
+	}
+	return replies, nil
+}
+
+// commentSortArgs are the arguments of comment list fields; comments are
+// oldest first by default
+var commentSortArgs = graphql.FieldConfigArgument{
+	"sort": &graphql.ArgumentConfig{Type: graphql.String, DefaultValue: "old"},
+}
+
+// allowWrite applies the write rate limit to a mutation. POST /graphql is
+// exempt from the route-level limit so that queries aren't counted.
+func (l *graphqlLoader) allowWrite() error {
+	status := l.h.limiter.Allow(strconv.Itoa(l.userID), time.Now())
+	if !status.Allowed {
+		l.h.metrics.Inc("goreddit_rate_limited_total")
+		return fmt.Errorf("rate limit exceeded, retry in %ds", int(status.RetryAfter.Seconds())+1)
+	}
+	return nil
+}
+
+// Argument helpers. graphql-go has already checked argument types against
+// the schema.
+
+func intArg(p graphql.ResolveParams, name string) int {
+	value, _ := p.Args[name].(int)
+	return value
+}
+
+func optionalIntArg(p graphql.ResolveParams, name string) *int {
+	if value, ok := p.Args[name].(int); ok {
+		return &value
+	}
+	return nil
+}
+
+func stringArg(p graphql.ResolveParams, name string) string {
+	value, _ := p.Args[name].(string)
+	return value
+}
+
+// pageArgs returns the limit and offset arguments of a list field
+func pageArgs(p graphql.ResolveParams) (int, int) {
+	limit, offset := intArg(p, "limit"), intArg(p, "offset")
+	if limit < 1 || limit > maxPageSize {
+		limit = defaultPageSize
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	return limit, offset
+}
+
+var pageArguments = graphql.FieldConfigArgument{
+	"limit":  &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: defaultPageSize},
+	"offset": &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: 0},
+}
+
+func postPointers(posts []Post) []*Post {
+	pointers := make([]*Post, len(posts))
+	for i := range posts {
+		pointers[i] = &posts[i]
+	}
+	return pointers
+}
+
+// field builds a field resolved from its source object
+func field[T any](t graphql.Output, resolve func(*T) interface{}) *graphql.Field {
+	return &graphql.Field{Type: t, Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+		return resolve(p.Source.(*T)), nil
+	}}
+}
+
+// newGraphQLSchema builds the GraphQL schema
+func newGraphQLSchema() (graphql.Schema, error) {
+	var postType, commentType, subredditType, userType *graphql.Object
+
+	userType = graphql.NewObject(graphql.ObjectConfig{
+		Name: "User",
+		Fields: graphql.FieldsThunk(func() graphql.Fields {
+			return graphql.Fields{
+				"id":       field(graphql.NewNonNull(graphql.Int), func(u *User) interface{} { id, _ := strconv.Atoi(u.ID); return id }),
+				"username": field(graphql.NewNonNull(graphql.String), func(u *User) interface{} { return u.Username }),
+				"karma":    field(graphql.NewNonNull(graphql.Int), func(u *User) interface{} { return u.Karma }),
+				"posts": &graphql.Field{
+					Type: graphql.NewList(graphql.NewNonNull(postType)),
+					Args: graphql.FieldConfigArgument{
+						"limit": &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: defaultPageSize},
+					},
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						limit, _ := pageArgs(p)
+						posts, err := loaderOf(p).db.GetRecentUserPosts(p.Source.(*User).Username, limit)
+						return postPointers(posts), err
+					},
+				},
+			}
+		}),
+	})
+
+	subredditType = graphql.NewObject(graphql.ObjectConfig{
+		Name: "Subreddit",
+		Fields: graphql.FieldsThunk(func() graphql.Fields {
+			return graphql.Fields{
+				"id":          field(graphql.NewNonNull(graphql.Int), func(s *Subreddit) interface{} { return s.ID }),
+				"name":        field(graphql.NewNonNull(graphql.String), func(s *Subreddit) interface{} { return s.Name }),
+				"description": field(graphql.NewNonNull(graphql.String), func(s *Subreddit) interface{} { return s.Description }),
+				"createdAt":   field(graphql.NewNonNull(graphql.DateTime), func(s *Subreddit) interface{} { return s.CreatedAt }),
+				"memberCount": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.Int),
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						about, err := loaderOf(p).db.GetSubredditAbout(p.Source.(*Subreddit).Name)
+						if err != nil {
+							return nil, err
+						}
+						return about.MemberCount, nil
+					},
+				},
+				"posts": &graphql.Field{
+					Type: graphql.NewList(graphql.NewNonNull(postType)),
+					Args: graphql.FieldConfigArgument{
+						"sort":   &graphql.ArgumentConfig{Type: graphql.String},
+						"limit":  pageArguments["limit"],
+						"offset": pageArguments["offset"],
+					},
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return loaderOf(p).subredditPosts(p.Source.(*Subreddit).ID, p)
+					},
+				},
+			}
+		}),
+	})
+
+	postType = graphql.NewObject(graphql.ObjectConfig{
+		Name: "Post",
+		Fields: graphql.FieldsThunk(func() graphql.Fields {
+			return graphql.Fields{
+				"id":        field(graphql.NewNonNull(graphql.Int), func(p *Post) interface{} { return p.ID }),
+				"title":     field(graphql.NewNonNull(graphql.String), func(p *Post) interface{} { return p.Title }),
+				"content":   field(graphql.NewNonNull(graphql.String), func(p *Post) interface{} { return p.Content }),
+				"flair":     field(graphql.String, func(p *Post) interface{} { return p.Flair }),
+				"pinned":    field(graphql.NewNonNull(graphql.Boolean), func(p *Post) interface{} { return p.Pinned }),
+				"kind":      field(graphql.NewNonNull(graphql.String), func(p *Post) interface{} { return p.Kind }),
+				"createdAt": field(graphql.NewNonNull(graphql.DateTime), func(p *Post) interface{} { return p.CreatedAt }),
+				"editedAt":  field(graphql.DateTime, func(p *Post) interface{} { return p.EditedAt }),
+				"upvotes":   field(graphql.NewNonNull(graphql.Int), func(p *Post) interface{} { return p.VoteCount.Upvotes }),
+				"downvotes": field(graphql.NewNonNull(graphql.Int), func(p *Post) interface{} { return p.VoteCount.Downvotes }),
+				"score":     field(graphql.NewNonNull(graphql.Int), func(p *Post) interface{} { return netScore(*p) }),
+				"author": &graphql.Field{
+					Type: graphql.NewNonNull(userType),
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return loaderOf(p).user(p.Source.(*Post).AuthorID)
+					},
+				},
+				"subreddit": &graphql.Field{
+					Type: graphql.NewNonNull(subredditType),
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return loaderOf(p).subreddit(p.Source.(*Post).SubredditID)
+					},
+				},
+				"commentCount": field(graphql.NewNonNull(graphql.Int), func(p *Post) interface{} { return p.CommentCount }),
+				"comments": &graphql.Field{
+					Type:        graphql.NewList(graphql.NewNonNull(commentType)),
+					Description: "Top-level comments ordered by sort (top, best, new or old). Replies are nested under each comment.",
+					Args:        commentSortArgs,
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return loaderOf(p).replies(p.Source.(*Post).ID, nil, stringArg(p, "sort"))
+					},
+				},
+			}
+		}),
+	})
+
+	commentType = graphql.NewObject(graphql.ObjectConfig{
+		Name: "Comment",
+		Fields: graphql.FieldsThunk(func() graphql.Fields {
+			return graphql.Fields{
+				"id":        field(graphql.NewNonNull(graphql.Int), func(c *Comment) interface{} { return c.ID }),
+				"content":   field(graphql.NewNonNull(graphql.String), func(c *Comment) interface{} { return c.Content }),
+				"createdAt": field(graphql.NewNonNull(graphql.DateTime), func(c *Comment) interface{} { return c.CreatedAt }),
+				"editedAt":  field(graphql.DateTime, func(c *Comment) interface{} { return c.EditedAt }),
+				"score":     field(graphql.NewNonNull(graphql.Int), func(c *Comment) interface{} { return c.Votes }),
+				"userVote":  field(graphql.Int, func(c *Comment) interface{} { return c.UserVote }),
+				"collapsed": field(graphql.NewNonNull(graphql.Boolean), func(c *Comment) interface{} { return c.Collapsed }),
+				"author": &graphql.Field{
+					Type: graphql.NewNonNull(userType),
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return loaderOf(p).user(p.Source.(*Comment).AuthorID)
+					},
+				},
+				"post": &graphql.Field{
+					Type: postType,
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return loaderOf(p).db.GetPost(p.Source.(*Comment).PostID)
+					},
+				},
+				"parentId": field(graphql.Int, func(c *Comment) interface{} { return c.ParentCommentID }),
+				"replies": &graphql.Field{
+					Type: graphql.NewList(graphql.NewNonNull(commentType)),
+					Args: commentSortArgs,
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						comment := p.Source.(*Comment)
+						return loaderOf(p).replies(comment.PostID, &comment.ID, stringArg(p, "sort"))
+					},
+				},
+			}
+		}),
+	})
+
+	automodType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "AutomodOutcome",
+		Fields: graphql.Fields{
+			"removed": field(graphql.NewNonNull(graphql.Boolean), func(a *AutomodOutcome) interface{} { return a.Removed }),
+			"flagged": field(graphql.NewNonNull(graphql.Boolean), func(a *AutomodOutcome) interface{} { return a.Flagged }),
+			"flair":   field(graphql.String, func(a *AutomodOutcome) interface{} { return a.Flair }),
+		},
+	})
+
+	queryType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"me": &graphql.Field{
+				Type: graphql.NewNonNull(userType),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return loaderOf(p).user(loaderOf(p).userID)
+				},
+			},
+			"user": &graphql.Field{
+				Type: userType,
+				Args: graphql.FieldConfigArgument{
+					"username": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return loaderOf(p).db.GetUserByUsername(stringArg(p, "username"))
+				},
+			},
+			"subreddit": &graphql.Field{
+				Type: subredditType,
+				Args: graphql.FieldConfigArgument{
+					"name": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					l := loaderOf(p)
+					subredditID, err := l.db.GetSubredditIDByName(stringArg(p, "name"))
+					if err != nil {
+						return nil, err
+					}
+					return l.subreddit(subredditID)
+				},
+			},
+			"subreddits": &graphql.Field{
+				Type: graphql.NewList(graphql.NewNonNull(subredditType)),
+				Args: pageArguments,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					subreddits, err := loaderOf(p).db.GetAllSubreddits()
+					if err != nil {
+						return nil, err
+					}
+					limit, offset := pageArgs(p)
+					page := []*Subreddit{}
+					for i := offset; i < len(subreddits) && i < offset+limit; i++ {
+						page = append(page, &subreddits[i])
+					}
+					return page, nil
+				},
+			},
+			"post": &graphql.Field{
+				Type: postType,
+				Args: graphql.FieldConfigArgument{
+					"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					l := loaderOf(p)
+					post, err := l.db.GetPost(intArg(p, "id"))
+					if err != nil {
+						return nil, err
+					}
+					recordPostView(l.db, post, l.userID)
+					return post, nil
+				},
+			},
+			"posts": &graphql.Field{
+				Type:        graphql.NewList(graphql.NewNonNull(postType)),
+				Description: "Posts across every subreddit, ranked by sort (hot by default)",
+				Args: graphql.FieldConfigArgument{
+					"sort":   &graphql.ArgumentConfig{Type: graphql.String, DefaultValue: defaultRanking},
+					"limit":  pageArguments["limit"],
+					"offset": pageArguments["offset"],
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return loaderOf(p).subredditPosts(0, p)
+				},
+			},
+			"comment": &graphql.Field{
+				Type: commentType,
+				Args: graphql.FieldConfigArgument{
+					"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return loaderOf(p).db.GetComment(intArg(p, "id"), loaderOf(p).userID)
+				},
+			},
+		},
+	})
+
+	createdPostType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "CreatePostResult",
+		Fields: graphql.Fields{
+			"id":      field(graphql.NewNonNull(graphql.Int), func(r *createdContent) interface{} { return r.ID }),
+			"post":    &graphql.Field{Type: postType, Resolve: func(p graphql.ResolveParams) (interface{}, error) { return p.Source.(*createdContent).Post, nil }},
+			"automod": field(graphql.NewNonNull(automodType), func(r *createdContent) interface{} { return &r.Automod }),
+		},
+	})
+	createdCommentType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "CreateCommentResult",
+		Fields: graphql.Fields{
+			"id":      field(graphql.NewNonNull(graphql.Int), func(r *createdContent) interface{} { return r.ID }),
+			"comment": &graphql.Field{Type: commentType, Resolve: func(p graphql.ResolveParams) (interface{}, error) { return p.Source.(*createdContent).Comment, nil }},
+			"automod": field(graphql.NewNonNull(automodType), func(r *createdContent) interface{} { return &r.Automod }),
+		},
+	})
+	voteResultType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "VoteResult",
+		Fields: graphql.Fields{
+			"targetType": field(graphql.NewNonNull(graphql.String), func(v *VoteRequest) interface{} { return v.TargetType }),
+			"targetId":   field(graphql.NewNonNull(graphql.Int), func(v *VoteRequest) interface{} { return v.TargetID }),
+			"value":      field(graphql.NewNonNull(graphql.Int), func(v *VoteRequest) interface{} { return v.Value }),
+		},
+	})
+
+	mutationType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Mutation",
+		Fields: graphql.Fields{
+			"createSubreddit": &graphql.Field{
+				Type: graphql.NewNonNull(subredditType),
+				Args: graphql.FieldConfigArgument{
+					"name":        &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+					"description": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+				},
+				Resolve: mutation(func(l *graphqlLoader, p graphql.ResolveParams) (interface{}, error) {
+					subredditID, err := l.db.CreateSubreddit(stringArg(p, "name"), stringArg(p, "description"), l.userID)
+					if err != nil {
+						return nil, err
+					}
+					return l.subreddit(subredditID)
+				}),
+			},
+			"joinSubreddit": &graphql.Field{
+				Type: graphql.NewNonNull(subredditType),
+				Args: graphql.FieldConfigArgument{
+					"subredditId": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)},
+				},
+				Resolve: mutation(func(l *graphqlLoader, p graphql.ResolveParams) (interface{}, error) {
+					subredditID := intArg(p, "subredditId")
+					if err := l.db.JoinSubreddit(l.userID, subredditID); err != nil {
+						return nil, err
+					}
+					l.h.events.Emit(UserSubscribedEvent{UserID: l.userID, SubredditID: subredditID})
+					return l.subreddit(subredditID)
+				}),
+			},
+			"leaveSubreddit": &graphql.Field{
+				Type: graphql.NewNonNull(subredditType),
+				Args: graphql.FieldConfigArgument{
+					"subredditId": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)},
+				},
+				Resolve: mutation(func(l *graphqlLoader, p graphql.ResolveParams) (interface{}, error) {
+					subredditID := intArg(p, "subredditId")
+					if err := l.db.LeaveSubreddit(l.userID, subredditID); err != nil {
+						return nil, err
+					}
+					l.h.events.Emit(UserUnsubscribedEvent{UserID: l.userID, SubredditID: subredditID})
+					return l.subreddit(subredditID)
+				}),
+			},
+			"createPost": &graphql.Field{
+				Type: graphql.NewNonNull(createdPostType),
+				Args: graphql.FieldConfigArgument{
+					"subredditId": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)},
+					"title":       &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+					"content":     &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+					"kind":        &graphql.ArgumentConfig{Type: graphql.String, Description: "text (the default), link, image or poll"},
+					"crosspostOf": &graphql.ArgumentConfig{Type: graphql.Int},
+				},
+				Resolve: mutation(func(l *graphqlLoader, p graphql.ResolveParams) (interface{}, error) {
+					title, subredditID := stringArg(p, "title"), intArg(p, "subredditId")
+					postID, automod, err := l.db.CreatePost(title, stringArg(p, "content"), l.userID, subredditID,
+						stringArg(p, "kind"), optionalIntArg(p, "crosspostOf"))
+					if err != nil {
+						return nil, err
+					}
+					l.h.events.Emit(PostCreatedEvent{PostID: postID, AuthorID: l.userID, SubredditID: subredditID, Title: title, Removed: automod.Removed})
+
+					result := &createdContent{ID: postID, Automod: automod}
+					if !automod.Removed {
+						if result.Post, err = l.db.GetPost(postID); err != nil {
+							return nil, err
+						}
+					}
+					return result, nil
+				}),
+			},
+			"createComment": &graphql.Field{
+				Type: graphql.NewNonNull(createdCommentType),
+				Args: graphql.FieldConfigArgument{
+					"postId":          &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)},
+					"parentCommentId": &graphql.ArgumentConfig{Type: graphql.Int},
+					"content":         &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+				},
+				Resolve: mutation(func(l *graphqlLoader, p graphql.ResolveParams) (interface{}, error) {
+					postID, parentID := intArg(p, "postId"), optionalIntArg(p, "parentCommentId")
+					commentID, automod, err := l.db.CreateComment(stringArg(p, "content"), l.userID, postID, parentID)
+					if err != nil {
+						return nil, err
+					}
+					l.h.events.Emit(CommentCreatedEvent{CommentID: commentID, PostID: postID, ParentCommentID: parentID, AuthorID: l.userID, Removed: automod.Removed})
+
+					result := &createdContent{ID: commentID, Automod: automod}
+					if !automod.Removed {
+						if result.Comment, err = l.db.GetComment(commentID, l.userID); err != nil {
+							return nil, err
+						}
+					}
+					return result, nil
+				}),
+			},
+			"editPost": &graphql.Field{
+				Type: graphql.NewNonNull(postType),
+				Args: graphql.FieldConfigArgument{
+					"id":      &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)},
+					"content": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+				},
+				Resolve: mutation(func(l *graphqlLoader, p graphql.ResolveParams) (interface{}, error) {
+					return l.db.EditPost(intArg(p, "id"), l.userID, stringArg(p, "content"), l.h.editGracePeriod())
+				}),
+			},
+			"editComment": &graphql.Field{
+				Type: graphql.NewNonNull(commentType),
+				Args: graphql.FieldConfigArgument{
+					"id":      &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)},
+					"content": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+				},
+				Resolve: mutation(func(l *graphqlLoader, p graphql.ResolveParams) (interface{}, error) {
+					return l.db.EditComment(intArg(p, "id"), l.userID, stringArg(p, "content"), l.h.editGracePeriod())
+				}),
+			},
+			"vote": &graphql.Field{
+				Type:        graphql.NewNonNull(voteResultType),
+				Description: "Vote on a post or comment. Like POST /vote, nonce must be unique and timestamp within 5 minutes of the server clock.",
+				Args: graphql.FieldConfigArgument{
+					"targetType": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+					"targetId":   &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)},
+					"value":      &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)},
+					"nonce":      &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+					"timestamp":  &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)},
+				},
+				Resolve: mutation(func(l *graphqlLoader, p graphql.ResolveParams) (interface{}, error) {
+					vote := &VoteRequest{
+						TargetType: stringArg(p, "targetType"),
+						TargetID:   intArg(p, "targetId"),
+						Value:      intArg(p, "value"),
+						Nonce:      stringArg(p, "nonce"),
+						Timestamp:  int64(intArg(p, "timestamp")),
+					}
+					if vote.TargetType != "post" && vote.TargetType != "comment" {
+						return nil, fmt.Errorf("targetType must be post or comment")
+					}
+					if vote.Value != 1 && vote.Value != -1 {
+						return nil, fmt.Errorf("value must be 1 or -1")
+					}
+					if vote.Nonce == "" || len(vote.Nonce) > 128 {
+						return nil, fmt.Errorf("nonce must be 1 to 128 characters")
+					}
+					skew := time.Since(time.Unix(vote.Timestamp, 0))
+					if skew > voteMaxClockSkew || skew < -voteMaxClockSkew {
+						l.h.metrics.Inc(`goreddit_vote_replays_rejected_total{reason="stale"}`)
+						return nil, fmt.Errorf("vote timestamp is outside the accepted window")
+					}
+
+					err := l.db.Vote(l.userID, vote.TargetID, vote.TargetType, vote.Value, vote.Nonce)
+					if errors.Is(err, ErrVoteReplay) {
+						l.h.metrics.Inc(`goreddit_vote_replays_rejected_total{reason="nonce"}`)
+					}
+					if err != nil {
+						return nil, err
+					}
+					l.h.events.Emit(VoteCastEvent{UserID: l.userID, TargetID: vote.TargetID, TargetType: vote.TargetType, Value: vote.Value})
+					return vote, nil
+				}),
+			},
+			"sendMessage": &graphql.Field{
+				Type:        graphql.NewNonNull(graphql.Int),
+				Description: "Send a direct message, returning its ID",
+				Args: graphql.FieldConfigArgument{
+					"toUserId": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)},
+					"content":  &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+				},
+				Resolve: mutation(func(l *graphqlLoader, p graphql.ResolveParams) (interface{}, error) {
+					toUserID := intArg(p, "toUserId")
+					messageID, err := l.db.SendDirectMessage(l.userID, toUserID, stringArg(p, "content"))
+					if err != nil {
+						return nil, err
+					}
+					l.h.events.Emit(MessageSentEvent{MessageID: messageID, FromUserID: l.userID, ToUserID: toUserID})
+					return messageID, nil
+				}),
+			},
+		},
+	})
+
+	return graphql.NewSchema(graphql.SchemaConfig{Query: queryType, Mutation: mutationType})
+}
+
+// createdContent is the result of createPost and createComment. The post or
+// comment is nil when automod removed it.
+type createdContent struct {
+	ID      int
+	Post    *Post
+	Comment *Comment
+	Automod AutomodOutcome
+}
+
+// mutation wraps a mutation resolver with the write rate limit
+func mutation(resolve func(*graphqlLoader, graphql.ResolveParams) (interface{}, error)) graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (interface{}, error) {
+		l := loaderOf(p)
+		if err := l.allowWrite(); err != nil {
+			return nil, err
+		}
+		return resolve(l, p)
+	}
+}
+
+// subredditPosts returns a page of a subreddit's posts, or of all posts when
+// subredditID is 0, ranked by the sort argument. Subreddits default to their
+// own ranking settings and keep pinned posts on top, like their REST feed.
+func (l *graphqlLoader) subredditPosts(subredditID int, p graphql.ResolveParams) ([]*Post, error) {
+	var posts []Post
+	var err error
+	sortBy := stringArg(p, "sort")
+	params := RankingParams{HalfLifeHours: defaultHalfLifeHours}
+
+	if subredditID == 0 {
+		posts, err = l.db.GetAllPosts(l.userID)
+	} else {
+		var settings *SubredditSettings
+		if settings, err = l.db.GetSubredditSettings(subredditID); err != nil {
+			return nil, err
+		}
+		if sortBy == "" {
+			sortBy = settings.DefaultSort
+		}
+		params.HalfLifeHours = settings.HalfLifeHours
+		posts, err = l.db.GetSubredditPosts(subredditID, allPosts, 0)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if sortBy == "" {
+		sortBy = defaultRanking
+	}
+	if err := l.h.rankPosts(posts, sortBy, params); err != nil {
+		return nil, err
+	}
+	if subredditID != 0 {
+		sort.SliceStable(posts, func(i, j int) bool { return posts[i].Pinned && !posts[j].Pinned })
+	}
+
+	limit, offset := pageArgs(p)
+	return postPointers(paginatePosts(posts, limit, offset).Posts), nil
+}
+
+// GraphQLRequest is a GraphQL query and its variables, as sent by GraphQL
+// clients
+type GraphQLRequest struct {
+	Query         string                 `json:"query" binding:"required"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// GraphQLResponse is the result of a GraphQL request
+type GraphQLResponse struct {
+	Data   interface{}    `json:"data"`
+	Errors []GraphQLError `json:"errors,omitempty"`
+}
+
+// GraphQLError is an error in a GraphQL response, with the message and code
+// the REST API would answer it with
+type GraphQLError struct {
+	Message string `json:"message"`
+	Code    string `json:"code"`
+}
+
+// graphqlErrors classifies the errors of a GraphQL request like
+// errorResponses does, logging server errors with their cause. Errors in the
+// query itself, which no resolver returned, are the client's.
+func graphqlErrors(errs []gqlerrors.FormattedError) []GraphQLError {
+	var classified []GraphQLError
+	for _, e := range errs {
+		err := e.OriginalError()
+		var located *gqlerrors.Error
+		if errors.As(err, &located) {
+			err = located.OriginalError
+		}
+		if err == nil {
+			classified = append(classified, GraphQLError{Message: e.Message, Code: codeInvalidRequest})
+			continue
+		}
+
+		apiErr := classifyError(err)
+		if apiErr.Status >= http.StatusInternalServerError {
+			logAt(logError, "GraphQL request failed: %v", err)
+		}
+		classified = append(classified, GraphQLError{Message: apiErr.Message, Code: apiErr.Code})
+	}
+	return classified
+}
+
+// serveGraphQL executes a GraphQL request as the authenticated user.
+// Failures inside the query are reported in the response's errors, with
+// status 200, as GraphQL clients expect.
+func (h *APIHandler) serveGraphQL(c *gin.Context) {
+	var req GraphQLRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(withStatus(http.StatusBadRequest, err))
+		return
+	}
+
+	userID, _ := strconv.Atoi(c.GetString("user_id"))
+	loader := &graphqlLoader{
+		h:          h,
+		db:         h.dbFor(c),
+		userID:     userID,
+		users:      make(map[int]*User),
+		subreddits: make(map[int]*Subreddit),
+		comments:   make(map[int][]*Comment),
+	}
+
+	result := graphql.Do(graphql.Params{
+		Schema:         h.graphql,
+		RequestString:  req.Query,
+		OperationName:  req.OperationName,
+		VariableValues: req.Variables,
+		Context:        context.WithValue(c.Request.Context(), graphqlLoaderKey{}, loader),
+	})
+	if result.HasErrors() {
+		h.metrics.Inc(`goreddit_graphql_requests_total{result="error"}`)
+	} else {
+		h.metrics.Inc(`goreddit_graphql_requests_total{result="ok"}`)
+	}
+
+	c.JSON(http.StatusOK, GraphQLResponse{Data: result.Data, Errors: graphqlErrors(result.Errors)})
+}
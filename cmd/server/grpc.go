@@ -0,0 +1,330 @@
+//go:build grpc
+
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/ArjunKaliyath/GoReddit/internal/redditpb"
+)
+
+// gRPC API
+//
+// RedditService (proto/goreddit/v1/reddit.proto) is served on GRPC_ADDR next
+// to the REST API, on the same DatabaseManager. Calls authenticate with a
+// session token, and writes have the same side effects and rate limit as
+// their REST counterparts.
+
+// grpcServer implements redditpb.RedditServiceServer
+type grpcServer struct {
+	redditpb.UnimplementedRedditServiceServer
+	h *APIHandler
+}
+
+type grpcUserKey struct{}
+
+// grpcUserID returns the authenticated caller of an RPC
+func grpcUserID(ctx context.Context) int {
+	userID, _ := ctx.Value(grpcUserKey{}).(int)
+	return userID
+}
+
+// grpcAuth authenticates every call with the session token in its
+// authorization metadata. Impersonation sessions can't be used, since their
+// writes must go through the audit logged REST API.
+func (s *grpcServer) grpcAuth(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get("authorization")
+	if len(values) == 0 || !strings.HasPrefix(values[0], "Bearer ") {
+		return nil, status.Error(codes.Unauthenticated, "session token required")
+	}
+
+	session, err := s.h.db.WithContext(ctx).GetSessionByToken(strings.TrimPrefix(values[0], "Bearer "))
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "Invalid, expired or revoked session")
+	}
+	if session.ImpersonatorID != nil {
+		return nil, status.Error(codes.PermissionDenied, "impersonation sessions can't use the gRPC API")
+	}
+
+	s.h.metrics.Inc(`goreddit_grpc_requests_total{method="` + info.FullMethod + `"}`)
+
+	ctx, span := tracer.Start(ctx, info.FullMethod, trace.WithSpanKind(trace.SpanKindServer))
+	resp, err := handler(context.WithValue(ctx, grpcUserKey{}, session.UserID), req)
+	endSpan(span, err)
+	return resp, err
+}
+
+// allowWrite applies the write rate limit to a call
+func (s *grpcServer) allowWrite(ctx context.Context) error {
+	rateStatus := s.h.limiter.Allow(strconv.Itoa(grpcUserID(ctx)), time.Now())
+	if !rateStatus.Allowed {
+		s.h.metrics.Inc("goreddit_rate_limited_total")
+		return status.Errorf(codes.ResourceExhausted, "rate limit exceeded, retry in %ds", int(rateStatus.RetryAfter.Seconds())+1)
+	}
+	return nil
+}
+
+// grpcError converts a data layer error to a gRPC status
+func grpcError(err error) error {
+	switch {
+	case errors.Is(err, ErrBannedFromSubreddit), errors.Is(err, ErrNotEnoughKarma):
+		return status.Error(codes.PermissionDenied, err.Error())
+	case errors.Is(err, ErrPostNotAllowed):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, ErrPostingTooFast):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	case errors.Is(err, ErrPostArchived), errors.Is(err, ErrSubredditArchived):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, ErrVoteReplay):
+		return status.Error(codes.AlreadyExists, err.Error())
+	case strings.Contains(err.Error(), "not found"):
+		return status.Error(codes.NotFound, err.Error())
+	default:
+		return status.Error(codes.Internal, err.Error())
+	}
+}
+
+func timestampOrNil(t *time.Time) *timestamppb.Timestamp {
+	if t == nil {
+		return nil
+	}
+	return timestamppb.New(*t)
+}
+
+func toPostProto(post Post) *redditpb.Post {
+	return &redditpb.Post{
+		Id:            int64(post.ID),
+		Title:         post.Title,
+		Content:       post.Content,
+		AuthorId:      int64(post.AuthorID),
+		AuthorName:    post.AuthorUsername,
+		SubredditId:   int64(post.SubredditID),
+		SubredditName: post.SubredditName,
+		Flair:         post.Flair,
+		Pinned:        post.Pinned,
+		CreatedAt:     timestamppb.New(post.CreatedAt),
+		EditedAt:      timestampOrNil(post.EditedAt),
+		Upvotes:       int64(post.VoteCount.Upvotes),
+		Downvotes:     int64(post.VoteCount.Downvotes),
+		CommentCount:  int64(post.CommentCount),
+	}
+}
+
+func toCommentProto(comment Comment) *redditpb.Comment {
+	pb := &redditpb.Comment{
+		Id:             int64(comment.ID),
+		Content:        comment.Content,
+		AuthorId:       int64(comment.AuthorID),
+		AuthorUsername: comment.AuthorUsername,
+		PostId:         int64(comment.PostID),
+		CreatedAt:      timestamppb.New(comment.CreatedAt),
+		EditedAt:       timestampOrNil(comment.EditedAt),
+		Score:          int64(comment.Votes),
+	}
+	if comment.ParentCommentID != nil {
+		parentID := int64(*comment.ParentCommentID)
+		pb.ParentCommentId = &parentID
+	}
+	return pb
+}
+
+func (s *grpcServer) CreatePost(ctx context.Context, req *redditpb.CreatePostRequest) (*redditpb.CreatePostResponse, error) {
+	if req.GetTitle() == "" || req.GetContent() == "" {
+		return nil, status.Error(codes.InvalidArgument, "title and content are required")
+	}
+	if err := s.allowWrite(ctx); err != nil {
+		return nil, err
+	}
+
+	userID, subredditID := grpcUserID(ctx), int(req.GetSubredditId())
+	postID, automod, err := s.h.db.WithContext(ctx).CreatePost(req.GetTitle(), req.GetContent(), userID, subredditID, req.GetKind(), nil)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	s.h.events.Emit(PostCreatedEvent{PostID: postID, AuthorID: userID, SubredditID: subredditID, Title: req.GetTitle(), Removed: automod.Removed})
+
+	return &redditpb.CreatePostResponse{
+		PostId:  int64(postID),
+		Automod: &redditpb.AutomodOutcome{Removed: automod.Removed, Flagged: automod.Flagged, Flair: automod.Flair},
+	}, nil
+}
+
+func (s *grpcServer) Vote(ctx context.Context, req *redditpb.VoteRequest) (*redditpb.VoteResponse, error) {
+	var targetType string
+	switch req.GetTargetType() {
+	case redditpb.TargetType_TARGET_TYPE_POST:
+		targetType = "post"
+	case redditpb.TargetType_TARGET_TYPE_COMMENT:
+		targetType = "comment"
+	default:
+		return nil, status.Error(codes.InvalidArgument, "target_type must be a post or comment")
+	}
+	if req.GetValue() != 1 && req.GetValue() != -1 {
+		return nil, status.Error(codes.InvalidArgument, "value must be 1 or -1")
+	}
+	if req.GetNonce() == "" || len(req.GetNonce()) > 128 {
+		return nil, status.Error(codes.InvalidArgument, "nonce must be 1 to 128 characters")
+	}
+	if err := s.allowWrite(ctx); err != nil {
+		return nil, err
+	}
+
+	// Reject requests outside the timestamp window, since their nonces may
+	// already have been pruned
+	skew := time.Since(time.Unix(req.GetTimestamp(), 0))
+	if skew > voteMaxClockSkew || skew < -voteMaxClockSkew {
+		s.h.metrics.Inc(`goreddit_vote_replays_rejected_total{reason="stale"}`)
+		return nil, status.Error(codes.InvalidArgument, "vote timestamp is outside the accepted window")
+	}
+
+	userID, targetID, value := grpcUserID(ctx), int(req.GetTargetId()), int(req.GetValue())
+	err := s.h.db.WithContext(ctx).Vote(userID, targetID, targetType, value, req.GetNonce())
+	if errors.Is(err, ErrVoteReplay) {
+		s.h.metrics.Inc(`goreddit_vote_replays_rejected_total{reason="nonce"}`)
+	}
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	s.h.events.Emit(VoteCastEvent{UserID: userID, TargetID: targetID, TargetType: targetType, Value: value})
+	return &redditpb.VoteResponse{}, nil
+}
+
+func (s *grpcServer) GetFeed(ctx context.Context, req *redditpb.GetFeedRequest) (*redditpb.GetFeedResponse, error) {
+	userID := grpcUserID(ctx)
+	limit, offset := int(req.GetLimit()), int(req.GetOffset())
+	if limit <= 0 {
+		limit = defaultPageSize
+	}
+	if limit > maxPageSize {
+		limit = maxPageSize
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	// Like GET /feed: newest first unless a ranking is asked for or is the
+	// user's default
+	sortBy := req.GetSort()
+	if sortBy == "" {
+		sortBy = defaultFeedSort(s.h.db.WithContext(ctx), userID)
+	}
+
+	var page PostPage
+	if newestFirst(sortBy) {
+		posts, err := s.h.db.WithContext(ctx).GetFeed(userID, limit+1, offset)
+		if err != nil {
+			return nil, grpcError(err)
+		}
+		page = newPostPage(posts, limit, offset)
+	} else {
+		posts, err := s.h.db.WithContext(ctx).GetFeed(userID, allPosts, 0)
+		if err != nil {
+			return nil, grpcError(err)
+		}
+		if err := s.h.rankPosts(posts, sortBy, RankingParams{HalfLifeHours: defaultHalfLifeHours}); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		page = paginatePosts(posts, limit, offset)
+	}
+
+	resp := &redditpb.GetFeedResponse{}
+	for _, post := range page.Posts {
+		resp.Posts = append(resp.Posts, toPostProto(post))
+	}
+	if page.NextOffset != nil {
+		next := int32(*page.NextOffset)
+		resp.NextOffset = &next
+	}
+	return resp, nil
+}
+
+func (s *grpcServer) SendMessage(ctx context.Context, req *redditpb.SendMessageRequest) (*redditpb.SendMessageResponse, error) {
+	if req.GetContent() == "" {
+		return nil, status.Error(codes.InvalidArgument, "content is required")
+	}
+	if err := s.allowWrite(ctx); err != nil {
+		return nil, err
+	}
+
+	userID, toUserID := grpcUserID(ctx), int(req.GetToUserId())
+	messageID, err := s.h.db.WithContext(ctx).SendDirectMessage(userID, toUserID, req.GetContent())
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	s.h.events.Emit(MessageSentEvent{MessageID: messageID, FromUserID: userID, ToUserID: toUserID})
+
+	return &redditpb.SendMessageResponse{MessageId: int64(messageID)}, nil
+}
+
+func (s *grpcServer) GetPost(ctx context.Context, req *redditpb.GetPostRequest) (*redditpb.GetPostResponse, error) {
+	post, err := s.h.db.WithContext(ctx).GetPost(int(req.GetId()))
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	recordPostView(s.h.db.WithContext(ctx), post, grpcUserID(ctx))
+	comments, err := s.h.db.WithContext(ctx).GetCommentsSince(post.ID, 0, grpcUserID(ctx))
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	resp := &redditpb.GetPostResponse{Post: toPostProto(*post)}
+	for _, comment := range comments {
+		resp.Comments = append(resp.Comments, toCommentProto(comment))
+	}
+	return resp, nil
+}
+
+func (s *grpcServer) GetSubreddit(ctx context.Context, req *redditpb.GetSubredditRequest) (*redditpb.Subreddit, error) {
+	about, err := s.h.db.WithContext(ctx).GetSubredditAbout(req.GetName())
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	return &redditpb.Subreddit{
+		Id:          int64(about.ID),
+		Name:        about.Name,
+		Description: about.Description,
+		CreatedAt:   timestamppb.New(about.CreatedAt),
+		MemberCount: int64(about.MemberCount),
+	}, nil
+}
+
+func (s *grpcServer) GetUser(ctx context.Context, req *redditpb.GetUserRequest) (*redditpb.User, error) {
+	user, err := s.h.db.WithContext(ctx).GetUserByUsername(req.GetUsername())
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	userID, _ := strconv.Atoi(user.ID)
+	return &redditpb.User{Id: int64(userID), Username: user.Username, Karma: int64(user.Karma)}, nil
+}
+
+// serveGRPC serves RedditService on addr until the listener fails
+func serveGRPC(h *APIHandler, addr string) error {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	s := &grpcServer{h: h}
+	server := grpc.NewServer(grpc.UnaryInterceptor(s.grpcAuth))
+	redditpb.RegisterRedditServiceServer(server, s)
+
+	log.Printf("gRPC API listening on %s", addr)
+	return server.Serve(lis)
+}
@@ -0,0 +1,12 @@
+//go:build !grpc
+
+package main
+
+import "errors"
+
+// serveGRPC fails in builds without the grpc tag, which leave out the code
+// generated from the proto file. Run `make proto` and build with -tags grpc
+// to serve the gRPC API.
+func serveGRPC(h *APIHandler, addr string) error {
+	return errors.New("this build doesn't include the gRPC API; run make proto and build with -tags grpc")
+}
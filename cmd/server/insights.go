@@ -0,0 +1,278 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Post insights
+//
+// Authors can see how their posts are doing: views and shares over time,
+// how the comment count grew, how often the post was crossposted, and the
+// vote ratio. Views and shares are counted into hourly buckets in
+// post_insights as they happen, rather than kept as one row per event. A
+// view is counted when someone other than the author opens the post, from
+// the Reddit-compatible comments listing, GraphQL or gRPC, and a share when
+// a client reports one on POST /posts/:id/share. Each user's share of a post
+// is counted once: post_shares remembers who shared what.
+
+// Metrics counted in post_insights
+const (
+	postViews  = "views"
+	postShares = "shares"
+)
+
+const (
+	defaultInsightsHours  = 48
+	maxInsightsHours      = 30 * 24
+	postInsightsRetention = 90 * 24 * time.Hour
+)
+
+// RecordPostMetric counts an event of a post's metric in the current hour
+func (dm *DatabaseManager) RecordPostMetric(postID int, metric string) error {
+	defer dm.span("RecordPostMetric").End()
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	_, err := dm.db.Exec(`
+		INSERT INTO post_insights (post_id, metric, hour, count)
+		VALUES (?, ?, strftime('%Y-%m-%d %H:00', 'now'), 1)
+		ON CONFLICT (post_id, metric, hour) DO UPDATE SET count = count + 1
+	`, postID, metric)
+	if err != nil {
+		return fmt.Errorf("failed to record post %s: %v", metric, err)
+	}
+	return nil
+}
+
+// RecordPostShare counts a user's share of a post in the current hour,
+// unless they already shared it. It reports whether the share was counted.
+func (dm *DatabaseManager) RecordPostShare(postID, userID int) (bool, error) {
+	defer dm.span("RecordPostShare").End()
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	tx, err := dm.db.Begin()
+	if err != nil {
+		return false, err
+	}
+
+	result, err := tx.Exec(`INSERT OR IGNORE INTO post_shares (post_id, user_id) VALUES (?, ?)`, postID, userID)
+	if err != nil {
+		tx.Rollback()
+		return false, fmt.Errorf("failed to record post share: %v", err)
+	}
+	if added, _ := result.RowsAffected(); added == 0 {
+		tx.Rollback()
+		return false, nil
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO post_insights (post_id, metric, hour, count)
+		VALUES (?, ?, strftime('%Y-%m-%d %H:00', 'now'), 1)
+		ON CONFLICT (post_id, metric, hour) DO UPDATE SET count = count + 1
+	`, postID, postShares)
+	if err != nil {
+		tx.Rollback()
+		return false, fmt.Errorf("failed to record post share: %v", err)
+	}
+
+	return true, tx.Commit()
+}
+
+// PrunePostInsights deletes the hourly buckets older than retention,
+// returning how many
+func (dm *DatabaseManager) PrunePostInsights(retention time.Duration) (int, error) {
+	defer dm.span("PrunePostInsights").End()
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	cutoff := time.Now().UTC().Add(-retention).Format(statsHourFormat)
+	result, err := dm.db.Exec(`DELETE FROM post_insights WHERE hour < ?`, cutoff)
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune post insights: %v", err)
+	}
+	pruned, _ := result.RowsAffected()
+	return int(pruned), nil
+}
+
+// PostInsights is how a post has been doing. The series have a bucket for
+// every hour of the requested range, in UTC.
+type PostInsights struct {
+	PostID        int           `json:"post_id"`
+	Hours         int           `json:"hours"`
+	Views         int           `json:"views"`      // all time, as far back as buckets are kept
+	Shares        int           `json:"shares"`     // likewise, once per user
+	Crossposts    int           `json:"crossposts"` // visible crossposts of the post
+	Upvotes       int           `json:"upvotes"`
+	Downvotes     int           `json:"downvotes"`
+	UpvoteRatio   float64       `json:"upvote_ratio"` // upvotes out of all votes, 0 without votes
+	CommentCount  int           `json:"comment_count"`
+	ViewsPerHour  []StatsBucket `json:"views_per_hour"`
+	SharesPerHour []StatsBucket `json:"shares_per_hour"`
+	// CommentGrowth is the post's visible comment count at the end of each
+	// hour
+	CommentGrowth []StatsBucket `json:"comment_growth"`
+}
+
+// GetPostInsights returns a post's insights with series covering the last
+// hours hours
+func (dm *DatabaseManager) GetPostInsights(postID, hours int, now time.Time) (*PostInsights, error) {
+	defer dm.span("GetPostInsights").End()
+	dm.mu.RLock()
+	defer dm.mu.RUnlock()
+
+	insights := &PostInsights{PostID: postID, Hours: hours}
+	err := dm.db.QueryRow(`
+		SELECT p.comment_count,
+			(SELECT COUNT(*) FROM votes WHERE target_id = p.id AND target_type = 'post' AND vote_value = 1),
+			(SELECT COUNT(*) FROM votes WHERE target_id = p.id AND target_type = 'post' AND vote_value = -1),
+			COALESCE((SELECT SUM(count) FROM post_insights WHERE post_id = p.id AND metric = ?), 0),
+			COALESCE((SELECT SUM(count) FROM post_insights WHERE post_id = p.id AND metric = ?), 0),
+			(SELECT COUNT(*) FROM posts x WHERE x.crosspost_of = p.id AND x.removed = 0 AND x.deleted_at IS NULL)
+		FROM posts p WHERE p.id = ?
+	`, postViews, postShares, postID).Scan(&insights.CommentCount, &insights.Upvotes, &insights.Downvotes,
+		&insights.Views, &insights.Shares, &insights.Crossposts)
+	if err != nil {
+		return nil, fmt.Errorf("post not found: %v", err)
+	}
+	if votes := insights.Upvotes + insights.Downvotes; votes > 0 {
+		insights.UpvoteRatio = float64(insights.Upvotes) / float64(votes)
+	}
+
+	now = now.UTC()
+	buckets := make([]string, hours)
+	for i := range buckets {
+		buckets[i] = now.Add(time.Duration(i-hours+1) * time.Hour).Format(statsHourFormat)
+	}
+
+	series := func(query string, args ...interface{}) (map[string]int, error) {
+		rows, err := dm.db.Query(query, args...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get post insights: %v", err)
+		}
+		defer rows.Close()
+
+		counts := make(map[string]int)
+		for rows.Next() {
+			var bucket string
+			var count int
+			if err := rows.Scan(&bucket, &count); err != nil {
+				return nil, err
+			}
+			counts[bucket] = count
+		}
+		return counts, rows.Err()
+	}
+
+	metrics := map[string]*[]StatsBucket{postViews: &insights.ViewsPerHour, postShares: &insights.SharesPerHour}
+	for metric, out := range metrics {
+		counts, err := series(`SELECT hour, count FROM post_insights WHERE post_id = ? AND metric = ? AND hour >= ?`,
+			postID, metric, buckets[0])
+		if err != nil {
+			return nil, err
+		}
+		*out = make([]StatsBucket, len(buckets))
+		for i, bucket := range buckets {
+			(*out)[i] = StatsBucket{Bucket: bucket, Count: counts[bucket]}
+		}
+	}
+
+	// The comment count grows from the comments made before the range
+	var before int
+	err = dm.db.QueryRow(`
+		SELECT COUNT(*) FROM comments
+		WHERE post_id = ? AND removed = 0 AND deleted_at IS NULL AND strftime('%Y-%m-%d %H:00', created_at) < ?
+	`, postID, buckets[0]).Scan(&before)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get post insights: %v", err)
+	}
+	counts, err := series(`
+		SELECT strftime('%Y-%m-%d %H:00', created_at), COUNT(*) FROM comments
+		WHERE post_id = ? AND removed = 0 AND deleted_at IS NULL AND strftime('%Y-%m-%d %H:00', created_at) >= ?
+		GROUP BY 1
+	`, postID, buckets[0])
+	if err != nil {
+		return nil, err
+	}
+	insights.CommentGrowth = make([]StatsBucket, len(buckets))
+	for i, bucket := range buckets {
+		before += counts[bucket]
+		insights.CommentGrowth[i] = StatsBucket{Bucket: bucket, Count: before}
+	}
+
+	return insights, nil
+}
+
+// recordPostView counts a view of a post, unless it's the author's own
+func recordPostView(db *DatabaseManager, post *Post, viewerID int) {
+	if post.AuthorID == viewerID {
+		return
+	}
+	if err := db.RecordPostMetric(post.ID, postViews); err != nil {
+		logAt(logWarn, "Failed to record a view of post %d: %v", post.ID, err)
+	}
+}
+
+// getPostInsights returns the insights of the current user's post over the
+// last ?hours= hours (48 by default, at most 30 days)
+func (h *APIHandler) getPostInsights(c *gin.Context) {
+	postID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.Error(newAPIError(http.StatusBadRequest, "Invalid post ID"))
+		return
+	}
+
+	hours := defaultInsightsHours
+	if param := c.Query("hours"); param != "" {
+		if hours, err = strconv.Atoi(param); err != nil || hours < 1 || hours > maxInsightsHours {
+			c.Error(newAPIError(http.StatusBadRequest, fmt.Sprintf("hours must be between 1 and %d", maxInsightsHours)))
+			return
+		}
+	}
+
+	post, err := h.dbFor(c).GetPost(postID)
+	if err != nil {
+		c.Error(newAPIError(http.StatusNotFound, "Post not found"))
+		return
+	}
+	userID, _ := strconv.Atoi(c.GetString("user_id"))
+	if post.AuthorID != userID {
+		c.Error(newAPIError(http.StatusForbidden, "Only the author can see a post's insights"))
+		return
+	}
+
+	insights, err := h.dbFor(c).GetPostInsights(postID, hours, time.Now())
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, insights)
+}
+
+// sharePost records that the current user shared a post. Sharing a post
+// again isn't counted again.
+func (h *APIHandler) sharePost(c *gin.Context) {
+	postID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.Error(newAPIError(http.StatusBadRequest, "Invalid post ID"))
+		return
+	}
+
+	if _, err := h.dbFor(c).GetPost(postID); err != nil {
+		c.Error(newAPIError(http.StatusNotFound, "Post not found"))
+		return
+	}
+	userID, _ := strconv.Atoi(c.GetString("user_id"))
+	if _, err := h.dbFor(c).RecordPostShare(postID, userID); err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Share recorded"})
+}
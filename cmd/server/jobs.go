@@ -0,0 +1,605 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Background jobs
+//
+// Periodic work, such as recomputing trending topics and reconciling karma,
+// is registered with the scheduler as a named job with a schedule. Every run
+// is recorded in job_runs with how it was started, how it ended and a short
+// summary, and admins can list the jobs, look at their recent runs and start
+// a job early from /admin/jobs. A job never runs twice at once. Loops that
+// deliver queued work (emails, webhooks, mirrors, the event outbox) aren't
+// jobs and keep running on their own.
+
+// Job run statuses
+const (
+	jobRunning   = "running"
+	jobSucceeded = "succeeded"
+	jobFailed    = "failed"
+)
+
+// jobRunHistory is how many runs of each job are kept
+const jobRunHistory = 200
+
+var (
+	errUnknownJob = errors.New("job not found")
+	errJobRunning = errors.New("job is already running")
+)
+
+// Schedule decides when a job next runs
+type Schedule interface {
+	Next(after time.Time) time.Time
+	String() string
+}
+
+// every runs a job at a fixed interval, counted from the end of its last run
+type every time.Duration
+
+func (s every) Next(after time.Time) time.Time { return after.Add(time.Duration(s)) }
+func (s every) String() string                 { return "every " + time.Duration(s).String() }
+
+// dailyAt runs a job once a day at a local hour
+type dailyAt int
+
+func (s dailyAt) Next(after time.Time) time.Time {
+	next := time.Date(after.Year(), after.Month(), after.Day(), int(s), 0, 0, 0, after.Location())
+	if !next.After(after) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
+
+func (s dailyAt) String() string { return fmt.Sprintf("daily at %02d:00", int(s)) }
+
+// Job is a unit of background work. Run returns a short summary of what it
+// did, which is recorded with the run.
+type Job struct {
+	Name        string
+	Description string
+	Schedule    Schedule
+	RunAtStart  bool // also run as soon as the scheduler starts
+	Run         func(ctx context.Context) (string, error)
+}
+
+type scheduledJob struct {
+	Job
+	trigger chan struct{}
+
+	// guarded by Scheduler.mu
+	running bool
+	nextRun time.Time
+}
+
+// Scheduler runs registered jobs on their schedules
+type Scheduler struct {
+	db      *DatabaseManager
+	metrics *Metrics
+
+	mu   sync.Mutex
+	jobs []*scheduledJob
+}
+
+func NewScheduler(db *DatabaseManager, metrics *Metrics) *Scheduler {
+	return &Scheduler{db: db, metrics: metrics}
+}
+
+// Register adds a job. Jobs must be registered before Start.
+func (s *Scheduler) Register(job Job) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, j := range s.jobs {
+		if j.Name == job.Name {
+			panic("job registered twice: " + job.Name)
+		}
+	}
+	s.jobs = append(s.jobs, &scheduledJob{Job: job, trigger: make(chan struct{}, 1)})
+}
+
+// Start runs the registered jobs on their schedules until the process exits.
+// Runs left unfinished by a previous process are marked failed first.
+func (s *Scheduler) Start() {
+	if err := s.db.AbandonJobRuns(); err != nil {
+		log.Printf("Failed to clean up job runs: %v", err)
+	}
+	for _, j := range s.jobs {
+		go s.loop(j)
+	}
+}
+
+func (s *Scheduler) loop(j *scheduledJob) {
+	next := time.Now()
+	if !j.RunAtStart {
+		next = j.Schedule.Next(next)
+	}
+
+	for {
+		s.mu.Lock()
+		j.nextRun = next
+		s.mu.Unlock()
+
+		timer := time.NewTimer(time.Until(next))
+		triggeredBy := "schedule"
+		select {
+		case <-timer.C:
+		case <-j.trigger:
+			timer.Stop()
+			triggeredBy = "admin"
+		}
+
+		s.run(j, triggeredBy)
+		next = j.Schedule.Next(time.Now())
+	}
+}
+
+// Trigger starts a job now, ahead of its schedule
+func (s *Scheduler) Trigger(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	j := s.find(name)
+	if j == nil {
+		return errUnknownJob
+	}
+	if j.running {
+		return errJobRunning
+	}
+	select {
+	case j.trigger <- struct{}{}:
+		return nil
+	default:
+		// Triggered already and about to start
+		return errJobRunning
+	}
+}
+
+func (s *Scheduler) find(name string) *scheduledJob {
+	for _, j := range s.jobs {
+		if j.Name == name {
+			return j
+		}
+	}
+	return nil
+}
+
+// run runs a job once and records the run. A job that panics fails the run
+// rather than the process.
+func (s *Scheduler) run(j *scheduledJob, triggeredBy string) {
+	s.mu.Lock()
+	j.running = true
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		j.running = false
+		s.mu.Unlock()
+	}()
+
+	ctx, span := tracer.Start(context.Background(), "job "+j.Name)
+	runID, err := s.db.WithContext(ctx).StartJobRun(j.Name, triggeredBy)
+	if err != nil {
+		log.Printf("Failed to record %s job run: %v", j.Name, err)
+	}
+
+	started := time.Now()
+	summary, runErr := func() (summary string, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				log.Printf("Job %s panicked: %v\n%s", j.Name, r, debug.Stack())
+				err = fmt.Errorf("job panicked: %v", r)
+			}
+		}()
+		return j.Run(ctx)
+	}()
+	endSpan(span, runErr)
+
+	status := jobSucceeded
+	if runErr != nil {
+		status = jobFailed
+		log.Printf("Job %s failed: %v", j.Name, runErr)
+	} else {
+		logAt(logDebug, "Job %s finished in %s: %s", j.Name, time.Since(started).Round(time.Millisecond), summary)
+	}
+	s.metrics.Inc(fmt.Sprintf(`goreddit_job_runs_total{job=%q,status=%q}`, j.Name, status))
+	s.metrics.Set(fmt.Sprintf(`goreddit_job_last_duration_seconds{job=%q}`, j.Name), time.Since(started).Seconds())
+
+	if runID != 0 {
+		if err := s.db.FinishJobRun(runID, j.Name, summary, runErr); err != nil {
+			log.Printf("Failed to record %s job run: %v", j.Name, err)
+		}
+	}
+}
+
+// JobRun is one run of a background job
+type JobRun struct {
+	ID          int        `json:"id"`
+	Job         string     `json:"job"`
+	TriggeredBy string     `json:"triggered_by"` // schedule or admin
+	Status      string     `json:"status"`       // running, succeeded or failed
+	Summary     string     `json:"summary,omitempty"`
+	Error       string     `json:"error,omitempty"`
+	StartedAt   time.Time  `json:"started_at"`
+	FinishedAt  *time.Time `json:"finished_at"`
+}
+
+// JobStatus describes a registered job
+type JobStatus struct {
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Schedule    string    `json:"schedule"`
+	Running     bool      `json:"running"`
+	NextRunAt   time.Time `json:"next_run_at"`
+	LastRun     *JobRun   `json:"last_run"`
+	Runs        []JobRun  `json:"runs,omitempty"` // most recent first, when looking at one job
+}
+
+// StartJobRun records that a job has started, returning the run's ID
+func (dm *DatabaseManager) StartJobRun(job, triggeredBy string) (int, error) {
+	defer dm.span("StartJobRun").End()
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	result, err := dm.db.Exec(`INSERT INTO job_runs (job, triggered_by) VALUES (?, ?)`, job, triggeredBy)
+	if err != nil {
+		return 0, fmt.Errorf("failed to start job run: %v", err)
+	}
+	id, err := result.LastInsertId()
+	return int(id), err
+}
+
+// FinishJobRun records how a run ended, and drops the job's runs beyond the
+// most recent jobRunHistory
+func (dm *DatabaseManager) FinishJobRun(runID int, job, summary string, runErr error) error {
+	defer dm.span("FinishJobRun").End()
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	tx, err := dm.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	status, errText := jobSucceeded, sql.NullString{}
+	if runErr != nil {
+		status, errText = jobFailed, sql.NullString{String: runErr.Error(), Valid: true}
+	}
+	_, err = tx.Exec(`
+		UPDATE job_runs SET status = ?, summary = ?, error = ?, finished_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`, status, summary, errText, runID)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to finish job run: %v", err)
+	}
+
+	_, err = tx.Exec(`
+		DELETE FROM job_runs
+		WHERE job = ? AND id <= (SELECT id FROM job_runs WHERE job = ? ORDER BY id DESC LIMIT 1 OFFSET ?)
+	`, job, job, jobRunHistory)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to prune job runs: %v", err)
+	}
+
+	return tx.Commit()
+}
+
+// AbandonJobRuns marks runs that never finished, because the server stopped
+// during them, as failed
+func (dm *DatabaseManager) AbandonJobRuns() error {
+	defer dm.span("AbandonJobRuns").End()
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	_, err := dm.db.Exec(`
+		UPDATE job_runs SET status = ?, error = 'server stopped during the run', finished_at = CURRENT_TIMESTAMP
+		WHERE status = ?
+	`, jobFailed, jobRunning)
+	if err != nil {
+		return fmt.Errorf("failed to abandon job runs: %v", err)
+	}
+	return nil
+}
+
+// GetJobRuns returns a job's most recent runs, newest first
+func (dm *DatabaseManager) GetJobRuns(job string, limit int) ([]JobRun, error) {
+	defer dm.span("GetJobRuns").End()
+	dm.mu.RLock()
+	defer dm.mu.RUnlock()
+
+	rows, err := dm.db.Query(`
+		SELECT id, job, triggered_by, status, COALESCE(summary, ''), COALESCE(error, ''), started_at, finished_at
+		FROM job_runs
+		WHERE job = ?
+		ORDER BY id DESC
+		LIMIT ?
+	`, job, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get job runs: %v", err)
+	}
+	defer rows.Close()
+
+	runs := []JobRun{}
+	for rows.Next() {
+		var r JobRun
+		if err := rows.Scan(&r.ID, &r.Job, &r.TriggeredBy, &r.Status, &r.Summary, &r.Error, &r.StartedAt, &r.FinishedAt); err != nil {
+			return nil, err
+		}
+		runs = append(runs, r)
+	}
+	return runs, rows.Err()
+}
+
+// status describes the registered jobs, each with its last run
+func (s *Scheduler) status(db *DatabaseManager) ([]JobStatus, error) {
+	s.mu.Lock()
+	statuses := make([]JobStatus, len(s.jobs))
+	for i, j := range s.jobs {
+		statuses[i] = JobStatus{
+			Name:        j.Name,
+			Description: j.Description,
+			Schedule:    j.Schedule.String(),
+			Running:     j.running,
+			NextRunAt:   j.nextRun,
+		}
+	}
+	s.mu.Unlock()
+
+	for i := range statuses {
+		runs, err := db.GetJobRuns(statuses[i].Name, 1)
+		if err != nil {
+			return nil, err
+		}
+		if len(runs) > 0 {
+			statuses[i].LastRun = &runs[0]
+		}
+	}
+	return statuses, nil
+}
+
+// getJobs lists the background jobs
+func (h *APIHandler) getJobs(c *gin.Context) {
+	jobs, err := h.scheduler.status(h.dbFor(c))
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, jobs)
+}
+
+// getJob describes a background job with its recent runs, up to ?limit=
+// (default 20, max jobRunHistory)
+func (h *APIHandler) getJob(c *gin.Context) {
+	limit := 20
+	if l, err := strconv.Atoi(c.Query("limit")); err == nil && l > 0 {
+		limit = l
+	}
+	if limit > jobRunHistory {
+		limit = jobRunHistory
+	}
+
+	jobs, err := h.scheduler.status(h.dbFor(c))
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	for _, job := range jobs {
+		if job.Name != c.Param("name") {
+			continue
+		}
+		if job.Runs, err = h.dbFor(c).GetJobRuns(job.Name, limit); err != nil {
+			c.Error(err)
+			return
+		}
+		c.JSON(http.StatusOK, job)
+		return
+	}
+
+	c.Error(errUnknownJob)
+}
+
+// triggerJob starts a background job now. The run is recorded like a
+// scheduled one, and can be followed with GET /admin/jobs/:name.
+func (h *APIHandler) triggerJob(c *gin.Context) {
+	if err := h.scheduler.Trigger(c.Param("name")); err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "Job started"})
+}
+
+// postArchiveAge is how old a post gets before it's archived
+const postArchiveAge = 180 * 24 * time.Hour
+
+// registerJobs registers the server's background jobs with its scheduler
+func (h *APIHandler) registerJobs() {
+	h.scheduler.Register(Job{
+		Name:        "trending",
+		Description: "Recompute trending topics from recent post titles",
+		Schedule:    every(trendingInterval),
+		RunAtStart:  true,
+		Run: func(ctx context.Context) (string, error) {
+			return "", h.db.WithContext(ctx).ComputeTrendingTopics(trendingWindow, trendingTopicLimit)
+		},
+	})
+	h.scheduler.Register(Job{
+		Name:        "subreddit_recommendations",
+		Description: "Recompute subreddit recommendations from overlapping memberships",
+		Schedule:    every(recommendationInterval),
+		RunAtStart:  true,
+		Run: func(ctx context.Context) (string, error) {
+			users, err := h.db.WithContext(ctx).ComputeSubredditRecommendations(recommendationsPerUser)
+			return fmt.Sprintf("recommended subreddits to %d users", users), err
+		},
+	})
+	h.scheduler.Register(Job{
+		Name:        "stats",
+		Description: "Recompute the site statistics",
+		Schedule:    every(statsInterval),
+		RunAtStart:  true,
+		Run: func(ctx context.Context) (string, error) {
+			return "", h.db.WithContext(ctx).ComputeSiteStats(time.Now())
+		},
+	})
+	h.scheduler.Register(Job{
+		Name:        "maintenance",
+		Description: "Check database integrity, reclaim free pages and refresh planner statistics",
+		Schedule:    dailyAt(maintenanceHour),
+		Run: func(ctx context.Context) (string, error) {
+			report, err := h.runMaintenance()
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("integrity ok: %t, free pages %d -> %d", report.IntegrityOK, report.FreePagesBefore, report.FreePagesAfter), nil
+		},
+	})
+	h.scheduler.Register(Job{
+		Name:        "karma_reconciliation",
+		Description: "Recompute karma and comment scores from the votes, correcting any drift",
+		Schedule:    dailyAt(maintenanceHour + 1),
+		Run: func(ctx context.Context) (string, error) {
+			users, comments, err := h.db.WithContext(ctx).ReconcileKarma()
+			return fmt.Sprintf("corrected %d users and %d comments", users, comments), err
+		},
+	})
+	h.scheduler.Register(Job{
+		Name:        "post_archival",
+		Description: "Archive posts older than 180 days, closing them to new comments and votes",
+		Schedule:    every(time.Hour),
+		Run: func(ctx context.Context) (string, error) {
+			archived, err := h.db.WithContext(ctx).ArchiveOldPosts(postArchiveAge)
+			return fmt.Sprintf("archived %d posts", archived), err
+		},
+	})
+	h.scheduler.Register(Job{
+		Name:        "post_insights_pruning",
+		Description: "Delete post insights older than 90 days",
+		Schedule:    dailyAt(maintenanceHour + 2),
+		Run: func(ctx context.Context) (string, error) {
+			pruned, err := h.db.WithContext(ctx).PrunePostInsights(postInsightsRetention)
+			return fmt.Sprintf("pruned %d hourly buckets", pruned), err
+		},
+	})
+	h.scheduler.Register(Job{
+		Name:        "notification_emails",
+		Description: "Queue emails and digests for notifications waiting for email delivery",
+		Schedule:    every(emailPollInterval),
+		Run: func(ctx context.Context) (string, error) {
+			queued, err := h.db.WithContext(ctx).QueueNotificationEmails()
+			return fmt.Sprintf("queued %d emails", queued), err
+		},
+	})
+}
+
+// ReconcileKarma recomputes every user's karma and every comment's score
+// from the votes, which they are otherwise only adjusted by, and returns how
+// many of each were wrong
+func (dm *DatabaseManager) ReconcileKarma() (int, int, error) {
+	defer dm.span("ReconcileKarma").End()
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	tx, err := dm.db.Begin()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	result, err := tx.Exec(`
+		WITH expected AS (
+			SELECT author_id, SUM(vote_value) AS karma FROM (
+				SELECT p.author_id, v.vote_value
+				FROM votes v JOIN posts p ON v.target_type = 'post' AND v.target_id = p.id
+				UNION ALL
+				SELECT c.author_id, v.vote_value
+				FROM votes v JOIN comments c ON v.target_type = 'comment' AND v.target_id = c.id
+			)
+			GROUP BY author_id
+		)
+		UPDATE users SET karma = COALESCE((SELECT karma FROM expected WHERE author_id = users.id), 0)
+		WHERE karma != COALESCE((SELECT karma FROM expected WHERE author_id = users.id), 0)
+	`)
+	if err != nil {
+		tx.Rollback()
+		return 0, 0, fmt.Errorf("failed to reconcile karma: %v", err)
+	}
+	users, _ := result.RowsAffected()
+
+	result, err = tx.Exec(`
+		WITH expected AS (
+			SELECT target_id, SUM(vote_value) AS score FROM votes WHERE target_type = 'comment' GROUP BY target_id
+		)
+		UPDATE comments SET score = COALESCE((SELECT score FROM expected WHERE target_id = comments.id), 0)
+		WHERE score != COALESCE((SELECT score FROM expected WHERE target_id = comments.id), 0)
+	`)
+	if err != nil {
+		tx.Rollback()
+		return 0, 0, fmt.Errorf("failed to reconcile comment scores: %v", err)
+	}
+	comments, _ := result.RowsAffected()
+
+	return int(users), int(comments), tx.Commit()
+}
+
+// ErrPostArchived is returned for comments and votes on an archived post
+var ErrPostArchived = errors.New("this post is archived and can't be commented or voted on")
+
+// ArchiveOldPosts archives the posts older than age, returning how many
+func (dm *DatabaseManager) ArchiveOldPosts(age time.Duration) (int, error) {
+	defer dm.span("ArchiveOldPosts").End()
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	result, err := dm.db.Exec(`
+		UPDATE posts SET archived_at = CURRENT_TIMESTAMP
+		WHERE archived_at IS NULL AND created_at < datetime('now', ?)
+	`, fmt.Sprintf("-%d seconds", int(age.Seconds())))
+	if err != nil {
+		return 0, fmt.Errorf("failed to archive posts: %v", err)
+	}
+	archived, _ := result.RowsAffected()
+	return int(archived), nil
+}
+
+// checkNotArchived fails with ErrPostArchived when a post, or the post of a
+// comment, is archived, and with ErrSubredditArchived when its subreddit is.
+// A post or comment that's deleted, or on a deleted post, is not found.
+func checkNotArchived(tx *sql.Tx, targetID int, targetType string) error {
+	query := `SELECT archived_at IS NOT NULL, deleted_at IS NOT NULL, subreddit_id FROM posts WHERE id = ?`
+	if targetType == "comment" {
+		query = `
+			SELECT p.archived_at IS NOT NULL, c.deleted_at IS NOT NULL OR p.deleted_at IS NOT NULL, p.subreddit_id
+			FROM comments c JOIN posts p ON c.post_id = p.id WHERE c.id = ?
+		`
+	}
+
+	var archived, deleted bool
+	var subredditID int
+	err := tx.QueryRow(query, targetID).Scan(&archived, &deleted, &subredditID)
+	if err == sql.ErrNoRows {
+		return nil // unknown targets aren't this check's concern
+	}
+	if err != nil {
+		return fmt.Errorf("failed to check archival: %v", err)
+	}
+	if deleted {
+		return fmt.Errorf("%s not found", targetType)
+	}
+	if archived {
+		return ErrPostArchived
+	}
+	return checkSubredditWritable(tx, subredditID)
+}
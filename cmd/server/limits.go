@@ -0,0 +1,121 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// Posting limits
+//
+// Creating a subreddit can take a minimum of karma, and accounts younger than
+// a number of days can be made to wait between posts. Both are part of the
+// runtime config and off by default. Subreddits can also cap how many posts
+// each user makes in them a day (max_posts_per_day in their settings).
+// The limits are checked in the creating transaction, failing with
+// ErrNotEnoughKarma (403) or ErrPostingTooFast (429) wrapped in a message
+// saying what the limit is and when the user can try again.
+
+var (
+	ErrNotEnoughKarma = errors.New("you don't have enough karma")
+	ErrPostingTooFast = errors.New("you're posting too fast")
+)
+
+// PostingLimits configures the site-wide posting limits
+type PostingLimits struct {
+	MinKarmaToCreateSubreddit int `json:"min_karma_to_create_subreddit"` // 0 lets anyone
+
+	// Accounts younger than NewAccountDays wait NewAccountPostCooldownSeconds
+	// between posts, 0 not at all
+	NewAccountDays                int `json:"new_account_days"`
+	NewAccountPostCooldownSeconds int `json:"new_account_post_cooldown_seconds"`
+}
+
+var defaultPostingLimits = PostingLimits{
+	NewAccountDays: 7,
+}
+
+// Validate checks the limits
+func (l PostingLimits) Validate() error {
+	if l.MinKarmaToCreateSubreddit < 0 || l.NewAccountDays < 0 || l.NewAccountPostCooldownSeconds < 0 {
+		return fmt.Errorf("posting_limits must not be negative")
+	}
+	return nil
+}
+
+// SetPostingLimits changes the limits new subreddits and posts are checked
+// against
+func (dm *DatabaseManager) SetPostingLimits(limits PostingLimits) {
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+	dm.limits = limits
+}
+
+// checkSubredditKarma fails with ErrNotEnoughKarma when the user doesn't have
+// the karma to create a subreddit
+func checkSubredditKarma(tx *sql.Tx, limits PostingLimits, userID int) error {
+	if limits.MinKarmaToCreateSubreddit == 0 {
+		return nil
+	}
+
+	var karma int
+	if err := tx.QueryRow(`SELECT karma FROM users WHERE id = ?`, userID).Scan(&karma); err != nil {
+		return fmt.Errorf("user not found: %v", err)
+	}
+	if karma < limits.MinKarmaToCreateSubreddit {
+		return fmt.Errorf("%w: creating a subreddit takes %d karma, and you have %d",
+			ErrNotEnoughKarma, limits.MinKarmaToCreateSubreddit, karma)
+	}
+	return nil
+}
+
+// checkPostingRate fails with ErrPostingTooFast when a new account is within
+// its cooldown, or the user has reached the subreddit's daily cap
+func checkPostingRate(tx *sql.Tx, limits PostingLimits, userID, subredditID int) error {
+	if limits.NewAccountPostCooldownSeconds > 0 {
+		// Seconds since the user's last post, null before their first
+		var newAccount bool
+		var sinceLast sql.NullFloat64
+		err := tx.QueryRow(`
+			SELECT u.created_at >= datetime('now', ?),
+				(SELECT (julianday('now') - julianday(MAX(p.created_at))) * 86400 FROM posts p WHERE p.author_id = u.id)
+			FROM users u WHERE u.id = ?
+		`, fmt.Sprintf("-%d days", limits.NewAccountDays), userID).Scan(&newAccount, &sinceLast)
+		if err != nil {
+			return fmt.Errorf("user not found: %v", err)
+		}
+
+		cooldown := float64(limits.NewAccountPostCooldownSeconds)
+		if newAccount && sinceLast.Valid && sinceLast.Float64 < cooldown {
+			return fmt.Errorf("%w: accounts younger than %d days can post once every %s; try again in %s",
+				ErrPostingTooFast, limits.NewAccountDays, time.Duration(cooldown)*time.Second,
+				waitFor(cooldown-sinceLast.Float64))
+		}
+	}
+
+	// Seconds until the oldest post of the last day leaves the window
+	var maxPerDay, posts int
+	var untilOldest sql.NullFloat64
+	err := tx.QueryRow(`
+		SELECT COALESCE((SELECT max_posts_per_day FROM subreddit_settings WHERE subreddit_id = ?), 0),
+			COUNT(*), (julianday(MIN(created_at)) + 1 - julianday('now')) * 86400
+		FROM posts
+		WHERE author_id = ? AND subreddit_id = ? AND created_at >= datetime('now', '-1 day')
+	`, subredditID, userID, subredditID).Scan(&maxPerDay, &posts, &untilOldest)
+	if err != nil {
+		return fmt.Errorf("failed to check posting frequency: %v", err)
+	}
+	if maxPerDay > 0 && posts >= maxPerDay {
+		return fmt.Errorf("%w: this subreddit allows %d posts a day from each user; try again in %s",
+			ErrPostingTooFast, maxPerDay, waitFor(untilOldest.Float64))
+	}
+	return nil
+}
+
+// waitFor is a wait in seconds for an error message, rounded up to the
+// second
+func waitFor(seconds float64) time.Duration {
+	return time.Duration(math.Max(math.Ceil(seconds), 1)) * time.Second
+}
@@ -0,0 +1,11434 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log"
+	"math"
+	"mime"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unicode"
+
+	"github.com/asynkron/protoactor-go/actor"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/graphql-go/graphql"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	_ "modernc.org/sqlite"
+
+	"github.com/ArjunKaliyath/GoReddit/internal/buildinfo"
+	"github.com/ArjunKaliyath/GoReddit/internal/config"
+	"github.com/ArjunKaliyath/GoReddit/internal/migrations"
+)
+
+// DatabaseManager handles all database operations
+type DatabaseManager struct {
+	*database
+	ctx context.Context // set on views made with WithContext, for tracing
+}
+
+// database is the state shared by a DatabaseManager and its WithContext views
+type database struct {
+	db *sql.DB
+	mu sync.RWMutex
+
+	lastActive sync.Map // user ID to when their activity was last recorded
+
+	spam   SpamThresholds // guarded by mu
+	limits PostingLimits  // likewise
+	outbox bool           // likewise; whether writes store their events
+}
+
+// memoryDatabases numbers the in-memory databases opened by InitDatabase
+var memoryDatabases int64
+
+// databaseBusyTimeout is how long a connection waits for another's lock on
+// the database before failing with SQLITE_BUSY
+const databaseBusyTimeout = 5 * time.Second
+
+// withConnectionPragmas adds the pragmas every connection is opened with to
+// a database path. dm.mu only serializes the writes of this process, so
+// other processes sharing the file, such as the nodes of a cluster, are
+// waited for with a busy timeout, and files use WAL so readers don't block
+// the writer.
+func withConnectionPragmas(dbPath string) string {
+	separator := "?"
+	if strings.Contains(dbPath, "?") {
+		separator = "&"
+	}
+	dbPath += fmt.Sprintf("%s_pragma=busy_timeout(%d)", separator, databaseBusyTimeout.Milliseconds())
+	if !strings.Contains(dbPath, "mode=memory") {
+		dbPath += "&_pragma=journal_mode(WAL)"
+	}
+	return dbPath
+}
+
+// InitDatabase invoked to create and setup initial database tables.
+func InitDatabase(dbPath string) (*DatabaseManager, error) {
+	// Each connection to ":memory:" would get its own empty database, so
+	// it's opened as a named in-memory database the connections share
+	if dbPath == ":memory:" {
+		dbPath = fmt.Sprintf("file:goreddit-memory-%d?mode=memory&cache=shared", atomic.AddInt64(&memoryDatabases, 1))
+	}
+	db, err := sql.Open("sqlite", withConnectionPragmas(dbPath))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %v", err)
+	}
+
+	// Lets the maintenance job reclaim free pages incrementally. Only takes
+	// effect on databases created after this setting was introduced.
+	if _, err := db.Exec(`PRAGMA auto_vacuum = INCREMENTAL`); err != nil {
+		return nil, fmt.Errorf("failed to configure database: %v", err)
+	}
+
+	// Create tables
+	_, err = db.Exec(migrations.Schema)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to create tables: %v", err)
+	}
+
+	if err := migrateNotifications(db); err != nil {
+		return nil, err
+	}
+
+	if err := migrateColumns(db); err != nil {
+		return nil, err
+	}
+
+	if err := backfillPostSlugs(db); err != nil {
+		return nil, err
+	}
+
+	if err := indexPostsForSearch(db); err != nil {
+		return nil, err
+	}
+
+	return &DatabaseManager{database: &database{db: db}}, nil
+}
+
+// columnMigrations lists columns added after a table was first created, so
+// databases created by older versions pick them up on startup
+var columnMigrations = []struct {
+	table      string
+	column     string
+	definition string
+}{
+	{"posts", "flair", "TEXT"},
+	{"posts", "removed", "INTEGER NOT NULL DEFAULT 0"},
+	{"comments", "removed", "INTEGER NOT NULL DEFAULT 0"},
+	{"posts", "pinned", "INTEGER NOT NULL DEFAULT 0"},
+	{"direct_messages", "read_at", "DATETIME"},
+	{"direct_messages", "deleted_by_sender", "INTEGER NOT NULL DEFAULT 0"},
+	{"direct_messages", "deleted_by_recipient", "INTEGER NOT NULL DEFAULT 0"},
+	{"sessions", "impersonator_id", "INTEGER"},
+	{"sessions", "scope", "TEXT"},
+	{"sessions", "expires_at", "DATETIME"},
+	{"posts", "edited_at", "DATETIME"},
+	{"comments", "edited_at", "DATETIME"},
+	{"comments", "score", "INTEGER NOT NULL DEFAULT 0"},
+	{"users", "last_active_at", "DATETIME"},
+	{"posts", "archived_at", "DATETIME"},
+	{"posts", "comment_count", "INTEGER NOT NULL DEFAULT 0"},
+	{"subreddit_settings", "collapse_below_score", fmt.Sprintf("INTEGER NOT NULL DEFAULT %d", defaultCollapseBelowScore)},
+	{"subreddit_settings", "collapse_negative_karma", "INTEGER NOT NULL DEFAULT 0"},
+	{"subreddit_settings", "max_posts_per_day", "INTEGER NOT NULL DEFAULT 0"},
+	{"posts", "deleted_at", "DATETIME"},
+	{"posts", "deleted_by", "INTEGER"},
+	{"comments", "deleted_at", "DATETIME"},
+	{"comments", "deleted_by", "INTEGER"},
+	{"subreddits", "deleted_at", "DATETIME"},
+	{"subreddits", "deleted_by", "INTEGER"},
+	{"subreddits", "owner_id", "INTEGER"},
+	{"subreddits", "archived_at", "DATETIME"},
+	{"posts", "kind", fmt.Sprintf("TEXT NOT NULL DEFAULT '%s'", postKindText)},
+	{"posts", "crosspost_of", "INTEGER"},
+	{"subreddit_settings", "allowed_post_types", fmt.Sprintf("TEXT NOT NULL DEFAULT '%s'", strings.Join(postKinds, ","))},
+	{"subreddit_settings", "min_title_length", "INTEGER NOT NULL DEFAULT 0"},
+	{"subreddit_settings", "allow_crossposts", "INTEGER NOT NULL DEFAULT 1"},
+	{"subreddit_settings", "edit_history_mod_only", "INTEGER NOT NULL DEFAULT 0"},
+	{"posts", "slug", "TEXT NOT NULL DEFAULT ''"},
+	{"subreddit_settings", "vote_fuzz_minutes", "INTEGER NOT NULL DEFAULT 0"},
+	{"subreddit_settings", "nsfw", "INTEGER NOT NULL DEFAULT 0"},
+}
+
+// columnBackfills fills in columns from existing rows when migrateColumns
+// adds them, keyed by table.column
+var columnBackfills = map[string]string{
+	"comments.score": `UPDATE comments SET score = COALESCE((
+		SELECT SUM(vote_value) FROM votes WHERE target_id = comments.id AND target_type = 'comment'
+	), 0)`,
+	"posts.comment_count": `UPDATE posts SET comment_count = (
+		SELECT COUNT(*) FROM comments WHERE post_id = posts.id AND removed = 0
+	)`,
+	"subreddits.owner_id": `UPDATE subreddits SET owner_id = (
+		SELECT user_id FROM subreddit_moderators WHERE subreddit_id = subreddits.id ORDER BY added_at, user_id LIMIT 1
+	)`,
+}
+
+// migrateColumns adds any missing columns listed in columnMigrations
+func migrateColumns(db *sql.DB) error {
+	for _, m := range columnMigrations {
+		var count int
+		err := db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?`, m.table, m.column).Scan(&count)
+		if err != nil {
+			return fmt.Errorf("failed to inspect %s: %v", m.table, err)
+		}
+		if count > 0 {
+			continue
+		}
+
+		_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", m.table, m.column, m.definition))
+		if err != nil {
+			return fmt.Errorf("failed to add %s.%s: %v", m.table, m.column, err)
+		}
+
+		if backfill, ok := columnBackfills[m.table+"."+m.column]; ok {
+			if _, err := db.Exec(backfill); err != nil {
+				return fmt.Errorf("failed to backfill %s.%s: %v", m.table, m.column, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// notificationTypes lists the kinds of notification users can get
+var notificationTypes = []string{"reply", "mention", "message", "chat_invite", "chat_message", "follow", "mod_action", "award"}
+
+// notificationsTable returns the DDL of the notifications table under name
+func notificationsTable(name string) string {
+	return fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			type TEXT CHECK(type IN ('%s')) NOT NULL,
+			actor_id INTEGER NOT NULL,
+			post_id INTEGER,
+			comment_id INTEGER,
+			message_id INTEGER,
+			chat_room_id INTEGER,
+			subreddit_id INTEGER,
+			in_app INTEGER NOT NULL DEFAULT 1,
+			push_status TEXT,
+			email_status TEXT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			read_at DATETIME,
+			FOREIGN KEY (user_id) REFERENCES users(id),
+			FOREIGN KEY (actor_id) REFERENCES users(id)
+		)
+	`, name, strings.Join(notificationTypes, "', '"))
+}
+
+// migrateNotifications creates the notifications table, or rebuilds it when
+// its type constraint predates a type in notificationTypes. SQLite can't
+// alter a CHECK constraint in place.
+func migrateNotifications(db *sql.DB) error {
+	var schema string
+	err := db.QueryRow(`SELECT sql FROM sqlite_master WHERE type = 'table' AND name = 'notifications'`).Scan(&schema)
+	if err == sql.ErrNoRows {
+		if _, err := db.Exec(notificationsTable("notifications")); err != nil {
+			return fmt.Errorf("failed to create notifications: %v", err)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to inspect notifications: %v", err)
+	}
+
+	current := true
+	for _, t := range notificationTypes {
+		current = current && strings.Contains(schema, "'"+t+"'")
+	}
+	if current {
+		return nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(notificationsTable("notifications_new")); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to migrate notifications: %v", err)
+	}
+
+	// Copy the columns both versions of the table have
+	var columns string
+	err = tx.QueryRow(`
+		SELECT group_concat(name, ', ') FROM pragma_table_info('notifications')
+		WHERE name IN (SELECT name FROM pragma_table_info('notifications_new'))
+	`).Scan(&columns)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to inspect notifications: %v", err)
+	}
+
+	statements := []string{
+		fmt.Sprintf(`INSERT INTO notifications_new (%s) SELECT %s FROM notifications`, columns, columns),
+		`DROP TABLE notifications`,
+		`ALTER TABLE notifications_new RENAME TO notifications`,
+	}
+	for _, stmt := range statements {
+		if _, err := tx.Exec(stmt); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to migrate notifications: %v", err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Register User
+func (dm *DatabaseManager) RegisterUser(username, password string) (int, error) {
+	defer dm.span("RegisterUser").End()
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	query := `INSERT INTO users (username, password) VALUES (?, ?)`
+	result, err := dm.db.Exec(query, username, password)
+	if err != nil {
+		return 0, fmt.Errorf("failed to register user: %v", err)
+	}
+
+	id, err := result.LastInsertId()
+	return int(id), err
+}
+
+func (dm *DatabaseManager) GetUserByUsername(username string) (*User, error) {
+	defer dm.span("GetUserByUsername").End()
+	dm.mu.RLock()
+	defer dm.mu.RUnlock()
+
+	var user User
+	query := `SELECT id, username, karma FROM users WHERE username = ?`
+	err := dm.db.QueryRow(query, username).Scan(&user.ID, &user.Username, &user.Karma)
+	if err != nil {
+		return nil, fmt.Errorf("user not found: %v", err)
+	}
+
+	return &user, nil
+}
+
+// ErrInvalidCredentials is returned when a login's username or password is wrong
+var ErrInvalidCredentials = errors.New("invalid username or password")
+
+// AuthenticateUser checks a username and password and returns the user's ID
+func (dm *DatabaseManager) AuthenticateUser(username, password string) (int, error) {
+	defer dm.span("AuthenticateUser").End()
+	dm.mu.RLock()
+	defer dm.mu.RUnlock()
+
+	var userID int
+	var stored string
+	err := dm.db.QueryRow(`SELECT id, password FROM users WHERE username = ?`, username).Scan(&userID, &stored)
+	if err == sql.ErrNoRows {
+		return 0, ErrInvalidCredentials
+	}
+	if err != nil {
+		return 0, err
+	}
+	if subtle.ConstantTimeCompare([]byte(stored), []byte(password)) != 1 {
+		return 0, ErrInvalidCredentials
+	}
+
+	return userID, nil
+}
+
+// randomHex returns n random bytes encoded as hex
+func randomHex(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// hashToken is how session tokens are stored, so a leaked database can't be replayed
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateSession records a login and returns the session ID and bearer token
+func (dm *DatabaseManager) CreateSession(userID int, ip, userAgent string) (string, string, error) {
+	defer dm.span("CreateSession").End()
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	sessionID, err := randomHex(8)
+	if err != nil {
+		return "", "", err
+	}
+	token, err := randomHex(32)
+	if err != nil {
+		return "", "", err
+	}
+
+	_, err = dm.db.Exec(`
+		INSERT INTO sessions (id, user_id, token_hash, ip, user_agent)
+		VALUES (?, ?, ?, ?, ?)
+	`, sessionID, userID, hashToken(token), ip, userAgent)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to create session: %v", err)
+	}
+
+	return sessionID, token, nil
+}
+
+// Impersonation scopes
+const (
+	impersonationRead  = "read"  // GET requests only
+	impersonationWrite = "write" // any request
+)
+
+// Impersonation token lifetimes
+const (
+	defaultImpersonationMinutes = 15
+	maxImpersonationMinutes     = 60
+)
+
+// CreateImpersonationSession issues a short-lived session letting an admin act
+// as another user, and records it in the admin audit log
+func (dm *DatabaseManager) CreateImpersonationSession(adminID, userID int, scope string, minutes int, reason, ip, userAgent string) (string, string, time.Time, error) {
+	defer dm.span("CreateImpersonationSession").End()
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	sessionID, err := randomHex(8)
+	if err != nil {
+		return "", "", time.Time{}, err
+	}
+	token, err := randomHex(32)
+	if err != nil {
+		return "", "", time.Time{}, err
+	}
+	expiresAt := time.Now().UTC().Add(time.Duration(minutes) * time.Minute)
+
+	tx, err := dm.db.Begin()
+	if err != nil {
+		return "", "", time.Time{}, err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO sessions (id, user_id, token_hash, ip, user_agent, impersonator_id, scope, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, datetime('now', ?))
+	`, sessionID, userID, hashToken(token), ip, userAgent, adminID, scope, fmt.Sprintf("+%d minutes", minutes))
+	if err != nil {
+		tx.Rollback()
+		return "", "", time.Time{}, fmt.Errorf("failed to create session: %v", err)
+	}
+
+	details := fmt.Sprintf("session %s, scope %s, %d minutes", sessionID, scope, minutes)
+	if err := logAdminAction(tx, adminID, "impersonate_user", "user", &userID, reason, details); err != nil {
+		tx.Rollback()
+		return "", "", time.Time{}, err
+	}
+
+	return sessionID, token, expiresAt, tx.Commit()
+}
+
+// AuthSession is the session a bearer token resolved to
+type AuthSession struct {
+	UserID         int
+	SessionID      string
+	ImpersonatorID *int   // set when an admin is acting as the user
+	Scope          string // impersonation scope
+	ExpiresAt      *time.Time
+}
+
+// GetSessionByToken resolves an unrevoked, unexpired bearer token to its user
+// and session, refreshing the session's last seen time at most once a minute
+func (dm *DatabaseManager) GetSessionByToken(token string) (*AuthSession, error) {
+	defer dm.span("GetSessionByToken").End()
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	var session AuthSession
+	err := dm.db.QueryRow(`
+		SELECT user_id, id, impersonator_id, COALESCE(scope, ''), expires_at FROM sessions
+		WHERE token_hash = ? AND revoked_at IS NULL
+		  AND (expires_at IS NULL OR expires_at > CURRENT_TIMESTAMP)
+	`, hashToken(token)).Scan(&session.UserID, &session.SessionID, &session.ImpersonatorID, &session.Scope, &session.ExpiresAt)
+	if err != nil {
+		return nil, fmt.Errorf("invalid session: %v", err)
+	}
+
+	_, err = dm.db.Exec(`
+		UPDATE sessions SET last_seen_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND last_seen_at < datetime('now', '-60 seconds')
+	`, session.SessionID)
+
+	return &session, err
+}
+
+// logAdminAction appends an entry to the admin audit log
+func logAdminAction(db execer, adminID int, action, targetType string, targetID *int, reason, details string) error {
+	_, err := db.Exec(`
+		INSERT INTO admin_audit_log (admin_id, action, target_type, target_id, reason, details)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, adminID, action, targetType, targetID, reason, details)
+	if err != nil {
+		return fmt.Errorf("failed to record admin action: %v", err)
+	}
+	return nil
+}
+
+// LogAdminAction records a privileged action in the admin audit log
+func (dm *DatabaseManager) LogAdminAction(adminID int, action, targetType string, targetID *int, reason, details string) error {
+	defer dm.span("LogAdminAction").End()
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+	return logAdminAction(dm.db, adminID, action, targetType, targetID, reason, details)
+}
+
+// GetUserSessions lists a user's login history, newest first
+func (dm *DatabaseManager) GetUserSessions(userID int) ([]Session, error) {
+	defer dm.span("GetUserSessions").End()
+	dm.mu.RLock()
+	defer dm.mu.RUnlock()
+
+	rows, err := dm.db.Query(`
+		SELECT id, COALESCE(ip, ''), COALESCE(user_agent, ''), created_at, last_seen_at, revoked_at
+		FROM sessions
+		WHERE user_id = ?
+		ORDER BY created_at DESC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []Session{}
+	for rows.Next() {
+		var session Session
+		var revokedAt sql.NullTime
+		err := rows.Scan(
+			&session.ID, &session.IP, &session.UserAgent,
+			&session.CreatedAt, &session.LastSeenAt, &revokedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if revokedAt.Valid {
+			session.RevokedAt = &revokedAt.Time
+		}
+		sessions = append(sessions, session)
+	}
+
+	return sessions, nil
+}
+
+// RevokeSession invalidates one of the user's sessions
+func (dm *DatabaseManager) RevokeSession(userID int, sessionID string) error {
+	defer dm.span("RevokeSession").End()
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	result, err := dm.db.Exec(`
+		UPDATE sessions SET revoked_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND user_id = ? AND revoked_at IS NULL
+	`, sessionID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke session: %v", err)
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return fmt.Errorf("session not found")
+	}
+
+	return nil
+}
+
+// GetBetaOptIns returns the beta features the user has opted into
+func (dm *DatabaseManager) GetBetaOptIns(userID int) (map[string]bool, error) {
+	defer dm.span("GetBetaOptIns").End()
+	dm.mu.RLock()
+	defer dm.mu.RUnlock()
+
+	rows, err := dm.db.Query(`SELECT feature FROM user_beta_optins WHERE user_id = ?`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	optIns := make(map[string]bool)
+	for rows.Next() {
+		var feature string
+		if err := rows.Scan(&feature); err != nil {
+			return nil, err
+		}
+		optIns[feature] = true
+	}
+
+	return optIns, nil
+}
+
+// SetBetaOptIn opts the user into or out of a beta feature
+func (dm *DatabaseManager) SetBetaOptIn(userID int, feature string, enabled bool) error {
+	defer dm.span("SetBetaOptIn").End()
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	var err error
+	if enabled {
+		_, err = dm.db.Exec(`
+			INSERT OR IGNORE INTO user_beta_optins (user_id, feature)
+			VALUES (?, ?)
+		`, userID, feature)
+	} else {
+		_, err = dm.db.Exec(`
+			DELETE FROM user_beta_optins
+			WHERE user_id = ? AND feature = ?
+		`, userID, feature)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update beta opt-in: %v", err)
+	}
+
+	return nil
+}
+
+// Subreddit Operations
+func (dm *DatabaseManager) CreateSubreddit(name, description string, creatorID int) (int, error) {
+	defer dm.span("CreateSubreddit").End()
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	tx, err := dm.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+
+	if err := checkSubredditKarma(tx, dm.limits, creatorID); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	// Create subreddit
+	result, err := tx.Exec(`INSERT INTO subreddits (name, description, owner_id) VALUES (?, ?, ?)`, name, description, creatorID)
+	if err != nil {
+		tx.Rollback()
+		return 0, fmt.Errorf("failed to create subreddit: %v", err)
+	}
+
+	subredditID, err := result.LastInsertId()
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	// Add creator as first member
+	_, err = tx.Exec(`
+		INSERT INTO subreddit_members (subreddit_id, user_id) 
+		VALUES (?, ?)
+	`, subredditID, creatorID)
+
+	if err != nil {
+		tx.Rollback()
+		return 0, fmt.Errorf("failed to add creator to subreddit: %v", err)
+	}
+
+	// Creator moderates the subreddit
+	_, err = tx.Exec(`
+		INSERT INTO subreddit_moderators (subreddit_id, user_id)
+		VALUES (?, ?)
+	`, subredditID, creatorID)
+
+	if err != nil {
+		tx.Rollback()
+		return 0, fmt.Errorf("failed to add creator as moderator: %v", err)
+	}
+
+	err = tx.Commit()
+	return int(subredditID), err
+}
+
+func (dm *DatabaseManager) JoinSubreddit(userID, subredditID int) error {
+	defer dm.span("JoinSubreddit").End()
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	tx, err := dm.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		INSERT OR IGNORE INTO subreddit_members (subreddit_id, user_id) 
+		VALUES (?, ?)
+	`, subredditID, userID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := dm.enqueueOutboxEvent(tx, UserSubscribedEvent{UserID: userID, SubredditID: subredditID}); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (dm *DatabaseManager) LeaveSubreddit(userID, subredditID int) error {
+	defer dm.span("LeaveSubreddit").End()
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	tx, err := dm.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		DELETE FROM subreddit_members 
+		WHERE subreddit_id = ? AND user_id = ?
+	`, subredditID, userID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := dm.enqueueOutboxEvent(tx, UserUnsubscribedEvent{UserID: userID, SubredditID: subredditID}); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Create Reddit Post
+func (dm *DatabaseManager) CreatePost(title, content string, authorID, subredditID int, kind string, crosspostOf *int) (int, AutomodOutcome, error) {
+	defer dm.span("CreatePost").End()
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	tx, err := dm.db.Begin()
+	if err != nil {
+		return 0, AutomodOutcome{}, err
+	}
+
+	if err := checkSubredditExists(tx, subredditID); err != nil {
+		tx.Rollback()
+		return 0, AutomodOutcome{}, err
+	}
+	if err := checkSubredditWritable(tx, subredditID); err != nil {
+		tx.Rollback()
+		return 0, AutomodOutcome{}, err
+	}
+	if err := checkNotBanned(tx, subredditID, authorID); err != nil {
+		tx.Rollback()
+		return 0, AutomodOutcome{}, err
+	}
+	if err := checkPostingRate(tx, dm.limits, authorID, subredditID); err != nil {
+		tx.Rollback()
+		return 0, AutomodOutcome{}, err
+	}
+	if kind == "" {
+		kind = postKindText
+	}
+	if err := checkPostContent(tx, subredditID, title, content, kind, crosspostOf); err != nil {
+		tx.Rollback()
+		return 0, AutomodOutcome{}, err
+	}
+
+	result, err := tx.Exec(`
+		INSERT INTO posts (title, content, author_id, subreddit_id, kind, crosspost_of, slug) 
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, title, content, authorID, subredditID, kind, crosspostOf, slugify(title))
+
+	if err != nil {
+		tx.Rollback()
+		return 0, AutomodOutcome{}, fmt.Errorf("failed to create post: %v", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		tx.Rollback()
+		return 0, AutomodOutcome{}, err
+	}
+
+	outcome, err := applyAutomodRules(tx, subredditID, "post", int(id), authorID, title+"\n"+content)
+	if err != nil {
+		tx.Rollback()
+		return 0, AutomodOutcome{}, err
+	}
+	if !outcome.Removed {
+		if err := applySpamCheck(tx, dm.spam, subredditID, "post", int(id), authorID, title, content, &outcome); err != nil {
+			tx.Rollback()
+			return 0, AutomodOutcome{}, err
+		}
+	}
+
+	if !outcome.Removed {
+		if err := recordMentions(tx, authorID, int(id), nil, content); err != nil {
+			tx.Rollback()
+			return 0, AutomodOutcome{}, err
+		}
+	}
+
+	event := PostCreatedEvent{PostID: int(id), AuthorID: authorID, SubredditID: subredditID, Title: title, Removed: outcome.Removed}
+	if err := dm.enqueueOutboxEvent(tx, event); err != nil {
+		tx.Rollback()
+		return 0, AutomodOutcome{}, err
+	}
+
+	return int(id), outcome, tx.Commit()
+}
+
+// mentionPattern matches u/username references that aren't part of a longer
+// word or path, e.g. "thanks u/alice" but not "example.com/u/alice"
+var mentionPattern = regexp.MustCompile(`(?:^|[^\w/])u/([A-Za-z0-9_-]+)`)
+
+// parseMentions returns the distinct usernames mentioned in content, in the
+// order they first appear
+func parseMentions(content string) []string {
+	var usernames []string
+	seen := make(map[string]bool)
+	for _, match := range mentionPattern.FindAllStringSubmatch(content, -1) {
+		if !seen[match[1]] {
+			seen[match[1]] = true
+			usernames = append(usernames, match[1])
+		}
+	}
+	return usernames
+}
+
+// recordMentions stores a mention and notifies each existing user mentioned
+// in a post or comment. Mentions of unknown users are ignored, as are users
+// already mentioned in it before it was edited.
+func recordMentions(tx *sql.Tx, authorID, postID int, commentID *int, content string) error {
+	for _, username := range parseMentions(content) {
+		var userID int
+		var mentioned bool
+		err := tx.QueryRow(`
+			SELECT id, EXISTS (SELECT 1 FROM mentions WHERE mentioned_user_id = users.id AND post_id = ? AND comment_id IS ?)
+			FROM users WHERE username = ?
+		`, postID, commentID, username).Scan(&userID, &mentioned)
+		if err == sql.ErrNoRows || (err == nil && (userID == authorID || mentioned)) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to look up mentioned user: %v", err)
+		}
+
+		_, err = tx.Exec(`
+			INSERT INTO mentions (mentioned_user_id, author_id, post_id, comment_id)
+			VALUES (?, ?, ?, ?)
+		`, userID, authorID, postID, commentID)
+		if err != nil {
+			return fmt.Errorf("failed to record mention: %v", err)
+		}
+
+		if err := createNotification(tx, userID, "mention", authorID, notificationRefs{PostID: &postID, CommentID: commentID}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Function to retrieve user's top feed items
+// Returns limit posts from offset, newest first, or all of them when limit
+// is allPosts.
+func (dm *DatabaseManager) GetFeed(userID, limit, offset int) ([]Post, error) {
+	defer dm.span("GetFeed").End()
+	dm.mu.RLock()
+	defer dm.mu.RUnlock()
+
+	query := `
+		SELECT ` + postColumns + `
+		FROM posts p
+		JOIN subreddit_members sm ON p.subreddit_id = sm.subreddit_id
+		JOIN users u ON p.author_id = u.id
+		JOIN subreddits s ON p.subreddit_id = s.id
+		WHERE sm.user_id = ? AND p.removed = 0 AND p.deleted_at IS NULL AND ` + mutedPostFilter + `
+		AND ` + nsfwPostFilter + `
+		ORDER BY p.created_at DESC, p.id DESC
+		LIMIT ? OFFSET ?
+	`
+
+	rows, err := dm.db.Query(query, userID, userID, userID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanPosts(rows)
+}
+
+// GetFollowingFeed returns limit posts from offset written by the users the
+// given user subscribes to, newest first, or all of them when limit is
+// allPosts
+func (dm *DatabaseManager) GetFollowingFeed(userID, limit, offset int) ([]Post, error) {
+	defer dm.span("GetFollowingFeed").End()
+	dm.mu.RLock()
+	defer dm.mu.RUnlock()
+
+	rows, err := dm.db.Query(`
+		SELECT `+postColumns+`
+		FROM posts p
+		JOIN user_subscriptions us ON p.author_id = us.subscribed_user_id
+		JOIN users u ON p.author_id = u.id
+		JOIN subreddits s ON p.subreddit_id = s.id
+		WHERE us.subscriber_id = ? AND p.removed = 0 AND p.deleted_at IS NULL AND `+mutedPostFilter+`
+		AND `+nsfwPostFilter+`
+		ORDER BY p.created_at DESC, p.id DESC
+		LIMIT ? OFFSET ?
+	`, userID, userID, userID, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get following feed: %v", err)
+	}
+	defer rows.Close()
+
+	return scanPosts(rows)
+}
+
+// ErrVoteReplay is returned when a vote reuses a nonce the user already sent
+var ErrVoteReplay = errors.New("vote request has already been processed")
+
+// ErrStaleVote is returned for a vote whose timestamp is too far from the
+// server clock
+var ErrStaleVote = errors.New("vote timestamp is outside the accepted window")
+
+// Function to let user upvote or downvote on a post and calculate User Karma.
+// The nonce is recorded alongside the vote so a replayed request is rejected
+// with ErrVoteReplay instead of counting twice.
+func (dm *DatabaseManager) Vote(userID, targetID int, targetType string, value int, nonce string) error {
+	defer dm.span("Vote").End()
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	tx, err := dm.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if err := pruneVoteNonces(tx, userID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := claimVoteNonce(tx, userID, nonce); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := applyVote(tx, userID, targetID, targetType, value); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	event := VoteCastEvent{UserID: userID, TargetID: targetID, TargetType: targetType, Value: value}
+	if err := dm.enqueueOutboxEvent(tx, event); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// pruneVoteNonces forgets the user's nonces older than the timestamp window.
+// Nonces only need to outlive it, after which stale requests are rejected
+// anyway.
+func pruneVoteNonces(tx *sql.Tx, userID int) error {
+	_, err := tx.Exec(`
+		DELETE FROM vote_nonces
+		WHERE user_id = ? AND created_at < datetime('now', ?)
+	`, userID, fmt.Sprintf("-%d seconds", int(2*voteMaxClockSkew.Seconds())))
+	if err != nil {
+		return fmt.Errorf("failed to prune vote nonces: %v", err)
+	}
+	return nil
+}
+
+// claimVoteNonce records a nonce the user sent a vote with, or returns
+// ErrVoteReplay if they already have
+func claimVoteNonce(tx *sql.Tx, userID int, nonce string) error {
+	var seen bool
+	err := tx.QueryRow(`
+		SELECT EXISTS(SELECT 1 FROM vote_nonces WHERE user_id = ? AND nonce = ?)
+	`, userID, nonce).Scan(&seen)
+	if err != nil {
+		return fmt.Errorf("failed to check vote nonce: %v", err)
+	}
+	if seen {
+		return ErrVoteReplay
+	}
+
+	if _, err := tx.Exec(`INSERT INTO vote_nonces (user_id, nonce) VALUES (?, ?)`, userID, nonce); err != nil {
+		return fmt.Errorf("failed to record vote nonce: %v", err)
+	}
+	return nil
+}
+
+// applyVote records a vote and credits its value to the target's author
+func applyVote(tx *sql.Tx, userID, targetID int, targetType string, value int) error {
+	if err := checkNotArchived(tx, targetID, targetType); err != nil {
+		return err
+	}
+
+	// Upsert vote
+	_, err := tx.Exec(`
+		INSERT INTO votes (user_id, target_id, target_type, vote_value) 
+		VALUES (?, ?, ?, ?)
+	`, userID, targetID, targetType, value)
+
+	if err != nil {
+		return fmt.Errorf("failed to record vote: %v", err)
+	}
+
+	// Update karma based on vote type and target
+	var updateQuery string
+	if targetType == "post" {
+		updateQuery = `
+			UPDATE users 
+			SET karma = karma + ? 
+			WHERE id = (SELECT author_id FROM posts WHERE id = ?)
+		`
+	} else { // comment
+		updateQuery = `
+			UPDATE users 
+			SET karma = karma + ? 
+			WHERE id = (SELECT author_id FROM comments WHERE id = ?)
+		`
+	}
+
+	_, err = tx.Exec(updateQuery, value, targetID)
+	if err != nil {
+		return fmt.Errorf("failed to update karma: %v", err)
+	}
+
+	// Comments keep their score denormalized for the top comments leaderboard
+	if targetType == "comment" {
+		_, err = tx.Exec(`UPDATE comments SET score = score + ? WHERE id = ?`, value, targetID)
+		if err != nil {
+			return fmt.Errorf("failed to update comment score: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// GetTargetSubredditID returns the subreddit of a post or comment
+func (dm *DatabaseManager) GetTargetSubredditID(targetID int, targetType string) (int, error) {
+	defer dm.span("GetTargetSubredditID").End()
+	dm.mu.RLock()
+	defer dm.mu.RUnlock()
+
+	query := `SELECT subreddit_id FROM posts WHERE id = ?`
+	if targetType == "comment" {
+		query = `SELECT p.subreddit_id FROM comments c JOIN posts p ON c.post_id = p.id WHERE c.id = ?`
+	}
+
+	var subredditID int
+	if err := dm.db.QueryRow(query, targetID).Scan(&subredditID); err != nil {
+		return 0, fmt.Errorf("%s not found: %v", targetType, err)
+	}
+	return subredditID, nil
+}
+
+// BulkVote is one vote event of a bulk ingestion stream
+type BulkVote struct {
+	UserID     int    `json:"user_id"`
+	TargetID   int    `json:"target_id"`
+	TargetType string `json:"target_type"`
+	Value      int    `json:"value"`
+}
+
+// ApplyVoteBatch records a batch of votes in one transaction. Each vote runs
+// in its own savepoint, so a failing vote is rolled back on its own and
+// reported at its index in the returned slice (nil where the vote was
+// recorded). Bulk votes skip the nonce replay check.
+func (dm *DatabaseManager) ApplyVoteBatch(votes []BulkVote) ([]error, error) {
+	defer dm.span("ApplyVoteBatch").End()
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	tx, err := dm.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]error, len(votes))
+	for i, v := range votes {
+		if _, err := tx.Exec(`SAVEPOINT bulk_vote`); err != nil {
+			tx.Rollback()
+			return nil, fmt.Errorf("failed to start vote: %v", err)
+		}
+
+		if err := applyVote(tx, v.UserID, v.TargetID, v.TargetType, v.Value); err != nil {
+			results[i] = err
+			if _, err := tx.Exec(`ROLLBACK TO bulk_vote`); err != nil {
+				tx.Rollback()
+				return nil, fmt.Errorf("failed to roll back vote: %v", err)
+			}
+		}
+
+		if _, err := tx.Exec(`RELEASE bulk_vote`); err != nil {
+			tx.Rollback()
+			return nil, fmt.Errorf("failed to release vote: %v", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit votes: %v", err)
+	}
+	return results, nil
+}
+
+// Function to let user comment on a post or reply to a comment
+func (dm *DatabaseManager) CreateComment(content string, authorID, postID int, parentCommentID *int) (int, AutomodOutcome, error) {
+	defer dm.span("CreateComment").End()
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	tx, err := dm.db.Begin()
+	if err != nil {
+		return 0, AutomodOutcome{}, err
+	}
+
+	var subredditID int
+	var archived bool
+	err = tx.QueryRow(`SELECT subreddit_id, archived_at IS NOT NULL FROM posts WHERE id = ? AND deleted_at IS NULL`, postID).Scan(&subredditID, &archived)
+	if err != nil {
+		tx.Rollback()
+		return 0, AutomodOutcome{}, fmt.Errorf("post not found: %v", err)
+	}
+	if archived {
+		tx.Rollback()
+		return 0, AutomodOutcome{}, ErrPostArchived
+	}
+	if err := checkSubredditWritable(tx, subredditID); err != nil {
+		tx.Rollback()
+		return 0, AutomodOutcome{}, err
+	}
+
+	if err := checkNotBanned(tx, subredditID, authorID); err != nil {
+		tx.Rollback()
+		return 0, AutomodOutcome{}, err
+	}
+
+	// Replies must stay in the thread of the post they were made on
+	if parentCommentID != nil {
+		var parentPostID int
+		err = tx.QueryRow(`SELECT post_id FROM comments WHERE id = ? AND deleted_at IS NULL`, *parentCommentID).Scan(&parentPostID)
+		if err != nil || parentPostID != postID {
+			tx.Rollback()
+			return 0, AutomodOutcome{}, fmt.Errorf("parent comment not found on this post")
+		}
+	}
+
+	query := `
+		INSERT INTO comments (content, author_id, post_id, parent_comment_id) 
+		VALUES (?, ?, ?, ?)
+	`
+
+	result, err := tx.Exec(query, content, authorID, postID, parentCommentID)
+	if err != nil {
+		tx.Rollback()
+		return 0, AutomodOutcome{}, fmt.Errorf("failed to create comment: %v", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		tx.Rollback()
+		return 0, AutomodOutcome{}, err
+	}
+
+	outcome, err := applyAutomodRules(tx, subredditID, "comment", int(id), authorID, content)
+	if err != nil {
+		tx.Rollback()
+		return 0, AutomodOutcome{}, err
+	}
+	if !outcome.Removed {
+		if err := applySpamCheck(tx, dm.spam, subredditID, "comment", int(id), authorID, "", content, &outcome); err != nil {
+			tx.Rollback()
+			return 0, AutomodOutcome{}, err
+		}
+	}
+
+	if err := updateCommentCount(tx, postID); err != nil {
+		tx.Rollback()
+		return 0, AutomodOutcome{}, err
+	}
+
+	// Tell the author of the post or parent comment about the reply, unless
+	// automod removed it
+	if !outcome.Removed {
+		var recipientID int
+		if parentCommentID != nil {
+			err = tx.QueryRow(`SELECT author_id FROM comments WHERE id = ?`, *parentCommentID).Scan(&recipientID)
+		} else {
+			err = tx.QueryRow(`SELECT author_id FROM posts WHERE id = ?`, postID).Scan(&recipientID)
+		}
+		if err != nil {
+			tx.Rollback()
+			return 0, AutomodOutcome{}, fmt.Errorf("failed to find reply recipient: %v", err)
+		}
+
+		commentID := int(id)
+		if err := createNotification(tx, recipientID, "reply", authorID, notificationRefs{PostID: &postID, CommentID: &commentID}); err != nil {
+			tx.Rollback()
+			return 0, AutomodOutcome{}, err
+		}
+
+		if err := recordMentions(tx, authorID, postID, &commentID, content); err != nil {
+			tx.Rollback()
+			return 0, AutomodOutcome{}, err
+		}
+	}
+
+	event := CommentCreatedEvent{CommentID: int(id), PostID: postID, ParentCommentID: parentCommentID, AuthorID: authorID, Removed: outcome.Removed}
+	if err := dm.enqueueOutboxEvent(tx, event); err != nil {
+		tx.Rollback()
+		return 0, AutomodOutcome{}, err
+	}
+
+	return int(id), outcome, tx.Commit()
+}
+
+// Function to let users send messages to other users
+func (dm *DatabaseManager) SendDirectMessage(fromUserID, toUserID int, content string) (int, error) {
+	defer dm.span("SendDirectMessage").End()
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	tx, err := dm.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+
+	result, err := tx.Exec(`
+		INSERT INTO direct_messages (from_user_id, to_user_id, content) 
+		VALUES (?, ?, ?)
+	`, fromUserID, toUserID, content)
+
+	if err != nil {
+		tx.Rollback()
+		return 0, fmt.Errorf("failed to send message: %v", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	messageID := int(id)
+	if err := createNotification(tx, toUserID, "message", fromUserID, notificationRefs{MessageID: &messageID}); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	if err := dm.enqueueOutboxEvent(tx, MessageSentEvent{MessageID: messageID, FromUserID: fromUserID, ToUserID: toUserID}); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	return messageID, tx.Commit()
+}
+
+// notificationPreferenceTypes are the notification types users choose
+// delivery channels for. Chat notifications follow the message preference.
+var notificationPreferenceTypes = []string{"reply", "mention", "message", "follow", "mod_action", "award"}
+
+// preferenceTypeOf returns the preference type governing a notification type
+func preferenceTypeOf(notificationType string) string {
+	if strings.HasPrefix(notificationType, "chat_") {
+		return "message"
+	}
+	return notificationType
+}
+
+// NotificationChannels is how a type of notification is delivered to a user
+type NotificationChannels struct {
+	InApp bool `json:"in_app"` // listed by GET /notifications
+	Push  bool `json:"push"`   // pushed to the user's real-time connections
+	Email bool `json:"email"`  // queued for email delivery
+}
+
+// any reports whether the notification is delivered at all
+func (ch NotificationChannels) any() bool {
+	return ch.InApp || ch.Push || ch.Email
+}
+
+// defaultNotificationChannels apply to notification types a user hasn't
+// configured. notifyChatMembers mirrors them in SQL.
+var defaultNotificationChannels = NotificationChannels{InApp: true, Push: true}
+
+// notificationRefs are the things a notification points at. Only the ones
+// relevant to its type are set.
+type notificationRefs struct {
+	PostID      *int
+	CommentID   *int
+	MessageID   *int
+	ChatRoomID  *int
+	SubredditID *int
+}
+
+// queryExecer is satisfied by both *sql.DB and *sql.Tx
+type queryExecer interface {
+	execer
+	rowQueryer
+}
+
+// notificationChannels returns the user's delivery channels for a type of
+// notification
+func notificationChannels(db rowQueryer, userID int, notificationType string) (NotificationChannels, error) {
+	var ch NotificationChannels
+	err := db.QueryRow(`
+		SELECT in_app, push, email FROM notification_preferences WHERE user_id = ? AND type = ?
+	`, userID, preferenceTypeOf(notificationType)).Scan(&ch.InApp, &ch.Push, &ch.Email)
+	if err == sql.ErrNoRows {
+		return defaultNotificationChannels, nil
+	}
+	if err != nil {
+		return ch, fmt.Errorf("failed to get notification preferences: %v", err)
+	}
+	return ch, nil
+}
+
+// pendingIf returns the delivery status of a channel: pending when the
+// notification is to be delivered on it, NULL otherwise
+func pendingIf(enabled bool) interface{} {
+	if enabled {
+		return "pending"
+	}
+	return nil
+}
+
+// createNotification is the notification dispatcher: it notifies a user of
+// something another user did on the channels the user chose for that type.
+// Users are never notified of their own actions. Push and email deliveries
+// are marked pending for publishNotifications and the mailer to pick up.
+func createNotification(db queryExecer, userID int, notificationType string, actorID int, refs notificationRefs) error {
+	if userID == actorID {
+		return nil
+	}
+
+	channels, err := notificationChannels(db, userID, notificationType)
+	if err != nil {
+		return err
+	}
+	if !channels.any() {
+		return nil
+	}
+
+	_, err = db.Exec(`
+		INSERT INTO notifications (user_id, type, actor_id, post_id, comment_id, message_id, chat_room_id,
+			subreddit_id, in_app, push_status, email_status)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, userID, notificationType, actorID, refs.PostID, refs.CommentID, refs.MessageID, refs.ChatRoomID,
+		refs.SubredditID, channels.InApp, pendingIf(channels.Push), pendingIf(channels.Email))
+	if err != nil {
+		return fmt.Errorf("failed to create notification: %v", err)
+	}
+
+	return nil
+}
+
+// GetNotificationPreferences returns the user's delivery channels for every
+// notification preference type
+func (dm *DatabaseManager) GetNotificationPreferences(userID int) (map[string]NotificationChannels, error) {
+	defer dm.span("GetNotificationPreferences").End()
+	dm.mu.RLock()
+	defer dm.mu.RUnlock()
+
+	prefs := make(map[string]NotificationChannels, len(notificationPreferenceTypes))
+	for _, t := range notificationPreferenceTypes {
+		prefs[t] = defaultNotificationChannels
+	}
+
+	rows, err := dm.db.Query(`
+		SELECT type, in_app, push, email FROM notification_preferences WHERE user_id = ?
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get notification preferences: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var t string
+		var ch NotificationChannels
+		if err := rows.Scan(&t, &ch.InApp, &ch.Push, &ch.Email); err != nil {
+			return nil, err
+		}
+		prefs[t] = ch
+	}
+
+	return prefs, rows.Err()
+}
+
+// SetNotificationPreferences stores the user's delivery channels for the
+// given notification types
+func (dm *DatabaseManager) SetNotificationPreferences(userID int, prefs map[string]NotificationChannels) error {
+	defer dm.span("SetNotificationPreferences").End()
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	tx, err := dm.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	for t, ch := range prefs {
+		_, err := tx.Exec(`
+			INSERT INTO notification_preferences (user_id, type, in_app, push, email)
+			VALUES (?, ?, ?, ?, ?)
+			ON CONFLICT(user_id, type) DO UPDATE SET
+				in_app = excluded.in_app,
+				push = excluded.push,
+				email = excluded.email
+		`, userID, t, ch.InApp, ch.Push, ch.Email)
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to update notification preferences: %v", err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// pushRetention is how long a push waits for its recipient to connect. Older
+// pushes are never sent, though their notifications stay listed in-app.
+const pushRetention = 24 * time.Hour
+
+// PendingPushes returns the notifications from the last pushRetention that
+// are waiting for push delivery, oldest first
+func (dm *DatabaseManager) PendingPushes() ([]UserNotification, error) {
+	defer dm.span("PendingPushes").End()
+	dm.mu.RLock()
+	defer dm.mu.RUnlock()
+
+	rows, err := dm.db.Query(`
+		SELECT n.user_id, `+notificationColumns+`
+		FROM notifications n
+		JOIN users u ON n.actor_id = u.id
+		WHERE n.push_status = 'pending' AND n.created_at >= datetime('now', ?)
+		ORDER BY n.id
+	`, fmt.Sprintf("-%d seconds", int(pushRetention.Seconds())))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pending pushes: %v", err)
+	}
+	defer rows.Close()
+
+	var notifications []UserNotification
+	for rows.Next() {
+		var n UserNotification
+		if err := rows.Scan(append([]interface{}{&n.UserID}, n.scanFields()...)...); err != nil {
+			return nil, err
+		}
+		notifications = append(notifications, n)
+	}
+	return notifications, rows.Err()
+}
+
+// MarkPushesSent marks notifications as delivered by push
+func (dm *DatabaseManager) MarkPushesSent(ids []int) error {
+	defer dm.span("MarkPushesSent").End()
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	tx, err := dm.db.Begin()
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if _, err := tx.Exec(`UPDATE notifications SET push_status = 'sent' WHERE id = ?`, id); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to mark push sent: %v", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// Email digest frequencies. With a digest, emailed notifications are bundled
+// into one email per period instead of being sent as they happen.
+var emailDigestPeriods = map[string]time.Duration{
+	"off":    0,
+	"daily":  24 * time.Hour,
+	"weekly": 7 * 24 * time.Hour,
+}
+
+// emailVerificationTTL is how long an email verification link stays valid
+const emailVerificationTTL = 24 * time.Hour
+
+// UserEmail is a user's email address and email settings
+type UserEmail struct {
+	Email    string `json:"email"`
+	Verified bool   `json:"verified"`
+	Digest   string `json:"digest"` // off, daily or weekly
+}
+
+// SetUserEmail sets the user's email address, which must be verified again,
+// and returns the verification token
+func (dm *DatabaseManager) SetUserEmail(userID int, email string) (string, error) {
+	defer dm.span("SetUserEmail").End()
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	token, err := randomHex(32)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = dm.db.Exec(`
+		INSERT INTO user_emails (user_id, email, verify_token_hash, verify_expires_at)
+		VALUES (?, ?, ?, datetime('now', ?))
+		ON CONFLICT(user_id) DO UPDATE SET
+			email = excluded.email,
+			verified_at = NULL,
+			verify_token_hash = excluded.verify_token_hash,
+			verify_expires_at = excluded.verify_expires_at
+	`, userID, email, hashToken(token), fmt.Sprintf("+%d seconds", int(emailVerificationTTL.Seconds())))
+	if err != nil {
+		return "", fmt.Errorf("failed to set email: %v", err)
+	}
+
+	return token, nil
+}
+
+// RenewEmailVerification issues a new verification token for the user's
+// unverified email address
+func (dm *DatabaseManager) RenewEmailVerification(userID int) (string, string, error) {
+	defer dm.span("RenewEmailVerification").End()
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	token, err := randomHex(32)
+	if err != nil {
+		return "", "", err
+	}
+
+	var email string
+	err = dm.db.QueryRow(`
+		UPDATE user_emails SET verify_token_hash = ?, verify_expires_at = datetime('now', ?)
+		WHERE user_id = ? AND verified_at IS NULL
+		RETURNING email
+	`, hashToken(token), fmt.Sprintf("+%d seconds", int(emailVerificationTTL.Seconds())), userID).Scan(&email)
+	if err != nil {
+		return "", "", fmt.Errorf("no unverified email: %v", err)
+	}
+
+	return email, token, nil
+}
+
+// VerifyEmail marks the email address a verification token was issued for as
+// verified
+func (dm *DatabaseManager) VerifyEmail(token string) error {
+	defer dm.span("VerifyEmail").End()
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	result, err := dm.db.Exec(`
+		UPDATE user_emails
+		SET verified_at = CURRENT_TIMESTAMP, verify_token_hash = NULL, verify_expires_at = NULL
+		WHERE verify_token_hash = ? AND verify_expires_at > CURRENT_TIMESTAMP
+	`, hashToken(token))
+	if err != nil {
+		return fmt.Errorf("failed to verify email: %v", err)
+	}
+	if verified, _ := result.RowsAffected(); verified == 0 {
+		return fmt.Errorf("invalid or expired verification token")
+	}
+
+	return nil
+}
+
+// GetUserEmail returns the user's email settings, or nil when the user has no
+// email address
+func (dm *DatabaseManager) GetUserEmail(userID int) (*UserEmail, error) {
+	defer dm.span("GetUserEmail").End()
+	dm.mu.RLock()
+	defer dm.mu.RUnlock()
+
+	var email UserEmail
+	err := dm.db.QueryRow(`
+		SELECT email, verified_at IS NOT NULL, digest FROM user_emails WHERE user_id = ?
+	`, userID).Scan(&email.Email, &email.Verified, &email.Digest)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get email: %v", err)
+	}
+
+	return &email, nil
+}
+
+// SetEmailDigest sets how often the user's emailed notifications are sent
+func (dm *DatabaseManager) SetEmailDigest(userID int, digest string) error {
+	defer dm.span("SetEmailDigest").End()
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	result, err := dm.db.Exec(`UPDATE user_emails SET digest = ? WHERE user_id = ?`, digest, userID)
+	if err != nil {
+		return fmt.Errorf("failed to set digest: %v", err)
+	}
+	if updated, _ := result.RowsAffected(); updated == 0 {
+		return fmt.Errorf("no email address set")
+	}
+
+	return nil
+}
+
+// QueueEmail queues an email for the email job to send
+func (dm *DatabaseManager) QueueEmail(userID int, to, kind, subject, body string) error {
+	defer dm.span("QueueEmail").End()
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+	return queueEmail(dm.db, userID, to, kind, subject, body)
+}
+
+func queueEmail(db execer, userID int, to, kind, subject, body string) error {
+	_, err := db.Exec(`
+		INSERT INTO email_queue (user_id, to_address, kind, subject, body) VALUES (?, ?, ?, ?, ?)
+	`, userID, to, kind, subject, body)
+	if err != nil {
+		return fmt.Errorf("failed to queue email: %v", err)
+	}
+	return nil
+}
+
+// pendingNotificationEmail is a notification waiting to be emailed, with its
+// recipient's email settings
+type pendingNotificationEmail struct {
+	UserNotification
+	Email        *string // nil unless the user has a verified address
+	Digest       string
+	LastDigestAt *time.Time
+}
+
+// QueueNotificationEmails turns notifications waiting for email delivery into
+// queued emails: one each for users without a digest, and one digest per
+// period for the rest. Notifications for users without a verified email
+// address are skipped. It returns how many emails were queued.
+func (dm *DatabaseManager) QueueNotificationEmails() (int, error) {
+	defer dm.span("QueueNotificationEmails").End()
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	tx, err := dm.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+
+	rows, err := tx.Query(`
+		SELECT n.user_id, ` + notificationColumns + `,
+			   CASE WHEN ue.verified_at IS NOT NULL THEN ue.email END,
+			   COALESCE(ue.digest, 'off'), ue.last_digest_at
+		FROM notifications n
+		JOIN users u ON n.actor_id = u.id
+		LEFT JOIN user_emails ue ON ue.user_id = n.user_id
+		WHERE n.email_status = 'pending'
+		ORDER BY n.user_id, n.id
+	`)
+	if err != nil {
+		tx.Rollback()
+		return 0, fmt.Errorf("failed to get pending emails: %v", err)
+	}
+
+	var pending []pendingNotificationEmail
+	for rows.Next() {
+		var p pendingNotificationEmail
+		fields := append([]interface{}{&p.UserID}, p.scanFields()...)
+		if err := rows.Scan(append(fields, &p.Email, &p.Digest, &p.LastDigestAt)...); err != nil {
+			rows.Close()
+			tx.Rollback()
+			return 0, err
+		}
+		pending = append(pending, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	setStatus := func(id int, status string) error {
+		_, err := tx.Exec(`UPDATE notifications SET email_status = ? WHERE id = ?`, status, id)
+		if err != nil {
+			return fmt.Errorf("failed to update email status: %v", err)
+		}
+		return nil
+	}
+
+	queued := 0
+	for start := 0; start < len(pending); {
+		// Rows are ordered by user, so each user's notifications are adjacent
+		end := start
+		for end < len(pending) && pending[end].UserID == pending[start].UserID {
+			end++
+		}
+		batch := pending[start:end]
+		start = end
+
+		first := batch[0]
+		if first.Email == nil {
+			for _, p := range batch {
+				if err := setStatus(p.ID, "skipped"); err != nil {
+					tx.Rollback()
+					return 0, err
+				}
+			}
+			continue
+		}
+
+		period := emailDigestPeriods[first.Digest]
+		if period == 0 {
+			for _, p := range batch {
+				subject, body := renderNotificationEmail(p.Notification)
+				if err := queueEmail(tx, p.UserID, *p.Email, "notification", subject, body); err != nil {
+					tx.Rollback()
+					return 0, err
+				}
+				if err := setStatus(p.ID, "queued"); err != nil {
+					tx.Rollback()
+					return 0, err
+				}
+				queued++
+			}
+			continue
+		}
+
+		// Digests wait until a period has passed since the last one
+		if first.LastDigestAt != nil && time.Since(*first.LastDigestAt) < period {
+			continue
+		}
+		notifications := make([]Notification, len(batch))
+		for i, p := range batch {
+			notifications[i] = p.Notification
+			if err := setStatus(p.ID, "queued"); err != nil {
+				tx.Rollback()
+				return 0, err
+			}
+		}
+		subject, body := renderDigestEmail(first.Digest, notifications)
+		if err := queueEmail(tx, first.UserID, *first.Email, "digest", subject, body); err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+		_, err := tx.Exec(`UPDATE user_emails SET last_digest_at = CURRENT_TIMESTAMP WHERE user_id = ?`, first.UserID)
+		if err != nil {
+			tx.Rollback()
+			return 0, fmt.Errorf("failed to record digest: %v", err)
+		}
+		queued++
+	}
+
+	return queued, tx.Commit()
+}
+
+// QueuedEmail is an email waiting to be sent
+type QueuedEmail struct {
+	ID       int
+	To       string
+	Subject  string
+	Body     string
+	Attempts int
+}
+
+// DueEmails returns queued emails that are due to be sent, oldest first
+func (dm *DatabaseManager) DueEmails(limit int) ([]QueuedEmail, error) {
+	defer dm.span("DueEmails").End()
+	dm.mu.RLock()
+	defer dm.mu.RUnlock()
+
+	rows, err := dm.db.Query(`
+		SELECT id, to_address, subject, body, attempts FROM email_queue
+		WHERE sent_at IS NULL AND failed_at IS NULL AND next_attempt_at <= CURRENT_TIMESTAMP
+		ORDER BY id
+		LIMIT ?
+	`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get due emails: %v", err)
+	}
+	defer rows.Close()
+
+	var due []QueuedEmail
+	for rows.Next() {
+		var e QueuedEmail
+		if err := rows.Scan(&e.ID, &e.To, &e.Subject, &e.Body, &e.Attempts); err != nil {
+			return nil, err
+		}
+		due = append(due, e)
+	}
+	return due, rows.Err()
+}
+
+// RecordEmailAttempt records the outcome of sending an email. A failed email
+// is retried after retryAfter, or given up on when retryAfter is 0.
+func (dm *DatabaseManager) RecordEmailAttempt(emailID int, sendErr error, retryAfter time.Duration) error {
+	defer dm.span("RecordEmailAttempt").End()
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	var err error
+	switch {
+	case sendErr == nil:
+		_, err = dm.db.Exec(`
+			UPDATE email_queue
+			SET attempts = attempts + 1, sent_at = CURRENT_TIMESTAMP, last_error = NULL
+			WHERE id = ?
+		`, emailID)
+	case retryAfter > 0:
+		_, err = dm.db.Exec(`
+			UPDATE email_queue
+			SET attempts = attempts + 1, last_error = ?, next_attempt_at = datetime('now', ?)
+			WHERE id = ?
+		`, sendErr.Error(), fmt.Sprintf("+%d seconds", int(retryAfter.Seconds())), emailID)
+	default:
+		_, err = dm.db.Exec(`
+			UPDATE email_queue
+			SET attempts = attempts + 1, last_error = ?, failed_at = CURRENT_TIMESTAMP
+			WHERE id = ?
+		`, sendErr.Error(), emailID)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to record email attempt: %v", err)
+	}
+	return nil
+}
+
+// maxChatRoomMembers caps how many users can be in one chat room
+const maxChatRoomMembers = 50
+
+// ErrNotChatMember is returned when a user acts on a chat room they aren't in
+var ErrNotChatMember = errors.New("you are not a member of this chat room")
+
+// ErrNotChatOwner is returned when a member who doesn't own a chat room tries
+// to remove someone else from it
+var ErrNotChatOwner = errors.New("only the room owner can remove other members")
+
+// ErrChatRoomFull is returned when an invite would exceed maxChatRoomMembers
+var ErrChatRoomFull = errors.New("chat room is full")
+
+// notifyChatMembers notifies members of a chat room of something actorID did
+// in it, on the channels each chose for messages. With userID set only that
+// member is notified, otherwise all of them are (never the actor).
+func notifyChatMembers(db execer, roomID int, notificationType string, actorID int, userID, messageID *int) error {
+	_, err := db.Exec(`
+		INSERT INTO notifications (user_id, type, actor_id, chat_room_id, message_id, in_app, push_status, email_status)
+		SELECT m.user_id, ?, ?, m.room_id, ?, COALESCE(p.in_app, 1),
+			   CASE WHEN COALESCE(p.push, 1) = 1 THEN 'pending' END,
+			   CASE WHEN COALESCE(p.email, 0) = 1 THEN 'pending' END
+		FROM chat_room_members m
+		LEFT JOIN notification_preferences p ON p.user_id = m.user_id AND p.type = 'message'
+		WHERE m.room_id = ? AND m.user_id != ? AND (? IS NULL OR m.user_id = ?)
+		  AND (COALESCE(p.in_app, 1) = 1 OR COALESCE(p.push, 1) = 1 OR COALESCE(p.email, 0) = 1)
+	`, notificationType, actorID, messageID, roomID, actorID, userID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to create notification: %v", err)
+	}
+	return nil
+}
+
+// isChatMember reports whether the user is in the chat room
+func isChatMember(db rowQueryer, roomID, userID int) (bool, error) {
+	var count int
+	err := db.QueryRow(`
+		SELECT COUNT(*) FROM chat_room_members WHERE room_id = ? AND user_id = ?
+	`, roomID, userID).Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("failed to check chat membership: %v", err)
+	}
+	return count > 0, nil
+}
+
+// addChatMember adds a user to a chat room and notifies them of the invite
+func addChatMember(tx *sql.Tx, roomID, userID, invitedBy int) error {
+	var members int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM chat_room_members WHERE room_id = ?`, roomID).Scan(&members); err != nil {
+		return fmt.Errorf("failed to count chat members: %v", err)
+	}
+	if members >= maxChatRoomMembers {
+		return ErrChatRoomFull
+	}
+
+	result, err := tx.Exec(`
+		INSERT OR IGNORE INTO chat_room_members (room_id, user_id, invited_by) VALUES (?, ?, ?)
+	`, roomID, userID, invitedBy)
+	if err != nil {
+		return fmt.Errorf("failed to add chat member: %v", err)
+	}
+	if added, _ := result.RowsAffected(); added == 0 {
+		return nil
+	}
+
+	return notifyChatMembers(tx, roomID, "chat_invite", invitedBy, &userID, nil)
+}
+
+// CreateChatRoom creates a chat room owned by ownerID with the given members
+func (dm *DatabaseManager) CreateChatRoom(ownerID int, name string, memberIDs []int) (int, error) {
+	defer dm.span("CreateChatRoom").End()
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	tx, err := dm.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+
+	result, err := tx.Exec(`INSERT INTO chat_rooms (name, owner_id) VALUES (?, ?)`, name, ownerID)
+	if err != nil {
+		tx.Rollback()
+		return 0, fmt.Errorf("failed to create chat room: %v", err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	roomID := int(id)
+
+	_, err = tx.Exec(`
+		INSERT INTO chat_room_members (room_id, user_id, invited_by) VALUES (?, ?, ?)
+	`, roomID, ownerID, ownerID)
+	if err != nil {
+		tx.Rollback()
+		return 0, fmt.Errorf("failed to add chat member: %v", err)
+	}
+
+	for _, memberID := range memberIDs {
+		if err := addChatMember(tx, roomID, memberID, ownerID); err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+	}
+
+	return roomID, tx.Commit()
+}
+
+// GetChatRooms lists the chat rooms the user is in, most recently active first
+func (dm *DatabaseManager) GetChatRooms(userID int) ([]ChatRoom, error) {
+	defer dm.span("GetChatRooms").End()
+	dm.mu.RLock()
+	defer dm.mu.RUnlock()
+
+	rows, err := dm.db.Query(`
+		SELECT r.id, r.name, r.owner_id, r.created_at,
+			   (SELECT COUNT(*) FROM chat_room_members WHERE room_id = r.id), lm.created_at
+		FROM chat_rooms r
+		JOIN chat_room_members m ON m.room_id = r.id
+		LEFT JOIN chat_messages lm ON lm.id = (SELECT MAX(id) FROM chat_messages WHERE room_id = r.id)
+		WHERE m.user_id = ?
+		ORDER BY COALESCE(lm.id, 0) DESC, r.id DESC
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get chat rooms: %v", err)
+	}
+	defer rows.Close()
+
+	rooms := []ChatRoom{}
+	for rows.Next() {
+		var room ChatRoom
+		if err := rows.Scan(&room.ID, &room.Name, &room.OwnerID, &room.CreatedAt,
+			&room.MemberCount, &room.LastMessageAt); err != nil {
+			return nil, err
+		}
+		rooms = append(rooms, room)
+	}
+
+	return rooms, rows.Err()
+}
+
+// GetChatRoom returns a chat room and its members, if the user is one of them
+func (dm *DatabaseManager) GetChatRoom(roomID, userID int) (*ChatRoom, error) {
+	defer dm.span("GetChatRoom").End()
+	dm.mu.RLock()
+	defer dm.mu.RUnlock()
+
+	var room ChatRoom
+	err := dm.db.QueryRow(`
+		SELECT id, name, owner_id, created_at FROM chat_rooms WHERE id = ?
+	`, roomID).Scan(&room.ID, &room.Name, &room.OwnerID, &room.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("chat room not found: %v", err)
+	}
+
+	rows, err := dm.db.Query(`
+		SELECT m.user_id, u.username, m.joined_at
+		FROM chat_room_members m
+		JOIN users u ON m.user_id = u.id
+		WHERE m.room_id = ?
+		ORDER BY m.joined_at, m.user_id
+	`, roomID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get chat members: %v", err)
+	}
+	defer rows.Close()
+
+	member := false
+	room.Members = []ChatMember{}
+	for rows.Next() {
+		var m ChatMember
+		if err := rows.Scan(&m.UserID, &m.Username, &m.JoinedAt); err != nil {
+			return nil, err
+		}
+		member = member || m.UserID == userID
+		room.Members = append(room.Members, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if !member {
+		return nil, ErrNotChatMember
+	}
+
+	room.MemberCount = len(room.Members)
+	return &room, nil
+}
+
+// InviteChatMember adds a user to a chat room. Any member can invite.
+func (dm *DatabaseManager) InviteChatMember(roomID, inviterID, userID int) error {
+	defer dm.span("InviteChatMember").End()
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	tx, err := dm.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	member, err := isChatMember(tx, roomID, inviterID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if !member {
+		tx.Rollback()
+		return ErrNotChatMember
+	}
+
+	if err := addChatMember(tx, roomID, userID, inviterID); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// RemoveChatMember removes a user from a chat room. Members can remove
+// themselves; only the owner can remove others. When the owner leaves, the
+// longest-standing member takes over, and a room left empty is deleted.
+func (dm *DatabaseManager) RemoveChatMember(roomID, removerID, userID int) error {
+	defer dm.span("RemoveChatMember").End()
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	tx, err := dm.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	var ownerID int
+	if err := tx.QueryRow(`SELECT owner_id FROM chat_rooms WHERE id = ?`, roomID).Scan(&ownerID); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("chat room not found: %v", err)
+	}
+	if removerID != userID && removerID != ownerID {
+		tx.Rollback()
+		return ErrNotChatOwner
+	}
+
+	result, err := tx.Exec(`DELETE FROM chat_room_members WHERE room_id = ? AND user_id = ?`, roomID, userID)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to remove chat member: %v", err)
+	}
+	if removed, _ := result.RowsAffected(); removed == 0 {
+		tx.Rollback()
+		return ErrNotChatMember
+	}
+
+	if userID == ownerID {
+		var newOwnerID int
+		err := tx.QueryRow(`
+			SELECT user_id FROM chat_room_members WHERE room_id = ?
+			ORDER BY joined_at, user_id LIMIT 1
+		`, roomID).Scan(&newOwnerID)
+		switch {
+		case err == sql.ErrNoRows:
+			if _, err = tx.Exec(`DELETE FROM chat_messages WHERE room_id = ?`, roomID); err == nil {
+				_, err = tx.Exec(`DELETE FROM chat_rooms WHERE id = ?`, roomID)
+			}
+		case err == nil:
+			_, err = tx.Exec(`UPDATE chat_rooms SET owner_id = ? WHERE id = ?`, newOwnerID, roomID)
+		}
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to update chat room: %v", err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// SendChatMessage posts a message to a chat room and notifies its other members
+func (dm *DatabaseManager) SendChatMessage(roomID, senderID int, content string) (int, error) {
+	defer dm.span("SendChatMessage").End()
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	tx, err := dm.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+
+	member, err := isChatMember(tx, roomID, senderID)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	if !member {
+		tx.Rollback()
+		return 0, ErrNotChatMember
+	}
+
+	result, err := tx.Exec(`
+		INSERT INTO chat_messages (room_id, sender_id, content) VALUES (?, ?, ?)
+	`, roomID, senderID, content)
+	if err != nil {
+		tx.Rollback()
+		return 0, fmt.Errorf("failed to send message: %v", err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	messageID := int(id)
+	if err := notifyChatMembers(tx, roomID, "chat_message", senderID, nil, &messageID); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	return messageID, tx.Commit()
+}
+
+// GetChatMessages returns a page of a chat room's history, newest first, and
+// whether older messages follow
+func (dm *DatabaseManager) GetChatMessages(roomID, userID, limit, offset int) ([]ChatMessage, bool, error) {
+	defer dm.span("GetChatMessages").End()
+	dm.mu.RLock()
+	defer dm.mu.RUnlock()
+
+	member, err := isChatMember(dm.db, roomID, userID)
+	if err != nil {
+		return nil, false, err
+	}
+	if !member {
+		return nil, false, ErrNotChatMember
+	}
+
+	rows, err := dm.db.Query(`
+		SELECT cm.id, cm.room_id, cm.sender_id, u.username, cm.content, cm.created_at
+		FROM chat_messages cm
+		JOIN users u ON cm.sender_id = u.id
+		WHERE cm.room_id = ?
+		ORDER BY cm.created_at DESC, cm.id DESC
+		LIMIT ? OFFSET ?
+	`, roomID, limit+1, offset)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to get messages: %v", err)
+	}
+	defer rows.Close()
+
+	messages := []ChatMessage{}
+	for rows.Next() {
+		var msg ChatMessage
+		if err := rows.Scan(&msg.ID, &msg.RoomID, &msg.SenderID, &msg.SenderUsername,
+			&msg.Content, &msg.CreatedAt); err != nil {
+			return nil, false, err
+		}
+		messages = append(messages, msg)
+	}
+
+	// One extra row was fetched to tell whether there is a next page
+	hasMore := len(messages) > limit
+	if hasMore {
+		messages = messages[:limit]
+	}
+	return messages, hasMore, rows.Err()
+}
+
+// UserNotification is a notification with its recipient
+type UserNotification struct {
+	UserID int
+	Notification
+}
+
+// notificationColumns selects the fields read by Notification.scanFields.
+// Queries using it must alias notifications as n and the actor as u.
+const notificationColumns = `
+	n.id, n.type, n.actor_id, u.username, n.post_id, n.comment_id, n.message_id,
+	n.chat_room_id, n.subreddit_id, n.created_at, n.read_at IS NOT NULL
+`
+
+// scanFields returns the scan destinations of notificationColumns
+func (n *Notification) scanFields() []interface{} {
+	return []interface{}{&n.ID, &n.Type, &n.ActorID, &n.ActorUsername, &n.PostID, &n.CommentID,
+		&n.MessageID, &n.ChatRoomID, &n.SubredditID, &n.CreatedAt, &n.Read}
+}
+
+// GetVoteScore returns the author of a post or comment and its score
+func (dm *DatabaseManager) GetVoteScore(targetID int, targetType string) (int, int, error) {
+	defer dm.span("GetVoteScore").End()
+	dm.mu.RLock()
+	defer dm.mu.RUnlock()
+
+	table := "posts"
+	if targetType == "comment" {
+		table = "comments"
+	}
+
+	var authorID, score int
+	err := dm.db.QueryRow(fmt.Sprintf(`
+		SELECT t.author_id, COALESCE((
+			SELECT SUM(vote_value) FROM votes WHERE target_id = t.id AND target_type = ?
+		), 0)
+		FROM %s t WHERE t.id = ?
+	`, table), targetType, targetID).Scan(&authorID, &score)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to get vote score: %v", err)
+	}
+
+	return authorID, score, nil
+}
+
+// LatestCommentID returns the ID of the newest comment on a post, or 0 when
+// it has none. It fails when the post doesn't exist.
+func (dm *DatabaseManager) LatestCommentID(postID int) (int, error) {
+	defer dm.span("LatestCommentID").End()
+	dm.mu.RLock()
+	defer dm.mu.RUnlock()
+
+	var latest int
+	err := dm.db.QueryRow(`
+		SELECT COALESCE((SELECT MAX(id) FROM comments WHERE post_id = p.id), 0)
+		FROM posts p WHERE p.id = ?
+	`, postID).Scan(&latest)
+	if err != nil {
+		return 0, fmt.Errorf("post not found: %v", err)
+	}
+
+	return latest, nil
+}
+
+// maxCommentsSince caps how many comments GetCommentsSince returns at once
+const maxCommentsSince = 500
+
+// ErrNotAuthor is returned when a user edits content they didn't write
+var ErrNotAuthor = errors.New("only the author can edit this")
+
+// editedAtUpdate sets edited_at on an edit made after the grace period, and
+// leaves it as it is for edits made within grace of created_at
+const editedAtUpdate = `
+	edited_at = CASE WHEN created_at > datetime('now', ?) THEN edited_at ELSE CURRENT_TIMESTAMP END
+`
+
+// graceModifier is the SQLite datetime modifier reaching back a grace period
+func graceModifier(grace time.Duration) string {
+	return fmt.Sprintf("-%d seconds", int(grace.Seconds()))
+}
+
+// EditPost changes the content of a post, keeping the content it replaces
+// as a revision. The new content must still suit the post's kind. Edits made
+// after the grace period mark the post as edited.
+func (dm *DatabaseManager) EditPost(postID, authorID int, content string, grace time.Duration) (*Post, error) {
+	defer dm.span("EditPost").End()
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	var ownerID, subredditID int
+	var title, kind string
+	err := dm.db.QueryRow(`SELECT author_id, subreddit_id, title, kind FROM posts WHERE id = ? AND removed = 0 AND deleted_at IS NULL`, postID).
+		Scan(&ownerID, &subredditID, &title, &kind)
+	if err != nil {
+		return nil, fmt.Errorf("post not found: %v", err)
+	}
+	if ownerID != authorID {
+		return nil, ErrNotAuthor
+	}
+	if err := validatePostKind(kind, content); err != nil {
+		return nil, err
+	}
+	if err := checkSubredditWritable(dm.db, subredditID); err != nil {
+		return nil, err
+	}
+
+	tx, err := dm.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	if err := recordRevision(tx, "post", postID, content); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	_, err = tx.Exec(`UPDATE posts SET content = ?, `+editedAtUpdate+` WHERE id = ?`,
+		content, graceModifier(grace), postID)
+	if err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("failed to edit post: %v", err)
+	}
+
+	// The new content gets the same automod rules and mentions as a new post
+	outcome, err := applyAutomodRules(tx, subredditID, "post", postID, authorID, title+"\n"+content)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if !outcome.Removed {
+		if err := recordMentions(tx, authorID, postID, nil, content); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	rows, err := dm.db.Query(`
+		SELECT `+postColumns+`
+		FROM posts p
+		JOIN users u ON p.author_id = u.id
+		JOIN subreddits s ON p.subreddit_id = s.id
+		WHERE p.id = ?
+	`, postID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get post: %v", err)
+	}
+	defer rows.Close()
+
+	posts, err := scanPosts(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(posts) == 0 {
+		return nil, fmt.Errorf("post not found")
+	}
+	return &posts[0], nil
+}
+
+// EditComment changes the content of a comment, keeping the content it
+// replaces as a revision. Edits made after the grace period mark the comment
+// as edited.
+func (dm *DatabaseManager) EditComment(commentID, authorID int, content string, grace time.Duration) (*Comment, error) {
+	defer dm.span("EditComment").End()
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	var ownerID, subredditID, postID int
+	err := dm.db.QueryRow(`
+		SELECT c.author_id, p.subreddit_id, p.id FROM comments c JOIN posts p ON c.post_id = p.id
+		WHERE c.id = ? AND c.removed = 0 AND c.deleted_at IS NULL
+	`, commentID).Scan(&ownerID, &subredditID, &postID)
+	if err != nil {
+		return nil, fmt.Errorf("comment not found: %v", err)
+	}
+	if ownerID != authorID {
+		return nil, ErrNotAuthor
+	}
+	if err := checkSubredditWritable(dm.db, subredditID); err != nil {
+		return nil, err
+	}
+
+	tx, err := dm.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	if err := recordRevision(tx, "comment", commentID, content); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	_, err = tx.Exec(`UPDATE comments SET content = ?, `+editedAtUpdate+` WHERE id = ?`,
+		content, graceModifier(grace), commentID)
+	if err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("failed to edit comment: %v", err)
+	}
+
+	// The new content gets the same automod rules and mentions as a new
+	// comment
+	outcome, err := applyAutomodRules(tx, subredditID, "comment", commentID, authorID, content)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if outcome.Removed {
+		err = updateCommentCount(tx, postID)
+	} else {
+		err = recordMentions(tx, authorID, postID, &commentID, content)
+	}
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	var comment Comment
+	err = dm.db.QueryRow(`
+		SELECT `+commentColumns+`
+		FROM comments c
+		JOIN users u ON c.author_id = u.id
+		`+commentVoteJoin+`
+		WHERE c.id = ?
+	`, authorID, commentID).Scan(comment.scanFields()...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get comment: %v", err)
+	}
+	comments := []Comment{comment}
+	if err := dm.markCollapsed(comments); err != nil {
+		return nil, err
+	}
+	return &comments[0], nil
+}
+
+// GetCommentsSince returns the visible comments on a post with IDs above
+// afterID, oldest first, with viewerID's votes on them. Comments mentioning
+// words viewerID muted are left out.
+func (dm *DatabaseManager) GetCommentsSince(postID, afterID, viewerID int) ([]Comment, error) {
+	defer dm.span("GetCommentsSince").End()
+	dm.mu.RLock()
+	defer dm.mu.RUnlock()
+
+	rows, err := dm.db.Query(`
+		SELECT `+commentColumns+`
+		FROM comments c
+		JOIN users u ON c.author_id = u.id
+		`+commentVoteJoin+`
+		WHERE c.post_id = ? AND c.id > ? AND c.removed = 0 AND c.deleted_at IS NULL AND `+mutedCommentFilter+`
+		ORDER BY c.id
+		LIMIT ?
+	`, viewerID, postID, afterID, viewerID, maxCommentsSince)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get comments: %v", err)
+	}
+	defer rows.Close()
+
+	var comments []Comment
+	for rows.Next() {
+		var comment Comment
+		if err := rows.Scan(comment.scanFields()...); err != nil {
+			return nil, err
+		}
+		comments = append(comments, comment)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return comments, dm.markCollapsed(comments)
+}
+
+// GetNotifications returns a page of the user's notifications, newest first,
+// and whether more follow
+func (dm *DatabaseManager) GetNotifications(userID int, unreadOnly bool, limit, offset int) ([]Notification, bool, error) {
+	defer dm.span("GetNotifications").End()
+	dm.mu.RLock()
+	defer dm.mu.RUnlock()
+
+	rows, err := dm.db.Query(`
+		SELECT `+notificationColumns+`
+		FROM notifications n
+		JOIN users u ON n.actor_id = u.id
+		WHERE n.user_id = ? AND n.in_app = 1 AND (? = 0 OR n.read_at IS NULL)
+		ORDER BY n.created_at DESC, n.id DESC
+		LIMIT ? OFFSET ?
+	`, userID, unreadOnly, limit+1, offset)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to get notifications: %v", err)
+	}
+	defer rows.Close()
+
+	notifications := []Notification{}
+	for rows.Next() {
+		var n Notification
+		if err := rows.Scan(n.scanFields()...); err != nil {
+			return nil, false, err
+		}
+		notifications = append(notifications, n)
+	}
+
+	// One extra row was fetched to tell whether there is a next page
+	hasMore := len(notifications) > limit
+	if hasMore {
+		notifications = notifications[:limit]
+	}
+	return notifications, hasMore, rows.Err()
+}
+
+// CountUnreadNotifications returns how many of the user's notifications are unread
+func (dm *DatabaseManager) CountUnreadNotifications(userID int) (int, error) {
+	defer dm.span("CountUnreadNotifications").End()
+	dm.mu.RLock()
+	defer dm.mu.RUnlock()
+
+	var count int
+	err := dm.db.QueryRow(`
+		SELECT COUNT(*) FROM notifications WHERE user_id = ? AND in_app = 1 AND read_at IS NULL
+	`, userID).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count notifications: %v", err)
+	}
+
+	return count, nil
+}
+
+// MarkNotificationsRead marks the user's notifications as read: the one with
+// the given ID, or all of them when notificationID is nil. It returns how many
+// were marked.
+func (dm *DatabaseManager) MarkNotificationsRead(userID int, notificationID *int) (int, error) {
+	defer dm.span("MarkNotificationsRead").End()
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	result, err := dm.db.Exec(`
+		UPDATE notifications SET read_at = CURRENT_TIMESTAMP
+		WHERE user_id = ? AND in_app = 1 AND read_at IS NULL AND (? IS NULL OR id = ?)
+	`, userID, notificationID, notificationID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to mark notifications read: %v", err)
+	}
+
+	marked, err := result.RowsAffected()
+	return int(marked), err
+}
+
+// GetConversations returns one entry per user the given user has exchanged
+// direct messages with, holding the latest message either way and the number
+// of unread messages received from them, most recently active first
+func (dm *DatabaseManager) GetConversations(userID int) ([]Conversation, error) {
+	defer dm.span("GetConversations").End()
+	dm.mu.RLock()
+	defer dm.mu.RUnlock()
+
+	rows, err := dm.db.Query(`
+		WITH msgs AS (
+			SELECT id, from_user_id, to_user_id, content, created_at, read_at,
+				   CASE WHEN from_user_id = ? THEN to_user_id ELSE from_user_id END AS other_id
+			FROM direct_messages
+			WHERE (from_user_id = ? AND deleted_by_sender = 0)
+			   OR (to_user_id = ? AND deleted_by_recipient = 0)
+		),
+		latest AS (
+			SELECT other_id, MAX(id) AS latest_id,
+				   SUM(CASE WHEN to_user_id = ? AND read_at IS NULL THEN 1 ELSE 0 END) AS unread
+			FROM msgs
+			GROUP BY other_id
+		)
+		SELECT l.other_id, other.username, l.unread,
+			   m.id, m.from_user_id, sender.username, m.to_user_id, m.content, m.created_at, m.read_at
+		FROM latest l
+		JOIN msgs m ON m.id = l.latest_id
+		JOIN users other ON other.id = l.other_id
+		JOIN users sender ON sender.id = m.from_user_id
+		ORDER BY m.id DESC
+	`, userID, userID, userID, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get conversations: %v", err)
+	}
+	defer rows.Close()
+
+	conversations := []Conversation{}
+	for rows.Next() {
+		var conv Conversation
+		msg := &conv.LatestMessage
+		if err := rows.Scan(&conv.UserID, &conv.Username, &conv.UnreadCount,
+			&msg.ID, &msg.FromUserID, &msg.FromUsername, &msg.ToUserID, &msg.Content, &msg.CreatedAt, &msg.ReadAt); err != nil {
+			return nil, err
+		}
+		conversations = append(conversations, conv)
+	}
+
+	return conversations, rows.Err()
+}
+
+// GetDirectMessageThread returns every message exchanged between two users,
+// oldest first
+func (dm *DatabaseManager) GetDirectMessageThread(userID, otherUserID int) ([]DirectMessage, error) {
+	defer dm.span("GetDirectMessageThread").End()
+	dm.mu.RLock()
+	defer dm.mu.RUnlock()
+
+	rows, err := dm.db.Query(`
+		SELECT dm.id, dm.from_user_id, u.username, dm.to_user_id, dm.content, dm.created_at, dm.read_at
+		FROM direct_messages dm
+		JOIN users u ON dm.from_user_id = u.id
+		WHERE (dm.from_user_id = ? AND dm.to_user_id = ? AND dm.deleted_by_sender = 0)
+		   OR (dm.from_user_id = ? AND dm.to_user_id = ? AND dm.deleted_by_recipient = 0)
+		ORDER BY dm.created_at, dm.id
+	`, userID, otherUserID, otherUserID, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get messages: %v", err)
+	}
+	defer rows.Close()
+
+	messages := []DirectMessage{}
+	for rows.Next() {
+		var msg DirectMessage
+		if err := rows.Scan(&msg.ID, &msg.FromUserID, &msg.FromUsername, &msg.ToUserID,
+			&msg.Content, &msg.CreatedAt, &msg.ReadAt); err != nil {
+			return nil, err
+		}
+		messages = append(messages, msg)
+	}
+
+	return messages, rows.Err()
+}
+
+// MarkDirectMessageRead marks a message the user received as read
+func (dm *DatabaseManager) MarkDirectMessageRead(userID, messageID int) error {
+	defer dm.span("MarkDirectMessageRead").End()
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	result, err := dm.db.Exec(`
+		UPDATE direct_messages SET read_at = COALESCE(read_at, CURRENT_TIMESTAMP)
+		WHERE id = ? AND to_user_id = ? AND deleted_by_recipient = 0
+	`, messageID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to mark message read: %v", err)
+	}
+
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return fmt.Errorf("message not found")
+	}
+
+	return nil
+}
+
+// MarkThreadRead marks every message the user received from fromUserID as
+// read and returns how many were unread
+func (dm *DatabaseManager) MarkThreadRead(userID, fromUserID int) (int, error) {
+	defer dm.span("MarkThreadRead").End()
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	result, err := dm.db.Exec(`
+		UPDATE direct_messages SET read_at = CURRENT_TIMESTAMP
+		WHERE to_user_id = ? AND from_user_id = ? AND read_at IS NULL
+	`, userID, fromUserID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to mark messages read: %v", err)
+	}
+
+	marked, err := result.RowsAffected()
+	return int(marked), err
+}
+
+// DeleteDirectMessage hides a message from the user, who may be its sender or
+// recipient. The other party still sees it.
+func (dm *DatabaseManager) DeleteDirectMessage(userID, messageID int) error {
+	defer dm.span("DeleteDirectMessage").End()
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	result, err := dm.db.Exec(`
+		UPDATE direct_messages
+		SET deleted_by_sender = CASE WHEN from_user_id = ? THEN 1 ELSE deleted_by_sender END,
+			deleted_by_recipient = CASE WHEN to_user_id = ? THEN 1 ELSE deleted_by_recipient END
+		WHERE id = ?
+		  AND ((from_user_id = ? AND deleted_by_sender = 0) OR (to_user_id = ? AND deleted_by_recipient = 0))
+	`, userID, userID, messageID, userID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete message: %v", err)
+	}
+
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return fmt.Errorf("message not found")
+	}
+
+	return nil
+}
+
+// UnreadCounts is the number of unread items in a user's inbox
+type UnreadCounts struct {
+	Messages      int `json:"messages"`
+	Notifications int `json:"notifications"`
+}
+
+// GetUnreadCounts counts the user's unread direct messages and notifications
+func (dm *DatabaseManager) GetUnreadCounts(userID int) (*UnreadCounts, error) {
+	defer dm.span("GetUnreadCounts").End()
+	dm.mu.RLock()
+	defer dm.mu.RUnlock()
+
+	var counts UnreadCounts
+	err := dm.db.QueryRow(`
+		SELECT
+			(SELECT COUNT(*) FROM direct_messages
+			 WHERE to_user_id = ? AND read_at IS NULL AND deleted_by_recipient = 0),
+			(SELECT COUNT(*) FROM notifications WHERE user_id = ? AND in_app = 1 AND read_at IS NULL)
+	`, userID, userID).Scan(&counts.Messages, &counts.Notifications)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count unread items: %v", err)
+	}
+
+	return &counts, nil
+}
+
+// Functions to let user subscribe and unsubscribe to other users.
+func (dm *DatabaseManager) SubscribeToUser(subscriberID, subscribedUserID int) error {
+	defer dm.span("SubscribeToUser").End()
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	tx, err := dm.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.Exec(`
+        INSERT OR IGNORE INTO user_subscriptions 
+        (subscriber_id, subscribed_user_id) 
+        VALUES (?, ?)
+    `, subscriberID, subscribedUserID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	// Only a new follower is worth a notification
+	if added, _ := result.RowsAffected(); added > 0 {
+		if err := createNotification(tx, subscribedUserID, "follow", subscriberID, notificationRefs{}); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (dm *DatabaseManager) UnsubscribeFromUser(subscriberID, subscribedUserID int) error {
+	defer dm.span("UnsubscribeFromUser").End()
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	_, err := dm.db.Exec(`
+        DELETE FROM user_subscriptions 
+        WHERE subscriber_id = ? AND subscribed_user_id = ?
+    `, subscriberID, subscribedUserID)
+
+	return err
+}
+
+func (dm *DatabaseManager) GetUserSubscriptions(userID int) ([]User, error) {
+	defer dm.span("GetUserSubscriptions").End()
+	dm.mu.RLock()
+	defer dm.mu.RUnlock()
+
+	query := `
+        SELECT u.id, u.username, u.karma
+        FROM users u
+        JOIN user_subscriptions us ON u.id = us.subscribed_user_id
+        WHERE us.subscriber_id = ?
+    `
+
+	rows, err := dm.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var subscriptions []User
+	for rows.Next() {
+		var user User
+		err := rows.Scan(&user.ID, &user.Username, &user.Karma)
+		if err != nil {
+			return nil, err
+		}
+		subscriptions = append(subscriptions, user)
+	}
+
+	return subscriptions, nil
+}
+
+// Function to close the database
+func (dm *DatabaseManager) Close() {
+	if dm.db != nil {
+		dm.db.Close()
+	}
+}
+
+// Structs for database operations
+type User struct {
+	ID       string
+	Username string
+	Karma    int
+}
+
+type Post struct {
+	ID             int
+	Title          string
+	Content        string
+	AuthorID       int    `json:"author_id"`
+	AuthorUsername string `json:"author_name"`
+	SubredditID    int    `json:"subreddit_id"`
+	SubredditName  string `json:"subreddit_name"`
+	Flair          string `json:"flair,omitempty"`
+	Pinned         bool   `json:"pinned"`
+	Kind           string `json:"kind"`         // text, link, image or poll
+	CrosspostOf    *int   `json:"crosspost_of"` // nil unless a crosspost
+	NSFW           bool   `json:"nsfw"`         // posted in an NSFW subreddit
+	Slug           string `json:"slug"`         // the title in a form fit for URLs
+	Permalink      string `json:"permalink"`    // e.g. /r/golang/comments/42/hello_world
+	CreatedAt      time.Time
+	EditedAt       *time.Time  `json:"edited_at"` // nil unless edited after the grace period
+	CommentCount   int         `json:"comment_count"`
+	Awards         AwardCounts `json:"awards"`
+	VoteCount      struct {
+		Upvotes   int `json:"upvotes"`
+		Downvotes int `json:"downvotes"`
+	} `json:"vote_count"` // fuzzed on new posts, see fuzzVoteCount
+
+	activity          postActivity
+	subredditFuzzMins int // the subreddit's vote_fuzz_minutes
+}
+
+// postActivity counts events on a post within a recent window, used for ranking
+type postActivity struct {
+	votes    int // net votes cast within the window
+	comments int
+}
+
+type DirectMessage struct {
+	ID           int
+	FromUserID   int `json:"from_user_id"`
+	FromUsername string
+	ToUserID     int `json:"to_user_id"`
+	Content      string
+	CreatedAt    time.Time
+	ReadAt       *time.Time `json:"read_at"`
+}
+
+// Conversation is the direct messages exchanged with one other user
+type Conversation struct {
+	UserID        int           `json:"user_id"`
+	Username      string        `json:"username"`
+	LatestMessage DirectMessage `json:"latest_message"`
+	UnreadCount   int           `json:"unread_count"`
+}
+
+// Notification tells a user about a reply, mention, direct message, new
+// follower, moderator action on their content or account, or an invite to or
+// message in a chat room. Only the IDs relevant to its type are set; for chat
+// messages MessageID is the chat message's ID.
+type Notification struct {
+	ID            int       `json:"id"`
+	Type          string    `json:"type"`
+	ActorID       int       `json:"actor_id"`
+	ActorUsername string    `json:"actor_username"`
+	PostID        *int      `json:"post_id,omitempty"`
+	CommentID     *int      `json:"comment_id,omitempty"`
+	MessageID     *int      `json:"message_id,omitempty"`
+	ChatRoomID    *int      `json:"chat_room_id,omitempty"`
+	SubredditID   *int      `json:"subreddit_id,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+	Read          bool      `json:"read"`
+}
+
+// NotificationPage is one page of a user's notifications
+type NotificationPage struct {
+	Notifications []Notification `json:"notifications"`
+	UnreadCount   int            `json:"unread_count"`
+	Limit         int            `json:"limit"`
+	Offset        int            `json:"offset"`
+	NextOffset    *int           `json:"next_offset"` // nil on the last page
+}
+
+// ChatRoom is a group conversation between its members
+type ChatRoom struct {
+	ID            int          `json:"id"`
+	Name          string       `json:"name"`
+	OwnerID       int          `json:"owner_id"`
+	MemberCount   int          `json:"member_count"`
+	Members       []ChatMember `json:"members,omitempty"`
+	CreatedAt     time.Time    `json:"created_at"`
+	LastMessageAt *time.Time   `json:"last_message_at"`
+}
+
+// ChatMember is a user in a chat room
+type ChatMember struct {
+	UserID   int       `json:"user_id"`
+	Username string    `json:"username"`
+	JoinedAt time.Time `json:"joined_at"`
+}
+
+// ChatMessage is a message posted to a chat room
+type ChatMessage struct {
+	ID             int       `json:"id"`
+	RoomID         int       `json:"room_id"`
+	SenderID       int       `json:"sender_id"`
+	SenderUsername string    `json:"sender_username"`
+	Content        string    `json:"content"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// ChatMessagePage is one page of a chat room's history
+type ChatMessagePage struct {
+	Messages   []ChatMessage `json:"messages"`
+	Limit      int           `json:"limit"`
+	Offset     int           `json:"offset"`
+	NextOffset *int          `json:"next_offset"` // nil on the last page
+}
+
+type CreateChatRoomRequest struct {
+	Name      string `json:"name" binding:"required,max=100"`
+	MemberIDs []int  `json:"member_ids"`
+}
+
+type InviteChatMemberRequest struct {
+	UserID int `json:"user_id" binding:"required"`
+}
+
+type ChatMessageRequest struct {
+	Content string `json:"content" binding:"required,max=10000"`
+}
+
+// Session is a login of a user, identified by its token ID
+type Session struct {
+	ID         string     `json:"id"`
+	IP         string     `json:"ip"`
+	UserAgent  string     `json:"user_agent"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastSeenAt time.Time  `json:"last_seen_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	Current    bool       `json:"current"`
+}
+
+// Request/Response structs
+type LoginRequest struct {
+	Username string `json:"username" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+type RegisterUserRequest struct {
+	Username string `json:"username" binding:"required"`
+	Password string `json:"password" binding:"required"`
+	Email    string `json:"email" binding:"omitempty,email,max=254"` // optional, verified by email
+
+	// CaptchaToken is the response of the CAPTCHA in GET /captcha, required
+	// when one is configured
+	CaptchaToken string `json:"captcha_token"`
+}
+
+type CreateSubredditRequest struct {
+	Name        string `json:"name" binding:"required"`
+	Description string `json:"description" binding:"required"`
+}
+
+type CreatePostRequest struct {
+	Title       string `json:"title" binding:"required"`
+	Content     string `json:"content" binding:"required"`
+	SubredditID int    `json:"subreddit_id" binding:"required"`
+	Kind        string `json:"kind" binding:"omitempty,oneof=text link image poll"` // text when empty
+	CrosspostOf *int   `json:"crosspost_of"`                                        // the ID of the post this crossposts
+}
+
+type CreateCommentRequest struct {
+	Content         string `json:"content" binding:"required"`
+	PostID          int    `json:"post_id" binding:"required"`
+	ParentCommentID *int   `json:"parent_comment_id"`
+}
+
+// VoteRequest carries a client generated nonce and the unix time the request
+// was made, so replayed votes can be detected
+type VoteRequest struct {
+	TargetID   int    `json:"target_id" binding:"required"`
+	TargetType string `json:"target_type" binding:"required,oneof=post comment"`
+	Value      int    `json:"value" binding:"required,oneof=-1 1"`
+	Nonce      string `json:"nonce" binding:"required,max=128"`
+	Timestamp  int64  `json:"timestamp" binding:"required"`
+}
+
+// voteMaxClockSkew is how far a vote's timestamp may be from the server clock
+const voteMaxClockSkew = 5 * time.Minute
+
+type SendMessageRequest struct {
+	ToUserID int    `json:"to_user_id" binding:"required"`
+	Content  string `json:"content" binding:"required"`
+}
+
+type PostWithDetails struct {
+	Post
+	Votes     int       `json:"votes"`
+	UserVote  *int      `json:"user_vote"`
+	Comments  []Comment `json:"comments"`
+	VoteCount struct {
+		Upvotes   int `json:"upvotes"`
+		Downvotes int `json:"downvotes"`
+	} `json:"vote_count"`
+}
+
+type Comment struct {
+	ID              int         `json:"id"`
+	Content         string      `json:"content"`
+	AuthorID        int         `json:"author_id"`
+	AuthorUsername  string      `json:"author_username"`
+	PostID          int         `json:"post_id"`
+	ParentCommentID *int        `json:"parent_comment_id"`
+	CreatedAt       time.Time   `json:"created_at"`
+	EditedAt        *time.Time  `json:"edited_at"` // nil unless edited after the grace period
+	Votes           int         `json:"votes"`
+	Upvotes         int         `json:"upvotes"`
+	Downvotes       int         `json:"downvotes"`
+	UserVote        *int        `json:"user_vote"`
+	Awards          AwardCounts `json:"awards"`
+
+	// Collapsed comments are for clients to render folded, because of their
+	// subreddit's crowd control settings. CollapsedReason is low_score or
+	// negative_karma.
+	Collapsed       bool   `json:"collapsed"`
+	CollapsedReason string `json:"collapsed_reason,omitempty"`
+}
+
+// commentColumns selects the fields read by Comment.scanFields. Queries using
+// it must alias comments as c and the author as u, and join the viewer's vote
+// with commentVoteJoin.
+const commentColumns = `
+	c.id, c.content, c.author_id, u.username, c.post_id, c.parent_comment_id, c.created_at, c.edited_at, c.score,
+	(SELECT COUNT(*) FROM votes WHERE target_id = c.id AND target_type = 'comment' AND vote_value = 1),
+	(SELECT COUNT(*) FROM votes WHERE target_id = c.id AND target_type = 'comment' AND vote_value = -1),
+	cv.vote_value, (SELECT json_group_array(award) FROM awards WHERE target_type = 'comment' AND target_id = c.id)
+`
+
+// commentVoteJoin joins the vote a viewer cast on each comment, summed since
+// a user can hold both an up and a downvote. It takes the viewer's user ID,
+// which is 0 for anonymous requests, so user_vote is null.
+const commentVoteJoin = `
+	LEFT JOIN (
+		SELECT target_id, SUM(vote_value) AS vote_value FROM votes
+		WHERE target_type = 'comment' AND user_id = ?
+		GROUP BY target_id
+	) cv ON cv.target_id = c.id
+`
+
+// defaultCollapseBelowScore is the score below which comments are collapsed
+// in subreddits that haven't set their own
+const defaultCollapseBelowScore = -5
+
+// markCollapsed marks the comments their subreddits' crowd control settings
+// collapse. Callers hold dm.mu.
+func (dm *DatabaseManager) markCollapsed(comments []Comment) error {
+	type crowdControl struct {
+		subredditID   int
+		belowScore    int
+		negativeKarma bool
+	}
+	byPost := make(map[int]crowdControl)
+	karma := make(map[[2]int]int) // by author and subreddit
+
+	for i := range comments {
+		comment := &comments[i]
+		settings, ok := byPost[comment.PostID]
+		if !ok {
+			err := dm.db.QueryRow(`
+				SELECT p.subreddit_id, COALESCE(ss.collapse_below_score, ?), COALESCE(ss.collapse_negative_karma, 0)
+				FROM posts p
+				LEFT JOIN subreddit_settings ss ON ss.subreddit_id = p.subreddit_id
+				WHERE p.id = ?
+			`, defaultCollapseBelowScore, comment.PostID).Scan(&settings.subredditID, &settings.belowScore, &settings.negativeKarma)
+			if err != nil {
+				return fmt.Errorf("failed to get crowd control settings: %v", err)
+			}
+			byPost[comment.PostID] = settings
+		}
+
+		if comment.Votes < settings.belowScore {
+			comment.Collapsed, comment.CollapsedReason = true, "low_score"
+			continue
+		}
+		if !settings.negativeKarma {
+			continue
+		}
+
+		key := [2]int{comment.AuthorID, settings.subredditID}
+		authorKarma, ok := karma[key]
+		if !ok {
+			err := dm.db.QueryRow(`
+				SELECT COALESCE((
+					SELECT SUM(v.vote_value) FROM votes v JOIN posts p ON v.target_type = 'post' AND v.target_id = p.id
+					WHERE p.author_id = ? AND p.subreddit_id = ?
+				), 0) + COALESCE((
+					SELECT SUM(v.vote_value) FROM votes v JOIN comments c ON v.target_type = 'comment' AND v.target_id = c.id
+					JOIN posts p ON c.post_id = p.id
+					WHERE c.author_id = ? AND p.subreddit_id = ?
+				), 0)
+			`, key[0], key[1], key[0], key[1]).Scan(&authorKarma)
+			if err != nil {
+				return fmt.Errorf("failed to get subreddit karma: %v", err)
+			}
+			karma[key] = authorKarma
+		}
+		if authorKarma < 0 {
+			comment.Collapsed, comment.CollapsedReason = true, "negative_karma"
+		}
+	}
+	return nil
+}
+
+// scanFields returns the scan destinations of commentColumns
+func (c *Comment) scanFields() []interface{} {
+	return []interface{}{&c.ID, &c.Content, &c.AuthorID, &c.AuthorUsername, &c.PostID,
+		&c.ParentCommentID, &c.CreatedAt, &c.EditedAt, &c.Votes, &c.Upvotes, &c.Downvotes, &c.UserVote, &c.Awards}
+}
+
+type TopUser struct {
+	ID           int    `json:"id"`
+	Username     string `json:"username"`
+	Karma        int    `json:"karma"`
+	PostCount    int    `json:"post_count"`
+	CommentCount int    `json:"comment_count"`
+}
+
+type TopSubscribedUser struct {
+	ID              int    `json:"id"`
+	Username        string `json:"username"`
+	Karma           int    `json:"karma"`
+	SubscriberCount int    `json:"subscriber_count"`
+}
+
+// SubredditListing is a subreddit with its size and recent activity, used by
+// search and discovery
+type SubredditListing struct {
+	Subreddit
+	MemberCount    int `json:"member_count"`
+	RecentActivity int `json:"recent_activity"`
+}
+
+// AutomodRule is a per-subreddit rule evaluated when posts and comments are created
+type AutomodRule struct {
+	ID                int       `json:"id"`
+	SubredditID       int       `json:"subreddit_id"`
+	Name              string    `json:"name"`
+	MatchType         string    `json:"match_type,omitempty"`
+	Pattern           string    `json:"pattern,omitempty"`
+	AppliesTo         string    `json:"applies_to"`
+	MinAccountAgeDays int       `json:"min_account_age_days"`
+	MinKarma          *int      `json:"min_karma"`
+	Action            string    `json:"action"`
+	FlairText         string    `json:"flair_text,omitempty"`
+	CreatedBy         int       `json:"created_by"`
+	CreatedAt         time.Time `json:"created_at"`
+}
+
+type CreateAutomodRuleRequest struct {
+	Name              string `json:"name" binding:"required"`
+	MatchType         string `json:"match_type" binding:"omitempty,oneof=keyword regex"`
+	Pattern           string `json:"pattern"`
+	AppliesTo         string `json:"applies_to" binding:"required,oneof=post comment both"`
+	MinAccountAgeDays int    `json:"min_account_age_days" binding:"min=0"`
+	MinKarma          *int   `json:"min_karma"`
+	Action            string `json:"action" binding:"required,oneof=remove flag flair"`
+	FlairText         string `json:"flair_text"`
+}
+
+// validate checks rule combinations that binding tags can't express
+func (r CreateAutomodRuleRequest) validate() error {
+	if r.MatchType != "" && r.Pattern == "" {
+		return fmt.Errorf("pattern is required for %s rules", r.MatchType)
+	}
+	if r.MatchType == "regex" {
+		if _, err := regexp.Compile(r.Pattern); err != nil {
+			return fmt.Errorf("invalid regex: %v", err)
+		}
+	}
+	if r.MatchType == "" && r.MinAccountAgeDays == 0 && r.MinKarma == nil {
+		return fmt.Errorf("rule must set a pattern, min_account_age_days, or min_karma")
+	}
+	if r.Action == "flair" {
+		if r.FlairText == "" {
+			return fmt.Errorf("flair_text is required for flair rules")
+		}
+		if r.AppliesTo != "post" {
+			return fmt.Errorf("flair rules can only apply to posts")
+		}
+	}
+	return nil
+}
+
+// AutomodOutcome summarizes the automod actions taken on new content
+type AutomodOutcome struct {
+	Removed bool   `json:"removed"`
+	Flagged bool   `json:"flagged"`
+	Flair   string `json:"flair,omitempty"`
+}
+
+// ModQueueItem is content waiting for moderator review
+type ModQueueItem struct {
+	ID          int       `json:"id"`
+	SubredditID int       `json:"subreddit_id"`
+	TargetType  string    `json:"target_type"`
+	TargetID    int       `json:"target_id"`
+	Reason      string    `json:"reason"`
+	Source      string    `json:"source"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// SubredditSettings holds a subreddit's configurable behaviour
+type SubredditSettings struct {
+	SubredditID   int     `json:"subreddit_id"`
+	DefaultSort   string  `json:"default_sort"`
+	HalfLifeHours float64 `json:"half_life_hours"`
+
+	// Crowd control: comments are marked collapsed when their score is below
+	// CollapseBelowScore or, with CollapseNegativeKarma, when their author's
+	// karma in the subreddit is negative
+	CollapseBelowScore    int  `json:"collapse_below_score"`
+	CollapseNegativeKarma bool `json:"collapse_negative_karma"`
+
+	MaxPostsPerDay int `json:"max_posts_per_day"` // from each user, 0 for no cap
+
+	// Content settings, enforced on new posts
+	AllowedPostTypes []string `json:"allowed_post_types"`
+	MinTitleLength   int      `json:"min_title_length"`
+	AllowCrossposts  bool     `json:"allow_crossposts"`
+
+	EditHistoryModOnly bool `json:"edit_history_mod_only"` // hide edit history from all but moderators
+
+	VoteFuzzMinutes int `json:"vote_fuzz_minutes"` // fuzz the vote counts of posts younger than this, 0 for off
+
+	NSFW bool `json:"nsfw"` // keep its posts out of site-wide listings for users without show_nsfw
+}
+
+type UpdateSubredditSettingsRequest struct {
+	DefaultSort           *string   `json:"default_sort"`
+	HalfLifeHours         *float64  `json:"half_life_hours" binding:"omitempty,gt=0"`
+	CollapseBelowScore    *int      `json:"collapse_below_score"`
+	CollapseNegativeKarma *bool     `json:"collapse_negative_karma"`
+	MaxPostsPerDay        *int      `json:"max_posts_per_day" binding:"omitempty,min=0"`
+	AllowedPostTypes      *[]string `json:"allowed_post_types" binding:"omitempty,min=1,dive,oneof=text link image poll"`
+	MinTitleLength        *int      `json:"min_title_length" binding:"omitempty,min=0,max=300"`
+	AllowCrossposts       *bool     `json:"allow_crossposts"`
+	EditHistoryModOnly    *bool     `json:"edit_history_mod_only"`
+	VoteFuzzMinutes       *int      `json:"vote_fuzz_minutes" binding:"omitempty,min=0,max=1440"`
+	NSFW                  *bool     `json:"nsfw"`
+}
+
+// SubredditRule is one of the rules a subreddit asks its members to follow
+type SubredditRule struct {
+	Title       string `json:"title" binding:"required,max=100"`
+	Description string `json:"description" binding:"max=500"`
+}
+
+type UpdateSubredditRulesRequest struct {
+	Rules []SubredditRule `json:"rules" binding:"max=15,dive"`
+}
+
+// SubredditAbout is what a client needs to render a subreddit's header
+type SubredditAbout struct {
+	ID          int             `json:"id"`
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	CreatedAt   time.Time       `json:"created_at"`
+	MemberCount int             `json:"member_count"`
+	Owner       string          `json:"owner"`       // the head moderator's username
+	ArchivedAt  *time.Time      `json:"archived_at"` // null unless the subreddit is read-only
+	Rules       []SubredditRule `json:"rules"`
+	Moderators  []string        `json:"moderators"` // usernames, longest serving first
+	PinnedPosts []Post          `json:"pinned_posts"`
+}
+
+// UserProfile holds a user's public profile and display preferences. An empty
+// DefaultFeedSort keeps the home feed newest first.
+type UserProfile struct {
+	UserID          int      `json:"user_id"`
+	Bio             string   `json:"bio"`
+	AvatarURL       string   `json:"avatar_url"`
+	ShowNSFW        bool     `json:"show_nsfw"`
+	DefaultFeedSort string   `json:"default_feed_sort"`
+	MutedKeywords   []string `json:"muted_keywords"`
+	MutedDomains    []string `json:"muted_domains"`
+}
+
+type UpdateUserProfileRequest struct {
+	Bio             *string   `json:"bio" binding:"omitempty,max=500"`
+	AvatarURL       *string   `json:"avatar_url" binding:"omitempty,max=2048"`
+	ShowNSFW        *bool     `json:"show_nsfw"`
+	DefaultFeedSort *string   `json:"default_feed_sort"`
+	MutedKeywords   *[]string `json:"muted_keywords"`
+	MutedDomains    *[]string `json:"muted_domains"`
+}
+
+// automodName is the moderator name shown for automated moderation actions
+const automodName = "AutoModerator"
+
+// ModLogEntry is a recorded moderation action
+type ModLogEntry struct {
+	ID          int       `json:"id"`
+	SubredditID int       `json:"subreddit_id"`
+	ModeratorID *int      `json:"moderator_id"`
+	Moderator   string    `json:"moderator"`
+	Action      string    `json:"action"`
+	TargetType  string    `json:"target_type"`
+	TargetID    int       `json:"target_id"`
+	Details     string    `json:"details,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// SubredditBan is an active ban of a user from a subreddit
+type SubredditBan struct {
+	UserID    int        `json:"user_id"`
+	Username  string     `json:"username"`
+	Reason    string     `json:"reason,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+type RemoveContentRequest struct {
+	TargetType string `json:"target_type" binding:"required,oneof=post comment"`
+	TargetID   int    `json:"target_id" binding:"required"`
+	Reason     string `json:"reason"`
+}
+
+type BanUserRequest struct {
+	UserID       int    `json:"user_id" binding:"required"`
+	Reason       string `json:"reason"`
+	DurationDays int    `json:"duration_days" binding:"min=0"`
+}
+
+type PinPostRequest struct {
+	PostID int  `json:"post_id" binding:"required"`
+	Pinned bool `json:"pinned"`
+}
+
+type SetFlairRequest struct {
+	PostID int    `json:"post_id" binding:"required"`
+	Flair  string `json:"flair"`
+}
+
+// ModLists are a subreddit's exportable ban list and word filters
+type ModLists struct {
+	Bans    []ModListBan    `json:"bans"`
+	Filters []ModListFilter `json:"filters"`
+}
+
+// ModListBan is a ban list entry, keyed by username so it carries across subreddits
+type ModListBan struct {
+	Username  string     `json:"username"`
+	Reason    string     `json:"reason,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// ModListFilter is a word filter, stored as a pattern-matching automod rule
+type ModListFilter struct {
+	Name      string `json:"name"`
+	MatchType string `json:"match_type"`
+	Pattern   string `json:"pattern"`
+	AppliesTo string `json:"applies_to"`
+	Action    string `json:"action"`
+	FlairText string `json:"flair_text,omitempty"`
+}
+
+// validate applies the automod rule checks to an imported filter
+func (f ModListFilter) validate() error {
+	if f.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if f.MatchType != "keyword" && f.MatchType != "regex" {
+		return fmt.Errorf("match_type must be keyword or regex")
+	}
+	if f.AppliesTo != "post" && f.AppliesTo != "comment" && f.AppliesTo != "both" {
+		return fmt.Errorf("applies_to must be post, comment or both")
+	}
+	if f.Action != "remove" && f.Action != "flag" && f.Action != "flair" {
+		return fmt.Errorf("action must be remove, flag or flair")
+	}
+
+	return CreateAutomodRuleRequest{
+		Name:      f.Name,
+		MatchType: f.MatchType,
+		Pattern:   f.Pattern,
+		AppliesTo: f.AppliesTo,
+		Action:    f.Action,
+		FlairText: f.FlairText,
+	}.validate()
+}
+
+// ModListImportReport describes the outcome of a moderation list import
+type ModListImportReport struct {
+	DryRun          bool           `json:"dry_run"`
+	ImportedBans    int            `json:"imported_bans"`
+	ImportedFilters int            `json:"imported_filters"`
+	Conflicts       []ModListIssue `json:"conflicts"`
+	Invalid         []ModListIssue `json:"invalid"`
+}
+
+// ModListIssue is an import entry that was skipped
+type ModListIssue struct {
+	Kind   string `json:"kind"`
+	Entry  string `json:"entry"`
+	Reason string `json:"reason"`
+}
+
+// modListCSVHeader is the column layout of CSV moderation lists. Ban rows fill
+// username, reason and expires_at; filter rows fill the remaining columns.
+var modListCSVHeader = []string{
+	"kind", "username", "reason", "expires_at",
+	"name", "match_type", "pattern", "applies_to", "action", "flair_text",
+}
+
+// writeModListsCSV encodes moderation lists using modListCSVHeader
+func writeModListsCSV(w io.Writer, lists *ModLists) error {
+	out := csv.NewWriter(w)
+	if err := out.Write(modListCSVHeader); err != nil {
+		return err
+	}
+
+	for _, ban := range lists.Bans {
+		expiresAt := ""
+		if ban.ExpiresAt != nil {
+			expiresAt = ban.ExpiresAt.UTC().Format(time.RFC3339)
+		}
+		if err := out.Write([]string{"ban", ban.Username, ban.Reason, expiresAt, "", "", "", "", "", ""}); err != nil {
+			return err
+		}
+	}
+	for _, f := range lists.Filters {
+		if err := out.Write([]string{"filter", "", "", "", f.Name, f.MatchType, f.Pattern, f.AppliesTo, f.Action, f.FlairText}); err != nil {
+			return err
+		}
+	}
+
+	out.Flush()
+	return out.Error()
+}
+
+// readModListsCSV decodes CSV moderation lists. Columns are matched by header
+// name so files may omit or reorder them.
+func readModListsCSV(r io.Reader) (*ModLists, error) {
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("invalid CSV: %v", err)
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("CSV header row is required")
+	}
+
+	columns := make(map[string]int)
+	for i, name := range records[0] {
+		columns[strings.TrimSpace(strings.ToLower(name))] = i
+	}
+	if _, ok := columns["kind"]; !ok {
+		return nil, fmt.Errorf("CSV is missing the kind column")
+	}
+	field := func(record []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	lists := &ModLists{}
+	for line, record := range records[1:] {
+		switch field(record, "kind") {
+		case "ban":
+			ban := ModListBan{Username: field(record, "username"), Reason: field(record, "reason")}
+			if value := field(record, "expires_at"); value != "" {
+				expiresAt, err := time.Parse(time.RFC3339, value)
+				if err != nil {
+					return nil, fmt.Errorf("line %d: invalid expires_at: %v", line+2, err)
+				}
+				ban.ExpiresAt = &expiresAt
+			}
+			lists.Bans = append(lists.Bans, ban)
+		case "filter":
+			lists.Filters = append(lists.Filters, ModListFilter{
+				Name:      field(record, "name"),
+				MatchType: field(record, "match_type"),
+				Pattern:   field(record, "pattern"),
+				AppliesTo: field(record, "applies_to"),
+				Action:    field(record, "action"),
+				FlairText: field(record, "flair_text"),
+			})
+		default:
+			return nil, fmt.Errorf("line %d: kind must be ban or filter", line+2)
+		}
+	}
+
+	return lists, nil
+}
+
+// TrendingTopic is a term or phrase trending in recent post titles
+type TrendingTopic struct {
+	Term       string    `json:"term"`
+	Score      float64   `json:"score"`
+	PostCount  int       `json:"post_count"`
+	ComputedAt time.Time `json:"computed_at"`
+	Posts      []Post    `json:"posts"`
+}
+
+// Subreddit represents a subreddit in the system
+type Subreddit struct {
+	ID          int       `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Ranking engine
+
+// Ranking defaults used when a subreddit has no settings row
+const (
+	defaultRanking       = "hot"
+	defaultHalfLifeHours = 12.0
+)
+
+// RankingParams carries the tunable inputs of a ranking algorithm
+type RankingParams struct {
+	HalfLifeHours float64
+}
+
+// RankingFunc scores a post at a point in time; higher scores rank first
+type RankingFunc func(post Post, now time.Time, params RankingParams) float64
+
+// rankingAlgorithms is the registry of feed orderings selectable by subreddits
+// and by the sort query parameter
+var rankingAlgorithms = map[string]RankingFunc{
+	"hot":       hotScore,
+	"rising":    risingScore,
+	"latest":    latestScore,
+	"half_life": halfLifeScore,
+}
+
+// commentSorts is the registry of comment orderings selectable by the sort
+// query parameter. Each scores a comment; higher scores come first.
+var commentSorts = map[string]func(comment Comment) float64{
+	"top":  func(comment Comment) float64 { return float64(comment.Votes) },
+	"best": func(comment Comment) float64 { return wilsonLowerBound(comment.Upvotes, comment.Downvotes) },
+	"new":  func(comment Comment) float64 { return float64(comment.CreatedAt.Unix()) },
+	"old":  func(comment Comment) float64 { return -float64(comment.CreatedAt.Unix()) },
+}
+
+// wilsonZ is the z-score of the confidence level best ranks comments at, 80%
+const wilsonZ = 1.281551565545
+
+// wilsonLowerBound is the lower bound of the Wilson score interval of a
+// comment's upvote ratio: the ratio it can be said to have with confidence.
+// Unlike the net score, it doesn't let a few early votes outrank a comment
+// with many more votes and a slightly lower ratio, and unlike the ratio
+// alone, it doesn't let one upvote beat a hundred to one.
+func wilsonLowerBound(upvotes, downvotes int) float64 {
+	n := float64(upvotes + downvotes)
+	if n == 0 {
+		return 0
+	}
+	p := float64(upvotes) / n
+	z2 := wilsonZ * wilsonZ
+	return (p + z2/(2*n) - wilsonZ*math.Sqrt((p*(1-p)+z2/(4*n))/n)) / (1 + z2/n)
+}
+
+// sortComments orders comments in place by the named sort, breaking ties by
+// age, oldest first
+func sortComments(comments []Comment, sortBy string) error {
+	score, ok := commentSorts[sortBy]
+	if !ok {
+		return fmt.Errorf("unknown sort: %s", sortBy)
+	}
+	sort.SliceStable(comments, func(i, j int) bool {
+		if si, sj := score(comments[i]), score(comments[j]); si != sj {
+			return si > sj
+		}
+		return comments[i].ID < comments[j].ID
+	})
+	return nil
+}
+
+// netScore is upvotes minus downvotes
+func netScore(post Post) int {
+	return post.VoteCount.Upvotes - post.VoteCount.Downvotes
+}
+
+// hotScore is Reddit's hot formula: logarithmic in score, linear in age
+func hotScore(post Post, now time.Time, params RankingParams) float64 {
+	score := netScore(post)
+	order := math.Log10(math.Max(math.Abs(float64(score)), 1))
+	sign := 0.0
+	if score > 0 {
+		sign = 1
+	} else if score < 0 {
+		sign = -1
+	}
+	seconds := float64(post.CreatedAt.Unix() - 1134028003)
+	return sign*order + seconds/45000
+}
+
+// Rising ranking settings
+const (
+	risingWindow = time.Hour      // activity counted towards a post's velocity
+	risingMaxAge = 24 * time.Hour // posts older than this never rise
+)
+
+// risingScore favours young posts whose recent vote and comment activity is
+// high relative to their age. Activity must be loaded with LoadRecentActivity.
+func risingScore(post Post, now time.Time, params RankingParams) float64 {
+	age := now.Sub(post.CreatedAt)
+	if age > risingMaxAge {
+		return -1
+	}
+	velocity := float64(post.activity.votes+post.activity.comments) / risingWindow.Hours()
+	return velocity / (math.Max(age.Hours(), 0) + 2)
+}
+
+// latestScore orders purely by creation time
+func latestScore(post Post, now time.Time, params RankingParams) float64 {
+	return float64(post.CreatedAt.UnixNano())
+}
+
+// halfLifeScore decays the net score exponentially with the configured half-life
+func halfLifeScore(post Post, now time.Time, params RankingParams) float64 {
+	ageHours := math.Max(now.Sub(post.CreatedAt).Hours(), 0)
+	halfLife := params.HalfLifeHours
+	if halfLife <= 0 {
+		halfLife = defaultHalfLifeHours
+	}
+	return float64(netScore(post)+1) * math.Pow(0.5, ageHours/halfLife)
+}
+
+// Pagination defaults for post listings
+const (
+	defaultPageSize = 25
+	maxPageSize     = 100
+)
+
+// allPosts is the limit that has a paged post query return every post.
+// SQLite reads a negative LIMIT as no limit.
+const allPosts = -1
+
+// newestFirst reports whether a ranking orders posts newest first, as the
+// post queries do, so a page of it can be read with LIMIT and OFFSET rather
+// than by ranking every post. An empty ranking is newest first.
+func newestFirst(algorithm string) bool {
+	return algorithm == "" || algorithm == "latest"
+}
+
+// PostPage is one page of a paginated post listing
+type PostPage struct {
+	Posts      []Post `json:"posts"`
+	Limit      int    `json:"limit"`
+	Offset     int    `json:"offset"`
+	NextOffset *int   `json:"next_offset"` // nil on the last page
+}
+
+// parsePagination reads the ?limit= and ?offset= query parameters
+func parsePagination(c *gin.Context) (int, int) {
+	limit, offset := defaultPageSize, 0
+	if parsedLimit, err := strconv.Atoi(c.Query("limit")); err == nil && parsedLimit > 0 {
+		limit = parsedLimit
+	}
+	if limit > maxPageSize {
+		limit = maxPageSize
+	}
+	if parsedOffset, err := strconv.Atoi(c.Query("offset")); err == nil && parsedOffset > 0 {
+		offset = parsedOffset
+	}
+	return limit, offset
+}
+
+// newPostPage makes a page of the posts a query read from offset with a
+// LIMIT of limit+1, the extra post only showing that there's a next page
+func newPostPage(posts []Post, limit, offset int) PostPage {
+	page := PostPage{Posts: posts, Limit: limit, Offset: offset}
+	if len(posts) > limit {
+		page.Posts = posts[:limit]
+		next := offset + limit
+		page.NextOffset = &next
+	}
+	return page
+}
+
+// paginatePosts slices one page out of an ordered listing
+func paginatePosts(posts []Post, limit, offset int) PostPage {
+	page := PostPage{Posts: []Post{}, Limit: limit, Offset: offset}
+	if offset >= len(posts) {
+		return page
+	}
+
+	end := offset + limit
+	if end < len(posts) {
+		page.NextOffset = &end
+	} else {
+		end = len(posts)
+	}
+	page.Posts = posts[offset:end]
+
+	return page
+}
+
+// rankPosts ranks posts with the named algorithm, loading the recent activity
+// that the rising ranking depends on
+func (h *APIHandler) rankPosts(posts []Post, algorithm string, params RankingParams) error {
+	if algorithm == "rising" {
+		if err := h.db.LoadRecentActivity(posts, risingWindow); err != nil {
+			return err
+		}
+	}
+	return rankPosts(posts, algorithm, params, time.Now())
+}
+
+// rankPosts orders posts in place using the named algorithm, breaking ties by recency
+func rankPosts(posts []Post, algorithm string, params RankingParams, now time.Time) error {
+	rank, ok := rankingAlgorithms[algorithm]
+	if !ok {
+		return fmt.Errorf("unknown sort: %s", algorithm)
+	}
+
+	scores := make(map[int]float64, len(posts))
+	for _, post := range posts {
+		scores[post.ID] = rank(post, now, params)
+	}
+
+	sort.SliceStable(posts, func(i, j int) bool {
+		if scores[posts[i].ID] != scores[posts[j].ID] {
+			return scores[posts[i].ID] > scores[posts[j].ID]
+		}
+		return posts[i].CreatedAt.After(posts[j].CreatedAt)
+	})
+
+	return nil
+}
+
+// Feature flags
+
+// Feature flag stages
+const (
+	featureOff  = "off"  // unavailable to everyone
+	featureBeta = "beta" // available to users who opt in
+	featureOn   = "on"   // available to everyone
+)
+
+// FeatureFlag gates a feature behind a rollout stage
+type FeatureFlag struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Stage       string `json:"stage"`
+}
+
+// defaultFeatureFlags are the flags the server starts with. Features that
+// shipped before their flag start on, so adding a flag doesn't take them away.
+var defaultFeatureFlags = []FeatureFlag{
+	{
+		Name:        "subreddit_discovery",
+		Description: "Suggestions of active subreddits you haven't joined",
+		Stage:       featureOn,
+	},
+}
+
+// FeatureFlags holds the current flag set and is safe for concurrent use
+type FeatureFlags struct {
+	mu    sync.RWMutex
+	flags map[string]FeatureFlag
+}
+
+func NewFeatureFlags(flags []FeatureFlag) *FeatureFlags {
+	f := &FeatureFlags{}
+	f.Replace(flags)
+	return f
+}
+
+// Replace swaps in a new flag set
+func (f *FeatureFlags) Replace(flags []FeatureFlag) {
+	byName := make(map[string]FeatureFlag, len(flags))
+	for _, flag := range flags {
+		byName[flag.Name] = flag
+	}
+
+	f.mu.Lock()
+	f.flags = byName
+	f.mu.Unlock()
+}
+
+// Get looks up a flag by name
+func (f *FeatureFlags) Get(name string) (FeatureFlag, bool) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	flag, ok := f.flags[name]
+	return flag, ok
+}
+
+// Betas lists the flags currently open for opt-in, sorted by name
+func (f *FeatureFlags) Betas() []FeatureFlag {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	betas := []FeatureFlag{}
+	for _, flag := range f.flags {
+		if flag.Stage == featureBeta {
+			betas = append(betas, flag)
+		}
+	}
+	sort.Slice(betas, func(i, j int) bool { return betas[i].Name < betas[j].Name })
+	return betas
+}
+
+// BetaFeature is a beta flag along with whether the requesting user opted in
+type BetaFeature struct {
+	FeatureFlag
+	OptedIn bool `json:"opted_in"`
+}
+
+// Runtime configuration
+
+// RuntimeConfig is the configuration that can be reloaded while the server is
+// running, by sending it SIGHUP or through the admin API
+type RuntimeConfig struct {
+	LogLevel      string        `json:"log_level"`
+	ActorPoolSize int           `json:"actor_pool_size"`
+	RateLimits    RateLimits    `json:"rate_limits"`
+	FeatureFlags  []FeatureFlag `json:"feature_flags"`
+
+	// EditGraceSeconds is how long after posting edits don't mark a post or
+	// comment as edited. 0 marks every edit.
+	EditGraceSeconds int `json:"edit_grace_seconds"`
+
+	// OnboardingSubreddits are the names of subreddits suggested to new users
+	// ahead of the most popular ones
+	OnboardingSubreddits []string `json:"onboarding_subreddits"`
+
+	Spam          SpamThresholds `json:"spam"`
+	PostingLimits PostingLimits  `json:"posting_limits"`
+
+	// VoteFuzzMinutes fuzzes the vote counts of posts younger than this in
+	// every subreddit, on top of subreddits' own setting. 0 turns it off.
+	VoteFuzzMinutes int `json:"vote_fuzz_minutes"`
+}
+
+func defaultRuntimeConfig() RuntimeConfig {
+	return RuntimeConfig{
+		LogLevel:         "debug",
+		ActorPoolSize:    5,
+		FeatureFlags:     defaultFeatureFlags,
+		EditGraceSeconds: 180,
+		Spam:             defaultSpamThresholds,
+		PostingLimits:    defaultPostingLimits,
+	}
+}
+
+// loadRuntimeConfig reads a JSON config file. Settings missing from the file
+// keep their values in defaults.
+func loadRuntimeConfig(path string, defaults RuntimeConfig) (RuntimeConfig, error) {
+	config := defaults
+
+	f, err := os.Open(path)
+	if err != nil {
+		return config, fmt.Errorf("failed to open config: %v", err)
+	}
+	defer f.Close()
+
+	decoder := json.NewDecoder(f)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&config); err != nil {
+		return config, fmt.Errorf("failed to parse config: %v", err)
+	}
+
+	return config, config.Validate()
+}
+
+// Validate checks every setting, so a bad config is rejected as a whole
+func (c RuntimeConfig) Validate() error {
+	if _, ok := logLevels[c.LogLevel]; !ok {
+		return fmt.Errorf("unknown log_level %q", c.LogLevel)
+	}
+	if c.ActorPoolSize < 1 || c.ActorPoolSize > 1000 {
+		return fmt.Errorf("actor_pool_size must be between 1 and 1000")
+	}
+	if c.RateLimits.WritesPerMinute < 0 || c.RateLimits.Burst < 0 {
+		return fmt.Errorf("rate_limits must not be negative")
+	}
+	if c.EditGraceSeconds < 0 || c.EditGraceSeconds > 3600 {
+		return fmt.Errorf("edit_grace_seconds must be between 0 and 3600")
+	}
+	if c.VoteFuzzMinutes < 0 || c.VoteFuzzMinutes > maxVoteFuzzMinutes {
+		return fmt.Errorf("vote_fuzz_minutes must be between 0 and %d", maxVoteFuzzMinutes)
+	}
+	if len(c.OnboardingSubreddits) > onboardingSuggestionCount {
+		return fmt.Errorf("onboarding_subreddits can list at most %d subreddits", onboardingSuggestionCount)
+	}
+	if err := c.Spam.Validate(); err != nil {
+		return err
+	}
+	if err := c.PostingLimits.Validate(); err != nil {
+		return err
+	}
+
+	seen := make(map[string]bool)
+	for _, flag := range c.FeatureFlags {
+		if flag.Name == "" || seen[flag.Name] {
+			return fmt.Errorf("feature flag names must be unique and non-empty")
+		}
+		seen[flag.Name] = true
+		switch flag.Stage {
+		case featureOff, featureBeta, featureOn:
+		default:
+			return fmt.Errorf("feature flag %s: unknown stage %q", flag.Name, flag.Stage)
+		}
+	}
+	return nil
+}
+
+// applyConfig switches the server to a validated config. If any part fails
+// to apply, the previous config is restored.
+func (h *APIHandler) applyConfig(config RuntimeConfig) error {
+	if err := config.Validate(); err != nil {
+		return err
+	}
+
+	h.configMu.Lock()
+	defer h.configMu.Unlock()
+
+	previous := h.config
+	if err := h.setConfig(config); err != nil {
+		if rollbackErr := h.setConfig(previous); rollbackErr != nil {
+			log.Printf("Failed to roll back config: %v", rollbackErr)
+		}
+		return err
+	}
+	h.config = config
+	return nil
+}
+
+func (h *APIHandler) setConfig(config RuntimeConfig) error {
+	setLogLevel(config.LogLevel)
+	h.limiter.SetLimits(config.RateLimits)
+	h.flags.Replace(config.FeatureFlags)
+	h.db.SetSpamThresholds(config.Spam)
+	h.db.SetPostingLimits(config.PostingLimits)
+	setVoteFuzzMinutes(config.VoteFuzzMinutes)
+	if h.pool != nil {
+		if err := h.pool.Resize(config.ActorPoolSize); err != nil {
+			return fmt.Errorf("failed to resize actor pool: %v", err)
+		}
+	}
+	return nil
+}
+
+// reloadConfig re-reads the config file and applies it, keeping the current
+// config when the file is invalid
+func (h *APIHandler) reloadConfig() (RuntimeConfig, error) {
+	if h.configPath == "" {
+		return RuntimeConfig{}, fmt.Errorf("no config file is set (CONFIG_FILE)")
+	}
+
+	config, err := loadRuntimeConfig(h.configPath, h.configDefaults)
+	if err == nil {
+		err = h.applyConfig(config)
+	}
+	if err != nil {
+		h.metrics.Inc(`goreddit_config_reloads_total{result="error"}`)
+		return RuntimeConfig{}, err
+	}
+
+	h.metrics.Inc(`goreddit_config_reloads_total{result="ok"}`)
+	return config, nil
+}
+
+// getConfig returns the config the server is running with
+func (h *APIHandler) getConfig(c *gin.Context) {
+	h.configMu.Lock()
+	config := h.config
+	h.configMu.Unlock()
+
+	c.JSON(http.StatusOK, config)
+}
+
+// reloadConfigHandler lets admins reload the config file
+func (h *APIHandler) reloadConfigHandler(c *gin.Context) {
+	config, err := h.reloadConfig()
+	if err != nil {
+		c.Error(withStatus(http.StatusBadRequest, err))
+		return
+	}
+
+	c.JSON(http.StatusOK, config)
+}
+
+// reloadConfigOnSignal reloads the config file each time the process gets SIGHUP
+func reloadConfigOnSignal(h *APIHandler) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+
+	for range signals {
+		if _, err := h.reloadConfig(); err != nil {
+			log.Printf("Config reload failed, keeping current config: %v", err)
+			continue
+		}
+		log.Printf("Config reloaded from %s", h.configPath)
+	}
+}
+
+// Log levels, from most to least verbose
+const (
+	logDebug int32 = iota
+	logInfo
+	logWarn
+	logError
+)
+
+var logLevels = map[string]int32{
+	"debug": logDebug,
+	"info":  logInfo,
+	"warn":  logWarn,
+	"error": logError,
+}
+
+var currentLogLevel int32 = logDebug
+
+func setLogLevel(name string) {
+	atomic.StoreInt32(&currentLogLevel, logLevels[name])
+}
+
+// logAt logs a message when level is at or above the configured log level
+func logAt(level int32, format string, args ...interface{}) {
+	if level >= atomic.LoadInt32(&currentLogLevel) {
+		log.Printf(format, args...)
+	}
+}
+
+// RateLimits caps how fast each user can make write requests
+type RateLimits struct {
+	WritesPerMinute int `json:"writes_per_minute"` // 0 disables rate limiting
+	Burst           int `json:"burst"`             // writes allowed at once; defaults to WritesPerMinute
+}
+
+// RateLimiter is a per-user token bucket limiter whose limits can be changed
+// at runtime
+type RateLimiter struct {
+	mu      sync.Mutex
+	limits  RateLimits
+	buckets map[string]*tokenBucket
+}
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+func NewRateLimiter(limits RateLimits) *RateLimiter {
+	return &RateLimiter{limits: limits, buckets: make(map[string]*tokenBucket)}
+}
+
+// SetLimits changes the limits. Existing buckets keep their tokens, capped at
+// the new burst size.
+func (l *RateLimiter) SetLimits(limits RateLimits) {
+	l.mu.Lock()
+	l.limits = limits
+	l.mu.Unlock()
+}
+
+// RateLimitStatus is the outcome of a rate limit check, reported to clients
+// in X-RateLimit-* headers so they can slow down before being rejected
+type RateLimitStatus struct {
+	Allowed    bool
+	Unlimited  bool          // rate limiting is off
+	Limit      int           // bucket size: writes allowed at once
+	Remaining  int           // writes left right now
+	Reset      time.Duration // until the bucket is full again
+	RetryAfter time.Duration // until the next token, when not allowed
+}
+
+// Allow takes a token from the key's bucket. When none is left the write is
+// not allowed, and RetryAfter says how long until the next token.
+func (l *RateLimiter) Allow(key string, now time.Time) RateLimitStatus {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.limits.WritesPerMinute == 0 {
+		return RateLimitStatus{Allowed: true, Unlimited: true}
+	}
+	burst := float64(l.limits.Burst)
+	if burst == 0 {
+		burst = float64(l.limits.WritesPerMinute)
+	}
+	perSecond := float64(l.limits.WritesPerMinute) / 60
+
+	bucket, ok := l.buckets[key]
+	if !ok {
+		// Drop buckets that have refilled completely, so idle users don't
+		// accumulate in memory
+		if len(l.buckets) >= 10000 {
+			for k, b := range l.buckets {
+				if b.tokens+now.Sub(b.updated).Seconds()*perSecond >= burst {
+					delete(l.buckets, k)
+				}
+			}
+		}
+		bucket = &tokenBucket{tokens: burst, updated: now}
+		l.buckets[key] = bucket
+	}
+
+	bucket.tokens = math.Min(burst, bucket.tokens+now.Sub(bucket.updated).Seconds()*perSecond)
+	bucket.updated = now
+
+	status := RateLimitStatus{Limit: int(burst)}
+	if bucket.tokens < 1 {
+		status.RetryAfter = time.Duration((1 - bucket.tokens) / perSecond * float64(time.Second))
+	} else {
+		status.Allowed = true
+		bucket.tokens--
+	}
+	status.Remaining = int(bucket.tokens)
+	status.Reset = time.Duration((burst - bucket.tokens) / perSecond * float64(time.Second))
+	return status
+}
+
+// rateLimitWrites rejects write requests from users over their rate limit.
+// Every write response carries X-RateLimit-Limit, X-RateLimit-Remaining and
+// X-RateLimit-Reset (seconds until the limit is fully replenished), so
+// clients can pace themselves instead of running into 429s.
+func (h *APIHandler) rateLimitWrites() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// GraphQL mutations are limited one by one, so queries aren't
+		// counted, and batched votes are limited vote by vote
+		path := c.FullPath()
+		if c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead || path == "/graphql" || path == "/votes/batch" {
+			c.Next()
+			return
+		}
+
+		if h.allowWrite(c) {
+			c.Next()
+		}
+	}
+}
+
+// allowWrite charges the current user one write, reporting their limit in
+// the X-RateLimit-* headers, which are left out while rate limiting is off so
+// their absence means unlimited. Past the limit it responds with a 429 and
+// returns false.
+func (h *APIHandler) allowWrite(c *gin.Context) bool {
+	status := h.limiter.Allow(c.GetString("user_id"), time.Now())
+	if !status.Unlimited {
+		c.Header("X-RateLimit-Limit", strconv.Itoa(status.Limit))
+		c.Header("X-RateLimit-Remaining", strconv.Itoa(status.Remaining))
+		c.Header("X-RateLimit-Reset", strconv.Itoa(int(math.Ceil(status.Reset.Seconds()))))
+	}
+	if !status.Allowed {
+		h.metrics.Inc("goreddit_rate_limited_total")
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(status.RetryAfter.Seconds()))))
+		c.Error(newAPIError(http.StatusTooManyRequests, "Rate limit exceeded"))
+		c.Abort()
+		return false
+	}
+	return true
+}
+
+// Metrics is a small registry of counters and gauges exposed in the
+// Prometheus text format. Names may carry labels, e.g. name{label="value"}.
+type Metrics struct {
+	mu       sync.Mutex
+	counters map[string]float64
+	gauges   map[string]float64
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		counters: make(map[string]float64),
+		gauges:   make(map[string]float64),
+	}
+}
+
+// Inc adds one to a counter
+func (m *Metrics) Inc(name string) {
+	m.Add(name, 1)
+}
+
+// Add adds a value to a counter
+func (m *Metrics) Add(name string, value float64) {
+	m.mu.Lock()
+	m.counters[name] += value
+	m.mu.Unlock()
+}
+
+// Set sets a gauge
+func (m *Metrics) Set(name string, value float64) {
+	m.mu.Lock()
+	m.gauges[name] = value
+	m.mu.Unlock()
+}
+
+// WriteTo renders all metrics in the Prometheus text exposition format
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var buf bytes.Buffer
+	write := func(kind string, values map[string]float64) {
+		names := make([]string, 0, len(values))
+		for name := range values {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		typed := make(map[string]bool)
+		for _, name := range names {
+			base := name
+			if i := strings.IndexByte(name, '{'); i >= 0 {
+				base = name[:i]
+			}
+			if !typed[base] {
+				fmt.Fprintf(&buf, "# TYPE %s %s\n", base, kind)
+				typed[base] = true
+			}
+			fmt.Fprintf(&buf, "%s %g\n", name, values[name])
+		}
+	}
+	write("counter", m.counters)
+	write("gauge", m.gauges)
+
+	return buf.WriteTo(w)
+}
+
+// API handler struct
+type APIHandler struct {
+	db      *DatabaseManager
+	flags   *FeatureFlags
+	metrics *Metrics
+	admins  map[int]bool
+
+	maintenanceMu   sync.Mutex
+	lastMaintenance *MaintenanceReport
+
+	pushMu sync.Mutex // serializes publishNotifications, so nothing is pushed twice
+
+	standby *Standby         // nil unless STANDBY_DIR is set
+	captcha *captchaVerifier // nil unless captcha.provider is set
+
+	limiter    *RateLimiter
+	pool       *ActorPool
+	hub        *EventHub
+	events     *EventBus
+	scheduler  *Scheduler
+	mailer     Mailer
+	publicURL  string // base URL used in links sent by email
+	graphql    graphql.Schema
+	openapi    []byte // the OpenAPI document, built once at startup
+	configPath string
+	configMu   sync.Mutex
+	config     RuntimeConfig
+
+	// configDefaults are what the config file's settings are applied to, the
+	// built-in defaults adjusted by the startup config
+	configDefaults RuntimeConfig
+
+	// allowedOrigins are the origins besides publicURL's that browsers may
+	// open WebSockets from
+	allowedOrigins []string
+}
+
+func NewAPIHandler(dbManager *DatabaseManager) (*APIHandler, error) {
+	graphqlSchema, err := newGraphQLSchema()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build GraphQL schema: %v", err)
+	}
+	openapi, err := json.Marshal(newOpenAPIDocument(apiDocs))
+	if err != nil {
+		return nil, fmt.Errorf("failed to build OpenAPI document: %v", err)
+	}
+	config := defaultRuntimeConfig()
+	metrics := NewMetrics()
+	h := &APIHandler{
+		db:        dbManager,
+		flags:     NewFeatureFlags(config.FeatureFlags),
+		metrics:   metrics,
+		admins:    make(map[int]bool),
+		limiter:   NewRateLimiter(config.RateLimits),
+		hub:       NewEventHub(),
+		events:    NewEventBus(metrics),
+		scheduler: NewScheduler(dbManager, metrics),
+		mailer:    logMailer{},
+		publicURL: "http://localhost:8080",
+		graphql:   graphqlSchema,
+		openapi:   openapi,
+		config:    config,
+
+		configDefaults: config,
+	}
+	h.events.Subscribe(realtimeSink{h})
+	h.events.Subscribe(achievementsSink{dbManager})
+	dbManager.SetSpamThresholds(config.Spam)
+	dbManager.SetPostingLimits(config.PostingLimits)
+	setVoteFuzzMinutes(config.VoteFuzzMinutes)
+	return h, nil
+}
+
+// isAdmin reports whether the request was made by a configured admin signed
+// in with their own session. The X-User-ID header can name anyone, so it
+// never grants admin access, and neither does impersonating an admin.
+func (h *APIHandler) isAdmin(c *gin.Context) bool {
+	if _, ok := c.Get("session_id"); !ok || c.GetString("impersonator_id") != "" {
+		return false
+	}
+	userID, _ := strconv.Atoi(c.GetString("user_id"))
+	return h.admins[userID]
+}
+
+// requireAdmin restricts a route to the configured admin users
+func (h *APIHandler) requireAdmin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !h.isAdmin(c) {
+			c.Error(newAPIError(http.StatusForbidden, "Admin access required"))
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// featureEnabled evaluates a flag for a user: features that are on are enabled
+// for everyone, beta features only for users who opted in
+func (h *APIHandler) featureEnabled(userID int, name string) (bool, error) {
+	flag, ok := h.flags.Get(name)
+	if !ok {
+		return false, nil
+	}
+
+	switch flag.Stage {
+	case featureOn:
+		return true, nil
+	case featureBeta:
+		optIns, err := h.db.GetBetaOptIns(userID)
+		if err != nil {
+			return false, err
+		}
+		return optIns[name], nil
+	default:
+		return false, nil
+	}
+}
+
+// requireFeature hides a route from users the feature flag isn't enabled for
+func (h *APIHandler) requireFeature(name string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, _ := strconv.Atoi(c.GetString("user_id"))
+		enabled, err := h.featureEnabled(userID, name)
+		if err != nil {
+			c.Error(err)
+			c.Abort()
+			return
+		}
+		if !enabled {
+			c.Error(newAPIError(http.StatusNotFound, "Feature not available"))
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// Middleware to authenticate user based on user ID as a parameter
+//
+// The X-User-ID header is only accepted when headerAuth is set. Servers with
+// admins don't set it, since the header would let anyone act as any user,
+// with none of the audit trail of impersonation.
+func authMiddleware(db *DatabaseManager, headerAuth bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := db.WithContext(c.Request.Context())
+
+		// Session tokens issued by /login take precedence
+		if auth := c.GetHeader("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+			session, err := db.GetSessionByToken(strings.TrimPrefix(auth, "Bearer "))
+			if err != nil {
+				c.Error(newAPIError(http.StatusUnauthorized, "Invalid, expired or revoked session"))
+				c.Abort()
+				return
+			}
+			c.Set("user_id", strconv.Itoa(session.UserID))
+			c.Set("session_id", session.SessionID)
+			recordActivity(db, session.UserID)
+
+			if session.ImpersonatorID != nil {
+				impersonate(c, db, session)
+				return
+			}
+			c.Next()
+			return
+		}
+
+		// In a real application, implement proper authentication
+		// For now, we'll use a simple user_id header
+		userID := c.GetHeader("X-User-ID")
+		if userID == "" {
+			c.Error(newAPIError(http.StatusUnauthorized, "User ID required"))
+			c.Abort()
+			return
+		}
+		if !headerAuth {
+			c.Error(newAPIError(http.StatusUnauthorized, "Session token required"))
+			c.Abort()
+			return
+		}
+		c.Set("user_id", userID)
+		if id, err := strconv.Atoi(userID); err == nil {
+			recordActivity(db, id)
+		}
+		c.Next()
+	}
+}
+
+// recordActivity tracks a user's activity for the site stats. Failing to
+// record it doesn't fail the request.
+func recordActivity(db *DatabaseManager, userID int) {
+	if err := db.RecordUserActivity(userID); err != nil {
+		log.Printf("Failed to record user activity: %v", err)
+	}
+}
+
+// impersonate serves a request made with an admin's impersonation token. Every
+// response is flagged with X-Impersonated-By so clients can show a banner,
+// read-scoped tokens can only make GET requests, and writes are audit logged.
+func impersonate(c *gin.Context, db *DatabaseManager, session *AuthSession) {
+	adminID := *session.ImpersonatorID
+	c.Set("impersonator_id", strconv.Itoa(adminID))
+	c.Header("X-Impersonated-By", strconv.Itoa(adminID))
+	if session.ExpiresAt != nil {
+		c.Header("X-Impersonation-Expires", session.ExpiresAt.UTC().Format(time.RFC3339))
+	}
+
+	if c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead {
+		c.Next()
+		return
+	}
+	if session.Scope != impersonationWrite {
+		c.Error(newAPIError(http.StatusForbidden, "Impersonation session is read-only"))
+		c.Abort()
+		return
+	}
+
+	details := c.Request.Method + " " + c.Request.URL.Path
+	if err := db.LogAdminAction(adminID, "impersonated_request", "user", &session.UserID, "", details); err != nil {
+		c.Error(err)
+		c.Abort()
+		return
+	}
+	c.Next()
+}
+
+// Function to get users with highest karma after the simulation
+//
+// With a window, users are ranked by the karma from votes cast within it, and
+// their posts and comments are counted from within it too.
+func (dm *DatabaseManager) GetTopUsers(window time.Duration, limit int) ([]TopUser, error) {
+	defer dm.span("GetTopUsers").End()
+	dm.mu.RLock()
+	defer dm.mu.RUnlock()
+
+	query := `
+        SELECT 
+            u.id,
+            u.username,
+            u.karma,
+            (SELECT COUNT(*) FROM posts WHERE author_id = u.id) as post_count,
+            (SELECT COUNT(*) FROM comments WHERE author_id = u.id) as comment_count
+        FROM users u
+        ORDER BY u.karma DESC
+        LIMIT ?
+    `
+	args := []interface{}{limit}
+	if window > 0 {
+		query = `
+			SELECT u.id, u.username,
+				COALESCE((SELECT SUM(v.vote_value) FROM votes v JOIN posts p ON v.target_id = p.id
+					WHERE v.target_type = 'post' AND p.author_id = u.id AND v.created_at >= datetime('now', ?)), 0) +
+				COALESCE((SELECT SUM(v.vote_value) FROM votes v JOIN comments c ON v.target_id = c.id
+					WHERE v.target_type = 'comment' AND c.author_id = u.id AND v.created_at >= datetime('now', ?)), 0) AS period_karma,
+				(SELECT COUNT(*) FROM posts WHERE author_id = u.id AND created_at >= datetime('now', ?)) AS post_count,
+				(SELECT COUNT(*) FROM comments WHERE author_id = u.id AND created_at >= datetime('now', ?)) AS comment_count
+			FROM users u
+			ORDER BY period_karma DESC, u.id
+			LIMIT ?
+		`
+		since := fmt.Sprintf("-%d seconds", int(window.Seconds()))
+		args = []interface{}{since, since, since, since, limit}
+	}
+
+	rows, err := dm.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []TopUser
+	for rows.Next() {
+		var user TopUser
+		err := rows.Scan(
+			&user.ID,
+			&user.Username,
+			&user.Karma,
+			&user.PostCount,
+			&user.CommentCount,
+		)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+
+	return users, nil
+}
+
+// Function to get details of most subscribed users
+//
+// With a window, only subscriptions made within it are counted, so users are
+// ranked by the subscribers they gained.
+func (dm *DatabaseManager) GetTopSubscribedUsers(window time.Duration, limit int) ([]TopSubscribedUser, error) {
+	defer dm.span("GetTopSubscribedUsers").End()
+	dm.mu.RLock()
+	defer dm.mu.RUnlock()
+
+	since := ""
+	var args []interface{}
+	if window > 0 {
+		since = ` AND us.created_at >= datetime('now', ?)`
+		args = append(args, fmt.Sprintf("-%d seconds", int(window.Seconds())))
+	}
+	query := `
+        SELECT 
+            u.id,
+            u.username,
+            u.karma,
+            COUNT(us.subscriber_id) as subscriber_count
+        FROM users u
+        LEFT JOIN user_subscriptions us ON u.id = us.subscribed_user_id` + since + `
+        GROUP BY u.id, u.username, u.karma
+        ORDER BY subscriber_count DESC
+        LIMIT ?
+    `
+	args = append(args, limit)
+
+	rows, err := dm.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []TopSubscribedUser
+	for rows.Next() {
+		var user TopSubscribedUser
+		err := rows.Scan(
+			&user.ID,
+			&user.Username,
+			&user.Karma,
+			&user.SubscriberCount,
+		)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+
+	return users, nil
+}
+
+// Function to get posts with highest difference between upvotes and downvotes.
+// NSFW posts are left out unless viewerID has show_nsfw on.
+func (dm *DatabaseManager) GetTopPosts(viewerID, limit int) ([]Post, error) {
+	defer dm.span("GetTopPosts").End()
+	dm.mu.RLock()
+	defer dm.mu.RUnlock()
+
+	query := `
+        SELECT ` + postColumns + `
+        FROM posts p
+        JOIN users u ON p.author_id = u.id
+        JOIN subreddits s ON p.subreddit_id = s.id
+        WHERE p.removed = 0 AND p.deleted_at IS NULL AND ` + nsfwPostFilter + `
+        ORDER BY upvotes - downvotes DESC
+        LIMIT ?
+    `
+
+	rows, err := dm.db.Query(query, viewerID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanPosts(rows)
+}
+
+// GetSubredditTopPosts returns a subreddit's highest scoring visible posts
+// made within window (0 for all time)
+func (dm *DatabaseManager) GetSubredditTopPosts(subredditID int, window time.Duration, limit, offset int) ([]Post, error) {
+	defer dm.span("GetSubredditTopPosts").End()
+	dm.mu.RLock()
+	defer dm.mu.RUnlock()
+
+	query := `
+		SELECT ` + postColumns + `
+		FROM posts p
+		JOIN users u ON p.author_id = u.id
+		JOIN subreddits s ON p.subreddit_id = s.id
+		WHERE p.subreddit_id = ? AND p.removed = 0 AND p.deleted_at IS NULL
+	`
+	args := []interface{}{subredditID}
+	if window > 0 {
+		query += ` AND p.created_at >= datetime('now', ?)`
+		args = append(args, fmt.Sprintf("-%d seconds", int(window.Seconds())))
+	}
+	query += ` ORDER BY upvotes - downvotes DESC, p.id DESC LIMIT ? OFFSET ?`
+	args = append(args, limit, offset)
+
+	rows, err := dm.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get top posts: %v", err)
+	}
+	defer rows.Close()
+
+	return scanPosts(rows)
+}
+
+// topTimeframes maps the ?t= values of top listings to how far back they
+// reach; "all" has no limit
+var topTimeframes = map[string]time.Duration{
+	"hour":  time.Hour,
+	"day":   24 * time.Hour,
+	"week":  7 * 24 * time.Hour,
+	"month": 30 * 24 * time.Hour,
+	"year":  365 * 24 * time.Hour,
+	"all":   0,
+}
+
+// defaultTopTimeframe is used when a top listing has no ?t=
+const defaultTopTimeframe = "day"
+
+// TopComment is a comment in the top comments leaderboard, with the post it
+// was made on
+type TopComment struct {
+	Comment
+	PostTitle     string `json:"post_title"`
+	SubredditID   int    `json:"subreddit_id"`
+	SubredditName string `json:"subreddit_name"`
+}
+
+// GetTopComments returns the highest scoring visible comments made within
+// window (0 for all time), across every subreddit or only in subredditID when
+// it isn't 0. Ties go to the newer comment.
+func (dm *DatabaseManager) GetTopComments(subredditID, viewerID int, window time.Duration, limit, offset int) ([]TopComment, error) {
+	defer dm.span("GetTopComments").End()
+	dm.mu.RLock()
+	defer dm.mu.RUnlock()
+
+	query := `
+		SELECT ` + commentColumns + `, p.title, s.id, s.name
+		FROM comments c
+		JOIN users u ON c.author_id = u.id
+		JOIN posts p ON c.post_id = p.id
+		JOIN subreddits s ON p.subreddit_id = s.id
+		` + commentVoteJoin + `
+		WHERE c.removed = 0 AND c.deleted_at IS NULL AND p.removed = 0 AND p.deleted_at IS NULL
+	`
+	args := []interface{}{viewerID}
+	if window > 0 {
+		query += ` AND c.created_at >= datetime('now', ?)`
+		args = append(args, fmt.Sprintf("-%d seconds", int(window.Seconds())))
+	}
+	if subredditID != 0 {
+		query += ` AND p.subreddit_id = ?`
+		args = append(args, subredditID)
+	}
+	query += ` ORDER BY c.score DESC, c.id DESC LIMIT ? OFFSET ?`
+	args = append(args, limit, offset)
+
+	rows, err := dm.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get top comments: %v", err)
+	}
+	defer rows.Close()
+
+	comments := []TopComment{}
+	for rows.Next() {
+		var comment TopComment
+		fields := append(comment.scanFields(), &comment.PostTitle, &comment.SubredditID, &comment.SubredditName)
+		if err := rows.Scan(fields...); err != nil {
+			return nil, err
+		}
+		comments = append(comments, comment)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	marked := make([]Comment, len(comments))
+	for i := range comments {
+		marked[i] = comments[i].Comment
+	}
+	if err := dm.markCollapsed(marked); err != nil {
+		return nil, err
+	}
+	for i := range comments {
+		comments[i].Comment = marked[i]
+	}
+	return comments, nil
+}
+
+// trendingStopWords are ignored when extracting terms from post titles
+var trendingStopWords = map[string]bool{
+	"the": true, "and": true, "for": true, "are": true, "but": true, "not": true,
+	"you": true, "all": true, "any": true, "can": true, "had": true, "her": true,
+	"was": true, "one": true, "our": true, "out": true, "has": true, "his": true,
+	"how": true, "its": true, "who": true, "why": true, "what": true, "when": true,
+	"with": true, "this": true, "that": true, "from": true, "have": true, "just": true,
+	"your": true, "they": true, "will": true, "about": true, "into": true, "than": true,
+	"then": true, "them": true, "been": true, "were": true, "there": true, "their": true,
+	"does": true, "did": true, "get": true, "got": true, "new": true, "now": true,
+}
+
+// minTrendingPosts is the number of recent posts a term must appear in to trend
+const minTrendingPosts = 2
+
+// maxTrendingRepresentatives caps the representative posts stored per topic
+const maxTrendingRepresentatives = 3
+
+// extractTitleTerms splits a title into lowercase terms and two-word phrases,
+// skipping stop words and very short tokens. Each term is returned once.
+func extractTitleTerms(title string) []string {
+	words := strings.FieldsFunc(strings.ToLower(title), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	seen := make(map[string]bool)
+	var terms []string
+	add := func(term string) {
+		if !seen[term] {
+			seen[term] = true
+			terms = append(terms, term)
+		}
+	}
+
+	prev := ""
+	for _, word := range words {
+		if len(word) < 3 || trendingStopWords[word] {
+			prev = ""
+			continue
+		}
+		add(word)
+		if prev != "" {
+			add(prev + " " + word)
+		}
+		prev = word
+	}
+
+	return terms
+}
+
+// ComputeTrendingTopics scores terms from post titles created within the window
+// using TF-IDF against the full post corpus, and replaces the stored trending topics
+func (dm *DatabaseManager) ComputeTrendingTopics(window time.Duration, limit int) error {
+	defer dm.span("ComputeTrendingTopics").End()
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	query := `
+		SELECT p.id, p.title,
+			   p.created_at >= datetime('now', ?) AS recent,
+			   (SELECT COUNT(*) FROM votes WHERE target_id = p.id AND target_type = 'post' AND vote_value = 1) -
+			   (SELECT COUNT(*) FROM votes WHERE target_id = p.id AND target_type = 'post' AND vote_value = -1) AS score
+		FROM posts p
+		WHERE p.removed = 0 AND p.deleted_at IS NULL
+	`
+
+	rows, err := dm.db.Query(query, fmt.Sprintf("-%d seconds", int(window.Seconds())))
+	if err != nil {
+		return err
+	}
+
+	type scoredPost struct {
+		id    int
+		score int
+	}
+
+	totalDocs := 0
+	docFreq := make(map[string]int)
+	termFreq := make(map[string]int)
+	termPosts := make(map[string][]scoredPost)
+
+	for rows.Next() {
+		var (
+			postID, score int
+			title         string
+			recent        bool
+		)
+		if err := rows.Scan(&postID, &title, &recent, &score); err != nil {
+			rows.Close()
+			return err
+		}
+
+		totalDocs++
+		for _, term := range extractTitleTerms(title) {
+			docFreq[term]++
+			if recent {
+				termFreq[term]++
+				termPosts[term] = append(termPosts[term], scoredPost{id: postID, score: score})
+			}
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	var topics []TrendingTopic
+	for term, tf := range termFreq {
+		if tf < minTrendingPosts {
+			continue
+		}
+		idf := math.Log(float64(totalDocs+1)/float64(docFreq[term]+1)) + 1
+		topics = append(topics, TrendingTopic{
+			Term:      term,
+			Score:     float64(tf) * idf,
+			PostCount: tf,
+		})
+	}
+
+	sort.Slice(topics, func(i, j int) bool {
+		if topics[i].Score != topics[j].Score {
+			return topics[i].Score > topics[j].Score
+		}
+		return topics[i].Term < topics[j].Term
+	})
+	if len(topics) > limit {
+		topics = topics[:limit]
+	}
+
+	tx, err := dm.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if _, err = tx.Exec(`DELETE FROM trending_topic_posts`); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to clear trending posts: %v", err)
+	}
+	if _, err = tx.Exec(`DELETE FROM trending_topics`); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to clear trending topics: %v", err)
+	}
+
+	for _, topic := range topics {
+		_, err = tx.Exec(`
+			INSERT INTO trending_topics (term, score, post_count)
+			VALUES (?, ?, ?)
+		`, topic.Term, topic.Score, topic.PostCount)
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to store trending topic: %v", err)
+		}
+
+		// Representative posts are the highest scoring recent posts using the term
+		candidates := termPosts[topic.Term]
+		sort.Slice(candidates, func(i, j int) bool { return candidates[i].score > candidates[j].score })
+		if len(candidates) > maxTrendingRepresentatives {
+			candidates = candidates[:maxTrendingRepresentatives]
+		}
+		for _, candidate := range candidates {
+			_, err = tx.Exec(`
+				INSERT INTO trending_topic_posts (term, post_id)
+				VALUES (?, ?)
+			`, topic.Term, candidate.id)
+			if err != nil {
+				tx.Rollback()
+				return fmt.Errorf("failed to store trending post: %v", err)
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetTrendingTopics returns the most recently computed trending topics with their representative posts
+func (dm *DatabaseManager) GetTrendingTopics(limit int) ([]TrendingTopic, error) {
+	defer dm.span("GetTrendingTopics").End()
+	dm.mu.RLock()
+	defer dm.mu.RUnlock()
+
+	rows, err := dm.db.Query(`
+		SELECT term, score, post_count, computed_at
+		FROM trending_topics
+		ORDER BY score DESC, term
+		LIMIT ?
+	`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	topics := []TrendingTopic{}
+	index := make(map[string]int)
+	for rows.Next() {
+		var topic TrendingTopic
+		err := rows.Scan(&topic.Term, &topic.Score, &topic.PostCount, &topic.ComputedAt)
+		if err != nil {
+			return nil, err
+		}
+		topic.Posts = []Post{}
+		index[topic.Term] = len(topics)
+		topics = append(topics, topic)
+	}
+	if len(topics) == 0 {
+		return topics, nil
+	}
+
+	postRows, err := dm.db.Query(`
+		SELECT tp.term, p.id, p.title, p.content, p.author_id, p.subreddit_id, p.created_at, p.edited_at,
+			   u.username AS author_username, s.name AS subreddit_name, COALESCE(p.flair, ''), p.pinned, p.comment_count,
+			   (SELECT COUNT(*) FROM votes WHERE target_id = p.id AND target_type = 'post' AND vote_value = 1) AS upvotes,
+			   (SELECT COUNT(*) FROM votes WHERE target_id = p.id AND target_type = 'post' AND vote_value = -1) AS downvotes,
+			   (SELECT json_group_array(award) FROM awards WHERE target_type = 'post' AND target_id = p.id) AS awards,
+			   p.kind, p.crosspost_of, p.slug,
+			   COALESCE((SELECT vote_fuzz_minutes FROM subreddit_settings WHERE subreddit_id = p.subreddit_id), 0),
+			   COALESCE((SELECT nsfw FROM subreddit_settings WHERE subreddit_id = p.subreddit_id), 0)
+		FROM trending_topic_posts tp
+		JOIN posts p ON tp.post_id = p.id
+		JOIN users u ON p.author_id = u.id
+		JOIN subreddits s ON p.subreddit_id = s.id
+		WHERE p.removed = 0 AND p.deleted_at IS NULL
+		ORDER BY upvotes - downvotes DESC
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer postRows.Close()
+
+	for postRows.Next() {
+		var term string
+		var post Post
+		err := postRows.Scan(
+			&term, &post.ID, &post.Title, &post.Content, &post.AuthorID,
+			&post.SubredditID, &post.CreatedAt, &post.EditedAt,
+			&post.AuthorUsername, &post.SubredditName, &post.Flair, &post.Pinned, &post.CommentCount,
+			&post.VoteCount.Upvotes, &post.VoteCount.Downvotes, &post.Awards, &post.Kind, &post.CrosspostOf, &post.Slug,
+			&post.subredditFuzzMins, &post.NSFW,
+		)
+		if err != nil {
+			return nil, err
+		}
+		post.Permalink = postPermalink(post)
+		fuzzVoteCount(&post, time.Now())
+		if i, ok := index[term]; ok {
+			topics[i].Posts = append(topics[i].Posts, post)
+		}
+	}
+
+	return topics, nil
+}
+
+// GetAllSubreddits retrieves all subreddits with their IDs
+func (dm *DatabaseManager) GetAllSubreddits() ([]Subreddit, error) {
+	defer dm.span("GetAllSubreddits").End()
+	dm.mu.RLock()
+	defer dm.mu.RUnlock()
+
+	query := `
+		SELECT id, name, description, created_at
+		FROM subreddits
+		WHERE deleted_at IS NULL
+		ORDER BY name
+	`
+
+	rows, err := dm.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var subreddits []Subreddit
+	for rows.Next() {
+		var subreddit Subreddit
+		err := rows.Scan(
+			&subreddit.ID, &subreddit.Name,
+			&subreddit.Description, &subreddit.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		subreddits = append(subreddits, subreddit)
+	}
+
+	return subreddits, nil
+}
+
+// discoveryWindow is how far back activity counts when ranking subreddits
+const discoveryWindow = 7 * 24 * time.Hour
+
+// subredditListingColumns selects the fields read by scanSubredditListings. The
+// first placeholder is the activity window. Queries must alias subreddits as s.
+const subredditListingColumns = `
+	s.id, s.name, COALESCE(s.description, ''), s.created_at,
+	(SELECT COUNT(*) FROM subreddit_members WHERE subreddit_id = s.id) AS member_count,
+	(SELECT COUNT(*) FROM posts WHERE subreddit_id = s.id AND removed = 0 AND deleted_at IS NULL
+		AND created_at >= datetime('now', ?1)) +
+	(SELECT COUNT(*) FROM comments c JOIN posts p ON c.post_id = p.id
+		WHERE p.subreddit_id = s.id AND c.created_at >= datetime('now', ?1)) AS activity
+`
+
+func scanSubredditListings(rows *sql.Rows) ([]SubredditListing, error) {
+	listings := []SubredditListing{}
+	for rows.Next() {
+		var listing SubredditListing
+		err := rows.Scan(
+			&listing.ID, &listing.Name, &listing.Description, &listing.CreatedAt,
+			&listing.MemberCount, &listing.RecentActivity,
+		)
+		if err != nil {
+			return nil, err
+		}
+		listings = append(listings, listing)
+	}
+
+	return listings, rows.Err()
+}
+
+// SearchSubreddits finds subreddits whose name or description contains the
+// query, listing name matches first and then the largest communities
+func (dm *DatabaseManager) SearchSubreddits(query string, limit int) ([]SubredditListing, error) {
+	defer dm.span("SearchSubreddits").End()
+	dm.mu.RLock()
+	defer dm.mu.RUnlock()
+
+	// Escape LIKE wildcards so the query matches literally
+	escaped := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(strings.ToLower(query))
+	pattern := "%" + escaped + "%"
+
+	rows, err := dm.db.Query(`
+		SELECT `+subredditListingColumns+`
+		FROM subreddits s
+		WHERE s.deleted_at IS NULL
+		AND (LOWER(s.name) LIKE ?2 ESCAPE '\' OR LOWER(COALESCE(s.description, '')) LIKE ?2 ESCAPE '\')
+		ORDER BY LOWER(s.name) LIKE ?2 ESCAPE '\' DESC, member_count DESC, s.name
+		LIMIT ?3
+	`, fmt.Sprintf("-%d seconds", int(discoveryWindow.Seconds())), pattern, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanSubredditListings(rows)
+}
+
+// DiscoverSubreddits suggests subreddits the user hasn't joined, ranked by
+// recent post and comment activity and then by member count
+func (dm *DatabaseManager) DiscoverSubreddits(userID, limit int) ([]SubredditListing, error) {
+	defer dm.span("DiscoverSubreddits").End()
+	dm.mu.RLock()
+	defer dm.mu.RUnlock()
+
+	rows, err := dm.db.Query(`
+		SELECT `+subredditListingColumns+`
+		FROM subreddits s
+		WHERE s.deleted_at IS NULL AND s.id NOT IN (SELECT subreddit_id FROM subreddit_members WHERE user_id = ?2)
+		ORDER BY activity DESC, member_count DESC, s.name
+		LIMIT ?3
+	`, fmt.Sprintf("-%d seconds", int(discoveryWindow.Seconds())), userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanSubredditListings(rows)
+}
+
+// GetOnboardingSubreddits suggests subreddits for a new user to join: the
+// featured subreddits in the order given, then the largest and most active
+// ones. Subreddits the user has already joined are left out.
+func (dm *DatabaseManager) GetOnboardingSubreddits(userID int, featured []string, limit int) ([]SubredditListing, error) {
+	defer dm.span("GetOnboardingSubreddits").End()
+	dm.mu.RLock()
+	defer dm.mu.RUnlock()
+
+	window := fmt.Sprintf("-%d seconds", int(discoveryWindow.Seconds()))
+	suggestions := []SubredditListing{}
+
+	if len(featured) > 0 {
+		placeholders := make([]string, len(featured))
+		args := []interface{}{window, userID}
+		for i, name := range featured {
+			placeholders[i] = fmt.Sprintf("?%d", i+3)
+			args = append(args, name)
+		}
+
+		rows, err := dm.db.Query(`
+			SELECT `+subredditListingColumns+`
+			FROM subreddits s
+			WHERE s.name IN (`+strings.Join(placeholders, ", ")+`)
+			AND s.deleted_at IS NULL AND s.id NOT IN (SELECT subreddit_id FROM subreddit_members WHERE user_id = ?2)
+		`, args...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get featured subreddits: %v", err)
+		}
+		listings, err := scanSubredditListings(rows)
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, name := range featured {
+			for _, listing := range listings {
+				if listing.Name == name && len(suggestions) < limit {
+					suggestions = append(suggestions, listing)
+				}
+			}
+		}
+	}
+
+	rows, err := dm.db.Query(`
+		SELECT `+subredditListingColumns+`
+		FROM subreddits s
+		WHERE s.deleted_at IS NULL AND s.id NOT IN (SELECT subreddit_id FROM subreddit_members WHERE user_id = ?2)
+		ORDER BY member_count DESC, activity DESC, s.name
+		LIMIT ?3
+	`, window, userID, limit+len(suggestions))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get popular subreddits: %v", err)
+	}
+	defer rows.Close()
+
+	popular, err := scanSubredditListings(rows)
+	if err != nil {
+		return nil, err
+	}
+	for _, listing := range popular {
+		if len(suggestions) == limit {
+			break
+		}
+		suggested := false
+		for _, s := range suggestions {
+			suggested = suggested || s.ID == listing.ID
+		}
+		if !suggested {
+			suggestions = append(suggestions, listing)
+		}
+	}
+
+	return suggestions, nil
+}
+
+// JoinSubreddits adds the user to several subreddits at once. If any of them
+// doesn't exist, none are joined.
+func (dm *DatabaseManager) JoinSubreddits(userID int, subredditIDs []int) error {
+	defer dm.span("JoinSubreddits").End()
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	tx, err := dm.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	for _, subredditID := range subredditIDs {
+		var exists bool
+		err := tx.QueryRow(`SELECT EXISTS(SELECT 1 FROM subreddits WHERE id = ? AND deleted_at IS NULL)`, subredditID).Scan(&exists)
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to join subreddits: %v", err)
+		}
+		if !exists {
+			tx.Rollback()
+			return fmt.Errorf("subreddit %d not found", subredditID)
+		}
+
+		_, err = tx.Exec(`
+			INSERT OR IGNORE INTO subreddit_members (subreddit_id, user_id)
+			VALUES (?, ?)
+		`, subredditID, userID)
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to join subreddits: %v", err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetUserJoinedSubreddits retrieves subreddits a user has joined
+func (dm *DatabaseManager) GetUserJoinedSubreddits(userID int) ([]Subreddit, error) {
+	defer dm.span("GetUserJoinedSubreddits").End()
+	dm.mu.RLock()
+	defer dm.mu.RUnlock()
+
+	query := `
+		SELECT s.id, s.name, s.description, s.created_at
+		FROM subreddits s
+		JOIN subreddit_members sm ON s.id = sm.subreddit_id
+		WHERE sm.user_id = ? AND s.deleted_at IS NULL
+		ORDER BY s.name
+	`
+
+	rows, err := dm.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var subreddits []Subreddit
+	for rows.Next() {
+		var subreddit Subreddit
+		err := rows.Scan(
+			&subreddit.ID, &subreddit.Name,
+			&subreddit.Description, &subreddit.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		subreddits = append(subreddits, subreddit)
+	}
+
+	return subreddits, nil
+}
+
+// IsModerator reports whether the user moderates the subreddit
+func (dm *DatabaseManager) IsModerator(userID, subredditID int) (bool, error) {
+	defer dm.span("IsModerator").End()
+	dm.mu.RLock()
+	defer dm.mu.RUnlock()
+
+	var count int
+	err := dm.db.QueryRow(`
+		SELECT COUNT(*) FROM subreddit_moderators
+		WHERE subreddit_id = ? AND user_id = ?
+	`, subredditID, userID).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+// CreateAutomodRule stores a new automod rule for a subreddit
+func (dm *DatabaseManager) CreateAutomodRule(subredditID, createdBy int, req CreateAutomodRuleRequest) (int, error) {
+	defer dm.span("CreateAutomodRule").End()
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	var matchType, pattern, flairText interface{}
+	if req.MatchType != "" {
+		matchType = req.MatchType
+		pattern = req.Pattern
+	}
+	if req.FlairText != "" {
+		flairText = req.FlairText
+	}
+
+	result, err := dm.db.Exec(`
+		INSERT INTO automod_rules (subreddit_id, name, match_type, pattern, applies_to,
+			min_account_age_days, min_karma, action, flair_text, created_by)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, subredditID, req.Name, matchType, pattern, req.AppliesTo,
+		req.MinAccountAgeDays, req.MinKarma, req.Action, flairText, createdBy)
+
+	if err != nil {
+		return 0, fmt.Errorf("failed to create automod rule: %v", err)
+	}
+
+	id, err := result.LastInsertId()
+	return int(id), err
+}
+
+// GetAutomodRules lists a subreddit's automod rules
+func (dm *DatabaseManager) GetAutomodRules(subredditID int) ([]AutomodRule, error) {
+	defer dm.span("GetAutomodRules").End()
+	dm.mu.RLock()
+	defer dm.mu.RUnlock()
+
+	rows, err := dm.db.Query(`
+		SELECT id, subreddit_id, name, COALESCE(match_type, ''), COALESCE(pattern, ''), applies_to,
+			   min_account_age_days, min_karma, action, COALESCE(flair_text, ''), created_by, created_at
+		FROM automod_rules
+		WHERE subreddit_id = ?
+		ORDER BY id
+	`, subredditID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanAutomodRules(rows)
+}
+
+// DeleteAutomodRule removes a rule from a subreddit
+func (dm *DatabaseManager) DeleteAutomodRule(subredditID, ruleID int) error {
+	defer dm.span("DeleteAutomodRule").End()
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	result, err := dm.db.Exec(`
+		DELETE FROM automod_rules
+		WHERE id = ? AND subreddit_id = ?
+	`, ruleID, subredditID)
+	if err != nil {
+		return fmt.Errorf("failed to delete automod rule: %v", err)
+	}
+
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return fmt.Errorf("automod rule not found")
+	}
+
+	return nil
+}
+
+// GetModQueue lists unresolved items flagged for review in a subreddit
+func (dm *DatabaseManager) GetModQueue(subredditID int) ([]ModQueueItem, error) {
+	defer dm.span("GetModQueue").End()
+	dm.mu.RLock()
+	defer dm.mu.RUnlock()
+
+	rows, err := dm.db.Query(`
+		SELECT id, subreddit_id, target_type, target_id, reason, source, created_at
+		FROM mod_queue
+		WHERE subreddit_id = ? AND resolved_at IS NULL
+		ORDER BY created_at
+	`, subredditID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []ModQueueItem{}
+	for rows.Next() {
+		var item ModQueueItem
+		err := rows.Scan(
+			&item.ID, &item.SubredditID, &item.TargetType, &item.TargetID,
+			&item.Reason, &item.Source, &item.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+
+	return items, nil
+}
+
+// execer is satisfied by both *sql.DB and *sql.Tx
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// rowQueryer is satisfied by both *sql.DB and *sql.Tx
+type rowQueryer interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// logModAction appends an entry to the moderation log. A nil moderatorID
+// records an automated (automod) action.
+func logModAction(db execer, subredditID int, moderatorID *int, action, targetType string, targetID int, details string) error {
+	result, err := db.Exec(`
+		INSERT INTO mod_log (subreddit_id, moderator_id, action, target_type, target_id, details)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, subredditID, moderatorID, action, targetType, targetID, details)
+	if err != nil {
+		return fmt.Errorf("failed to record mod action: %v", err)
+	}
+
+	logID, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	return enqueueModWebhooks(db, ModWebhookEvent{
+		Event:       modEventFor(action, moderatorID),
+		ModLogID:    int(logID),
+		SubredditID: subredditID,
+		ModeratorID: moderatorID,
+		Action:      action,
+		TargetType:  targetType,
+		TargetID:    targetID,
+		Details:     details,
+		CreatedAt:   time.Now().UTC(),
+	})
+}
+
+// Moderation webhook events. Each mod log entry is delivered as exactly one of these.
+const (
+	modEventReport    = "report"     // content flagged into the mod queue
+	modEventAutomod   = "automod"    // other actions taken by automod
+	modEventBan       = "ban"        // users banned or unbanned
+	modEventModAction = "mod_action" // any other action taken by a moderator
+)
+
+var modEvents = []string{modEventReport, modEventAutomod, modEventBan, modEventModAction}
+
+// modEventFor classifies a mod log action as a webhook event
+func modEventFor(action string, moderatorID *int) string {
+	switch {
+	case strings.HasPrefix(action, "flag_"):
+		return modEventReport
+	case moderatorID == nil:
+		return modEventAutomod
+	case action == "ban_user" || action == "unban_user":
+		return modEventBan
+	default:
+		return modEventModAction
+	}
+}
+
+// ModWebhook is an external URL subscribed to a subreddit's moderation events
+type ModWebhook struct {
+	ID          int       `json:"id"`
+	SubredditID int       `json:"subreddit_id"`
+	URL         string    `json:"url"`
+	Events      []string  `json:"events"`
+	Secret      string    `json:"secret,omitempty"` // only returned when the webhook is created
+	CreatedBy   int       `json:"created_by"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// ModWebhookEvent is the JSON body POSTed to a webhook
+type ModWebhookEvent struct {
+	Event       string    `json:"event"`
+	ModLogID    int       `json:"mod_log_id"`
+	SubredditID int       `json:"subreddit_id"`
+	ModeratorID *int      `json:"moderator_id"` // nil for automod
+	Action      string    `json:"action"`
+	TargetType  string    `json:"target_type"`
+	TargetID    int       `json:"target_id"`
+	Details     string    `json:"details,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// ModWebhookDelivery is one attempt-tracked delivery of an event to a webhook
+type ModWebhookDelivery struct {
+	ID            int        `json:"id"`
+	WebhookID     int        `json:"webhook_id"`
+	Event         string     `json:"event"`
+	Payload       string     `json:"payload"`
+	Attempts      int        `json:"attempts"`
+	NextAttemptAt *time.Time `json:"next_attempt_at,omitempty"`
+	DeliveredAt   *time.Time `json:"delivered_at,omitempty"`
+	FailedAt      *time.Time `json:"failed_at,omitempty"`
+	LastError     string     `json:"last_error,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+}
+
+type CreateModWebhookRequest struct {
+	URL    string   `json:"url" binding:"required,max=2048"`
+	Events []string `json:"events" binding:"required,min=1"`
+}
+
+// enqueueModWebhooks queues a delivery of a mod log entry to every webhook of
+// the subreddit subscribed to its event. It runs in the same transaction as the
+// action, so deliveries are only sent for actions that were committed.
+func enqueueModWebhooks(db execer, event ModWebhookEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`
+		INSERT INTO mod_webhook_deliveries (webhook_id, event, payload)
+		SELECT id, ?, ? FROM mod_webhooks
+		WHERE subreddit_id = ? AND ',' || events || ',' LIKE '%,' || ? || ',%'
+	`, event.Event, string(payload), event.SubredditID, event.Event)
+	if err != nil {
+		return fmt.Errorf("failed to queue mod webhooks: %v", err)
+	}
+	return nil
+}
+
+// CreateModWebhook subscribes a URL to a subreddit's moderation events and
+// generates the secret its deliveries are signed with
+func (dm *DatabaseManager) CreateModWebhook(subredditID, createdBy int, url string, events []string) (*ModWebhook, error) {
+	defer dm.span("CreateModWebhook").End()
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	secret, err := randomHex(32)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := dm.db.Exec(`
+		INSERT INTO mod_webhooks (subreddit_id, url, secret, events, created_by)
+		VALUES (?, ?, ?, ?, ?)
+	`, subredditID, url, secret, strings.Join(events, ","), createdBy)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create webhook: %v", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	return &ModWebhook{
+		ID:          int(id),
+		SubredditID: subredditID,
+		URL:         url,
+		Events:      events,
+		Secret:      secret,
+		CreatedBy:   createdBy,
+		CreatedAt:   time.Now(),
+	}, nil
+}
+
+// GetModWebhooks lists a subreddit's webhooks, without their secrets
+func (dm *DatabaseManager) GetModWebhooks(subredditID int) ([]ModWebhook, error) {
+	defer dm.span("GetModWebhooks").End()
+	dm.mu.RLock()
+	defer dm.mu.RUnlock()
+
+	rows, err := dm.db.Query(`
+		SELECT id, subreddit_id, url, events, created_by, created_at
+		FROM mod_webhooks
+		WHERE subreddit_id = ?
+		ORDER BY id
+	`, subredditID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get webhooks: %v", err)
+	}
+	defer rows.Close()
+
+	webhooks := []ModWebhook{}
+	for rows.Next() {
+		var webhook ModWebhook
+		var events string
+		if err := rows.Scan(&webhook.ID, &webhook.SubredditID, &webhook.URL, &events,
+			&webhook.CreatedBy, &webhook.CreatedAt); err != nil {
+			return nil, err
+		}
+		webhook.Events = strings.Split(events, ",")
+		webhooks = append(webhooks, webhook)
+	}
+
+	return webhooks, rows.Err()
+}
+
+// DeleteModWebhook removes a webhook along with its pending deliveries
+func (dm *DatabaseManager) DeleteModWebhook(subredditID, webhookID int) error {
+	defer dm.span("DeleteModWebhook").End()
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	tx, err := dm.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.Exec(`DELETE FROM mod_webhooks WHERE id = ? AND subreddit_id = ?`, webhookID, subredditID)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to delete webhook: %v", err)
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		tx.Rollback()
+		return fmt.Errorf("webhook not found")
+	}
+
+	if _, err := tx.Exec(`DELETE FROM mod_webhook_deliveries WHERE webhook_id = ?`, webhookID); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to delete webhook deliveries: %v", err)
+	}
+
+	return tx.Commit()
+}
+
+// GetModWebhookDeliveries lists the most recent deliveries to a webhook, for
+// debugging a receiver
+func (dm *DatabaseManager) GetModWebhookDeliveries(subredditID, webhookID, limit int) ([]ModWebhookDelivery, error) {
+	defer dm.span("GetModWebhookDeliveries").End()
+	dm.mu.RLock()
+	defer dm.mu.RUnlock()
+
+	rows, err := dm.db.Query(`
+		SELECT d.id, d.webhook_id, d.event, d.payload, d.attempts, d.next_attempt_at, d.delivered_at, d.failed_at, COALESCE(d.last_error, ''), d.created_at
+		FROM mod_webhook_deliveries d
+		JOIN mod_webhooks w ON d.webhook_id = w.id
+		WHERE d.webhook_id = ? AND w.subreddit_id = ?
+		ORDER BY d.id DESC
+		LIMIT ?
+	`, webhookID, subredditID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get webhook deliveries: %v", err)
+	}
+	defer rows.Close()
+
+	return scanModWebhookDeliveries(rows)
+}
+
+func scanModWebhookDeliveries(rows *sql.Rows) ([]ModWebhookDelivery, error) {
+	deliveries := []ModWebhookDelivery{}
+	for rows.Next() {
+		var d ModWebhookDelivery
+		if err := rows.Scan(&d.ID, &d.WebhookID, &d.Event, &d.Payload, &d.Attempts, &d.NextAttemptAt,
+			&d.DeliveredAt, &d.FailedAt, &d.LastError, &d.CreatedAt); err != nil {
+			return nil, err
+		}
+		// Finished deliveries have no next attempt
+		if d.DeliveredAt != nil || d.FailedAt != nil {
+			d.NextAttemptAt = nil
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+// DueModWebhookDelivery is a pending delivery together with where to send it
+type DueModWebhookDelivery struct {
+	ModWebhookDelivery
+	URL    string
+	Secret string
+}
+
+// DueModWebhookDeliveries returns pending deliveries whose next attempt is due,
+// oldest first
+func (dm *DatabaseManager) DueModWebhookDeliveries(limit int) ([]DueModWebhookDelivery, error) {
+	defer dm.span("DueModWebhookDeliveries").End()
+	dm.mu.RLock()
+	defer dm.mu.RUnlock()
+
+	rows, err := dm.db.Query(`
+		SELECT d.id, d.webhook_id, d.event, d.payload, d.attempts, w.url, w.secret
+		FROM mod_webhook_deliveries d
+		JOIN mod_webhooks w ON d.webhook_id = w.id
+		WHERE d.delivered_at IS NULL AND d.failed_at IS NULL AND d.next_attempt_at <= CURRENT_TIMESTAMP
+		ORDER BY d.id
+		LIMIT ?
+	`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get due webhook deliveries: %v", err)
+	}
+	defer rows.Close()
+
+	var due []DueModWebhookDelivery
+	for rows.Next() {
+		var d DueModWebhookDelivery
+		if err := rows.Scan(&d.ID, &d.WebhookID, &d.Event, &d.Payload, &d.Attempts, &d.URL, &d.Secret); err != nil {
+			return nil, err
+		}
+		due = append(due, d)
+	}
+	return due, rows.Err()
+}
+
+// RecordModWebhookAttempt records the outcome of a delivery attempt. A failed
+// delivery is retried after retryAfter, or given up on when retryAfter is 0.
+func (dm *DatabaseManager) RecordModWebhookAttempt(deliveryID int, deliveryErr error, retryAfter time.Duration) error {
+	defer dm.span("RecordModWebhookAttempt").End()
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	var err error
+	switch {
+	case deliveryErr == nil:
+		_, err = dm.db.Exec(`
+			UPDATE mod_webhook_deliveries
+			SET attempts = attempts + 1, delivered_at = CURRENT_TIMESTAMP, last_error = NULL
+			WHERE id = ?
+		`, deliveryID)
+	case retryAfter > 0:
+		_, err = dm.db.Exec(`
+			UPDATE mod_webhook_deliveries
+			SET attempts = attempts + 1, last_error = ?, next_attempt_at = datetime('now', ?)
+			WHERE id = ?
+		`, deliveryErr.Error(), fmt.Sprintf("+%d seconds", int(retryAfter.Seconds())), deliveryID)
+	default:
+		_, err = dm.db.Exec(`
+			UPDATE mod_webhook_deliveries
+			SET attempts = attempts + 1, last_error = ?, failed_at = CURRENT_TIMESTAMP
+			WHERE id = ?
+		`, deliveryErr.Error(), deliveryID)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to record webhook attempt: %v", err)
+	}
+	return nil
+}
+
+// ErrBannedFromSubreddit is returned when a banned user tries to post or comment
+var ErrBannedFromSubreddit = errors.New("you are banned from this subreddit")
+
+// checkNotBanned fails with ErrBannedFromSubreddit when the user has an active ban
+func checkNotBanned(tx *sql.Tx, subredditID, userID int) error {
+	var count int
+	err := tx.QueryRow(`
+		SELECT COUNT(*) FROM subreddit_bans
+		WHERE subreddit_id = ? AND user_id = ?
+		  AND (expires_at IS NULL OR expires_at > CURRENT_TIMESTAMP)
+	`, subredditID, userID).Scan(&count)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrBannedFromSubreddit
+	}
+	return nil
+}
+
+// RemoveContent lets a moderator remove a post or comment from their subreddit
+func (dm *DatabaseManager) RemoveContent(subredditID, moderatorID int, targetType string, targetID int, reason string) error {
+	defer dm.span("RemoveContent").End()
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	tx, err := dm.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	var result sql.Result
+	if targetType == "post" {
+		result, err = tx.Exec(`
+			UPDATE posts SET removed = 1
+			WHERE id = ? AND subreddit_id = ?
+		`, targetID, subredditID)
+	} else {
+		result, err = tx.Exec(`
+			UPDATE comments SET removed = 1
+			WHERE id = ? AND post_id IN (SELECT id FROM posts WHERE subreddit_id = ?)
+		`, targetID, subredditID)
+	}
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to remove %s: %v", targetType, err)
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		tx.Rollback()
+		return fmt.Errorf("%s not found in subreddit", targetType)
+	}
+
+	if targetType == "comment" {
+		var postID int
+		err := tx.QueryRow(`SELECT post_id FROM comments WHERE id = ?`, targetID).Scan(&postID)
+		if err == nil {
+			err = updateCommentCount(tx, postID)
+		}
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	// Removing content resolves any pending review of it
+	_, err = tx.Exec(`
+		UPDATE mod_queue SET resolved_at = CURRENT_TIMESTAMP
+		WHERE subreddit_id = ? AND target_type = ? AND target_id = ? AND resolved_at IS NULL
+	`, subredditID, targetType, targetID)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to resolve mod queue: %v", err)
+	}
+
+	if err := logModAction(tx, subredditID, &moderatorID, "remove_"+targetType, targetType, targetID, reason); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	refs := notificationRefs{SubredditID: &subredditID}
+	var authorID int
+	if targetType == "post" {
+		refs.PostID = &targetID
+		err = tx.QueryRow(`SELECT author_id FROM posts WHERE id = ?`, targetID).Scan(&authorID)
+	} else {
+		var postID int
+		refs.PostID, refs.CommentID = &postID, &targetID
+		err = tx.QueryRow(`SELECT author_id, post_id FROM comments WHERE id = ?`, targetID).Scan(&authorID, &postID)
+	}
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to find author: %v", err)
+	}
+	if err := createNotification(tx, authorID, "mod_action", moderatorID, refs); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// BanUser bans a user from a subreddit, permanently when durationDays is zero
+func (dm *DatabaseManager) BanUser(subredditID, moderatorID, userID int, reason string, durationDays int) error {
+	defer dm.span("BanUser").End()
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	tx, err := dm.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	var expiresAt interface{}
+	if durationDays > 0 {
+		expiresAt = time.Now().UTC().AddDate(0, 0, durationDays).Format("2006-01-02 15:04:05")
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO subreddit_bans (subreddit_id, user_id, reason, banned_by, expires_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(subreddit_id, user_id) DO UPDATE SET
+			reason = excluded.reason,
+			banned_by = excluded.banned_by,
+			expires_at = excluded.expires_at,
+			created_at = CURRENT_TIMESTAMP
+	`, subredditID, userID, reason, moderatorID, expiresAt)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to ban user: %v", err)
+	}
+
+	details := reason
+	if durationDays > 0 {
+		details = fmt.Sprintf("%s (%d days)", reason, durationDays)
+	}
+	if err := logModAction(tx, subredditID, &moderatorID, "ban_user", "user", userID, details); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := createNotification(tx, userID, "mod_action", moderatorID, notificationRefs{SubredditID: &subredditID}); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UnbanUser lifts a user's ban from a subreddit
+func (dm *DatabaseManager) UnbanUser(subredditID, moderatorID, userID int) error {
+	defer dm.span("UnbanUser").End()
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	tx, err := dm.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.Exec(`
+		DELETE FROM subreddit_bans
+		WHERE subreddit_id = ? AND user_id = ?
+	`, subredditID, userID)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to unban user: %v", err)
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		tx.Rollback()
+		return fmt.Errorf("user is not banned")
+	}
+
+	if err := logModAction(tx, subredditID, &moderatorID, "unban_user", "user", userID, ""); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := createNotification(tx, userID, "mod_action", moderatorID, notificationRefs{SubredditID: &subredditID}); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetSubredditBans lists the active bans of a subreddit
+func (dm *DatabaseManager) GetSubredditBans(subredditID int) ([]SubredditBan, error) {
+	defer dm.span("GetSubredditBans").End()
+	dm.mu.RLock()
+	defer dm.mu.RUnlock()
+
+	rows, err := dm.db.Query(`
+		SELECT b.user_id, u.username, COALESCE(b.reason, ''), b.expires_at, b.created_at
+		FROM subreddit_bans b
+		JOIN users u ON b.user_id = u.id
+		WHERE b.subreddit_id = ?
+		  AND (b.expires_at IS NULL OR b.expires_at > CURRENT_TIMESTAMP)
+		ORDER BY b.created_at DESC
+	`, subredditID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	bans := []SubredditBan{}
+	for rows.Next() {
+		var ban SubredditBan
+		var expiresAt sql.NullTime
+		if err := rows.Scan(&ban.UserID, &ban.Username, &ban.Reason, &expiresAt, &ban.CreatedAt); err != nil {
+			return nil, err
+		}
+		if expiresAt.Valid {
+			ban.ExpiresAt = &expiresAt.Time
+		}
+		bans = append(bans, ban)
+	}
+
+	return bans, nil
+}
+
+// SetPostPinned pins or unpins a post at the top of its subreddit
+func (dm *DatabaseManager) SetPostPinned(subredditID, moderatorID, postID int, pinned bool) error {
+	defer dm.span("SetPostPinned").End()
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	tx, err := dm.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.Exec(`
+		UPDATE posts SET pinned = ?
+		WHERE id = ? AND subreddit_id = ?
+	`, pinned, postID, subredditID)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to pin post: %v", err)
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		tx.Rollback()
+		return fmt.Errorf("post not found in subreddit")
+	}
+
+	action := "pin_post"
+	if !pinned {
+		action = "unpin_post"
+	}
+	if err := logModAction(tx, subredditID, &moderatorID, action, "post", postID, ""); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// SetPostFlair changes a post's flair; an empty flair clears it
+func (dm *DatabaseManager) SetPostFlair(subredditID, moderatorID, postID int, flair string) error {
+	defer dm.span("SetPostFlair").End()
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	tx, err := dm.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	var flairValue interface{}
+	if flair != "" {
+		flairValue = flair
+	}
+
+	result, err := tx.Exec(`
+		UPDATE posts SET flair = ?
+		WHERE id = ? AND subreddit_id = ?
+	`, flairValue, postID, subredditID)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to change flair: %v", err)
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		tx.Rollback()
+		return fmt.Errorf("post not found in subreddit")
+	}
+
+	if err := logModAction(tx, subredditID, &moderatorID, "change_flair", "post", postID, flair); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetModLog lists a subreddit's moderation log, newest first. Empty filters
+// match everything; the moderator "AutoModerator" matches automated actions.
+func (dm *DatabaseManager) GetModLog(subredditID int, moderator, action string, limit int) ([]ModLogEntry, error) {
+	defer dm.span("GetModLog").End()
+	dm.mu.RLock()
+	defer dm.mu.RUnlock()
+
+	query := `
+		SELECT l.id, l.subreddit_id, l.moderator_id, COALESCE(u.username, ?), l.action,
+			   l.target_type, l.target_id, COALESCE(l.details, ''), l.created_at
+		FROM mod_log l
+		LEFT JOIN users u ON l.moderator_id = u.id
+		WHERE l.subreddit_id = ?
+	`
+	args := []interface{}{automodName, subredditID}
+
+	if moderator == automodName {
+		query += ` AND l.moderator_id IS NULL`
+	} else if moderator != "" {
+		query += ` AND u.username = ?`
+		args = append(args, moderator)
+	}
+	if action != "" {
+		query += ` AND l.action = ?`
+		args = append(args, action)
+	}
+	query += ` ORDER BY l.created_at DESC, l.id DESC LIMIT ?`
+	args = append(args, limit)
+
+	rows, err := dm.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []ModLogEntry{}
+	for rows.Next() {
+		var entry ModLogEntry
+		var moderatorID sql.NullInt64
+		err := rows.Scan(
+			&entry.ID, &entry.SubredditID, &moderatorID, &entry.Moderator, &entry.Action,
+			&entry.TargetType, &entry.TargetID, &entry.Details, &entry.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if moderatorID.Valid {
+			id := int(moderatorID.Int64)
+			entry.ModeratorID = &id
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// ExportModLists returns a subreddit's active bans and word filters. Word
+// filters are the automod rules that match on a keyword or regex.
+func (dm *DatabaseManager) ExportModLists(subredditID int) (*ModLists, error) {
+	defer dm.span("ExportModLists").End()
+	bans, err := dm.GetSubredditBans(subredditID)
+	if err != nil {
+		return nil, err
+	}
+	rules, err := dm.GetAutomodRules(subredditID)
+	if err != nil {
+		return nil, err
+	}
+
+	lists := &ModLists{Bans: []ModListBan{}, Filters: []ModListFilter{}}
+	for _, ban := range bans {
+		lists.Bans = append(lists.Bans, ModListBan{
+			Username:  ban.Username,
+			Reason:    ban.Reason,
+			ExpiresAt: ban.ExpiresAt,
+		})
+	}
+	for _, rule := range rules {
+		if rule.MatchType == "" {
+			continue
+		}
+		lists.Filters = append(lists.Filters, ModListFilter{
+			Name:      rule.Name,
+			MatchType: rule.MatchType,
+			Pattern:   rule.Pattern,
+			AppliesTo: rule.AppliesTo,
+			Action:    rule.Action,
+			FlairText: rule.FlairText,
+		})
+	}
+
+	return lists, nil
+}
+
+// ImportModLists adds bans and word filters from another community's lists in
+// one transaction. Invalid entries and entries that conflict with existing
+// bans or filters are skipped and reported. A dry run only produces the report.
+func (dm *DatabaseManager) ImportModLists(subredditID, moderatorID int, lists ModLists, dryRun bool) (*ModListImportReport, error) {
+	defer dm.span("ImportModLists").End()
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	report := &ModListImportReport{
+		DryRun:    dryRun,
+		Conflicts: []ModListIssue{},
+		Invalid:   []ModListIssue{},
+	}
+
+	tx, err := dm.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	for _, ban := range lists.Bans {
+		if ban.Username == "" {
+			report.Invalid = append(report.Invalid, ModListIssue{Kind: "ban", Reason: "username is required"})
+			continue
+		}
+
+		var userID int
+		err := tx.QueryRow(`SELECT id FROM users WHERE username = ?`, ban.Username).Scan(&userID)
+		if err == sql.ErrNoRows {
+			report.Invalid = append(report.Invalid, ModListIssue{Kind: "ban", Entry: ban.Username, Reason: "user does not exist"})
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var existing int
+		err = tx.QueryRow(`
+			SELECT COUNT(*) FROM subreddit_bans
+			WHERE subreddit_id = ? AND user_id = ?
+			  AND (expires_at IS NULL OR expires_at > CURRENT_TIMESTAMP)
+		`, subredditID, userID).Scan(&existing)
+		if err != nil {
+			return nil, err
+		}
+		if existing > 0 {
+			report.Conflicts = append(report.Conflicts, ModListIssue{Kind: "ban", Entry: ban.Username, Reason: "user is already banned"})
+			continue
+		}
+		if ban.ExpiresAt != nil && ban.ExpiresAt.Before(time.Now()) {
+			report.Invalid = append(report.Invalid, ModListIssue{Kind: "ban", Entry: ban.Username, Reason: "ban has already expired"})
+			continue
+		}
+
+		var expiresAt interface{}
+		if ban.ExpiresAt != nil {
+			expiresAt = ban.ExpiresAt.UTC().Format("2006-01-02 15:04:05")
+		}
+		_, err = tx.Exec(`
+			INSERT OR REPLACE INTO subreddit_bans (subreddit_id, user_id, reason, banned_by, expires_at)
+			VALUES (?, ?, ?, ?, ?)
+		`, subredditID, userID, ban.Reason, moderatorID, expiresAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to import ban: %v", err)
+		}
+		if err := logModAction(tx, subredditID, &moderatorID, "ban_user", "user", userID, "imported: "+ban.Reason); err != nil {
+			return nil, err
+		}
+		report.ImportedBans++
+	}
+
+	for _, filter := range lists.Filters {
+		if err := filter.validate(); err != nil {
+			report.Invalid = append(report.Invalid, ModListIssue{Kind: "filter", Entry: filter.Pattern, Reason: err.Error()})
+			continue
+		}
+
+		var existing int
+		err := tx.QueryRow(`
+			SELECT COUNT(*) FROM automod_rules
+			WHERE subreddit_id = ? AND match_type = ? AND pattern = ?
+		`, subredditID, filter.MatchType, filter.Pattern).Scan(&existing)
+		if err != nil {
+			return nil, err
+		}
+		if existing > 0 {
+			report.Conflicts = append(report.Conflicts, ModListIssue{Kind: "filter", Entry: filter.Pattern, Reason: "filter already exists"})
+			continue
+		}
+
+		var flairText interface{}
+		if filter.FlairText != "" {
+			flairText = filter.FlairText
+		}
+		_, err = tx.Exec(`
+			INSERT INTO automod_rules (subreddit_id, name, match_type, pattern, applies_to,
+				action, flair_text, created_by)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		`, subredditID, filter.Name, filter.MatchType, filter.Pattern, filter.AppliesTo,
+			filter.Action, flairText, moderatorID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to import filter: %v", err)
+		}
+		report.ImportedFilters++
+	}
+
+	if dryRun {
+		return report, nil
+	}
+
+	return report, tx.Commit()
+}
+
+func scanAutomodRules(rows *sql.Rows) ([]AutomodRule, error) {
+	rules := []AutomodRule{}
+	for rows.Next() {
+		var rule AutomodRule
+		var minKarma sql.NullInt64
+		err := rows.Scan(
+			&rule.ID, &rule.SubredditID, &rule.Name, &rule.MatchType, &rule.Pattern,
+			&rule.AppliesTo, &rule.MinAccountAgeDays, &minKarma, &rule.Action,
+			&rule.FlairText, &rule.CreatedBy, &rule.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if minKarma.Valid {
+			karma := int(minKarma.Int64)
+			rule.MinKarma = &karma
+		}
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+// matches reports whether new content trips the rule. Every condition the
+// rule sets must hold: the pattern matches, and the author is younger or has
+// less karma than the configured minimums.
+func (r AutomodRule) matches(text string, authorKarma int, accountAgeDays float64) bool {
+	switch r.MatchType {
+	case "keyword":
+		if !strings.Contains(strings.ToLower(text), strings.ToLower(r.Pattern)) {
+			return false
+		}
+	case "regex":
+		re, err := regexp.Compile(r.Pattern)
+		if err != nil || !re.MatchString(text) {
+			return false
+		}
+	}
+
+	if r.MinAccountAgeDays > 0 && accountAgeDays >= float64(r.MinAccountAgeDays) {
+		return false
+	}
+	if r.MinKarma != nil && authorKarma >= *r.MinKarma {
+		return false
+	}
+
+	return true
+}
+
+// applyAutomodRules evaluates a subreddit's rules against newly created content
+// within the creating transaction and applies the actions of every matching rule
+func applyAutomodRules(tx *sql.Tx, subredditID int, targetType string, targetID, authorID int, text string) (AutomodOutcome, error) {
+	var outcome AutomodOutcome
+
+	var karma int
+	var ageDays float64
+	err := tx.QueryRow(`
+		SELECT karma, julianday('now') - julianday(created_at)
+		FROM users WHERE id = ?
+	`, authorID).Scan(&karma, &ageDays)
+	if err != nil {
+		return outcome, fmt.Errorf("failed to load author for automod: %v", err)
+	}
+
+	rows, err := tx.Query(`
+		SELECT id, subreddit_id, name, COALESCE(match_type, ''), COALESCE(pattern, ''), applies_to,
+			   min_account_age_days, min_karma, action, COALESCE(flair_text, ''), created_by, created_at
+		FROM automod_rules
+		WHERE subreddit_id = ? AND applies_to IN (?, 'both')
+		ORDER BY id
+	`, subredditID, targetType)
+	if err != nil {
+		return outcome, err
+	}
+	rules, err := scanAutomodRules(rows)
+	rows.Close()
+	if err != nil {
+		return outcome, err
+	}
+
+	table := "posts"
+	if targetType == "comment" {
+		table = "comments"
+	}
+
+	for _, rule := range rules {
+		if !rule.matches(text, karma, ageDays) {
+			continue
+		}
+
+		reason := "automod rule: " + rule.Name
+		switch rule.Action {
+		case "remove":
+			_, err = tx.Exec(fmt.Sprintf("UPDATE %s SET removed = 1 WHERE id = ?", table), targetID)
+			if err == nil {
+				err = logModAction(tx, subredditID, nil, "remove_"+targetType, targetType, targetID, reason)
+			}
+			outcome.Removed = true
+		case "flag":
+			_, err = tx.Exec(`
+				INSERT INTO mod_queue (subreddit_id, target_type, target_id, reason, source)
+				VALUES (?, ?, ?, ?, 'automod')
+			`, subredditID, targetType, targetID, reason)
+			if err == nil {
+				err = logModAction(tx, subredditID, nil, "flag_"+targetType, targetType, targetID, reason)
+			}
+			outcome.Flagged = true
+		case "flair":
+			if targetType == "post" {
+				_, err = tx.Exec(`UPDATE posts SET flair = ? WHERE id = ?`, rule.FlairText, targetID)
+				if err == nil {
+					err = logModAction(tx, subredditID, nil, "change_flair", targetType, targetID, reason)
+				}
+				outcome.Flair = rule.FlairText
+			}
+		}
+		if err != nil {
+			return outcome, fmt.Errorf("failed to apply automod rule %d: %v", rule.ID, err)
+		}
+	}
+
+	return outcome, nil
+}
+
+// postColumns selects the fields read by scanPosts. Queries using it must alias
+// posts as p, users as u and subreddits as s.
+const postColumns = `
+	p.id, p.title, p.content, p.author_id, p.subreddit_id, p.created_at, p.edited_at,
+	u.username AS author_username, s.name AS subreddit_name, COALESCE(p.flair, ''), p.pinned, p.comment_count,
+	(SELECT COUNT(*) FROM votes WHERE target_id = p.id AND target_type = 'post' AND vote_value = 1) AS upvotes,
+	(SELECT COUNT(*) FROM votes WHERE target_id = p.id AND target_type = 'post' AND vote_value = -1) AS downvotes,
+	(SELECT json_group_array(award) FROM awards WHERE target_type = 'post' AND target_id = p.id) AS awards,
+	p.kind, p.crosspost_of, p.slug,
+	COALESCE((SELECT vote_fuzz_minutes FROM subreddit_settings WHERE subreddit_id = p.subreddit_id), 0),
+	COALESCE((SELECT nsfw FROM subreddit_settings WHERE subreddit_id = p.subreddit_id), 0)
+`
+
+// nsfwPostFilter leaves out the posts of NSFW subreddits unless the viewer,
+// bound to ?, has turned show_nsfw on. Site-wide listings use it; a
+// subreddit's own listings show all its posts. Queries using it must alias
+// posts as p.
+const nsfwPostFilter = `
+	(NOT EXISTS (SELECT 1 FROM subreddit_settings WHERE subreddit_id = p.subreddit_id AND nsfw = 1)
+		OR EXISTS (SELECT 1 FROM user_profiles WHERE user_id = ? AND show_nsfw = 1))
+`
+
+// scanPosts reads rows selected with postColumns
+func scanPosts(rows *sql.Rows) ([]Post, error) {
+	posts := []Post{}
+	for rows.Next() {
+		var post Post
+		err := rows.Scan(
+			&post.ID, &post.Title, &post.Content, &post.AuthorID,
+			&post.SubredditID, &post.CreatedAt, &post.EditedAt,
+			&post.AuthorUsername, &post.SubredditName, &post.Flair, &post.Pinned, &post.CommentCount,
+			&post.VoteCount.Upvotes, &post.VoteCount.Downvotes, &post.Awards, &post.Kind, &post.CrosspostOf, &post.Slug,
+			&post.subredditFuzzMins, &post.NSFW,
+		)
+		if err != nil {
+			return nil, err
+		}
+		post.Permalink = postPermalink(post)
+		fuzzVoteCount(&post, time.Now())
+		posts = append(posts, post)
+	}
+
+	return posts, rows.Err()
+}
+
+// GetSubredditPosts retrieves limit visible posts of a subreddit from offset,
+// pinned posts first and then newest first, or all of them when limit is
+// allPosts
+func (dm *DatabaseManager) GetSubredditPosts(subredditID, limit, offset int) ([]Post, error) {
+	defer dm.span("GetSubredditPosts").End()
+	dm.mu.RLock()
+	defer dm.mu.RUnlock()
+
+	rows, err := dm.db.Query(`
+		SELECT `+postColumns+`
+		FROM posts p
+		JOIN users u ON p.author_id = u.id
+		JOIN subreddits s ON p.subreddit_id = s.id
+		WHERE p.subreddit_id = ? AND p.removed = 0 AND p.deleted_at IS NULL
+		ORDER BY p.pinned DESC, p.created_at DESC, p.id DESC
+		LIMIT ? OFFSET ?
+	`, subredditID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanPosts(rows)
+}
+
+// GetAllPosts retrieves every visible post across all subreddits, newest
+// first. NSFW posts are left out unless viewerID has show_nsfw on.
+func (dm *DatabaseManager) GetAllPosts(viewerID int) ([]Post, error) {
+	defer dm.span("GetAllPosts").End()
+	dm.mu.RLock()
+	defer dm.mu.RUnlock()
+
+	rows, err := dm.db.Query(`
+		SELECT `+postColumns+`
+		FROM posts p
+		JOIN users u ON p.author_id = u.id
+		JOIN subreddits s ON p.subreddit_id = s.id
+		WHERE p.removed = 0 AND p.deleted_at IS NULL AND `+nsfwPostFilter+`
+		ORDER BY p.created_at DESC
+	`, viewerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanPosts(rows)
+}
+
+// LoadRecentActivity fills in the votes and comments each post received within
+// the window, computed from the vote and comment timestamps
+func (dm *DatabaseManager) LoadRecentActivity(posts []Post, window time.Duration) error {
+	defer dm.span("LoadRecentActivity").End()
+	dm.mu.RLock()
+	defer dm.mu.RUnlock()
+
+	since := fmt.Sprintf("-%d seconds", int(window.Seconds()))
+	votes := make(map[int]int)
+	comments := make(map[int]int)
+
+	rows, err := dm.db.Query(`
+		SELECT target_id, SUM(vote_value)
+		FROM votes
+		WHERE target_type = 'post' AND created_at >= datetime('now', ?)
+		GROUP BY target_id
+	`, since)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var postID, total int
+		if err := rows.Scan(&postID, &total); err != nil {
+			rows.Close()
+			return err
+		}
+		votes[postID] = total
+	}
+	rows.Close()
+
+	rows, err = dm.db.Query(`
+		SELECT post_id, COUNT(*)
+		FROM comments
+		WHERE created_at >= datetime('now', ?)
+		GROUP BY post_id
+	`, since)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var postID, total int
+		if err := rows.Scan(&postID, &total); err != nil {
+			rows.Close()
+			return err
+		}
+		comments[postID] = total
+	}
+	rows.Close()
+
+	for i := range posts {
+		posts[i].activity = postActivity{
+			votes:    votes[posts[i].ID],
+			comments: comments[posts[i].ID],
+		}
+	}
+
+	return nil
+}
+
+// GetSubredditSettings returns a subreddit's settings, falling back to defaults
+// for subreddits that never customized them
+func (dm *DatabaseManager) GetSubredditSettings(subredditID int) (*SubredditSettings, error) {
+	defer dm.span("GetSubredditSettings").End()
+	dm.mu.RLock()
+	defer dm.mu.RUnlock()
+
+	settings := SubredditSettings{SubredditID: subredditID}
+	var allowedPostTypes string
+	err := dm.db.QueryRow(`
+		SELECT COALESCE(ss.default_sort, ?), COALESCE(ss.half_life_hours, ?),
+			COALESCE(ss.collapse_below_score, ?), COALESCE(ss.collapse_negative_karma, 0),
+			COALESCE(ss.max_posts_per_day, 0), COALESCE(ss.allowed_post_types, ?),
+			COALESCE(ss.min_title_length, 0), COALESCE(ss.allow_crossposts, 1), COALESCE(ss.edit_history_mod_only, 0),
+			COALESCE(ss.vote_fuzz_minutes, 0), COALESCE(ss.nsfw, 0)
+		FROM subreddits s
+		LEFT JOIN subreddit_settings ss ON ss.subreddit_id = s.id
+		WHERE s.id = ? AND s.deleted_at IS NULL
+	`, defaultRanking, defaultHalfLifeHours, defaultCollapseBelowScore, strings.Join(postKinds, ","), subredditID).Scan(&settings.DefaultSort,
+		&settings.HalfLifeHours, &settings.CollapseBelowScore, &settings.CollapseNegativeKarma, &settings.MaxPostsPerDay,
+		&allowedPostTypes, &settings.MinTitleLength, &settings.AllowCrossposts, &settings.EditHistoryModOnly, &settings.VoteFuzzMinutes,
+		&settings.NSFW)
+	if err != nil {
+		return nil, fmt.Errorf("subreddit not found: %v", err)
+	}
+	settings.AllowedPostTypes = parsePostKinds(allowedPostTypes)
+
+	return &settings, nil
+}
+
+// UpdateSubredditSettings stores a subreddit's settings
+func (dm *DatabaseManager) UpdateSubredditSettings(settings SubredditSettings) error {
+	defer dm.span("UpdateSubredditSettings").End()
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	_, err := dm.db.Exec(`
+		INSERT INTO subreddit_settings (subreddit_id, default_sort, half_life_hours, collapse_below_score, collapse_negative_karma,
+			max_posts_per_day, allowed_post_types, min_title_length, allow_crossposts, edit_history_mod_only, vote_fuzz_minutes, nsfw)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(subreddit_id) DO UPDATE SET
+			default_sort = excluded.default_sort,
+			half_life_hours = excluded.half_life_hours,
+			collapse_below_score = excluded.collapse_below_score,
+			collapse_negative_karma = excluded.collapse_negative_karma,
+			max_posts_per_day = excluded.max_posts_per_day,
+			allowed_post_types = excluded.allowed_post_types,
+			min_title_length = excluded.min_title_length,
+			allow_crossposts = excluded.allow_crossposts,
+			edit_history_mod_only = excluded.edit_history_mod_only,
+			vote_fuzz_minutes = excluded.vote_fuzz_minutes,
+			nsfw = excluded.nsfw,
+			updated_at = CURRENT_TIMESTAMP
+	`, settings.SubredditID, settings.DefaultSort, settings.HalfLifeHours, settings.CollapseBelowScore, settings.CollapseNegativeKarma,
+		settings.MaxPostsPerDay, strings.Join(settings.AllowedPostTypes, ","), settings.MinTitleLength, settings.AllowCrossposts,
+		settings.EditHistoryModOnly, settings.VoteFuzzMinutes, settings.NSFW)
+	if err != nil {
+		return fmt.Errorf("failed to update subreddit settings: %v", err)
+	}
+
+	return nil
+}
+
+// GetRecentSubredditPosts returns a subreddit's description and its newest
+// visible posts
+func (dm *DatabaseManager) GetRecentSubredditPosts(name string, limit int) (string, []Post, error) {
+	defer dm.span("GetRecentSubredditPosts").End()
+	dm.mu.RLock()
+	defer dm.mu.RUnlock()
+
+	var subredditID int
+	var description string
+	err := dm.db.QueryRow(`
+		SELECT id, COALESCE(description, '') FROM subreddits WHERE name = ? AND deleted_at IS NULL
+	`, name).Scan(&subredditID, &description)
+	if err != nil {
+		return "", nil, fmt.Errorf("subreddit not found: %v", err)
+	}
+
+	rows, err := dm.db.Query(`
+		SELECT `+postColumns+`
+		FROM posts p
+		JOIN users u ON p.author_id = u.id
+		JOIN subreddits s ON p.subreddit_id = s.id
+		WHERE p.subreddit_id = ? AND p.removed = 0 AND p.deleted_at IS NULL
+		ORDER BY p.created_at DESC
+		LIMIT ?
+	`, subredditID, limit)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to get posts: %v", err)
+	}
+	defer rows.Close()
+
+	posts, err := scanPosts(rows)
+	return description, posts, err
+}
+
+// GetRecentUserPosts returns a user's newest visible posts
+func (dm *DatabaseManager) GetRecentUserPosts(username string, limit int) ([]Post, error) {
+	defer dm.span("GetRecentUserPosts").End()
+	dm.mu.RLock()
+	defer dm.mu.RUnlock()
+
+	var userID int
+	if err := dm.db.QueryRow(`SELECT id FROM users WHERE username = ?`, username).Scan(&userID); err != nil {
+		return nil, fmt.Errorf("user not found: %v", err)
+	}
+
+	rows, err := dm.db.Query(`
+		SELECT `+postColumns+`
+		FROM posts p
+		JOIN users u ON p.author_id = u.id
+		JOIN subreddits s ON p.subreddit_id = s.id
+		WHERE p.author_id = ? AND p.removed = 0 AND p.deleted_at IS NULL
+		ORDER BY p.created_at DESC
+		LIMIT ?
+	`, userID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get posts: %v", err)
+	}
+	defer rows.Close()
+
+	return scanPosts(rows)
+}
+
+// GetSubredditIDByName returns the ID of the subreddit with the given name
+func (dm *DatabaseManager) GetSubredditIDByName(name string) (int, error) {
+	defer dm.span("GetSubredditIDByName").End()
+	dm.mu.RLock()
+	defer dm.mu.RUnlock()
+
+	var subredditID int
+	if err := dm.db.QueryRow(`SELECT id FROM subreddits WHERE name = ? AND deleted_at IS NULL`, name).Scan(&subredditID); err != nil {
+		return 0, fmt.Errorf("subreddit not found: %v", err)
+	}
+	return subredditID, nil
+}
+
+// GetPost returns a visible post
+func (dm *DatabaseManager) GetPost(postID int) (*Post, error) {
+	defer dm.span("GetPost").End()
+	dm.mu.RLock()
+	defer dm.mu.RUnlock()
+
+	rows, err := dm.db.Query(`
+		SELECT `+postColumns+`
+		FROM posts p
+		JOIN users u ON p.author_id = u.id
+		JOIN subreddits s ON p.subreddit_id = s.id
+		WHERE p.id = ? AND p.removed = 0 AND p.deleted_at IS NULL
+	`, postID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get post: %v", err)
+	}
+	defer rows.Close()
+
+	posts, err := scanPosts(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(posts) == 0 {
+		return nil, fmt.Errorf("post not found")
+	}
+	return &posts[0], nil
+}
+
+// GetUserByID returns a user by ID
+func (dm *DatabaseManager) GetUserByID(userID int) (*User, error) {
+	defer dm.span("GetUserByID").End()
+	dm.mu.RLock()
+	defer dm.mu.RUnlock()
+
+	var user User
+	err := dm.db.QueryRow(`SELECT id, username, karma FROM users WHERE id = ?`, userID).Scan(&user.ID, &user.Username, &user.Karma)
+	if err != nil {
+		return nil, fmt.Errorf("user not found: %v", err)
+	}
+	return &user, nil
+}
+
+// GetSubreddit returns a subreddit by ID
+func (dm *DatabaseManager) GetSubreddit(subredditID int) (*Subreddit, error) {
+	defer dm.span("GetSubreddit").End()
+	dm.mu.RLock()
+	defer dm.mu.RUnlock()
+
+	var subreddit Subreddit
+	err := dm.db.QueryRow(`SELECT id, name, description, created_at FROM subreddits WHERE id = ? AND deleted_at IS NULL`, subredditID).
+		Scan(&subreddit.ID, &subreddit.Name, &subreddit.Description, &subreddit.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("subreddit not found: %v", err)
+	}
+	return &subreddit, nil
+}
+
+// GetComment returns a visible comment, with viewerID's vote on it
+func (dm *DatabaseManager) GetComment(commentID, viewerID int) (*Comment, error) {
+	defer dm.span("GetComment").End()
+	dm.mu.RLock()
+	defer dm.mu.RUnlock()
+
+	var comment Comment
+	err := dm.db.QueryRow(`
+		SELECT `+commentColumns+`
+		FROM comments c
+		JOIN users u ON c.author_id = u.id
+		`+commentVoteJoin+`
+		WHERE c.id = ? AND c.removed = 0 AND c.deleted_at IS NULL
+	`, viewerID, commentID).Scan(comment.scanFields()...)
+	if err != nil {
+		return nil, fmt.Errorf("comment not found: %v", err)
+	}
+	comments := []Comment{comment}
+	if err := dm.markCollapsed(comments); err != nil {
+		return nil, err
+	}
+	return &comments[0], nil
+}
+
+// updateCommentCount recounts a post's visible comments into its
+// comment_count. Writes that add or remove comments call it in their
+// transaction.
+func updateCommentCount(tx *sql.Tx, postID int) error {
+	_, err := tx.Exec(`
+		UPDATE posts SET comment_count = (SELECT COUNT(*) FROM comments WHERE post_id = ? AND removed = 0 AND deleted_at IS NULL)
+		WHERE id = ?
+	`, postID, postID)
+	if err != nil {
+		return fmt.Errorf("failed to update comment count: %v", err)
+	}
+	return nil
+}
+
+// aboutPinnedPosts is how many pinned posts GetSubredditAbout includes
+const aboutPinnedPosts = 5
+
+// GetSubredditRules returns a subreddit's rules in order
+func (dm *DatabaseManager) GetSubredditRules(subredditID int) ([]SubredditRule, error) {
+	defer dm.span("GetSubredditRules").End()
+	dm.mu.RLock()
+	defer dm.mu.RUnlock()
+	return getSubredditRules(dm.db, subredditID)
+}
+
+func getSubredditRules(db *sql.DB, subredditID int) ([]SubredditRule, error) {
+	rows, err := db.Query(`
+		SELECT title, COALESCE(description, '') FROM subreddit_rules
+		WHERE subreddit_id = ? ORDER BY position
+	`, subredditID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get rules: %v", err)
+	}
+	defer rows.Close()
+
+	rules := []SubredditRule{}
+	for rows.Next() {
+		var rule SubredditRule
+		if err := rows.Scan(&rule.Title, &rule.Description); err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+
+	return rules, rows.Err()
+}
+
+// SetSubredditRules replaces a subreddit's rules
+func (dm *DatabaseManager) SetSubredditRules(subredditID, moderatorID int, rules []SubredditRule) error {
+	defer dm.span("SetSubredditRules").End()
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	tx, err := dm.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`DELETE FROM subreddit_rules WHERE subreddit_id = ?`, subredditID); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to update rules: %v", err)
+	}
+	for i, rule := range rules {
+		_, err := tx.Exec(`
+			INSERT INTO subreddit_rules (subreddit_id, position, title, description)
+			VALUES (?, ?, ?, ?)
+		`, subredditID, i+1, rule.Title, rule.Description)
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to update rules: %v", err)
+		}
+	}
+
+	details := fmt.Sprintf("%d rules", len(rules))
+	if err := logModAction(tx, subredditID, &moderatorID, "update_rules", "subreddit", subredditID, details); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetSubredditAbout gathers everything a client needs to render a
+// subreddit's header: its details, rules, moderators and pinned posts
+func (dm *DatabaseManager) GetSubredditAbout(name string) (*SubredditAbout, error) {
+	defer dm.span("GetSubredditAbout").End()
+	dm.mu.RLock()
+	defer dm.mu.RUnlock()
+
+	var about SubredditAbout
+	err := dm.db.QueryRow(`
+		SELECT s.id, s.name, COALESCE(s.description, ''), s.created_at,
+			   (SELECT COUNT(*) FROM subreddit_members WHERE subreddit_id = s.id),
+			   COALESCE((SELECT username FROM users WHERE id = s.owner_id), ''), s.archived_at
+		FROM subreddits s WHERE s.name = ? AND s.deleted_at IS NULL
+	`, name).Scan(&about.ID, &about.Name, &about.Description, &about.CreatedAt, &about.MemberCount,
+		&about.Owner, &about.ArchivedAt)
+	if err != nil {
+		return nil, fmt.Errorf("subreddit not found: %v", err)
+	}
+
+	if about.Rules, err = getSubredditRules(dm.db, about.ID); err != nil {
+		return nil, err
+	}
+
+	rows, err := dm.db.Query(`
+		SELECT u.username FROM subreddit_moderators m
+		JOIN users u ON m.user_id = u.id
+		WHERE m.subreddit_id = ?
+		ORDER BY m.added_at, u.username
+	`, about.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get moderators: %v", err)
+	}
+	defer rows.Close()
+
+	about.Moderators = []string{}
+	for rows.Next() {
+		var username string
+		if err := rows.Scan(&username); err != nil {
+			return nil, err
+		}
+		about.Moderators = append(about.Moderators, username)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	pinned, err := dm.db.Query(`
+		SELECT `+postColumns+`
+		FROM posts p
+		JOIN users u ON p.author_id = u.id
+		JOIN subreddits s ON p.subreddit_id = s.id
+		WHERE p.subreddit_id = ? AND p.pinned = 1 AND p.removed = 0 AND p.deleted_at IS NULL
+		ORDER BY p.created_at DESC
+		LIMIT ?
+	`, about.ID, aboutPinnedPosts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pinned posts: %v", err)
+	}
+	defer pinned.Close()
+
+	if about.PinnedPosts, err = scanPosts(pinned); err != nil {
+		return nil, err
+	}
+
+	return &about, nil
+}
+
+// GetUserProfile returns a user's profile, falling back to defaults for users
+// that never customized it
+func (dm *DatabaseManager) GetUserProfile(userID int) (*UserProfile, error) {
+	defer dm.span("GetUserProfile").End()
+	dm.mu.RLock()
+	defer dm.mu.RUnlock()
+
+	profile := UserProfile{UserID: userID}
+	err := dm.db.QueryRow(`
+		SELECT COALESCE(up.bio, ''), COALESCE(up.avatar_url, ''),
+			   COALESCE(up.show_nsfw, 0), COALESCE(up.default_feed_sort, '')
+		FROM users u
+		LEFT JOIN user_profiles up ON up.user_id = u.id
+		WHERE u.id = ?
+	`, userID).Scan(&profile.Bio, &profile.AvatarURL, &profile.ShowNSFW, &profile.DefaultFeedSort)
+	if err != nil {
+		return nil, fmt.Errorf("user not found: %v", err)
+	}
+
+	if profile.MutedKeywords, err = getMutedWords(dm.db, userID, mutedKeyword); err != nil {
+		return nil, err
+	}
+	if profile.MutedDomains, err = getMutedWords(dm.db, userID, mutedDomain); err != nil {
+		return nil, err
+	}
+
+	return &profile, nil
+}
+
+// UpdateUserProfile stores a user's profile, along with their muted words
+func (dm *DatabaseManager) UpdateUserProfile(profile UserProfile) error {
+	defer dm.span("UpdateUserProfile").End()
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	tx, err := dm.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO user_profiles (user_id, bio, avatar_url, show_nsfw, default_feed_sort)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(user_id) DO UPDATE SET
+			bio = excluded.bio,
+			avatar_url = excluded.avatar_url,
+			show_nsfw = excluded.show_nsfw,
+			default_feed_sort = excluded.default_feed_sort,
+			updated_at = CURRENT_TIMESTAMP
+	`, profile.UserID, profile.Bio, profile.AvatarURL, profile.ShowNSFW, profile.DefaultFeedSort)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to update user profile: %v", err)
+	}
+
+	if err := setMutedWords(tx, profile.UserID, mutedKeyword, profile.MutedKeywords); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := setMutedWords(tx, profile.UserID, mutedDomain, profile.MutedDomains); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Thread integrity problems found by RepairCommentThreads
+const (
+	threadMissingParent = "missing_parent"       // parent_comment_id points to no comment
+	threadCrossPost     = "parent_on_other_post" // the parent comment belongs to another post
+	threadMissingPost   = "missing_post"         // the comment's post no longer exists
+)
+
+// ThreadIssue is a comment whose place in its thread is inconsistent
+type ThreadIssue struct {
+	CommentID       int    `json:"comment_id"`
+	PostID          int    `json:"post_id"`
+	ParentCommentID *int   `json:"parent_comment_id"`
+	Problem         string `json:"problem"`
+	Fix             string `json:"fix"` // reparented, flagged, or none
+}
+
+// ThreadRepairReport lists what RepairCommentThreads found and did
+type ThreadRepairReport struct {
+	Mode    string        `json:"mode"`
+	DryRun  bool          `json:"dry_run"`
+	Checked int           `json:"checked"`
+	Fixed   int           `json:"fixed"`
+	Issues  []ThreadIssue `json:"issues"`
+}
+
+// RepairCommentThreads finds comments whose parent is missing or belongs to a
+// different post. With mode "reparent" they become top-level comments on their
+// own post; with mode "flag" they are added to the subreddit's mod queue for a
+// moderator to deal with. Comments whose post is missing can't be fixed either
+// way and are only reported. A dry run changes nothing.
+func (dm *DatabaseManager) RepairCommentThreads(mode string, dryRun bool) (*ThreadRepairReport, error) {
+	defer dm.span("RepairCommentThreads").End()
+	if mode != "reparent" && mode != "flag" {
+		return nil, fmt.Errorf("unknown repair mode %q", mode)
+	}
+
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	tx, err := dm.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	report := &ThreadRepairReport{Mode: mode, DryRun: dryRun, Issues: []ThreadIssue{}}
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM comments`).Scan(&report.Checked); err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.Query(`
+		SELECT c.id, c.post_id, c.parent_comment_id,
+			   CASE
+				   WHEN p.id IS NULL THEN ?
+				   WHEN c.parent_comment_id IS NOT NULL AND parent.id IS NULL THEN ?
+				   ELSE ?
+			   END,
+			   COALESCE(p.subreddit_id, 0)
+		FROM comments c
+		LEFT JOIN posts p ON c.post_id = p.id
+		LEFT JOIN comments parent ON c.parent_comment_id = parent.id
+		WHERE p.id IS NULL
+		   OR (c.parent_comment_id IS NOT NULL AND (parent.id IS NULL OR parent.post_id != c.post_id))
+		ORDER BY c.id
+	`, threadMissingPost, threadMissingParent, threadCrossPost)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check comment threads: %v", err)
+	}
+
+	var subreddits []int
+	for rows.Next() {
+		var issue ThreadIssue
+		var subredditID int
+		if err := rows.Scan(&issue.CommentID, &issue.PostID, &issue.ParentCommentID, &issue.Problem, &subredditID); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		issue.Fix = "none"
+		report.Issues = append(report.Issues, issue)
+		subreddits = append(subreddits, subredditID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range report.Issues {
+		issue := &report.Issues[i]
+		if issue.Problem == threadMissingPost {
+			continue
+		}
+
+		if mode == "reparent" {
+			issue.Fix = "reparented"
+			if !dryRun {
+				_, err = tx.Exec(`UPDATE comments SET parent_comment_id = NULL WHERE id = ?`, issue.CommentID)
+			}
+		} else {
+			issue.Fix = "flagged"
+			if !dryRun {
+				reason := "thread integrity: " + issue.Problem
+				_, err = tx.Exec(`
+					INSERT INTO mod_queue (subreddit_id, target_type, target_id, reason, source)
+					SELECT ?, 'comment', ?, ?, 'integrity'
+					WHERE NOT EXISTS (
+						SELECT 1 FROM mod_queue
+						WHERE target_type = 'comment' AND target_id = ? AND source = 'integrity' AND resolved_at IS NULL
+					)
+				`, subreddits[i], issue.CommentID, reason, issue.CommentID)
+				if err == nil {
+					err = logModAction(tx, subreddits[i], nil, "flag_comment", "comment", issue.CommentID, reason)
+				}
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to repair comment %d: %v", issue.CommentID, err)
+		}
+		report.Fixed++
+	}
+
+	if dryRun {
+		return report, nil
+	}
+	return report, tx.Commit()
+}
+
+// MaintenanceReport is the outcome of a database maintenance run
+type MaintenanceReport struct {
+	StartedAt       time.Time `json:"started_at"`
+	DurationMs      int64     `json:"duration_ms"`
+	IntegrityOK     bool      `json:"integrity_ok"`
+	IntegrityErrors []string  `json:"integrity_errors,omitempty"`
+	FreePagesBefore int       `json:"free_pages_before"`
+	FreePagesAfter  int       `json:"free_pages_after"`
+	Vacuumed        bool      `json:"vacuumed"`
+	VacuumSkipped   string    `json:"vacuum_skipped,omitempty"`
+	Analyzed        bool      `json:"analyzed"`
+	Error           string    `json:"error,omitempty"`
+}
+
+// RunMaintenance checks database integrity, reclaims free pages with an
+// incremental vacuum and refreshes query planner statistics. Writes are
+// blocked while it runs.
+func (dm *DatabaseManager) RunMaintenance() (*MaintenanceReport, error) {
+	defer dm.span("RunMaintenance").End()
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	report := &MaintenanceReport{StartedAt: time.Now()}
+	defer func() { report.DurationMs = time.Since(report.StartedAt).Milliseconds() }()
+
+	rows, err := dm.db.Query(`PRAGMA integrity_check`)
+	if err != nil {
+		return report, fmt.Errorf("integrity check failed: %v", err)
+	}
+	for rows.Next() {
+		var result string
+		if err := rows.Scan(&result); err != nil {
+			rows.Close()
+			return report, err
+		}
+		if result != "ok" {
+			report.IntegrityErrors = append(report.IntegrityErrors, result)
+		}
+	}
+	rows.Close()
+	report.IntegrityOK = len(report.IntegrityErrors) == 0
+
+	if err := dm.db.QueryRow(`PRAGMA freelist_count`).Scan(&report.FreePagesBefore); err != nil {
+		return report, err
+	}
+
+	var autoVacuum int
+	if err := dm.db.QueryRow(`PRAGMA auto_vacuum`).Scan(&autoVacuum); err != nil {
+		return report, err
+	}
+	if autoVacuum == 2 { // INCREMENTAL
+		if _, err := dm.db.Exec(`PRAGMA incremental_vacuum`); err != nil {
+			return report, fmt.Errorf("incremental vacuum failed: %v", err)
+		}
+		report.Vacuumed = true
+	} else {
+		report.VacuumSkipped = "auto_vacuum is not INCREMENTAL for this database"
+	}
+
+	if err := dm.db.QueryRow(`PRAGMA freelist_count`).Scan(&report.FreePagesAfter); err != nil {
+		return report, err
+	}
+
+	if _, err := dm.db.Exec(`ANALYZE`); err != nil {
+		return report, fmt.Errorf("analyze failed: %v", err)
+	}
+	report.Analyzed = true
+
+	return report, nil
+}
+
+// Ping checks the database connection is usable
+func (dm *DatabaseManager) Ping() error {
+	defer dm.span("Ping").End()
+	return dm.db.Ping()
+}
+
+// Function to clear the database after all simulation operations are done.
+// The admin audit log is kept, and records the reset.
+func (dm *DatabaseManager) ResetDatabase(userID int, reason string) error {
+	defer dm.span("ResetDatabase").End()
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	tables := []string{
+		"site_stats",
+		"user_activity",
+		"mirrored_comments",
+		"mirrored_posts",
+		"subreddit_mirrors",
+		"email_queue",
+		"user_emails",
+		"notification_preferences",
+		"subreddit_rules",
+		"chat_messages",
+		"chat_room_members",
+		"chat_rooms",
+		"mod_webhook_deliveries",
+		"mod_webhooks",
+		"mentions",
+		"notifications",
+		"user_profiles",
+		"muted_words",
+		"vote_nonces",
+		"user_beta_optins",
+		"sessions",
+		"mod_log",
+		"revisions",
+		"subreddit_bans",
+		"subreddit_settings",
+		"mod_queue",
+		"automod_rules",
+		"subreddit_moderators",
+		"trending_topic_posts",
+		"trending_topics",
+		"subreddit_recommendations",
+		"direct_messages",
+		"awards",
+		"user_trophies",
+		"post_insights",
+		"votes",
+		"comments",
+		"posts",
+		"subreddit_members",
+		"subreddits",
+		"users",
+	}
+
+	tx, err := dm.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	// Delete all rows from tables
+	for _, table := range tables {
+		_, err = tx.Exec(fmt.Sprintf("DELETE FROM %s", table))
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to delete from %s: %v", table, err)
+		}
+	}
+
+	for _, table := range tables {
+		_, err = tx.Exec(fmt.Sprintf("DELETE FROM sqlite_sequence WHERE name='%s'", table))
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to reset auto-increment for %s: %v", table, err)
+		}
+	}
+
+	if err := logAdminAction(tx, userID, "reset_database", "database", nil, reason, ""); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// API handlers
+func (h *APIHandler) getTopPosts(c *gin.Context) {
+	limit := 5 // Default to top 5 posts
+	if limitParam := c.Query("limit"); limitParam != "" {
+		if parsedLimit, err := strconv.Atoi(limitParam); err == nil && parsedLimit > 0 {
+			limit = parsedLimit
+		}
+	}
+
+	userID, _ := strconv.Atoi(c.GetString("user_id"))
+	posts, err := h.dbFor(c).GetTopPosts(userID, limit)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, posts)
+}
+
+// getSubredditTopPosts returns a subreddit's highest scoring posts made in
+// the ?t= timeframe (hour, day, week, month, year or all; day by default)
+func (h *APIHandler) getSubredditTopPosts(c *gin.Context) {
+	subredditID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.Error(newAPIError(http.StatusBadRequest, "Invalid subreddit ID"))
+		return
+	}
+
+	timeframe := c.DefaultQuery("t", defaultTopTimeframe)
+	window, ok := topTimeframes[timeframe]
+	if !ok {
+		c.Error(newAPIError(http.StatusBadRequest, "t must be one of hour, day, week, month, year or all"))
+		return
+	}
+
+	if _, err := h.dbFor(c).GetSubreddit(subredditID); err != nil {
+		c.Error(newAPIError(http.StatusNotFound, "Subreddit not found"))
+		return
+	}
+
+	limit, offset := parsePagination(c)
+	posts, err := h.dbFor(c).GetSubredditTopPosts(subredditID, window, limit+1, offset)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, newPostPage(posts, limit, offset))
+}
+
+// getTopComments returns the highest scoring comments made in the ?t=
+// timeframe (hour, day, week, month, year or all; day by default), site-wide
+// or in the subreddit named by ?subreddit=
+func (h *APIHandler) getTopComments(c *gin.Context) {
+	timeframe := c.DefaultQuery("t", defaultTopTimeframe)
+	window, ok := topTimeframes[timeframe]
+	if !ok {
+		c.Error(newAPIError(http.StatusBadRequest, "t must be one of hour, day, week, month, year or all"))
+		return
+	}
+
+	subredditID := 0
+	if name := c.Query("subreddit"); name != "" {
+		var err error
+		if subredditID, err = h.dbFor(c).GetSubredditIDByName(name); err != nil {
+			c.Error(err)
+			return
+		}
+	}
+
+	userID, _ := strconv.Atoi(c.GetString("user_id"))
+	limit, offset := parsePagination(c)
+	comments, err := h.dbFor(c).GetTopComments(subredditID, userID, window, limit, offset)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"t":        timeframe,
+		"comments": comments,
+		"limit":    limit,
+		"offset":   offset,
+	})
+}
+
+func (h *APIHandler) getTrendingTopics(c *gin.Context) {
+	limit := 10 // Default to top 10 topics
+	if limitParam := c.Query("limit"); limitParam != "" {
+		if parsedLimit, err := strconv.Atoi(limitParam); err == nil && parsedLimit > 0 {
+			limit = parsedLimit
+		}
+	}
+
+	topics, err := h.dbFor(c).GetTrendingTopics(limit)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, topics)
+}
+
+// runMaintenance runs database maintenance and records the result for the
+// health and metrics endpoints
+func (h *APIHandler) runMaintenance() (*MaintenanceReport, error) {
+	h.maintenanceMu.Lock()
+	defer h.maintenanceMu.Unlock()
+
+	report, err := h.db.RunMaintenance()
+	if err != nil {
+		report.Error = err.Error()
+		h.metrics.Inc("goreddit_maintenance_failures_total")
+	}
+	h.metrics.Inc("goreddit_maintenance_runs_total")
+	h.metrics.Set("goreddit_maintenance_last_run_timestamp_seconds", float64(report.StartedAt.Unix()))
+	h.metrics.Set("goreddit_maintenance_last_duration_seconds", float64(report.DurationMs)/1000)
+	h.metrics.Set("goreddit_db_free_pages", float64(report.FreePagesAfter))
+	integrityOK := 0.0
+	if report.IntegrityOK {
+		integrityOK = 1
+	}
+	h.metrics.Set("goreddit_db_integrity_ok", integrityOK)
+
+	h.lastMaintenance = report
+	return report, err
+}
+
+// triggerMaintenance lets admins run database maintenance on demand
+func (h *APIHandler) triggerMaintenance(c *gin.Context) {
+	report, err := h.runMaintenance()
+	if err != nil {
+		c.Error(withReport(err, report))
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+// repairCommentThreads lets admins run the comment thread repair, with
+// ?mode=reparent|flag (default reparent) and ?dry_run=true
+func (h *APIHandler) repairCommentThreads(c *gin.Context) {
+	dryRun, _ := strconv.ParseBool(c.Query("dry_run"))
+	report, err := h.dbFor(c).RepairCommentThreads(c.DefaultQuery("mode", "reparent"), dryRun)
+	if err != nil {
+		c.Error(withStatus(http.StatusBadRequest, err))
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+// Bulk vote ingestion limits
+const (
+	bulkVoteBatchSize = 1000 // votes per transaction
+	maxBulkVoteErrors = 1000 // per-line errors included in the response
+)
+
+// BulkVoteError reports why one line of a bulk vote stream was rejected
+type BulkVoteError struct {
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+// BulkVoteReport summarizes a bulk vote ingestion
+type BulkVoteReport struct {
+	Lines           int             `json:"lines"`
+	Accepted        int             `json:"accepted"`
+	Rejected        int             `json:"rejected"`
+	Errors          []BulkVoteError `json:"errors"`
+	ErrorsTruncated bool            `json:"errors_truncated"`
+}
+
+func (r *BulkVoteReport) reject(line int, err error) {
+	r.Rejected++
+	if len(r.Errors) < maxBulkVoteErrors {
+		r.Errors = append(r.Errors, BulkVoteError{Line: line, Error: err.Error()})
+	} else {
+		r.ErrorsTruncated = true
+	}
+}
+
+// parseBulkVote decodes and validates one line of a bulk vote stream
+func parseBulkVote(line []byte) (BulkVote, error) {
+	var v BulkVote
+	if err := json.Unmarshal(line, &v); err != nil {
+		return v, fmt.Errorf("invalid JSON: %v", err)
+	}
+	switch {
+	case v.UserID <= 0:
+		return v, fmt.Errorf("user_id is required")
+	case v.TargetID <= 0:
+		return v, fmt.Errorf("target_id is required")
+	case v.TargetType != "post" && v.TargetType != "comment":
+		return v, fmt.Errorf("target_type must be post or comment")
+	case v.Value != 1 && v.Value != -1:
+		return v, fmt.Errorf("value must be 1 or -1")
+	}
+	return v, nil
+}
+
+// bulkVotes ingests an NDJSON stream of votes for simulations, one
+// {"user_id", "target_id", "target_type", "value"} object per line. Votes are
+// recorded in batched transactions as the stream is read, and each rejected
+// line is reported by its line number.
+func (h *APIHandler) bulkVotes(c *gin.Context) {
+	report := BulkVoteReport{Errors: []BulkVoteError{}}
+	batch := make([]BulkVote, 0, bulkVoteBatchSize)
+	lines := make([]int, 0, bulkVoteBatchSize)
+
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		results, err := h.dbFor(c).ApplyVoteBatch(batch)
+		if err != nil {
+			return err
+		}
+		for i, err := range results {
+			if err != nil {
+				report.reject(lines[i], err)
+			} else {
+				report.Accepted++
+			}
+		}
+		batch, lines = batch[:0], lines[:0]
+		return nil
+	}
+
+	scanner := bufio.NewScanner(c.Request.Body)
+	for scanner.Scan() {
+		report.Lines++
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		vote, err := parseBulkVote(line)
+		if err != nil {
+			report.reject(report.Lines, err)
+			continue
+		}
+		batch = append(batch, vote)
+		lines = append(lines, report.Lines)
+
+		if len(batch) == bulkVoteBatchSize {
+			if err := flush(); err != nil {
+				c.Error(withReport(err, report))
+				return
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		// Votes read before the stream broke are still recorded
+		if flushErr := flush(); flushErr != nil {
+			err = flushErr
+		}
+		c.Error(withReport(withStatus(http.StatusBadRequest, fmt.Errorf("failed to read stream: %v", err)), report))
+		return
+	}
+	if err := flush(); err != nil {
+		c.Error(withReport(err, report))
+		return
+	}
+
+	h.metrics.Add(`goreddit_bulk_votes_total{result="accepted"}`, float64(report.Accepted))
+	h.metrics.Add(`goreddit_bulk_votes_total{result="rejected"}`, float64(report.Rejected))
+	c.JSON(http.StatusOK, report)
+}
+
+// health reports whether the database is reachable and the result of the last
+// maintenance run. The status is degraded when integrity problems were found.
+func (h *APIHandler) health(c *gin.Context) {
+	h.maintenanceMu.Lock()
+	last := h.lastMaintenance
+	h.maintenanceMu.Unlock()
+
+	status := "ok"
+	database := "ok"
+	if err := h.dbFor(c).Ping(); err != nil {
+		logAt(logError, "Health check failed to reach the database: %v", err)
+		status = "unavailable"
+		database = "unreachable"
+	} else if last != nil && !last.IntegrityOK {
+		status = "degraded"
+	}
+
+	code := http.StatusOK
+	if status == "unavailable" {
+		code = http.StatusServiceUnavailable
+	}
+
+	response := gin.H{
+		"status":           status,
+		"version":          buildinfo.Version,
+		"database":         database,
+		"last_maintenance": last,
+	}
+	if h.standby != nil {
+		response["standby"] = h.standby.Status()
+	}
+
+	c.JSON(code, response)
+}
+
+// shipSnapshot ships a database snapshot to the standby and records the
+// outcome in the metrics
+func (h *APIHandler) shipSnapshot() (string, error) {
+	name, err := h.standby.Ship(h.db)
+	if err != nil {
+		log.Printf("Standby snapshot failed: %v", err)
+		h.metrics.Inc("goreddit_standby_snapshot_failures_total")
+		return "", err
+	}
+	h.metrics.Inc("goreddit_standby_snapshots_total")
+	h.metrics.Set("goreddit_standby_last_snapshot_timestamp_seconds", float64(time.Now().Unix()))
+	return name, nil
+}
+
+// triggerSnapshot lets admins ship a standby snapshot on demand, e.g. before
+// a risky migration
+func (h *APIHandler) triggerSnapshot(c *gin.Context) {
+	if h.standby == nil {
+		c.Error(newAPIError(http.StatusConflict, "Standby replication is not configured"))
+		return
+	}
+
+	name, err := h.shipSnapshot()
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"snapshot": name, "standby": h.standby.Status()})
+}
+
+// metricsHandler serves metrics in the Prometheus text format
+func (h *APIHandler) metricsHandler(c *gin.Context) {
+	var buf bytes.Buffer
+	h.metrics.WriteTo(&buf)
+	c.Data(http.StatusOK, "text/plain; version=0.0.4", buf.Bytes())
+}
+
+func (h *APIHandler) resetDatabase(c *gin.Context) {
+	userID, _ := strconv.Atoi(c.GetString("user_id"))
+	err := h.dbFor(c).ResetDatabase(userID, c.GetHeader(adminAuditReasonHeader))
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Database reset successfully"})
+}
+
+func (h *APIHandler) registerUser(c *gin.Context) {
+	var req RegisterUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(withStatus(http.StatusBadRequest, err))
+		return
+	}
+
+	if h.captcha != nil {
+		err := h.captcha.Verify(req.CaptchaToken, c.ClientIP())
+		if errors.Is(err, ErrCaptchaFailed) {
+			c.Error(withStatus(http.StatusBadRequest, err))
+			return
+		}
+		if err != nil {
+			log.Printf("CAPTCHA verification failed: %v", err)
+			c.Error(newAPIError(http.StatusBadGateway, "CAPTCHA could not be verified, try again later"))
+			return
+		}
+	}
+
+	userID, err := h.dbFor(c).RegisterUser(req.Username, req.Password)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	if req.Email != "" {
+		if err := h.queueVerificationEmail(userID, req.Email); err != nil {
+			c.Error(err)
+			return
+		}
+	}
+
+	// Registration has already succeeded, so failing to suggest subreddits
+	// only leaves the suggestions out
+	suggestions, err := h.onboardingSubreddits(userID)
+	if err != nil {
+		log.Printf("Failed to get onboarding subreddits: %v", err)
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"user_id":              userID,
+		"username":             req.Username,
+		"suggested_subreddits": suggestions,
+	})
+}
+
+// login authenticates a user and starts a new session
+func (h *APIHandler) login(c *gin.Context) {
+	var req LoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(withStatus(http.StatusBadRequest, err))
+		return
+	}
+
+	userID, err := h.dbFor(c).AuthenticateUser(req.Username, req.Password)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	sessionID, token, err := h.dbFor(c).CreateSession(userID, c.ClientIP(), c.GetHeader("User-Agent"))
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"user_id":    userID,
+		"username":   req.Username,
+		"session_id": sessionID,
+		"token":      token,
+	})
+}
+
+type ImpersonateRequest struct {
+	Reason          string `json:"reason" binding:"required"`
+	Scope           string `json:"scope" binding:"omitempty,oneof=read write"`
+	DurationMinutes int    `json:"duration_minutes" binding:"omitempty,min=1"`
+}
+
+// impersonateUser issues an admin a short-lived token to act as a user while
+// debugging a reported problem. Tokens are read-only unless scope is "write",
+// and other admins can't be impersonated.
+func (h *APIHandler) impersonateUser(c *gin.Context) {
+	var req ImpersonateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(withStatus(http.StatusBadRequest, err))
+		return
+	}
+
+	userID, err := strconv.Atoi(c.Param("user_id"))
+	if err != nil {
+		c.Error(newAPIError(http.StatusBadRequest, "Invalid user ID"))
+		return
+	}
+	if h.admins[userID] {
+		c.Error(newAPIError(http.StatusForbidden, "Admins can't be impersonated"))
+		return
+	}
+	if _, err := h.dbFor(c).GetUserProfile(userID); err != nil {
+		c.Error(newAPIError(http.StatusNotFound, "User not found"))
+		return
+	}
+
+	scope := req.Scope
+	if scope == "" {
+		scope = impersonationRead
+	}
+	minutes := req.DurationMinutes
+	if minutes == 0 {
+		minutes = defaultImpersonationMinutes
+	}
+	if minutes > maxImpersonationMinutes {
+		minutes = maxImpersonationMinutes
+	}
+
+	adminID, _ := strconv.Atoi(c.GetString("user_id"))
+	sessionID, token, expiresAt, err := h.dbFor(c).CreateImpersonationSession(adminID, userID, scope, minutes,
+		req.Reason, c.ClientIP(), c.GetHeader("User-Agent"))
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"user_id":    userID,
+		"session_id": sessionID,
+		"token":      token,
+		"scope":      scope,
+		"expires_at": expiresAt,
+	})
+}
+
+// logout revokes the session used to make the request
+func (h *APIHandler) logout(c *gin.Context) {
+	sessionID := c.GetString("session_id")
+	if sessionID == "" {
+		c.Error(newAPIError(http.StatusBadRequest, "Request was not made with a session token"))
+		return
+	}
+
+	userID, _ := strconv.Atoi(c.GetString("user_id"))
+	if err := h.dbFor(c).RevokeSession(userID, sessionID); err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out"})
+}
+
+// getSessions lists the requesting user's login history
+func (h *APIHandler) getSessions(c *gin.Context) {
+	userID, _ := strconv.Atoi(c.GetString("user_id"))
+	sessions, err := h.dbFor(c).GetUserSessions(userID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	current := c.GetString("session_id")
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == current
+	}
+
+	c.JSON(http.StatusOK, sessions)
+}
+
+// revokeSession remotely revokes one of the requesting user's sessions
+func (h *APIHandler) revokeSession(c *gin.Context) {
+	userID, _ := strconv.Atoi(c.GetString("user_id"))
+	if err := h.dbFor(c).RevokeSession(userID, c.Param("session_id")); err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Session revoked"})
+}
+
+// getBetas lists the beta features currently available and whether the user opted in
+func (h *APIHandler) getBetas(c *gin.Context) {
+	userID, _ := strconv.Atoi(c.GetString("user_id"))
+	optIns, err := h.dbFor(c).GetBetaOptIns(userID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	betas := []BetaFeature{}
+	for _, flag := range h.flags.Betas() {
+		betas = append(betas, BetaFeature{FeatureFlag: flag, OptedIn: optIns[flag.Name]})
+	}
+
+	c.JSON(http.StatusOK, betas)
+}
+
+// setBetaOptIn opts the user into (POST) or out of (DELETE) a beta feature
+func (h *APIHandler) setBetaOptIn(enabled bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		name := c.Param("name")
+		flag, ok := h.flags.Get(name)
+		if enabled && (!ok || flag.Stage != featureBeta) {
+			c.Error(newAPIError(http.StatusNotFound, "Beta feature not found"))
+			return
+		}
+
+		userID, _ := strconv.Atoi(c.GetString("user_id"))
+		if err := h.dbFor(c).SetBetaOptIn(userID, name, enabled); err != nil {
+			c.Error(err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"feature": name, "opted_in": enabled})
+	}
+}
+
+func (h *APIHandler) getUserByUsername(c *gin.Context) {
+	username := c.Param("username")
+	user, err := h.dbFor(c).GetUserByUsername(username)
+	if err != nil {
+		c.Error(newAPIError(http.StatusNotFound, "User not found"))
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+func (h *APIHandler) getFeed(c *gin.Context) {
+	userID, _ := strconv.Atoi(c.GetString("user_id"))
+	h.serveFeed(c, userID, h.dbFor(c).GetFeed)
+}
+
+// getFollowingFeed lists posts by the users the current user subscribes to,
+// sorted and paged the same way as the home feed
+func (h *APIHandler) getFollowingFeed(c *gin.Context) {
+	userID, _ := strconv.Atoi(c.GetString("user_id"))
+	h.serveFeed(c, userID, h.dbFor(c).GetFollowingFeed)
+}
+
+// serveFeed responds with a personal feed read by load. Feeds are newest
+// first unless another ranking is requested, either in the query or as the
+// user's default, and are paged with ?limit= and ?offset=, or returned whole
+// when neither is given. Personal feeds stay a plain list of posts either
+// way. Newest-first feeds are paged by the query, while ranked ones have to
+// be read whole to be ranked.
+func (h *APIHandler) serveFeed(c *gin.Context, userID int, load func(userID, limit, offset int) ([]Post, error)) {
+	sortBy := c.Query("sort")
+	if sortBy == "" {
+		sortBy = defaultFeedSort(h.dbFor(c), userID)
+	}
+	limit, offset := allPosts, 0
+	if c.Query("limit") != "" || c.Query("offset") != "" {
+		limit, offset = parsePagination(c)
+	}
+
+	if newestFirst(sortBy) {
+		posts, err := load(userID, limit, offset)
+		if err != nil {
+			c.Error(err)
+			return
+		}
+		c.JSON(http.StatusOK, posts)
+		return
+	}
+
+	posts, err := load(userID, allPosts, 0)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	params := RankingParams{HalfLifeHours: defaultHalfLifeHours}
+	if err := h.rankPosts(posts, sortBy, params); err != nil {
+		c.Error(withStatus(http.StatusBadRequest, err))
+		return
+	}
+	if limit != allPosts {
+		posts = paginatePosts(posts, limit, offset).Posts
+	}
+
+	c.JSON(http.StatusOK, posts)
+}
+
+// defaultFeedSort returns the sort the user chose for their feed, or "" for
+// newest first. A feed isn't worth failing over its sort, so when the
+// profile can't be loaded the feed falls back to the default.
+func defaultFeedSort(db *DatabaseManager, userID int) string {
+	profile, err := db.GetUserProfile(userID)
+	if err != nil {
+		logAt(logWarn, "Failed to get the feed sort of user %d, using the default: %v", userID, err)
+		return ""
+	}
+	return profile.DefaultFeedSort
+}
+
+// getAllFeed lists posts across every subreddit, ranked by ?sort= (hot by default)
+func (h *APIHandler) getAllFeed(c *gin.Context) {
+	userID, _ := strconv.Atoi(c.GetString("user_id"))
+	posts, err := h.dbFor(c).GetAllPosts(userID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	params := RankingParams{HalfLifeHours: defaultHalfLifeHours}
+	if err := h.rankPosts(posts, c.DefaultQuery("sort", defaultRanking), params); err != nil {
+		c.Error(withStatus(http.StatusBadRequest, err))
+		return
+	}
+
+	limit, offset := parsePagination(c)
+	c.JSON(http.StatusOK, paginatePosts(posts, limit, offset))
+}
+
+// popularSubredditCap limits how many posts one subreddit contributes to /popular
+const popularSubredditCap = 5
+
+// getPopularFeed lists the hottest posts site-wide, capping each subreddit's
+// share so a single busy community can't take over the listing
+func (h *APIHandler) getPopularFeed(c *gin.Context) {
+	userID, _ := strconv.Atoi(c.GetString("user_id"))
+	posts, err := h.dbFor(c).GetAllPosts(userID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	params := RankingParams{HalfLifeHours: defaultHalfLifeHours}
+	if err := h.rankPosts(posts, "hot", params); err != nil {
+		c.Error(err)
+		return
+	}
+
+	perSubreddit := make(map[int]int)
+	popular := posts[:0]
+	for _, post := range posts {
+		if perSubreddit[post.SubredditID] >= popularSubredditCap {
+			continue
+		}
+		perSubreddit[post.SubredditID]++
+		popular = append(popular, post)
+	}
+
+	limit, offset := parsePagination(c)
+	c.JSON(http.StatusOK, paginatePosts(popular, limit, offset))
+}
+
+// getConversations lists the current user's conversations, most recently
+// active first
+func (h *APIHandler) getConversations(c *gin.Context) {
+	userID, _ := strconv.Atoi(c.GetString("user_id"))
+	conversations, err := h.dbFor(c).GetConversations(userID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, conversations)
+}
+
+// getDirectMessageThread returns the full conversation with another user,
+// oldest first. Reading it doesn't mark it read, which clients do with
+// POST /messages/with/:user_id/read.
+func (h *APIHandler) getDirectMessageThread(c *gin.Context) {
+	userID, _ := strconv.Atoi(c.GetString("user_id"))
+	otherUserID, err := strconv.Atoi(c.Param("user_id"))
+	if err != nil {
+		c.Error(newAPIError(http.StatusBadRequest, "Invalid user ID"))
+		return
+	}
+
+	messages, err := h.dbFor(c).GetDirectMessageThread(userID, otherUserID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, messages)
+}
+
+// markDirectMessageRead marks a received message as read
+func (h *APIHandler) markDirectMessageRead(c *gin.Context) {
+	userID, _ := strconv.Atoi(c.GetString("user_id"))
+	messageID, err := strconv.Atoi(c.Param("message_id"))
+	if err != nil {
+		c.Error(newAPIError(http.StatusBadRequest, "Invalid message ID"))
+		return
+	}
+
+	if err := h.dbFor(c).MarkDirectMessageRead(userID, messageID); err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Message marked as read"})
+}
+
+// markThreadRead marks every message received from a user as read
+func (h *APIHandler) markThreadRead(c *gin.Context) {
+	userID, _ := strconv.Atoi(c.GetString("user_id"))
+	otherUserID, err := strconv.Atoi(c.Param("user_id"))
+	if err != nil {
+		c.Error(newAPIError(http.StatusBadRequest, "Invalid user ID"))
+		return
+	}
+
+	marked, err := h.dbFor(c).MarkThreadRead(userID, otherUserID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"marked": marked})
+}
+
+// deleteDirectMessage hides a message for the current user only
+func (h *APIHandler) deleteDirectMessage(c *gin.Context) {
+	userID, _ := strconv.Atoi(c.GetString("user_id"))
+	messageID, err := strconv.Atoi(c.Param("message_id"))
+	if err != nil {
+		c.Error(newAPIError(http.StatusBadRequest, "Invalid message ID"))
+		return
+	}
+
+	if err := h.dbFor(c).DeleteDirectMessage(userID, messageID); err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Message deleted"})
+}
+
+// getUnreadCounts returns the current user's unread message and notification
+// counts, for rendering badges
+func (h *APIHandler) getUnreadCounts(c *gin.Context) {
+	userID, _ := strconv.Atoi(c.GetString("user_id"))
+	counts, err := h.dbFor(c).GetUnreadCounts(userID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, counts)
+}
+
+// EditContentRequest carries the new content of a post or comment
+type EditContentRequest struct {
+	Content string `json:"content" binding:"required"`
+}
+
+// editGracePeriod returns how long after creation edits don't mark content
+// as edited
+func (h *APIHandler) editGracePeriod() time.Duration {
+	h.configMu.Lock()
+	defer h.configMu.Unlock()
+	return time.Duration(h.config.EditGraceSeconds) * time.Second
+}
+
+// editPost lets the author change a post's content
+func (h *APIHandler) editPost(c *gin.Context) {
+	postID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.Error(newAPIError(http.StatusBadRequest, "Invalid post ID"))
+		return
+	}
+
+	var req EditContentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(withStatus(http.StatusBadRequest, err))
+		return
+	}
+
+	userID, _ := strconv.Atoi(c.GetString("user_id"))
+	post, err := h.dbFor(c).EditPost(postID, userID, req.Content, h.editGracePeriod())
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, post)
+}
+
+// editComment lets the author change a comment's content
+func (h *APIHandler) editComment(c *gin.Context) {
+	commentID, err := strconv.Atoi(c.Param("comment_id"))
+	if err != nil {
+		c.Error(newAPIError(http.StatusBadRequest, "Invalid comment ID"))
+		return
+	}
+
+	var req EditContentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(withStatus(http.StatusBadRequest, err))
+		return
+	}
+
+	userID, _ := strconv.Atoi(c.GetString("user_id"))
+	comment, err := h.dbFor(c).EditComment(commentID, userID, req.Content, h.editGracePeriod())
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, comment)
+}
+
+// chatRoomID parses the :room_id path parameter
+func chatRoomID(c *gin.Context) (int, bool) {
+	roomID, err := strconv.Atoi(c.Param("room_id"))
+	if err != nil {
+		c.Error(newAPIError(http.StatusBadRequest, "Invalid room ID"))
+		return 0, false
+	}
+	return roomID, true
+}
+
+// createChatRoom creates a chat room owned by the current user. The users in
+// member_ids are added to it and notified.
+func (h *APIHandler) createChatRoom(c *gin.Context) {
+	var req CreateChatRoomRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(withStatus(http.StatusBadRequest, err))
+		return
+	}
+	if len(req.MemberIDs) >= maxChatRoomMembers {
+		c.Error(newAPIError(http.StatusBadRequest, fmt.Sprintf("A chat room can have at most %d members", maxChatRoomMembers)))
+		return
+	}
+
+	userID, _ := strconv.Atoi(c.GetString("user_id"))
+	roomID, err := h.dbFor(c).CreateChatRoom(userID, req.Name, req.MemberIDs)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	h.publishNotifications()
+
+	room, err := h.dbFor(c).GetChatRoom(roomID, userID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, room)
+}
+
+// getChatRooms lists the current user's chat rooms, most recently active first
+func (h *APIHandler) getChatRooms(c *gin.Context) {
+	userID, _ := strconv.Atoi(c.GetString("user_id"))
+	rooms, err := h.dbFor(c).GetChatRooms(userID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, rooms)
+}
+
+// getChatRoom returns a chat room the current user is in, with its members
+func (h *APIHandler) getChatRoom(c *gin.Context) {
+	roomID, ok := chatRoomID(c)
+	if !ok {
+		return
+	}
+
+	userID, _ := strconv.Atoi(c.GetString("user_id"))
+	room, err := h.dbFor(c).GetChatRoom(roomID, userID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, room)
+}
+
+// inviteChatMember adds a user to a chat room the current user is in
+func (h *APIHandler) inviteChatMember(c *gin.Context) {
+	roomID, ok := chatRoomID(c)
+	if !ok {
+		return
+	}
+
+	var req InviteChatMemberRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(withStatus(http.StatusBadRequest, err))
+		return
+	}
+
+	userID, _ := strconv.Atoi(c.GetString("user_id"))
+	if err := h.dbFor(c).InviteChatMember(roomID, userID, req.UserID); err != nil {
+		c.Error(err)
+		return
+	}
+	h.publishNotifications()
+
+	c.JSON(http.StatusOK, gin.H{"message": "Member added"})
+}
+
+// removeChatMember removes a user from a chat room. Members can leave by
+// removing themselves; the owner can remove anyone.
+func (h *APIHandler) removeChatMember(c *gin.Context) {
+	roomID, ok := chatRoomID(c)
+	if !ok {
+		return
+	}
+	memberID, err := strconv.Atoi(c.Param("user_id"))
+	if err != nil {
+		c.Error(newAPIError(http.StatusBadRequest, "Invalid user ID"))
+		return
+	}
+
+	userID, _ := strconv.Atoi(c.GetString("user_id"))
+	if err := h.dbFor(c).RemoveChatMember(roomID, userID, memberID); err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Member removed"})
+}
+
+// sendChatMessage posts a message to a chat room the current user is in
+func (h *APIHandler) sendChatMessage(c *gin.Context) {
+	roomID, ok := chatRoomID(c)
+	if !ok {
+		return
+	}
+
+	var req ChatMessageRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(withStatus(http.StatusBadRequest, err))
+		return
+	}
+
+	userID, _ := strconv.Atoi(c.GetString("user_id"))
+	messageID, err := h.dbFor(c).SendChatMessage(roomID, userID, req.Content)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	h.publishNotifications()
+
+	c.JSON(http.StatusCreated, gin.H{"message_id": messageID})
+}
+
+// getChatMessages returns a page of a chat room's history, newest first
+func (h *APIHandler) getChatMessages(c *gin.Context) {
+	roomID, ok := chatRoomID(c)
+	if !ok {
+		return
+	}
+
+	userID, _ := strconv.Atoi(c.GetString("user_id"))
+	limit, offset := parsePagination(c)
+	messages, hasMore, err := h.dbFor(c).GetChatMessages(roomID, userID, limit, offset)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	page := ChatMessagePage{Messages: messages, Limit: limit, Offset: offset}
+	if hasMore {
+		next := offset + limit
+		page.NextOffset = &next
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
+// getNotificationPreferences returns the current user's delivery channels
+// for each notification type
+func (h *APIHandler) getNotificationPreferences(c *gin.Context) {
+	userID, _ := strconv.Atoi(c.GetString("user_id"))
+	prefs, err := h.dbFor(c).GetNotificationPreferences(userID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, prefs)
+}
+
+// NotificationChannelsUpdate changes some of the channels of a notification
+// type, leaving the ones that are nil as they are
+type NotificationChannelsUpdate struct {
+	InApp *bool `json:"in_app"`
+	Push  *bool `json:"push"`
+	Email *bool `json:"email"`
+}
+
+// updateNotificationPreferences changes the current user's delivery channels,
+// given as {"reply": {"email": true}, ...}. Types and channels left out keep
+// their current setting.
+func (h *APIHandler) updateNotificationPreferences(c *gin.Context) {
+	var req map[string]NotificationChannelsUpdate
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(withStatus(http.StatusBadRequest, err))
+		return
+	}
+
+	userID, _ := strconv.Atoi(c.GetString("user_id"))
+	prefs, err := h.dbFor(c).GetNotificationPreferences(userID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	changed := make(map[string]NotificationChannels, len(req))
+	for t, update := range req {
+		ch, ok := prefs[t]
+		if !ok {
+			c.Error(newAPIError(http.StatusBadRequest, "Unknown notification type: "+t))
+			return
+		}
+		if update.InApp != nil {
+			ch.InApp = *update.InApp
+		}
+		if update.Push != nil {
+			ch.Push = *update.Push
+		}
+		if update.Email != nil {
+			ch.Email = *update.Email
+		}
+		prefs[t], changed[t] = ch, ch
+	}
+
+	if err := h.dbFor(c).SetNotificationPreferences(userID, changed); err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, prefs)
+}
+
+// queueVerificationEmail sets the user's email address and queues a link to
+// verify it
+func (h *APIHandler) queueVerificationEmail(userID int, email string) error {
+	token, err := h.db.SetUserEmail(userID, email)
+	if err != nil {
+		return err
+	}
+	subject, body := renderVerificationEmail(h.publicURL + "/verify-email?token=" + token)
+	return h.db.QueueEmail(userID, email, "verification", subject, body)
+}
+
+// verifyEmail confirms an email address from the link in a verification email
+func (h *APIHandler) verifyEmail(c *gin.Context) {
+	token := c.Query("token")
+	if token == "" {
+		c.Error(newAPIError(http.StatusBadRequest, "token is required"))
+		return
+	}
+
+	if err := h.dbFor(c).VerifyEmail(token); err != nil {
+		c.Error(withStatus(http.StatusBadRequest, err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Email verified"})
+}
+
+// getEmailSettings returns the current user's email address and digest
+// setting
+func (h *APIHandler) getEmailSettings(c *gin.Context) {
+	userID, _ := strconv.Atoi(c.GetString("user_id"))
+	email, err := h.dbFor(c).GetUserEmail(userID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	if email == nil {
+		c.Error(newAPIError(http.StatusNotFound, "No email address set"))
+		return
+	}
+
+	c.JSON(http.StatusOK, email)
+}
+
+// UpdateEmailSettingsRequest changes the current user's email address, digest
+// setting or both
+type UpdateEmailSettingsRequest struct {
+	Email  *string `json:"email" binding:"omitempty,email,max=254"`
+	Digest *string `json:"digest" binding:"omitempty,oneof=off daily weekly"`
+}
+
+// updateEmailSettings changes the current user's email settings. A new
+// address must be verified again before emails are sent to it.
+func (h *APIHandler) updateEmailSettings(c *gin.Context) {
+	var req UpdateEmailSettingsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(withStatus(http.StatusBadRequest, err))
+		return
+	}
+
+	userID, _ := strconv.Atoi(c.GetString("user_id"))
+	current, err := h.dbFor(c).GetUserEmail(userID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	if req.Email != nil && (current == nil || !strings.EqualFold(*req.Email, current.Email)) {
+		if err := h.queueVerificationEmail(userID, *req.Email); err != nil {
+			c.Error(err)
+			return
+		}
+	}
+	if req.Digest != nil {
+		if err := h.dbFor(c).SetEmailDigest(userID, *req.Digest); err != nil {
+			c.Error(withStatus(http.StatusBadRequest, err))
+			return
+		}
+	}
+
+	h.getEmailSettings(c)
+}
+
+// resendVerificationEmail queues a new verification link for the current
+// user's unverified email address
+func (h *APIHandler) resendVerificationEmail(c *gin.Context) {
+	userID, _ := strconv.Atoi(c.GetString("user_id"))
+	email, token, err := h.dbFor(c).RenewEmailVerification(userID)
+	if err != nil {
+		c.Error(withStatus(http.StatusBadRequest, err))
+		return
+	}
+
+	subject, body := renderVerificationEmail(h.publicURL + "/verify-email?token=" + token)
+	if err := h.dbFor(c).QueueEmail(userID, email, "verification", subject, body); err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "Verification email sent"})
+}
+
+// getNotifications lists the current user's notifications, newest first.
+// ?unread=true limits the list to unread ones.
+func (h *APIHandler) getNotifications(c *gin.Context) {
+	userID, _ := strconv.Atoi(c.GetString("user_id"))
+	unreadOnly, _ := strconv.ParseBool(c.Query("unread"))
+	limit, offset := parsePagination(c)
+
+	notifications, hasMore, err := h.dbFor(c).GetNotifications(userID, unreadOnly, limit, offset)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	unread, err := h.dbFor(c).CountUnreadNotifications(userID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	page := NotificationPage{
+		Notifications: notifications,
+		UnreadCount:   unread,
+		Limit:         limit,
+		Offset:        offset,
+	}
+	if hasMore {
+		next := offset + limit
+		page.NextOffset = &next
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
+// getUnreadNotificationCount returns how many notifications the current user
+// hasn't read
+func (h *APIHandler) getUnreadNotificationCount(c *gin.Context) {
+	userID, _ := strconv.Atoi(c.GetString("user_id"))
+	unread, err := h.dbFor(c).CountUnreadNotifications(userID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"unread_count": unread})
+}
+
+// markNotificationRead marks one of the current user's notifications as read
+func (h *APIHandler) markNotificationRead(c *gin.Context) {
+	userID, _ := strconv.Atoi(c.GetString("user_id"))
+	notificationID, err := strconv.Atoi(c.Param("notification_id"))
+	if err != nil {
+		c.Error(newAPIError(http.StatusBadRequest, "Invalid notification ID"))
+		return
+	}
+
+	marked, err := h.dbFor(c).MarkNotificationsRead(userID, &notificationID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	if marked == 0 {
+		c.Error(newAPIError(http.StatusNotFound, "Unread notification not found"))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Notification marked as read"})
+}
+
+// markAllNotificationsRead marks all of the current user's notifications as read
+func (h *APIHandler) markAllNotificationsRead(c *gin.Context) {
+	userID, _ := strconv.Atoi(c.GetString("user_id"))
+	marked, err := h.dbFor(c).MarkNotificationsRead(userID, nil)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"marked": marked})
+}
+
+func (h *APIHandler) getTopUsers(c *gin.Context) {
+	limit := 10 // Default limit
+	if limitParam := c.Query("limit"); limitParam != "" {
+		if parsedLimit, err := strconv.Atoi(limitParam); err == nil && parsedLimit > 0 {
+			limit = parsedLimit
+		}
+	}
+
+	window, ok := topTimeframes[c.DefaultQuery("period", "all")]
+	if !ok {
+		c.Error(newAPIError(http.StatusBadRequest, "period must be one of hour, day, week, month, year or all"))
+		return
+	}
+
+	users, err := h.dbFor(c).GetTopUsers(window, limit)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, users)
+}
+
+func (h *APIHandler) subscribeToUser(c *gin.Context) {
+	userToSubscribe, err := strconv.Atoi(c.Param("user_id"))
+	if err != nil {
+		c.Error(newAPIError(http.StatusBadRequest, "Invalid user ID"))
+		return
+	}
+
+	subscriberID, _ := strconv.Atoi(c.GetString("user_id"))
+	err = h.dbFor(c).SubscribeToUser(subscriberID, userToSubscribe)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	h.publishNotifications()
+
+	c.JSON(http.StatusOK, gin.H{"message": "Successfully subscribed to user"})
+}
+
+func (h *APIHandler) unsubscribeFromUser(c *gin.Context) {
+	userToUnsubscribe, err := strconv.Atoi(c.Param("user_id"))
+	if err != nil {
+		c.Error(newAPIError(http.StatusBadRequest, "Invalid user ID"))
+		return
+	}
+
+	subscriberID, _ := strconv.Atoi(c.GetString("user_id"))
+	err = h.dbFor(c).UnsubscribeFromUser(subscriberID, userToUnsubscribe)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Successfully unsubscribed from user"})
+}
+
+func (h *APIHandler) getUserSubscriptions(c *gin.Context) {
+	userID, _ := strconv.Atoi(c.GetString("user_id"))
+	subscriptions, err := h.dbFor(c).GetUserSubscriptions(userID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, subscriptions)
+}
+
+func (h *APIHandler) getTopSubscribedUsers(c *gin.Context) {
+	limit := 10 // Default limit
+	if limitParam := c.Query("limit"); limitParam != "" {
+		if parsedLimit, err := strconv.Atoi(limitParam); err == nil && parsedLimit > 0 {
+			limit = parsedLimit
+		}
+	}
+
+	window, ok := topTimeframes[c.DefaultQuery("period", "all")]
+	if !ok {
+		c.Error(newAPIError(http.StatusBadRequest, "period must be one of hour, day, week, month, year or all"))
+		return
+	}
+
+	users, err := h.dbFor(c).GetTopSubscribedUsers(window, limit)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, users)
+}
+
+// RequestProcessingActor represents a worker actor in the pool
+type RequestProcessingActor struct {
+	handler *APIHandler
+	id      int
+	hot     *hotPosts // nil when vote aggregation is off
+}
+
+// Command is a write processed by the actor pool. Commands carry the acting
+// user and everything else the actor needs, so actors know nothing of HTTP;
+// handlers build them from requests and turn their results into responses.
+type Command interface {
+	commandType() string // names the command in logs and traces
+}
+
+type CreatePostCommand struct {
+	UserID int
+	CreatePostRequest
+}
+
+type CreateCommentCommand struct {
+	UserID int
+	CreateCommentRequest
+}
+
+type SendMessageCommand struct {
+	UserID int
+	SendMessageRequest
+}
+
+type JoinSubredditCommand struct {
+	UserID      int
+	SubredditID int
+}
+
+type LeaveSubredditCommand struct {
+	UserID      int
+	SubredditID int
+}
+
+type CreateSubredditCommand struct {
+	UserID int
+	CreateSubredditRequest
+}
+
+type VoteCommand struct {
+	UserID int
+	VoteRequest
+}
+
+type VoteBatchCommand struct {
+	UserID int
+	Votes  []VoteRequest
+}
+
+func (CreatePostCommand) commandType() string      { return "create_post" }
+func (CreateCommentCommand) commandType() string   { return "create_comment" }
+func (SendMessageCommand) commandType() string     { return "send_message" }
+func (JoinSubredditCommand) commandType() string   { return "join_subreddit" }
+func (LeaveSubredditCommand) commandType() string  { return "leave_subreddit" }
+func (CreateSubredditCommand) commandType() string { return "create_subreddit" }
+func (VoteCommand) commandType() string            { return "vote" }
+func (VoteBatchCommand) commandType() string       { return "vote_batch" }
+
+// PostCreated is the result of a CreatePostCommand
+type PostCreated struct {
+	PostID  int            `json:"post_id"`
+	Title   string         `json:"title"`
+	Automod AutomodOutcome `json:"automod"`
+}
+
+// CommentCreated is the result of a CreateCommentCommand
+type CommentCreated struct {
+	CommentID int            `json:"comment_id"`
+	Content   string         `json:"content"`
+	Automod   AutomodOutcome `json:"automod"`
+}
+
+// MessageSent is the result of a SendMessageCommand
+type MessageSent struct {
+	MessageID int    `json:"message_id"`
+	Content   string `json:"content"`
+}
+
+// SubredditCreated is the result of a CreateSubredditCommand
+type SubredditCreated struct {
+	SubredditID int    `json:"subreddit_id"`
+	Name        string `json:"name"`
+}
+
+// Acknowledged is the result of commands that create nothing
+type Acknowledged struct {
+	Message string `json:"message"`
+}
+
+// Request is a command on its way to an actor
+type Request struct {
+	Command Command
+	Ctx     context.Context // the HTTP request's context, for tracing
+
+	worker *poolWorker // the worker it was sent to
+}
+
+// Response is an actor's answer to a Request: the command's result, or the
+// error it failed with
+type Response struct {
+	Result interface{}
+	Err    error
+}
+
+// ActorPool manages a pool of request processing actors. Requests are
+// sharded by the entity they write to, so requests for the same subreddit
+// (or, for direct messages, the same recipient) are handled in order by one
+// actor, which can then cache what it knows about the entity.
+type ActorPool struct {
+	system      *actor.ActorSystem
+	handler     *APIHandler
+	parent      *actor.PID // the workers' parent, which supervises them
+	actors      []*poolWorker
+	roundRobin  int           // for requests without a shard key
+	mailboxSize int32         // requests an actor queues before new ones are turned away
+	timeout     time.Duration // how long a handler waits for an actor's answer
+	voteFlush   time.Duration // how often vote aggregators write, off if zero
+	mu          sync.Mutex
+
+	// cluster, if set, processes commands on worker nodes instead of the
+	// pool's own actors
+	cluster commandCluster
+
+	// targetSubreddits caches the subreddit of each post and comment
+	// requests were routed for, keyed like "post:12"
+	targetMu         sync.Mutex
+	targetSubreddits map[string]int
+}
+
+// commandCluster sends commands to the worker nodes of a cluster, keyed the
+// same way as the pool's own actors (see cluster.go)
+type commandCluster interface {
+	Process(ctx context.Context, key string, cmd Command) (interface{}, error)
+}
+
+// poolWorker is an actor of the pool, with the number of requests sent to it
+// that haven't been answered yet
+type poolWorker struct {
+	pid     *actor.PID
+	pending int32
+}
+
+var (
+	// errPoolSaturated is returned for requests whose actor's mailbox is full
+	errPoolSaturated = errors.New("server is busy, try again shortly")
+
+	// errRequestTimeout is returned for requests that weren't answered in
+	// time. The actor may still process them later.
+	errRequestTimeout = errors.New("request timed out")
+)
+
+// maxTargetSubreddits bounds the pool's cache of target subreddits, which is
+// cleared when it fills up
+const maxTargetSubreddits = 100000
+
+// ActorPoolOptions are the settings of an ActorPool that are fixed at startup
+type ActorPoolOptions struct {
+	MailboxSize       int           // requests each actor queues
+	Timeout           time.Duration // how long handlers wait for an actor
+	VoteFlushInterval time.Duration // see hotPosts, off if zero
+}
+
+// NewActorPool creates a pool of actors
+func NewActorPool(system *actor.ActorSystem, handler *APIHandler, poolSize int, opts ActorPoolOptions) (*ActorPool, error) {
+	pool := &ActorPool{
+		system:      system,
+		handler:     handler,
+		actors:      make([]*poolWorker, poolSize),
+		mailboxSize: int32(opts.MailboxSize),
+		timeout:     opts.Timeout,
+		voteFlush:   opts.VoteFlushInterval,
+
+		targetSubreddits: make(map[string]int),
+	}
+
+	// The workers are children of one parent, as a supervisor strategy
+	// applies to the children of the actor it's given to
+	pool.parent = system.Root.Spawn(actor.PropsFromProducer(func() actor.Actor {
+		return workerParent{}
+	}, actor.WithSupervisor(pool.workerSupervisor())))
+
+	// Create pool of actors
+	for i := 0; i < poolSize; i++ {
+		worker, err := pool.spawnWorker(i)
+		if err != nil {
+			return nil, err
+		}
+		pool.actors[i] = worker
+	}
+
+	return pool, nil
+}
+
+// workerParent is the parent of the pool's workers. It only spawns them, and
+// supervises them with the strategy it was spawned with.
+type workerParent struct{}
+
+// spawnChild asks the workerParent to spawn a worker, answering with its PID
+type spawnChild struct {
+	props *actor.Props
+}
+
+func (workerParent) Receive(context actor.Context) {
+	if msg, ok := context.Message().(spawnChild); ok {
+		context.Respond(context.Spawn(msg.props))
+	}
+}
+
+// workerSupervisor restarts a worker whenever it panics. The panicking
+// request has already been answered by then, and the restart gives the worker
+// fresh state, while the requests waiting in its mailbox are kept. Workers
+// are never stopped for crashing too often, as requests sent to a stopped
+// worker would never be answered.
+func (p *ActorPool) workerSupervisor() actor.SupervisorStrategy {
+	return actor.NewOneForOneStrategy(math.MaxInt32, time.Minute, func(reason interface{}) actor.Directive {
+		p.handler.metrics.Inc("goreddit_actor_restarts_total")
+		return actor.RestartDirective
+	})
+}
+
+func (p *ActorPool) spawnWorker(id int) (*poolWorker, error) {
+	props := actor.PropsFromProducer(func() actor.Actor {
+		return &RequestProcessingActor{
+			handler: p.handler,
+			id:      id,
+			hot:     newHotPosts(p.voteFlush),
+		}
+	}, actor.WithMailbox(actor.Bounded(int(p.mailboxSize))))
+
+	result, err := p.system.Root.RequestFuture(p.parent, spawnChild{props: props}, 5*time.Second).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to spawn worker %d: %v", id, err)
+	}
+	return &poolWorker{pid: result.(*actor.PID)}, nil
+}
+
+// Resize grows or shrinks the pool. Removed workers are poisoned, so they
+// finish the requests already in their mailboxes before stopping.
+func (p *ActorPool) Resize(poolSize int) error {
+	if poolSize < 1 {
+		return fmt.Errorf("pool size must be at least 1")
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for len(p.actors) < poolSize {
+		worker, err := p.spawnWorker(len(p.actors))
+		if err != nil {
+			return err
+		}
+		p.actors = append(p.actors, worker)
+	}
+	for _, worker := range p.actors[poolSize:] {
+		p.system.Root.Poison(worker.pid)
+	}
+	p.actors = p.actors[:poolSize]
+	p.roundRobin %= poolSize
+
+	return nil
+}
+
+// targetSubreddit returns the subreddit a post or comment belongs to, which
+// never changes once it's created
+func (p *ActorPool) targetSubreddit(c *gin.Context, targetID int, targetType string) (int, error) {
+	key := targetType + ":" + strconv.Itoa(targetID)
+	p.targetMu.Lock()
+	subredditID, ok := p.targetSubreddits[key]
+	p.targetMu.Unlock()
+	if ok {
+		return subredditID, nil
+	}
+
+	subredditID, err := p.handler.dbFor(c).GetTargetSubredditID(targetID, targetType)
+	if err != nil {
+		return 0, err
+	}
+
+	p.targetMu.Lock()
+	if len(p.targetSubreddits) >= maxTargetSubreddits {
+		p.targetSubreddits = make(map[string]int)
+	}
+	p.targetSubreddits[key] = subredditID
+	p.targetMu.Unlock()
+	return subredditID, nil
+}
+
+// shardKey names the entity a command writes to: its subreddit, or the
+// recipient of a direct message. Commands whose target can't be found get
+// no key, and fail in whichever actor handles them.
+func (p *ActorPool) shardKey(c *gin.Context, cmd Command) string {
+	subreddit := func(targetID int, targetType string) string {
+		subredditID, err := p.targetSubreddit(c, targetID, targetType)
+		if err != nil {
+			return ""
+		}
+		return "subreddit:" + strconv.Itoa(subredditID)
+	}
+
+	switch cmd := cmd.(type) {
+	case CreatePostCommand:
+		return "subreddit:" + strconv.Itoa(cmd.SubredditID)
+	case CreateCommentCommand:
+		return subreddit(cmd.PostID, "post")
+	case VoteCommand:
+		return subreddit(cmd.TargetID, cmd.TargetType)
+	case VoteBatchCommand:
+		// A batch can span subreddits, so it has no single owner
+		return ""
+	case JoinSubredditCommand:
+		return "subreddit:" + strconv.Itoa(cmd.SubredditID)
+	case LeaveSubredditCommand:
+		return "subreddit:" + strconv.Itoa(cmd.SubredditID)
+	case CreateSubredditCommand:
+		return "subreddit-name:" + cmd.Name
+	case SendMessageCommand:
+		return "user:" + strconv.Itoa(cmd.ToUserID)
+	}
+	return ""
+}
+
+// ProcessRequest sends a command to the actor that owns its shard key, or to
+// the next actor in a round-robin fashion if it has none, and returns the
+// command's result. Resizing the pool moves keys between actors. If the
+// actor's mailbox is full the command is turned away with errPoolSaturated
+// rather than left waiting, and errRequestTimeout is returned if the actor
+// doesn't answer within the pool's timeout. In cluster mode the command goes
+// to the worker node that owns its key instead.
+func (p *ActorPool) ProcessRequest(c *gin.Context, cmd Command) (interface{}, error) {
+	key := p.shardKey(c, cmd)
+
+	// The span covers the wait in the worker's mailbox as well as the work
+	span := traceRequest(c, "ActorPool.ProcessRequest",
+		attribute.String("request.type", cmd.commandType()),
+		attribute.String("actor.shard_key", key),
+	)
+
+	var result interface{}
+	var err error
+	if p.cluster != nil {
+		result, err = p.cluster.Process(c.Request.Context(), key, cmd)
+	} else {
+		result, err = p.processLocally(c.Request.Context(), key, cmd)
+	}
+	if errors.Is(err, errRequestTimeout) {
+		p.handler.metrics.Inc("goreddit_actor_requests_timed_out_total")
+	}
+	endSpan(span, err)
+	if err != nil {
+		return nil, err
+	}
+
+	p.handler.emitCommandEvents(cmd, result)
+	return result, nil
+}
+
+// processLocally sends a command to one of the pool's own actors
+func (p *ActorPool) processLocally(ctx context.Context, key string, cmd Command) (interface{}, error) {
+	p.mu.Lock()
+	var worker *poolWorker
+	if key != "" {
+		hash := fnv.New32a()
+		hash.Write([]byte(key))
+		worker = p.actors[hash.Sum32()%uint32(len(p.actors))]
+	} else {
+		worker = p.actors[p.roundRobin]
+		p.roundRobin = (p.roundRobin + 1) % len(p.actors)
+	}
+	p.mu.Unlock()
+
+	// The worker takes the request off its count once it's processed it,
+	// even if the handler has stopped waiting by then
+	if atomic.AddInt32(&worker.pending, 1) > p.mailboxSize {
+		atomic.AddInt32(&worker.pending, -1)
+		p.handler.metrics.Inc("goreddit_actor_requests_rejected_total")
+		return nil, errPoolSaturated
+	}
+
+	future := p.system.Root.RequestFuture(worker.pid, &Request{
+		Command: cmd,
+		Ctx:     ctx,
+		worker:  worker,
+	}, p.timeout)
+
+	result, err := future.Result()
+	if errors.Is(err, actor.ErrTimeout) {
+		return nil, errRequestTimeout
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	resp, ok := result.(*Response)
+	if !ok {
+		return nil, fmt.Errorf("unexpected response %T from worker", result)
+	}
+	return resp.Result, resp.Err
+}
+
+// Create a custom Gin handler that uses the actor pool
+func ActorPoolHandler(pool *ActorPool, requestType string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, _ := strconv.Atoi(c.GetString("user_id"))
+		var cmd Command
+		var err error
+
+		// Build the command from the request
+		switch requestType {
+		case "create_post":
+			var req CreatePostRequest
+			err = c.ShouldBindJSON(&req)
+			cmd = CreatePostCommand{UserID: userID, CreatePostRequest: req}
+		case "create_comment":
+			var req CreateCommentRequest
+			err = c.ShouldBindJSON(&req)
+			cmd = CreateCommentCommand{UserID: userID, CreateCommentRequest: req}
+		case "send_message":
+			var req SendMessageRequest
+			err = c.ShouldBindJSON(&req)
+			cmd = SendMessageCommand{UserID: userID, SendMessageRequest: req}
+		case "join_subreddit", "leave_subreddit":
+			// The subreddit ID comes from the URL parameter
+			subredditID, parseErr := strconv.Atoi(c.Param("id"))
+			if parseErr != nil {
+				c.Error(newAPIError(http.StatusBadRequest, "Invalid subreddit ID"))
+				return
+			}
+			if requestType == "join_subreddit" {
+				cmd = JoinSubredditCommand{UserID: userID, SubredditID: subredditID}
+			} else {
+				cmd = LeaveSubredditCommand{UserID: userID, SubredditID: subredditID}
+			}
+		case "create_subreddit":
+			var req CreateSubredditRequest
+			err = c.ShouldBindJSON(&req)
+			cmd = CreateSubredditCommand{UserID: userID, CreateSubredditRequest: req}
+		case "vote":
+			var req VoteRequest
+			err = c.ShouldBindJSON(&req)
+			cmd = VoteCommand{UserID: userID, VoteRequest: req}
+		default:
+			c.Error(newAPIError(http.StatusBadRequest, "Invalid request type"))
+			return
+		}
+
+		// Handle parsing error
+		if err != nil {
+			c.Error(withStatus(http.StatusBadRequest, err))
+			return
+		}
+
+		// Process the command through the actor pool
+		result, err := pool.ProcessRequest(c, cmd)
+		if err != nil {
+			c.Error(err)
+			return
+		}
+
+		switch result.(type) {
+		case PostCreated, CommentCreated, MessageSent, SubredditCreated:
+			c.JSON(http.StatusCreated, result)
+		default:
+			c.JSON(http.StatusOK, result)
+		}
+	}
+}
+
+// recoverRequest answers a request whose processing panicked, so its handler
+// doesn't wait for it to time out, then panics again to let the supervisor
+// restart the worker
+func (a *RequestProcessingActor) recoverRequest(context actor.Context, msg *Request) {
+	r := recover()
+	if r == nil {
+		return
+	}
+
+	log.Printf("Worker %d crashed processing request of type %s: %v\n%s", a.id, msg.Command.commandType(), r, debug.Stack())
+	context.Respond(&Response{Err: fmt.Errorf("internal error processing %s request", msg.Command.commandType())})
+	atomic.AddInt32(&msg.worker.pending, -1)
+	panic(r)
+}
+
+func (a *RequestProcessingActor) Receive(context actor.Context) {
+	switch msg := context.Message().(type) {
+	case *Request:
+		defer a.recoverRequest(context, msg)
+
+		resp := &Response{}
+		resp.Result, resp.Err = a.process(context, msg.Ctx, msg.Command)
+		context.Respond(resp)
+		atomic.AddInt32(&msg.worker.pending, -1)
+	}
+}
+
+// process runs a command and returns its result. Its span is a child of ctx,
+// which carries the trace of the HTTP request the command came from, and ac
+// is the context of the actor running it.
+func (a *RequestProcessingActor) process(ac actor.Context, ctx context.Context, cmd Command) (result interface{}, err error) {
+	requestType := cmd.commandType()
+	logAt(logDebug, "Worker %d processing request of type %s", a.id, requestType)
+	ctx, span := tracer.Start(ctx, "RequestProcessingActor."+requestType, trace.WithAttributes(attribute.Int("actor.worker", a.id)))
+	defer func() { endSpan(span, err) }()
+
+	db := a.handler.db.WithContext(ctx)
+	switch cmd := cmd.(type) {
+	case CreatePostCommand:
+		return a.createPost(db, cmd)
+	case CreateCommentCommand:
+		return a.createComment(db, cmd)
+	case SendMessageCommand:
+		return a.sendMessage(db, cmd)
+	case JoinSubredditCommand:
+		return a.joinSubreddit(db, cmd)
+	case CreateSubredditCommand:
+		return a.createSubreddit(db, cmd)
+	case VoteCommand:
+		return a.vote(ac, db, cmd)
+	case VoteBatchCommand:
+		return a.voteBatch(db, cmd)
+	case LeaveSubredditCommand:
+		return a.leaveSubreddit(db, cmd)
+	}
+	return nil, fmt.Errorf("unhandled request type: %s", requestType)
+}
+
+// getUserJoinedSubreddits handles retrieving subreddits user has joined
+func (h *APIHandler) getUserJoinedSubreddits(c *gin.Context) {
+	userID, _ := strconv.Atoi(c.GetString("user_id"))
+	subreddits, err := h.dbFor(c).GetUserJoinedSubreddits(userID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, subreddits)
+}
+
+// searchSubreddits handles searching subreddits by name and description
+func (h *APIHandler) searchSubreddits(c *gin.Context) {
+	query := strings.TrimSpace(c.Query("q"))
+	if query == "" {
+		c.Error(newAPIError(http.StatusBadRequest, "Search query q is required"))
+		return
+	}
+
+	limit := 25 // Default limit
+	if limitParam := c.Query("limit"); limitParam != "" {
+		if parsedLimit, err := strconv.Atoi(limitParam); err == nil && parsedLimit > 0 {
+			limit = parsedLimit
+		}
+	}
+
+	subreddits, err := h.dbFor(c).SearchSubreddits(query, limit)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, subreddits)
+}
+
+// discoverSubreddits handles suggesting active subreddits the user hasn't joined
+func (h *APIHandler) discoverSubreddits(c *gin.Context) {
+	limit := 10 // Default limit
+	if limitParam := c.Query("limit"); limitParam != "" {
+		if parsedLimit, err := strconv.Atoi(limitParam); err == nil && parsedLimit > 0 {
+			limit = parsedLimit
+		}
+	}
+
+	userID, _ := strconv.Atoi(c.GetString("user_id"))
+	subreddits, err := h.dbFor(c).DiscoverSubreddits(userID, limit)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, subreddits)
+}
+
+// onboardingSuggestionCount is how many subreddits new users are offered
+const onboardingSuggestionCount = 10
+
+// onboardingSubreddits suggests subreddits for a user to start out in,
+// featuring the config's onboarding_subreddits first
+func (h *APIHandler) onboardingSubreddits(userID int) ([]SubredditListing, error) {
+	h.configMu.Lock()
+	featured := h.config.OnboardingSubreddits
+	h.configMu.Unlock()
+	return h.db.GetOnboardingSubreddits(userID, featured, onboardingSuggestionCount)
+}
+
+// getOnboarding returns the subreddits suggested to the current user to join
+func (h *APIHandler) getOnboarding(c *gin.Context) {
+	userID, _ := strconv.Atoi(c.GetString("user_id"))
+	subreddits, err := h.onboardingSubreddits(userID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"subreddits": subreddits})
+}
+
+// JoinSubredditsRequest lists subreddits to join in one call
+type JoinSubredditsRequest struct {
+	SubredditIDs []int `json:"subreddit_ids" binding:"required,min=1,max=25"`
+}
+
+// joinSubreddits joins several subreddits at once, such as the ones picked
+// from the onboarding suggestions
+func (h *APIHandler) joinSubreddits(c *gin.Context) {
+	var req JoinSubredditsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(withStatus(http.StatusBadRequest, err))
+		return
+	}
+
+	userID, _ := strconv.Atoi(c.GetString("user_id"))
+	if err := h.dbFor(c).JoinSubreddits(userID, req.SubredditIDs); err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			c.Error(withStatus(http.StatusNotFound, err))
+			return
+		}
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":       "Successfully joined subreddits",
+		"subreddit_ids": req.SubredditIDs,
+	})
+}
+
+// getAllSubreddits handles retrieving all subreddits
+func (h *APIHandler) getAllSubreddits(c *gin.Context) {
+	subreddits, err := h.dbFor(c).GetAllSubreddits()
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, subreddits)
+}
+
+// moderatedSubreddit parses the :id subreddit parameter and verifies the
+// requesting user moderates it, writing an error response when they don't
+func (h *APIHandler) moderatedSubreddit(c *gin.Context) (int, bool) {
+	subredditID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.Error(newAPIError(http.StatusBadRequest, "Invalid subreddit ID"))
+		return 0, false
+	}
+
+	userID, _ := strconv.Atoi(c.GetString("user_id"))
+	isMod, err := h.dbFor(c).IsModerator(userID, subredditID)
+	if err != nil {
+		c.Error(err)
+		return 0, false
+	}
+	if !isMod {
+		c.Error(newAPIError(http.StatusForbidden, "Moderator access required"))
+		return 0, false
+	}
+
+	return subredditID, true
+}
+
+// getAutomodRules lists the subreddit's automod rules
+func (h *APIHandler) getAutomodRules(c *gin.Context) {
+	subredditID, ok := h.moderatedSubreddit(c)
+	if !ok {
+		return
+	}
+
+	rules, err := h.dbFor(c).GetAutomodRules(subredditID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, rules)
+}
+
+// createAutomodRule adds an automod rule to the subreddit
+func (h *APIHandler) createAutomodRule(c *gin.Context) {
+	subredditID, ok := h.moderatedSubreddit(c)
+	if !ok {
+		return
+	}
+
+	var req CreateAutomodRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(withStatus(http.StatusBadRequest, err))
+		return
+	}
+	if err := req.validate(); err != nil {
+		c.Error(withStatus(http.StatusBadRequest, err))
+		return
+	}
+
+	userID, _ := strconv.Atoi(c.GetString("user_id"))
+	ruleID, err := h.dbFor(c).CreateAutomodRule(subredditID, userID, req)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"rule_id": ruleID})
+}
+
+// deleteAutomodRule removes an automod rule from the subreddit
+func (h *APIHandler) deleteAutomodRule(c *gin.Context) {
+	subredditID, ok := h.moderatedSubreddit(c)
+	if !ok {
+		return
+	}
+
+	ruleID, err := strconv.Atoi(c.Param("rule_id"))
+	if err != nil {
+		c.Error(newAPIError(http.StatusBadRequest, "Invalid rule ID"))
+		return
+	}
+
+	if err := h.dbFor(c).DeleteAutomodRule(subredditID, ruleID); err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Automod rule deleted"})
+}
+
+// getModQueue lists content flagged for review in the subreddit
+func (h *APIHandler) getModQueue(c *gin.Context) {
+	subredditID, ok := h.moderatedSubreddit(c)
+	if !ok {
+		return
+	}
+
+	items, err := h.dbFor(c).GetModQueue(subredditID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, items)
+}
+
+// getProfile returns the current user's profile
+func (h *APIHandler) getProfile(c *gin.Context) {
+	userID, _ := strconv.Atoi(c.GetString("user_id"))
+	profile, err := h.dbFor(c).GetUserProfile(userID)
+	if err != nil {
+		c.Error(newAPIError(http.StatusNotFound, "User not found"))
+		return
+	}
+
+	c.JSON(http.StatusOK, profile)
+}
+
+// updateProfile changes the fields of the current user's profile that are
+// present in the request
+func (h *APIHandler) updateProfile(c *gin.Context) {
+	userID, _ := strconv.Atoi(c.GetString("user_id"))
+
+	var req UpdateUserProfileRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(withStatus(http.StatusBadRequest, err))
+		return
+	}
+
+	profile, err := h.dbFor(c).GetUserProfile(userID)
+	if err != nil {
+		c.Error(newAPIError(http.StatusNotFound, "User not found"))
+		return
+	}
+
+	if req.Bio != nil {
+		profile.Bio = *req.Bio
+	}
+	if req.AvatarURL != nil {
+		// Avatars are hosted elsewhere, so only absolute http(s) links are accepted
+		if *req.AvatarURL != "" {
+			u, err := url.Parse(*req.AvatarURL)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				c.Error(newAPIError(http.StatusBadRequest, "avatar_url must be an http or https URL"))
+				return
+			}
+		}
+		profile.AvatarURL = *req.AvatarURL
+	}
+	if req.ShowNSFW != nil {
+		profile.ShowNSFW = *req.ShowNSFW
+	}
+	if req.DefaultFeedSort != nil {
+		if _, ok := rankingAlgorithms[*req.DefaultFeedSort]; !ok && *req.DefaultFeedSort != "" {
+			c.Error(newAPIError(http.StatusBadRequest, "Unknown sort: "+*req.DefaultFeedSort))
+			return
+		}
+		profile.DefaultFeedSort = *req.DefaultFeedSort
+	}
+	if req.MutedKeywords != nil {
+		if profile.MutedKeywords, err = normalizeMutedWords(mutedKeyword, *req.MutedKeywords); err != nil {
+			c.Error(withStatus(http.StatusBadRequest, err))
+			return
+		}
+	}
+	if req.MutedDomains != nil {
+		if profile.MutedDomains, err = normalizeMutedWords(mutedDomain, *req.MutedDomains); err != nil {
+			c.Error(withStatus(http.StatusBadRequest, err))
+			return
+		}
+	}
+
+	if err := h.dbFor(c).UpdateUserProfile(*profile); err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, profile)
+}
+
+// getSubredditSettings returns the subreddit's settings
+func (h *APIHandler) getSubredditSettings(c *gin.Context) {
+	subredditID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.Error(newAPIError(http.StatusBadRequest, "Invalid subreddit ID"))
+		return
+	}
+
+	settings, err := h.dbFor(c).GetSubredditSettings(subredditID)
+	if err != nil {
+		c.Error(newAPIError(http.StatusNotFound, "Subreddit not found"))
+		return
+	}
+
+	c.JSON(http.StatusOK, settings)
+}
+
+// updateSubredditSettings lets moderators change the subreddit's settings
+func (h *APIHandler) updateSubredditSettings(c *gin.Context) {
+	subredditID, ok := h.moderatedSubreddit(c)
+	if !ok {
+		return
+	}
+
+	var req UpdateSubredditSettingsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(withStatus(http.StatusBadRequest, err))
+		return
+	}
+
+	settings, err := h.dbFor(c).GetSubredditSettings(subredditID)
+	if err != nil {
+		c.Error(newAPIError(http.StatusNotFound, "Subreddit not found"))
+		return
+	}
+
+	if req.DefaultSort != nil {
+		if _, ok := rankingAlgorithms[*req.DefaultSort]; !ok {
+			c.Error(newAPIError(http.StatusBadRequest, "Unknown sort: "+*req.DefaultSort))
+			return
+		}
+		settings.DefaultSort = *req.DefaultSort
+	}
+	if req.HalfLifeHours != nil {
+		settings.HalfLifeHours = *req.HalfLifeHours
+	}
+	if req.CollapseBelowScore != nil {
+		settings.CollapseBelowScore = *req.CollapseBelowScore
+	}
+	if req.CollapseNegativeKarma != nil {
+		settings.CollapseNegativeKarma = *req.CollapseNegativeKarma
+	}
+	if req.MaxPostsPerDay != nil {
+		settings.MaxPostsPerDay = *req.MaxPostsPerDay
+	}
+	if req.AllowedPostTypes != nil {
+		settings.AllowedPostTypes = normalizePostKinds(*req.AllowedPostTypes)
+	}
+	if req.MinTitleLength != nil {
+		settings.MinTitleLength = *req.MinTitleLength
+	}
+	if req.AllowCrossposts != nil {
+		settings.AllowCrossposts = *req.AllowCrossposts
+	}
+	if req.EditHistoryModOnly != nil {
+		settings.EditHistoryModOnly = *req.EditHistoryModOnly
+	}
+	if req.VoteFuzzMinutes != nil {
+		settings.VoteFuzzMinutes = *req.VoteFuzzMinutes
+	}
+	if req.NSFW != nil {
+		settings.NSFW = *req.NSFW
+	}
+
+	if err := h.dbFor(c).UpdateSubredditSettings(*settings); err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, settings)
+}
+
+// getSubredditAbout returns a subreddit's description, rules, moderators,
+// creation date, member count and pinned posts in one call, everything a
+// client needs to render the community header
+func (h *APIHandler) getSubredditAbout(c *gin.Context) {
+	about, err := h.dbFor(c).GetSubredditAbout(c.Param("name"))
+	if err != nil {
+		c.Error(newAPIError(http.StatusNotFound, "Subreddit not found"))
+		return
+	}
+
+	c.JSON(http.StatusOK, about)
+}
+
+// Reddit API compatibility
+//
+// The .json endpoints return posts and comments in the Listing and thing
+// shapes of Reddit's API, so third-party tooling written against Reddit can
+// point at this server. IDs are base36 as on Reddit, posts are t3 things,
+// comments t1 and subreddits t5.
+
+// Reddit listing page sizes
+const (
+	redditDefaultLimit = 25
+	redditMaxLimit     = 100
+)
+
+type redditListing struct {
+	Kind string            `json:"kind"` // always "Listing"
+	Data redditListingData `json:"data"`
+}
+
+type redditListingData struct {
+	After    *string       `json:"after"`
+	Before   *string       `json:"before"`
+	Dist     *int          `json:"dist"`
+	Children []redditThing `json:"children"`
+}
+
+type redditThing struct {
+	Kind string      `json:"kind"`
+	Data interface{} `json:"data"`
+}
+
+// redditLink is the data of a t3 thing, a post
+type redditLink struct {
+	ID                    string      `json:"id"`
+	Name                  string      `json:"name"`
+	Title                 string      `json:"title"`
+	Selftext              string      `json:"selftext"`
+	Author                string      `json:"author"`
+	Subreddit             string      `json:"subreddit"`
+	SubredditID           string      `json:"subreddit_id"`
+	SubredditNamePrefixed string      `json:"subreddit_name_prefixed"`
+	Created               float64     `json:"created"`
+	CreatedUTC            float64     `json:"created_utc"`
+	Edited                interface{} `json:"edited"` // false, or when it was edited
+	Score                 int         `json:"score"`
+	Ups                   int         `json:"ups"`
+	Downs                 int         `json:"downs"`
+	UpvoteRatio           float64     `json:"upvote_ratio"`
+	NumComments           int         `json:"num_comments"`
+	Permalink             string      `json:"permalink"`
+	URL                   string      `json:"url"`
+	LinkFlairText         *string     `json:"link_flair_text"`
+	Stickied              bool        `json:"stickied"`
+	IsSelf                bool        `json:"is_self"`
+}
+
+// redditComment is the data of a t1 thing, a comment
+type redditComment struct {
+	ID          string      `json:"id"`
+	Name        string      `json:"name"`
+	Body        string      `json:"body"`
+	Author      string      `json:"author"`
+	ParentID    string      `json:"parent_id"`
+	LinkID      string      `json:"link_id"`
+	Subreddit   string      `json:"subreddit"`
+	SubredditID string      `json:"subreddit_id"`
+	Created     float64     `json:"created"`
+	CreatedUTC  float64     `json:"created_utc"`
+	Edited      interface{} `json:"edited"`
+	Score       int         `json:"score"`
+	Ups         int         `json:"ups"`
+	Downs       int         `json:"downs"`
+	Depth       int         `json:"depth"`
+	Permalink   string      `json:"permalink"`
+	Replies     interface{} `json:"replies"` // a Listing, or "" without replies
+}
+
+// redditFullname is a thing's type prefix and base36 ID, e.g. t3_2n
+func redditFullname(kind string, id int) string {
+	return kind + "_" + strconv.FormatInt(int64(id), 36)
+}
+
+// redditEdited is the edited field: false, or the unix time of the edit
+func redditEdited(editedAt *time.Time) interface{} {
+	if editedAt == nil {
+		return false
+	}
+	return float64(editedAt.Unix())
+}
+
+// toRedditLink converts a post to a t3 thing
+func (h *APIHandler) toRedditLink(post Post) redditThing {
+	created := float64(post.CreatedAt.Unix())
+	ratio := 0.0
+	if total := post.VoteCount.Upvotes + post.VoteCount.Downvotes; total > 0 {
+		ratio = math.Round(float64(post.VoteCount.Upvotes)/float64(total)*100) / 100
+	}
+	var flair *string
+	if post.Flair != "" {
+		flair = &post.Flair
+	}
+
+	// Links and images point at what they share rather than at themselves
+	permalink := postPermalink(post)
+	link, selftext, isSelf := h.publicURL+permalink, post.Content, true
+	if post.Kind == postKindLink || post.Kind == postKindImage {
+		link, selftext, isSelf = strings.TrimSpace(post.Content), "", false
+	}
+	return redditThing{Kind: "t3", Data: redditLink{
+		ID:                    strconv.FormatInt(int64(post.ID), 36),
+		Name:                  redditFullname("t3", post.ID),
+		Title:                 post.Title,
+		Selftext:              selftext,
+		Author:                post.AuthorUsername,
+		Subreddit:             post.SubredditName,
+		SubredditID:           redditFullname("t5", post.SubredditID),
+		SubredditNamePrefixed: "r/" + post.SubredditName,
+		Created:               created,
+		CreatedUTC:            created,
+		Edited:                redditEdited(post.EditedAt),
+		Score:                 netScore(post),
+		Ups:                   post.VoteCount.Upvotes,
+		Downs:                 post.VoteCount.Downvotes,
+		UpvoteRatio:           ratio,
+		NumComments:           post.CommentCount,
+		Permalink:             permalink,
+		URL:                   link,
+		LinkFlairText:         flair,
+		Stickied:              post.Pinned,
+		IsSelf:                isSelf,
+	}}
+}
+
+// newRedditListing wraps things in a Listing
+func newRedditListing(children []redditThing, after *string) redditListing {
+	dist := len(children)
+	return redditListing{Kind: "Listing", Data: redditListingData{
+		After:    after,
+		Dist:     &dist,
+		Children: children,
+	}}
+}
+
+// redditSubredditListing serves a subreddit's posts as a Listing, ranked by
+// the given sort. Pages follow Reddit's ?limit= and ?after= parameters.
+func (h *APIHandler) redditSubredditListing(sortBy string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		subredditID, err := h.dbFor(c).GetSubredditIDByName(c.Param("name"))
+		if err != nil {
+			c.Error(newAPIError(http.StatusNotFound, "Subreddit not found"))
+			return
+		}
+
+		posts, err := h.dbFor(c).GetSubredditPosts(subredditID, allPosts, 0)
+		if err != nil {
+			c.Error(err)
+			return
+		}
+		params := RankingParams{HalfLifeHours: defaultHalfLifeHours}
+		if err := h.rankPosts(posts, sortBy, params); err != nil {
+			c.Error(err)
+			return
+		}
+		if sortBy == "hot" {
+			// Pinned posts are stickied to the top of hot, as on Reddit
+			sort.SliceStable(posts, func(i, j int) bool { return posts[i].Pinned && !posts[j].Pinned })
+		}
+
+		if after := c.Query("after"); after != "" {
+			for i, post := range posts {
+				if redditFullname("t3", post.ID) == after {
+					posts = posts[i+1:]
+					break
+				}
+			}
+		}
+		limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(redditDefaultLimit)))
+		if err != nil || limit < 1 {
+			limit = redditDefaultLimit
+		}
+		if limit > redditMaxLimit {
+			limit = redditMaxLimit
+		}
+		var next *string
+		if len(posts) > limit {
+			posts = posts[:limit]
+			fullname := redditFullname("t3", posts[limit-1].ID)
+			next = &fullname
+		}
+
+		children := make([]redditThing, len(posts))
+		for i, post := range posts {
+			children[i] = h.toRedditLink(post)
+		}
+		c.JSON(http.StatusOK, newRedditListing(children, next))
+	}
+}
+
+// redditCommentsListing serves /comments/:id.json: a Listing with the post
+// followed by a Listing of its comment tree, each level ordered by ?sort=
+// (top, best, new or old; highest scoring first by default)
+func (h *APIHandler) redditCommentsListing(c *gin.Context) {
+	param := c.Param("id")
+	if !strings.HasSuffix(param, ".json") {
+		c.Error(newAPIError(http.StatusNotFound, "Not found"))
+		return
+	}
+	postID, err := strconv.ParseInt(strings.TrimPrefix(strings.TrimSuffix(param, ".json"), "t3_"), 36, 64)
+	if err != nil {
+		c.Error(newAPIError(http.StatusBadRequest, "Invalid post ID"))
+		return
+	}
+	sortBy := c.DefaultQuery("sort", "top")
+	if _, ok := commentSorts[sortBy]; !ok {
+		c.Error(newAPIError(http.StatusBadRequest, "sort must be one of top, best, new or old"))
+		return
+	}
+
+	post, err := h.dbFor(c).GetPost(int(postID))
+	if err != nil {
+		c.Error(newAPIError(http.StatusNotFound, "Post not found"))
+		return
+	}
+	// The listing is public, so there's only a viewer with a session
+	userID, _ := strconv.Atoi(c.GetString("user_id"))
+	recordPostView(h.dbFor(c), post, userID)
+	comments, err := h.dbFor(c).GetCommentsSince(post.ID, 0, userID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	// Comments whose parent isn't visible are shown at the top level
+	visible := make(map[int]bool, len(comments))
+	for _, comment := range comments {
+		visible[comment.ID] = true
+	}
+	replies := make(map[int][]Comment)
+	for _, comment := range comments {
+		parent := 0
+		if comment.ParentCommentID != nil && visible[*comment.ParentCommentID] {
+			parent = *comment.ParentCommentID
+		}
+		replies[parent] = append(replies[parent], comment)
+	}
+
+	var tree func(parent, depth int) []redditThing
+	tree = func(parent, depth int) []redditThing {
+		level := replies[parent]
+		sortComments(level, sortBy)
+
+		things := make([]redditThing, len(level))
+		for i, comment := range level {
+			parentID := redditFullname("t3", post.ID)
+			if parent != 0 {
+				parentID = redditFullname("t1", parent)
+			}
+			var children interface{} = ""
+			if len(replies[comment.ID]) > 0 {
+				children = newRedditListing(tree(comment.ID, depth+1), nil)
+			}
+			created := float64(comment.CreatedAt.Unix())
+			things[i] = redditThing{Kind: "t1", Data: redditComment{
+				ID:          strconv.FormatInt(int64(comment.ID), 36),
+				Name:        redditFullname("t1", comment.ID),
+				Body:        comment.Content,
+				Author:      comment.AuthorUsername,
+				ParentID:    parentID,
+				LinkID:      redditFullname("t3", post.ID),
+				Subreddit:   post.SubredditName,
+				SubredditID: redditFullname("t5", post.SubredditID),
+				Created:     created,
+				CreatedUTC:  created,
+				Edited:      redditEdited(comment.EditedAt),
+				Score:       comment.Votes,
+				Ups:         comment.Votes,
+				Depth:       depth,
+				Permalink:   postPermalink(*post) + "/" + strconv.FormatInt(int64(comment.ID), 36) + "/",
+				Replies:     children,
+			}}
+		}
+		return things
+	}
+
+	c.JSON(http.StatusOK, []redditListing{
+		newRedditListing([]redditThing{h.toRedditLink(*post)}, nil),
+		newRedditListing(tree(0, 0), nil),
+	})
+}
+
+// rssFeedItems is how many recent posts RSS feeds include
+const rssFeedItems = 25
+
+// rssFeed is an RSS 2.0 document
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	AtomNS  string     `xml:"xmlns:atom,attr"`
+	DCNS    string     `xml:"xmlns:dc,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	Self          rssLink   `xml:"atom:link"`
+	LastBuildDate string    `xml:"lastBuildDate,omitempty"`
+	Items         []rssItem `xml:"item"`
+}
+
+// rssLink is the channel's atom:link to the feed itself, recommended by the
+// RSS Advisory Board and required by most validators
+type rssLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr"`
+	Type string `xml:"type,attr"`
+}
+
+type rssItem struct {
+	Title       string  `xml:"title"`
+	Description string  `xml:"description"`
+	Creator     string  `xml:"dc:creator"` // RSS's own author element must be an email address
+	Category    string  `xml:"category"`
+	GUID        rssGUID `xml:"guid"`
+	PubDate     string  `xml:"pubDate"`
+}
+
+type rssGUID struct {
+	Value       string `xml:",chardata"`
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+}
+
+// writeRSS renders posts as an RSS feed
+func (h *APIHandler) writeRSS(c *gin.Context, title, link, description string, posts []Post) {
+	channel := rssChannel{
+		Title:       title,
+		Link:        link,
+		Description: description,
+		Self: rssLink{
+			Href: h.publicURL + c.Request.URL.Path,
+			Rel:  "self",
+			Type: "application/rss+xml",
+		},
+		Items: []rssItem{},
+	}
+	if len(posts) > 0 {
+		channel.LastBuildDate = posts[0].CreatedAt.UTC().Format(time.RFC1123Z)
+	}
+	for _, post := range posts {
+		channel.Items = append(channel.Items, rssItem{
+			Title:       post.Title,
+			Description: post.Content,
+			Creator:     "u/" + post.AuthorUsername,
+			Category:    "r/" + post.SubredditName,
+			GUID:        rssGUID{Value: fmt.Sprintf("goreddit:post:%d", post.ID)},
+			PubDate:     post.CreatedAt.UTC().Format(time.RFC1123Z),
+		})
+	}
+
+	body, err := xml.MarshalIndent(rssFeed{
+		Version: "2.0",
+		AtomNS:  "http://www.w3.org/2005/Atom",
+		DCNS:    "http://purl.org/dc/elements/1.1/",
+		Channel: channel,
+	}, "", "  ")
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.Data(http.StatusOK, "application/rss+xml; charset=utf-8", append([]byte(xml.Header), body...))
+}
+
+// getSubredditRSS serves a subreddit's newest posts as an RSS feed, so feed
+// readers can follow it without an account
+func (h *APIHandler) getSubredditRSS(c *gin.Context) {
+	name := c.Param("name")
+	description, posts, err := h.dbFor(c).GetRecentSubredditPosts(name, rssFeedItems)
+	if err != nil {
+		c.Error(newAPIError(http.StatusNotFound, "Subreddit not found"))
+		return
+	}
+	if description == "" {
+		description = "Newest posts in r/" + name
+	}
+
+	h.writeRSS(c, "r/"+name, h.publicURL+"/r/"+url.PathEscape(name)+"/about", description, posts)
+}
+
+// getUserRSS serves a user's newest posts as an RSS feed
+func (h *APIHandler) getUserRSS(c *gin.Context) {
+	username := c.Param("username")
+	posts, err := h.dbFor(c).GetRecentUserPosts(username, rssFeedItems)
+	if err != nil {
+		c.Error(newAPIError(http.StatusNotFound, "User not found"))
+		return
+	}
+
+	h.writeRSS(c, "u/"+username, h.publicURL+"/users/"+url.PathEscape(username), "Newest posts by u/"+username, posts)
+}
+
+// getSubredditRules lists a subreddit's rules in order
+func (h *APIHandler) getSubredditRules(c *gin.Context) {
+	subredditID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.Error(newAPIError(http.StatusBadRequest, "Invalid subreddit ID"))
+		return
+	}
+
+	rules, err := h.dbFor(c).GetSubredditRules(subredditID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, rules)
+}
+
+// updateSubredditRules replaces a subreddit's rules with the ones given, in
+// order
+func (h *APIHandler) updateSubredditRules(c *gin.Context) {
+	subredditID, ok := h.moderatedSubreddit(c)
+	if !ok {
+		return
+	}
+
+	var req UpdateSubredditRulesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(withStatus(http.StatusBadRequest, err))
+		return
+	}
+
+	moderatorID, _ := strconv.Atoi(c.GetString("user_id"))
+	if err := h.dbFor(c).SetSubredditRules(subredditID, moderatorID, req.Rules); err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, req.Rules)
+}
+
+// getSubredditFeed lists a subreddit's posts ranked by the sort query
+// parameter, or by the subreddit's default ranking when none is given
+func (h *APIHandler) getSubredditFeed(c *gin.Context) {
+	posts, ok := h.rankedSubredditPosts(c, allPosts, 0)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, posts)
+}
+
+// getSubredditPosts pages through a subreddit's posts, ranked like its feed,
+// whether or not the user has joined it
+func (h *APIHandler) getSubredditPosts(c *gin.Context) {
+	limit, offset := parsePagination(c)
+	posts, ok := h.rankedSubredditPosts(c, limit+1, offset)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, newPostPage(posts, limit, offset))
+}
+
+// rankedSubredditPosts loads limit of the :id subreddit's posts from offset,
+// or all of them when limit is allPosts, ranked by ?sort= or the subreddit's
+// default ranking, pinned posts first. Newest-first pages are read by the
+// query; other rankings read every post to rank them. It responds with an
+// error and returns false when the subreddit doesn't exist or the ranking
+// fails.
+func (h *APIHandler) rankedSubredditPosts(c *gin.Context, limit, offset int) ([]Post, bool) {
+	subredditID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.Error(newAPIError(http.StatusBadRequest, "Invalid subreddit ID"))
+		return nil, false
+	}
+
+	settings, err := h.dbFor(c).GetSubredditSettings(subredditID)
+	if err != nil {
+		c.Error(newAPIError(http.StatusNotFound, "Subreddit not found"))
+		return nil, false
+	}
+
+	sortBy := c.DefaultQuery("sort", settings.DefaultSort)
+	if newestFirst(sortBy) {
+		posts, err := h.dbFor(c).GetSubredditPosts(subredditID, limit, offset)
+		if err != nil {
+			c.Error(err)
+			return nil, false
+		}
+		return posts, true
+	}
+
+	posts, err := h.dbFor(c).GetSubredditPosts(subredditID, allPosts, 0)
+	if err != nil {
+		c.Error(err)
+		return nil, false
+	}
+
+	params := RankingParams{HalfLifeHours: settings.HalfLifeHours}
+	if err := h.rankPosts(posts, sortBy, params); err != nil {
+		c.Error(withStatus(http.StatusBadRequest, err))
+		return nil, false
+	}
+
+	// Pinned posts stay at the top of the subreddit
+	sort.SliceStable(posts, func(i, j int) bool { return posts[i].Pinned && !posts[j].Pinned })
+
+	if limit != allPosts {
+		posts = paginatePosts(posts, limit, offset).Posts
+	}
+	return posts, true
+}
+
+// removeContent lets moderators remove a post or comment
+func (h *APIHandler) removeContent(c *gin.Context) {
+	subredditID, ok := h.moderatedSubreddit(c)
+	if !ok {
+		return
+	}
+
+	var req RemoveContentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(withStatus(http.StatusBadRequest, err))
+		return
+	}
+
+	moderatorID, _ := strconv.Atoi(c.GetString("user_id"))
+	if err := h.dbFor(c).RemoveContent(subredditID, moderatorID, req.TargetType, req.TargetID, req.Reason); err != nil {
+		c.Error(err)
+		return
+	}
+	h.publishNotifications()
+
+	c.JSON(http.StatusOK, gin.H{"message": "Content removed"})
+}
+
+// getSubredditBans lists the subreddit's active bans
+func (h *APIHandler) getSubredditBans(c *gin.Context) {
+	subredditID, ok := h.moderatedSubreddit(c)
+	if !ok {
+		return
+	}
+
+	bans, err := h.dbFor(c).GetSubredditBans(subredditID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, bans)
+}
+
+// banUser bans a user from posting and commenting in the subreddit
+func (h *APIHandler) banUser(c *gin.Context) {
+	subredditID, ok := h.moderatedSubreddit(c)
+	if !ok {
+		return
+	}
+
+	var req BanUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(withStatus(http.StatusBadRequest, err))
+		return
+	}
+
+	moderatorID, _ := strconv.Atoi(c.GetString("user_id"))
+	if err := h.dbFor(c).BanUser(subredditID, moderatorID, req.UserID, req.Reason, req.DurationDays); err != nil {
+		c.Error(err)
+		return
+	}
+	h.publishNotifications()
+
+	c.JSON(http.StatusOK, gin.H{"message": "User banned"})
+}
+
+// unbanUser lifts a user's ban
+func (h *APIHandler) unbanUser(c *gin.Context) {
+	subredditID, ok := h.moderatedSubreddit(c)
+	if !ok {
+		return
+	}
+
+	userID, err := strconv.Atoi(c.Param("user_id"))
+	if err != nil {
+		c.Error(newAPIError(http.StatusBadRequest, "Invalid user ID"))
+		return
+	}
+
+	moderatorID, _ := strconv.Atoi(c.GetString("user_id"))
+	if err := h.dbFor(c).UnbanUser(subredditID, moderatorID, userID); err != nil {
+		c.Error(err)
+		return
+	}
+	h.publishNotifications()
+
+	c.JSON(http.StatusOK, gin.H{"message": "User unbanned"})
+}
+
+// pinPost pins or unpins a post in the subreddit
+func (h *APIHandler) pinPost(c *gin.Context) {
+	subredditID, ok := h.moderatedSubreddit(c)
+	if !ok {
+		return
+	}
+
+	var req PinPostRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(withStatus(http.StatusBadRequest, err))
+		return
+	}
+
+	moderatorID, _ := strconv.Atoi(c.GetString("user_id"))
+	if err := h.dbFor(c).SetPostPinned(subredditID, moderatorID, req.PostID, req.Pinned); err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Post updated", "pinned": req.Pinned})
+}
+
+// setPostFlair changes the flair of a post in the subreddit
+func (h *APIHandler) setPostFlair(c *gin.Context) {
+	subredditID, ok := h.moderatedSubreddit(c)
+	if !ok {
+		return
+	}
+
+	var req SetFlairRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(withStatus(http.StatusBadRequest, err))
+		return
+	}
+
+	moderatorID, _ := strconv.Atoi(c.GetString("user_id"))
+	if err := h.dbFor(c).SetPostFlair(subredditID, moderatorID, req.PostID, req.Flair); err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Flair updated", "flair": req.Flair})
+}
+
+// getModLog lists the subreddit's moderation log, filtered by ?moderator= and ?action=
+func (h *APIHandler) getModLog(c *gin.Context) {
+	subredditID, ok := h.moderatedSubreddit(c)
+	if !ok {
+		return
+	}
+
+	limit := 100 // Default limit
+	if limitParam := c.Query("limit"); limitParam != "" {
+		if parsedLimit, err := strconv.Atoi(limitParam); err == nil && parsedLimit > 0 {
+			limit = parsedLimit
+		}
+	}
+
+	entries, err := h.dbFor(c).GetModLog(subredditID, c.Query("moderator"), c.Query("action"), limit)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, entries)
+}
+
+// getModWebhooks lists the subreddit's moderation webhooks
+func (h *APIHandler) getModWebhooks(c *gin.Context) {
+	subredditID, ok := h.moderatedSubreddit(c)
+	if !ok {
+		return
+	}
+
+	webhooks, err := h.dbFor(c).GetModWebhooks(subredditID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, webhooks)
+}
+
+// createModWebhook subscribes an external URL to the subreddit's moderation
+// events. The response includes the signing secret, which is not shown again.
+func (h *APIHandler) createModWebhook(c *gin.Context) {
+	subredditID, ok := h.moderatedSubreddit(c)
+	if !ok {
+		return
+	}
+
+	var req CreateModWebhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(withStatus(http.StatusBadRequest, err))
+		return
+	}
+
+	if err := checkOutboundURL(req.URL); err != nil {
+		c.Error(newAPIError(http.StatusBadRequest, "url "+err.Error()))
+		return
+	}
+
+	events := []string{}
+	seen := make(map[string]bool)
+	for _, event := range req.Events {
+		known := false
+		for _, modEvent := range modEvents {
+			known = known || event == modEvent
+		}
+		if !known {
+			c.Error(newAPIError(http.StatusBadRequest, "Unknown event: "+event))
+			return
+		}
+		if !seen[event] {
+			seen[event] = true
+			events = append(events, event)
+		}
+	}
+
+	userID, _ := strconv.Atoi(c.GetString("user_id"))
+	webhook, err := h.dbFor(c).CreateModWebhook(subredditID, userID, req.URL, events)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, webhook)
+}
+
+// deleteModWebhook unsubscribes a webhook
+func (h *APIHandler) deleteModWebhook(c *gin.Context) {
+	subredditID, ok := h.moderatedSubreddit(c)
+	if !ok {
+		return
+	}
+
+	webhookID, err := strconv.Atoi(c.Param("webhook_id"))
+	if err != nil {
+		c.Error(newAPIError(http.StatusBadRequest, "Invalid webhook ID"))
+		return
+	}
+
+	if err := h.dbFor(c).DeleteModWebhook(subredditID, webhookID); err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Webhook deleted"})
+}
+
+// getModWebhookDeliveries lists recent deliveries to a webhook with their
+// attempts and errors
+func (h *APIHandler) getModWebhookDeliveries(c *gin.Context) {
+	subredditID, ok := h.moderatedSubreddit(c)
+	if !ok {
+		return
+	}
+
+	webhookID, err := strconv.Atoi(c.Param("webhook_id"))
+	if err != nil {
+		c.Error(newAPIError(http.StatusBadRequest, "Invalid webhook ID"))
+		return
+	}
+
+	limit, _ := parsePagination(c)
+	deliveries, err := h.dbFor(c).GetModWebhookDeliveries(subredditID, webhookID, limit)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, deliveries)
+}
+
+// exportModLists downloads the subreddit's ban list and word filters as
+// JSON (default) or CSV with ?format=csv
+func (h *APIHandler) exportModLists(c *gin.Context) {
+	subredditID, ok := h.moderatedSubreddit(c)
+	if !ok {
+		return
+	}
+
+	lists, err := h.dbFor(c).ExportModLists(subredditID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	switch c.DefaultQuery("format", "json") {
+	case "json":
+		c.JSON(http.StatusOK, lists)
+	case "csv":
+		var buf bytes.Buffer
+		if err := writeModListsCSV(&buf, lists); err != nil {
+			c.Error(err)
+			return
+		}
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=subreddit-%d-modlists.csv", subredditID))
+		c.Data(http.StatusOK, "text/csv", buf.Bytes())
+	default:
+		c.Error(newAPIError(http.StatusBadRequest, "format must be json or csv"))
+	}
+}
+
+// importModLists merges another community's ban list and word filters into the
+// subreddit. The body is JSON, or CSV with ?format=csv; ?dry_run=true only validates.
+func (h *APIHandler) importModLists(c *gin.Context) {
+	subredditID, ok := h.moderatedSubreddit(c)
+	if !ok {
+		return
+	}
+
+	var lists ModLists
+	switch c.DefaultQuery("format", "json") {
+	case "json":
+		if err := c.ShouldBindJSON(&lists); err != nil {
+			c.Error(withStatus(http.StatusBadRequest, err))
+			return
+		}
+	case "csv":
+		parsed, err := readModListsCSV(c.Request.Body)
+		if err != nil {
+			c.Error(withStatus(http.StatusBadRequest, err))
+			return
+		}
+		lists = *parsed
+	default:
+		c.Error(newAPIError(http.StatusBadRequest, "format must be json or csv"))
+		return
+	}
+
+	moderatorID, _ := strconv.Atoi(c.GetString("user_id"))
+	report, err := h.dbFor(c).ImportModLists(subredditID, moderatorID, lists, c.Query("dry_run") == "true")
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+// Actor API handlers
+func (a *RequestProcessingActor) createPost(db *DatabaseManager, cmd CreatePostCommand) (PostCreated, error) {
+	postID, automod, err := db.CreatePost(cmd.Title, cmd.Content, cmd.UserID, cmd.SubredditID, cmd.Kind, cmd.CrosspostOf)
+	if err != nil {
+		return PostCreated{}, err
+	}
+
+	return PostCreated{PostID: postID, Title: cmd.Title, Automod: automod}, nil
+}
+
+func (a *RequestProcessingActor) createComment(db *DatabaseManager, cmd CreateCommentCommand) (CommentCreated, error) {
+	// Call database method to create comment
+	commentID, automod, err := db.CreateComment(
+		cmd.Content,
+		cmd.UserID,
+		cmd.PostID,
+		cmd.ParentCommentID,
+	)
+	if err != nil {
+		return CommentCreated{}, err
+	}
+
+	return CommentCreated{CommentID: commentID, Content: cmd.Content, Automod: automod}, nil
+}
+
+func (a *RequestProcessingActor) sendMessage(db *DatabaseManager, cmd SendMessageCommand) (MessageSent, error) {
+	// Call database method to send direct message
+	messageID, err := db.SendDirectMessage(
+		cmd.UserID,
+		cmd.ToUserID,
+		cmd.Content,
+	)
+	if err != nil {
+		return MessageSent{}, err
+	}
+
+	return MessageSent{MessageID: messageID, Content: cmd.Content}, nil
+}
+
+// Additional actor-based handlers for other complex operations
+
+func (a *RequestProcessingActor) joinSubreddit(db *DatabaseManager, cmd JoinSubredditCommand) (Acknowledged, error) {
+	if err := db.JoinSubreddit(cmd.UserID, cmd.SubredditID); err != nil {
+		return Acknowledged{}, err
+	}
+	return Acknowledged{Message: "Successfully joined subreddit"}, nil
+}
+
+func (a *RequestProcessingActor) leaveSubreddit(db *DatabaseManager, cmd LeaveSubredditCommand) (Acknowledged, error) {
+	if err := db.LeaveSubreddit(cmd.UserID, cmd.SubredditID); err != nil {
+		return Acknowledged{}, err
+	}
+	return Acknowledged{Message: "Successfully left subreddit"}, nil
+}
+
+func (a *RequestProcessingActor) createSubreddit(db *DatabaseManager, cmd CreateSubredditCommand) (SubredditCreated, error) {
+	// Call database method to create subreddit
+	subredditID, err := db.CreateSubreddit(
+		cmd.Name,
+		cmd.Description,
+		cmd.UserID,
+	)
+	if err != nil {
+		return SubredditCreated{}, err
+	}
+
+	return SubredditCreated{SubredditID: subredditID, Name: cmd.Name}, nil
+}
+
+func (a *RequestProcessingActor) vote(ac actor.Context, db *DatabaseManager, cmd VoteCommand) (Acknowledged, error) {
+	// Reject requests outside the timestamp window, since their nonces may
+	// already have been pruned
+	skew := time.Since(time.Unix(cmd.Timestamp, 0))
+	if skew > voteMaxClockSkew || skew < -voteMaxClockSkew {
+		a.handler.metrics.Inc(`goreddit_vote_replays_rejected_total{reason="stale"}`)
+		return Acknowledged{}, ErrStaleVote
+	}
+
+	// Votes on hot posts go through the post's aggregator
+	var err error
+	if aggregator := a.hotPostAggregator(ac, cmd); aggregator != nil {
+		err = aggregateVote(ac, aggregator, cmd)
+	} else {
+		err = db.Vote(
+			cmd.UserID,
+			cmd.TargetID,
+			cmd.TargetType,
+			cmd.Value,
+			cmd.Nonce,
+		)
+	}
+	if errors.Is(err, ErrVoteReplay) {
+		a.handler.metrics.Inc(`goreddit_vote_replays_rejected_total{reason="nonce"}`)
+	}
+	if err != nil {
+		return Acknowledged{}, err
+	}
+
+	return Acknowledged{Message: "Vote recorded successfully"}, nil
+}
+
+func (a *RequestProcessingActor) voteBatch(db *DatabaseManager, cmd VoteBatchCommand) (Acknowledged, error) {
+	// Reject the batch if any vote is outside the timestamp window, as for
+	// single votes
+	for i, vote := range cmd.Votes {
+		skew := time.Since(time.Unix(vote.Timestamp, 0))
+		if skew > voteMaxClockSkew || skew < -voteMaxClockSkew {
+			a.handler.metrics.Inc(`goreddit_vote_replays_rejected_total{reason="stale"}`)
+			return Acknowledged{}, fmt.Errorf("vote %d: %w", i, ErrStaleVote)
+		}
+	}
+
+	err := db.VoteBatch(cmd.UserID, cmd.Votes)
+	if errors.Is(err, ErrVoteReplay) {
+		a.handler.metrics.Inc(`goreddit_vote_replays_rejected_total{reason="nonce"}`)
+	}
+	if err != nil {
+		return Acknowledged{}, err
+	}
+
+	return Acknowledged{Message: "Votes recorded successfully"}, nil
+}
+
+// Event is pushed to a user's real-time connections as something happens
+type Event struct {
+	Type      string      `json:"type"` // a notification type, vote_milestone or comment
+	Data      interface{} `json:"data"`
+	CreatedAt time.Time   `json:"created_at"`
+}
+
+// eventBufferSize is how many events a subscriber can fall behind by before
+// it is dropped
+const eventBufferSize = 64
+
+// Subscription receives the events published to one topic until it is
+// closed. Events is closed when the subscriber falls too far behind.
+type Subscription struct {
+	Topic  string
+	Events chan Event
+	hub    *EventHub
+}
+
+// userTopic is the topic of a user's own events
+func userTopic(userID int) string {
+	return fmt.Sprintf("user:%d", userID)
+}
+
+// postTopic is the topic of new comments on a post
+func postTopic(postID int) string {
+	return fmt.Sprintf("post:%d", postID)
+}
+
+// Close stops the subscription
+func (s *Subscription) Close() {
+	s.hub.unsubscribe(s)
+}
+
+// EventHub is an in-process pub/sub hub fanning events out to real-time
+// connections by topic, such as a user's WebSocket or a post's comment
+// stream. Write paths publish to it after their changes are committed.
+type EventHub struct {
+	mu          sync.Mutex
+	subscribers map[string]map[*Subscription]bool
+	count       int
+}
+
+func NewEventHub() *EventHub {
+	return &EventHub{subscribers: make(map[string]map[*Subscription]bool)}
+}
+
+// Subscribe starts receiving a topic's events
+func (hub *EventHub) Subscribe(topic string) *Subscription {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	sub := &Subscription{Topic: topic, Events: make(chan Event, eventBufferSize), hub: hub}
+	if hub.subscribers[topic] == nil {
+		hub.subscribers[topic] = make(map[*Subscription]bool)
+	}
+	hub.subscribers[topic][sub] = true
+	hub.count++
+	return sub
+}
+
+func (hub *EventHub) unsubscribe(sub *Subscription) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	hub.remove(sub)
+}
+
+// remove drops a subscription and closes its channel. hub.mu must be held.
+func (hub *EventHub) remove(sub *Subscription) {
+	subs := hub.subscribers[sub.Topic]
+	if !subs[sub] {
+		return
+	}
+	delete(subs, sub)
+	if len(subs) == 0 {
+		delete(hub.subscribers, sub.Topic)
+	}
+	hub.count--
+	close(sub.Events)
+}
+
+// Publish sends an event to every subscriber of the topic and returns how
+// many it reached. It never blocks: subscribers whose buffer is full are
+// dropped and have to reconnect.
+func (hub *EventHub) Publish(topic, eventType string, data interface{}) int {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	event := Event{Type: eventType, Data: data, CreatedAt: time.Now().UTC()}
+	sent := 0
+	for sub := range hub.subscribers[topic] {
+		select {
+		case sub.Events <- event:
+			sent++
+		default:
+			logAt(logWarn, "Dropping slow event subscriber of %s", topic)
+			hub.remove(sub)
+		}
+	}
+	return sent
+}
+
+// Connections returns the number of open subscriptions across all topics
+func (hub *EventHub) Connections() int {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	return hub.count
+}
+
+// voteMilestones are the scores at which an author is told about a post or
+// comment doing well
+var voteMilestones = []int{10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000}
+
+// isVoteMilestone reports whether a score is one of voteMilestones
+func isVoteMilestone(score int) bool {
+	for _, milestone := range voteMilestones {
+		if score == milestone {
+			return true
+		}
+	}
+	return false
+}
+
+// publishNotifications pushes the notifications waiting for push delivery to
+// their recipients' real-time connections, and marks those that reached one
+// as sent. The rest wait, up to pushRetention, for their recipient to
+// connect. Write paths that notify users call it once their change is
+// committed, and new connections call it to catch up.
+func (h *APIHandler) publishNotifications() {
+	h.pushMu.Lock()
+	defer h.pushMu.Unlock()
+
+	notifications, err := h.db.PendingPushes()
+	if err != nil {
+		logAt(logWarn, "Failed to load notifications to publish: %v", err)
+		return
+	}
+	var sent []int
+	for _, n := range notifications {
+		if h.hub.Publish(userTopic(n.UserID), n.Type, n.Notification) > 0 {
+			sent = append(sent, n.ID)
+		}
+	}
+	if len(sent) == 0 {
+		return
+	}
+	if err := h.db.MarkPushesSent(sent); err != nil {
+		logAt(logWarn, "Failed to mark notifications pushed: %v", err)
+	}
+}
+
+// publishVoteMilestone tells the author of a post or comment when an upvote
+// takes its score to a milestone
+func (h *APIHandler) publishVoteMilestone(voterID, targetID int, targetType string) {
+	authorID, score, err := h.db.GetVoteScore(targetID, targetType)
+	if err != nil {
+		logAt(logWarn, "Failed to load vote score: %v", err)
+		return
+	}
+	if authorID == voterID || !isVoteMilestone(score) {
+		return
+	}
+	h.hub.Publish(userTopic(authorID), "vote_milestone", gin.H{
+		"target_id":   targetID,
+		"target_type": targetType,
+		"score":       score,
+	})
+}
+
+// WebSocket connection timings
+const (
+	wsWriteWait  = 10 * time.Second
+	wsPongWait   = 60 * time.Second
+	wsPingPeriod = wsPongWait * 9 / 10
+)
+
+// wsUpgrader is copied by serveWebSocket, which checks the origin
+var wsUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+// checkWebSocketOrigin lets browsers open WebSockets only from the origin of
+// the public URL or one of allowed_origins, so other sites can't connect
+// with a visitor's credentials. Requests without an Origin header don't come
+// from browsers and are let through.
+func (h *APIHandler) checkWebSocketOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if strings.EqualFold(origin, originOf(h.publicURL)) {
+		return true
+	}
+	for _, allowed := range h.allowedOrigins {
+		if strings.EqualFold(origin, allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// originOf returns the origin of a URL, e.g. https://example.com for
+// https://example.com/r/golang
+func originOf(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+// serveWebSocket upgrades the request to a WebSocket and pushes the current
+// user's events to it as JSON messages until either side closes it. Messages
+// sent by the client are ignored.
+func (h *APIHandler) serveWebSocket(c *gin.Context) {
+	userID, _ := strconv.Atoi(c.GetString("user_id"))
+	upgrader := wsUpgrader
+	upgrader.CheckOrigin = h.checkWebSocketOrigin
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// The upgrader has already responded
+		return
+	}
+	defer conn.Close()
+
+	sub := h.hub.Subscribe(userTopic(userID))
+	defer func() {
+		sub.Close()
+		h.metrics.Set("goreddit_event_subscribers", float64(h.hub.Connections()))
+	}()
+	h.metrics.Set("goreddit_event_subscribers", float64(h.hub.Connections()))
+
+	// Deliver the pushes that were waiting for the user to connect
+	h.publishNotifications()
+
+	// Read until the client goes away, answering pings and tracking pongs
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		conn.SetReadLimit(512)
+		conn.SetReadDeadline(time.Now().Add(wsPongWait))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(wsPongWait))
+		})
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(wsPingPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case event, ok := <-sub.Events:
+			conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if !ok {
+				conn.WriteMessage(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "too slow"))
+				return
+			}
+			if err := conn.WriteJSON(event); err != nil {
+				return
+			}
+		case <-ticker.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteWait)); err != nil {
+				return
+			}
+		case <-closed:
+			return
+		}
+	}
+}
+
+// sseKeepAlive is how often an idle event stream sends a comment line, so
+// proxies don't time the connection out
+const sseKeepAlive = 15 * time.Second
+
+// streamPostComments streams the comments added to a post as Server-Sent
+// Events, one "comment" event per comment with the comment ID as the event
+// ID. A client reconnecting with Last-Event-ID first receives the comments it
+// missed; otherwise the stream starts with the next new comment.
+func (h *APIHandler) streamPostComments(c *gin.Context) {
+	postID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.Error(newAPIError(http.StatusBadRequest, "Invalid post ID"))
+		return
+	}
+	userID, _ := strconv.Atoi(c.GetString("user_id"))
+
+	// Subscribe before looking up the latest comment so none slip between
+	sub := h.hub.Subscribe(postTopic(postID))
+	defer func() {
+		sub.Close()
+		h.metrics.Set("goreddit_event_subscribers", float64(h.hub.Connections()))
+	}()
+	h.metrics.Set("goreddit_event_subscribers", float64(h.hub.Connections()))
+
+	lastID, err := h.dbFor(c).LatestCommentID(postID)
+	if err != nil {
+		c.Error(newAPIError(http.StatusNotFound, "Post not found"))
+		return
+	}
+	resume := false
+	if id, err := strconv.Atoi(c.GetHeader("Last-Event-ID")); err == nil && id >= 0 && id < lastID {
+		lastID, resume = id, true
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	// sendNew writes every comment after lastID, reporting false once the
+	// client is gone
+	sendNew := func() bool {
+		for {
+			comments, err := h.dbFor(c).GetCommentsSince(postID, lastID, userID)
+			if err != nil {
+				logAt(logWarn, "Failed to load comments to stream: %v", err)
+				return true
+			}
+			for _, comment := range comments {
+				data, err := json.Marshal(comment)
+				if err != nil {
+					return true
+				}
+				if _, err := fmt.Fprintf(c.Writer, "id: %d\nevent: comment\ndata: %s\n\n", comment.ID, data); err != nil {
+					return false
+				}
+				lastID = comment.ID
+			}
+			c.Writer.Flush()
+			if len(comments) < maxCommentsSince {
+				return true
+			}
+		}
+	}
+
+	if resume && !sendNew() {
+		return
+	}
+
+	ticker := time.NewTicker(sseKeepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case _, ok := <-sub.Events:
+			if !ok || !sendNew() {
+				return
+			}
+		case <-ticker.C:
+			if _, err := io.WriteString(c.Writer, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			c.Writer.Flush()
+		case <-c.Request.Context().Done():
+			return
+		}
+	}
+}
+
+// Trending job settings
+const (
+	trendingInterval   = 5 * time.Minute
+	trendingWindow     = 24 * time.Hour
+	trendingTopicLimit = 50
+)
+
+// Moderation webhook delivery settings
+const (
+	webhookPollInterval  = 5 * time.Second
+	webhookTimeout       = 10 * time.Second
+	webhookMaxAttempts   = 8
+	webhookBaseBackoff   = 30 * time.Second
+	webhookMaxBackoff    = 6 * time.Hour
+	webhookDeliveryBatch = 50
+)
+
+// signModWebhook computes the signature sent in X-GoReddit-Signature: an
+// HMAC-SHA256 of the timestamp, a dot and the body, keyed by the webhook
+// secret. Receivers should recompute it and reject stale timestamps.
+func signModWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// deliverModWebhook POSTs one event to its webhook, failing on any non-2xx response
+func deliverModWebhook(client *http.Client, d DueModWebhookDelivery) error {
+	body := []byte(d.Payload)
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req, err := http.NewRequest(http.MethodPost, d.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-GoReddit-Event", d.Event)
+	req.Header.Set("X-GoReddit-Delivery", strconv.Itoa(d.ID))
+	req.Header.Set("X-GoReddit-Timestamp", timestamp)
+	req.Header.Set("X-GoReddit-Signature", signModWebhook(d.Secret, timestamp, body))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with %s", resp.Status)
+	}
+	return nil
+}
+
+// webhookBackoff is how long to wait before retrying after the given number of
+// failed attempts, doubling each time up to webhookMaxBackoff
+func webhookBackoff(attempts int) time.Duration {
+	backoff := webhookBaseBackoff
+	for i := 1; i < attempts && backoff < webhookMaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > webhookMaxBackoff {
+		backoff = webhookMaxBackoff
+	}
+	return backoff
+}
+
+// runModWebhookJob delivers queued moderation webhook events until the process
+// exits, retrying failures with exponential backoff
+func runModWebhookJob(h *APIHandler) {
+	client := newOutboundClient(webhookTimeout)
+	ticker := time.NewTicker(webhookPollInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		due, err := h.db.DueModWebhookDeliveries(webhookDeliveryBatch)
+		if err != nil {
+			log.Printf("Webhook delivery job failed: %v", err)
+			continue
+		}
+
+		for _, d := range due {
+			deliveryErr := deliverModWebhook(client, d)
+
+			var retryAfter time.Duration
+			if deliveryErr != nil {
+				h.metrics.Inc(`goreddit_mod_webhook_deliveries_total{result="error"}`)
+				if d.Attempts+1 < webhookMaxAttempts {
+					retryAfter = webhookBackoff(d.Attempts + 1)
+				}
+			} else {
+				h.metrics.Inc(`goreddit_mod_webhook_deliveries_total{result="ok"}`)
+			}
+
+			if err := h.db.RecordModWebhookAttempt(d.ID, deliveryErr, retryAfter); err != nil {
+				log.Printf("Webhook delivery job failed: %v", err)
+			}
+		}
+	}
+}
+
+// Email delivery settings
+const (
+	emailPollInterval = 15 * time.Second
+	emailMaxAttempts  = 6
+	emailBaseBackoff  = time.Minute
+	emailMaxBackoff   = 6 * time.Hour
+	emailSendBatch    = 50
+)
+
+// Mailer sends plain-text emails
+type Mailer interface {
+	Send(to, subject, body string) error
+}
+
+// smtpMailer sends email through an SMTP relay
+type smtpMailer struct {
+	addr     string
+	from     string // From header, e.g. "GoReddit <no-reply@example.com>"
+	envelope string // bare address used as the SMTP sender
+	auth     smtp.Auth
+}
+
+func (m *smtpMailer) Send(to, subject, body string) error {
+	// Subjects are built from user content, so strip anything that could
+	// start a new header
+	subject = strings.NewReplacer("\r", " ", "\n", " ").Replace(subject)
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", m.from)
+	fmt.Fprintf(&msg, "To: %s\r\n", to)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+
+	return smtp.SendMail(m.addr, m.auth, m.envelope, []string{to}, msg.Bytes())
+}
+
+// logMailer logs emails instead of sending them, for development setups
+// without an SMTP relay
+type logMailer struct{}
+
+func (logMailer) Send(to, subject, body string) error {
+	log.Printf("Email to %s: %s\n%s", to, subject, body)
+	return nil
+}
+
+// newMailerFromEnv builds the mailer configured by SMTP_HOST, SMTP_PORT,
+// SMTP_USERNAME, SMTP_PASSWORD and SMTP_FROM. Without SMTP_HOST emails are
+// only logged.
+func newMailerFromEnv() (Mailer, error) {
+	host := os.Getenv("SMTP_HOST")
+	if host == "" {
+		return logMailer{}, nil
+	}
+
+	port := "587"
+	if value := os.Getenv("SMTP_PORT"); value != "" {
+		if _, err := strconv.Atoi(value); err != nil {
+			return nil, fmt.Errorf("invalid SMTP_PORT: %q", value)
+		}
+		port = value
+	}
+
+	from := os.Getenv("SMTP_FROM")
+	if from == "" {
+		return nil, fmt.Errorf("SMTP_FROM is required with SMTP_HOST")
+	}
+	sender, err := mail.ParseAddress(from)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SMTP_FROM: %v", err)
+	}
+
+	m := &smtpMailer{addr: host + ":" + port, from: sender.String(), envelope: sender.Address}
+	if username := os.Getenv("SMTP_USERNAME"); username != "" {
+		m.auth = smtp.PlainAuth("", username, os.Getenv("SMTP_PASSWORD"), host)
+	}
+	return m, nil
+}
+
+// describeNotification is the one-line text of a notification used in emails
+func describeNotification(n Notification) string {
+	actor := "u/" + n.ActorUsername
+	var text string
+	switch n.Type {
+	case "reply":
+		text = actor + " replied to you"
+	case "mention":
+		text = actor + " mentioned you"
+	case "message":
+		text = actor + " sent you a message"
+	case "chat_invite":
+		text = actor + " added you to a chat room"
+	case "chat_message":
+		text = actor + " posted in a chat room you're in"
+	case "follow":
+		text = actor + " started following you"
+	case "mod_action":
+		text = "Moderator " + actor + " took action on your content"
+	case "award":
+		text = actor + " gave you an award"
+	default:
+		text = actor + " did something (" + n.Type + ")"
+	}
+
+	switch {
+	case n.CommentID != nil:
+		text += fmt.Sprintf(" (comment %d)", *n.CommentID)
+	case n.PostID != nil:
+		text += fmt.Sprintf(" (post %d)", *n.PostID)
+	case n.ChatRoomID != nil:
+		text += fmt.Sprintf(" (chat room %d)", *n.ChatRoomID)
+	}
+	return text
+}
+
+// renderNotificationEmail renders the email sent for a single notification
+func renderNotificationEmail(n Notification) (string, string) {
+	text := describeNotification(n)
+	body := text + "\n\nYou can change which notifications are emailed to you with PUT /notifications/preferences.\n"
+	return text, body
+}
+
+// renderDigestEmail renders a digest of the notifications received since the
+// last one
+func renderDigestEmail(digest string, notifications []Notification) (string, string) {
+	subject := fmt.Sprintf("Your %s GoReddit digest: %d notifications", digest, len(notifications))
+
+	var body strings.Builder
+	for _, n := range notifications {
+		fmt.Fprintf(&body, "- %s, %s\n", describeNotification(n), n.CreatedAt.UTC().Format("Jan 2 15:04 UTC"))
+	}
+	body.WriteString("\nYou can change your digest frequency with PUT /users/me/email.\n")
+	return subject, body.String()
+}
+
+// renderVerificationEmail renders the email asking a user to confirm their
+// address
+func renderVerificationEmail(link string) (string, string) {
+	body := fmt.Sprintf("Confirm your email address by opening this link within %d hours:\n\n%s\n\n"+
+		"If you didn't ask for this, you can ignore this email.\n", int(emailVerificationTTL.Hours()), link)
+	return "Confirm your GoReddit email address", body
+}
+
+// emailBackoff is how long to wait before retrying an email after the given
+// number of failed attempts, doubling each time up to emailMaxBackoff
+func emailBackoff(attempts int) time.Duration {
+	backoff := emailBaseBackoff
+	for i := 1; i < attempts && backoff < emailMaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > emailMaxBackoff {
+		backoff = emailMaxBackoff
+	}
+	return backoff
+}
+
+// runEmailJob sends queued emails until the process exits. Emails for
+// notifications are queued by the notification_emails job.
+func runEmailJob(h *APIHandler) {
+	ticker := time.NewTicker(emailPollInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		due, err := h.db.DueEmails(emailSendBatch)
+		if err != nil {
+			log.Printf("Email job failed: %v", err)
+			continue
+		}
+
+		for _, e := range due {
+			sendErr := h.mailer.Send(e.To, e.Subject, e.Body)
+
+			var retryAfter time.Duration
+			if sendErr != nil {
+				h.metrics.Inc(`goreddit_emails_total{result="error"}`)
+				if e.Attempts+1 < emailMaxAttempts {
+					retryAfter = emailBackoff(e.Attempts + 1)
+				}
+			} else {
+				h.metrics.Inc(`goreddit_emails_total{result="ok"}`)
+			}
+
+			if err := h.db.RecordEmailAttempt(e.ID, sendErr, retryAfter); err != nil {
+				log.Printf("Email job failed: %v", err)
+			}
+		}
+	}
+}
+
+// Standby keeps a rolling set of consistent database snapshots in a standby
+// directory, ideally on another disk or a network mount, so a single-node
+// deployment can be restored after losing its disk
+type Standby struct {
+	dir    string
+	retain int
+
+	mu     sync.Mutex
+	status StandbyStatus
+}
+
+// StandbyStatus is the outcome of the most recent snapshot
+type StandbyStatus struct {
+	Dir          string     `json:"dir"`
+	LastSnapshot string     `json:"last_snapshot,omitempty"`
+	LastSuccess  *time.Time `json:"last_success,omitempty"`
+	LastError    string     `json:"last_error,omitempty"`
+}
+
+// standbyLatestFile names the newest snapshot in a standby directory
+const standbyLatestFile = "LATEST"
+
+func NewStandby(dir string, retain int) (*Standby, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create standby directory: %v", err)
+	}
+	return &Standby{dir: dir, retain: retain, status: StandbyStatus{Dir: dir}}, nil
+}
+
+// SnapshotTo writes a consistent copy of the database to path
+func (dm *DatabaseManager) SnapshotTo(path string) error {
+	defer dm.span("SnapshotTo").End()
+	dm.mu.RLock()
+	defer dm.mu.RUnlock()
+
+	if _, err := dm.db.Exec(`VACUUM INTO ?`, path); err != nil {
+		return fmt.Errorf("failed to snapshot database: %v", err)
+	}
+	return nil
+}
+
+// Ship writes a new snapshot to the standby directory, points LATEST at it and
+// prunes snapshots beyond the retention count. Files are written under a
+// temporary name and renamed, so the standby never sees a partial snapshot.
+func (s *Standby) Ship(dm *DatabaseManager) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	name, err := s.ship(dm)
+	if err != nil {
+		s.status.LastError = err.Error()
+		return "", err
+	}
+
+	now := time.Now()
+	s.status.LastSnapshot = name
+	s.status.LastSuccess = &now
+	s.status.LastError = ""
+	return name, nil
+}
+
+func (s *Standby) ship(dm *DatabaseManager) (string, error) {
+	name := "snapshot-" + time.Now().UTC().Format("20060102T150405.000Z") + ".db"
+	path := filepath.Join(s.dir, name)
+
+	if err := dm.SnapshotTo(path + ".tmp"); err != nil {
+		os.Remove(path + ".tmp")
+		return "", err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return "", fmt.Errorf("failed to store snapshot: %v", err)
+	}
+
+	latest := filepath.Join(s.dir, standbyLatestFile)
+	if err := os.WriteFile(latest+".tmp", []byte(name+"\n"), 0o644); err != nil {
+		return "", fmt.Errorf("failed to update %s: %v", standbyLatestFile, err)
+	}
+	if err := os.Rename(latest+".tmp", latest); err != nil {
+		return "", fmt.Errorf("failed to update %s: %v", standbyLatestFile, err)
+	}
+
+	snapshots, err := listSnapshots(s.dir)
+	if err != nil {
+		return "", err
+	}
+	for len(snapshots) > s.retain {
+		if err := os.Remove(filepath.Join(s.dir, snapshots[0])); err != nil {
+			return "", fmt.Errorf("failed to prune snapshot: %v", err)
+		}
+		snapshots = snapshots[1:]
+	}
+
+	return name, nil
+}
+
+// Status returns the outcome of the most recent snapshot
+func (s *Standby) Status() StandbyStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.status
+}
+
+// listSnapshots returns the snapshot files in a standby directory, oldest first
+func listSnapshots(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read standby directory: %v", err)
+	}
+
+	var snapshots []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, "snapshot-") && strings.HasSuffix(name, ".db") {
+			snapshots = append(snapshots, name)
+		}
+	}
+	// Timestamps in the names sort chronologically
+	sort.Strings(snapshots)
+	return snapshots, nil
+}
+
+// latestSnapshot returns the snapshot LATEST points at
+func latestSnapshot(dir string) (string, error) {
+	data, err := os.ReadFile(filepath.Join(dir, standbyLatestFile))
+	if err != nil {
+		return "", fmt.Errorf("no snapshot has been shipped to %s: %v", dir, err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// restoreSnapshot checks a snapshot's integrity and copies it into place as
+// the live database. An existing database is only replaced when force is set.
+func restoreSnapshot(snapshotPath, dbPath string, force bool) error {
+	if _, err := os.Stat(dbPath); err == nil && !force {
+		return fmt.Errorf("%s already exists; pass -force to replace it", dbPath)
+	}
+
+	db, err := sql.Open("sqlite", snapshotPath)
+	if err != nil {
+		return fmt.Errorf("failed to open snapshot: %v", err)
+	}
+	var result string
+	err = db.QueryRow(`PRAGMA integrity_check`).Scan(&result)
+	db.Close()
+	if err != nil {
+		return fmt.Errorf("failed to check snapshot: %v", err)
+	}
+	if result != "ok" {
+		return fmt.Errorf("snapshot failed integrity check: %s", result)
+	}
+
+	src, err := os.Open(snapshotPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.Create(dbPath + ".restore")
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		os.Remove(dbPath + ".restore")
+		return fmt.Errorf("failed to copy snapshot: %v", err)
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+
+	// Drop any journal or WAL left by the old database so it isn't replayed
+	// over the restored one
+	for _, suffix := range []string{"-journal", "-wal", "-shm"} {
+		os.Remove(dbPath + suffix)
+	}
+	return os.Rename(dbPath+".restore", dbPath)
+}
+
+// runRepairCommentsCommand implements the "repair-comments" admin command:
+//
+//	go run main.go repair-comments [-mode reparent|flag] [-dry-run] [-db reddit_clone.db]
+func runRepairCommentsCommand(args []string) error {
+	fs := flag.NewFlagSet("repair-comments", flag.ExitOnError)
+	mode := fs.String("mode", "reparent", "reparent broken comments to top level, or flag them for moderators")
+	dryRun := fs.Bool("dry-run", false, "report problems without changing anything")
+	dbPath := fs.String("db", config.Default().DatabasePath, "database file to repair")
+	fs.Parse(args)
+
+	dm, err := InitDatabase(*dbPath)
+	if err != nil {
+		return err
+	}
+	defer dm.Close()
+
+	report, err := dm.RepairCommentThreads(*mode, *dryRun)
+	if err != nil {
+		return err
+	}
+
+	for _, issue := range report.Issues {
+		parent := "none"
+		if issue.ParentCommentID != nil {
+			parent = strconv.Itoa(*issue.ParentCommentID)
+		}
+		fmt.Printf("comment %d (post %d, parent %s): %s -> %s\n",
+			issue.CommentID, issue.PostID, parent, issue.Problem, issue.Fix)
+	}
+
+	verb := "fixed"
+	if report.DryRun {
+		verb = "would fix"
+	}
+	fmt.Printf("Checked %d comments: %d problems, %s %d\n", report.Checked, len(report.Issues), verb, report.Fixed)
+	return nil
+}
+
+// runStandbyCommand implements the "standby" admin command:
+//
+//	go run main.go standby list -dir /mnt/standby
+//	go run main.go standby restore -dir /mnt/standby [-snapshot NAME] [-db reddit_clone.db] [-force]
+func runStandbyCommand(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: standby list|restore [flags]")
+	}
+
+	fs := flag.NewFlagSet("standby "+args[0], flag.ExitOnError)
+	dir := fs.String("dir", os.Getenv("STANDBY_DIR"), "standby directory")
+	dbPath := fs.String("db", config.Default().DatabasePath, "database file to restore into")
+	snapshot := fs.String("snapshot", "", "snapshot to restore (defaults to the latest)")
+	force := fs.Bool("force", false, "replace an existing database")
+	fs.Parse(args[1:])
+
+	if *dir == "" {
+		return fmt.Errorf("-dir or STANDBY_DIR is required")
+	}
+
+	switch args[0] {
+	case "list":
+		snapshots, err := listSnapshots(*dir)
+		if err != nil {
+			return err
+		}
+		latest, _ := latestSnapshot(*dir)
+		for _, name := range snapshots {
+			marker := ""
+			if name == latest {
+				marker = " (latest)"
+			}
+			fmt.Printf("%s%s\n", name, marker)
+		}
+		return nil
+
+	case "restore":
+		name := *snapshot
+		if name == "" {
+			var err error
+			if name, err = latestSnapshot(*dir); err != nil {
+				return err
+			}
+		}
+		if err := restoreSnapshot(filepath.Join(*dir, name), *dbPath, *force); err != nil {
+			return err
+		}
+		fmt.Printf("Restored %s to %s\n", name, *dbPath)
+		return nil
+
+	default:
+		return fmt.Errorf("unknown standby command %q", args[0])
+	}
+}
+
+// runStandbyJob ships a snapshot to the standby every interval until the
+// process exits
+func runStandbyJob(h *APIHandler, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		h.shipSnapshot()
+	}
+}
+
+// maintenanceHour is the local hour of the daily low-traffic maintenance window
+const maintenanceHour = 4
+
+// main function - code invocation starts from here
+func main() {
+	// Admin commands run instead of the server
+	if len(os.Args) > 1 && os.Args[1] == "version" {
+		fmt.Println(buildinfo.String("goreddit-server"))
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "openapi" {
+		if err := runOpenAPICommand(); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "standby" {
+		if err := runStandbyCommand(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "repair-comments" {
+		if err := runRepairCommentsCommand(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// Startup settings come from defaults, a YAML file, the environment and
+	// flags, and are checked before anything starts
+	cfg, err := config.Load(os.Args[1:], os.Getenv)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	db, err := InitDatabase(cfg.DatabasePath)
+	if err != nil {
+		log.Fatalf("Failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	server, err := NewServer(cfg, db)
+	if err != nil {
+		log.Fatalf("Failed to initialize server: %v", err)
+	}
+
+	// Spans are exported when an OTLP endpoint is configured
+	shutdownTracing, err := initTracing(context.Background())
+	if err != nil {
+		log.Fatalf("Failed to set up tracing: %v", err)
+	}
+	defer shutdownTracing(context.Background())
+
+	// In cluster mode writes are processed by the worker nodes, which serve
+	// nothing else
+	if cfg.Cluster.Role != "" {
+		workers, leaveCluster, err := startCluster(server.system, server.Handler, cfg.Cluster, cfg.ActorRequestTimeout)
+		if err != nil {
+			log.Fatalf("Failed to join cluster: %v", err)
+		}
+		defer leaveCluster()
+
+		if cfg.Cluster.Role == config.ClusterWorker {
+			log.Printf("Cluster worker %s joined %s", cfg.Cluster.Addr, cfg.Cluster.Name)
+			stop := make(chan os.Signal, 1)
+			signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+			<-stop
+			return
+		}
+		server.Pool.cluster = workers
+	}
+
+	if err := server.Start(); err != nil {
+		log.Fatal(err)
+	}
+	defer server.Close()
+
+	// Start running the backend server, on port 8080 by default
+	if err := listenAndServe(cfg, server); err != nil {
+		log.Printf("Server stopped: %v", err)
+	}
+}
@@ -0,0 +1,590 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Subreddit mirrors
+//
+// A mirror copies a local subreddit's new posts to a subreddit on another
+// GoReddit instance through its REST API, posting as the remote user whose
+// session token it was set up with. Mirrors that pull comments also copy the
+// comments made on the remote copies back onto the local posts, as the
+// moderator who set the mirror up. This makes it easy to run multi-instance
+// topologies with the simulator.
+
+// Mirror sync settings
+const (
+	mirrorSyncInterval  = 30 * time.Second
+	mirrorTimeout       = 10 * time.Second
+	mirrorPostBatch     = 20             // posts pushed per mirror per run
+	mirrorCommentWindow = 48 * time.Hour // how long comments are pulled for after a post is mirrored
+)
+
+// SubredditMirror copies a subreddit's posts to another instance
+type SubredditMirror struct {
+	ID                int        `json:"id"`
+	SubredditID       int        `json:"subreddit_id"`
+	RemoteURL         string     `json:"remote_url"`
+	RemoteToken       string     `json:"-"`
+	RemoteSubredditID int        `json:"remote_subreddit_id"`
+	PullComments      bool       `json:"pull_comments"`
+	LastPostID        int        `json:"last_post_id"` // newest local post mirrored
+	LastSyncedAt      *time.Time `json:"last_synced_at"`
+	LastError         *string    `json:"last_error"`
+	CreatedBy         int        `json:"created_by"`
+	CreatedAt         time.Time  `json:"created_at"`
+}
+
+const mirrorColumns = `
+	id, subreddit_id, remote_url, remote_token, remote_subreddit_id, pull_comments,
+	last_post_id, last_synced_at, last_error, created_by, created_at
+`
+
+func scanMirrors(rows *sql.Rows) ([]SubredditMirror, error) {
+	mirrors := []SubredditMirror{}
+	for rows.Next() {
+		var m SubredditMirror
+		err := rows.Scan(&m.ID, &m.SubredditID, &m.RemoteURL, &m.RemoteToken, &m.RemoteSubredditID,
+			&m.PullComments, &m.LastPostID, &m.LastSyncedAt, &m.LastError, &m.CreatedBy, &m.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		mirrors = append(mirrors, m)
+	}
+	return mirrors, rows.Err()
+}
+
+// CreateSubredditMirror sets up a mirror of a subreddit. Only posts made
+// from now on are mirrored.
+func (dm *DatabaseManager) CreateSubredditMirror(m SubredditMirror) (*SubredditMirror, error) {
+	defer dm.span("CreateSubredditMirror").End()
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	err := dm.db.QueryRow(`SELECT COALESCE(MAX(id), 0) FROM posts WHERE subreddit_id = ?`, m.SubredditID).Scan(&m.LastPostID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create mirror: %v", err)
+	}
+
+	result, err := dm.db.Exec(`
+		INSERT INTO subreddit_mirrors (subreddit_id, remote_url, remote_token, remote_subreddit_id, pull_comments, last_post_id, created_by)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, m.SubredditID, m.RemoteURL, m.RemoteToken, m.RemoteSubredditID, m.PullComments, m.LastPostID, m.CreatedBy)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create mirror: %v", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	m.ID = int(id)
+	m.CreatedAt = time.Now()
+	return &m, nil
+}
+
+// GetSubredditMirrors lists a subreddit's mirrors, or every mirror when
+// subredditID is 0
+func (dm *DatabaseManager) GetSubredditMirrors(subredditID int) ([]SubredditMirror, error) {
+	defer dm.span("GetSubredditMirrors").End()
+	dm.mu.RLock()
+	defer dm.mu.RUnlock()
+
+	rows, err := dm.db.Query(`
+		SELECT `+mirrorColumns+`
+		FROM subreddit_mirrors
+		WHERE ? = 0 OR subreddit_id = ?
+		ORDER BY id
+	`, subredditID, subredditID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get mirrors: %v", err)
+	}
+	defer rows.Close()
+
+	return scanMirrors(rows)
+}
+
+// DeleteSubredditMirror stops a mirror and forgets what it synced
+func (dm *DatabaseManager) DeleteSubredditMirror(subredditID, mirrorID int) error {
+	defer dm.span("DeleteSubredditMirror").End()
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	tx, err := dm.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.Exec(`DELETE FROM subreddit_mirrors WHERE id = ? AND subreddit_id = ?`, mirrorID, subredditID)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to delete mirror: %v", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		tx.Rollback()
+		return fmt.Errorf("mirror not found")
+	}
+
+	for _, table := range []string{"mirrored_posts", "mirrored_comments"} {
+		if _, err := tx.Exec(`DELETE FROM `+table+` WHERE mirror_id = ?`, mirrorID); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to delete mirror: %v", err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// PostsToMirror returns the subreddit's visible posts the mirror hasn't
+// pushed yet, oldest first
+func (dm *DatabaseManager) PostsToMirror(m SubredditMirror, limit int) ([]Post, error) {
+	defer dm.span("PostsToMirror").End()
+	dm.mu.RLock()
+	defer dm.mu.RUnlock()
+
+	rows, err := dm.db.Query(`
+		SELECT `+postColumns+`
+		FROM posts p
+		JOIN users u ON p.author_id = u.id
+		JOIN subreddits s ON p.subreddit_id = s.id
+		WHERE p.subreddit_id = ? AND p.id > ? AND p.removed = 0 AND p.deleted_at IS NULL
+		ORDER BY p.id
+		LIMIT ?
+	`, m.SubredditID, m.LastPostID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get posts to mirror: %v", err)
+	}
+	defer rows.Close()
+
+	return scanPosts(rows)
+}
+
+// RecordMirroredPost records that a post was pushed and advances the mirror
+// past it
+func (dm *DatabaseManager) RecordMirroredPost(mirrorID, localPostID, remotePostID int) error {
+	defer dm.span("RecordMirroredPost").End()
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	tx, err := dm.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		INSERT OR IGNORE INTO mirrored_posts (mirror_id, local_post_id, remote_post_id)
+		VALUES (?, ?, ?)
+	`, mirrorID, localPostID, remotePostID)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to record mirrored post: %v", err)
+	}
+
+	_, err = tx.Exec(`UPDATE subreddit_mirrors SET last_post_id = MAX(last_post_id, ?) WHERE id = ?`, localPostID, mirrorID)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to record mirrored post: %v", err)
+	}
+
+	return tx.Commit()
+}
+
+// MirroredPost pairs a local post with its copy on the remote instance
+type MirroredPost struct {
+	LocalPostID  int
+	RemotePostID int
+}
+
+// RecentMirroredPosts returns the posts a mirror pushed within the window
+func (dm *DatabaseManager) RecentMirroredPosts(mirrorID int, window time.Duration) ([]MirroredPost, error) {
+	defer dm.span("RecentMirroredPosts").End()
+	dm.mu.RLock()
+	defer dm.mu.RUnlock()
+
+	rows, err := dm.db.Query(`
+		SELECT local_post_id, remote_post_id
+		FROM mirrored_posts
+		WHERE mirror_id = ? AND created_at >= datetime('now', ?)
+		ORDER BY local_post_id
+	`, mirrorID, fmt.Sprintf("-%d seconds", int(window.Seconds())))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get mirrored posts: %v", err)
+	}
+	defer rows.Close()
+
+	var posts []MirroredPost
+	for rows.Next() {
+		var p MirroredPost
+		if err := rows.Scan(&p.LocalPostID, &p.RemotePostID); err != nil {
+			return nil, err
+		}
+		posts = append(posts, p)
+	}
+	return posts, rows.Err()
+}
+
+// PulledComments maps the remote IDs of the comments a mirror has pulled to
+// their local IDs
+func (dm *DatabaseManager) PulledComments(mirrorID int) (map[int]int, error) {
+	defer dm.span("PulledComments").End()
+	dm.mu.RLock()
+	defer dm.mu.RUnlock()
+
+	rows, err := dm.db.Query(`SELECT remote_comment_id, local_comment_id FROM mirrored_comments WHERE mirror_id = ?`, mirrorID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pulled comments: %v", err)
+	}
+	defer rows.Close()
+
+	pulled := make(map[int]int)
+	for rows.Next() {
+		var remoteID, localID int
+		if err := rows.Scan(&remoteID, &localID); err != nil {
+			return nil, err
+		}
+		pulled[remoteID] = localID
+	}
+	return pulled, rows.Err()
+}
+
+// RecordPulledComment records that a remote comment was copied locally
+func (dm *DatabaseManager) RecordPulledComment(mirrorID, remoteCommentID, localCommentID int) error {
+	defer dm.span("RecordPulledComment").End()
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	_, err := dm.db.Exec(`
+		INSERT OR IGNORE INTO mirrored_comments (mirror_id, remote_comment_id, local_comment_id)
+		VALUES (?, ?, ?)
+	`, mirrorID, remoteCommentID, localCommentID)
+	if err != nil {
+		return fmt.Errorf("failed to record pulled comment: %v", err)
+	}
+	return nil
+}
+
+// RecordMirrorSync records the outcome of a mirror's sync run
+func (dm *DatabaseManager) RecordMirrorSync(mirrorID int, syncErr error) error {
+	defer dm.span("RecordMirrorSync").End()
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	var lastError *string
+	if syncErr != nil {
+		message := syncErr.Error()
+		lastError = &message
+	}
+
+	_, err := dm.db.Exec(`
+		UPDATE subreddit_mirrors SET last_synced_at = CURRENT_TIMESTAMP, last_error = ? WHERE id = ?
+	`, lastError, mirrorID)
+	if err != nil {
+		return fmt.Errorf("failed to record mirror sync: %v", err)
+	}
+	return nil
+}
+
+// mirrorClient calls the REST API of a mirror's remote instance
+type mirrorClient struct {
+	http   *http.Client
+	mirror SubredditMirror
+}
+
+// do sends a request to the remote instance and decodes its JSON response
+func (c *mirrorClient) do(method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, strings.TrimRight(c.mirror.RemoteURL, "/")+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.mirror.RemoteToken)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("%s %s: remote responded with %s", method, path, resp.Status)
+	}
+	if out != nil {
+		return json.NewDecoder(io.LimitReader(resp.Body, 8<<20)).Decode(out)
+	}
+	return nil
+}
+
+// pushPosts copies the mirror's new local posts to the remote subreddit
+func (c *mirrorClient) pushPosts(h *APIHandler) error {
+	posts, err := h.db.PostsToMirror(c.mirror, mirrorPostBatch)
+	if err != nil {
+		return err
+	}
+
+	for _, post := range posts {
+		var created struct {
+			PostID int `json:"post_id"`
+		}
+		body := CreatePostRequest{
+			Title:       post.Title,
+			Content:     fmt.Sprintf("%s\n\n(mirrored from u/%s)", post.Content, post.AuthorUsername),
+			SubredditID: c.mirror.RemoteSubredditID,
+		}
+		if err := c.do("POST", "/posts", body, &created); err != nil {
+			h.metrics.Inc(`goreddit_mirror_posts_total{result="error"}`)
+			return err
+		}
+		h.metrics.Inc(`goreddit_mirror_posts_total{result="ok"}`)
+
+		if err := h.db.RecordMirroredPost(c.mirror.ID, post.ID, created.PostID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// remoteListing is the part of the Reddit-compatible comments listing the
+// mirror reads
+type remoteListing struct {
+	Data struct {
+		Children []struct {
+			Kind string `json:"kind"`
+			Data struct {
+				ID       string          `json:"id"`
+				ParentID string          `json:"parent_id"`
+				Author   string          `json:"author"`
+				Body     string          `json:"body"`
+				Replies  json.RawMessage `json:"replies"`
+			} `json:"data"`
+		} `json:"children"`
+	} `json:"data"`
+}
+
+// remoteComment is a comment read from a remote instance
+type remoteComment struct {
+	ID       int
+	ParentID int // 0 for a top-level comment
+	Author   string
+	Body     string
+}
+
+// flatten lists the comments of a listing and their replies, parents
+// before their replies
+func (l remoteListing) flatten() []remoteComment {
+	var comments []remoteComment
+	for _, child := range l.Data.Children {
+		if child.Kind != "t1" {
+			continue
+		}
+		id, err := strconv.ParseInt(child.Data.ID, 36, 64)
+		if err != nil {
+			continue
+		}
+		comment := remoteComment{ID: int(id), Author: child.Data.Author, Body: child.Data.Body}
+		if parent := strings.TrimPrefix(child.Data.ParentID, "t1_"); parent != child.Data.ParentID {
+			parentID, _ := strconv.ParseInt(parent, 36, 64)
+			comment.ParentID = int(parentID)
+		}
+		comments = append(comments, comment)
+
+		var replies remoteListing
+		if len(child.Data.Replies) > 0 && child.Data.Replies[0] == '{' && json.Unmarshal(child.Data.Replies, &replies) == nil {
+			comments = append(comments, replies.flatten()...)
+		}
+	}
+	return comments
+}
+
+// pullComments copies new comments on the remote copies of recently
+// mirrored posts back onto the local posts, keeping their threading
+func (c *mirrorClient) pullComments(h *APIHandler) error {
+	posts, err := h.db.RecentMirroredPosts(c.mirror.ID, mirrorCommentWindow)
+	if err != nil {
+		return err
+	}
+	pulled, err := h.db.PulledComments(c.mirror.ID)
+	if err != nil {
+		return err
+	}
+
+	host := c.mirror.RemoteURL
+	if u, err := url.Parse(c.mirror.RemoteURL); err == nil && u.Host != "" {
+		host = u.Host
+	}
+
+	for _, post := range posts {
+		var listings []remoteListing
+		path := "/comments/" + strconv.FormatInt(int64(post.RemotePostID), 36) + ".json"
+		if err := c.do("GET", path, nil, &listings); err != nil {
+			return err
+		}
+		if len(listings) < 2 {
+			continue
+		}
+
+		for _, comment := range listings[1].flatten() {
+			if _, ok := pulled[comment.ID]; ok {
+				continue
+			}
+			var parentID *int
+			if localParent, ok := pulled[comment.ParentID]; ok && comment.ParentID != 0 {
+				parentID = &localParent
+			}
+
+			content := fmt.Sprintf("u/%s on %s: %s", comment.Author, host, comment.Body)
+			localID, automod, err := h.db.CreateComment(content, c.mirror.CreatedBy, post.LocalPostID, parentID)
+			if err != nil {
+				return err
+			}
+			if err := h.db.RecordPulledComment(c.mirror.ID, comment.ID, localID); err != nil {
+				return err
+			}
+			pulled[comment.ID] = localID
+			h.metrics.Inc("goreddit_mirror_comments_pulled_total")
+
+			h.publishNotifications()
+			if !automod.Removed {
+				h.hub.Publish(postTopic(post.LocalPostID), "comment", gin.H{"comment_id": localID})
+			}
+		}
+	}
+	return nil
+}
+
+// runMirrorJob syncs every subreddit mirror until the process exits
+func runMirrorJob(h *APIHandler) {
+	client := newOutboundClient(mirrorTimeout)
+	ticker := time.NewTicker(mirrorSyncInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		mirrors, err := h.db.GetSubredditMirrors(0)
+		if err != nil {
+			log.Printf("Mirror job failed: %v", err)
+			continue
+		}
+
+		for _, mirror := range mirrors {
+			c := &mirrorClient{http: client, mirror: mirror}
+			syncErr := c.pushPosts(h)
+			if syncErr == nil && mirror.PullComments {
+				syncErr = c.pullComments(h)
+			}
+			if syncErr != nil {
+				log.Printf("Mirror %d of subreddit %d failed: %v", mirror.ID, mirror.SubredditID, syncErr)
+			}
+			if err := h.db.RecordMirrorSync(mirror.ID, syncErr); err != nil {
+				log.Printf("Mirror job failed: %v", err)
+			}
+		}
+	}
+}
+
+// CreateMirrorRequest sets up a mirror of a subreddit on another instance
+type CreateMirrorRequest struct {
+	RemoteURL         string `json:"remote_url" binding:"required"`
+	RemoteToken       string `json:"remote_token" binding:"required"` // a session token on the remote instance
+	RemoteSubredditID int    `json:"remote_subreddit_id" binding:"required"`
+	PullComments      bool   `json:"pull_comments"`
+}
+
+// getSubredditMirrors lists a subreddit's mirrors
+func (h *APIHandler) getSubredditMirrors(c *gin.Context) {
+	subredditID, ok := h.moderatedSubreddit(c)
+	if !ok {
+		return
+	}
+
+	mirrors, err := h.dbFor(c).GetSubredditMirrors(subredditID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, mirrors)
+}
+
+// createSubredditMirror starts mirroring a subreddit's new posts to another
+// instance
+func (h *APIHandler) createSubredditMirror(c *gin.Context) {
+	subredditID, ok := h.moderatedSubreddit(c)
+	if !ok {
+		return
+	}
+
+	var req CreateMirrorRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(withStatus(http.StatusBadRequest, err))
+		return
+	}
+
+	if err := checkOutboundURL(req.RemoteURL); err != nil {
+		c.Error(newAPIError(http.StatusBadRequest, "remote_url "+err.Error()))
+		return
+	}
+
+	userID, _ := strconv.Atoi(c.GetString("user_id"))
+	mirror, err := h.dbFor(c).CreateSubredditMirror(SubredditMirror{
+		SubredditID:       subredditID,
+		RemoteURL:         strings.TrimRight(req.RemoteURL, "/"),
+		RemoteToken:       req.RemoteToken,
+		RemoteSubredditID: req.RemoteSubredditID,
+		PullComments:      req.PullComments,
+		CreatedBy:         userID,
+	})
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, mirror)
+}
+
+// deleteSubredditMirror stops a mirror
+func (h *APIHandler) deleteSubredditMirror(c *gin.Context) {
+	subredditID, ok := h.moderatedSubreddit(c)
+	if !ok {
+		return
+	}
+
+	mirrorID, err := strconv.Atoi(c.Param("mirror_id"))
+	if err != nil {
+		c.Error(newAPIError(http.StatusBadRequest, "Invalid mirror ID"))
+		return
+	}
+
+	if err := h.dbFor(c).DeleteSubredditMirror(subredditID, mirrorID); err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			c.Error(withStatus(http.StatusNotFound, err))
+			return
+		}
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Mirror deleted"})
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Muted keywords and domains
+//
+// Users can mute keywords and domains in their profile, hiding the posts and
+// comments that mention them from their feeds and post threads. Matching is
+// a case-insensitive substring match, so muting "example.com" hides links to
+// it as well as plain mentions. Mutes are stored lowercased in muted_words.
+
+// Kinds of muted words
+const (
+	mutedKeyword = "keyword"
+	mutedDomain  = "domain"
+)
+
+const (
+	maxMutedWords      = 100 // of each kind
+	maxMutedWordLength = 100
+)
+
+// mutedPostFilter is a condition excluding the posts, aliased as p, that
+// mention a word muted by the user whose ID it takes
+const mutedPostFilter = `
+	NOT EXISTS (
+		SELECT 1 FROM muted_words mw
+		WHERE mw.user_id = ? AND instr(lower(p.title || ' ' || p.content), mw.word) > 0
+	)
+`
+
+// mutedCommentFilter is mutedPostFilter for comments aliased as c
+const mutedCommentFilter = `
+	NOT EXISTS (
+		SELECT 1 FROM muted_words mw
+		WHERE mw.user_id = ? AND instr(lower(c.content), mw.word) > 0
+	)
+`
+
+// normalizeMutedWords lowercases and deduplicates a list of muted words of a
+// kind, reducing domains given as URLs to their host
+func normalizeMutedWords(kind string, words []string) ([]string, error) {
+	if len(words) > maxMutedWords {
+		return nil, fmt.Errorf("at most %d muted %ss are allowed", maxMutedWords, kind)
+	}
+
+	normalized := []string{}
+	seen := make(map[string]bool)
+	for _, word := range words {
+		word = strings.ToLower(strings.TrimSpace(word))
+		if kind == mutedDomain {
+			if u, err := url.Parse(word); err == nil && u.Host != "" {
+				word = u.Host
+			}
+			word = strings.TrimPrefix(word, "www.")
+			if !strings.Contains(word, ".") || strings.ContainsAny(word, " /") {
+				return nil, fmt.Errorf("invalid muted domain %q", word)
+			}
+		}
+		if word == "" || len(word) > maxMutedWordLength {
+			return nil, fmt.Errorf("muted %ss must be between 1 and %d characters", kind, maxMutedWordLength)
+		}
+		if !seen[word] {
+			seen[word] = true
+			normalized = append(normalized, word)
+		}
+	}
+	return normalized, nil
+}
+
+// getMutedWords returns the words of a kind a user has muted, alphabetically
+func getMutedWords(db rowQueryer, userID int, kind string) ([]string, error) {
+	var data string
+	err := db.QueryRow(`
+		SELECT json_group_array(word) FROM (
+			SELECT word FROM muted_words WHERE user_id = ? AND kind = ? ORDER BY word
+		)
+	`, userID, kind).Scan(&data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get muted %ss: %v", kind, err)
+	}
+
+	words := []string{}
+	if err := json.Unmarshal([]byte(data), &words); err != nil {
+		return nil, err
+	}
+	return words, nil
+}
+
+// setMutedWords replaces the words of a kind a user has muted
+func setMutedWords(tx *sql.Tx, userID int, kind string, words []string) error {
+	if _, err := tx.Exec(`DELETE FROM muted_words WHERE user_id = ? AND kind = ?`, userID, kind); err != nil {
+		return fmt.Errorf("failed to update muted %ss: %v", kind, err)
+	}
+	for _, word := range words {
+		_, err := tx.Exec(`INSERT OR IGNORE INTO muted_words (user_id, kind, word) VALUES (?, ?, ?)`, userID, kind, word)
+		if err != nil {
+			return fmt.Errorf("failed to update muted %ss: %v", kind, err)
+		}
+	}
+	return nil
+}
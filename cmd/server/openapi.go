@@ -0,0 +1,484 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ArjunKaliyath/GoReddit/internal/buildinfo"
+)
+
+// OpenAPI document
+//
+// Every route is annotated in apiDocs with the request and response structs
+// its handler uses. The OpenAPI 3 document served at /openapi.json is built
+// from those structs by reflection, so field names, types and required
+// fields always match what the handlers bind and return. Routes missing
+// from apiDocs are logged at startup.
+
+// apiOperation annotates one route
+type apiOperation struct {
+	Method   string
+	Path     string // in gin syntax, e.g. /posts/:id
+	Tag      string
+	Summary  string
+	Public   bool        // doesn't require authentication
+	Query    []string    // query parameters, described in apiQueryParams
+	Request  interface{} // JSON request body, e.g. CreatePostRequest{}
+	Response interface{} // JSON body of a successful response
+	Status   int         // status of a successful response, 200 when 0
+
+	// StringIDs marks path IDs that aren't numeric, like Reddit's base36
+	StringIDs bool
+}
+
+// MessageResponse is the body of responses that only confirm an action
+type MessageResponse struct {
+	Message string `json:"message"`
+}
+
+// apiQueryParams describes the query parameters used across the API
+var apiQueryParams = map[string]struct{ Type, Description string }{
+	"limit":     {"integer", "Number of items to return"},
+	"offset":    {"integer", "Number of items to skip"},
+	"sort":      {"string", "Ranking: hot, rising, latest or half_life"},
+	"t":         {"string", "Timeframe: hour, day, week, month, year or all"},
+	"period":    {"string", "Timeframe: hour, day, week, month, year or all"},
+	"subreddit": {"string", "Subreddit name"},
+	"q":         {"string", "Search text"},
+	"after":     {"string", "Fullname of the last item on the previous page"},
+	"token":     {"string", "Email verification token"},
+	"unread":    {"boolean", "Only return unread items"},
+	"dry_run":   {"boolean", "Report what would change without changing anything"},
+	"mode":      {"string", "reparent (the default) or flag"},
+	"format":    {"string", "json (the default) or csv"},
+	"moderator": {"string", "Only actions by this moderator"},
+	"action":    {"string", "Only actions of this type"},
+	"reason":    {"string", "Why, recorded in the mod log or admin audit log"},
+	"hours":     {"integer", "Number of hours the series cover"},
+
+	"admin_id":    {"integer", "Only actions by this admin"},
+	"target_type": {"string", "Only actions on this type of target"},
+	"target_id":   {"integer", "Only actions on this target"},
+	"since":       {"string", "Only entries from this time (RFC 3339) or date on"},
+	"until":       {"string", "Only entries before this time (RFC 3339) or date"},
+}
+
+var apiPaged = []string{"limit", "offset"}
+
+// apiDocs annotates every route of the API
+var apiDocs = []apiOperation{
+	// Public
+	{Method: "GET", Path: "/health", Tag: "Utility", Summary: "Database status, version and the result of the last maintenance run", Public: true},
+	{Method: "GET", Path: "/metrics", Tag: "Utility", Summary: "Server metrics in the Prometheus text format", Public: true},
+	{Method: "GET", Path: "/openapi.json", Tag: "Utility", Summary: "This OpenAPI document", Public: true},
+	{Method: "GET", Path: "/docs", Tag: "Utility", Summary: "Swagger UI for this document", Public: true},
+	{Method: "POST", Path: "/register", Tag: "Users", Summary: "Register a new user", Public: true, Request: RegisterUserRequest{}, Status: http.StatusCreated},
+	{Method: "GET", Path: "/captcha", Tag: "Users", Summary: "The CAPTCHA registering takes, if any", Public: true, Response: CaptchaSettings{}},
+	{Method: "POST", Path: "/login", Tag: "Users", Summary: "Log in and start a session", Public: true, Request: LoginRequest{}},
+	{Method: "GET", Path: "/verify-email", Tag: "Users", Summary: "Verify an email address", Public: true, Query: []string{"token"}, Response: MessageResponse{}},
+	{Method: "GET", Path: "/users/:username", Tag: "Users", Summary: "Get a user's public profile", Public: true, Response: User{}},
+	{Method: "GET", Path: "/users/:username/awards", Tag: "Users", Summary: "Awards a user has received", Public: true, Query: []string{"limit", "offset"}, Response: []ReceivedAward{}},
+	{Method: "GET", Path: "/users/:username/trophies", Tag: "Users", Summary: "Trophies a user has earned", Public: true, Response: []UserTrophy{}},
+	{Method: "GET", Path: "/awards", Tag: "Awards", Summary: "The awards that can be given", Public: true, Response: []AwardType{}},
+	{Method: "GET", Path: "/r/:name", Tag: "Subreddits", Summary: "Look up a subreddit by name", Public: true, Response: Subreddit{}},
+	{Method: "GET", Path: "/r/:name/comments/:post_id/:slug", Tag: "Posts", Summary: "A post by its permalink, redirecting to the canonical one", Public: true, Response: Post{}},
+	{Method: "GET", Path: "/r/:name/about", Tag: "Subreddits", Summary: "Get a subreddit's description, rules, moderators and pinned posts", Public: true, Response: SubredditAbout{}},
+	{Method: "GET", Path: "/r/:name/feed.rss", Tag: "Feeds", Summary: "RSS feed of a subreddit's recent posts", Public: true},
+	{Method: "GET", Path: "/u/:username/feed.rss", Tag: "Feeds", Summary: "RSS feed of a user's recent posts", Public: true},
+	{Method: "GET", Path: "/r/:name/hot.json", Tag: "Reddit-compatible", Summary: "Reddit Listing of a subreddit's hot posts", Public: true, Query: []string{"limit", "after"}, Response: redditListing{}},
+	{Method: "GET", Path: "/r/:name/new.json", Tag: "Reddit-compatible", Summary: "Reddit Listing of a subreddit's newest posts", Public: true, Query: []string{"limit", "after"}, Response: redditListing{}},
+	{Method: "GET", Path: "/comments/:id", Tag: "Reddit-compatible", Summary: "Reddit Listings of a post and its comment tree (id is the base36 post ID followed by .json)", Public: true, StringIDs: true, Response: []redditListing{}},
+
+	// Users and sessions
+	{Method: "POST", Path: "/logout", Tag: "Users", Summary: "End the current session", Response: MessageResponse{}},
+	{Method: "GET", Path: "/users/me/sessions", Tag: "Users", Summary: "List the current user's sessions", Response: []Session{}},
+	{Method: "DELETE", Path: "/users/me/sessions/:session_id", Tag: "Users", Summary: "Revoke a session", Response: MessageResponse{}},
+	{Method: "GET", Path: "/users/me/profile", Tag: "Users", Summary: "Get the current user's profile", Response: UserProfile{}},
+	{Method: "PUT", Path: "/users/me/profile", Tag: "Users", Summary: "Update the current user's profile", Request: UpdateUserProfileRequest{}, Response: UserProfile{}},
+	{Method: "GET", Path: "/users/me/betas", Tag: "Users", Summary: "List beta features and whether the user opted in", Response: []BetaFeature{}},
+	{Method: "POST", Path: "/users/me/betas/:name", Tag: "Users", Summary: "Opt in to a beta feature"},
+	{Method: "DELETE", Path: "/users/me/betas/:name", Tag: "Users", Summary: "Opt out of a beta feature"},
+	{Method: "GET", Path: "/users/me/email", Tag: "Users", Summary: "Get the current user's email settings", Response: UserEmail{}},
+	{Method: "PUT", Path: "/users/me/email", Tag: "Users", Summary: "Change the email address or digest setting", Request: UpdateEmailSettingsRequest{}, Response: UserEmail{}},
+	{Method: "POST", Path: "/users/me/email/verify", Tag: "Users", Summary: "Resend the verification email", Response: MessageResponse{}, Status: http.StatusAccepted},
+	{Method: "GET", Path: "/users/top", Tag: "Users", Summary: "Users with the most karma", Query: []string{"period", "limit"}, Response: []TopUser{}},
+	{Method: "GET", Path: "/users/top-subscribed", Tag: "Users", Summary: "Users with the most subscribers", Query: []string{"period", "limit"}, Response: []TopSubscribedUser{}},
+	{Method: "GET", Path: "/subscriptions", Tag: "Users", Summary: "Users the current user subscribes to", Response: []User{}},
+	{Method: "POST", Path: "/users/:user_id/subscribe", Tag: "Users", Summary: "Subscribe to a user", Response: MessageResponse{}},
+	{Method: "POST", Path: "/users/:user_id/unsubscribe", Tag: "Users", Summary: "Unsubscribe from a user", Response: MessageResponse{}},
+	{Method: "GET", Path: "/onboarding", Tag: "Users", Summary: "Subreddits suggested to start out in"},
+
+	// Posts, comments and votes
+	{Method: "POST", Path: "/posts", Tag: "Posts", Summary: "Create a post", Request: CreatePostRequest{}, Status: http.StatusCreated},
+	{Method: "PUT", Path: "/posts/:id", Tag: "Posts", Summary: "Edit your post", Request: EditContentRequest{}, Response: Post{}},
+	{Method: "DELETE", Path: "/posts/:id", Tag: "Posts", Summary: "Delete your post, or any post as a moderator or admin", Query: []string{"reason"}, Response: MessageResponse{}},
+	{Method: "POST", Path: "/posts/:id/restore", Tag: "Moderation", Summary: "Restore a deleted post", Query: []string{"reason"}, Response: MessageResponse{}},
+	{Method: "GET", Path: "/posts", Tag: "Posts", Summary: "Several posts by ID, in the order listed", Query: []string{"ids"}, Response: []Post{}},
+	{Method: "GET", Path: "/posts/top", Tag: "Posts", Summary: "Top posts by score", Query: []string{"limit"}, Response: []Post{}},
+	{Method: "GET", Path: "/posts/:id/comments/stream", Tag: "Real-time", Summary: "Server-Sent Events stream of new comments on a post"},
+	{Method: "POST", Path: "/comments", Tag: "Comments", Summary: "Comment on a post", Request: CreateCommentRequest{}, Status: http.StatusCreated},
+	{Method: "PUT", Path: "/comments/:comment_id", Tag: "Comments", Summary: "Edit your comment", Request: EditContentRequest{}, Response: Comment{}},
+	{Method: "DELETE", Path: "/comments/:comment_id", Tag: "Comments", Summary: "Delete your comment, or any comment as a moderator or admin", Query: []string{"reason"}, Response: MessageResponse{}},
+	{Method: "POST", Path: "/comments/:comment_id/restore", Tag: "Moderation", Summary: "Restore a deleted comment", Query: []string{"reason"}, Response: MessageResponse{}},
+	{Method: "GET", Path: "/posts/:id/history", Tag: "Posts", Summary: "A post's edit history", Response: EditHistory{}},
+	{Method: "GET", Path: "/comments/:id/history", Tag: "Comments", Summary: "A comment's edit history", Response: EditHistory{}},
+	{Method: "GET", Path: "/posts/:id/insights", Tag: "Posts", Summary: "Views, shares, votes and comment growth of your post", Query: []string{"hours"}, Response: PostInsights{}},
+	{Method: "POST", Path: "/posts/:id/share", Tag: "Posts", Summary: "Record that you shared a post"},
+	{Method: "GET", Path: "/posts/:id/related", Tag: "Posts", Summary: "Posts like a post, by shared terms, subreddit and flair", Query: []string{"limit"}, Response: []Post{}},
+	{Method: "POST", Path: "/posts/:id/awards", Tag: "Awards", Summary: "Give a post an award", Request: GiveAwardRequest{}},
+	{Method: "POST", Path: "/comments/:comment_id/awards", Tag: "Awards", Summary: "Give a comment an award", Request: GiveAwardRequest{}},
+	{Method: "GET", Path: "/comments/top", Tag: "Comments", Summary: "Top comments in a timeframe", Query: []string{"t", "subreddit", "limit", "offset"}},
+	{Method: "POST", Path: "/vote", Tag: "Votes", Summary: "Upvote or downvote a post or comment", Request: VoteRequest{}, Response: MessageResponse{}},
+	{Method: "POST", Path: "/votes/batch", Tag: "Votes", Summary: "Cast up to 50 votes at once, all or none of them", Request: VoteBatchRequest{}, Response: VoteBatchResponse{}},
+	{Method: "POST", Path: "/graphql", Tag: "GraphQL", Summary: "Run a GraphQL query or mutation", Request: GraphQLRequest{}, Response: GraphQLResponse{}},
+
+	// Feeds
+	{Method: "GET", Path: "/feed", Tag: "Feeds", Summary: "Posts from joined subreddits", Query: []string{"sort", "limit", "offset"}, Response: []Post{}},
+	{Method: "GET", Path: "/feed/following", Tag: "Feeds", Summary: "Posts by users the current user subscribes to", Query: []string{"sort", "limit", "offset"}, Response: []Post{}},
+	{Method: "GET", Path: "/all", Tag: "Feeds", Summary: "Posts across every subreddit", Query: []string{"sort", "limit", "offset"}, Response: PostPage{}},
+	{Method: "GET", Path: "/popular", Tag: "Feeds", Summary: "Hottest posts site-wide, at most 5 per subreddit", Query: apiPaged, Response: PostPage{}},
+	{Method: "GET", Path: "/trending/topics", Tag: "Feeds", Summary: "Trending terms in recent post titles", Query: []string{"limit"}, Response: []TrendingTopic{}},
+
+	// Direct messages
+	{Method: "POST", Path: "/messages", Tag: "Messages", Summary: "Send a direct message", Request: SendMessageRequest{}, Status: http.StatusCreated},
+	{Method: "GET", Path: "/messages", Tag: "Messages", Summary: "List conversations", Response: []Conversation{}},
+	{Method: "GET", Path: "/messages/with/:user_id", Tag: "Messages", Summary: "Get the conversation with a user", Response: []DirectMessage{}},
+	{Method: "POST", Path: "/messages/with/:user_id/read", Tag: "Messages", Summary: "Mark a conversation as read"},
+	{Method: "POST", Path: "/messages/:message_id/read", Tag: "Messages", Summary: "Mark a message as read", Response: MessageResponse{}},
+	{Method: "DELETE", Path: "/messages/:message_id", Tag: "Messages", Summary: "Delete a message for yourself", Response: MessageResponse{}},
+	{Method: "GET", Path: "/me/unread", Tag: "Messages", Summary: "Unread message and notification counts", Response: UnreadCounts{}},
+
+	// Chats
+	{Method: "POST", Path: "/chats", Tag: "Chats", Summary: "Create a group chat room", Request: CreateChatRoomRequest{}, Response: ChatRoom{}, Status: http.StatusCreated},
+	{Method: "GET", Path: "/chats", Tag: "Chats", Summary: "List the current user's chat rooms", Response: []ChatRoom{}},
+	{Method: "GET", Path: "/chats/:room_id", Tag: "Chats", Summary: "Get a chat room", Response: ChatRoom{}},
+	{Method: "POST", Path: "/chats/:room_id/members", Tag: "Chats", Summary: "Add a member to a chat room", Request: InviteChatMemberRequest{}, Response: MessageResponse{}},
+	{Method: "DELETE", Path: "/chats/:room_id/members/:user_id", Tag: "Chats", Summary: "Remove a member from a chat room", Response: MessageResponse{}},
+	{Method: "GET", Path: "/chats/:room_id/messages", Tag: "Chats", Summary: "Get a chat room's messages", Query: apiPaged, Response: ChatMessagePage{}},
+	{Method: "POST", Path: "/chats/:room_id/messages", Tag: "Chats", Summary: "Post in a chat room", Request: ChatMessageRequest{}, Status: http.StatusCreated},
+
+	// Notifications and real-time
+	{Method: "GET", Path: "/notifications", Tag: "Notifications", Summary: "List notifications, newest first", Query: []string{"unread", "limit", "offset"}, Response: NotificationPage{}},
+	{Method: "GET", Path: "/notifications/preferences", Tag: "Notifications", Summary: "Get notification delivery preferences by type", Response: map[string]NotificationChannels{}},
+	{Method: "PUT", Path: "/notifications/preferences", Tag: "Notifications", Summary: "Update notification delivery preferences", Request: map[string]NotificationChannelsUpdate{}, Response: map[string]NotificationChannels{}},
+	{Method: "GET", Path: "/notifications/unread-count", Tag: "Notifications", Summary: "Number of unread notifications"},
+	{Method: "POST", Path: "/notifications/read-all", Tag: "Notifications", Summary: "Mark every notification as read"},
+	{Method: "POST", Path: "/notifications/:notification_id/read", Tag: "Notifications", Summary: "Mark a notification as read", Response: MessageResponse{}},
+	{Method: "GET", Path: "/ws", Tag: "Real-time", Summary: "WebSocket pushing the current user's events"},
+
+	// Subreddits
+	{Method: "POST", Path: "/subreddits", Tag: "Subreddits", Summary: "Create a subreddit", Request: CreateSubredditRequest{}, Status: http.StatusCreated},
+	{Method: "POST", Path: "/subreddits/:id/join", Tag: "Subreddits", Summary: "Join a subreddit", Response: MessageResponse{}},
+	{Method: "POST", Path: "/subreddits/:id/leave", Tag: "Subreddits", Summary: "Leave a subreddit", Response: MessageResponse{}},
+	{Method: "POST", Path: "/subreddits/join", Tag: "Subreddits", Summary: "Join several subreddits at once", Request: JoinSubredditsRequest{}},
+	{Method: "GET", Path: "/subreddits/all", Tag: "Subreddits", Summary: "List every subreddit", Response: []Subreddit{}},
+	{Method: "GET", Path: "/subreddits/joined", Tag: "Subreddits", Summary: "Subreddits the current user has joined", Response: []Subreddit{}},
+	{Method: "GET", Path: "/subreddits/search", Tag: "Subreddits", Summary: "Search subreddits by name and description", Query: []string{"q", "limit"}, Response: []SubredditListing{}},
+	{Method: "GET", Path: "/recommendations/subreddits", Tag: "Subreddits", Summary: "Subreddits joined by users with similar memberships", Query: []string{"limit"}, Response: []SubredditRecommendation{}},
+	{Method: "GET", Path: "/subreddits/discover", Tag: "Subreddits", Summary: "Active subreddits the user hasn't joined (beta)", Query: []string{"limit"}, Response: []SubredditListing{}},
+	{Method: "GET", Path: "/subreddits/:id/feed", Tag: "Subreddits", Summary: "A subreddit's posts, pinned first", Query: []string{"sort"}, Response: []Post{}},
+	{Method: "GET", Path: "/subreddits/:id/posts", Tag: "Subreddits", Summary: "A page of a subreddit's posts, joined or not", Query: []string{"sort", "limit", "offset"}, Response: PostPage{}},
+	{Method: "GET", Path: "/subreddits/:id/top", Tag: "Subreddits", Summary: "A subreddit's top posts in a timeframe", Query: []string{"t", "limit", "offset"}, Response: PostPage{}},
+	{Method: "GET", Path: "/subreddits/:id/settings", Tag: "Subreddits", Summary: "Get a subreddit's ranking settings", Response: SubredditSettings{}},
+	{Method: "GET", Path: "/subreddits/:id/rules", Tag: "Subreddits", Summary: "Get a subreddit's rules", Response: []SubredditRule{}},
+
+	// Moderation
+	{Method: "PUT", Path: "/subreddits/:id/rules", Tag: "Moderation", Summary: "Replace a subreddit's rules", Request: UpdateSubredditRulesRequest{}, Response: []SubredditRule{}},
+	{Method: "PUT", Path: "/subreddits/:id/settings", Tag: "Moderation", Summary: "Change a subreddit's ranking settings", Request: UpdateSubredditSettingsRequest{}, Response: SubredditSettings{}},
+	{Method: "GET", Path: "/subreddits/:id/automod", Tag: "Moderation", Summary: "List automod rules", Response: []AutomodRule{}},
+	{Method: "POST", Path: "/subreddits/:id/automod", Tag: "Moderation", Summary: "Create an automod rule", Request: CreateAutomodRuleRequest{}, Status: http.StatusCreated},
+	{Method: "DELETE", Path: "/subreddits/:id/automod/:rule_id", Tag: "Moderation", Summary: "Delete an automod rule", Response: MessageResponse{}},
+	{Method: "GET", Path: "/subreddits/:id/modqueue", Tag: "Moderation", Summary: "Content waiting for review", Response: []ModQueueItem{}},
+	{Method: "POST", Path: "/subreddits/:id/remove", Tag: "Moderation", Summary: "Remove a post or comment", Request: RemoveContentRequest{}, Response: MessageResponse{}},
+	{Method: "POST", Path: "/subreddits/:id/archive", Tag: "Moderation", Summary: "Make the subreddit read-only (head moderator)", Response: MessageResponse{}},
+	{Method: "POST", Path: "/subreddits/:id/unarchive", Tag: "Moderation", Summary: "Make an archived subreddit writable again (head moderator)", Response: MessageResponse{}},
+	{Method: "POST", Path: "/subreddits/:id/transfer", Tag: "Moderation", Summary: "Make another moderator the head moderator", Request: TransferSubredditRequest{}, Response: MessageResponse{}},
+	{Method: "GET", Path: "/subreddits/:id/bans", Tag: "Moderation", Summary: "List banned users", Response: []SubredditBan{}},
+	{Method: "POST", Path: "/subreddits/:id/bans", Tag: "Moderation", Summary: "Ban a user", Request: BanUserRequest{}, Response: MessageResponse{}},
+	{Method: "DELETE", Path: "/subreddits/:id/bans/:user_id", Tag: "Moderation", Summary: "Unban a user", Response: MessageResponse{}},
+	{Method: "POST", Path: "/subreddits/:id/pin", Tag: "Moderation", Summary: "Pin or unpin a post", Request: PinPostRequest{}},
+	{Method: "POST", Path: "/subreddits/:id/flair", Tag: "Moderation", Summary: "Set a post's flair", Request: SetFlairRequest{}},
+	{Method: "GET", Path: "/subreddits/:id/modlog", Tag: "Moderation", Summary: "Moderator actions, newest first", Query: []string{"moderator", "action", "limit"}, Response: []ModLogEntry{}},
+	{Method: "GET", Path: "/subreddits/:id/webhooks", Tag: "Moderation", Summary: "List moderation webhooks", Response: []ModWebhook{}},
+	{Method: "POST", Path: "/subreddits/:id/webhooks", Tag: "Moderation", Summary: "Register a moderation webhook", Request: CreateModWebhookRequest{}, Response: ModWebhook{}, Status: http.StatusCreated},
+	{Method: "DELETE", Path: "/subreddits/:id/webhooks/:webhook_id", Tag: "Moderation", Summary: "Delete a moderation webhook", Response: MessageResponse{}},
+	{Method: "GET", Path: "/subreddits/:id/webhooks/:webhook_id/deliveries", Tag: "Moderation", Summary: "A webhook's recent deliveries", Query: apiPaged, Response: []ModWebhookDelivery{}},
+	{Method: "GET", Path: "/subreddits/:id/mirrors", Tag: "Moderation", Summary: "List the subreddit's mirrors", Response: []SubredditMirror{}},
+	{Method: "POST", Path: "/subreddits/:id/mirrors", Tag: "Moderation", Summary: "Mirror new posts to another instance", Request: CreateMirrorRequest{}, Response: SubredditMirror{}, Status: http.StatusCreated},
+	{Method: "DELETE", Path: "/subreddits/:id/mirrors/:mirror_id", Tag: "Moderation", Summary: "Stop a mirror", Response: MessageResponse{}},
+	{Method: "GET", Path: "/subreddits/:id/modlists/export", Tag: "Moderation", Summary: "Export the ban list and word filters", Query: []string{"format"}, Response: ModLists{}},
+	{Method: "POST", Path: "/subreddits/:id/modlists/import", Tag: "Moderation", Summary: "Import a ban list and word filters", Query: []string{"format", "dry_run"}, Request: ModLists{}, Response: ModListImportReport{}},
+
+	// Admin
+	{Method: "POST", Path: "/admin/maintenance", Tag: "Admin", Summary: "Run database maintenance now", Response: MaintenanceReport{}},
+	{Method: "POST", Path: "/admin/reset-database", Tag: "Admin", Summary: "Reset the entire database", Response: MessageResponse{}},
+	{Method: "POST", Path: "/admin/repair-comments", Tag: "Admin", Summary: "Repair broken comment threads", Query: []string{"mode", "dry_run"}, Response: ThreadRepairReport{}},
+	{Method: "POST", Path: "/admin/impersonate/:user_id", Tag: "Admin", Summary: "Get a short-lived token to act as a user", Request: ImpersonateRequest{}, Status: http.StatusCreated},
+	{Method: "POST", Path: "/admin/votes/bulk", Tag: "Admin", Summary: "Ingest an NDJSON stream of votes", Response: BulkVoteReport{}},
+	{Method: "POST", Path: "/admin/import", Tag: "Admin", Summary: "Import a bundle of users, subreddits, posts, comments and votes", Request: ImportBundle{}, Response: ImportResult{}, Status: http.StatusCreated},
+	{Method: "POST", Path: "/admin/standby/snapshot", Tag: "Admin", Summary: "Ship a standby snapshot now"},
+	{Method: "GET", Path: "/admin/stats", Tag: "Admin", Summary: "Site-wide totals and activity over time", Response: SiteStats{}},
+	{Method: "GET", Path: "/admin/config", Tag: "Admin", Summary: "The runtime config in use", Response: RuntimeConfig{}},
+	{Method: "POST", Path: "/admin/config/reload", Tag: "Admin", Summary: "Reload the runtime config file", Response: RuntimeConfig{}},
+	{Method: "GET", Path: "/admin/votes", Tag: "Admin", Summary: "Individual votes by a user or on a post or comment, newest first", Query: []string{"user_id", "target_type", "target_id", "limit", "offset"}, Response: []VoteRecord{}},
+	{Method: "GET", Path: "/admin/audit", Tag: "Admin", Summary: "The admin audit log, newest first", Query: []string{"admin_id", "action", "target_type", "target_id", "since", "until", "limit", "offset"}, Response: []AdminAuditEntry{}},
+	{Method: "DELETE", Path: "/admin/subreddits/:id", Tag: "Admin", Summary: "Delete a subreddit and its posts", Response: DeleteSubredditResponse{}},
+	{Method: "POST", Path: "/admin/subreddits/:id/restore", Tag: "Admin", Summary: "Restore a deleted subreddit and the posts deleted with it", Response: DeleteSubredditResponse{}},
+	{Method: "GET", Path: "/admin/jobs", Tag: "Admin", Summary: "List the background jobs with their last runs", Response: []JobStatus{}},
+	{Method: "GET", Path: "/admin/jobs/:name", Tag: "Admin", Summary: "A background job with its recent runs", Query: []string{"limit"}, Response: JobStatus{}},
+	{Method: "POST", Path: "/admin/jobs/:name/run", Tag: "Admin", Summary: "Start a background job now", Status: http.StatusAccepted},
+}
+
+// openAPISchemas builds component schemas from Go types, following the
+// encoding/json rules for field names and gin's binding tags for required
+// fields and enums
+type openAPISchemas map[string]interface{}
+
+// schemaOf returns the schema of a Go type, adding named structs to the
+// components and referring to them
+func (schemas openAPISchemas) schemaOf(t reflect.Type) map[string]interface{} {
+	switch {
+	case t == reflect.TypeOf(time.Time{}):
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case t.Kind() == reflect.Ptr:
+		schema := schemas.schemaOf(t.Elem())
+		if _, ref := schema["$ref"]; ref {
+			return map[string]interface{}{"allOf": []interface{}{schema}, "nullable": true}
+		}
+		schema["nullable"] = true
+		return schema
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": schemas.schemaOf(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": schemas.schemaOf(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return schemas.structSchema(t)
+		}
+		if _, ok := schemas[t.Name()]; !ok {
+			schemas[t.Name()] = map[string]interface{}{} // placeholder for recursive types
+			schemas[t.Name()] = schemas.structSchema(t)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + t.Name()}
+	default:
+		return map[string]interface{}{}
+	}
+}
+
+func (schemas openAPISchemas) structSchema(t reflect.Type) map[string]interface{} {
+	properties := map[string]interface{}{}
+	var required []string
+	schemas.addFields(t, properties, &required)
+
+	schema := map[string]interface{}{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		sort.Strings(required)
+		schema["required"] = required
+	}
+	return schema
+}
+
+func (schemas openAPISchemas) addFields(t reflect.Type, properties map[string]interface{}, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" || (!field.IsExported() && !field.Anonymous) {
+			continue
+		}
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			schemas.addFields(field.Type, properties, required)
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		schema := schemas.schemaOf(field.Type)
+		for _, rule := range strings.Split(field.Tag.Get("binding"), ",") {
+			switch {
+			case rule == "required":
+				*required = append(*required, name)
+			case strings.HasPrefix(rule, "oneof="):
+				schema["enum"] = strings.Fields(strings.TrimPrefix(rule, "oneof="))
+			}
+		}
+		properties[name] = schema
+	}
+}
+
+// openAPIPath converts a gin path to OpenAPI syntax and lists its parameters.
+// Parameters named id or ending in _id are integers unless stringIDs is set.
+func openAPIPath(path string, stringIDs bool) (string, []interface{}) {
+	var params []interface{}
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if !strings.HasPrefix(segment, ":") {
+			continue
+		}
+		name := strings.TrimPrefix(segment, ":")
+		segments[i] = "{" + name + "}"
+
+		paramType := "string"
+		if !stringIDs && name != "session_id" && (name == "id" || strings.HasSuffix(name, "_id")) {
+			paramType = "integer"
+		}
+		params = append(params, map[string]interface{}{
+			"name": name, "in": "path", "required": true,
+			"schema": map[string]interface{}{"type": paramType},
+		})
+	}
+	return strings.Join(segments, "/"), params
+}
+
+// newOpenAPIDocument builds the OpenAPI 3 document of the annotated routes
+func newOpenAPIDocument(docs []apiOperation) map[string]interface{} {
+	schemas := openAPISchemas{}
+	paths := map[string]map[string]interface{}{}
+
+	for _, doc := range docs {
+		path, params := openAPIPath(doc.Path, doc.StringIDs)
+		for _, name := range doc.Query {
+			param := apiQueryParams[name]
+			params = append(params, map[string]interface{}{
+				"name": name, "in": "query", "description": param.Description,
+				"schema": map[string]interface{}{"type": param.Type},
+			})
+		}
+
+		status := doc.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		response := map[string]interface{}{"description": http.StatusText(status)}
+		if doc.Response != nil {
+			response["content"] = map[string]interface{}{
+				"application/json": map[string]interface{}{"schema": schemas.schemaOf(reflect.TypeOf(doc.Response))},
+			}
+		}
+
+		operation := map[string]interface{}{
+			"summary":   doc.Summary,
+			"tags":      []string{doc.Tag},
+			"responses": map[string]interface{}{strconv.Itoa(status): response, "default": map[string]interface{}{"$ref": "#/components/responses/Error"}},
+		}
+		if len(params) > 0 {
+			operation["parameters"] = params
+		}
+		if doc.Request != nil {
+			operation["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{"schema": schemas.schemaOf(reflect.TypeOf(doc.Request))},
+				},
+			}
+		}
+		if doc.Public {
+			operation["security"] = []interface{}{}
+		}
+
+		if paths[path] == nil {
+			paths[path] = map[string]interface{}{}
+		}
+		paths[path][strings.ToLower(doc.Method)] = operation
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "GoReddit API",
+			"version": buildinfo.Version,
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": schemas,
+			"responses": map[string]interface{}{
+				"Error": map[string]interface{}{
+					"description": "Error",
+					"content": map[string]interface{}{"application/json": map[string]interface{}{"schema": map[string]interface{}{
+						"type": "object",
+						"properties": map[string]interface{}{
+							"error": map[string]interface{}{"type": "string"},
+							"code":  map[string]interface{}{"type": "string", "description": "Stable error code, e.g. not_found"},
+						},
+					}}},
+				},
+			},
+			"securitySchemes": map[string]interface{}{
+				"session": map[string]interface{}{"type": "http", "scheme": "bearer", "description": "Session token from POST /login"},
+				"userId":  map[string]interface{}{"type": "apiKey", "in": "header", "name": "X-User-ID"},
+			},
+		},
+		"security": []interface{}{
+			map[string]interface{}{"session": []string{}},
+			map[string]interface{}{"userId": []string{}},
+		},
+	}
+}
+
+// checkAPIDocs logs the routes missing from apiDocs, and annotations of
+// routes that don't exist, so the document can't silently drift
+func checkAPIDocs(routes gin.RoutesInfo, docs []apiOperation) {
+	documented := make(map[string]bool)
+	for _, doc := range docs {
+		documented[doc.Method+" "+doc.Path] = true
+	}
+
+	registered := make(map[string]bool)
+	for _, route := range routes {
+		key := route.Method + " " + route.Path
+		registered[key] = true
+		if !documented[key] {
+			log.Printf("OpenAPI: route %s is not documented in apiDocs", key)
+		}
+	}
+	for key := range documented {
+		if !registered[key] {
+			log.Printf("OpenAPI: apiDocs documents %s, which isn't a route", key)
+		}
+	}
+}
+
+//go:embed swagger.html
+var swaggerPage []byte
+
+// serveOpenAPI serves the OpenAPI document
+func (h *APIHandler) serveOpenAPI(c *gin.Context) {
+	c.Data(http.StatusOK, "application/json", h.openapi)
+}
+
+// serveSwaggerUI serves a Swagger UI page for the OpenAPI document
+func (h *APIHandler) serveSwaggerUI(c *gin.Context) {
+	c.Data(http.StatusOK, "text/html; charset=utf-8", swaggerPage)
+}
+
+// runOpenAPICommand writes the OpenAPI document to stdout, for generating
+// clients without running the server:
+//
+//	goreddit-server openapi > openapi.json
+func runOpenAPICommand() error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(newOpenAPIDocument(apiDocs))
+}
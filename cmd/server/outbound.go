@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+// Outbound requests
+//
+// Moderators choose the URLs some requests are sent to, such as their
+// webhooks, so those requests must not reach the server's own network:
+// loopback, private, link-local and unspecified addresses are refused. URLs
+// are checked when they're saved, so a mistake is reported right away, and
+// again by the dialer on every connection, so a hostname that later resolves
+// somewhere internal, or a redirect there, is refused too.
+
+// outboundLookupTimeout bounds resolving a URL's host when it's checked
+const outboundLookupTimeout = 5 * time.Second
+
+// errInternalAddress is returned for outbound requests to internal addresses
+var errInternalAddress = errors.New("address is not publicly routable")
+
+// isInternalIP reports whether ip is one outbound requests may not reach
+func isInternalIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast()
+}
+
+// checkOutboundURL checks that raw is an http or https URL whose host only
+// resolves to public addresses
+func checkOutboundURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return fmt.Errorf("must be an http or https URL")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), outboundLookupTimeout)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, u.Hostname())
+	if err != nil {
+		return fmt.Errorf("host %s could not be resolved", u.Hostname())
+	}
+	for _, addr := range addrs {
+		if isInternalIP(addr.IP) {
+			return fmt.Errorf("host %s: %w", u.Hostname(), errInternalAddress)
+		}
+	}
+	return nil
+}
+
+// refuseInternalDial is a net.Dialer Control hook that refuses connections to
+// internal addresses. It sees the address actually dialed, after resolution.
+func refuseInternalDial(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || isInternalIP(ip) {
+		return fmt.Errorf("dial %s: %w", address, errInternalAddress)
+	}
+	return nil
+}
+
+// newOutboundClient returns an HTTP client for requests to moderator-chosen
+// URLs, which can't connect to internal addresses however the URL resolves
+// or redirects
+func newOutboundClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{Timeout: 10 * time.Second, Control: refuseInternalDial}
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: 10 * time.Second,
+			MaxIdleConns:        100,
+			IdleConnTimeout:     90 * time.Second,
+		},
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Event outbox
+//
+// With events.broker set, domain events are published to NATS or Kafka for
+// consumers outside the server. Each write stores its event in the
+// event_outbox table in the same transaction as the write, so an event is
+// stored exactly when its write commits, and the outbox relay publishes
+// stored events in order, marking them published once the broker has
+// acknowledged them. Events outlive broker outages and restarts, and an
+// event may be published more than once if the server stops between the
+// broker's acknowledgement and the mark, so consumers should ignore event
+// IDs they have already seen.
+
+const (
+	outboxPollInterval = time.Second
+	outboxBatch        = 100
+	outboxMaxBackoff   = time.Minute
+	outboxRetention    = 24 * time.Hour // how long published events are kept
+	outboxPublishWait  = 10 * time.Second
+)
+
+// EventPublisher publishes serialized events to a message broker. Publish
+// returns once the broker has acknowledged the message.
+type EventPublisher interface {
+	Publish(ctx context.Context, topic string, event OutboxEvent) error
+	Close() error
+}
+
+// OutboxEvent is a stored event waiting to be published
+type OutboxEvent struct {
+	ID       int
+	EventID  string
+	Type     string
+	Payload  []byte // the event envelope as JSON
+	Attempts int
+}
+
+// EnableOutbox makes writes store their events in the outbox from now on.
+// It's called at startup when a broker is configured.
+func (dm *DatabaseManager) EnableOutbox() {
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+	dm.outbox = true
+}
+
+// enqueueOutboxEvent stores the event of a write in the outbox, as part of
+// the write's transaction, when the outbox is enabled. The caller holds
+// dm.mu.
+func (dm *DatabaseManager) enqueueOutboxEvent(tx *sql.Tx, event DomainEvent) error {
+	if !dm.outbox {
+		return nil
+	}
+
+	envelope := newEventEnvelope(event)
+	payload, err := json.Marshal(envelope)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`INSERT INTO event_outbox (event_id, type, payload) VALUES (?, ?, ?)`,
+		envelope.ID, envelope.Type, string(payload))
+	if err != nil {
+		return fmt.Errorf("failed to store outbox event: %v", err)
+	}
+	return nil
+}
+
+// PendingOutboxEvents returns the oldest events that haven't been published
+func (dm *DatabaseManager) PendingOutboxEvents(limit int) ([]OutboxEvent, error) {
+	defer dm.span("PendingOutboxEvents").End()
+	dm.mu.RLock()
+	defer dm.mu.RUnlock()
+
+	rows, err := dm.db.Query(`
+		SELECT id, event_id, type, payload, attempts
+		FROM event_outbox
+		WHERE published_at IS NULL
+		ORDER BY id
+		LIMIT ?
+	`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pending outbox events: %v", err)
+	}
+	defer rows.Close()
+
+	var events []OutboxEvent
+	for rows.Next() {
+		var e OutboxEvent
+		var payload string
+		if err := rows.Scan(&e.ID, &e.EventID, &e.Type, &payload, &e.Attempts); err != nil {
+			return nil, err
+		}
+		e.Payload = []byte(payload)
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// RecordOutboxAttempt records the outcome of publishing an event
+func (dm *DatabaseManager) RecordOutboxAttempt(id int, publishErr error) error {
+	defer dm.span("RecordOutboxAttempt").End()
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	var err error
+	if publishErr == nil {
+		_, err = dm.db.Exec(`
+			UPDATE event_outbox
+			SET attempts = attempts + 1, published_at = CURRENT_TIMESTAMP, last_error = NULL
+			WHERE id = ?
+		`, id)
+	} else {
+		_, err = dm.db.Exec(`UPDATE event_outbox SET attempts = attempts + 1, last_error = ? WHERE id = ?`,
+			publishErr.Error(), id)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to record outbox attempt: %v", err)
+	}
+	return nil
+}
+
+// PruneOutbox deletes events published longer ago than retention
+func (dm *DatabaseManager) PruneOutbox(retention time.Duration) error {
+	defer dm.span("PruneOutbox").End()
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	_, err := dm.db.Exec(`DELETE FROM event_outbox WHERE published_at < datetime('now', ?)`,
+		fmt.Sprintf("-%d seconds", int(retention.Seconds())))
+	if err != nil {
+		return fmt.Errorf("failed to prune event outbox: %v", err)
+	}
+	return nil
+}
+
+// runOutboxRelay publishes stored events to the broker until the process
+// exits. Events are published one at a time in the order they were emitted,
+// each to the topic prefix followed by its type. When publishing fails the
+// relay backs off and retries the same event, so later events never
+// overtake it.
+func runOutboxRelay(h *APIHandler, publisher EventPublisher, topicPrefix string) {
+	backoff := outboxPollInterval
+	lastPrune := time.Now()
+
+	for {
+		failed := false
+		pending, err := h.db.PendingOutboxEvents(outboxBatch)
+		if err != nil {
+			log.Printf("Outbox relay failed: %v", err)
+			failed = true
+		}
+
+		for _, event := range pending {
+			ctx, cancel := context.WithTimeout(context.Background(), outboxPublishWait)
+			publishErr := publisher.Publish(ctx, topicPrefix+event.Type, event)
+			cancel()
+
+			if publishErr != nil {
+				h.metrics.Inc("goreddit_outbox_publish_errors_total")
+				logAt(logWarn, "Failed to publish %s event %s (attempt %d): %v", event.Type, event.EventID, event.Attempts+1, publishErr)
+			} else {
+				h.metrics.Inc("goreddit_outbox_events_published_total")
+			}
+			if err := h.db.RecordOutboxAttempt(event.ID, publishErr); err != nil {
+				log.Printf("Outbox relay failed: %v", err)
+			}
+			if publishErr != nil {
+				failed = true
+				break
+			}
+		}
+
+		if time.Since(lastPrune) > time.Hour {
+			if err := h.db.PruneOutbox(outboxRetention); err != nil {
+				log.Printf("Outbox relay failed: %v", err)
+			}
+			lastPrune = time.Now()
+		}
+
+		if failed {
+			time.Sleep(backoff)
+			if backoff *= 2; backoff > outboxMaxBackoff {
+				backoff = outboxMaxBackoff
+			}
+			continue
+		}
+		backoff = outboxPollInterval
+		if len(pending) < outboxBatch {
+			time.Sleep(outboxPollInterval)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Subreddit ownership and archival
+//
+// Every subreddit has a head moderator, its owner: the user who created it,
+// until they transfer ownership to another of its moderators. Only the head
+// moderator can archive a subreddit, which makes it read-only: nobody can
+// post, comment, vote, give awards or edit in it until it's unarchived,
+// while moderators can still remove and delete content. Both are recorded in
+// the mod log, and shown on GET /r/:name/about.
+
+var (
+	ErrSubredditArchived = errors.New("this subreddit is archived and read-only")
+	ErrNotOwner          = errors.New("only the head moderator can do this")
+	ErrNotModerator      = errors.New("the new owner must be a moderator of the subreddit")
+	ErrAlreadyOwner      = errors.New("you already own this subreddit")
+)
+
+// checkSubredditWritable fails with ErrSubredditArchived when a subreddit is
+// archived
+func checkSubredditWritable(db rowQueryer, subredditID int) error {
+	var archived bool
+	err := db.QueryRow(`SELECT archived_at IS NOT NULL FROM subreddits WHERE id = ?`, subredditID).Scan(&archived)
+	if err == sql.ErrNoRows {
+		return nil // unknown subreddits aren't this check's concern
+	}
+	if err != nil {
+		return fmt.Errorf("failed to check archival: %v", err)
+	}
+	if archived {
+		return ErrSubredditArchived
+	}
+	return nil
+}
+
+// checkOwner fails with ErrNotOwner unless the user is the subreddit's head
+// moderator
+func checkOwner(tx *sql.Tx, subredditID, userID int) error {
+	var ownerID sql.NullInt64
+	err := tx.QueryRow(`SELECT owner_id FROM subreddits WHERE id = ? AND deleted_at IS NULL`, subredditID).Scan(&ownerID)
+	if err != nil {
+		return fmt.Errorf("subreddit not found: %v", err)
+	}
+	if !ownerID.Valid || int(ownerID.Int64) != userID {
+		return ErrNotOwner
+	}
+	return nil
+}
+
+// SetSubredditArchived archives or unarchives a subreddit on behalf of its
+// head moderator
+func (dm *DatabaseManager) SetSubredditArchived(subredditID, userID int, archived bool) error {
+	defer dm.span("SetSubredditArchived").End()
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	tx, err := dm.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if err := checkOwner(tx, subredditID, userID); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	update, action := `UPDATE subreddits SET archived_at = NULL WHERE id = ?`, "unarchive_subreddit"
+	if archived {
+		update, action = `UPDATE subreddits SET archived_at = COALESCE(archived_at, CURRENT_TIMESTAMP) WHERE id = ?`, "archive_subreddit"
+	}
+	if _, err := tx.Exec(update, subredditID); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to %s: %v", action, err)
+	}
+
+	if err := logModAction(tx, subredditID, &userID, action, "subreddit", subredditID, ""); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// TransferSubreddit makes another moderator the head moderator of a
+// subreddit, returning their ID. The previous owner stays a moderator.
+func (dm *DatabaseManager) TransferSubreddit(subredditID, ownerID int, newOwner string) (int, error) {
+	defer dm.span("TransferSubreddit").End()
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	tx, err := dm.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+
+	if err := checkOwner(tx, subredditID, ownerID); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	var newOwnerID int
+	if err := tx.QueryRow(`SELECT id FROM users WHERE username = ?`, newOwner).Scan(&newOwnerID); err != nil {
+		tx.Rollback()
+		return 0, fmt.Errorf("user not found: %v", err)
+	}
+	if newOwnerID == ownerID {
+		tx.Rollback()
+		return 0, ErrAlreadyOwner
+	}
+	isMod, err := isModeratorTx(tx, newOwnerID, subredditID)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	if !isMod {
+		tx.Rollback()
+		return 0, ErrNotModerator
+	}
+
+	if _, err := tx.Exec(`UPDATE subreddits SET owner_id = ? WHERE id = ?`, newOwnerID, subredditID); err != nil {
+		tx.Rollback()
+		return 0, fmt.Errorf("failed to transfer subreddit: %v", err)
+	}
+
+	if err := logModAction(tx, subredditID, &ownerID, "transfer_ownership", "user", newOwnerID, newOwner); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	if err := createNotification(tx, newOwnerID, "mod_action", ownerID, notificationRefs{SubredditID: &subredditID}); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	return newOwnerID, tx.Commit()
+}
+
+// TransferSubredditRequest is the body of POST /subreddits/:id/transfer
+type TransferSubredditRequest struct {
+	Username string `json:"username" binding:"required"`
+}
+
+// archiveSubreddit returns a handler archiving the subreddit, or
+// unarchiving it when archived is false
+func (h *APIHandler) archiveSubreddit(archived bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		subredditID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.Error(newAPIError(http.StatusBadRequest, "Invalid subreddit ID"))
+			return
+		}
+
+		userID, _ := strconv.Atoi(c.GetString("user_id"))
+		if err := h.dbFor(c).SetSubredditArchived(subredditID, userID, archived); err != nil {
+			c.Error(err)
+			return
+		}
+
+		message := "Subreddit archived"
+		if !archived {
+			message = "Subreddit unarchived"
+		}
+		c.JSON(http.StatusOK, gin.H{"message": message})
+	}
+}
+
+// transferSubreddit hands the subreddit's ownership to another moderator
+func (h *APIHandler) transferSubreddit(c *gin.Context) {
+	subredditID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.Error(newAPIError(http.StatusBadRequest, "Invalid subreddit ID"))
+		return
+	}
+
+	var req TransferSubredditRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(withStatus(http.StatusBadRequest, err))
+		return
+	}
+
+	userID, _ := strconv.Atoi(c.GetString("user_id"))
+	if _, err := h.dbFor(c).TransferSubreddit(subredditID, userID, req.Username); err != nil {
+		c.Error(err)
+		return
+	}
+	h.publishNotifications()
+
+	c.JSON(http.StatusOK, gin.H{"message": "Ownership transferred to " + req.Username})
+}
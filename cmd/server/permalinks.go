@@ -0,0 +1,126 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxSlugLength caps how much of a title a post's slug keeps
+const maxSlugLength = 50
+
+// slugify turns a post title into the slug of its permalink, e.g. "Hello,
+// World!" into "hello_world": lowercase letters and digits, with anything
+// else between words turned into one underscore. Long titles are cut at a
+// word, and titles without a letter or digit become "post".
+func slugify(title string) string {
+	var b strings.Builder
+	gap := false
+	for _, r := range strings.ToLower(title) {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' {
+			if gap && b.Len() > 0 {
+				b.WriteByte('_')
+			}
+			b.WriteRune(r)
+			gap = false
+		} else {
+			gap = true
+		}
+	}
+
+	slug := b.String()
+	if len(slug) > maxSlugLength {
+		slug = slug[:maxSlugLength]
+		if i := strings.LastIndexByte(slug, '_'); i > 0 {
+			slug = slug[:i]
+		}
+	}
+	if slug == "" {
+		return "post"
+	}
+	return slug
+}
+
+// postPermalink is the canonical path of a post,
+// /r/:name/comments/:post_id/:slug
+func postPermalink(post Post) string {
+	return fmt.Sprintf("/r/%s/comments/%d/%s", post.SubredditName, post.ID, post.Slug)
+}
+
+// backfillPostSlugs gives posts made before slugs existed one from their
+// title
+func backfillPostSlugs(db *sql.DB) error {
+	rows, err := db.Query(`SELECT id, title FROM posts WHERE slug = ''`)
+	if err != nil {
+		return fmt.Errorf("failed to find posts without slugs: %v", err)
+	}
+	slugs := make(map[int]string)
+	for rows.Next() {
+		var id int
+		var title string
+		if err := rows.Scan(&id, &title); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to find posts without slugs: %v", err)
+		}
+		slugs[id] = slugify(title)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to find posts without slugs: %v", err)
+	}
+
+	for id, slug := range slugs {
+		if _, err := db.Exec(`UPDATE posts SET slug = ? WHERE id = ?`, slug, id); err != nil {
+			return fmt.Errorf("failed to backfill the slug of post %d: %v", id, err)
+		}
+	}
+	return nil
+}
+
+// getSubredditByName returns the subreddit named :name
+func (h *APIHandler) getSubredditByName(c *gin.Context) {
+	subredditID, err := h.dbFor(c).GetSubredditIDByName(c.Param("name"))
+	if err != nil {
+		c.Error(newAPIError(http.StatusNotFound, "Subreddit not found"))
+		return
+	}
+
+	subreddit, err := h.dbFor(c).GetSubreddit(subredditID)
+	if err != nil {
+		c.Error(newAPIError(http.StatusNotFound, "Subreddit not found"))
+		return
+	}
+
+	c.JSON(http.StatusOK, subreddit)
+}
+
+// getPostByPermalink returns the post at a permalink. Permalinks with a
+// wrong slug or subreddit name, such as one typed by hand, are redirected to
+// the canonical one as long as the post ID is right.
+func (h *APIHandler) getPostByPermalink(c *gin.Context) {
+	postID, err := strconv.Atoi(c.Param("post_id"))
+	if err != nil {
+		c.Error(newAPIError(http.StatusBadRequest, "Invalid post ID"))
+		return
+	}
+
+	post, err := h.dbFor(c).GetPost(postID)
+	if err != nil {
+		c.Error(newAPIError(http.StatusNotFound, "Post not found"))
+		return
+	}
+	if c.Param("name") != post.SubredditName || c.Param("slug") != post.Slug {
+		c.Redirect(http.StatusMovedPermanently, post.Permalink)
+		return
+	}
+
+	// The permalink is public, so there's only a viewer with a session
+	userID, _ := strconv.Atoi(c.GetString("user_id"))
+	recordPostView(h.dbFor(c), post, userID)
+
+	c.JSON(http.StatusOK, post)
+}
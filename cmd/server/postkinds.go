@@ -0,0 +1,155 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+	"unicode/utf8"
+)
+
+// Post kinds and subreddit content settings
+//
+// A post is a text post, a link, an image or a poll. A link's content is the
+// http or https URL it shares and an image's the URL of the image, while a
+// poll's content is its options, one per line. A post can also be a
+// crosspost of a post from another subreddit.
+//
+// Subreddits choose which kinds of post they accept, how long titles must be
+// at least and whether they take crossposts. CreatePost enforces these,
+// failing with ErrPostNotAllowed wrapped in a message naming the rule that
+// was broken.
+
+const (
+	postKindText  = "text"
+	postKindLink  = "link"
+	postKindImage = "image"
+	postKindPoll  = "poll"
+)
+
+// postKinds are the kinds of post, all of which subreddits accept by default
+var postKinds = []string{postKindText, postKindLink, postKindImage, postKindPoll}
+
+const (
+	minPollOptions = 2
+	maxPollOptions = 10
+)
+
+// imageExtensions are the extensions an image post's URL can end in
+var imageExtensions = map[string]bool{".png": true, ".jpg": true, ".jpeg": true, ".gif": true, ".webp": true}
+
+// ErrPostNotAllowed is returned, wrapped in the reason, for posts that
+// aren't valid for their kind or that break their subreddit's content
+// settings
+var ErrPostNotAllowed = errors.New("post not allowed")
+
+// validatePostKind checks a post's content is what its kind needs
+func validatePostKind(kind, content string) error {
+	switch kind {
+	case postKindText:
+	case postKindLink, postKindImage:
+		u, err := url.Parse(strings.TrimSpace(content))
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("%w: a %s post's content must be an http or https URL", ErrPostNotAllowed, kind)
+		}
+		if kind == postKindImage && !imageExtensions[strings.ToLower(path.Ext(u.Path))] {
+			return fmt.Errorf("%w: an image post's URL must end in .png, .jpg, .jpeg, .gif or .webp", ErrPostNotAllowed)
+		}
+	case postKindPoll:
+		options := 0
+		for _, line := range strings.Split(content, "\n") {
+			if strings.TrimSpace(line) != "" {
+				options++
+			}
+		}
+		if options < minPollOptions || options > maxPollOptions {
+			return fmt.Errorf("%w: a poll needs %d to %d options, one per line", ErrPostNotAllowed, minPollOptions, maxPollOptions)
+		}
+	default:
+		return fmt.Errorf("%w: the kind of post must be one of %s", ErrPostNotAllowed, strings.Join(postKinds, ", "))
+	}
+	return nil
+}
+
+// parsePostKinds reads the comma-separated kinds stored in
+// subreddit_settings
+func parsePostKinds(stored string) []string {
+	kinds := []string{}
+	for _, kind := range strings.Split(stored, ",") {
+		if kind = strings.TrimSpace(kind); kind != "" {
+			kinds = append(kinds, kind)
+		}
+	}
+	return kinds
+}
+
+// normalizePostKinds dedupes kinds and puts them in the order of postKinds
+func normalizePostKinds(kinds []string) []string {
+	normalized := []string{}
+	for _, kind := range postKinds {
+		for _, k := range kinds {
+			if k == kind {
+				normalized = append(normalized, kind)
+				break
+			}
+		}
+	}
+	return normalized
+}
+
+// checkPostContent fails with ErrPostNotAllowed when a new post isn't valid
+// for its kind or breaks its subreddit's content settings
+func checkPostContent(tx *sql.Tx, subredditID int, title, content, kind string, crosspostOf *int) error {
+	if err := validatePostKind(kind, content); err != nil {
+		return err
+	}
+
+	var allowed string
+	var minTitleLength int
+	var allowCrossposts bool
+	err := tx.QueryRow(`
+		SELECT allowed_post_types, min_title_length, allow_crossposts
+		FROM subreddit_settings WHERE subreddit_id = ?
+	`, subredditID).Scan(&allowed, &minTitleLength, &allowCrossposts)
+	switch {
+	case err == sql.ErrNoRows:
+		allowed, allowCrossposts = strings.Join(postKinds, ","), true
+	case err != nil:
+		return fmt.Errorf("failed to get content settings: %v", err)
+	}
+
+	accepted := parsePostKinds(allowed)
+	isAccepted := false
+	for _, k := range accepted {
+		isAccepted = isAccepted || k == kind
+	}
+	if !isAccepted {
+		return fmt.Errorf("%w: this subreddit doesn't accept %s posts, only %s", ErrPostNotAllowed, kind, strings.Join(accepted, ", "))
+	}
+
+	if utf8.RuneCountInString(strings.TrimSpace(title)) < minTitleLength {
+		return fmt.Errorf("%w: titles in this subreddit must be at least %d characters", ErrPostNotAllowed, minTitleLength)
+	}
+
+	if crosspostOf != nil {
+		if !allowCrossposts {
+			return fmt.Errorf("%w: this subreddit doesn't accept crossposts", ErrPostNotAllowed)
+		}
+		var sourceSubredditID int
+		err := tx.QueryRow(`SELECT subreddit_id FROM posts WHERE id = ? AND removed = 0 AND deleted_at IS NULL`, *crosspostOf).
+			Scan(&sourceSubredditID)
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("%w: the crossposted post doesn't exist", ErrPostNotAllowed)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to find the crossposted post: %v", err)
+		}
+		if sourceSubredditID == subredditID {
+			return fmt.Errorf("%w: a post can't be crossposted to its own subreddit", ErrPostNotAllowed)
+		}
+	}
+
+	return nil
+}
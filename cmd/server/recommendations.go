@@ -0,0 +1,224 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Subreddit recommendations
+//
+// Users are recommended the subreddits joined by users whose memberships
+// overlap with theirs. How similar two users are is the Jaccard similarity
+// of the subreddits they've joined, and a subreddit's score for a user is the
+// sum of the similarities of the users in it. The recommendations are
+// computed by the subreddit_recommendations job into the
+// subreddit_recommendations table, and served from there.
+
+// How often recommendations are recomputed, and how many are kept for each
+// user, which caps ?limit= on GET /recommendations/subreddits
+const (
+	recommendationInterval = time.Hour
+	recommendationsPerUser = 20
+)
+
+// SubredditRecommendation is a subreddit suggested to a user, with how
+// strongly. Subreddits suggested for lack of recommendations score 0.
+type SubredditRecommendation struct {
+	SubredditListing
+	Score float64 `json:"score"`
+}
+
+// ComputeSubredditRecommendations replaces every user's recommendations with
+// the subreddits similar users have joined, and returns how many users got
+// any. The recommendations are computed from a snapshot of the memberships
+// without holding the lock, which is only taken for writing to replace the
+// stored ones.
+func (dm *DatabaseManager) ComputeSubredditRecommendations(perUser int) (int, error) {
+	defer dm.span("ComputeSubredditRecommendations").End()
+
+	joined, members, err := dm.loadMemberships()
+	if err != nil {
+		return 0, err
+	}
+
+	type scored struct {
+		subredditID int
+		score       float64
+	}
+	recommendations := make(map[int][]scored)
+	for userID, subreddits := range joined {
+		// How many subreddits the user shares with everyone sharing any
+		overlap := make(map[int]int)
+		for subredditID := range subreddits {
+			for _, other := range members[subredditID] {
+				if other != userID {
+					overlap[other]++
+				}
+			}
+		}
+
+		scores := make(map[int]float64)
+		for other, shared := range overlap {
+			similarity := float64(shared) / float64(len(subreddits)+len(joined[other])-shared)
+			for subredditID := range joined[other] {
+				if !subreddits[subredditID] {
+					scores[subredditID] += similarity
+				}
+			}
+		}
+
+		ranked := make([]scored, 0, len(scores))
+		for subredditID, score := range scores {
+			ranked = append(ranked, scored{subredditID, score})
+		}
+		sort.Slice(ranked, func(i, j int) bool {
+			if ranked[i].score != ranked[j].score {
+				return ranked[i].score > ranked[j].score
+			}
+			return ranked[i].subredditID < ranked[j].subredditID
+		})
+		if len(ranked) > perUser {
+			ranked = ranked[:perUser]
+		}
+		if len(ranked) > 0 {
+			recommendations[userID] = ranked
+		}
+	}
+
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	tx, err := dm.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec(`DELETE FROM subreddit_recommendations`); err != nil {
+		tx.Rollback()
+		return 0, fmt.Errorf("failed to clear subreddit recommendations: %v", err)
+	}
+	for userID, ranked := range recommendations {
+		for _, r := range ranked {
+			_, err := tx.Exec(`
+				INSERT INTO subreddit_recommendations (user_id, subreddit_id, score)
+				VALUES (?, ?, ?)
+			`, userID, r.subredditID, r.score)
+			if err != nil {
+				tx.Rollback()
+				return 0, fmt.Errorf("failed to store subreddit recommendation: %v", err)
+			}
+		}
+	}
+
+	return len(recommendations), tx.Commit()
+}
+
+// loadMemberships returns the subreddits each user has joined and the members
+// of each subreddit, leaving out deleted subreddits
+func (dm *DatabaseManager) loadMemberships() (map[int]map[int]bool, map[int][]int, error) {
+	dm.mu.RLock()
+	defer dm.mu.RUnlock()
+
+	rows, err := dm.db.Query(`
+		SELECT sm.user_id, sm.subreddit_id
+		FROM subreddit_members sm
+		JOIN subreddits s ON sm.subreddit_id = s.id
+		WHERE s.deleted_at IS NULL
+	`)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load memberships: %v", err)
+	}
+	joined := make(map[int]map[int]bool) // user to their subreddits
+	members := make(map[int][]int)       // subreddit to its members
+	for rows.Next() {
+		var userID, subredditID int
+		if err := rows.Scan(&userID, &subredditID); err != nil {
+			rows.Close()
+			return nil, nil, fmt.Errorf("failed to load memberships: %v", err)
+		}
+		if joined[userID] == nil {
+			joined[userID] = make(map[int]bool)
+		}
+		joined[userID][subredditID] = true
+		members[subredditID] = append(members[subredditID], userID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("failed to load memberships: %v", err)
+	}
+
+	return joined, members, nil
+}
+
+// GetSubredditRecommendations returns the subreddits last recommended to a
+// user, best first, leaving out any they've joined since
+func (dm *DatabaseManager) GetSubredditRecommendations(userID, limit int) ([]SubredditRecommendation, error) {
+	defer dm.span("GetSubredditRecommendations").End()
+	dm.mu.RLock()
+	defer dm.mu.RUnlock()
+
+	rows, err := dm.db.Query(`
+		SELECT `+subredditListingColumns+`, r.score
+		FROM subreddit_recommendations r
+		JOIN subreddits s ON r.subreddit_id = s.id
+		WHERE r.user_id = ?2 AND s.deleted_at IS NULL
+		AND s.id NOT IN (SELECT subreddit_id FROM subreddit_members WHERE user_id = ?2)
+		ORDER BY r.score DESC, s.id
+		LIMIT ?3
+	`, fmt.Sprintf("-%d seconds", int(discoveryWindow.Seconds())), userID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get subreddit recommendations: %v", err)
+	}
+	defer rows.Close()
+
+	recommendations := []SubredditRecommendation{}
+	for rows.Next() {
+		var r SubredditRecommendation
+		err := rows.Scan(
+			&r.ID, &r.Name, &r.Description, &r.CreatedAt,
+			&r.MemberCount, &r.RecentActivity, &r.Score,
+		)
+		if err != nil {
+			return nil, err
+		}
+		recommendations = append(recommendations, r)
+	}
+	return recommendations, rows.Err()
+}
+
+// getSubredditRecommendations suggests subreddits to join based on the ones
+// the user has. Users without recommendations yet, such as those who haven't
+// joined anything, get the most active subreddits instead.
+func (h *APIHandler) getSubredditRecommendations(c *gin.Context) {
+	limit := 10
+	if parsedLimit, err := strconv.Atoi(c.Query("limit")); err == nil && parsedLimit > 0 {
+		limit = parsedLimit
+	}
+	if limit > recommendationsPerUser {
+		limit = recommendationsPerUser
+	}
+
+	userID, _ := strconv.Atoi(c.GetString("user_id"))
+	recommendations, err := h.dbFor(c).GetSubredditRecommendations(userID, limit)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	if len(recommendations) == 0 {
+		listings, err := h.dbFor(c).DiscoverSubreddits(userID, limit)
+		if err != nil {
+			c.Error(err)
+			return
+		}
+		for _, listing := range listings {
+			recommendations = append(recommendations, SubredditRecommendation{SubredditListing: listing})
+		}
+	}
+
+	c.JSON(http.StatusOK, recommendations)
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Related posts
+//
+// "More like this" posts are found through posts_fts, a full-text index of
+// post titles and content that triggers keep in step with posts. A post's
+// related posts are those matching any of the terms of its title or the
+// start of its content, ranked by BM25 with title matches counting double,
+// and boosted when they share its subreddit or flair. Posts with too few
+// matches are topped up with the newest posts of their subreddit.
+
+// How many related posts GET /posts/:id/related returns by default and at
+// most, and how many content terms a post is matched on besides its title's
+const (
+	defaultRelatedPosts = 10
+	maxRelatedPosts     = 25
+	relatedContentTerms = 10
+)
+
+// indexPostsForSearch fills posts_fts with the posts made before it existed.
+// Later posts are indexed by its triggers.
+func indexPostsForSearch(db *sql.DB) error {
+	var indexed bool
+	err := db.QueryRow(`SELECT EXISTS (SELECT 1 FROM posts_fts)`).Scan(&indexed)
+	if err != nil {
+		return fmt.Errorf("failed to check the post search index: %v", err)
+	}
+	if indexed {
+		return nil
+	}
+
+	_, err = db.Exec(`INSERT INTO posts_fts (rowid, title, content) SELECT id, title, content FROM posts`)
+	if err != nil {
+		return fmt.Errorf("failed to index posts for search: %v", err)
+	}
+	return nil
+}
+
+// relatedMatchQuery is the FTS5 query related posts must match: any of the
+// terms of the post's title, or of the first few of its content, as phrases.
+// It's empty for posts with nothing worth matching on.
+func relatedMatchQuery(post *Post) string {
+	terms := extractTitleTerms(post.Title)
+	contentTerms := extractTitleTerms(post.Content)
+	if len(contentTerms) > relatedContentTerms {
+		contentTerms = contentTerms[:relatedContentTerms]
+	}
+	terms = append(terms, contentTerms...)
+
+	// Terms are only letters, digits and spaces, so quoting them is enough
+	seen := make(map[string]bool)
+	phrases := make([]string, 0, len(terms))
+	for _, term := range terms {
+		if !seen[term] {
+			seen[term] = true
+			phrases = append(phrases, `"`+term+`"`)
+		}
+	}
+	return strings.Join(phrases, " OR ")
+}
+
+// GetRelatedPosts returns up to limit visible posts like post: those sharing
+// terms with it first, then others from its subreddit
+func (dm *DatabaseManager) GetRelatedPosts(post *Post, limit int) ([]Post, error) {
+	defer dm.span("GetRelatedPosts").End()
+	dm.mu.RLock()
+	defer dm.mu.RUnlock()
+
+	posts := []Post{}
+	if match := relatedMatchQuery(post); match != "" {
+		// bm25 is lower for better matches, so the boosts multiply it
+		rows, err := dm.db.Query(`
+			SELECT `+postColumns+`
+			FROM posts_fts
+			JOIN posts p ON p.id = posts_fts.rowid
+			JOIN users u ON p.author_id = u.id
+			JOIN subreddits s ON p.subreddit_id = s.id
+			WHERE posts_fts MATCH ?1 AND p.id != ?2 AND p.removed = 0 AND p.deleted_at IS NULL
+			AND s.deleted_at IS NULL
+			ORDER BY bm25(posts_fts, 2.0, 1.0) * (1
+				+ 0.5 * (p.subreddit_id = ?3)
+				+ 0.5 * (?4 != '' AND COALESCE(p.flair, '') = ?4))
+			LIMIT ?5
+		`, match, post.ID, post.SubredditID, post.Flair, limit)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get related posts: %v", err)
+		}
+		posts, err = scanPosts(rows)
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(posts) >= limit {
+		return posts, nil
+	}
+
+	exclude := []interface{}{post.ID}
+	placeholders := []string{"?"}
+	for _, p := range posts {
+		exclude = append(exclude, p.ID)
+		placeholders = append(placeholders, "?")
+	}
+	args := append([]interface{}{post.SubredditID}, exclude...)
+	args = append(args, post.Flair, post.Flair, limit-len(posts))
+
+	rows, err := dm.db.Query(`
+		SELECT `+postColumns+`
+		FROM posts p
+		JOIN users u ON p.author_id = u.id
+		JOIN subreddits s ON p.subreddit_id = s.id
+		WHERE p.subreddit_id = ? AND p.id NOT IN (`+strings.Join(placeholders, ", ")+`)
+		AND p.removed = 0 AND p.deleted_at IS NULL
+		ORDER BY (? != '' AND COALESCE(p.flair, '') = ?) DESC, p.created_at DESC
+		LIMIT ?
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get related posts: %v", err)
+	}
+	defer rows.Close()
+
+	more, err := scanPosts(rows)
+	if err != nil {
+		return nil, err
+	}
+	return append(posts, more...), nil
+}
+
+// getRelatedPosts returns posts like post :id, for "more like this" lists.
+// ?limit= defaults to 10 and is capped at 25.
+func (h *APIHandler) getRelatedPosts(c *gin.Context) {
+	postID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.Error(newAPIError(http.StatusBadRequest, "Invalid post ID"))
+		return
+	}
+
+	limit := defaultRelatedPosts
+	if parsedLimit, err := strconv.Atoi(c.Query("limit")); err == nil && parsedLimit > 0 {
+		limit = parsedLimit
+	}
+	if limit > maxRelatedPosts {
+		limit = maxRelatedPosts
+	}
+
+	post, err := h.dbFor(c).GetPost(postID)
+	if err != nil {
+		c.Error(newAPIError(http.StatusNotFound, "Post not found"))
+		return
+	}
+
+	posts, err := h.dbFor(c).GetRelatedPosts(post, limit)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, posts)
+}
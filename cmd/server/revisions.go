@@ -0,0 +1,154 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Edit history
+//
+// Every edit that changes a post's or comment's content keeps what it
+// replaced as a revision, so GET /posts/:id/history and
+// /comments/:id/history can show how the content got to what it is now.
+// Subreddits can make the history of their content visible to their
+// moderators only with the edit_history_mod_only setting; admins can always
+// see it. History of removed or deleted content is only shown to moderators
+// and admins.
+
+// ErrHistoryHidden is returned when a subreddit shows its edit history to
+// moderators only
+var ErrHistoryHidden = errors.New("this subreddit's edit history is visible to moderators only")
+
+// Revision is the content of a post or comment before one of its edits
+type Revision struct {
+	ID         int       `json:"id"`
+	Content    string    `json:"content"`
+	ReplacedAt time.Time `json:"replaced_at"` // when the edit replaced it
+}
+
+// EditHistory is a post's or comment's current content and its revisions
+type EditHistory struct {
+	TargetType string     `json:"target_type"`
+	TargetID   int        `json:"target_id"`
+	Content    string     `json:"content"`
+	EditedAt   *time.Time `json:"edited_at"`
+	Revisions  []Revision `json:"revisions"` // newest first
+}
+
+// recordRevision keeps the content a post or comment has before it's edited
+// to content, unless the edit leaves it unchanged
+func recordRevision(tx *sql.Tx, targetType string, targetID int, content string) error {
+	table := "posts"
+	if targetType == "comment" {
+		table = "comments"
+	}
+
+	_, err := tx.Exec(`
+		INSERT INTO revisions (target_type, target_id, content)
+		SELECT ?, id, content FROM `+table+` WHERE id = ? AND content != ?
+	`, targetType, targetID, content)
+	if err != nil {
+		return fmt.Errorf("failed to record revision: %v", err)
+	}
+	return nil
+}
+
+// GetEditHistory returns the edit history of a post or comment as the
+// viewer may see it
+func (dm *DatabaseManager) GetEditHistory(viewerID int, isAdmin bool, targetType string, targetID int) (*EditHistory, error) {
+	defer dm.span("GetEditHistory").End()
+	dm.mu.RLock()
+	defer dm.mu.RUnlock()
+
+	history := EditHistory{TargetType: targetType, TargetID: targetID, Revisions: []Revision{}}
+	var subredditID int
+	var hidden, modOnly bool
+	var editedAt sql.NullTime
+	var err error
+	if targetType == "post" {
+		err = dm.db.QueryRow(`
+			SELECT p.content, p.edited_at, p.subreddit_id, p.removed = 1 OR p.deleted_at IS NOT NULL,
+				COALESCE(ss.edit_history_mod_only, 0)
+			FROM posts p
+			LEFT JOIN subreddit_settings ss ON ss.subreddit_id = p.subreddit_id
+			WHERE p.id = ?
+		`, targetID).Scan(&history.Content, &editedAt, &subredditID, &hidden, &modOnly)
+	} else {
+		err = dm.db.QueryRow(`
+			SELECT c.content, c.edited_at, p.subreddit_id, c.removed = 1 OR c.deleted_at IS NOT NULL,
+				COALESCE(ss.edit_history_mod_only, 0)
+			FROM comments c
+			JOIN posts p ON c.post_id = p.id
+			LEFT JOIN subreddit_settings ss ON ss.subreddit_id = p.subreddit_id
+			WHERE c.id = ?
+		`, targetID).Scan(&history.Content, &editedAt, &subredditID, &hidden, &modOnly)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s not found: %v", targetType, err)
+	}
+	if editedAt.Valid {
+		history.EditedAt = &editedAt.Time
+	}
+
+	if (hidden || modOnly) && !isAdmin {
+		var isMod bool
+		err := dm.db.QueryRow(`
+			SELECT EXISTS (SELECT 1 FROM subreddit_moderators WHERE subreddit_id = ? AND user_id = ?)
+		`, subredditID, viewerID).Scan(&isMod)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check moderators: %v", err)
+		}
+		if !isMod && hidden {
+			return nil, fmt.Errorf("%s not found", targetType)
+		}
+		if !isMod {
+			return nil, ErrHistoryHidden
+		}
+	}
+
+	rows, err := dm.db.Query(`
+		SELECT id, content, created_at FROM revisions
+		WHERE target_type = ? AND target_id = ?
+		ORDER BY id DESC
+	`, targetType, targetID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get revisions: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var revision Revision
+		if err := rows.Scan(&revision.ID, &revision.Content, &revision.ReplacedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan revision: %v", err)
+		}
+		history.Revisions = append(history.Revisions, revision)
+	}
+	return &history, rows.Err()
+}
+
+// getEditHistory returns a handler listing the edit history of the post or
+// comment named by the :id path parameter
+func (h *APIHandler) getEditHistory(targetType string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		targetID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.Error(newAPIError(http.StatusBadRequest, "Invalid "+targetType+" ID"))
+			return
+		}
+
+		userID, _ := strconv.Atoi(c.GetString("user_id"))
+		history, err := h.dbFor(c).GetEditHistory(userID, h.isAdmin(c), targetType, targetID)
+		if err != nil {
+			c.Error(err)
+			return
+		}
+
+		c.JSON(http.StatusOK, history)
+	}
+}
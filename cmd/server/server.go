@@ -0,0 +1,325 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/asynkron/protoactor-go/actor"
+	"github.com/gin-gonic/gin"
+
+	"github.com/ArjunKaliyath/GoReddit/internal/config"
+)
+
+// Server is the REST API server: the handler, the actor pool it sends writes
+// to, and the router in front of them
+type Server struct {
+	Handler *APIHandler
+	Pool    *ActorPool
+	Router  *gin.Engine
+
+	cfg       *config.Config
+	system    *actor.ActorSystem
+	publisher EventPublisher // set by Start with events.broker
+}
+
+// NewServer builds the server for cfg on an open database. It doesn't
+// listen, start background work or join a cluster, so tests can serve Router
+// with httptest against a database from InitDatabase(":memory:"), and call
+// Start when they need the background work.
+func NewServer(cfg *config.Config, db *DatabaseManager) (*Server, error) {
+	handler, err := NewAPIHandler(db)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, id := range cfg.AdminUserIDs {
+		handler.admins[id] = true
+	}
+
+	if cfg.Standby.Dir != "" {
+		if handler.standby, err = NewStandby(cfg.Standby.Dir, cfg.Standby.Retain); err != nil {
+			return nil, fmt.Errorf("failed to set up standby: %v", err)
+		}
+	}
+
+	// Emails go through SMTP_HOST when it's set and are only logged otherwise
+	if handler.mailer, err = newMailerFromEnv(); err != nil {
+		return nil, fmt.Errorf("invalid mail settings: %v", err)
+	}
+	handler.publicURL = strings.TrimRight(cfg.PublicURL, "/")
+	for _, origin := range cfg.AllowedOrigins {
+		handler.allowedOrigins = append(handler.allowedOrigins, originOf(origin))
+	}
+
+	if cfg.Captcha.Provider != "" {
+		handler.captcha = newCaptchaVerifier(cfg.Captcha)
+	}
+
+	// Domain events always feed the real-time pushes, and the log and
+	// webhook sinks when they're configured. The broker sink is added by
+	// Start, since it connects to the broker.
+	if cfg.Events.Log {
+		handler.events.Subscribe(logSink{})
+	}
+	if cfg.Events.WebhookURL != "" {
+		handler.events.Subscribe(newWebhookSink(cfg.Events.WebhookURL, cfg.Events.WebhookSecret))
+	}
+
+	// The startup pool size holds until a runtime config sets one
+	handler.configDefaults.ActorPoolSize = cfg.ActorPoolSize
+	handler.config = handler.configDefaults
+
+	// Runtime config can be reloaded later with SIGHUP or the admin API
+	if handler.configPath = cfg.RuntimeConfig; handler.configPath != "" {
+		runtimeConfig, err := loadRuntimeConfig(handler.configPath, handler.configDefaults)
+		if err == nil {
+			err = handler.applyConfig(runtimeConfig)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid config: %v", err)
+		}
+	}
+
+	// Create actor pool, sized by the runtime config
+	system := actor.NewActorSystem()
+	pool, err := NewActorPool(system, handler, handler.config.ActorPoolSize, ActorPoolOptions{
+		MailboxSize:       cfg.ActorMailboxSize,
+		Timeout:           cfg.ActorRequestTimeout,
+		VoteFlushInterval: cfg.VoteFlushInterval,
+	})
+	if err != nil {
+		return nil, err
+	}
+	handler.pool = pool
+	handler.registerJobs()
+
+	return &Server{
+		Handler: handler,
+		Pool:    pool,
+		Router:  newRouter(handler, pool),
+		cfg:     cfg,
+		system:  system,
+	}, nil
+}
+
+// Start starts the server's background work: delivering domain events, the
+// scheduled jobs and the delivery loops, and the gRPC API when grpc_addr is
+// set
+func (s *Server) Start() error {
+	handler, cfg := s.Handler, s.cfg
+
+	if cfg.Events.Broker != "" {
+		publisher, err := newEventPublisher(cfg.Events)
+		if err != nil {
+			return fmt.Errorf("failed to set up event broker: %v", err)
+		}
+		s.publisher = publisher
+		handler.db.EnableOutbox()
+		go runOutboxRelay(handler, publisher, cfg.Events.TopicPrefix)
+	}
+	go handler.events.Run()
+	go reloadConfigOnSignal(handler)
+
+	if handler.standby != nil {
+		go runStandbyJob(handler, cfg.Standby.Interval)
+	}
+	handler.scheduler.Start()
+	go runModWebhookJob(handler)
+	go runEmailJob(handler)
+	go runMirrorJob(handler)
+
+	// The gRPC API is served next to the REST API when grpc_addr is set
+	if cfg.GRPCAddr != "" {
+		go func() {
+			log.Fatalf("gRPC server failed: %v", serveGRPC(handler, cfg.GRPCAddr))
+		}()
+	}
+	return nil
+}
+
+// Close releases what the server holds, except the database, which belongs
+// to the caller
+func (s *Server) Close() {
+	if s.publisher != nil {
+		s.publisher.Close()
+	}
+}
+
+// ServeHTTP serves a request with the router
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.Router.ServeHTTP(w, r)
+}
+
+// newRouter registers the REST API's routes
+func newRouter(handler *APIHandler, actorPool *ActorPool) *gin.Engine {
+	r := gin.Default()
+	r.Use(tracingMiddleware(), gzipMiddleware(), errorResponses())
+
+	// Read endpoints that clients poll answer If-None-Match with 304
+	etag := etagMiddleware()
+
+	// Public routes
+	r.GET("/health", handler.health)
+	r.GET("/metrics", handler.metricsHandler)
+	r.GET("/openapi.json", handler.serveOpenAPI)
+	r.GET("/docs", handler.serveSwaggerUI)
+	r.POST("/register", handler.registerUser)
+	r.GET("/captcha", handler.getCaptchaSettings)
+	r.POST("/login", handler.login)
+	r.GET("/verify-email", handler.verifyEmail)
+	r.GET("/users/:username", etag, handler.getUserByUsername)
+	r.GET("/users/:username/awards", etag, handler.getUserAwards)
+	r.GET("/users/:username/trophies", etag, handler.getUserTrophies)
+	r.GET("/awards", handler.getAwardTypes)
+	r.GET("/r/:name", etag, handler.getSubredditByName)
+	r.GET("/r/:name/about", etag, handler.getSubredditAbout)
+	r.GET("/r/:name/comments/:post_id/:slug", etag, handler.getPostByPermalink)
+	r.GET("/r/:name/feed.rss", handler.getSubredditRSS)
+	r.GET("/u/:username/feed.rss", handler.getUserRSS)
+	r.GET("/r/:name/hot.json", etag, handler.redditSubredditListing("hot"))
+	r.GET("/r/:name/new.json", etag, handler.redditSubredditListing("latest"))
+	r.GET("/comments/:id", etag, handler.redditCommentsListing)
+
+	// Protected routes
+	authorized := r.Group("/")
+	authorized.Use(authMiddleware(handler.db, len(handler.admins) == 0), handler.rateLimitWrites())
+	{
+		// Account routes
+		authorized.POST("/logout", handler.logout)
+		authorized.GET("/users/me/sessions", handler.getSessions)
+		authorized.DELETE("/users/me/sessions/:session_id", handler.revokeSession)
+		authorized.GET("/users/me/profile", handler.getProfile)
+		authorized.PUT("/users/me/profile", handler.updateProfile)
+		authorized.GET("/users/me/betas", handler.getBetas)
+		authorized.POST("/users/me/betas/:name", handler.setBetaOptIn(true))
+		authorized.DELETE("/users/me/betas/:name", handler.setBetaOptIn(false))
+
+		// Use actor pool handlers for more complex operations
+		authorized.POST("/posts", ActorPoolHandler(actorPool, "create_post"))
+		authorized.POST("/comments", ActorPoolHandler(actorPool, "create_comment"))
+		authorized.PUT("/posts/:id", handler.editPost)
+		authorized.PUT("/comments/:comment_id", handler.editComment)
+		authorized.DELETE("/posts/:id", handler.deleteContent("post", "id"))
+		authorized.POST("/posts/:id/restore", handler.restoreContent("post", "id"))
+		authorized.DELETE("/comments/:comment_id", handler.deleteContent("comment", "comment_id"))
+		authorized.POST("/comments/:comment_id/restore", handler.restoreContent("comment", "comment_id"))
+		authorized.GET("/posts/:id/history", handler.getEditHistory("post"))
+		authorized.GET("/comments/:id/history", handler.getEditHistory("comment"))
+		authorized.POST("/posts/:id/awards", handler.giveAward("post", "id"))
+		authorized.GET("/posts/:id/insights", handler.getPostInsights)
+		authorized.POST("/posts/:id/share", handler.sharePost)
+		authorized.GET("/posts/:id/related", etag, handler.getRelatedPosts)
+		authorized.POST("/comments/:comment_id/awards", handler.giveAward("comment", "comment_id"))
+		authorized.POST("/messages", ActorPoolHandler(actorPool, "send_message"))
+		authorized.POST("/subreddits", ActorPoolHandler(actorPool, "create_subreddit"))
+		authorized.POST("/subreddits/:id/join", ActorPoolHandler(actorPool, "join_subreddit"))
+		authorized.POST("/vote", ActorPoolHandler(actorPool, "vote"))
+		authorized.POST("/votes/batch", handler.voteBatch)
+		authorized.POST("/subreddits/:id/leave", ActorPoolHandler(actorPool, "leave_subreddit"))
+		authorized.POST("/graphql", handler.serveGraphQL)
+
+		// other routes that don't need complex processing
+		authorized.GET("/feed", etag, handler.getFeed)
+		authorized.GET("/feed/following", etag, handler.getFollowingFeed)
+		authorized.GET("/all", etag, handler.getAllFeed)
+		authorized.GET("/popular", etag, handler.getPopularFeed)
+		authorized.GET("/messages", etag, handler.getConversations)
+		authorized.GET("/messages/with/:user_id", handler.getDirectMessageThread)
+		authorized.POST("/messages/with/:user_id/read", handler.markThreadRead)
+		authorized.POST("/messages/:message_id/read", handler.markDirectMessageRead)
+		authorized.DELETE("/messages/:message_id", handler.deleteDirectMessage)
+		authorized.GET("/me/unread", etag, handler.getUnreadCounts)
+		authorized.GET("/ws", handler.serveWebSocket)
+		authorized.GET("/posts/:id/comments/stream", handler.streamPostComments)
+		authorized.POST("/chats", handler.createChatRoom)
+		authorized.GET("/chats", handler.getChatRooms)
+		authorized.GET("/chats/:room_id", handler.getChatRoom)
+		authorized.POST("/chats/:room_id/members", handler.inviteChatMember)
+		authorized.DELETE("/chats/:room_id/members/:user_id", handler.removeChatMember)
+		authorized.GET("/chats/:room_id/messages", handler.getChatMessages)
+		authorized.POST("/chats/:room_id/messages", handler.sendChatMessage)
+		authorized.GET("/notifications", etag, handler.getNotifications)
+		authorized.GET("/notifications/preferences", handler.getNotificationPreferences)
+		authorized.PUT("/notifications/preferences", handler.updateNotificationPreferences)
+		authorized.GET("/users/me/email", handler.getEmailSettings)
+		authorized.PUT("/users/me/email", handler.updateEmailSettings)
+		authorized.POST("/users/me/email/verify", handler.resendVerificationEmail)
+		authorized.GET("/notifications/unread-count", etag, handler.getUnreadNotificationCount)
+		authorized.POST("/notifications/read-all", handler.markAllNotificationsRead)
+		authorized.POST("/notifications/:notification_id/read", handler.markNotificationRead)
+		authorized.GET("/users/top", etag, handler.getTopUsers)
+		authorized.GET("/posts", etag, handler.getPosts)
+		authorized.GET("/posts/top", etag, handler.getTopPosts)
+		authorized.GET("/comments/top", etag, handler.getTopComments)
+		authorized.GET("/trending/topics", etag, handler.getTrendingTopics)
+		authorized.GET("/subscriptions", handler.getUserSubscriptions)
+		authorized.GET("/users/top-subscribed", etag, handler.getTopSubscribedUsers)
+		authorized.POST("/users/:user_id/subscribe", handler.subscribeToUser)
+		authorized.POST("/users/:user_id/unsubscribe", handler.unsubscribeFromUser)
+		authorized.GET("/subreddits/all", etag, handler.getAllSubreddits)
+		authorized.GET("/subreddits/joined", etag, handler.getUserJoinedSubreddits)
+		authorized.POST("/subreddits/join", handler.joinSubreddits)
+		authorized.GET("/onboarding", handler.getOnboarding)
+		authorized.GET("/subreddits/search", handler.searchSubreddits)
+		authorized.GET("/recommendations/subreddits", handler.getSubredditRecommendations)
+		authorized.GET("/subreddits/discover", handler.requireFeature("subreddit_discovery"), handler.discoverSubreddits)
+		authorized.GET("/subreddits/:id/feed", etag, handler.getSubredditFeed)
+		authorized.GET("/subreddits/:id/posts", etag, handler.getSubredditPosts)
+		authorized.GET("/subreddits/:id/top", etag, handler.getSubredditTopPosts)
+		authorized.GET("/subreddits/:id/settings", handler.getSubredditSettings)
+		authorized.GET("/subreddits/:id/rules", handler.getSubredditRules)
+		authorized.PUT("/subreddits/:id/rules", handler.updateSubredditRules)
+
+		// Moderator routes
+		authorized.GET("/subreddits/:id/automod", handler.getAutomodRules)
+		authorized.POST("/subreddits/:id/automod", handler.createAutomodRule)
+		authorized.DELETE("/subreddits/:id/automod/:rule_id", handler.deleteAutomodRule)
+		authorized.GET("/subreddits/:id/modqueue", handler.getModQueue)
+		authorized.PUT("/subreddits/:id/settings", handler.updateSubredditSettings)
+		authorized.POST("/subreddits/:id/remove", handler.removeContent)
+		authorized.POST("/subreddits/:id/archive", handler.archiveSubreddit(true))
+		authorized.POST("/subreddits/:id/unarchive", handler.archiveSubreddit(false))
+		authorized.POST("/subreddits/:id/transfer", handler.transferSubreddit)
+		authorized.GET("/subreddits/:id/bans", handler.getSubredditBans)
+		authorized.POST("/subreddits/:id/bans", handler.banUser)
+		authorized.DELETE("/subreddits/:id/bans/:user_id", handler.unbanUser)
+		authorized.POST("/subreddits/:id/pin", handler.pinPost)
+		authorized.POST("/subreddits/:id/flair", handler.setPostFlair)
+		authorized.GET("/subreddits/:id/modlog", handler.getModLog)
+		authorized.GET("/subreddits/:id/webhooks", handler.getModWebhooks)
+		authorized.POST("/subreddits/:id/webhooks", handler.createModWebhook)
+		authorized.DELETE("/subreddits/:id/webhooks/:webhook_id", handler.deleteModWebhook)
+		authorized.GET("/subreddits/:id/webhooks/:webhook_id/deliveries", handler.getModWebhookDeliveries)
+		authorized.GET("/subreddits/:id/mirrors", handler.getSubredditMirrors)
+		authorized.POST("/subreddits/:id/mirrors", handler.createSubredditMirror)
+		authorized.DELETE("/subreddits/:id/mirrors/:mirror_id", handler.deleteSubredditMirror)
+		authorized.GET("/subreddits/:id/modlists/export", handler.exportModLists)
+		authorized.POST("/subreddits/:id/modlists/import", handler.importModLists)
+
+		// Admin routes
+		admin := authorized.Group("/admin")
+		admin.Use(handler.requireAdmin(), handler.auditAdminActions())
+		admin.POST("/maintenance", handler.triggerMaintenance)
+		admin.POST("/reset-database", handler.resetDatabase)
+		admin.POST("/repair-comments", handler.repairCommentThreads)
+		admin.POST("/impersonate/:user_id", handler.impersonateUser)
+		admin.POST("/votes/bulk", handler.bulkVotes)
+		admin.GET("/votes", handler.getVotes)
+		admin.POST("/import", handler.importBundle)
+		admin.POST("/standby/snapshot", handler.triggerSnapshot)
+		admin.GET("/stats", handler.getSiteStats)
+		admin.GET("/config", handler.getConfig)
+		admin.GET("/jobs", handler.getJobs)
+		admin.GET("/jobs/:name", handler.getJob)
+		admin.POST("/jobs/:name/run", handler.triggerJob)
+		admin.POST("/config/reload", handler.reloadConfigHandler)
+		admin.GET("/audit", handler.getAdminAuditLog)
+		admin.DELETE("/subreddits/:id", handler.deleteSubreddit)
+		admin.POST("/subreddits/:id/restore", handler.restoreSubreddit)
+	}
+
+	checkAPIDocs(r.Routes(), apiDocs)
+	return r
+}
@@ -0,0 +1,418 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ArjunKaliyath/GoReddit/internal/config"
+)
+
+// newTestServer builds a server with the default config on a fresh in-memory
+// database
+func newTestServer(t *testing.T) *Server {
+	t.Helper()
+	return newTestServerWithConfig(t, config.Default())
+}
+
+// newTestServerWithConfig builds a server with cfg on a fresh in-memory
+// database
+func newTestServerWithConfig(t *testing.T, cfg config.Config) *Server {
+	t.Helper()
+	db, err := InitDatabase(":memory:")
+	if err != nil {
+		t.Fatalf("InitDatabase: %v", err)
+	}
+	srv, err := NewServer(&cfg, db)
+	if err != nil {
+		db.Close()
+		t.Fatalf("NewServer: %v", err)
+	}
+	t.Cleanup(func() {
+		srv.Close()
+		db.Close()
+	})
+	return srv
+}
+
+// testUser makes requests to a test server as one user. Requests are
+// anonymous while token is empty.
+type testUser struct {
+	t     *testing.T
+	srv   *Server
+	id    int
+	token string
+}
+
+// do sends a request with body encoded as JSON, unless it's a string, which
+// is sent as it is
+func (u *testUser) do(method, path string, body interface{}) *httptest.ResponseRecorder {
+	u.t.Helper()
+	var payload []byte
+	switch body := body.(type) {
+	case nil:
+	case string:
+		payload = []byte(body)
+	default:
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			u.t.Fatalf("encoding request body: %v", err)
+		}
+	}
+
+	req := httptest.NewRequest(method, path, bytes.NewReader(payload))
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if u.token != "" {
+		req.Header.Set("Authorization", "Bearer "+u.token)
+	}
+	rec := httptest.NewRecorder()
+	u.srv.ServeHTTP(rec, req)
+	return rec
+}
+
+// expect sends a request, fails the test unless it's answered with status,
+// and decodes the response into out unless out is nil
+func (u *testUser) expect(status int, out interface{}, method, path string, body interface{}) {
+	u.t.Helper()
+	rec := u.do(method, path, body)
+	if rec.Code != status {
+		u.t.Fatalf("%s %s: got status %d, want %d: %s", method, path, rec.Code, status, rec.Body.String())
+	}
+	if out != nil {
+		if err := json.Unmarshal(rec.Body.Bytes(), out); err != nil {
+			u.t.Fatalf("%s %s: decoding response: %v", method, path, err)
+		}
+	}
+}
+
+// signUp registers and logs in a user
+func signUp(t *testing.T, srv *Server, username string) *testUser {
+	t.Helper()
+	u := &testUser{t: t, srv: srv}
+	credentials := LoginRequest{Username: username, Password: "hunter2"}
+	u.expect(http.StatusCreated, nil, "POST", "/register", credentials)
+
+	var session struct {
+		UserID int    `json:"user_id"`
+		Token  string `json:"token"`
+	}
+	u.expect(http.StatusOK, &session, "POST", "/login", credentials)
+	if session.Token == "" {
+		t.Fatalf("login of %s returned no token", username)
+	}
+	u.id, u.token = session.UserID, session.Token
+	return u
+}
+
+// createSubreddit creates a subreddit as u and returns its ID
+func createSubreddit(u *testUser, name string) int {
+	u.t.Helper()
+	var created SubredditCreated
+	u.expect(http.StatusCreated, &created, "POST", "/subreddits",
+		CreateSubredditRequest{Name: name, Description: "A test subreddit"})
+	return created.SubredditID
+}
+
+// createPost creates a post as u and returns its ID
+func createPost(u *testUser, subredditID int, title string) int {
+	u.t.Helper()
+	var created PostCreated
+	u.expect(http.StatusCreated, &created, "POST", "/posts",
+		CreatePostRequest{Title: title, Content: "Some content", SubredditID: subredditID})
+	return created.PostID
+}
+
+// subredditFeed returns the IDs of the posts in a subreddit's feed, as u
+// sees it, and their comment counts
+func subredditFeed(u *testUser, subredditID int) map[int]int {
+	u.t.Helper()
+	var posts []Post
+	u.expect(http.StatusOK, &posts, "GET", fmt.Sprintf("/subreddits/%d/feed", subredditID), nil)
+	counts := make(map[int]int, len(posts))
+	for _, post := range posts {
+		counts[post.ID] = post.CommentCount
+	}
+	return counts
+}
+
+// karma returns a user's karma
+func karma(u *testUser, username string) int {
+	u.t.Helper()
+	var user User
+	u.expect(http.StatusOK, &user, "GET", "/users/"+username, nil)
+	return user.Karma
+}
+
+func TestAuth(t *testing.T) {
+	srv := newTestServer(t)
+	alice := signUp(t, srv, "alice")
+
+	alice.expect(http.StatusOK, nil, "GET", "/users/me/sessions", nil)
+
+	anonymous := &testUser{t: t, srv: srv}
+	anonymous.expect(http.StatusUnauthorized, nil, "GET", "/users/me/sessions", nil)
+
+	revoked := &testUser{t: t, srv: srv, token: alice.token}
+	alice.expect(http.StatusOK, nil, "POST", "/logout", nil)
+	revoked.expect(http.StatusUnauthorized, nil, "GET", "/users/me/sessions", nil)
+
+	var failed ErrorResponse
+	anonymous.expect(http.StatusUnauthorized, &failed, "POST", "/login",
+		LoginRequest{Username: "alice", Password: "wrong"})
+	if failed.Code != "invalid_credentials" {
+		t.Errorf("wrong password: got code %q, want invalid_credentials", failed.Code)
+	}
+}
+
+func TestPostCommentVoteFlow(t *testing.T) {
+	srv := newTestServer(t)
+	alice := signUp(t, srv, "alice")
+	bob := signUp(t, srv, "bob")
+
+	subredditID := createSubreddit(alice, "golang")
+	bob.expect(http.StatusOK, nil, "POST", fmt.Sprintf("/subreddits/%d/join", subredditID), nil)
+
+	var post PostCreated
+	alice.expect(http.StatusCreated, &post, "POST", "/posts",
+		CreatePostRequest{Title: "Hello", Content: "First post", SubredditID: subredditID})
+	if post.PostID == 0 || post.Automod.Removed {
+		t.Fatalf("unexpected post result: %+v", post)
+	}
+
+	var comment CommentCreated
+	bob.expect(http.StatusCreated, &comment, "POST", "/comments",
+		CreateCommentRequest{Content: "Welcome", PostID: post.PostID})
+	if comment.CommentID == 0 {
+		t.Fatalf("unexpected comment result: %+v", comment)
+	}
+
+	vote := VoteRequest{TargetID: post.PostID, TargetType: "post", Value: 1, Nonce: "vote-1", Timestamp: time.Now().Unix()}
+	bob.expect(http.StatusOK, nil, "POST", "/vote", vote)
+
+	var replay ErrorResponse
+	bob.expect(http.StatusConflict, &replay, "POST", "/vote", vote)
+	if replay.Code != "vote_replay" {
+		t.Errorf("replayed vote: got code %q, want vote_replay", replay.Code)
+	}
+
+	var author User
+	alice.expect(http.StatusOK, &author, "GET", "/users/alice", nil)
+	if author.Karma != 1 {
+		t.Errorf("author karma: got %d, want 1", author.Karma)
+	}
+
+	var posts []Post
+	alice.expect(http.StatusOK, &posts, "GET", fmt.Sprintf("/subreddits/%d/feed", subredditID), nil)
+	if len(posts) != 1 || posts[0].ID != post.PostID {
+		t.Fatalf("subreddit feed: got %+v, want post %d", posts, post.PostID)
+	}
+	if posts[0].CommentCount != 1 {
+		t.Errorf("comment count: got %d, want 1", posts[0].CommentCount)
+	}
+}
+
+func TestErrorEnvelope(t *testing.T) {
+	srv := newTestServer(t)
+	alice := signUp(t, srv, "alice")
+	createSubreddit(alice, "golang")
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   interface{}
+		status int
+		code   string
+	}{
+		{"malformed JSON", "POST", "/posts", "{", http.StatusBadRequest, "invalid_request"},
+		{"missing field", "POST", "/posts", CreatePostRequest{Title: "No content", SubredditID: 1}, http.StatusBadRequest, "invalid_request"},
+		{"invalid ID", "GET", "/subreddits/abc/feed", nil, http.StatusBadRequest, "invalid_request"},
+		{"unknown subreddit", "POST", "/posts", CreatePostRequest{Title: "Lost", Content: "Nowhere", SubredditID: 999}, http.StatusNotFound, "not_found"},
+		{"unknown post", "POST", "/comments", CreateCommentRequest{Content: "Hi", PostID: 999}, http.StatusNotFound, "not_found"},
+		{"taken username", "POST", "/register", LoginRequest{Username: "alice", Password: "hunter2"}, http.StatusConflict, "username_taken"},
+		{"existing subreddit", "POST", "/subreddits", CreateSubredditRequest{Name: "golang", Description: "Again"}, http.StatusConflict, "subreddit_exists"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := alice.do(tt.method, tt.path, tt.body)
+			if rec.Code != tt.status {
+				t.Fatalf("got status %d, want %d: %s", rec.Code, tt.status, rec.Body.String())
+			}
+			var body ErrorResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("decoding error response: %v", err)
+			}
+			if body.Code != tt.code || body.Error == "" {
+				t.Errorf("got %+v, want code %q and a message", body, tt.code)
+			}
+		})
+	}
+}
+
+func TestAdminAccess(t *testing.T) {
+	cfg := config.Default()
+	cfg.AdminUserIDs = []int{1}
+	srv := newTestServerWithConfig(t, cfg)
+	admin := signUp(t, srv, "admin")
+	alice := signUp(t, srv, "alice")
+	if admin.id != 1 {
+		t.Fatalf("admin got user ID %d, want 1", admin.id)
+	}
+
+	admin.expect(http.StatusOK, nil, "GET", "/admin/audit", nil)
+	alice.expect(http.StatusForbidden, nil, "GET", "/admin/audit", nil)
+
+	// With admins configured, X-User-ID can't stand in for a session
+	req := httptest.NewRequest("GET", "/admin/audit", nil)
+	req.Header.Set("X-User-ID", strconv.Itoa(admin.id))
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("X-User-ID of an admin: got status %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+
+	// An admin impersonating a user only has that user's access
+	var impersonation struct {
+		Token string `json:"token"`
+	}
+	admin.expect(http.StatusCreated, &impersonation, "POST", fmt.Sprintf("/admin/impersonate/%d", alice.id),
+		ImpersonateRequest{Reason: "support ticket"})
+	impersonator := &testUser{t: t, srv: srv, id: alice.id, token: impersonation.Token}
+	impersonator.expect(http.StatusOK, nil, "GET", "/feed", nil)
+	impersonator.expect(http.StatusForbidden, nil, "GET", "/admin/audit", nil)
+}
+
+func TestVoteBatch(t *testing.T) {
+	srv := newTestServer(t)
+	alice := signUp(t, srv, "alice")
+	bob := signUp(t, srv, "bob")
+
+	subredditID := createSubreddit(alice, "golang")
+	first := createPost(alice, subredditID, "First")
+	second := createPost(alice, subredditID, "Second")
+
+	now := time.Now().Unix()
+	var result VoteBatchResponse
+	bob.expect(http.StatusOK, &result, "POST", "/votes/batch", VoteBatchRequest{Votes: []VoteRequest{
+		{TargetID: first, TargetType: "post", Value: 1, Nonce: "batch-1", Timestamp: now},
+	}})
+	if result.Count != 1 {
+		t.Errorf("recorded votes: got %d, want 1", result.Count)
+	}
+
+	// A replayed nonce fails the whole batch, so the vote before it isn't
+	// recorded either
+	var failed ErrorResponse
+	bob.expect(http.StatusConflict, &failed, "POST", "/votes/batch", VoteBatchRequest{Votes: []VoteRequest{
+		{TargetID: second, TargetType: "post", Value: 1, Nonce: "batch-2", Timestamp: now},
+		{TargetID: second, TargetType: "post", Value: 1, Nonce: "batch-1", Timestamp: now},
+	}})
+	if failed.Code != "vote_replay" || !strings.HasPrefix(failed.Error, "vote 1: ") {
+		t.Errorf("replayed nonce: got %+v, want code vote_replay for vote 1", failed)
+	}
+	if got := karma(alice, "alice"); got != 1 {
+		t.Errorf("author karma after the failed batch: got %d, want 1", got)
+	}
+
+	// The failed batch's first vote can be cast again with its nonce
+	bob.expect(http.StatusOK, nil, "POST", "/votes/batch", VoteBatchRequest{Votes: []VoteRequest{
+		{TargetID: second, TargetType: "post", Value: 1, Nonce: "batch-2", Timestamp: now},
+	}})
+	if got := karma(alice, "alice"); got != 2 {
+		t.Errorf("author karma: got %d, want 2", got)
+	}
+
+	stale := time.Now().Add(-time.Hour).Unix()
+	bob.expect(http.StatusBadRequest, &failed, "POST", "/votes/batch", VoteBatchRequest{Votes: []VoteRequest{
+		{TargetID: first, TargetType: "post", Value: -1, Nonce: "batch-3", Timestamp: stale},
+	}})
+	if failed.Code != "stale_vote" {
+		t.Errorf("stale vote: got code %q, want stale_vote", failed.Code)
+	}
+}
+
+func TestSoftDeleteAndRestore(t *testing.T) {
+	srv := newTestServer(t)
+	alice := signUp(t, srv, "alice")
+	bob := signUp(t, srv, "bob")
+	carol := signUp(t, srv, "carol")
+
+	// Alice created the subreddit, so she moderates it
+	subredditID := createSubreddit(alice, "golang")
+	bob.expect(http.StatusOK, nil, "POST", fmt.Sprintf("/subreddits/%d/join", subredditID), nil)
+	postID := createPost(bob, subredditID, "Hello")
+
+	var comment CommentCreated
+	bob.expect(http.StatusCreated, &comment, "POST", "/comments", CreateCommentRequest{Content: "Hi", PostID: postID})
+	if got := subredditFeed(alice, subredditID)[postID]; got != 1 {
+		t.Fatalf("comment count: got %d, want 1", got)
+	}
+
+	var failed ErrorResponse
+	carol.expect(http.StatusForbidden, &failed, "DELETE", fmt.Sprintf("/comments/%d", comment.CommentID), nil)
+	if failed.Code != codeForbidden {
+		t.Errorf("deleting someone else's comment: got code %q, want %s", failed.Code, codeForbidden)
+	}
+
+	bob.expect(http.StatusOK, nil, "DELETE", fmt.Sprintf("/comments/%d", comment.CommentID), nil)
+	if got := subredditFeed(alice, subredditID)[postID]; got != 0 {
+		t.Errorf("comment count after deleting the comment: got %d, want 0", got)
+	}
+
+	bob.expect(http.StatusOK, nil, "DELETE", fmt.Sprintf("/posts/%d", postID), nil)
+	if _, ok := subredditFeed(alice, subredditID)[postID]; ok {
+		t.Errorf("deleted post %d is still in the feed", postID)
+	}
+
+	// Only moderators and admins can restore, even the author's own post
+	bob.expect(http.StatusForbidden, nil, "POST", fmt.Sprintf("/posts/%d/restore", postID), nil)
+	alice.expect(http.StatusNotFound, nil, "POST", fmt.Sprintf("/posts/%d/restore", postID+1), nil)
+	alice.expect(http.StatusOK, nil, "POST", fmt.Sprintf("/posts/%d/restore", postID), nil)
+	alice.expect(http.StatusOK, nil, "POST", fmt.Sprintf("/comments/%d/restore", comment.CommentID), nil)
+	if got, ok := subredditFeed(alice, subredditID)[postID]; !ok || got != 1 {
+		t.Errorf("restored post: got it listed %v with %d comments, want listed with 1", ok, got)
+	}
+}
+
+func TestGraphQLErrors(t *testing.T) {
+	srv := newTestServer(t)
+	alice := signUp(t, srv, "alice")
+
+	tests := []struct {
+		name    string
+		query   string
+		code    string
+		message string
+	}{
+		{"unknown post", `{ post(id: 999) { title } }`, codeNotFound, "post not found"},
+		{"syntax error", `{ post(id: ) { title } }`, codeInvalidRequest, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var result struct {
+				Errors []map[string]interface{} `json:"errors"`
+			}
+			alice.expect(http.StatusOK, &result, "POST", "/graphql", GraphQLRequest{Query: tt.query})
+			if len(result.Errors) != 1 {
+				t.Fatalf("got errors %v, want one", result.Errors)
+			}
+
+			// Errors carry a message and a code, and nothing else
+			err := result.Errors[0]
+			if err["code"] != tt.code || err["message"] == "" || len(err) != 2 {
+				t.Errorf("got %v, want code %q and a message only", err, tt.code)
+			}
+			if tt.message != "" && err["message"] != tt.message {
+				t.Errorf("got message %q, want %q", err["message"], tt.message)
+			}
+		})
+	}
+}
@@ -0,0 +1,372 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Deleting content
+//
+// Posts, comments and subreddits are soft-deleted: deleting one sets its
+// deleted_at and deleted_by, and every read query leaves out deleted rows,
+// the same way it leaves out content moderators removed. Nothing is lost, so
+// deleted content can be restored.
+//
+// Authors can delete their own posts and comments, and moderators and admins
+// anyone's; only moderators and admins can restore them. Subreddits are
+// deleted and restored by admins. Deleting a subreddit deletes its posts
+// along with it, and restoring it brings back the posts deleted with it but
+// not those deleted before. Moderators' deletions and restorations are
+// recorded in the mod log, admins' in the admin audit log.
+
+var (
+	ErrCannotDelete  = errors.New("only the author, a moderator or an admin can delete this")
+	ErrCannotRestore = errors.New("only a moderator or an admin can restore this")
+	ErrSubredditGone = errors.New("the subreddit is deleted; restore it first")
+)
+
+// deletedContent looks up the author and subreddit of a post or comment
+// that is deleted, or not, as deleted says
+func deletedContent(tx *sql.Tx, targetType string, targetID int, deleted bool) (authorID, subredditID, postID int, err error) {
+	condition := "deleted_at IS NULL"
+	if deleted {
+		condition = "deleted_at IS NOT NULL"
+	}
+
+	if targetType == "post" {
+		err = tx.QueryRow(`SELECT author_id, subreddit_id, id FROM posts WHERE id = ? AND `+condition, targetID).
+			Scan(&authorID, &subredditID, &postID)
+	} else {
+		err = tx.QueryRow(`
+			SELECT c.author_id, p.subreddit_id, c.post_id
+			FROM comments c JOIN posts p ON c.post_id = p.id
+			WHERE c.id = ? AND c.`+condition, targetID).Scan(&authorID, &subredditID, &postID)
+	}
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("%s not found: %v", targetType, err)
+	}
+	return authorID, subredditID, postID, nil
+}
+
+// isModeratorTx reports whether a user moderates a subreddit
+func isModeratorTx(tx *sql.Tx, userID, subredditID int) (bool, error) {
+	var isMod bool
+	err := tx.QueryRow(`
+		SELECT EXISTS (SELECT 1 FROM subreddit_moderators WHERE subreddit_id = ? AND user_id = ?)
+	`, subredditID, userID).Scan(&isMod)
+	if err != nil {
+		return false, fmt.Errorf("failed to check moderators: %v", err)
+	}
+	return isMod, nil
+}
+
+// logContentDeletion records a deletion or restoration in the mod log when
+// a moderator made it, or else in the admin audit log when an admin did.
+// Authors deleting their own content aren't recorded.
+func logContentDeletion(tx *sql.Tx, userID int, isMod, isAdmin bool, action, targetType string, targetID, subredditID int, reason string) error {
+	switch {
+	case isMod:
+		return logModAction(tx, subredditID, &userID, action, targetType, targetID, reason)
+	case isAdmin:
+		return logAdminAction(tx, userID, action, targetType, &targetID, reason, "")
+	}
+	return nil
+}
+
+// DeleteContent soft-deletes a post or comment. isAdmin says whether the
+// user deleting it is an admin.
+func (dm *DatabaseManager) DeleteContent(userID int, isAdmin bool, targetType string, targetID int, reason string) error {
+	defer dm.span("DeleteContent").End()
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	tx, err := dm.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	authorID, subredditID, postID, err := deletedContent(tx, targetType, targetID, false)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	isMod, err := isModeratorTx(tx, userID, subredditID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if authorID != userID && !isMod && !isAdmin {
+		tx.Rollback()
+		return ErrCannotDelete
+	}
+
+	_, err = tx.Exec(`UPDATE `+targetType+`s SET deleted_at = CURRENT_TIMESTAMP, deleted_by = ? WHERE id = ?`, userID, targetID)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to delete %s: %v", targetType, err)
+	}
+	if targetType == "comment" {
+		if err := updateCommentCount(tx, postID); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	if authorID != userID {
+		if err := logContentDeletion(tx, userID, isMod, isAdmin, "delete_"+targetType, targetType, targetID, subredditID, reason); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// RestoreContent brings back a deleted post or comment. isAdmin says
+// whether the user restoring it is an admin.
+func (dm *DatabaseManager) RestoreContent(userID int, isAdmin bool, targetType string, targetID int, reason string) error {
+	defer dm.span("RestoreContent").End()
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	tx, err := dm.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	_, subredditID, postID, err := deletedContent(tx, targetType, targetID, true)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	isMod, err := isModeratorTx(tx, userID, subredditID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if !isMod && !isAdmin {
+		tx.Rollback()
+		return ErrCannotRestore
+	}
+
+	var subredditDeleted bool
+	err = tx.QueryRow(`SELECT deleted_at IS NOT NULL FROM subreddits WHERE id = ?`, subredditID).Scan(&subredditDeleted)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("subreddit not found: %v", err)
+	}
+	if subredditDeleted {
+		tx.Rollback()
+		return ErrSubredditGone
+	}
+
+	_, err = tx.Exec(`UPDATE `+targetType+`s SET deleted_at = NULL, deleted_by = NULL WHERE id = ?`, targetID)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to restore %s: %v", targetType, err)
+	}
+	if targetType == "comment" {
+		if err := updateCommentCount(tx, postID); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	if err := logContentDeletion(tx, userID, isMod, isAdmin, "restore_"+targetType, targetType, targetID, subredditID, reason); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DeleteSubreddit soft-deletes a subreddit along with its posts, returning
+// how many posts were deleted with it
+func (dm *DatabaseManager) DeleteSubreddit(subredditID, adminID int, reason string) (int, error) {
+	defer dm.span("DeleteSubreddit").End()
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	tx, err := dm.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+
+	result, err := tx.Exec(`
+		UPDATE subreddits SET deleted_at = CURRENT_TIMESTAMP, deleted_by = ?
+		WHERE id = ? AND deleted_at IS NULL
+	`, adminID, subredditID)
+	if err != nil {
+		tx.Rollback()
+		return 0, fmt.Errorf("failed to delete subreddit: %v", err)
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		tx.Rollback()
+		return 0, fmt.Errorf("subreddit not found")
+	}
+
+	// The posts get the subreddit's timestamp, which is how restoring it
+	// tells them from posts deleted before
+	result, err = tx.Exec(`
+		UPDATE posts SET deleted_at = (SELECT deleted_at FROM subreddits WHERE id = ?1), deleted_by = ?2
+		WHERE subreddit_id = ?1 AND deleted_at IS NULL
+	`, subredditID, adminID)
+	if err != nil {
+		tx.Rollback()
+		return 0, fmt.Errorf("failed to delete the subreddit's posts: %v", err)
+	}
+	posts, _ := result.RowsAffected()
+
+	details := fmt.Sprintf("posts=%d", posts)
+	if err := logAdminAction(tx, adminID, "delete_subreddit", "subreddit", &subredditID, reason, details); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	return int(posts), tx.Commit()
+}
+
+// RestoreSubreddit brings back a deleted subreddit and the posts deleted
+// with it, returning how many posts were restored
+func (dm *DatabaseManager) RestoreSubreddit(subredditID, adminID int, reason string) (int, error) {
+	defer dm.span("RestoreSubreddit").End()
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	tx, err := dm.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+
+	var deleted bool
+	err = tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM subreddits WHERE id = ? AND deleted_at IS NOT NULL)`, subredditID).Scan(&deleted)
+	if err != nil {
+		tx.Rollback()
+		return 0, fmt.Errorf("failed to find subreddit: %v", err)
+	}
+	if !deleted {
+		tx.Rollback()
+		return 0, fmt.Errorf("subreddit not found")
+	}
+
+	// Compared in SQL, as the driver reads the timestamp back in another
+	// format than it's stored in
+	result, err := tx.Exec(`
+		UPDATE posts SET deleted_at = NULL, deleted_by = NULL
+		WHERE subreddit_id = ?1 AND deleted_at = (SELECT deleted_at FROM subreddits WHERE id = ?1)
+	`, subredditID)
+	if err != nil {
+		tx.Rollback()
+		return 0, fmt.Errorf("failed to restore the subreddit's posts: %v", err)
+	}
+	posts, _ := result.RowsAffected()
+
+	if _, err := tx.Exec(`UPDATE subreddits SET deleted_at = NULL, deleted_by = NULL WHERE id = ?`, subredditID); err != nil {
+		tx.Rollback()
+		return 0, fmt.Errorf("failed to restore subreddit: %v", err)
+	}
+
+	details := fmt.Sprintf("posts=%d", posts)
+	if err := logAdminAction(tx, adminID, "restore_subreddit", "subreddit", &subredditID, reason, details); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	return int(posts), tx.Commit()
+}
+
+// checkSubredditExists fails unless a subreddit exists and isn't deleted
+func checkSubredditExists(tx *sql.Tx, subredditID int) error {
+	var exists bool
+	err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM subreddits WHERE id = ? AND deleted_at IS NULL)`, subredditID).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("failed to find subreddit: %v", err)
+	}
+	if !exists {
+		return fmt.Errorf("subreddit not found")
+	}
+	return nil
+}
+
+// deleteContent returns a handler deleting the post or comment named by
+// the path parameter param. Moderators and admins can give a ?reason=.
+func (h *APIHandler) deleteContent(targetType, param string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		targetID, err := strconv.Atoi(c.Param(param))
+		if err != nil {
+			c.Error(newAPIError(http.StatusBadRequest, "Invalid "+targetType+" ID"))
+			return
+		}
+
+		userID, _ := strconv.Atoi(c.GetString("user_id"))
+		err = h.dbFor(c).DeleteContent(userID, h.isAdmin(c), targetType, targetID, c.Query("reason"))
+		if err != nil {
+			c.Error(err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": strings.ToUpper(targetType[:1]) + targetType[1:] + " deleted"})
+	}
+}
+
+// restoreContent returns a handler restoring the deleted post or comment
+// named by the path parameter param
+func (h *APIHandler) restoreContent(targetType, param string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		targetID, err := strconv.Atoi(c.Param(param))
+		if err != nil {
+			c.Error(newAPIError(http.StatusBadRequest, "Invalid "+targetType+" ID"))
+			return
+		}
+
+		userID, _ := strconv.Atoi(c.GetString("user_id"))
+		err = h.dbFor(c).RestoreContent(userID, h.isAdmin(c), targetType, targetID, c.Query("reason"))
+		if err != nil {
+			c.Error(err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": strings.ToUpper(targetType[:1]) + targetType[1:] + " restored"})
+	}
+}
+
+// DeleteSubredditResponse is the body of DELETE /admin/subreddits/:id and
+// POST /admin/subreddits/:id/restore
+type DeleteSubredditResponse struct {
+	SubredditID int `json:"subreddit_id"`
+	Posts       int `json:"posts"` // deleted or restored along with it
+}
+
+// deleteSubreddit deletes a subreddit and its posts
+func (h *APIHandler) deleteSubreddit(c *gin.Context) {
+	h.changeSubredditDeletion(c, h.dbFor(c).DeleteSubreddit)
+}
+
+// restoreSubreddit restores a deleted subreddit and the posts deleted with
+// it
+func (h *APIHandler) restoreSubreddit(c *gin.Context) {
+	h.changeSubredditDeletion(c, h.dbFor(c).RestoreSubreddit)
+}
+
+func (h *APIHandler) changeSubredditDeletion(c *gin.Context, change func(subredditID, adminID int, reason string) (int, error)) {
+	subredditID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.Error(newAPIError(http.StatusBadRequest, "Invalid subreddit ID"))
+		return
+	}
+
+	adminID, _ := strconv.Atoi(c.GetString("user_id"))
+	posts, err := change(subredditID, adminID, c.GetHeader(adminAuditReasonHeader))
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, DeleteSubredditResponse{SubredditID: subredditID, Posts: posts})
+}
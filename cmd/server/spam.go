@@ -0,0 +1,172 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Spam detection
+//
+// New posts and comments get a spam score from a few heuristics, each adding
+// its weight when it fires: content the author already posted elsewhere,
+// a new account posting many links, and an author posting faster than
+// anyone reasonably would. Content scoring at least the remove threshold is
+// removed, and content scoring at least the flag threshold is sent to the
+// mod queue. The thresholds are part of the runtime config, and both are 0,
+// so nothing is flagged or removed, until an operator sets them.
+
+// SpamThresholds configures spam detection
+type SpamThresholds struct {
+	FlagScore   int `json:"flag_score"`   // sends content to the mod queue, 0 never does
+	RemoveScore int `json:"remove_score"` // removes content, 0 never does
+
+	// NewAccountDays is how old an account must be for its links not to
+	// count, and MaxLinks how many links a newer account can post at once
+	NewAccountDays int `json:"new_account_days"`
+	MaxLinks       int `json:"max_links"`
+
+	// PostsPerHour is how many posts and comments together an author can
+	// make in an hour before velocity counts, 0 for no limit
+	PostsPerHour int `json:"posts_per_hour"`
+}
+
+var defaultSpamThresholds = SpamThresholds{
+	FlagScore:      0,
+	RemoveScore:    0,
+	NewAccountDays: 7,
+	MaxLinks:       2,
+	PostsPerHour:   20,
+}
+
+// Validate checks the thresholds
+func (t SpamThresholds) Validate() error {
+	if t.FlagScore < 0 || t.RemoveScore < 0 || t.NewAccountDays < 0 || t.MaxLinks < 0 || t.PostsPerHour < 0 {
+		return fmt.Errorf("spam thresholds must not be negative")
+	}
+	return nil
+}
+
+// Heuristic weights
+const (
+	spamDuplicateWeight = 3
+	spamLinksWeight     = 2
+	spamVelocityWeight  = 2
+)
+
+const (
+	spamDuplicateWindow    = 24 * 60 * 60 // seconds duplicates are looked for over
+	spamMinDuplicateLength = 20           // shorter comments ("thanks!") aren't duplicates
+)
+
+var linkPattern = regexp.MustCompile(`(?i)https?://`)
+
+// SetSpamThresholds changes the thresholds new content is checked against
+func (dm *DatabaseManager) SetSpamThresholds(thresholds SpamThresholds) {
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+	dm.spam = thresholds
+}
+
+// scoreSpam scores new content by the heuristics that fire on it, returning
+// their names
+func scoreSpam(tx *sql.Tx, thresholds SpamThresholds, targetType string, targetID, authorID int, title, content string) (int, []string, error) {
+	score := 0
+	var signals []string
+
+	var duplicates int
+	var err error
+	if targetType == "post" {
+		err = tx.QueryRow(`
+			SELECT COUNT(*) FROM posts p
+			WHERE p.author_id = ? AND p.id != ? AND p.title = ? AND p.content = ?
+				AND p.subreddit_id != (SELECT subreddit_id FROM posts WHERE id = ?)
+				AND p.created_at >= datetime('now', ?)
+		`, authorID, targetID, title, content, targetID, fmt.Sprintf("-%d seconds", spamDuplicateWindow)).Scan(&duplicates)
+	} else if len(strings.TrimSpace(content)) >= spamMinDuplicateLength {
+		err = tx.QueryRow(`
+			SELECT COUNT(*) FROM comments c
+			WHERE c.author_id = ? AND c.id != ? AND c.content = ?
+				AND c.post_id != (SELECT post_id FROM comments WHERE id = ?)
+				AND c.created_at >= datetime('now', ?)
+		`, authorID, targetID, content, targetID, fmt.Sprintf("-%d seconds", spamDuplicateWindow)).Scan(&duplicates)
+	}
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to check for duplicates: %v", err)
+	}
+	if duplicates > 0 {
+		score += spamDuplicateWeight
+		signals = append(signals, "duplicate content")
+	}
+
+	if links := len(linkPattern.FindAllString(title+"\n"+content, -1)); links > thresholds.MaxLinks {
+		var newAccount bool
+		err := tx.QueryRow(`SELECT created_at >= datetime('now', ?) FROM users WHERE id = ?`,
+			fmt.Sprintf("-%d days", thresholds.NewAccountDays), authorID).Scan(&newAccount)
+		if err != nil {
+			return 0, nil, fmt.Errorf("failed to check account age: %v", err)
+		}
+		if newAccount {
+			score += spamLinksWeight
+			signals = append(signals, "links from a new account")
+		}
+	}
+
+	if thresholds.PostsPerHour > 0 {
+		var recent int
+		err := tx.QueryRow(`
+			SELECT (SELECT COUNT(*) FROM posts WHERE author_id = ? AND created_at >= datetime('now', '-1 hour'))
+				+ (SELECT COUNT(*) FROM comments WHERE author_id = ? AND created_at >= datetime('now', '-1 hour'))
+		`, authorID, authorID).Scan(&recent)
+		if err != nil {
+			return 0, nil, fmt.Errorf("failed to check posting velocity: %v", err)
+		}
+		if recent > thresholds.PostsPerHour {
+			score += spamVelocityWeight
+			signals = append(signals, "posting velocity")
+		}
+	}
+
+	return score, signals, nil
+}
+
+// applySpamCheck scores new content within the creating transaction, and
+// removes or flags it when it reaches the thresholds
+func applySpamCheck(tx *sql.Tx, thresholds SpamThresholds, subredditID int, targetType string, targetID, authorID int, title, content string, outcome *AutomodOutcome) error {
+	if thresholds.FlagScore == 0 && thresholds.RemoveScore == 0 {
+		return nil
+	}
+
+	score, signals, err := scoreSpam(tx, thresholds, targetType, targetID, authorID, title, content)
+	if err != nil {
+		return err
+	}
+	reason := fmt.Sprintf("spam score %d: %s", score, strings.Join(signals, ", "))
+
+	switch {
+	case thresholds.RemoveScore > 0 && score >= thresholds.RemoveScore:
+		table := "posts"
+		if targetType == "comment" {
+			table = "comments"
+		}
+		_, err = tx.Exec(fmt.Sprintf("UPDATE %s SET removed = 1 WHERE id = ?", table), targetID)
+		if err == nil {
+			err = logModAction(tx, subredditID, nil, "remove_"+targetType, targetType, targetID, reason)
+		}
+		outcome.Removed = true
+	case thresholds.FlagScore > 0 && score >= thresholds.FlagScore:
+		_, err = tx.Exec(`
+			INSERT INTO mod_queue (subreddit_id, target_type, target_id, reason, source)
+			VALUES (?, ?, ?, ?, 'spam')
+		`, subredditID, targetType, targetID, reason)
+		if err == nil {
+			err = logModAction(tx, subredditID, nil, "flag_"+targetType, targetType, targetID, reason)
+		}
+		outcome.Flagged = true
+	}
+	if err != nil {
+		return fmt.Errorf("failed to apply spam check: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Site statistics
+//
+// The stats job periodically aggregates site-wide totals and time-bucketed
+// counts into the site_stats table, so GET /admin/stats is a cheap read
+// rather than a set of scans over the content tables. Daily active users are
+// counted from user_activity, which records each day a user made an
+// authenticated request.
+
+const (
+	statsInterval         = 10 * time.Minute
+	statsDays             = 30          // days of daily buckets kept
+	statsHours            = 48          // hours of hourly buckets kept
+	userActivityInterval  = time.Minute // how often a user's activity is written
+	userActivityRetention = 90 * 24 * time.Hour
+	statsDayFormat        = "2006-01-02"
+	statsHourFormat       = "2006-01-02 15:00"
+	statsTotalBucket      = "total"
+)
+
+// RecordUserActivity notes that a user made a request. Writes are skipped if
+// the user's activity was recorded within the last minute.
+func (dm *DatabaseManager) RecordUserActivity(userID int) error {
+	defer dm.span("RecordUserActivity").End()
+	now := time.Now()
+	if last, ok := dm.lastActive.Load(userID); ok && now.Sub(last.(time.Time)) < userActivityInterval {
+		return nil
+	}
+	dm.lastActive.Store(userID, now)
+
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	tx, err := dm.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`UPDATE users SET last_active_at = CURRENT_TIMESTAMP WHERE id = ?`, userID); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to record user activity: %v", err)
+	}
+	if _, err := tx.Exec(`INSERT OR IGNORE INTO user_activity (user_id, day) VALUES (?, date('now'))`, userID); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to record user activity: %v", err)
+	}
+
+	return tx.Commit()
+}
+
+// The queries the stats job aggregates. Series queries select a bucket and
+// a count, and are given the first bucket of their range.
+var statsTotals = map[string]string{
+	"users":      `SELECT COUNT(*) FROM users`,
+	"subreddits": `SELECT COUNT(*) FROM subreddits`,
+	"posts":      `SELECT COUNT(*) FROM posts`,
+	"comments":   `SELECT COUNT(*) FROM comments`,
+	"votes":      `SELECT COUNT(*) FROM votes`,
+	"messages":   `SELECT COUNT(*) FROM direct_messages`,
+}
+
+var statsDaily = map[string]string{
+	"signups":  `SELECT date(created_at), COUNT(*) FROM users WHERE created_at >= ? GROUP BY 1`,
+	"posts":    `SELECT date(created_at), COUNT(*) FROM posts WHERE created_at >= ? GROUP BY 1`,
+	"comments": `SELECT date(created_at), COUNT(*) FROM comments WHERE created_at >= ? GROUP BY 1`,
+	"dau":      `SELECT day, COUNT(*) FROM user_activity WHERE day >= ? GROUP BY 1`,
+}
+
+var statsHourly = map[string]string{
+	"votes": `SELECT strftime('%Y-%m-%d %H:00', created_at), COUNT(*) FROM votes WHERE created_at >= ? GROUP BY 1`,
+}
+
+// ComputeSiteStats rebuilds site_stats. Buckets without activity are stored
+// as zero, so every series covers its whole range. The counting only takes
+// the read lock; the write lock is held just to replace the stored stats.
+func (dm *DatabaseManager) ComputeSiteStats(now time.Time) error {
+	defer dm.span("ComputeSiteStats").End()
+
+	now = now.UTC()
+	stats, err := dm.countSiteStats(now)
+	if err != nil {
+		return err
+	}
+
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	tx, err := dm.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`DELETE FROM site_stats`); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to clear site stats: %v", err)
+	}
+	for _, s := range stats {
+		_, err := tx.Exec(`INSERT INTO site_stats (metric, bucket, count) VALUES (?, ?, ?)`, s.metric, s.bucket, s.count)
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to store site stats: %v", err)
+		}
+	}
+
+	// Activity older than any series is no longer needed
+	_, err = tx.Exec(`DELETE FROM user_activity WHERE day < ?`, now.Add(-userActivityRetention).Format(statsDayFormat))
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to prune user activity: %v", err)
+	}
+
+	return tx.Commit()
+}
+
+// siteStat is a count of a metric in one bucket
+type siteStat struct {
+	metric, bucket string
+	count          int
+}
+
+// countSiteStats runs the stats queries for the series ending at now
+func (dm *DatabaseManager) countSiteStats(now time.Time) ([]siteStat, error) {
+	dm.mu.RLock()
+	defer dm.mu.RUnlock()
+
+	var stats []siteStat
+
+	for metric, query := range statsTotals {
+		var count int
+		if err := dm.db.QueryRow(query).Scan(&count); err != nil {
+			return nil, fmt.Errorf("failed to count %s: %v", metric, err)
+		}
+		stats = append(stats, siteStat{metric, statsTotalBucket, count})
+	}
+
+	series := func(queries map[string]string, buckets []string, since string) error {
+		for metric, query := range queries {
+			counts := make(map[string]int)
+			rows, err := dm.db.Query(query, since)
+			if err != nil {
+				return fmt.Errorf("failed to count %s: %v", metric, err)
+			}
+			for rows.Next() {
+				var bucket string
+				var count int
+				if err := rows.Scan(&bucket, &count); err != nil {
+					rows.Close()
+					return err
+				}
+				counts[bucket] = count
+			}
+			rows.Close()
+			if err := rows.Err(); err != nil {
+				return err
+			}
+
+			for _, bucket := range buckets {
+				stats = append(stats, siteStat{metric, bucket, counts[bucket]})
+			}
+		}
+		return nil
+	}
+
+	days := make([]string, statsDays)
+	for i := range days {
+		days[i] = now.AddDate(0, 0, i-statsDays+1).Format(statsDayFormat)
+	}
+	if err := series(statsDaily, days, days[0]); err != nil {
+		return nil, err
+	}
+
+	hours := make([]string, statsHours)
+	for i := range hours {
+		hours[i] = now.Add(time.Duration(i-statsHours+1) * time.Hour).Format(statsHourFormat)
+	}
+	if err := series(statsHourly, hours, hours[0]); err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
+
+// StatsBucket is one time bucket of a series, a day (2006-01-02) or an hour
+// (2006-01-02 15:00) in UTC
+type StatsBucket struct {
+	Bucket string `json:"bucket"`
+	Count  int    `json:"count"`
+}
+
+// SiteStats is the latest output of the stats job
+type SiteStats struct {
+	ComputedAt       *time.Time     `json:"computed_at"`
+	Totals           map[string]int `json:"totals"`
+	SignupsPerDay    []StatsBucket  `json:"signups_per_day"`
+	PostsPerDay      []StatsBucket  `json:"posts_per_day"`
+	CommentsPerDay   []StatsBucket  `json:"comments_per_day"`
+	VotesPerHour     []StatsBucket  `json:"votes_per_hour"`
+	DailyActiveUsers []StatsBucket  `json:"daily_active_users"`
+}
+
+// GetSiteStats returns the stats last computed by the stats job
+func (dm *DatabaseManager) GetSiteStats() (*SiteStats, error) {
+	defer dm.span("GetSiteStats").End()
+	dm.mu.RLock()
+	defer dm.mu.RUnlock()
+
+	stats := &SiteStats{
+		Totals:           make(map[string]int),
+		SignupsPerDay:    []StatsBucket{},
+		PostsPerDay:      []StatsBucket{},
+		CommentsPerDay:   []StatsBucket{},
+		VotesPerHour:     []StatsBucket{},
+		DailyActiveUsers: []StatsBucket{},
+	}
+	series := map[string]*[]StatsBucket{
+		"signups":  &stats.SignupsPerDay,
+		"posts":    &stats.PostsPerDay,
+		"comments": &stats.CommentsPerDay,
+		"votes":    &stats.VotesPerHour,
+		"dau":      &stats.DailyActiveUsers,
+	}
+
+	rows, err := dm.db.Query(`SELECT metric, bucket, count, computed_at FROM site_stats ORDER BY metric, bucket`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get site stats: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var metric, bucket string
+		var count int
+		var computedAt time.Time
+		if err := rows.Scan(&metric, &bucket, &count, &computedAt); err != nil {
+			return nil, err
+		}
+		stats.ComputedAt = &computedAt
+
+		if bucket == statsTotalBucket {
+			stats.Totals[metric] = count
+		} else if s, ok := series[metric]; ok {
+			*s = append(*s, StatsBucket{Bucket: bucket, Count: count})
+		}
+	}
+	return stats, rows.Err()
+}
+
+// getSiteStats returns the site-wide statistics
+func (h *APIHandler) getSiteStats(c *gin.Context) {
+	stats, err := h.dbFor(c).GetSiteStats()
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, stats)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"crypto/tls"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+
+	"github.com/ArjunKaliyath/GoReddit/internal/config"
+)
+
+// listenAndServe serves the REST API on cfg.Addr, over HTTPS when TLS is
+// configured. HTTPS connections negotiate HTTP/2 with clients that support
+// it. With tls.redirect_addr set, plain HTTP requests there are redirected
+// to HTTPS, and with autocert it also answers Let's Encrypt's HTTP
+// challenges.
+func listenAndServe(cfg *config.Config, handler http.Handler) error {
+	server := &http.Server{
+		Addr:              cfg.Addr,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	if !cfg.TLS.Enabled() {
+		log.Printf("REST API listening on %s", cfg.Addr)
+		return server.ListenAndServe()
+	}
+
+	redirect := redirectToHTTPS(cfg.Addr)
+	server.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	if domains := cfg.TLS.AutocertDomains; len(domains) > 0 {
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(domains...),
+			Cache:      autocert.DirCache(cfg.TLS.AutocertCacheDir),
+			Email:      cfg.TLS.AutocertEmail,
+		}
+		server.TLSConfig = manager.TLSConfig()
+		server.TLSConfig.MinVersion = tls.VersionTLS12
+		redirect = manager.HTTPHandler(redirect)
+	}
+
+	if addr := cfg.TLS.RedirectAddr; addr != "" {
+		go func() {
+			redirectServer := &http.Server{Addr: addr, Handler: redirect, ReadHeaderTimeout: 10 * time.Second}
+			log.Fatalf("HTTP redirect server failed: %v", redirectServer.ListenAndServe())
+		}()
+	}
+
+	// With autocert the certificate comes from the TLS config instead
+	log.Printf("REST API listening on %s (HTTPS)", cfg.Addr)
+	return server.ListenAndServeTLS(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+}
+
+// redirectToHTTPS redirects requests to the same URL on the HTTPS address.
+// The redirect is permanent and keeps the method, so API clients retry
+// writes over HTTPS rather than turning them into GETs.
+func redirectToHTTPS(httpsAddr string) http.Handler {
+	_, port, _ := net.SplitHostPort(httpsAddr)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/ArjunKaliyath/GoReddit/internal/buildinfo"
+)
+
+// Tracing
+//
+// Requests are traced with OpenTelemetry from the Gin middleware, through the
+// actor pool and its workers, into every DatabaseManager method. The request
+// context travels on the gin.Context, and handlers reach the database through
+// h.dbFor(c) so DB spans nest under the request. Spans are exported over OTLP
+// when OTEL_EXPORTER_OTLP_ENDPOINT is set, and tracing is a no-op otherwise.
+
+var tracer = otel.Tracer("github.com/ArjunKaliyath/GoReddit/cmd/server")
+
+// initTracing sets up span export if an OTLP endpoint is configured. The
+// returned function flushes pending spans on shutdown.
+func initTracing(ctx context.Context) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" && os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	// The exporter and sampler take the rest of their settings from the
+	// standard OTEL_* environment variables
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, err
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(
+		attribute.String("service.name", "goreddit-server"),
+		attribute.String("service.version", buildinfo.Version),
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// tracingMiddleware starts a server span for each request, continuing the
+// caller's trace if the request carries a traceparent header. The trace ID
+// is returned in X-Trace-Id so slow requests can be looked up.
+func tracingMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := otel.GetTextMapPropagator().Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		ctx, span := tracer.Start(ctx, c.Request.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", c.Request.Method),
+				attribute.String("http.route", route),
+			),
+		)
+		defer span.End()
+
+		if span.SpanContext().HasTraceID() {
+			c.Header("X-Trace-Id", span.SpanContext().TraceID().String())
+		}
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+
+		status := c.Writer.Status()
+		span.SetAttributes(attribute.Int("http.response.status_code", status))
+		if userID := c.GetString("user_id"); userID != "" {
+			span.SetAttributes(attribute.String("enduser.id", userID))
+		}
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+	}
+}
+
+// traceRequest starts a span as a child of the request's current span and
+// makes it the request's current span, so that work done for the request
+// from here on, including by actors, nests under it
+func traceRequest(c *gin.Context, name string, attrs ...attribute.KeyValue) trace.Span {
+	ctx, span := tracer.Start(c.Request.Context(), name, trace.WithAttributes(attrs...))
+	c.Request = c.Request.WithContext(ctx)
+	return span
+}
+
+// endSpan ends a span, recording err on it if there was one
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// WithContext returns a view of the database whose methods trace as part of
+// ctx. The view shares the connection and locks of dm.
+func (dm *DatabaseManager) WithContext(ctx context.Context) *DatabaseManager {
+	return &DatabaseManager{database: dm.database, ctx: ctx}
+}
+
+// span starts the span of a DatabaseManager method. Methods called without a
+// context, such as from background jobs, start a new trace.
+func (dm *DatabaseManager) span(method string) trace.Span {
+	ctx := dm.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	_, span := tracer.Start(ctx, "DatabaseManager."+method, trace.WithAttributes(attribute.String("db.system", "sqlite")))
+	return span
+}
+
+// dbFor returns the database as seen from a request, so its DB spans nest
+// under the request's span
+func (h *APIHandler) dbFor(c *gin.Context) *DatabaseManager {
+	return h.db.WithContext(c.Request.Context())
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Achievements
+//
+// Users earn trophies for milestones, such as their first post or reaching
+// 100 karma. The achievements sink evaluates the achievements a domain event
+// could have earned for the users it concerns, and grants the trophies whose
+// condition now holds. Trophies are never taken away, and are listed on
+// GET /users/:username/trophies.
+
+// Trophy is an achievement a user can earn
+type Trophy struct {
+	Name        string `json:"name"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+}
+
+// What can earn an achievement: the user's posts, comments or karma
+// changing, or the user doing anything at all
+const (
+	achievedByPost     = "post"
+	achievedByComment  = "comment"
+	achievedByKarma    = "karma"
+	achievedByActivity = "activity"
+)
+
+// achievement is a trophy with the condition for earning it
+type achievement struct {
+	Trophy
+	trigger string // what can earn it
+	query   string // selects whether the user whose ID it's given has earned it
+}
+
+var achievements = []achievement{
+	{
+		Trophy:  Trophy{Name: "first_post", Title: "First Post", Description: "Made a first post"},
+		trigger: achievedByPost,
+		query:   `SELECT EXISTS (SELECT 1 FROM posts WHERE author_id = ? AND removed = 0 AND deleted_at IS NULL)`,
+	},
+	{
+		Trophy:  Trophy{Name: "first_comment", Title: "First Comment", Description: "Made a first comment"},
+		trigger: achievedByComment,
+		query:   `SELECT EXISTS (SELECT 1 FROM comments WHERE author_id = ? AND removed = 0 AND deleted_at IS NULL)`,
+	},
+	{
+		Trophy:  Trophy{Name: "karma_100", Title: "Rising Star", Description: "Reached 100 karma"},
+		trigger: achievedByKarma,
+		query:   `SELECT karma >= 100 FROM users WHERE id = ?`,
+	},
+	{
+		Trophy:  Trophy{Name: "karma_1000", Title: "Community Favorite", Description: "Reached 1,000 karma"},
+		trigger: achievedByKarma,
+		query:   `SELECT karma >= 1000 FROM users WHERE id = ?`,
+	},
+	{
+		Trophy:  Trophy{Name: "one_year_club", Title: "One-Year Club", Description: "Celebrated a first cake day"},
+		trigger: achievedByActivity,
+		query:   `SELECT created_at <= datetime('now', '-1 year') FROM users WHERE id = ?`,
+	},
+}
+
+// trophyByName looks up an achievement's trophy
+func trophyByName(name string) (Trophy, bool) {
+	for _, a := range achievements {
+		if a.Name == name {
+			return a.Trophy, true
+		}
+	}
+	return Trophy{}, false
+}
+
+// EvaluateAchievements grants a user the trophies they've earned among the
+// achievements with the given trigger, returning the names of those newly
+// granted
+func (dm *DatabaseManager) EvaluateAchievements(userID int, trigger string) ([]string, error) {
+	defer dm.span("EvaluateAchievements").End()
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	var granted []string
+	for _, a := range achievements {
+		if a.trigger != trigger {
+			continue
+		}
+
+		var has bool
+		err := dm.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM user_trophies WHERE user_id = ? AND trophy = ?)`, userID, a.Name).Scan(&has)
+		if err != nil {
+			return granted, fmt.Errorf("failed to check trophies: %v", err)
+		}
+		if has {
+			continue
+		}
+
+		var earned bool
+		if err := dm.db.QueryRow(a.query, userID).Scan(&earned); err != nil {
+			return granted, fmt.Errorf("failed to evaluate %s: %v", a.Name, err)
+		}
+		if !earned {
+			continue
+		}
+
+		if _, err := dm.db.Exec(`INSERT OR IGNORE INTO user_trophies (user_id, trophy) VALUES (?, ?)`, userID, a.Name); err != nil {
+			return granted, fmt.Errorf("failed to grant %s: %v", a.Name, err)
+		}
+		granted = append(granted, a.Name)
+	}
+	return granted, nil
+}
+
+// UserTrophy is a trophy a user has earned
+type UserTrophy struct {
+	Trophy
+	GrantedAt time.Time `json:"granted_at"`
+}
+
+// GetUserTrophies returns the trophies a user has earned, oldest first
+func (dm *DatabaseManager) GetUserTrophies(userID int) ([]UserTrophy, error) {
+	defer dm.span("GetUserTrophies").End()
+	dm.mu.RLock()
+	defer dm.mu.RUnlock()
+
+	rows, err := dm.db.Query(`SELECT trophy, granted_at FROM user_trophies WHERE user_id = ? ORDER BY granted_at, trophy`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get trophies: %v", err)
+	}
+	defer rows.Close()
+
+	trophies := []UserTrophy{}
+	for rows.Next() {
+		var name string
+		var t UserTrophy
+		if err := rows.Scan(&name, &t.GrantedAt); err != nil {
+			return nil, err
+		}
+		// Trophies no longer defined aren't shown
+		if trophy, ok := trophyByName(name); ok {
+			t.Trophy = trophy
+			trophies = append(trophies, t)
+		}
+	}
+	return trophies, rows.Err()
+}
+
+// achievementsSink evaluates the achievements that events could have earned
+type achievementsSink struct {
+	db *DatabaseManager
+}
+
+func (achievementsSink) Name() string { return "achievements" }
+
+func (s achievementsSink) Handle(event EventEnvelope) error {
+	type evaluation struct {
+		userID  int
+		trigger string
+	}
+	var evaluations []evaluation
+
+	switch e := event.Data.(type) {
+	case PostCreatedEvent:
+		evaluations = []evaluation{{e.AuthorID, achievedByPost}, {e.AuthorID, achievedByActivity}}
+	case CommentCreatedEvent:
+		evaluations = []evaluation{{e.AuthorID, achievedByComment}, {e.AuthorID, achievedByActivity}}
+	case VoteCastEvent:
+		evaluations = []evaluation{{e.UserID, achievedByActivity}}
+		if authorID, _, err := s.db.GetVoteScore(e.TargetID, e.TargetType); err == nil {
+			evaluations = append(evaluations, evaluation{authorID, achievedByKarma})
+		}
+	case UserSubscribedEvent:
+		evaluations = []evaluation{{e.UserID, achievedByActivity}}
+	case MessageSentEvent:
+		evaluations = []evaluation{{e.FromUserID, achievedByActivity}}
+	}
+
+	for _, ev := range evaluations {
+		granted, err := s.db.EvaluateAchievements(ev.userID, ev.trigger)
+		if err != nil {
+			return err
+		}
+		for _, name := range granted {
+			logAt(logInfo, "User %d earned the %s trophy", ev.userID, name)
+		}
+	}
+	return nil
+}
+
+// getUserTrophies lists the trophies a user has earned
+func (h *APIHandler) getUserTrophies(c *gin.Context) {
+	user, err := h.dbFor(c).GetUserByUsername(c.Param("username"))
+	if err != nil {
+		c.Error(newAPIError(http.StatusNotFound, "User not found"))
+		return
+	}
+	userID, _ := strconv.Atoi(user.ID)
+
+	trophies, err := h.dbFor(c).GetUserTrophies(userID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, trophies)
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/asynkron/protoactor-go/actor"
+)
+
+// Vote aggregation
+//
+// A vote storm on one post would otherwise be a write transaction per vote,
+// each waiting for the database lock. Once a post gets hotPostVotes votes
+// within hotPostWindow, the worker that owns its subreddit sends its votes to
+// a voteAggregator child actor instead. The aggregator checks each vote for
+// replays and duplicates, answers straight away, and writes the votes it has
+// collected every vote_flush_interval in one transaction, with one karma
+// update for the post's author. Votes are acknowledged before they're
+// written, so scores lag by up to an interval.
+
+const (
+	hotPostVotes          = 20
+	hotPostWindow         = 10 * time.Second
+	voteAggregatorTimeout = 5 * time.Second
+)
+
+// hotPosts is a worker's count of the votes on the posts it handles, and the
+// aggregators of the ones that are hot
+type hotPosts struct {
+	flushInterval time.Duration
+	windowStart   time.Time
+	counts        map[int]int // votes per post in the current window
+	aggregators   map[int]*postAggregator
+}
+
+type postAggregator struct {
+	pid      *actor.PID
+	lastVote time.Time
+}
+
+// newHotPosts returns the state for aggregating votes every flushInterval,
+// or nil if aggregation is off
+func newHotPosts(flushInterval time.Duration) *hotPosts {
+	if flushInterval <= 0 {
+		return nil
+	}
+	return &hotPosts{
+		flushInterval: flushInterval,
+		counts:        make(map[int]int),
+		aggregators:   make(map[int]*postAggregator),
+	}
+}
+
+// hotPostAggregator returns the aggregator for the post a vote is on,
+// spawning one if the post has just become hot, or nil if the vote should be
+// written directly. Aggregators that have been idle for a window are stopped
+// first, which writes their remaining votes.
+func (a *RequestProcessingActor) hotPostAggregator(ac actor.Context, cmd VoteCommand) *actor.PID {
+	h := a.hot
+	if h == nil || cmd.TargetType != "post" {
+		return nil
+	}
+
+	now := time.Now()
+	for postID, agg := range h.aggregators {
+		if now.Sub(agg.lastVote) > hotPostWindow {
+			ac.PoisonFuture(agg.pid).Wait()
+			delete(h.aggregators, postID)
+		}
+	}
+	if agg, ok := h.aggregators[cmd.TargetID]; ok {
+		agg.lastVote = now
+		return agg.pid
+	}
+
+	if now.Sub(h.windowStart) > hotPostWindow {
+		h.windowStart = now
+		h.counts = make(map[int]int)
+	}
+	h.counts[cmd.TargetID]++
+	if h.counts[cmd.TargetID] < hotPostVotes {
+		return nil
+	}
+
+	delete(h.counts, cmd.TargetID)
+	postID, db := cmd.TargetID, a.handler.db
+	pid := ac.Spawn(actor.PropsFromProducer(func() actor.Actor {
+		return &voteAggregator{db: db, postID: postID, flushInterval: h.flushInterval}
+	}))
+	h.aggregators[postID] = &postAggregator{pid: pid, lastVote: now}
+	a.handler.metrics.Inc("goreddit_vote_aggregators_started_total")
+	return pid
+}
+
+// aggregateVote hands a vote to a post's aggregator and waits for it to be
+// accepted
+func aggregateVote(ac actor.Context, aggregator *actor.PID, cmd VoteCommand) error {
+	reply, err := ac.RequestFuture(aggregator, &PendingVote{
+		UserID: cmd.UserID,
+		Value:  cmd.Value,
+		Nonce:  cmd.Nonce,
+	}, voteAggregatorTimeout).Result()
+	if err != nil {
+		return fmt.Errorf("failed to record vote: %v", err)
+	}
+	resp, ok := reply.(*Response)
+	if !ok {
+		return fmt.Errorf("unexpected reply %T from vote aggregator", reply)
+	}
+	return resp.Err
+}
+
+// PendingVote is a vote on a hot post that hasn't been written yet
+type PendingVote struct {
+	UserID int
+	Value  int
+	Nonce  string
+}
+
+// flushVotes tells a voteAggregator to write its pending votes
+type flushVotes struct{}
+
+// voteAggregator collects the votes on one hot post and writes them in
+// batches
+type voteAggregator struct {
+	db            *DatabaseManager
+	postID        int
+	flushInterval time.Duration
+
+	pending []PendingVote
+	seen    map[string]bool // nonces and votes in pending
+	timer   *time.Timer     // set while a flush is scheduled
+}
+
+func (v *voteAggregator) Receive(ctx actor.Context) {
+	switch msg := ctx.Message().(type) {
+	case *actor.Started:
+		v.seen = make(map[string]bool)
+	case *PendingVote:
+		err := v.accept(msg)
+		if err == nil && v.timer == nil {
+			self, root := ctx.Self(), ctx.ActorSystem().Root
+			v.timer = time.AfterFunc(v.flushInterval, func() { root.Send(self, &flushVotes{}) })
+		}
+		ctx.Respond(&Response{Err: err})
+	case *flushVotes:
+		v.timer = nil
+		v.flush()
+		if len(v.pending) > 0 {
+			// The write failed, so try again next interval
+			self, root := ctx.Self(), ctx.ActorSystem().Root
+			v.timer = time.AfterFunc(v.flushInterval, func() { root.Send(self, &flushVotes{}) })
+		}
+	case *actor.Stopping:
+		if v.timer != nil {
+			v.timer.Stop()
+		}
+		v.flush()
+	}
+}
+
+// accept checks a vote the way Vote would and adds it to the pending votes
+func (v *voteAggregator) accept(vote *PendingVote) error {
+	nonceKey := fmt.Sprintf("nonce:%d:%s", vote.UserID, vote.Nonce)
+	voteKey := fmt.Sprintf("vote:%d:%d", vote.UserID, vote.Value)
+	if v.seen[nonceKey] {
+		return ErrVoteReplay
+	}
+	if v.seen[voteKey] {
+		return errVoteExists
+	}
+	if err := v.db.CheckVote(vote.UserID, v.postID, "post", vote.Value, vote.Nonce); err != nil {
+		return err
+	}
+
+	v.seen[nonceKey] = true
+	v.seen[voteKey] = true
+	v.pending = append(v.pending, *vote)
+	return nil
+}
+
+// flush writes the pending votes, keeping them if the write fails
+func (v *voteAggregator) flush() {
+	if len(v.pending) == 0 {
+		return
+	}
+
+	recorded, err := v.db.RecordVoteBatch(v.postID, v.pending)
+	if err != nil {
+		log.Printf("Failed to write %d votes on post %d: %v", len(v.pending), v.postID, err)
+		return
+	}
+	if recorded < len(v.pending) {
+		logAt(logWarn, "Skipped %d of %d aggregated votes on post %d", len(v.pending)-recorded, len(v.pending), v.postID)
+	}
+	v.pending = nil
+	v.seen = make(map[string]bool)
+}
+
+// errVoteExists is returned for a vote the user has already cast
+var errVoteExists = errors.New("failed to record vote: vote already recorded")
+
+// CheckVote fails the way Vote would if the vote were cast now, with
+// ErrVoteReplay for a reused nonce or errVoteExists for a repeated vote,
+// without writing anything
+func (dm *DatabaseManager) CheckVote(userID, targetID int, targetType string, value int, nonce string) error {
+	defer dm.span("CheckVote").End()
+	dm.mu.RLock()
+	defer dm.mu.RUnlock()
+
+	var replayed, exists bool
+	err := dm.db.QueryRow(`
+		SELECT EXISTS(SELECT 1 FROM vote_nonces WHERE user_id = ? AND nonce = ?),
+			EXISTS(SELECT 1 FROM votes WHERE user_id = ? AND target_id = ? AND target_type = ? AND vote_value = ?)
+	`, userID, nonce, userID, targetID, targetType, value).Scan(&replayed, &exists)
+	if err != nil {
+		return fmt.Errorf("failed to check vote: %v", err)
+	}
+	if replayed {
+		return ErrVoteReplay
+	}
+	if exists {
+		return errVoteExists
+	}
+	return nil
+}
+
+// RecordVoteBatch writes the votes a vote aggregator collected on a post in
+// one transaction. A vote that fails, e.g. because its nonce was used on
+// another post in the meantime or the post has since been archived or
+// deleted, is skipped, and the author's karma is updated once with the net
+// value of the rest. It returns how many votes were recorded.
+func (dm *DatabaseManager) RecordVoteBatch(postID int, votes []PendingVote) (int, error) {
+	defer dm.span("RecordVoteBatch").End()
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	tx, err := dm.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+
+	recorded, karma := 0, 0
+	for _, v := range votes {
+		if _, err := tx.Exec(`SAVEPOINT aggregated_vote`); err != nil {
+			tx.Rollback()
+			return 0, fmt.Errorf("failed to start vote: %v", err)
+		}
+
+		// The same checks as applyVote, as the post may have changed since
+		// the vote was accepted
+		err := checkNotArchived(tx, postID, "post")
+		if err == nil {
+			_, err = tx.Exec(`INSERT INTO vote_nonces (user_id, nonce) VALUES (?, ?)`, v.UserID, v.Nonce)
+		}
+		if err == nil {
+			_, err = tx.Exec(`
+				INSERT INTO votes (user_id, target_id, target_type, vote_value)
+				VALUES (?, ?, 'post', ?)
+			`, v.UserID, postID, v.Value)
+		}
+		if err == nil {
+			err = dm.enqueueOutboxEvent(tx, VoteCastEvent{UserID: v.UserID, TargetID: postID, TargetType: "post", Value: v.Value})
+		}
+		if err != nil {
+			if _, err := tx.Exec(`ROLLBACK TO aggregated_vote`); err != nil {
+				tx.Rollback()
+				return 0, fmt.Errorf("failed to roll back vote: %v", err)
+			}
+		} else {
+			recorded++
+			karma += v.Value
+		}
+
+		if _, err := tx.Exec(`RELEASE aggregated_vote`); err != nil {
+			tx.Rollback()
+			return 0, fmt.Errorf("failed to release vote: %v", err)
+		}
+	}
+
+	if karma != 0 {
+		_, err := tx.Exec(`
+			UPDATE users
+			SET karma = karma + ?
+			WHERE id = (SELECT author_id FROM posts WHERE id = ?)
+		`, karma, postID)
+		if err != nil {
+			tx.Rollback()
+			return 0, fmt.Errorf("failed to update karma: %v", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit votes: %v", err)
+	}
+	return recorded, nil
+}
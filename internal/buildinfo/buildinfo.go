@@ -0,0 +1,18 @@
+// Package buildinfo describes the build of the server and client binaries.
+// Release builds set Version with
+//
+//	-ldflags "-X github.com/ArjunKaliyath/GoReddit/internal/buildinfo.Version=v1.2.3"
+package buildinfo
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// Version is the release the binary was built from, or "dev"
+var Version = "dev"
+
+// String describes the build, e.g. "goreddit-server v1.2.3 (go1.22.1 linux/arm64)"
+func String(binary string) string {
+	return fmt.Sprintf("%s %s (%s %s/%s)", binary, Version, runtime.Version(), runtime.GOOS, runtime.GOARCH)
+}
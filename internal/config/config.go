@@ -0,0 +1,561 @@
+// Package config loads the server's startup settings. Each setting has a
+// default, and can be set in a YAML file, by an environment variable and by a
+// command-line flag, each overriding the one before.
+//
+// Settings that can change while the server runs, such as rate limits and
+// feature flags, live in the runtime config file instead (RuntimeConfig).
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds the settings the server reads once at startup
+type Config struct {
+	Addr          string `yaml:"addr"`            // address the REST API listens on
+	DatabasePath  string `yaml:"database_path"`   // SQLite database file
+	ActorPoolSize int    `yaml:"actor_pool_size"` // initial size, until a runtime config sets it
+	GRPCAddr      string `yaml:"grpc_addr"`       // address of the gRPC API, off if empty
+	PublicURL     string `yaml:"public_url"`      // base URL used in links sent by email
+	AdminUserIDs  []int  `yaml:"admin_user_ids"`
+
+	// AllowedOrigins are the origins besides public_url's that browsers may
+	// open WebSockets from
+	AllowedOrigins []string `yaml:"allowed_origins"`
+
+	// ActorMailboxSize is how many requests each actor queues before the
+	// pool turns new ones away
+	ActorMailboxSize int `yaml:"actor_mailbox_size"`
+
+	// ActorRequestTimeout is how long a request waits for its actor to
+	// answer before failing with 504 Gateway Timeout
+	ActorRequestTimeout time.Duration `yaml:"actor_request_timeout"`
+
+	// VoteFlushInterval is how often votes on hot posts are written in a
+	// batch, and 0 writes every vote as it comes
+	VoteFlushInterval time.Duration `yaml:"vote_flush_interval"`
+
+	// RuntimeConfig is the JSON file of settings that are reloaded on SIGHUP
+	RuntimeConfig string `yaml:"runtime_config"`
+
+	Standby Standby `yaml:"standby"`
+	TLS     TLS     `yaml:"tls"`
+	Cluster Cluster `yaml:"cluster"`
+	Events  Events  `yaml:"events"`
+	Captcha Captcha `yaml:"captcha"`
+
+	// sources records where each setting that isn't a default came from,
+	// for error messages
+	sources map[string]string
+}
+
+// Standby configures shipping database snapshots to a standby directory
+type Standby struct {
+	Dir      string        `yaml:"dir"` // off if empty
+	Interval time.Duration `yaml:"interval"`
+	Retain   int           `yaml:"retain"`
+}
+
+// TLS configures serving the REST API over HTTPS, with a certificate from
+// files or obtained automatically from Let's Encrypt
+type TLS struct {
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+
+	AutocertDomains  []string `yaml:"autocert_domains"`   // domains to get certificates for
+	AutocertEmail    string   `yaml:"autocert_email"`     // contact for the ACME account, optional
+	AutocertCacheDir string   `yaml:"autocert_cache_dir"` // where certificates are kept between restarts
+
+	// RedirectAddr serves plain HTTP redirects to HTTPS, and ACME
+	// challenges when using autocert, off if empty
+	RedirectAddr string `yaml:"redirect_addr"`
+}
+
+// Enabled reports whether the API is served over HTTPS
+func (t TLS) Enabled() bool {
+	return t.CertFile != "" || t.KeyFile != "" || len(t.AutocertDomains) > 0
+}
+
+// Cluster roles
+const (
+	ClusterAPI    = "api"    // serves HTTP and sends writes to the workers
+	ClusterWorker = "worker" // processes writes for the API nodes
+)
+
+// Cluster configures running the request processing actors on worker nodes
+// behind one or more API nodes. Every node opens the same database.
+type Cluster struct {
+	Role           string   `yaml:"role"`            // ClusterAPI or ClusterWorker, off if empty
+	Name           string   `yaml:"name"`            // nodes only join a cluster of the same name
+	Addr           string   `yaml:"addr"`            // host:port other nodes reach this node's actors on
+	MembershipPort int      `yaml:"membership_port"` // port nodes check each other's health on
+	Seeds          []string `yaml:"seeds"`           // host:membership_port of every node
+}
+
+// Message brokers domain events can be published to
+const (
+	BrokerNATS  = "nats"
+	BrokerKafka = "kafka"
+)
+
+// Events configures the sinks domain events are delivered to, besides the
+// server's own real-time pushes
+type Events struct {
+	Log           bool   `yaml:"log"`            // write every event to the server log
+	WebhookURL    string `yaml:"webhook_url"`    // POST every event here, off if empty
+	WebhookSecret string `yaml:"webhook_secret"` // signs webhook deliveries
+
+	Broker      string   `yaml:"broker"`       // BrokerNATS or BrokerKafka, off if empty
+	BrokerURLs  []string `yaml:"broker_urls"`  // NATS server URLs or Kafka host:port addresses
+	TopicPrefix string   `yaml:"topic_prefix"` // prepended to the event type to name its topic
+}
+
+// CAPTCHA providers
+const (
+	CaptchaHCaptcha  = "hcaptcha"
+	CaptchaReCaptcha = "recaptcha"
+)
+
+// Captcha configures verifying a CAPTCHA solved by the client on registration
+type Captcha struct {
+	Provider string `yaml:"provider"` // CaptchaHCaptcha or CaptchaReCaptcha, off if empty
+	SiteKey  string `yaml:"site_key"` // given to clients to render the challenge with
+	Secret   string `yaml:"secret"`   // verifies responses with the provider
+}
+
+// Default returns the settings used when nothing else is configured
+func Default() Config {
+	return Config{
+		Addr:          ":8080",
+		DatabasePath:  "reddit_clone.db",
+		ActorPoolSize: 5,
+		PublicURL:     "http://localhost:8080",
+
+		ActorMailboxSize:    100,
+		ActorRequestTimeout: 10 * time.Second,
+		VoteFlushInterval:   250 * time.Millisecond,
+		Standby: Standby{
+			Interval: time.Minute,
+			Retain:   24,
+		},
+		TLS: TLS{
+			AutocertCacheDir: "autocert-cache",
+		},
+		Cluster: Cluster{
+			Name:           "goreddit",
+			Addr:           "127.0.0.1:6330",
+			MembershipPort: 6331,
+		},
+		Events: Events{
+			TopicPrefix: "goreddit.",
+		},
+	}
+}
+
+// setting describes how one setting is named in each source
+type setting struct {
+	key   string // in the YAML file
+	env   string
+	flag  string
+	usage string
+	set   func(c *Config, value string) error
+}
+
+var settings = []setting{
+	{"addr", "LISTEN_ADDR", "addr", "address to serve the REST API on", func(c *Config, v string) error {
+		c.Addr = v
+		return nil
+	}},
+	{"database_path", "DATABASE_PATH", "db", "SQLite database file", func(c *Config, v string) error {
+		c.DatabasePath = v
+		return nil
+	}},
+	{"actor_pool_size", "ACTOR_POOL_SIZE", "actor-pool-size", "number of request processing actors", func(c *Config, v string) error {
+		return parseInt(v, &c.ActorPoolSize)
+	}},
+	{"actor_mailbox_size", "ACTOR_MAILBOX_SIZE", "actor-mailbox-size", "requests each actor queues before new ones get a 503", func(c *Config, v string) error {
+		return parseInt(v, &c.ActorMailboxSize)
+	}},
+	{"actor_request_timeout", "ACTOR_REQUEST_TIMEOUT", "actor-request-timeout", "how long a request waits for its actor before a 504", func(c *Config, v string) error {
+		d, err := time.ParseDuration(v)
+		c.ActorRequestTimeout = d
+		return err
+	}},
+	{"vote_flush_interval", "VOTE_FLUSH_INTERVAL", "vote-flush-interval", "how often votes on hot posts are written in a batch, 0 to write each vote", func(c *Config, v string) error {
+		d, err := time.ParseDuration(v)
+		c.VoteFlushInterval = d
+		return err
+	}},
+	{"grpc_addr", "GRPC_ADDR", "grpc-addr", "address to serve the gRPC API on (requires the grpc build tag)", func(c *Config, v string) error {
+		c.GRPCAddr = v
+		return nil
+	}},
+	{"public_url", "PUBLIC_URL", "public-url", "base URL used in links sent by email", func(c *Config, v string) error {
+		c.PublicURL = v
+		return nil
+	}},
+	{"allowed_origins", "ALLOWED_ORIGINS", "allowed-origins", "comma separated origins besides public_url's that browsers may open WebSockets from", func(c *Config, v string) error {
+		c.AllowedOrigins = splitList(v)
+		return nil
+	}},
+	{"admin_user_ids", "ADMIN_USER_IDS", "admin-user-ids", "comma separated IDs of admin users", func(c *Config, v string) error {
+		ids, err := parseUserIDs(v)
+		c.AdminUserIDs = ids
+		return err
+	}},
+	{"runtime_config", "CONFIG_FILE", "runtime-config", "JSON file of settings reloaded on SIGHUP", func(c *Config, v string) error {
+		c.RuntimeConfig = v
+		return nil
+	}},
+	{"standby.dir", "STANDBY_DIR", "standby-dir", "directory to ship database snapshots to", func(c *Config, v string) error {
+		c.Standby.Dir = v
+		return nil
+	}},
+	{"standby.interval", "STANDBY_INTERVAL", "standby-interval", "time between standby snapshots", func(c *Config, v string) error {
+		d, err := time.ParseDuration(v)
+		c.Standby.Interval = d
+		return err
+	}},
+	{"standby.retain", "STANDBY_RETAIN", "standby-retain", "number of standby snapshots kept", func(c *Config, v string) error {
+		return parseInt(v, &c.Standby.Retain)
+	}},
+	{"tls.cert_file", "TLS_CERT_FILE", "tls-cert", "TLS certificate file (PEM)", func(c *Config, v string) error {
+		c.TLS.CertFile = v
+		return nil
+	}},
+	{"tls.key_file", "TLS_KEY_FILE", "tls-key", "TLS private key file (PEM)", func(c *Config, v string) error {
+		c.TLS.KeyFile = v
+		return nil
+	}},
+	{"tls.autocert_domains", "TLS_AUTOCERT_DOMAINS", "tls-autocert-domains", "comma separated domains to get Let's Encrypt certificates for", func(c *Config, v string) error {
+		c.TLS.AutocertDomains = splitList(v)
+		return nil
+	}},
+	{"tls.autocert_email", "TLS_AUTOCERT_EMAIL", "tls-autocert-email", "contact email for the Let's Encrypt account", func(c *Config, v string) error {
+		c.TLS.AutocertEmail = v
+		return nil
+	}},
+	{"tls.autocert_cache_dir", "TLS_AUTOCERT_CACHE_DIR", "tls-autocert-cache-dir", "directory Let's Encrypt certificates are kept in", func(c *Config, v string) error {
+		c.TLS.AutocertCacheDir = v
+		return nil
+	}},
+	{"tls.redirect_addr", "TLS_REDIRECT_ADDR", "tls-redirect-addr", "address to redirect plain HTTP to HTTPS from, such as :80", func(c *Config, v string) error {
+		c.TLS.RedirectAddr = v
+		return nil
+	}},
+	{"cluster.role", "CLUSTER_ROLE", "cluster-role", "cluster role of this node, api or worker (requires the cluster build tag)", func(c *Config, v string) error {
+		c.Cluster.Role = v
+		return nil
+	}},
+	{"cluster.name", "CLUSTER_NAME", "cluster-name", "name of the cluster to join", func(c *Config, v string) error {
+		c.Cluster.Name = v
+		return nil
+	}},
+	{"cluster.addr", "CLUSTER_ADDR", "cluster-addr", "host:port other nodes reach this node's actors on", func(c *Config, v string) error {
+		c.Cluster.Addr = v
+		return nil
+	}},
+	{"cluster.membership_port", "CLUSTER_MEMBERSHIP_PORT", "cluster-membership-port", "port cluster nodes check each other's health on", func(c *Config, v string) error {
+		return parseInt(v, &c.Cluster.MembershipPort)
+	}},
+	{"cluster.seeds", "CLUSTER_SEEDS", "cluster-seeds", "comma separated host:membership_port of every cluster node", func(c *Config, v string) error {
+		c.Cluster.Seeds = splitList(v)
+		return nil
+	}},
+	{"events.log", "EVENTS_LOG", "events-log", "write every domain event to the server log", func(c *Config, v string) error {
+		return parseBool(v, &c.Events.Log)
+	}},
+	{"events.webhook_url", "EVENTS_WEBHOOK_URL", "events-webhook-url", "URL every domain event is POSTed to", func(c *Config, v string) error {
+		c.Events.WebhookURL = v
+		return nil
+	}},
+	{"events.webhook_secret", "EVENTS_WEBHOOK_SECRET", "events-webhook-secret", "secret event webhook deliveries are signed with", func(c *Config, v string) error {
+		c.Events.WebhookSecret = v
+		return nil
+	}},
+	{"events.broker", "EVENTS_BROKER", "events-broker", "message broker to publish domain events to, nats or kafka (requires the broker build tag)", func(c *Config, v string) error {
+		c.Events.Broker = v
+		return nil
+	}},
+	{"events.broker_urls", "EVENTS_BROKER_URLS", "events-broker-urls", "comma separated NATS URLs or Kafka broker addresses", func(c *Config, v string) error {
+		c.Events.BrokerURLs = splitList(v)
+		return nil
+	}},
+	{"events.topic_prefix", "EVENTS_TOPIC_PREFIX", "events-topic-prefix", "prefix of the topic each event type is published to", func(c *Config, v string) error {
+		c.Events.TopicPrefix = v
+		return nil
+	}},
+	{"captcha.provider", "CAPTCHA_PROVIDER", "captcha-provider", "CAPTCHA to require on registration, hcaptcha or recaptcha", func(c *Config, v string) error {
+		c.Captcha.Provider = v
+		return nil
+	}},
+	{"captcha.site_key", "CAPTCHA_SITE_KEY", "captcha-site-key", "CAPTCHA site key clients render the challenge with", func(c *Config, v string) error {
+		c.Captcha.SiteKey = v
+		return nil
+	}},
+	{"captcha.secret", "CAPTCHA_SECRET", "captcha-secret", "CAPTCHA secret key responses are verified with", func(c *Config, v string) error {
+		c.Captcha.Secret = v
+		return nil
+	}},
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func parseInt(value string, out *int) error {
+	n, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil {
+		return fmt.Errorf("%q is not a number", value)
+	}
+	*out = n
+	return nil
+}
+
+func parseBool(value string, out *bool) error {
+	b, err := strconv.ParseBool(strings.TrimSpace(value))
+	if err != nil {
+		return fmt.Errorf("%q is not true or false", value)
+	}
+	*out = b
+	return nil
+}
+
+func parseUserIDs(value string) ([]int, error) {
+	var ids []int
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		id, err := strconv.Atoi(field)
+		if err != nil {
+			return nil, fmt.Errorf("invalid user ID %q", field)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// Load builds the config from the defaults, the YAML file named by -config
+// or SERVER_CONFIG, the environment and the command-line flags in args.
+// It returns flag.ErrHelp if args asked for usage, which has been printed.
+func Load(args []string, getenv func(string) string) (*Config, error) {
+	fs := flag.NewFlagSet("goreddit-server", flag.ContinueOnError)
+	path := fs.String("config", getenv("SERVER_CONFIG"), "YAML config file (env SERVER_CONFIG)")
+	flags := make(map[string]string)
+	for _, s := range settings {
+		s := s
+		fs.Func(s.flag, fmt.Sprintf("%s (env %s)", s.usage, s.env), func(value string) error {
+			flags[s.key] = value
+			return nil
+		})
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected argument %q", fs.Arg(0))
+	}
+
+	config := Default()
+	config.sources = make(map[string]string)
+
+	if *path != "" {
+		if err := config.loadFile(*path); err != nil {
+			return nil, err
+		}
+	}
+
+	var problems []string
+	for _, s := range settings {
+		if value := getenv(s.env); value != "" {
+			if err := s.set(&config, value); err != nil {
+				problems = append(problems, fmt.Sprintf("%s (from env %s): %v", s.key, s.env, err))
+			}
+			config.sources[s.key] = "env " + s.env
+		}
+	}
+	for _, s := range settings {
+		if value, ok := flags[s.key]; ok {
+			if err := s.set(&config, value); err != nil {
+				problems = append(problems, fmt.Sprintf("%s (from flag -%s): %v", s.key, s.flag, err))
+			}
+			config.sources[s.key] = "flag -" + s.flag
+		}
+	}
+	if len(problems) > 0 {
+		return nil, &Error{Problems: problems}
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+// loadFile reads settings from a YAML file. Unknown keys are rejected, so
+// typos don't go unnoticed.
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %v", err)
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("failed to parse config file %s: %v", path, err)
+	}
+
+	var keys map[string]interface{}
+	if err := yaml.Unmarshal(data, &keys); err == nil {
+		for _, s := range settings {
+			if fileHasKey(keys, s.key) {
+				c.sources[s.key] = path
+			}
+		}
+	}
+	return nil
+}
+
+// fileHasKey reports whether a decoded YAML document sets a dotted key
+func fileHasKey(doc map[string]interface{}, key string) bool {
+	name, rest, nested := strings.Cut(key, ".")
+	value, ok := doc[name]
+	if !ok || !nested {
+		return ok
+	}
+	section, ok := value.(map[string]interface{})
+	return ok && fileHasKey(section, rest)
+}
+
+// Error lists every problem found with a config
+type Error struct {
+	Problems []string
+}
+
+func (e *Error) Error() string {
+	return "invalid config:\n  - " + strings.Join(e.Problems, "\n  - ")
+}
+
+// Validate checks every setting and reports all problems at once, naming
+// where each bad value came from
+func (c *Config) Validate() error {
+	var problems []string
+	check := func(key string, ok bool, format string, args ...interface{}) {
+		if ok {
+			return
+		}
+		source := c.sources[key]
+		if source == "" {
+			source = "default"
+		}
+		problems = append(problems, fmt.Sprintf("%s (from %s): %s", key, source, fmt.Sprintf(format, args...)))
+	}
+
+	check("addr", validAddr(c.Addr), "%q must be host:port or :port", c.Addr)
+	check("database_path", c.DatabasePath != "", "must not be empty")
+	check("actor_pool_size", c.ActorPoolSize >= 1 && c.ActorPoolSize <= 1000, "must be between 1 and 1000, got %d", c.ActorPoolSize)
+	check("actor_mailbox_size", c.ActorMailboxSize >= 1, "must be at least 1, got %d", c.ActorMailboxSize)
+	check("actor_request_timeout", c.ActorRequestTimeout > 0, "must be positive, got %s", c.ActorRequestTimeout)
+	check("vote_flush_interval", c.VoteFlushInterval >= 0, "must not be negative, got %s", c.VoteFlushInterval)
+	if c.GRPCAddr != "" {
+		check("grpc_addr", validAddr(c.GRPCAddr), "%q must be host:port or :port", c.GRPCAddr)
+		check("grpc_addr", c.GRPCAddr != c.Addr, "must differ from addr")
+	}
+
+	u, err := url.Parse(c.PublicURL)
+	check("public_url", err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "",
+		"%q must be an http or https URL", c.PublicURL)
+
+	for _, origin := range c.AllowedOrigins {
+		u, err := url.Parse(origin)
+		check("allowed_origins", err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" && strings.Trim(u.Path, "/") == "",
+			"%q must be an http or https origin, such as https://example.com", origin)
+	}
+
+	for _, id := range c.AdminUserIDs {
+		check("admin_user_ids", id > 0, "user IDs must be positive, got %d", id)
+	}
+
+	if c.Standby.Dir != "" {
+		check("standby.interval", c.Standby.Interval > 0, "must be positive, got %s", c.Standby.Interval)
+		check("standby.retain", c.Standby.Retain > 0, "must be positive, got %d", c.Standby.Retain)
+	}
+
+	if t := c.TLS; t.Enabled() {
+		check("tls.cert_file", (t.CertFile == "") == (t.KeyFile == ""), "cert_file and key_file must be set together")
+		check("tls.autocert_domains", t.CertFile == "" || len(t.AutocertDomains) == 0, "can't be used with cert_file and key_file")
+		if len(t.AutocertDomains) > 0 {
+			check("tls.autocert_cache_dir", t.AutocertCacheDir != "", "must not be empty")
+		}
+	}
+	if c.TLS.RedirectAddr != "" {
+		check("tls.redirect_addr", c.TLS.Enabled(), "requires TLS to be configured")
+		check("tls.redirect_addr", validAddr(c.TLS.RedirectAddr), "%q must be host:port or :port", c.TLS.RedirectAddr)
+		check("tls.redirect_addr", c.TLS.RedirectAddr != c.Addr, "must differ from addr")
+	}
+
+	if cl := c.Cluster; cl.Role != "" {
+		check("cluster.role", cl.Role == ClusterAPI || cl.Role == ClusterWorker, "%q must be %s or %s", cl.Role, ClusterAPI, ClusterWorker)
+		check("cluster.name", cl.Name != "", "must not be empty")
+		host, _, _ := net.SplitHostPort(cl.Addr)
+		check("cluster.addr", validAddr(cl.Addr) && host != "", "%q must be host:port", cl.Addr)
+		check("cluster.membership_port", cl.MembershipPort > 0 && cl.MembershipPort <= 65535, "must be a port number, got %d", cl.MembershipPort)
+		check("cluster.seeds", len(cl.Seeds) > 0, "must list the cluster's nodes")
+		for _, seed := range cl.Seeds {
+			check("cluster.seeds", validAddr(seed), "%q must be host:port", seed)
+		}
+	}
+
+	if e := c.Events; e.WebhookURL != "" {
+		u, err := url.Parse(e.WebhookURL)
+		check("events.webhook_url", err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "",
+			"%q must be an http or https URL", e.WebhookURL)
+		check("events.webhook_secret", e.WebhookSecret != "", "must be set with webhook_url")
+	}
+	if e := c.Events; e.Broker != "" {
+		check("events.broker", e.Broker == BrokerNATS || e.Broker == BrokerKafka, "%q must be %s or %s", e.Broker, BrokerNATS, BrokerKafka)
+		check("events.broker_urls", len(e.BrokerURLs) > 0, "must list the broker's servers")
+		check("events.topic_prefix", !strings.ContainsAny(e.TopicPrefix, " *>"), "%q can't contain spaces, * or >", e.TopicPrefix)
+	}
+	if cp := c.Captcha; cp.Provider != "" {
+		check("captcha.provider", cp.Provider == CaptchaHCaptcha || cp.Provider == CaptchaReCaptcha,
+			"%q must be %s or %s", cp.Provider, CaptchaHCaptcha, CaptchaReCaptcha)
+		check("captcha.site_key", cp.SiteKey != "", "must be set with provider")
+		check("captcha.secret", cp.Secret != "", "must be set with provider")
+	}
+
+	if len(problems) > 0 {
+		return &Error{Problems: problems}
+	}
+	return nil
+}
+
+func validAddr(addr string) bool {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	n, err := strconv.Atoi(port)
+	return err == nil && n >= 0 && n <= 65535
+}
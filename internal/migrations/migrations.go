@@ -0,0 +1,11 @@
+// Package migrations holds the database schema, embedded into the server
+// binary so it can set up a fresh database without any files alongside it.
+package migrations
+
+import _ "embed"
+
+// Schema creates every table that doesn't exist yet. Columns added to
+// existing tables later are migrated by the server on startup.
+//
+//go:embed schema.sql
+var Schema string
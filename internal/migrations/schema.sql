@@ -0,0 +1,540 @@
+-- Users table
+CREATE TABLE IF NOT EXISTS users (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	username TEXT UNIQUE NOT NULL,
+	password TEXT NOT NULL,
+	karma INTEGER DEFAULT 0,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Subreddits table
+CREATE TABLE IF NOT EXISTS subreddits (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT UNIQUE NOT NULL,
+	description TEXT,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Subreddit Members table
+CREATE TABLE IF NOT EXISTS subreddit_members (
+	subreddit_id INTEGER,
+	user_id INTEGER,
+	joined_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (subreddit_id, user_id),
+	FOREIGN KEY (subreddit_id) REFERENCES subreddits(id),
+	FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+-- The subreddits a user has joined, for feeds
+CREATE INDEX IF NOT EXISTS idx_subreddit_members_user ON subreddit_members(user_id, subreddit_id);
+
+-- Posts table
+CREATE TABLE IF NOT EXISTS posts (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	title TEXT NOT NULL,
+	content TEXT NOT NULL,
+	author_id INTEGER NOT NULL,
+	subreddit_id INTEGER NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (author_id) REFERENCES users(id),
+	FOREIGN KEY (subreddit_id) REFERENCES subreddits(id)
+);
+
+-- A subreddit's posts, newest first
+CREATE INDEX IF NOT EXISTS idx_posts_subreddit_created ON posts(subreddit_id, created_at);
+
+-- Comments table (supports hierarchical comments)
+CREATE TABLE IF NOT EXISTS comments (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	content TEXT NOT NULL,
+	author_id INTEGER NOT NULL,
+	post_id INTEGER,
+	parent_comment_id INTEGER,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (author_id) REFERENCES users(id),
+	FOREIGN KEY (post_id) REFERENCES posts(id),
+	FOREIGN KEY (parent_comment_id) REFERENCES comments(id)
+);
+
+-- A post's comments, oldest first
+CREATE INDEX IF NOT EXISTS idx_comments_post ON comments(post_id, created_at);
+
+-- Votes table (for posts and comments)
+CREATE TABLE IF NOT EXISTS votes (
+	user_id INTEGER NOT NULL,
+	target_id INTEGER NOT NULL,
+	target_type TEXT CHECK(target_type IN ('post', 'comment')) NOT NULL,
+	vote_value INTEGER CHECK(vote_value IN (-1, 1)) NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (user_id, target_id, target_type, vote_value),
+	FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+-- The votes on a post or comment. It covers the up and downvote counts
+-- selected with every post, so they're answered from the index alone.
+CREATE INDEX IF NOT EXISTS idx_votes_target ON votes(target_type, target_id, vote_value);
+
+-- Direct Messages table
+CREATE TABLE IF NOT EXISTS direct_messages (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	from_user_id INTEGER NOT NULL,
+	to_user_id INTEGER NOT NULL,
+	content TEXT NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (from_user_id) REFERENCES users(id),
+	FOREIGN KEY (to_user_id) REFERENCES users(id)
+);
+
+-- User Subscriptions table
+    	CREATE TABLE IF NOT EXISTS user_subscriptions (
+        	subscriber_id INTEGER NOT NULL,
+        	subscribed_user_id INTEGER NOT NULL,
+        	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+        	PRIMARY KEY (subscriber_id, subscribed_user_id),
+        	FOREIGN KEY (subscriber_id) REFERENCES users(id),
+        	FOREIGN KEY (subscribed_user_id) REFERENCES users(id)
+    	);
+
+-- Trending topics table (rebuilt by the trending job)
+CREATE TABLE IF NOT EXISTS trending_topics (
+	term TEXT PRIMARY KEY,
+	score REAL NOT NULL,
+	post_count INTEGER NOT NULL,
+	computed_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Representative posts for each trending topic
+CREATE TABLE IF NOT EXISTS trending_topic_posts (
+	term TEXT NOT NULL,
+	post_id INTEGER NOT NULL,
+	PRIMARY KEY (term, post_id),
+	FOREIGN KEY (term) REFERENCES trending_topics(term),
+	FOREIGN KEY (post_id) REFERENCES posts(id)
+);
+
+-- Subreddit Moderators table
+CREATE TABLE IF NOT EXISTS subreddit_moderators (
+	subreddit_id INTEGER NOT NULL,
+	user_id INTEGER NOT NULL,
+	added_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (subreddit_id, user_id),
+	FOREIGN KEY (subreddit_id) REFERENCES subreddits(id),
+	FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+-- AutoModerator rules table (evaluated on post and comment creation)
+CREATE TABLE IF NOT EXISTS automod_rules (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	subreddit_id INTEGER NOT NULL,
+	name TEXT NOT NULL,
+	match_type TEXT CHECK(match_type IN ('keyword', 'regex')),
+	pattern TEXT,
+	applies_to TEXT CHECK(applies_to IN ('post', 'comment', 'both')) NOT NULL,
+	min_account_age_days INTEGER DEFAULT 0,
+	min_karma INTEGER,
+	action TEXT CHECK(action IN ('remove', 'flag', 'flair')) NOT NULL,
+	flair_text TEXT,
+	created_by INTEGER NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (subreddit_id) REFERENCES subreddits(id),
+	FOREIGN KEY (created_by) REFERENCES users(id)
+);
+
+-- Moderation queue table (content flagged for review)
+CREATE TABLE IF NOT EXISTS mod_queue (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	subreddit_id INTEGER NOT NULL,
+	target_type TEXT CHECK(target_type IN ('post', 'comment')) NOT NULL,
+	target_id INTEGER NOT NULL,
+	reason TEXT NOT NULL,
+	source TEXT NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	resolved_at DATETIME,
+	FOREIGN KEY (subreddit_id) REFERENCES subreddits(id)
+);
+
+-- Subreddit Settings table (one row per customized subreddit)
+CREATE TABLE IF NOT EXISTS subreddit_settings (
+	subreddit_id INTEGER PRIMARY KEY,
+	default_sort TEXT NOT NULL DEFAULT 'hot',
+	half_life_hours REAL NOT NULL DEFAULT 12,
+	updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (subreddit_id) REFERENCES subreddits(id)
+);
+
+-- Subreddit rules, shown in order on the community header
+CREATE TABLE IF NOT EXISTS subreddit_rules (
+	subreddit_id INTEGER NOT NULL,
+	position INTEGER NOT NULL,
+	title TEXT NOT NULL,
+	description TEXT,
+	PRIMARY KEY (subreddit_id, position),
+	FOREIGN KEY (subreddit_id) REFERENCES subreddits(id)
+);
+
+-- Subreddit Bans table
+CREATE TABLE IF NOT EXISTS subreddit_bans (
+	subreddit_id INTEGER NOT NULL,
+	user_id INTEGER NOT NULL,
+	reason TEXT,
+	banned_by INTEGER,
+	expires_at DATETIME,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (subreddit_id, user_id),
+	FOREIGN KEY (subreddit_id) REFERENCES subreddits(id),
+	FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+-- Moderation Log table (moderator_id is NULL for automod actions)
+CREATE TABLE IF NOT EXISTS mod_log (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	subreddit_id INTEGER NOT NULL,
+	moderator_id INTEGER,
+	action TEXT NOT NULL,
+	target_type TEXT NOT NULL,
+	target_id INTEGER NOT NULL,
+	details TEXT,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (subreddit_id) REFERENCES subreddits(id),
+	FOREIGN KEY (moderator_id) REFERENCES users(id)
+);
+
+-- Moderation webhooks and their queued deliveries
+CREATE TABLE IF NOT EXISTS mod_webhooks (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	subreddit_id INTEGER NOT NULL,
+	url TEXT NOT NULL,
+	secret TEXT NOT NULL,
+	events TEXT NOT NULL,
+	created_by INTEGER NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (subreddit_id) REFERENCES subreddits(id),
+	FOREIGN KEY (created_by) REFERENCES users(id)
+);
+
+CREATE TABLE IF NOT EXISTS mod_webhook_deliveries (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	webhook_id INTEGER NOT NULL,
+	event TEXT NOT NULL,
+	payload TEXT NOT NULL,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	next_attempt_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	delivered_at DATETIME,
+	failed_at DATETIME,
+	last_error TEXT,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (webhook_id) REFERENCES mod_webhooks(id)
+);
+
+-- Sessions table (one row per login, tokens are stored hashed)
+CREATE TABLE IF NOT EXISTS sessions (
+	id TEXT PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	token_hash TEXT UNIQUE NOT NULL,
+	ip TEXT,
+	user_agent TEXT,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	last_seen_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	revoked_at DATETIME,
+	FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+-- Admin audit log (append-only record of privileged actions)
+CREATE TABLE IF NOT EXISTS admin_audit_log (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	admin_id INTEGER NOT NULL,
+	action TEXT NOT NULL,
+	target_type TEXT NOT NULL,
+	target_id INTEGER,
+	reason TEXT,
+	details TEXT,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (admin_id) REFERENCES users(id)
+);
+
+-- Beta feature opt-ins
+CREATE TABLE IF NOT EXISTS user_beta_optins (
+	user_id INTEGER NOT NULL,
+	feature TEXT NOT NULL,
+	opted_in_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (user_id, feature),
+	FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+-- User Profiles table (one row per customized user)
+CREATE TABLE IF NOT EXISTS user_profiles (
+	user_id INTEGER PRIMARY KEY,
+	bio TEXT NOT NULL DEFAULT '',
+	avatar_url TEXT NOT NULL DEFAULT '',
+	show_nsfw BOOLEAN NOT NULL DEFAULT 0,
+	default_feed_sort TEXT NOT NULL DEFAULT '',
+	updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+-- Mentions table (u/username references in posts and comments)
+CREATE TABLE IF NOT EXISTS mentions (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	mentioned_user_id INTEGER NOT NULL,
+	author_id INTEGER NOT NULL,
+	post_id INTEGER NOT NULL,
+	comment_id INTEGER,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (mentioned_user_id) REFERENCES users(id),
+	FOREIGN KEY (author_id) REFERENCES users(id),
+	FOREIGN KEY (post_id) REFERENCES posts(id),
+	FOREIGN KEY (comment_id) REFERENCES comments(id)
+);
+
+-- Per-type notification delivery preferences (defaults apply without a row)
+CREATE TABLE IF NOT EXISTS notification_preferences (
+	user_id INTEGER NOT NULL,
+	type TEXT NOT NULL,
+	in_app INTEGER NOT NULL,
+	push INTEGER NOT NULL,
+	email INTEGER NOT NULL,
+	PRIMARY KEY (user_id, type),
+	FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+-- Email addresses and email settings (digest is off, daily or weekly)
+CREATE TABLE IF NOT EXISTS user_emails (
+	user_id INTEGER PRIMARY KEY,
+	email TEXT NOT NULL,
+	verified_at DATETIME,
+	verify_token_hash TEXT,
+	verify_expires_at DATETIME,
+	digest TEXT NOT NULL DEFAULT 'off' CHECK (digest IN ('off', 'daily', 'weekly')),
+	last_digest_at DATETIME,
+	FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+-- Outgoing emails, sent and retried by the email job
+CREATE TABLE IF NOT EXISTS email_queue (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL,
+	to_address TEXT NOT NULL,
+	kind TEXT NOT NULL CHECK (kind IN ('verification', 'notification', 'digest')),
+	subject TEXT NOT NULL,
+	body TEXT NOT NULL,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	last_error TEXT,
+	next_attempt_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	sent_at DATETIME,
+	failed_at DATETIME,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+-- Group chat rooms, their members and messages
+CREATE TABLE IF NOT EXISTS chat_rooms (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT NOT NULL,
+	owner_id INTEGER NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (owner_id) REFERENCES users(id)
+);
+
+CREATE TABLE IF NOT EXISTS chat_room_members (
+	room_id INTEGER NOT NULL,
+	user_id INTEGER NOT NULL,
+	invited_by INTEGER,
+	joined_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (room_id, user_id),
+	FOREIGN KEY (room_id) REFERENCES chat_rooms(id),
+	FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+CREATE TABLE IF NOT EXISTS chat_messages (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	room_id INTEGER NOT NULL,
+	sender_id INTEGER NOT NULL,
+	content TEXT NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (room_id) REFERENCES chat_rooms(id),
+	FOREIGN KEY (sender_id) REFERENCES users(id)
+);
+
+-- Nonces of recently accepted votes, used to reject replayed requests
+CREATE TABLE IF NOT EXISTS vote_nonces (
+	user_id INTEGER NOT NULL,
+	nonce TEXT NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (user_id, nonce),
+	FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+-- Subreddits mirrored to other GoReddit instances, and what they have synced
+CREATE TABLE IF NOT EXISTS subreddit_mirrors (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	subreddit_id INTEGER NOT NULL,
+	remote_url TEXT NOT NULL,
+	remote_token TEXT NOT NULL,
+	remote_subreddit_id INTEGER NOT NULL,
+	pull_comments BOOLEAN NOT NULL DEFAULT 0,
+	last_post_id INTEGER NOT NULL DEFAULT 0,
+	last_synced_at DATETIME,
+	last_error TEXT,
+	created_by INTEGER NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (subreddit_id) REFERENCES subreddits(id),
+	FOREIGN KEY (created_by) REFERENCES users(id)
+);
+
+CREATE TABLE IF NOT EXISTS mirrored_posts (
+	mirror_id INTEGER NOT NULL,
+	local_post_id INTEGER NOT NULL,
+	remote_post_id INTEGER NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (mirror_id, local_post_id),
+	FOREIGN KEY (mirror_id) REFERENCES subreddit_mirrors(id),
+	FOREIGN KEY (local_post_id) REFERENCES posts(id)
+);
+
+CREATE TABLE IF NOT EXISTS mirrored_comments (
+	mirror_id INTEGER NOT NULL,
+	remote_comment_id INTEGER NOT NULL,
+	local_comment_id INTEGER NOT NULL,
+	PRIMARY KEY (mirror_id, remote_comment_id),
+	FOREIGN KEY (mirror_id) REFERENCES subreddit_mirrors(id),
+	FOREIGN KEY (local_comment_id) REFERENCES comments(id)
+);
+
+-- Days on which each user made an authenticated request, for daily active users
+CREATE TABLE IF NOT EXISTS user_activity (
+	user_id INTEGER NOT NULL,
+	day TEXT NOT NULL,
+	PRIMARY KEY (user_id, day),
+	FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+-- Site-wide statistics (rebuilt by the stats job), bucketed by day or hour
+CREATE TABLE IF NOT EXISTS site_stats (
+	metric TEXT NOT NULL,
+	bucket TEXT NOT NULL,
+	count INTEGER NOT NULL,
+	computed_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (metric, bucket)
+);
+
+-- Domain events waiting to be published to the message broker, oldest first
+CREATE TABLE IF NOT EXISTS event_outbox (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	event_id TEXT UNIQUE NOT NULL,
+	type TEXT NOT NULL,
+	payload TEXT NOT NULL,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	last_error TEXT,
+	published_at DATETIME,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_event_outbox_pending ON event_outbox(published_at, id);
+
+-- Runs of the background jobs (see the scheduler), newest last
+CREATE TABLE IF NOT EXISTS job_runs (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	job TEXT NOT NULL,
+	triggered_by TEXT NOT NULL,
+	status TEXT NOT NULL DEFAULT 'running',
+	summary TEXT,
+	error TEXT,
+	started_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	finished_at DATETIME
+);
+
+CREATE INDEX IF NOT EXISTS idx_job_runs_job ON job_runs(job, id);
+
+-- Awards given to posts and comments. A user gives a piece of content each
+-- type of award at most once.
+CREATE TABLE IF NOT EXISTS awards (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	award TEXT NOT NULL,
+	giver_id INTEGER NOT NULL,
+	recipient_id INTEGER NOT NULL,
+	target_type TEXT CHECK(target_type IN ('post', 'comment')) NOT NULL,
+	target_id INTEGER NOT NULL,
+	message TEXT NOT NULL DEFAULT '',
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	UNIQUE (giver_id, target_type, target_id, award),
+	FOREIGN KEY (giver_id) REFERENCES users(id),
+	FOREIGN KEY (recipient_id) REFERENCES users(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_awards_target ON awards(target_type, target_id);
+CREATE INDEX IF NOT EXISTS idx_awards_recipient ON awards(recipient_id, id);
+
+-- Trophies users have earned for achievements
+CREATE TABLE IF NOT EXISTS user_trophies (
+	user_id INTEGER NOT NULL,
+	trophy TEXT NOT NULL,
+	granted_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (user_id, trophy),
+	FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+-- Hourly counts of post views and shares, for post insights
+CREATE TABLE IF NOT EXISTS post_insights (
+	post_id INTEGER NOT NULL,
+	metric TEXT NOT NULL,
+	hour TEXT NOT NULL,
+	count INTEGER NOT NULL DEFAULT 0,
+	PRIMARY KEY (post_id, metric, hour),
+	FOREIGN KEY (post_id) REFERENCES posts(id)
+);
+
+-- Who shared each post, so a share is counted once per user
+CREATE TABLE IF NOT EXISTS post_shares (
+	post_id INTEGER NOT NULL,
+	user_id INTEGER NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (post_id, user_id),
+	FOREIGN KEY (post_id) REFERENCES posts(id),
+	FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+-- Keywords and domains users have muted, stored lowercased
+CREATE TABLE IF NOT EXISTS muted_words (
+	user_id INTEGER NOT NULL,
+	kind TEXT CHECK(kind IN ('keyword', 'domain')) NOT NULL,
+	word TEXT NOT NULL,
+	PRIMARY KEY (user_id, kind, word),
+	FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+-- What posts and comments said before each of their edits
+CREATE TABLE IF NOT EXISTS revisions (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	target_type TEXT CHECK(target_type IN ('post', 'comment')) NOT NULL,
+	target_id INTEGER NOT NULL,
+	content TEXT NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_revisions_target ON revisions(target_type, target_id, id);
+
+-- Subreddits recommended to each user from the memberships of similar users
+-- (rebuilt by the subreddit_recommendations job)
+CREATE TABLE IF NOT EXISTS subreddit_recommendations (
+	user_id INTEGER NOT NULL,
+	subreddit_id INTEGER NOT NULL,
+	score REAL NOT NULL,
+	PRIMARY KEY (user_id, subreddit_id),
+	FOREIGN KEY (user_id) REFERENCES users(id),
+	FOREIGN KEY (subreddit_id) REFERENCES subreddits(id)
+);
+
+-- Full-text index of post titles and content, keyed by post ID and kept in
+-- step with posts by the triggers below. It finds related posts.
+CREATE VIRTUAL TABLE IF NOT EXISTS posts_fts USING fts5(title, content);
+
+CREATE TRIGGER IF NOT EXISTS posts_fts_insert AFTER INSERT ON posts BEGIN
+	INSERT INTO posts_fts (rowid, title, content) VALUES (new.id, new.title, new.content);
+END;
+
+CREATE TRIGGER IF NOT EXISTS posts_fts_update AFTER UPDATE OF title, content ON posts BEGIN
+	UPDATE posts_fts SET title = new.title, content = new.content WHERE rowid = new.id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS posts_fts_delete AFTER DELETE ON posts BEGIN
+	DELETE FROM posts_fts WHERE rowid = old.id;
+END;
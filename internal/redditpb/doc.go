@@ -0,0 +1,6 @@
+// Package redditpb holds the Go code generated from the proto files in
+// proto/goreddit/v1. Run `make proto` after changing them; the server only
+// uses this package when built with the grpc or cluster tag.
+package redditpb
+
+//go:generate protoc -I ../../proto --go_out=../.. --go_opt=module=github.com/ArjunKaliyath/GoReddit --go-grpc_out=../.. --go-grpc_opt=module=github.com/ArjunKaliyath/GoReddit goreddit/v1/reddit.proto goreddit/v1/cluster.proto
@@ -0,0 +1,537 @@
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Post is a post as feeds list it
+type Post struct {
+	ID            int
+	Title         string
+	Content       string
+	AuthorID      int    `json:"author_id"`
+	AuthorName    string `json:"author_name"`
+	SubredditID   int    `json:"subreddit_id"`
+	SubredditName string `json:"subreddit_name"`
+	Flair         string `json:"flair,omitempty"`
+	Pinned        bool   `json:"pinned"`
+	Kind          string `json:"kind"`         // text, link, image or poll
+	CrosspostOf   *int   `json:"crosspost_of"` // nil unless a crosspost
+	NSFW          bool   `json:"nsfw"`         // posted in an NSFW subreddit
+	Slug          string `json:"slug"`
+	Permalink     string `json:"permalink"` // e.g. /r/golang/comments/42/hello_world
+	CreatedAt     time.Time
+	EditedAt      *time.Time `json:"edited_at"`
+	CommentCount  int        `json:"comment_count"`
+	VoteCount     VoteCount  `json:"vote_count"`
+}
+
+// VoteCount is the votes on a post
+type VoteCount struct {
+	Upvotes   int `json:"upvotes"`
+	Downvotes int `json:"downvotes"`
+}
+
+// Score is the post's upvotes less its downvotes
+func (p Post) Score() int {
+	return p.VoteCount.Upvotes - p.VoteCount.Downvotes
+}
+
+// PostPage is one page of a paginated post listing
+type PostPage struct {
+	Posts      []Post `json:"posts"`
+	Limit      int    `json:"limit"`
+	Offset     int    `json:"offset"`
+	NextOffset *int   `json:"next_offset"` // nil on the last page
+}
+
+type Subreddit struct {
+	ID          int       `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// SubredditListing is a subreddit with how busy it is
+type SubredditListing struct {
+	Subreddit
+	MemberCount    int `json:"member_count"`
+	RecentActivity int `json:"recent_activity"`
+}
+
+// SubredditRecommendation is a subreddit suggested to the user, scored by
+// how much similar users have joined it
+type SubredditRecommendation struct {
+	SubredditListing
+	Score float64 `json:"score"`
+}
+
+// User is a user someone subscribes to
+type User struct {
+	ID       string
+	Username string
+	Karma    int
+}
+
+// TopUser is a user ranked by karma
+type TopUser struct {
+	ID           int    `json:"id"`
+	Username     string `json:"username"`
+	Karma        int    `json:"karma"`
+	PostCount    int    `json:"post_count"`
+	CommentCount int    `json:"comment_count"`
+}
+
+// TopSubscribedUser is a user ranked by subscribers
+type TopSubscribedUser struct {
+	ID              int    `json:"id"`
+	Username        string `json:"username"`
+	Karma           int    `json:"karma"`
+	SubscriberCount int    `json:"subscriber_count"`
+}
+
+// ChatRoom is a group conversation
+type ChatRoom struct {
+	ID            int        `json:"id"`
+	Name          string     `json:"name"`
+	OwnerID       int        `json:"owner_id"`
+	MemberCount   int        `json:"member_count"`
+	CreatedAt     time.Time  `json:"created_at"`
+	LastMessageAt *time.Time `json:"last_message_at"`
+}
+
+type DirectMessage struct {
+	ID           int
+	FromUserID   int `json:"from_user_id"`
+	FromUsername string
+	ToUserID     int `json:"to_user_id"`
+	Content      string
+	CreatedAt    time.Time
+	ReadAt       *time.Time `json:"read_at"`
+}
+
+// Conversation is the direct messages exchanged with one other user
+type Conversation struct {
+	UserID        int           `json:"user_id"`
+	Username      string        `json:"username"`
+	LatestMessage DirectMessage `json:"latest_message"`
+	UnreadCount   int           `json:"unread_count"`
+}
+
+type RegisterRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Email    string `json:"email,omitempty"` // optional, verified by email
+
+	// CaptchaToken is the response of the CAPTCHA in GET /captcha, required
+	// when the server has one configured
+	CaptchaToken string `json:"captcha_token,omitempty"`
+}
+
+type RegisterResult struct {
+	UserID              int                `json:"user_id"`
+	Username            string             `json:"username"`
+	SuggestedSubreddits []SubredditListing `json:"suggested_subreddits"`
+}
+
+type LoginRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+type LoginResult struct {
+	UserID    int    `json:"user_id"`
+	Username  string `json:"username"`
+	SessionID string `json:"session_id"`
+	Token     string `json:"token"`
+}
+
+type CreateSubredditRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+type SubredditCreated struct {
+	SubredditID int    `json:"subreddit_id"`
+	Name        string `json:"name"`
+}
+
+type JoinSubredditsRequest struct {
+	SubredditIDs []int `json:"subreddit_ids"`
+}
+
+type CreatePostRequest struct {
+	Title       string `json:"title"`
+	Content     string `json:"content"`
+	SubredditID int    `json:"subreddit_id"`
+	Kind        string `json:"kind,omitempty"`         // text when empty
+	CrosspostOf *int   `json:"crosspost_of,omitempty"` // the ID of the post this crossposts
+}
+
+// AutomodOutcome is what the subreddit's automod rules did to new content
+type AutomodOutcome struct {
+	Removed bool   `json:"removed"`
+	Flagged bool   `json:"flagged"`
+	Flair   string `json:"flair,omitempty"`
+}
+
+type PostCreated struct {
+	PostID  int            `json:"post_id"`
+	Title   string         `json:"title"`
+	Automod AutomodOutcome `json:"automod"`
+}
+
+type CreateCommentRequest struct {
+	Content         string `json:"content"`
+	PostID          int    `json:"post_id"`
+	ParentCommentID *int   `json:"parent_comment_id"` // nil for a top-level comment
+}
+
+type CommentCreated struct {
+	CommentID int            `json:"comment_id"`
+	Content   string         `json:"content"`
+	Automod   AutomodOutcome `json:"automod"`
+}
+
+// VoteRequest is an upvote (Value 1) or downvote (-1) on a post or comment.
+// The nonce and timestamp let the server reject replayed votes; Vote fills
+// them in when they're empty.
+type VoteRequest struct {
+	TargetID   int    `json:"target_id"`
+	TargetType string `json:"target_type"` // post or comment
+	Value      int    `json:"value"`
+	Nonce      string `json:"nonce"`
+	Timestamp  int64  `json:"timestamp"`
+}
+
+// NewVoteRequest returns a vote with a fresh nonce and the current time
+func NewVoteRequest(targetType string, targetID, value int) VoteRequest {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return VoteRequest{
+		TargetID:   targetID,
+		TargetType: targetType,
+		Value:      value,
+		Nonce:      hex.EncodeToString(b),
+		Timestamp:  time.Now().Unix(),
+	}
+}
+
+type SendMessageRequest struct {
+	ToUserID int    `json:"to_user_id"`
+	Content  string `json:"content"`
+}
+
+type MessageSent struct {
+	MessageID int    `json:"message_id"`
+	Content   string `json:"content"`
+}
+
+// FeedOptions pick the ranking and page of a feed. An empty Sort is the
+// user's default ranking, and a zero Limit the whole feed.
+type FeedOptions struct {
+	Sort   string // latest, hot, rising or half_life
+	Limit  int
+	Offset int
+}
+
+// Register creates an account and signs the client in as it, without a
+// session
+func (c *Client) Register(ctx context.Context, req RegisterRequest) (*RegisterResult, error) {
+	var result RegisterResult
+	if err := c.Do(ctx, "POST", "/register", req, &result); err != nil {
+		return nil, err
+	}
+	c.SetCredentials(strconv.Itoa(result.UserID), "")
+	return &result, nil
+}
+
+// Login starts a session and signs the client in with it
+func (c *Client) Login(ctx context.Context, req LoginRequest) (*LoginResult, error) {
+	var result LoginResult
+	if err := c.Do(ctx, "POST", "/login", req, &result); err != nil {
+		return nil, err
+	}
+	c.SetCredentials(strconv.Itoa(result.UserID), result.Token)
+	return &result, nil
+}
+
+// Logout ends the client's session, if it has one, and signs it out. A
+// session that had already expired or been revoked isn't an error.
+func (c *Client) Logout(ctx context.Context) error {
+	if c.token != "" {
+		if err := c.Do(ctx, "POST", "/logout", nil, nil); err != nil && !errors.Is(err, ErrUnauthorized) {
+			return err
+		}
+	}
+	c.SetCredentials("", "")
+	return nil
+}
+
+// Subreddits lists every subreddit
+func (c *Client) Subreddits(ctx context.Context) ([]Subreddit, error) {
+	var subreddits []Subreddit
+	if err := c.Do(ctx, "GET", "/subreddits/all", nil, &subreddits); err != nil {
+		return nil, err
+	}
+	return subreddits, nil
+}
+
+// RecommendedSubreddits suggests up to limit subreddits to join, best first.
+// A limit of 0 is the server's default.
+func (c *Client) RecommendedSubreddits(ctx context.Context, limit int) ([]SubredditRecommendation, error) {
+	path := "/recommendations/subreddits"
+	if limit > 0 {
+		path += "?limit=" + strconv.Itoa(limit)
+	}
+
+	var recommendations []SubredditRecommendation
+	if err := c.Do(ctx, "GET", path, nil, &recommendations); err != nil {
+		return nil, err
+	}
+	return recommendations, nil
+}
+
+// JoinedSubreddits lists the subreddits the user has joined
+func (c *Client) JoinedSubreddits(ctx context.Context) ([]Subreddit, error) {
+	var subreddits []Subreddit
+	if err := c.Do(ctx, "GET", "/subreddits/joined", nil, &subreddits); err != nil {
+		return nil, err
+	}
+	return subreddits, nil
+}
+
+func (c *Client) CreateSubreddit(ctx context.Context, req CreateSubredditRequest) (*SubredditCreated, error) {
+	var result SubredditCreated
+	if err := c.Do(ctx, "POST", "/subreddits", req, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (c *Client) JoinSubreddit(ctx context.Context, subredditID int) error {
+	return c.Do(ctx, "POST", fmt.Sprintf("/subreddits/%d/join", subredditID), nil, nil)
+}
+
+// JoinSubreddits joins up to 25 subreddits at once
+func (c *Client) JoinSubreddits(ctx context.Context, subredditIDs []int) error {
+	return c.Do(ctx, "POST", "/subreddits/join", JoinSubredditsRequest{SubredditIDs: subredditIDs}, nil)
+}
+
+func (c *Client) LeaveSubreddit(ctx context.Context, subredditID int) error {
+	return c.Do(ctx, "POST", fmt.Sprintf("/subreddits/%d/leave", subredditID), nil, nil)
+}
+
+func (c *Client) CreatePost(ctx context.Context, req CreatePostRequest) (*PostCreated, error) {
+	var result PostCreated
+	if err := c.Do(ctx, "POST", "/posts", req, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Feed lists the posts of the subreddits the user has joined
+func (c *Client) Feed(ctx context.Context, opts FeedOptions) ([]Post, error) {
+	var posts []Post
+	if err := c.Do(ctx, "GET", opts.path("/feed"), nil, &posts); err != nil {
+		return nil, err
+	}
+	return posts, nil
+}
+
+// SubredditByName looks up a subreddit by its name
+func (c *Client) SubredditByName(ctx context.Context, name string) (*Subreddit, error) {
+	var subreddit Subreddit
+	if err := c.Do(ctx, "GET", "/r/"+url.PathEscape(name), nil, &subreddit); err != nil {
+		return nil, err
+	}
+	return &subreddit, nil
+}
+
+// SubredditPosts gets a page of a subreddit's posts, whether or not the user
+// has joined it. An empty Sort is the subreddit's default ranking.
+func (c *Client) SubredditPosts(ctx context.Context, subredditID int, opts FeedOptions) (*PostPage, error) {
+	var page PostPage
+	if err := c.Do(ctx, "GET", opts.path(fmt.Sprintf("/subreddits/%d/posts", subredditID)), nil, &page); err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+// path adds the options to a listing's path as query parameters
+func (opts FeedOptions) path(path string) string {
+	query := url.Values{}
+	if opts.Sort != "" {
+		query.Set("sort", opts.Sort)
+	}
+	if opts.Limit > 0 {
+		query.Set("limit", strconv.Itoa(opts.Limit))
+	}
+	if opts.Offset > 0 {
+		query.Set("offset", strconv.Itoa(opts.Offset))
+	}
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+	return path
+}
+
+// Posts gets up to 100 posts by ID in one request, in the order given.
+// Posts that don't exist or were removed are left out.
+func (c *Client) Posts(ctx context.Context, ids []int) ([]Post, error) {
+	fields := make([]string, len(ids))
+	for i, id := range ids {
+		fields[i] = strconv.Itoa(id)
+	}
+
+	var posts []Post
+	if err := c.Do(ctx, "GET", "/posts?ids="+strings.Join(fields, ","), nil, &posts); err != nil {
+		return nil, err
+	}
+	return posts, nil
+}
+
+// RelatedPosts gets up to limit posts like a post, most alike first. A limit
+// of 0 is the server's default.
+func (c *Client) RelatedPosts(ctx context.Context, postID, limit int) ([]Post, error) {
+	path := fmt.Sprintf("/posts/%d/related", postID)
+	if limit > 0 {
+		path += "?limit=" + strconv.Itoa(limit)
+	}
+
+	var posts []Post
+	if err := c.Do(ctx, "GET", path, nil, &posts); err != nil {
+		return nil, err
+	}
+	return posts, nil
+}
+
+// CreateComment comments on a post, or replies to a comment
+func (c *Client) CreateComment(ctx context.Context, req CreateCommentRequest) (*CommentCreated, error) {
+	var result CommentCreated
+	if err := c.Do(ctx, "POST", "/comments", req, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (c *Client) Vote(ctx context.Context, req VoteRequest) error {
+	return c.Do(ctx, "POST", "/vote", withNonce(req), nil)
+}
+
+// VoteBatch casts up to 50 votes in one request. The server records all of
+// them or, if any is rejected, none.
+func (c *Client) VoteBatch(ctx context.Context, votes []VoteRequest) error {
+	req := struct {
+		Votes []VoteRequest `json:"votes"`
+	}{make([]VoteRequest, len(votes))}
+	for i, vote := range votes {
+		req.Votes[i] = withNonce(vote)
+	}
+	return c.Do(ctx, "POST", "/votes/batch", req, nil)
+}
+
+// withNonce fills in a vote's nonce and timestamp if they're missing
+func withNonce(req VoteRequest) VoteRequest {
+	if req.Nonce == "" || req.Timestamp == 0 {
+		fresh := NewVoteRequest(req.TargetType, req.TargetID, req.Value)
+		if req.Nonce == "" {
+			req.Nonce = fresh.Nonce
+		}
+		if req.Timestamp == 0 {
+			req.Timestamp = fresh.Timestamp
+		}
+	}
+	return req
+}
+
+func (c *Client) SendMessage(ctx context.Context, req SendMessageRequest) (*MessageSent, error) {
+	var result MessageSent
+	if err := c.Do(ctx, "POST", "/messages", req, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Conversations lists the user's conversations, most recent first
+func (c *Client) Conversations(ctx context.Context) ([]Conversation, error) {
+	var conversations []Conversation
+	if err := c.Do(ctx, "GET", "/messages", nil, &conversations); err != nil {
+		return nil, err
+	}
+	return conversations, nil
+}
+
+// Messages lists the messages exchanged with a user, oldest first
+func (c *Client) Messages(ctx context.Context, userID int) ([]DirectMessage, error) {
+	var messages []DirectMessage
+	if err := c.Do(ctx, "GET", fmt.Sprintf("/messages/with/%d", userID), nil, &messages); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// MarkConversationRead marks the messages from a user read
+func (c *Client) MarkConversationRead(ctx context.Context, userID int) error {
+	return c.Do(ctx, "POST", fmt.Sprintf("/messages/with/%d/read", userID), nil, nil)
+}
+
+// Subscriptions lists the users the user subscribes to
+func (c *Client) Subscriptions(ctx context.Context) ([]User, error) {
+	var users []User
+	if err := c.Do(ctx, "GET", "/subscriptions", nil, &users); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+func (c *Client) Subscribe(ctx context.Context, userID int) error {
+	return c.Do(ctx, "POST", fmt.Sprintf("/users/%d/subscribe", userID), nil, nil)
+}
+
+func (c *Client) Unsubscribe(ctx context.Context, userID int) error {
+	return c.Do(ctx, "POST", fmt.Sprintf("/users/%d/unsubscribe", userID), nil, nil)
+}
+
+// TopUsers lists the users with the most karma
+func (c *Client) TopUsers(ctx context.Context) ([]TopUser, error) {
+	var users []TopUser
+	if err := c.Do(ctx, "GET", "/users/top", nil, &users); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// TopSubscribedUsers lists the users with the most subscribers
+func (c *Client) TopSubscribedUsers(ctx context.Context) ([]TopSubscribedUser, error) {
+	var users []TopSubscribedUser
+	if err := c.Do(ctx, "GET", "/users/top-subscribed", nil, &users); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// ChatRooms lists the chat rooms the user is in
+func (c *Client) ChatRooms(ctx context.Context) ([]ChatRoom, error) {
+	var rooms []ChatRoom
+	if err := c.Do(ctx, "GET", "/chats", nil, &rooms); err != nil {
+		return nil, err
+	}
+	return rooms, nil
+}
+
+// LeaveChatRoom takes the user out of a chat room
+func (c *Client) LeaveChatRoom(ctx context.Context, roomID int) error {
+	if c.userID == "" {
+		return ErrUnauthorized
+	}
+	return c.Do(ctx, "DELETE", fmt.Sprintf("/chats/%d/members/%s", roomID, c.userID), nil, nil)
+}
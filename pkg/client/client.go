@@ -0,0 +1,191 @@
+// Package client is a Go SDK for the GoReddit API. It signs requests in as a
+// user, retries requests the server turned away, and turns error responses
+// into *APIError values:
+//
+//	c := client.New("http://localhost:8080")
+//	if _, err := c.Login(ctx, client.LoginRequest{Username: "alice", Password: "secret"}); err != nil {
+//		return err
+//	}
+//	post, err := c.CreatePost(ctx, client.CreatePostRequest{Title: "Hello", Content: "First post", SubredditID: 1})
+//
+// A Client is signed in as one user at a time, and isn't safe for signing in
+// from several goroutines at once.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultRetries = 2
+	defaultBackoff = 200 * time.Millisecond
+	maxBackoff     = 10 * time.Second
+)
+
+// Client sends requests to a GoReddit server
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	retries    int
+	backoff    time.Duration
+
+	userID string
+	token  string // session token, sent instead of the user ID
+}
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithHTTPClient sends requests with hc instead of a default http.Client
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) { c.httpClient = hc }
+}
+
+// WithRetries sets how many times a request the server turned away is
+// retried, 2 by default. 0 turns retries off.
+func WithRetries(n int) Option {
+	return func(c *Client) { c.retries = n }
+}
+
+// WithBackoff sets the wait before the first retry, which doubles for each
+// one after it, 200ms by default. A Retry-After header overrides it.
+func WithBackoff(d time.Duration) Option {
+	return func(c *Client) { c.backoff = d }
+}
+
+// New returns a Client for the server at baseURL, e.g.
+// "http://localhost:8080", that isn't signed in
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		httpClient: &http.Client{},
+		retries:    defaultRetries,
+		backoff:    defaultBackoff,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// SetCredentials signs the client in as a user, with a session token from
+// Login or, without one, just the user's ID. Empty values sign it out.
+func (c *Client) SetCredentials(userID, token string) {
+	c.userID, c.token = userID, token
+}
+
+// UserID is the ID of the user the client is signed in as, or ""
+func (c *Client) UserID() string {
+	return c.userID
+}
+
+// Token is the client's session token, or "" without a session
+func (c *Client) Token() string {
+	return c.token
+}
+
+// Send sends a request with body encoded as JSON, signed in as the client's
+// user, and returns the response whatever its status. Requests the server
+// turned away with 429 or 503, and failed GET, PUT and DELETE requests, are
+// retried; other requests may have been carried out and aren't.
+func (c *Client) Send(ctx context.Context, method, path string, body interface{}) (*http.Response, error) {
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			return nil, err
+		}
+	}
+
+	for attempt := 0; ; attempt++ {
+		resp, err := c.send(ctx, method, path, data)
+		if attempt >= c.retries || !retryable(method, resp, err) {
+			return resp, err
+		}
+
+		wait := c.backoff << attempt
+		if resp != nil {
+			if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+				wait = time.Duration(seconds) * time.Second
+			}
+			resp.Body.Close()
+		}
+		if wait > maxBackoff {
+			wait = maxBackoff
+		}
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (c *Client) send(ctx context.Context, method, path string, data []byte) (*http.Response, error) {
+	var body io.Reader
+	if data != nil {
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if data != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	} else if c.userID != "" {
+		req.Header.Set("X-User-ID", c.userID)
+	}
+	return c.httpClient.Do(req)
+}
+
+// retryable reports whether a request is worth sending again: the server
+// didn't carry it out, or doing it twice is harmless
+func retryable(method string, resp *http.Response, err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	idempotent := method == http.MethodGet || method == http.MethodHead || method == http.MethodPut || method == http.MethodDelete
+	if err != nil {
+		return idempotent
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		return true
+	case http.StatusBadGateway, http.StatusGatewayTimeout:
+		return idempotent
+	}
+	return false
+}
+
+// Do sends a request and decodes a successful response into out, unless
+// it's nil. An error response is returned as an *APIError.
+func (c *Client) Do(ctx context.Context, method, path string, body, out interface{}) error {
+	resp, err := c.Send(ctx, method, path, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return newAPIError(resp)
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode the response to %s %s: %v", method, path, err)
+	}
+	return nil
+}